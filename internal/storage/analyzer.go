@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"strings"
+	"unicode"
+)
+
+/*
+ * An Analyzer turns text into the terms stored in and looked up from a full-text index. The text
+ * is first split into words: maximal runs of letters, digits and combining marks, where an
+ * apostrophe between two letters, as in "don't", is part of the word. Han, Hiragana and Katakana
+ * characters are not separated by spaces, so each of them is a word on its own. Each word is then
+ * passed through the filters of the analyzer in order, which can change it or drop it.
+ *
+ * Words are numbered from 0 in the order they appear in the text, and a term keeps the position
+ * of its word. Dropped words still take a position, so that a phrase query with a stop word in
+ * the middle only matches text with a word between the two around it.
+ */
+
+// MaxTermLength is the maximum length in bytes of a term. Longer terms are dropped by analyzers.
+const MaxTermLength = 255
+
+// Token is a term produced by an Analyzer, along with the position of the word it comes from.
+type Token struct {
+	Term     string
+	Position uint32
+}
+
+// TokenFilter is a step of an Analyzer, which returns the term for the given word or term, or an
+// empty string to drop it.
+type TokenFilter func(term string) string
+
+// Analyzer splits text into words and passes each through a pipeline of filters to produce the
+// terms of a full-text index.
+type Analyzer struct {
+	Filters []TokenFilter
+}
+
+// EnglishStopWords are common English words that are dropped by StandardAnalyzer.
+var EnglishStopWords = []string{
+	"a", "an", "and", "are", "as", "at", "be", "but", "by", "for", "if", "in", "into", "is", "it",
+	"no", "not", "of", "on", "or", "such", "that", "the", "their", "then", "there", "these",
+	"they", "this", "to", "was", "will", "with",
+}
+
+// NewAnalyzer returns an analyzer that passes words through the given filters in order.
+func NewAnalyzer(filters ...TokenFilter) *Analyzer {
+	return &Analyzer{filters}
+}
+
+// StandardAnalyzer returns an analyzer that lowercases words and drops English stop words.
+func StandardAnalyzer() *Analyzer {
+	return NewAnalyzer(LowercaseFilter, StopWordFilter(EnglishStopWords...))
+}
+
+// EnglishAnalyzer returns an analyzer that lowercases words, drops English stop words and stems
+// the remaining words with StemFilter.
+func EnglishAnalyzer() *Analyzer {
+	return NewAnalyzer(LowercaseFilter, StopWordFilter(EnglishStopWords...), StemFilter)
+}
+
+// Analyze returns the terms of the given text, in the order of their positions.
+func (a *Analyzer) Analyze(text string) []Token {
+	var tokens []Token
+	position := uint32(0)
+	splitWords(
+		text, func(word string) {
+			term := word
+			for _, filter := range a.Filters {
+				if term = filter(term); term == "" {
+					break
+				}
+			}
+			if term != "" && len(term) <= MaxTermLength {
+				tokens = append(tokens, Token{term, position})
+			}
+			position++
+		},
+	)
+	return tokens
+}
+
+// isWordRune returns true if the given character is part of words.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+// isIdeograph returns true if the given character is a word on its own.
+func isIdeograph(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana)
+}
+
+// isApostrophe returns true if the given character is an apostrophe.
+func isApostrophe(r rune) bool {
+	return r == '\'' || r == '’'
+}
+
+// splitWords calls fn with each word of the given text, in order.
+func splitWords(text string, fn func(word string)) {
+	start := -1
+	var prev rune
+	runes := []rune(text)
+	offsets := make([]int, 0, len(runes)+1)
+	for offset := range text {
+		offsets = append(offsets, offset)
+	}
+	offsets = append(offsets, len(text))
+
+	for i, r := range runes {
+		switch {
+		case isIdeograph(r):
+			if start >= 0 {
+				fn(text[offsets[start]:offsets[i]])
+				start = -1
+			}
+			fn(text[offsets[i]:offsets[i+1]])
+		case isWordRune(r):
+			if start < 0 {
+				start = i
+			}
+		case isApostrophe(r) && start >= 0 && unicode.IsLetter(prev) &&
+			i+1 < len(runes) && unicode.IsLetter(runes[i+1]) && !isIdeograph(runes[i+1]):
+			// The apostrophe joins the letters around it.
+		default:
+			if start >= 0 {
+				fn(text[offsets[start]:offsets[i]])
+				start = -1
+			}
+		}
+		prev = r
+	}
+	if start >= 0 {
+		fn(text[offsets[start]:])
+	}
+}
+
+// LowercaseFilter is a TokenFilter that maps every letter of a word to lower case.
+func LowercaseFilter(term string) string {
+	return strings.ToLower(term)
+}
+
+// StopWordFilter returns a TokenFilter that drops the given words. As words are compared as they
+// are, the filter usually comes after LowercaseFilter.
+func StopWordFilter(words ...string) TokenFilter {
+	stopWords := make(map[string]bool, len(words))
+	for _, word := range words {
+		stopWords[word] = true
+	}
+	return func(term string) string {
+		if stopWords[term] {
+			return ""
+		}
+		return term
+	}
+}
+
+// StemFilter is a TokenFilter that reduces lowercase English words to their stem with steps 1a to
+// 1c of the Porter stemming algorithm, which remove plurals and -ed and -ing suffixes, so that
+// "jumps", "jumped" and "jumping" all become "jump". Terms that are not made of lowercase ASCII
+// letters are not changed.
+func StemFilter(term string) string {
+	if len(term) <= 2 {
+		return term
+	}
+	for i := 0; i < len(term); i++ {
+		if term[i] < 'a' || term[i] > 'z' {
+			return term
+		}
+	}
+
+	// Step 1a: plurals.
+	switch {
+	case strings.HasSuffix(term, "sses"), strings.HasSuffix(term, "ies"):
+		term = term[:len(term)-2]
+	case strings.HasSuffix(term, "ss"):
+	case strings.HasSuffix(term, "s"):
+		term = term[:len(term)-1]
+	}
+
+	// Step 1b: -eed, -ed and -ing.
+	switch {
+	case strings.HasSuffix(term, "eed"):
+		if porterMeasure(term[:len(term)-3]) > 0 {
+			term = term[:len(term)-1]
+		}
+	case strings.HasSuffix(term, "ed") && porterHasVowel(term[:len(term)-2]):
+		term = restoreStem(term[:len(term)-2])
+	case strings.HasSuffix(term, "ing") && porterHasVowel(term[:len(term)-3]):
+		term = restoreStem(term[:len(term)-3])
+	}
+
+	// Step 1c: a final y after a vowel becomes i, so that "party" matches "parties".
+	if strings.HasSuffix(term, "y") && porterHasVowel(term[:len(term)-1]) {
+		term = term[:len(term)-1] + "i"
+	}
+	return term
+}
+
+// restoreStem completes a stem whose -ed or -ing suffix was removed, as in step 1b of the Porter
+// algorithm: "hopp" becomes "hop" and "hop" becomes "hope".
+func restoreStem(stem string) string {
+	n := len(stem)
+	switch {
+	case strings.HasSuffix(stem, "at"), strings.HasSuffix(stem, "bl"),
+		strings.HasSuffix(stem, "iz"):
+		return stem + "e"
+	case n >= 2 && stem[n-1] == stem[n-2] && porterConsonant(stem, n-1) &&
+		!strings.ContainsRune("lsz", rune(stem[n-1])):
+		return stem[:n-1]
+	case porterMeasure(stem) == 1 && porterCVC(stem):
+		return stem + "e"
+	}
+	return stem
+}
+
+// porterConsonant returns true if the letter of the given word at the given index is a consonant:
+// a letter other than a, e, i, o and u, and other than a y after a consonant.
+func porterConsonant(word string, i int) bool {
+	switch word[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !porterConsonant(word, i-1)
+	}
+	return true
+}
+
+// porterMeasure returns the number of sequences of vowels followed by consonants in the given word.
+func porterMeasure(word string) int {
+	m := 0
+	for i := 1; i < len(word); i++ {
+		if porterConsonant(word, i) && !porterConsonant(word, i-1) {
+			m++
+		}
+	}
+	return m
+}
+
+// porterHasVowel returns true if the given word has a vowel.
+func porterHasVowel(word string) bool {
+	for i := range word {
+		if !porterConsonant(word, i) {
+			return true
+		}
+	}
+	return false
+}
+
+// porterCVC returns true if the given word ends with a consonant, a vowel and a consonant other
+// than w, x or y.
+func porterCVC(word string) bool {
+	n := len(word)
+	return n >= 3 && porterConsonant(word, n-3) && !porterConsonant(word, n-2) &&
+		porterConsonant(word, n-1) && !strings.ContainsRune("wxy", rune(word[n-1]))
+}
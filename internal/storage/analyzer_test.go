@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzer_Analyze(t *testing.T) {
+	t.Run(
+		"check word splitting", func(t *testing.T) {
+			tests := []struct {
+				text     string
+				expected []string
+			}{
+				{"Hello, World!", []string{"Hello", "World"}},
+				{"don't stop", []string{"don't", "stop"}},
+				{"rock 'n' roll", []string{"rock", "n", "roll"}},
+				{"e-mail 2024/10", []string{"e", "mail", "2024", "10"}},
+				{"Ünïcode naïve café", []string{"Ünïcode", "naïve", "café"}},
+				{"東京タワー in Tokyo", []string{"東", "京", "タ", "ワ", "ー", "in", "Tokyo"}},
+				{"  \t\n", nil},
+			}
+			for _, test := range tests {
+				var words []string
+				for _, token := range NewAnalyzer().Analyze(test.text) {
+					words = append(words, token.Term)
+				}
+				if !reflect.DeepEqual(words, test.expected) {
+					t.Errorf("%q: expected %q, got %q", test.text, test.expected, words)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check filters and positions", func(t *testing.T) {
+			tokens := StandardAnalyzer().Analyze("The Quick fox and THE dog")
+			expected := []Token{{"quick", 1}, {"fox", 2}, {"dog", 5}}
+			if !reflect.DeepEqual(tokens, expected) {
+				t.Errorf("expected %v, got %v", expected, tokens)
+			}
+			tokens = EnglishAnalyzer().Analyze("Jumping dogs jumped")
+			expected = []Token{{"jump", 0}, {"dog", 1}, {"jump", 2}}
+			if !reflect.DeepEqual(tokens, expected) {
+				t.Errorf("expected %v, got %v", expected, tokens)
+			}
+		},
+	)
+
+	t.Run(
+		"check long terms are dropped", func(t *testing.T) {
+			tokens := NewAnalyzer().Analyze(strings.Repeat("x", MaxTermLength+1) + " y")
+			if !reflect.DeepEqual(tokens, []Token{{"y", 1}}) {
+				t.Errorf("expected only y, got %v", tokens)
+			}
+		},
+	)
+}
+
+func TestStemFilter(t *testing.T) {
+	t.Run(
+		"check stems", func(t *testing.T) {
+			tests := map[string]string{
+				"caresses": "caress", "ponies": "poni", "cats": "cat", "caress": "caress",
+				"feed": "feed", "agreed": "agree", "plastered": "plaster", "bled": "bled",
+				"motoring": "motor", "sing": "sing", "conflated": "conflate",
+				"troubled": "trouble", "sized": "size", "hopping": "hop", "falling": "fall",
+				"hissing": "hiss", "filing": "file", "failing": "fail", "happy": "happi",
+				"sky": "sky", "is": "is", "Running": "Running", "naïve": "naïve",
+			}
+			for word, expected := range tests {
+				if stem := StemFilter(word); stem != expected {
+					t.Errorf("%s: expected %s, got %s", word, expected, stem)
+				}
+			}
+		},
+	)
+}
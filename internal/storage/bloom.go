@@ -0,0 +1,909 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+
+	"kyadb/internal/structs/element"
+)
+
+/*
+ * A BloomFilter is a set of keys that can be asked whether it may contain a key: it answers no
+ * for every key that was never added, and yes for a key that was added or, with a small false
+ * positive rate, for a key that was not. A key sets numHashes bits of the filter, chosen by
+ * double hashing: bit i is h1 + i*h2 modulo the number of bits, where h1 is the 64-bit FNV-1a
+ * hash of the key and h2 is h1 mixed by the splitmix64 finalizer. Keys cannot be removed.
+ *
+ * A PageFilter keeps a BloomFilter for each group of consecutive pages of a table, so that a scan
+ * for a key can skip the groups whose filter rules the key out. Removing a key from a group only
+ * counts it as removed; once a group holds more keys than it was sized for, or many removed keys,
+ * it is stale and its filter should be rebuilt from the records of its pages.
+ *
+ * The meta page of a PageFilter starts with a byte storing filterMetaPage, followed by a byte
+ * storing the version of the format, 2 bytes storing the number of pages per group, 8 bytes
+ * storing the false positive rate as float64 bits, and 4 bytes each storing the page number of
+ * the first page of the first group, the number of groups and the page number of the first page
+ * of the free list.
+ *
+ * The bits of the filter of a group are stored on a chain of pages. The first page of a group
+ * starts with a byte storing filterGroupPage, followed by 4 bytes storing the page number of the
+ * next page of the chain, 4 bytes storing the page number of the first page of the next group, 4
+ * bytes each storing the capacity, the number of added keys and the number of removed keys of the
+ * group, a byte storing the number of hashes and 4 bytes storing the number of bytes of bits. The
+ * other pages of the chain start with a byte storing filterBitsPage and 4 bytes storing the page
+ * number of the next page. The bits fill the rest of the pages. The first page of a group never
+ * changes, so that the groups stay linked when a filter is rebuilt.
+ *
+ * All integers are stored little-endian.
+ */
+
+const (
+	filterMetaPage  byte = 'P'
+	filterGroupPage byte = 'G'
+	filterBitsPage  byte = 'E'
+
+	filterVersion = 1
+
+	filterGroupHeaderSize = 26
+	filterBitsHeaderSize  = 5
+
+	// filterMaxHashes is the largest number of hashes of a BloomFilter, reached for false
+	// positive rates below one in a billion.
+	filterMaxHashes = 30
+
+	// filterMinKeysPerPage is the number of keys per page that a group is sized for when its
+	// pages hold no keys yet.
+	filterMinKeysPerPage = 64
+)
+
+// Defaults of the options of a PageFilter.
+const (
+	DefaultFalsePositiveRate = 0.01
+	DefaultFilterPageGroup   = 8
+)
+
+// BloomFilter is a probabilistic set of byte string keys, which may report that it contains a key
+// that was never added but never that it does not contain a key that was added.
+type BloomFilter struct {
+	bits      []byte
+	numHashes int
+}
+
+// PageFilter keeps a BloomFilter for each group of consecutive pages of a table, stored in the
+// pages of a PageFile. A filter is not safe for concurrent use.
+type PageFilter struct {
+	file              PageFile
+	metaPageNum       uint32
+	pagesPerGroup     int
+	falsePositiveRate float64
+	freeList          uint32
+	groups            []*filterGroup
+}
+
+// filterGroup is the filter of a group of pages of a PageFilter.
+type filterGroup struct {
+	pageNums   []uint32
+	filter     *BloomFilter
+	capacity   uint32
+	numKeys    uint32
+	numRemoved uint32
+}
+
+// FilterDefinition describes a filter of a table: bloom filters of the keys made of the values of
+// some columns of the records, one for each group of pages of the table.
+type FilterDefinition struct {
+	// Name identifies the filter within its table.
+	Name string
+
+	// Columns are the names of the schema fields that make up the key of the filter, in order.
+	Columns []string
+
+	// FalsePositiveRate is the probability that the filter of a group of pages does not rule out
+	// a key that none of its records has. Zero means DefaultFalsePositiveRate.
+	FalsePositiveRate float64
+
+	// PagesPerGroup is the number of consecutive pages of the table that share a filter. Zero
+	// means DefaultFilterPageGroup.
+	PagesPerGroup int
+}
+
+// tableFilter is a filter of a table. Until the filter is built, the groups from built on are not
+// maintained.
+type tableFilter struct {
+	definition FilterDefinition
+	positions  []ElementPosition
+	filter     *PageFilter
+	built      int
+	ready      bool
+}
+
+// FilterExistsError is returned when a filter is created with the name of an existing filter.
+type FilterExistsError struct {
+	Name string
+}
+
+// UnknownFilterError is returned when a table has no filter with the given name.
+type UnknownFilterError struct {
+	Name string
+}
+
+func (e *FilterExistsError) Error() string {
+	return fmt.Sprintf("filter '%s' already exists", e.Name)
+}
+
+func (e *UnknownFilterError) Error() string {
+	return fmt.Sprintf("unknown filter '%s'", e.Name)
+}
+
+// NewBloomFilter returns an empty filter sized so that, once it holds the given number of keys,
+// keys that were not added are reported with the given false positive rate.
+func NewBloomFilter(capacity int, falsePositiveRate float64) *BloomFilter {
+	if capacity < 1 {
+		capacity = 1
+	}
+	numBits := math.Ceil(-float64(capacity) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	if numBits < 64 {
+		numBits = 64
+	}
+	numBytes := (int(numBits) + 7) / 8
+	numHashes := int(math.Round(float64(numBytes*8) / float64(capacity) * math.Ln2))
+	if numHashes < 1 {
+		numHashes = 1
+	} else if numHashes > filterMaxHashes {
+		numHashes = filterMaxHashes
+	}
+	return &BloomFilter{make([]byte, numBytes), numHashes}
+}
+
+// bitPositions returns the positions of the bits that the given key sets.
+func (f *BloomFilter) bitPositions(key []byte) []uint64 {
+	hash := fnv.New64a()
+	hash.Write(key)
+	h1 := hash.Sum64()
+	h2 := h1 + 0x9e3779b97f4a7c15
+	h2 = (h2 ^ h2>>30) * 0xbf58476d1ce4e5b9
+	h2 = (h2 ^ h2>>27) * 0x94d049bb133111eb
+	h2 ^= h2 >> 31
+
+	numBits := uint64(len(f.bits)) * 8
+	positions := make([]uint64, f.numHashes)
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % numBits
+	}
+	return positions
+}
+
+// Add adds the given key to the filter.
+func (f *BloomFilter) Add(key []byte) {
+	f.set(key)
+}
+
+// set adds the given key to the filter and returns the indexes of the bytes of bits that changed.
+func (f *BloomFilter) set(key []byte) []int {
+	var changed []int
+	for _, position := range f.bitPositions(key) {
+		i, bit := position/8, byte(1)<<(position%8)
+		if f.bits[i]&bit == 0 {
+			f.bits[i] |= bit
+			changed = append(changed, int(i))
+		}
+	}
+	return changed
+}
+
+// MayContain returns false if the given key was never added to the filter, and true if it was
+// added or, with the false positive rate of the filter, if it was not.
+func (f *BloomFilter) MayContain(key []byte) bool {
+	for _, position := range f.bitPositions(key) {
+		if f.bits[position/8]&(1<<(position%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// NewPageFilter creates an empty filter in the given file for groups of the given number of
+// pages, whose bloom filters have the given false positive rate. Zero values select
+// DefaultFilterPageGroup and DefaultFalsePositiveRate. The pages of the filter are appended to
+// the file; MetaPageNum returns the page to open the filter from later.
+func NewPageFilter(
+	file PageFile, pagesPerGroup int, falsePositiveRate float64,
+) (*PageFilter, error) {
+	if pagesPerGroup == 0 {
+		pagesPerGroup = DefaultFilterPageGroup
+	}
+	if falsePositiveRate == 0 {
+		falsePositiveRate = DefaultFalsePositiveRate
+	}
+	if pagesPerGroup < 0 || pagesPerGroup > math.MaxUint16 {
+		return nil, fmt.Errorf("invalid number of pages per group %d", pagesPerGroup)
+	}
+	if !(falsePositiveRate > 0 && falsePositiveRate < 1) {
+		return nil, fmt.Errorf("invalid false positive rate %v", falsePositiveRate)
+	}
+	pageNums, err := file.AppendPages(&[]Page{{}})
+	if err != nil {
+		return nil, err
+	}
+	p := &PageFilter{
+		file:              file,
+		metaPageNum:       pageNums[0],
+		pagesPerGroup:     pagesPerGroup,
+		falsePositiveRate: falsePositiveRate,
+		freeList:          noPage,
+	}
+	return p, p.writeMeta()
+}
+
+// OpenPageFilter opens the filter whose meta page is stored at the given page number of the given
+// file, and reads the filters of its groups into memory.
+func OpenPageFilter(file PageFile, metaPageNum uint32) (*PageFilter, error) {
+	pages, err := file.ReadPages(metaPageNum, 1)
+	if err != nil {
+		return nil, err
+	}
+	page := &(*pages)[0]
+	if page[0] != filterMetaPage {
+		return nil, &CorruptIndexError{metaPageNum, "not a meta page"}
+	}
+	if page[1] != filterVersion {
+		return nil, &CorruptIndexError{metaPageNum, fmt.Sprintf("unknown version %d", page[1])}
+	}
+	p := &PageFilter{
+		file:              file,
+		metaPageNum:       metaPageNum,
+		pagesPerGroup:     int(binary.LittleEndian.Uint16(page[2:])),
+		falsePositiveRate: math.Float64frombits(binary.LittleEndian.Uint64(page[4:])),
+		freeList:          binary.LittleEndian.Uint32(page[20:]),
+	}
+	if p.pagesPerGroup == 0 || !(p.falsePositiveRate > 0 && p.falsePositiveRate < 1) {
+		return nil, &CorruptIndexError{metaPageNum, "invalid options"}
+	}
+	numGroups := binary.LittleEndian.Uint32(page[16:])
+	for pageNum := binary.LittleEndian.Uint32(page[12:]); pageNum != noPage; {
+		if len(p.groups) == int(numGroups) {
+			return nil, &CorruptIndexError{pageNum, "too many groups"}
+		}
+		g, next, err := p.readGroup(pageNum)
+		if err != nil {
+			return nil, err
+		}
+		p.groups = append(p.groups, g)
+		pageNum = next
+	}
+	if len(p.groups) != int(numGroups) {
+		return nil, &CorruptIndexError{metaPageNum, "missing groups"}
+	}
+	return p, nil
+}
+
+// readGroup reads the group whose first page is stored at the given page number, and returns it
+// with the page number of the first page of the next group.
+func (p *PageFilter) readGroup(pageNum uint32) (*filterGroup, uint32, error) {
+	pages, err := p.file.ReadPages(pageNum, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	page := &(*pages)[0]
+	if page[0] != filterGroupPage {
+		return nil, 0, &CorruptIndexError{pageNum, "not a group page"}
+	}
+	g := &filterGroup{
+		pageNums:   []uint32{pageNum},
+		capacity:   binary.LittleEndian.Uint32(page[9:]),
+		numKeys:    binary.LittleEndian.Uint32(page[13:]),
+		numRemoved: binary.LittleEndian.Uint32(page[17:]),
+	}
+	numHashes := int(page[21])
+	numBytes := int(binary.LittleEndian.Uint32(page[22:]))
+	if numHashes == 0 || numHashes > filterMaxHashes || numBytes == 0 || g.numRemoved > g.numKeys {
+		return nil, 0, &CorruptIndexError{pageNum, "invalid group"}
+	}
+	g.filter = &BloomFilter{make([]byte, numBytes), numHashes}
+	n := copy(g.filter.bits, page[filterGroupHeaderSize:])
+	next := binary.LittleEndian.Uint32(page[1:])
+	for n < numBytes {
+		if next == noPage {
+			return nil, 0, &CorruptIndexError{pageNum, "missing bits pages"}
+		}
+		pages, err := p.file.ReadPages(next, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		page := &(*pages)[0]
+		if page[0] != filterBitsPage {
+			return nil, 0, &CorruptIndexError{next, "not a bits page"}
+		}
+		g.pageNums = append(g.pageNums, next)
+		n += copy(g.filter.bits[n:], page[filterBitsHeaderSize:])
+		next = binary.LittleEndian.Uint32(page[1:])
+	}
+	return g, binary.LittleEndian.Uint32(page[5:]), nil
+}
+
+// filterGroupBytes returns the number of bytes of bits that fit on the given number of pages of a
+// group.
+func filterGroupBytes(numPages int) int {
+	return PageSize - filterGroupHeaderSize + (numPages-1)*(PageSize-filterBitsHeaderSize)
+}
+
+// MetaPageNum returns the number of the page that the filter can be opened from with
+// OpenPageFilter.
+func (p *PageFilter) MetaPageNum() uint32 {
+	return p.metaPageNum
+}
+
+// PagesPerGroup returns the number of pages of each group.
+func (p *PageFilter) PagesPerGroup() int {
+	return p.pagesPerGroup
+}
+
+// FalsePositiveRate returns the false positive rate of the bloom filters of the groups.
+func (p *PageFilter) FalsePositiveRate() float64 {
+	return p.falsePositiveRate
+}
+
+// NumGroups returns the number of groups that have a filter.
+func (p *PageFilter) NumGroups() int {
+	return len(p.groups)
+}
+
+// writeMeta writes the meta page of the filter.
+func (p *PageFilter) writeMeta() error {
+	var page Page
+	page[0] = filterMetaPage
+	page[1] = filterVersion
+	binary.LittleEndian.PutUint16(page[2:], uint16(p.pagesPerGroup))
+	binary.LittleEndian.PutUint64(page[4:], math.Float64bits(p.falsePositiveRate))
+	firstPage := noPage
+	if len(p.groups) > 0 {
+		firstPage = p.groups[0].pageNums[0]
+	}
+	binary.LittleEndian.PutUint32(page[12:], firstPage)
+	binary.LittleEndian.PutUint32(page[16:], uint32(len(p.groups)))
+	binary.LittleEndian.PutUint32(page[20:], p.freeList)
+	_, err := p.file.WritePages(&[]Page{page}, p.metaPageNum)
+	return err
+}
+
+// writeGroupPages writes the pages of the group with the given index whose indexes in the chain of
+// the group are given.
+func (p *PageFilter) writeGroupPages(group int, pageIndexes map[int]bool) error {
+	g := p.groups[group]
+	for i := range g.pageNums {
+		if !pageIndexes[i] {
+			continue
+		}
+		var page Page
+		next := noPage
+		if i+1 < len(g.pageNums) {
+			next = g.pageNums[i+1]
+		}
+		binary.LittleEndian.PutUint32(page[1:], next)
+		if i == 0 {
+			page[0] = filterGroupPage
+			nextGroup := noPage
+			if group+1 < len(p.groups) {
+				nextGroup = p.groups[group+1].pageNums[0]
+			}
+			binary.LittleEndian.PutUint32(page[5:], nextGroup)
+			binary.LittleEndian.PutUint32(page[9:], g.capacity)
+			binary.LittleEndian.PutUint32(page[13:], g.numKeys)
+			binary.LittleEndian.PutUint32(page[17:], g.numRemoved)
+			page[21] = byte(g.filter.numHashes)
+			binary.LittleEndian.PutUint32(page[22:], uint32(len(g.filter.bits)))
+			copy(page[filterGroupHeaderSize:], g.filter.bits)
+		} else {
+			page[0] = filterBitsPage
+			copy(page[filterBitsHeaderSize:], g.filter.bits[filterGroupBytes(i):])
+		}
+		if _, err := p.file.WritePages(&[]Page{page}, g.pageNums[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setGroup replaces the filter of the group with the given index by the given filter, sized for
+// the given capacity and holding the given number of keys, adding the group if it is the next
+// one, and writes all pages of the group.
+func (p *PageFilter) setGroup(
+	group int, filter *BloomFilter, capacity int, numKeys int,
+) error {
+	numPages := 1
+	for filterGroupBytes(numPages) < len(filter.bits) {
+		numPages++
+	}
+	var g *filterGroup
+	if group < len(p.groups) {
+		g = p.groups[group]
+	} else {
+		g = &filterGroup{}
+	}
+	for len(g.pageNums) > numPages {
+		last := len(g.pageNums) - 1
+		if err := freeIndexPage(p.file, &p.freeList, g.pageNums[last]); err != nil {
+			return err
+		}
+		g.pageNums = g.pageNums[:last]
+	}
+	for len(g.pageNums) < numPages {
+		pageNum, err := allocateIndexPage(p.file, &p.freeList)
+		if err != nil {
+			return err
+		}
+		g.pageNums = append(g.pageNums, pageNum)
+	}
+	g.filter, g.capacity, g.numKeys, g.numRemoved = filter, uint32(capacity), uint32(numKeys), 0
+
+	pageIndexes := make(map[int]bool, numPages)
+	for i := range g.pageNums {
+		pageIndexes[i] = true
+	}
+	if group == len(p.groups) {
+		p.groups = append(p.groups, g)
+		if group > 0 {
+			// The previous group links to the new one.
+			if err := p.writeGroupPages(group-1, map[int]bool{0: true}); err != nil {
+				return err
+			}
+		}
+	}
+	if err := p.writeGroupPages(group, pageIndexes); err != nil {
+		return err
+	}
+	return p.writeMeta()
+}
+
+// BuildGroup replaces the filter of the group with the given index by a filter of the given keys,
+// sized for the given number of keys or the number of given keys, whichever is larger. The
+// groups before the given group must have a filter; groups are added in order.
+func (p *PageFilter) BuildGroup(group int, keys [][]byte, capacity int) error {
+	if group < 0 || group > len(p.groups) {
+		return fmt.Errorf("group %d is not the next group %d", group, len(p.groups))
+	}
+	if capacity < len(keys) {
+		capacity = len(keys)
+	}
+	if capacity < filterMinKeysPerPage {
+		capacity = filterMinKeysPerPage
+	}
+	filter := NewBloomFilter(capacity, p.falsePositiveRate)
+	for _, key := range keys {
+		filter.Add(key)
+	}
+	return p.setGroup(group, filter, capacity, len(keys))
+}
+
+// Add adds the given key to the filter of the group with the given index. The groups up to the
+// given group that have no filter yet get an empty one, sized like the previous group.
+func (p *PageFilter) Add(group int, key []byte) error {
+	if group < 0 {
+		return fmt.Errorf("invalid group %d", group)
+	}
+	for len(p.groups) <= group {
+		capacity := filterMinKeysPerPage * p.pagesPerGroup
+		if n := len(p.groups); n > 0 && int(p.groups[n-1].capacity) > capacity {
+			capacity = int(p.groups[n-1].capacity)
+		}
+		err := p.setGroup(len(p.groups), NewBloomFilter(capacity, p.falsePositiveRate), capacity, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	g := p.groups[group]
+	g.numKeys++
+	pageIndexes := map[int]bool{0: true}
+	for _, i := range g.filter.set(key) {
+		n := 0
+		for filterGroupBytes(n+1) <= i {
+			n++
+		}
+		pageIndexes[n] = true
+	}
+	return p.writeGroupPages(group, pageIndexes)
+}
+
+// Remove counts a key of the group with the given index as removed. Its bits stay set, so the
+// filter keeps reporting that it may contain the key until the group is rebuilt.
+func (p *PageFilter) Remove(group int) error {
+	if group < 0 || group >= len(p.groups) {
+		return nil
+	}
+	g := p.groups[group]
+	if g.numRemoved < g.numKeys {
+		g.numRemoved++
+	}
+	return p.writeGroupPages(group, map[int]bool{0: true})
+}
+
+// MayContain returns false if no key added to the group with the given index since its filter was
+// built is the given key. It returns true for groups without a filter.
+func (p *PageFilter) MayContain(group int, key []byte) bool {
+	if group < 0 || group >= len(p.groups) {
+		return true
+	}
+	return p.groups[group].filter.MayContain(key)
+}
+
+// Stale returns true if the group with the given index holds more keys than it was sized for, or
+// if more than a quarter of its keys were removed, so that its filter should be rebuilt.
+func (p *PageFilter) Stale(group int) bool {
+	if group < 0 || group >= len(p.groups) {
+		return false
+	}
+	g := p.groups[group]
+	return g.numKeys > g.capacity || g.numRemoved*4 > g.numKeys
+}
+
+// newFilter returns a filter of the table with the given definition, without a PageFilter.
+func (t *Table) newFilter(definition FilterDefinition) (*tableFilter, error) {
+	for _, filter := range t.filters {
+		if filter.definition.Name == definition.Name {
+			return nil, &FilterExistsError{definition.Name}
+		}
+	}
+	if len(definition.Columns) == 0 {
+		return nil, fmt.Errorf("filter '%s' has no columns", definition.Name)
+	}
+	if t.lsm != nil {
+		// The SSTables of the tree have bloom filters of their own.
+		return nil, fmt.Errorf("filters need a table stored on table pages")
+	}
+	filter := &tableFilter{
+		definition: definition,
+		positions:  make([]ElementPosition, len(definition.Columns)),
+	}
+	for i, name := range definition.Columns {
+		position, err := t.schema.position(name)
+		if err != nil {
+			return nil, err
+		}
+		filter.positions[i] = position
+	}
+	return filter, nil
+}
+
+// filterKey returns the encoded key that the given filter stores for the given record.
+func (t *Table) filterKey(filter *tableFilter, record *Record) ([]byte, error) {
+	values := make([]any, len(filter.positions))
+	for i, position := range filter.positions {
+		_, value, err := record.getValue(position, t.schema[position].Type)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return element.EncodeKey(values...)
+}
+
+// filterGroup returns the index of the group of the page that holds the given home address.
+func (t *Table) filterGroup(filter *tableFilter, address RecordAddress) int {
+	return t.pages[address.PageNum] / filter.filter.pagesPerGroup
+}
+
+// maintained returns true if writes to the given group must be applied to the given filter.
+func (f *tableFilter) maintained(group int) bool {
+	return f.ready || group < f.built
+}
+
+// addFilterKeys adds the keys of the given record with the given home address to the filters of
+// the table.
+func (t *Table) addFilterKeys(address RecordAddress, record *Record) error {
+	for _, filter := range t.filters {
+		group := t.filterGroup(filter, address)
+		if !filter.maintained(group) {
+			continue
+		}
+		key, err := t.filterKey(filter, record)
+		if err != nil {
+			return err
+		}
+		if err = filter.filter.Add(group, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateFilterKeys updates the filters of the table for the record with the given home address
+// that was replaced by another record, when its key changed.
+func (t *Table) updateFilterKeys(address RecordAddress, existing, record *Record) error {
+	for _, filter := range t.filters {
+		group := t.filterGroup(filter, address)
+		if !filter.maintained(group) {
+			continue
+		}
+		oldKey, err := t.filterKey(filter, existing)
+		if err != nil {
+			return err
+		}
+		newKey, err := t.filterKey(filter, record)
+		if err != nil {
+			return err
+		}
+		if string(oldKey) == string(newKey) {
+			continue
+		}
+		if err = filter.filter.Remove(group); err != nil {
+			return err
+		}
+		if err = filter.filter.Add(group, newKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeFilterKeys counts the keys of the record with the given home address as removed from the
+// filters of the table.
+func (t *Table) removeFilterKeys(address RecordAddress) error {
+	for _, filter := range t.filters {
+		group := t.filterGroup(filter, address)
+		if !filter.maintained(group) {
+			continue
+		}
+		if err := filter.filter.Remove(group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// groupPageNums returns the page numbers of the pages of the table in the group with the given
+// index of a filter with the given number of pages per group.
+func (t *Table) groupPageNums(group int, pagesPerGroup int) []uint32 {
+	start, end := group*pagesPerGroup, (group+1)*pagesPerGroup
+	if end > len(t.pageNums) {
+		end = len(t.pageNums)
+	}
+	return t.pageNums[start:end]
+}
+
+// buildGroup rebuilds the filter of the group with the given index of the given filter from the
+// records of its pages.
+func (t *Table) buildGroup(filter *tableFilter, group int) error {
+	pagesPerGroup := filter.filter.pagesPerGroup
+	pageNums := t.groupPageNums(group, pagesPerGroup)
+	var keys [][]byte
+	for _, pageNum := range pageNums {
+		err := t.scanPage(
+			pageNum, func(address RecordAddress, record *Record) error {
+				key, err := t.filterKey(filter, record)
+				keys = append(keys, key)
+				return err
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	// Leave room for a quarter more keys, and for the pages that the group does not have yet.
+	capacity := len(keys) + len(keys)/4
+	if len(pageNums) > 0 {
+		capacity = capacity * pagesPerGroup / len(pageNums)
+	}
+	if capacity < filterMinKeysPerPage*pagesPerGroup {
+		capacity = filterMinKeysPerPage * pagesPerGroup
+	}
+	return filter.filter.BuildGroup(group, keys, capacity)
+}
+
+// numGroups returns the number of groups of the pages of the table for the given filter.
+func (t *Table) numGroups(filter *tableFilter) int {
+	pagesPerGroup := filter.filter.pagesPerGroup
+	return (len(t.pageNums) + pagesPerGroup - 1) / pagesPerGroup
+}
+
+// CreateFilter creates a filter of the table with the given definition and builds it from the
+// records stored in the table. The filter is built online: the table is locked for one group of
+// pages at a time, and records inserted, updated and deleted in between are added to and removed
+// from the built groups as usual. ScanKey reads the groups that are not built yet. In a
+// transaction, the whole filter is built while the transaction holds the table, and it is dropped
+// again if the transaction is rolled back.
+func (t *Table) CreateFilter(tx *Tx, definition FilterDefinition) error {
+	if err := t.lockSchema(tx); err != nil {
+		return err
+	}
+	filter, err := t.newFilter(definition)
+	if err == nil {
+		filter.filter, err = NewPageFilter(
+			t.file, definition.PagesPerGroup, definition.FalsePositiveRate,
+		)
+	}
+	if err == nil {
+		t.filters = append(t.filters, filter)
+	}
+	t.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for {
+		if err = t.lockSchema(tx); err != nil {
+			t.wait()
+			t.dropFilter(filter)
+			t.mutex.Unlock()
+			return err
+		}
+		if filter.built == t.numGroups(filter) {
+			filter.ready = true
+			t.mutex.Unlock()
+			return nil
+		}
+		err = t.buildGroup(filter, filter.built)
+		if err == nil {
+			filter.built++
+		} else {
+			t.dropFilter(filter)
+		}
+		t.mutex.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// dropFilter removes the given filter from the table. Its pages are not reclaimed.
+func (t *Table) dropFilter(filter *tableFilter) {
+	for i, other := range t.filters {
+		if other == filter {
+			t.filters = append(t.filters[:i], t.filters[i+1:]...)
+			return
+		}
+	}
+}
+
+// DropFilter removes the filter with the given name from the table. The pages of the filter are
+// not reclaimed. In a transaction, the filter is added back if the transaction is rolled back.
+func (t *Table) DropFilter(tx *Tx, name string) error {
+	if err := t.lockSchema(tx); err != nil {
+		return err
+	}
+	defer t.mutex.Unlock()
+	for _, filter := range t.filters {
+		if filter.definition.Name == name {
+			t.dropFilter(filter)
+			return nil
+		}
+	}
+	return &UnknownFilterError{name}
+}
+
+// Filters returns the definitions of the filters of the table, in the order they were created.
+func (t *Table) Filters() []FilterDefinition {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	definitions := make([]FilterDefinition, len(t.filters))
+	for i, filter := range t.filters {
+		definitions[i] = filter.definition
+	}
+	return definitions
+}
+
+// RebuildFilters rebuilds the stale groups of the built filters of the table from the records of
+// their pages, and returns the number of groups rebuilt. It is meant to be called periodically by
+// a maintenance job. Like CreateFilter, it locks the table for one group at a time. In a
+// transaction, the rebuilt groups are written through the transaction, and the filters are
+// restored if the transaction is rolled back.
+func (t *Table) RebuildFilters(tx *Tx) (int, error) {
+	if err := t.lockSchema(tx); err != nil {
+		return 0, err
+	}
+	filters := append([]*tableFilter(nil), t.filters...)
+	t.mutex.Unlock()
+
+	rebuilt := 0
+	for _, filter := range filters {
+		for group := 0; ; group++ {
+			if err := t.lockSchema(tx); err != nil {
+				return rebuilt, err
+			}
+			if group >= filter.filter.NumGroups() {
+				t.mutex.Unlock()
+				break
+			}
+			var err error
+			if filter.ready && filter.filter.Stale(group) {
+				if err = t.buildGroup(filter, group); err == nil {
+					rebuilt++
+				}
+			}
+			t.mutex.Unlock()
+			if err != nil {
+				return rebuilt, err
+			}
+		}
+	}
+	return rebuilt, nil
+}
+
+// ScanKey calls fn with the home address and the record of each record of the table whose key in
+// the filter with the given name is the given key, in the order of Scan, stopping at the first
+// error returned by fn. The key must have a value for each column of the filter. The pages of the
+// groups whose filter rules out the key are not read. fn must not modify the table.
+func (t *Table) ScanKey(
+	tx *Tx, name string, key []any, fn func(address RecordAddress, record *Record) error,
+) error {
+	if err := t.lockQuery(tx); err != nil {
+		return err
+	}
+	defer t.mutex.Unlock()
+	var filter *tableFilter
+	for _, other := range t.filters {
+		if other.definition.Name == name {
+			filter = other
+		}
+	}
+	if filter == nil {
+		return &UnknownFilterError{name}
+	}
+	if len(key) != len(filter.positions) {
+		return fmt.Errorf(
+			"filter '%s' has %d key columns, got %d values", name, len(filter.positions), len(key),
+		)
+	}
+	encoded, err := element.EncodeKey(key...)
+	if err != nil {
+		return err
+	}
+
+	for group := 0; group < t.numGroups(filter); group++ {
+		if filter.maintained(group) && !filter.filter.MayContain(group, encoded) {
+			continue
+		}
+		for _, pageNum := range t.groupPageNums(group, filter.filter.pagesPerGroup) {
+			err := t.scanPage(
+				pageNum, func(address RecordAddress, record *Record) error {
+					recordKey, err := t.filterKey(filter, record)
+					if err != nil || string(recordKey) != string(encoded) {
+						return err
+					}
+					return fn(address, record)
+				},
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// FilterMetaPageNum returns the number of the meta page of the filter with the given name, which
+// OpenFilter opens the filter from when the table is opened again.
+func (t *Table) FilterMetaPageNum(name string) (uint32, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, filter := range t.filters {
+		if filter.definition.Name == name {
+			return filter.filter.MetaPageNum(), nil
+		}
+	}
+	return 0, &UnknownFilterError{name}
+}
+
+// OpenFilter adds the filter with the given definition whose PageFilter is stored at the given
+// meta page of the file of the table, as returned by FilterMetaPageNum. The filter must have been
+// built for the pages of the table, and kept up to date with every write to the table since. The
+// pages per group and the false positive rate of the definition are those of the stored filter.
+func (t *Table) OpenFilter(definition FilterDefinition, metaPageNum uint32) error {
+	t.wait()
+	defer t.mutex.Unlock()
+	pageFilter, err := OpenPageFilter(t.file, metaPageNum)
+	if err != nil {
+		return err
+	}
+	definition.PagesPerGroup = pageFilter.PagesPerGroup()
+	definition.FalsePositiveRate = pageFilter.FalsePositiveRate()
+	filter, err := t.newFilter(definition)
+	if err != nil {
+		return err
+	}
+	filter.filter, filter.ready = pageFilter, true
+	t.filters = append(t.filters, filter)
+	return nil
+}
@@ -0,0 +1,443 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// countingFile is a memoryFile that counts the reads of each page.
+type countingFile struct {
+	*memoryFile
+	reads map[uint32]int
+}
+
+func (f *countingFile) ReadPages(pageNum uint32, numPages uint32) (*[]Page, error) {
+	for i := uint32(0); i < numPages; i++ {
+		f.reads[pageNum+i]++
+	}
+	return f.memoryFile.ReadPages(pageNum, numPages)
+}
+
+// pageReads returns the number of reads of the given pages since the reads were last reset.
+func (f *countingFile) pageReads(pageNums []uint32) int {
+	reads := 0
+	for _, pageNum := range pageNums {
+		reads += f.reads[pageNum]
+	}
+	f.reads = make(map[uint32]int)
+	return reads
+}
+
+func TestBloomFilter(t *testing.T) {
+	t.Run(
+		"check sizing", func(t *testing.T) {
+			f := NewBloomFilter(1000, 0.01)
+			if len(f.bits) != 1199 || f.numHashes != 7 {
+				t.Errorf(
+					"expected 1199 bytes and 7 hashes, got %d and %d", len(f.bits), f.numHashes,
+				)
+			}
+			f = NewBloomFilter(0, 0.5)
+			if len(f.bits) != 8 || f.numHashes < 1 {
+				t.Errorf("expected 8 bytes, got %d with %d hashes", len(f.bits), f.numHashes)
+			}
+		},
+	)
+
+	t.Run(
+		"check false positive rate", func(t *testing.T) {
+			for _, rate := range []float64{0.1, 0.01, 0.001} {
+				f := NewBloomFilter(5000, rate)
+				for i := 0; i < 5000; i++ {
+					f.Add([]byte(fmt.Sprintf("key %d", i)))
+				}
+				for i := 0; i < 5000; i++ {
+					if !f.MayContain([]byte(fmt.Sprintf("key %d", i))) {
+						t.Fatalf("%v: added key %d is not contained", rate, i)
+					}
+				}
+				falsePositives := 0
+				for i := 0; i < 100000; i++ {
+					if f.MayContain([]byte(fmt.Sprintf("other %d", i))) {
+						falsePositives++
+					}
+				}
+				if measured := float64(falsePositives) / 100000; measured > rate*1.5 {
+					t.Errorf("expected false positive rate %v, got %v", rate, measured)
+				}
+			}
+		},
+	)
+}
+
+func TestPageFilter(t *testing.T) {
+	t.Run(
+		"check groups", func(t *testing.T) {
+			p, err := NewPageFilter(&memoryFile{}, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.PagesPerGroup() != DefaultFilterPageGroup ||
+				p.FalsePositiveRate() != DefaultFalsePositiveRate {
+				t.Errorf("expected default options, got %d and %v", p.PagesPerGroup(),
+					p.FalsePositiveRate())
+			}
+			if err = p.BuildGroup(1, nil, 0); err == nil {
+				t.Error("expected error for a group out of order")
+			}
+			if err = p.BuildGroup(0, [][]byte{[]byte("a"), []byte("b")}, 0); err != nil {
+				t.Fatal(err)
+			}
+			if err = p.Add(2, []byte("c")); err != nil {
+				t.Fatal(err)
+			}
+			if p.NumGroups() != 3 {
+				t.Fatalf("expected 3 groups, got %d", p.NumGroups())
+			}
+			for group, key := range []string{"a", "", "c"} {
+				if key != "" && !p.MayContain(group, []byte(key)) {
+					t.Errorf("group %d: expected %s", group, key)
+				}
+			}
+			if p.MayContain(1, []byte("a")) {
+				t.Error("expected empty group 1 to rule out a")
+			}
+			if !p.MayContain(3, []byte("x")) {
+				t.Error("expected a group without a filter not to rule out keys")
+			}
+		},
+	)
+
+	t.Run(
+		"check stale groups", func(t *testing.T) {
+			p, err := NewPageFilter(&memoryFile{}, 1, 0.01)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = p.BuildGroup(0, nil, 4); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < filterMinKeysPerPage; i++ {
+				if err = p.Add(0, []byte(fmt.Sprint(i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if p.Stale(0) {
+				t.Error("expected a full group not to be stale")
+			}
+			if err = p.Add(0, []byte("x")); err != nil {
+				t.Fatal(err)
+			}
+			if !p.Stale(0) {
+				t.Error("expected a group over capacity to be stale")
+			}
+
+			keys := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+			if err = p.BuildGroup(0, keys, 0); err != nil {
+				t.Fatal(err)
+			}
+			if err = p.Remove(0); err != nil {
+				t.Fatal(err)
+			}
+			if !p.Stale(0) || !p.MayContain(0, []byte("a")) {
+				t.Error("expected a stale group still containing all keys")
+			}
+		},
+	)
+
+	t.Run(
+		"check reopen", func(t *testing.T) {
+			file := &memoryFile{}
+			p, err := NewPageFilter(file, 4, 0.001)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keys [][]byte
+			for i := 0; i < 20000; i++ {
+				keys = append(keys, []byte(fmt.Sprintf("key %d", i)))
+			}
+			// The first group spans several pages.
+			if err = p.BuildGroup(0, keys, 0); err != nil {
+				t.Fatal(err)
+			}
+			if len(p.groups[0].pageNums) != 5 {
+				t.Errorf("expected 5 pages, got %d", len(p.groups[0].pageNums))
+			}
+			if err = p.BuildGroup(1, keys[:10], 0); err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range keys[10:20] {
+				if err = p.Add(1, key); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = p.Remove(1); err != nil {
+				t.Fatal(err)
+			}
+
+			opened, err := OpenPageFilter(file, p.MetaPageNum())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(opened, p) {
+				t.Error("expected the opened filter to equal the written one")
+			}
+
+			// Rebuilding the first group with fewer keys frees pages for the next group.
+			numPages := len(file.pages)
+			if err = opened.BuildGroup(0, keys[:100], 0); err != nil {
+				t.Fatal(err)
+			}
+			if err = opened.BuildGroup(2, keys, 0); err != nil {
+				t.Fatal(err)
+			}
+			if len(file.pages) != numPages+1 {
+				t.Errorf("expected 1 appended page, got %d", len(file.pages)-numPages)
+			}
+			if opened, err = OpenPageFilter(file, p.MetaPageNum()); err != nil {
+				t.Fatal(err)
+			}
+			if opened.NumGroups() != 3 || !opened.MayContain(2, keys[19999]) {
+				t.Error("expected 3 groups")
+			}
+		},
+	)
+
+	t.Run(
+		"check corrupt filter", func(t *testing.T) {
+			file := &memoryFile{}
+			p, err := NewPageFilter(file, 1, 0.01)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = p.BuildGroup(0, nil, 20000); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = OpenPageFilter(file, p.groups[0].pageNums[0]); err == nil {
+				t.Error("expected error for a group page opened as meta page")
+			}
+			file.pages[p.groups[0].pageNums[0]][1] = 0xff
+			file.pages[p.groups[0].pageNums[0]][2] = 0xff
+			file.pages[p.groups[0].pageNums[0]][3] = 0xff
+			file.pages[p.groups[0].pageNums[0]][4] = 0xff
+			_, err = OpenPageFilter(file, p.MetaPageNum())
+			if _, ok := err.(*CorruptIndexError); !ok {
+				t.Errorf("expected CorruptIndexError for missing bits pages, got %v", err)
+			}
+		},
+	)
+}
+
+// newTestFilterTable returns a table with 2000 records of testTableSchema, whose name is the id
+// modulo 500, and a filter on the name with one page per group.
+func newTestFilterTable(t *testing.T) (*Table, *countingFile) {
+	t.Helper()
+	file := &countingFile{&memoryFile{}, make(map[uint32]int)}
+	table, err := OpenTable(testTableSchema, file, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2000; i++ {
+		record := testTableRecord(t, int64(i), fmt.Sprint("name ", i%500), nil)
+		if _, err = table.Insert(nil, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	definition := FilterDefinition{Name: "name", Columns: []string{"name"}, PagesPerGroup: 1}
+	if err = table.CreateFilter(nil, definition); err != nil {
+		t.Fatal(err)
+	}
+	return table, file
+}
+
+// scanKey returns the ids of the records with the given key in the filter with the given name.
+func scanKey(t *testing.T, table *Table, name string, key ...any) []int64 {
+	t.Helper()
+	var ids []int64
+	err := table.ScanKey(
+		nil, name, key, func(address RecordAddress, record *Record) error {
+			_, id, err := record.GetInt64(0)
+			ids = append(ids, id)
+			return err
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func TestTable_ScanKey(t *testing.T) {
+	t.Run(
+		"check pages are skipped", func(t *testing.T) {
+			table, file := newTestFilterTable(t)
+			numPages := len(table.pageNums)
+			if numPages < 8 {
+				t.Fatalf("expected at least 8 pages, got %d", numPages)
+			}
+			file.pageReads(table.pageNums)
+
+			ids := scanKey(t, table, "name", "name 7")
+			if !reflect.DeepEqual(ids, []int64{7, 507, 1007, 1507}) {
+				t.Errorf("expected records 7, 507, 1007 and 1507, got %v", ids)
+			}
+			if reads := file.pageReads(table.pageNums); reads >= numPages {
+				t.Errorf("expected fewer than %d page reads, got %d", numPages, reads)
+			}
+			reads := 0
+			for i := 0; i < 100; i++ {
+				if ids = scanKey(t, table, "name", fmt.Sprint("missing ", i)); ids != nil {
+					t.Errorf("expected no records, got %v", ids)
+				}
+				reads += file.pageReads(table.pageNums)
+			}
+			if reads > 10 {
+				t.Errorf("expected few page reads for missing keys, got %d", reads)
+			}
+		},
+	)
+
+	t.Run(
+		"check writes", func(t *testing.T) {
+			table, file := newTestFilterTable(t)
+			address, err := table.Insert(nil, testTableRecord(t, 2000, "new", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids := scanKey(t, table, "name", "new"); !reflect.DeepEqual(ids, []int64{2000}) {
+				t.Errorf("expected inserted record, got %v", ids)
+			}
+			err = table.Update(nil, address, testTableRecordWithBio(t, 2000, "newer", nil, "bio"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids := scanKey(t, table, "name", "newer"); !reflect.DeepEqual(ids, []int64{2000}) {
+				t.Errorf("expected updated record, got %v", ids)
+			}
+			if ids := scanKey(t, table, "name", "new"); ids != nil {
+				t.Errorf("expected no records, got %v", ids)
+			}
+
+			// A record moved to another page is found through its home page.
+			first := table.address(table.pageNums[0], 0)
+			bio := string(make([]byte, 4000))
+			for _, bio := range []string{bio, bio + bio} {
+				err = table.Update(nil, first, testTableRecordWithBio(t, 0, "moved", nil, bio))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if ids := scanKey(t, table, "name", "moved"); !reflect.DeepEqual(ids, []int64{0}) {
+				t.Errorf("expected moved record, got %v", ids)
+			}
+			if err = table.Delete(nil, first); err != nil {
+				t.Fatal(err)
+			}
+			if ids := scanKey(t, table, "name", "moved"); ids != nil {
+				t.Errorf("expected no records, got %v", ids)
+			}
+
+			// Filters are persisted with the table.
+			metaPageNum, err := table.FilterMetaPageNum("name")
+			if err != nil {
+				t.Fatal(err)
+			}
+			reopened, err := OpenTable(testTableSchema, file, 1, table.pageNums)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = reopened.OpenFilter(FilterDefinition{Name: "name", Columns: []string{"name"}},
+				metaPageNum)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if definitions := reopened.Filters(); definitions[0].PagesPerGroup != 1 {
+				t.Errorf("expected 1 page per group, got %d", definitions[0].PagesPerGroup)
+			}
+			ids := scanKey(t, reopened, "name", "newer")
+			if !reflect.DeepEqual(ids, []int64{2000}) {
+				t.Errorf("expected updated record, got %v", ids)
+			}
+		},
+	)
+
+	t.Run(
+		"check rebuild", func(t *testing.T) {
+			table, _ := newTestFilterTable(t)
+			if rebuilt, err := table.RebuildFilters(nil); err != nil || rebuilt != 0 {
+				t.Errorf("expected no stale groups, got %d, %v", rebuilt, err)
+			}
+			page := table.pageNums[1]
+			for slotNum := uint16(0); slotNum < 100; slotNum++ {
+				if err := table.Delete(nil, table.address(page, slotNum)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			filter := table.filters[0].filter
+			if !filter.Stale(1) || filter.Stale(0) {
+				t.Fatal("expected only group 1 to be stale")
+			}
+
+			// A rebuild in a transaction is undone when the transaction is rolled back.
+			m, err := NewTxManager(&memoryFile{}, table.file.PageFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx, err := m.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rebuilt, err := table.RebuildFilters(tx); err != nil || rebuilt != 1 {
+				t.Errorf("expected 1 rebuilt group, got %d, %v", rebuilt, err)
+			}
+			if err = tx.Rollback(); err != nil {
+				t.Fatal(err)
+			}
+			filter = table.filters[0].filter
+			if !filter.Stale(1) || filter.Stale(0) {
+				t.Error("expected only group 1 to be stale after the rollback")
+			}
+
+			if rebuilt, err := table.RebuildFilters(nil); err != nil || rebuilt != 1 {
+				t.Errorf("expected 1 rebuilt group, got %d, %v", rebuilt, err)
+			}
+			if filter.Stale(1) || filter.groups[1].numKeys == 0 {
+				t.Errorf("expected group 1 to be rebuilt, got %+v", filter.groups[1])
+			}
+		},
+	)
+
+	t.Run(
+		"check errors", func(t *testing.T) {
+			table, _ := newTestFilterTable(t)
+			err := table.CreateFilter(nil, FilterDefinition{Name: "name", Columns: []string{"id"}})
+			if _, ok := err.(*FilterExistsError); !ok {
+				t.Errorf("expected FilterExistsError, got %v", err)
+			}
+			err = table.CreateFilter(nil, FilterDefinition{Name: "x", Columns: []string{"missing"}})
+			if err == nil {
+				t.Error("expected error for an unknown column")
+			}
+			err = table.CreateFilter(
+				nil, FilterDefinition{Name: "x", Columns: []string{"id"}, FalsePositiveRate: 1},
+			)
+			if err == nil {
+				t.Error("expected error for an invalid false positive rate")
+			}
+			if err = table.ScanKey(nil, "name", []any{"a", "b"}, nil); err == nil {
+				t.Error("expected error for a key with too many values")
+			}
+			if err = table.DropFilter(nil, "name"); err != nil {
+				t.Fatal(err)
+			}
+			err = table.ScanKey(nil, "name", []any{"a"}, nil)
+			if _, ok := err.(*UnknownFilterError); !ok {
+				t.Errorf("expected UnknownFilterError, got %v", err)
+			}
+			if len(table.Filters()) != 0 {
+				t.Error("expected no filters")
+			}
+		},
+	)
+}
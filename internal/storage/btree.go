@@ -0,0 +1,1162 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"kyadb/internal/structs/element"
+)
+
+/*
+ * A BTree is a B+tree index stored in the pages of a PageFile. It maps keys made of one or more
+ * element values to the addresses of records.
+ *
+ * Every entry of the tree is stored as a byte string: the order-preserving key encoding of its key
+ * values, as returned by element.Comparator.EncodeKeyWithOrder with the collation and null order
+ * of the tree, followed by 8 bytes storing the record address
+ * big-endian: 2 bytes for the file ID, 4 bytes for the page number and 2 bytes for the slot number.
+ * Key encodings are self-delimiting, so comparing entries bytewise orders them by key and then by
+ * address, and all entries of a key are stored next to each other.
+ *
+ * The meta page of a tree starts with a byte storing btreeMetaPage, followed by a byte storing the
+ * version of the format, a byte of flags, a byte storing the number of key columns, 4 bytes
+ * storing the page number of the root node, 4 bytes storing the page number of the first page of
+ * the free list, 8 bytes storing the number of entries and a byte per key column storing its sort
+ * order. The name of the collation of the tree is stored after room for the orders of
+ * MaxBTreeKeyColumns columns, as a byte storing its length followed by the name.
+ *
+ * A node page starts with a byte storing its page type, 2 bytes storing the number of cells in the
+ * node and two 4-byte page numbers. For a leaf node, those are the page numbers of the next and the
+ * previous leaf, or noPage, and each cell stores the length of an entry in 2 bytes followed by the
+ * entry. For an internal node, the first page number is that of its last child and each cell stores
+ * the page number of a child in 4 bytes, followed by the length of a separator in 2 bytes and the
+ * separator. A child stores the entries that are greater than or equal to the separator before it
+ * and less than its own separator.
+ *
+ * Pages that are no longer used by the tree are added to the free list of the tree, whose
+ * head is stored in the meta page, and are reused before new pages are appended to the file.
+ *
+ * All integers are stored little-endian except for the record addresses in entries.
+ */
+
+const (
+	btreeMetaPage     byte = 'M'
+	btreeLeafPage     byte = 'L'
+	btreeInternalPage byte = 'I'
+
+	btreeVersion       = 1
+	btreeUniqueFlag    = 1 << 0
+	btreeNullsLastFlag = 1 << 1
+
+	btreeMetaHeaderSize      = 20
+	btreeMetaCollationOffset = btreeMetaHeaderSize + MaxBTreeKeyColumns
+	btreeNodeHeaderSize      = 11
+	btreeAddressSize         = 8
+
+	// btreeMaxHeight bounds the depth of descents, so that damaged pages that form a cycle cannot
+	// make them loop forever.
+	btreeMaxHeight = 64
+
+	// btreeMinNodeSize is the number of bytes below which a node that is not the root is merged
+	// with or refilled from a sibling.
+	btreeMinNodeSize = PageSize / 4
+
+	// btreeBulkFillSize is the number of bytes that nodes are filled up to by BuildBTree, leaving
+	// room for later inserts.
+	btreeBulkFillSize = PageSize * 9 / 10
+)
+
+// MaxBTreeKeyColumns is the maximum number of key columns of a BTree.
+const MaxBTreeKeyColumns = 32
+
+// MaxBTreeKeySize is the maximum size in bytes of the key encoding of the key of a BTree entry. It
+// ensures that every node can hold at least four entries.
+const MaxBTreeKeySize = (PageSize-btreeNodeHeaderSize)/4 - 6 - btreeAddressSize
+
+// BTreeOptions describes the keys of a BTree.
+type BTreeOptions struct {
+	// Orders holds the sort order of each key column, so the number of key columns is len(Orders).
+	Orders []element.SortOrder
+
+	// Unique rejects entries whose key is already stored in the tree.
+	Unique bool
+
+	// Collation is the name of a collation registered with element.RegisterCollation that string
+	// keys are ordered by. String keys are ordered bytewise if it is empty. Strings returned from
+	// the tree are the collation keys of the inserted strings.
+	Collation string
+
+	// NullsLast orders null keys after all other keys instead of before them.
+	NullsLast bool
+}
+
+// BTreeEntry is an entry of a BTree.
+type BTreeEntry struct {
+	Key     []any
+	Address RecordAddress
+}
+
+// KeyBound is the lower or upper bound of a range of keys. Key can have fewer values than the key
+// columns of the tree, in which case the bound applies to all keys starting with those values.
+type KeyBound struct {
+	Key       []any
+	Exclusive bool
+}
+
+// BTree is a B+tree index stored in the pages of a PageFile, which maps keys made of element
+// values to record addresses. A tree is not safe for concurrent use.
+type BTree struct {
+	file        PageFile
+	metaPageNum uint32
+	orders      []element.SortOrder
+	unique      bool
+	collation   string
+	comparator  element.Comparator
+	root        uint32
+	freeList    uint32
+	numEntries  uint64
+}
+
+// BTreeIterator iterates over the entries of a range of keys of a BTree. The iterator must not be
+// used after the tree is modified.
+type BTreeIterator struct {
+	tree    *BTree
+	node    *btreeNode
+	index   int
+	low     []byte
+	high    []byte
+	reverse bool
+}
+
+// btreeNode is a node of a BTree, decoded from its page.
+type btreeNode struct {
+	pageNum  uint32
+	leaf     bool
+	keys     [][]byte
+	children []uint32
+	next     uint32
+	prev     uint32
+}
+
+// btreeSplit describes the node created by splitting a node.
+type btreeSplit struct {
+	separator []byte
+	pageNum   uint32
+}
+
+// KeyColumnsError is returned when a key does not have as many values as the tree has key columns,
+// or when a bound has more values.
+type KeyColumnsError struct {
+	Expected int
+	Actual   int
+}
+
+// KeyTooLargeError is returned when the key encoding of a key is larger than MaxBTreeKeySize.
+type KeyTooLargeError struct {
+	Size int
+}
+
+// DuplicateKeyError is returned when an entry is inserted into a unique tree that already stores
+// its key, or into any tree that already stores the same key and address.
+type DuplicateKeyError struct {
+	Key []any
+}
+
+// UnsortedEntriesError is returned by BuildBTree when an entry is not ordered after the entry
+// before it.
+type UnsortedEntriesError struct {
+	Index int
+}
+
+// CorruptIndexError is returned when a page of an index cannot be decoded.
+type CorruptIndexError struct {
+	PageNum uint32
+	Reason  string
+}
+
+func (e *KeyColumnsError) Error() string {
+	return fmt.Sprintf("key has %d values, expected %d", e.Actual, e.Expected)
+}
+
+func (e *KeyTooLargeError) Error() string {
+	return fmt.Sprintf("key of %d bytes exceeds maximum of %d bytes", e.Size, MaxBTreeKeySize)
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("duplicate key %v", e.Key)
+}
+
+func (e *UnsortedEntriesError) Error() string {
+	return fmt.Sprintf("entry %d is not ordered after the entry before it", e.Index)
+}
+
+func (e *CorruptIndexError) Error() string {
+	return fmt.Sprintf("corrupt index page %d: %s", e.PageNum, e.Reason)
+}
+
+// NewBTree creates an empty tree with the given options in the given file. The pages of the tree
+// are appended to the file; MetaPageNum returns the page to open the tree from later.
+func NewBTree(file PageFile, options BTreeOptions) (*BTree, error) {
+	t, err := newBTree(file, options)
+	if err != nil {
+		return nil, err
+	}
+	root := &btreeNode{leaf: true, next: noPage, prev: noPage}
+	if root.pageNum, err = t.allocatePage(); err != nil {
+		return nil, err
+	}
+	if err = t.writeNode(root); err != nil {
+		return nil, err
+	}
+	t.root = root.pageNum
+	return t, t.writeMeta()
+}
+
+// newBTree returns a tree with the given options whose meta page is allocated but not written.
+func newBTree(file PageFile, options BTreeOptions) (*BTree, error) {
+	if len(options.Orders) == 0 || len(options.Orders) > MaxBTreeKeyColumns {
+		return nil, fmt.Errorf(
+			"number of key columns must be between 1 and %d, got %d",
+			MaxBTreeKeyColumns, len(options.Orders),
+		)
+	}
+	if len(options.Collation) > math.MaxUint8 {
+		return nil, fmt.Errorf("collation name '%s' is too long", options.Collation)
+	}
+	comparator, err := newComparator(options.Collation, options.NullsLast)
+	if err != nil {
+		return nil, err
+	}
+	t := &BTree{
+		file:       file,
+		orders:     append([]element.SortOrder(nil), options.Orders...),
+		unique:     options.Unique,
+		collation:  options.Collation,
+		comparator: comparator,
+		root:       noPage,
+		freeList:   noPage,
+	}
+	pageNums, err := file.AppendPages(&[]Page{{}})
+	if err != nil {
+		return nil, err
+	}
+	t.metaPageNum = pageNums[0]
+	return t, nil
+}
+
+// newComparator returns the comparator of keys ordered by the collation with the given name, with
+// nulls ordered last if nullsLast is true.
+func newComparator(collation string, nullsLast bool) (element.Comparator, error) {
+	comparator := element.Comparator{NullsLast: nullsLast}
+	if collation != "" {
+		var ok bool
+		if comparator.Collation, ok = element.LookupCollation(collation); !ok {
+			return comparator, fmt.Errorf("unknown collation '%s'", collation)
+		}
+	}
+	return comparator, nil
+}
+
+// OpenBTree opens the tree whose meta page is stored at the given page number of the given file.
+func OpenBTree(file PageFile, metaPageNum uint32) (*BTree, error) {
+	pages, err := file.ReadPages(metaPageNum, 1)
+	if err != nil {
+		return nil, err
+	}
+	page := &(*pages)[0]
+	if page[0] != btreeMetaPage {
+		return nil, &CorruptIndexError{metaPageNum, "not a meta page"}
+	}
+	if page[1] != btreeVersion {
+		return nil, &CorruptIndexError{metaPageNum, fmt.Sprintf("unknown version %d", page[1])}
+	}
+	numColumns := int(page[3])
+	if numColumns == 0 || numColumns > MaxBTreeKeyColumns {
+		return nil, &CorruptIndexError{
+			metaPageNum, fmt.Sprintf("invalid number of key columns %d", numColumns),
+		}
+	}
+	t := &BTree{
+		file:        file,
+		metaPageNum: metaPageNum,
+		orders:      make([]element.SortOrder, numColumns),
+		unique:      page[2]&btreeUniqueFlag != 0,
+		root:        binary.LittleEndian.Uint32(page[4:]),
+		freeList:    binary.LittleEndian.Uint32(page[8:]),
+		numEntries:  binary.LittleEndian.Uint64(page[12:]),
+	}
+	for i := range t.orders {
+		t.orders[i] = element.SortOrder(page[btreeMetaHeaderSize+i])
+		if t.orders[i] != element.Ascending && t.orders[i] != element.Descending {
+			return nil, &CorruptIndexError{
+				metaPageNum, fmt.Sprintf("unknown sort order %d", t.orders[i]),
+			}
+		}
+	}
+	nameStart := btreeMetaCollationOffset + 1
+	t.collation = string(page[nameStart : nameStart+int(page[btreeMetaCollationOffset])])
+	if t.comparator, err = newComparator(t.collation, page[2]&btreeNullsLastFlag != 0); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// MetaPageNum returns the number of the page that the tree can be opened from with OpenBTree.
+func (t *BTree) MetaPageNum() uint32 {
+	return t.metaPageNum
+}
+
+// Len returns the number of entries in the tree.
+func (t *BTree) Len() uint64 {
+	return t.numEntries
+}
+
+// writeMeta writes the meta page of the tree.
+func (t *BTree) writeMeta() error {
+	var page Page
+	page[0] = btreeMetaPage
+	page[1] = btreeVersion
+	if t.unique {
+		page[2] |= btreeUniqueFlag
+	}
+	if t.comparator.NullsLast {
+		page[2] |= btreeNullsLastFlag
+	}
+	page[3] = byte(len(t.orders))
+	binary.LittleEndian.PutUint32(page[4:], t.root)
+	binary.LittleEndian.PutUint32(page[8:], t.freeList)
+	binary.LittleEndian.PutUint64(page[12:], t.numEntries)
+	for i, order := range t.orders {
+		page[btreeMetaHeaderSize+i] = byte(order)
+	}
+	page[btreeMetaCollationOffset] = byte(len(t.collation))
+	copy(page[btreeMetaCollationOffset+1:], t.collation)
+	_, err := t.file.WritePages(&[]Page{page}, t.metaPageNum)
+	return err
+}
+
+// allocatePage returns the number of a page that the tree can use, taken from the free list or
+// appended to the file.
+func (t *BTree) allocatePage() (uint32, error) {
+	return allocateIndexPage(t.file, &t.freeList)
+}
+
+// freePage adds the page with the given number to the free list of the tree.
+func (t *BTree) freePage(pageNum uint32) error {
+	return freeIndexPage(t.file, &t.freeList, pageNum)
+}
+
+// cellSize returns the number of bytes taken by the cell of the key at the given index.
+func (n *btreeNode) cellSize(i int) int {
+	if n.leaf {
+		return 2 + len(n.keys[i])
+	}
+	return 4 + 2 + len(n.keys[i])
+}
+
+// size returns the number of bytes taken by the node on its page.
+func (n *btreeNode) size() int {
+	size := btreeNodeHeaderSize
+	for i := range n.keys {
+		size += n.cellSize(i)
+	}
+	return size
+}
+
+// search returns the index of the first key of the node that is greater than or equal to the given
+// entry, and whether that key is equal to the entry.
+func (n *btreeNode) search(entry []byte) (int, bool) {
+	i := sort.Search(len(n.keys), func(i int) bool { return bytes.Compare(n.keys[i], entry) >= 0 })
+	return i, i < len(n.keys) && bytes.Equal(n.keys[i], entry)
+}
+
+// childIndex returns the index of the child of an internal node that stores the given entry.
+func (n *btreeNode) childIndex(entry []byte) int {
+	return sort.Search(len(n.keys), func(i int) bool { return bytes.Compare(n.keys[i], entry) > 0 })
+}
+
+// encode writes the node to the given page. A PageFullError is returned if the node does not fit
+// on a page.
+func (n *btreeNode) encode(page *Page) error {
+	if size := n.size(); size > PageSize {
+		return &PageFullError{Available: PageSize, Needed: uint32(size)}
+	}
+	*page = Page{}
+	offset := btreeNodeHeaderSize
+	if n.leaf {
+		page[0] = btreeLeafPage
+		binary.LittleEndian.PutUint32(page[3:], n.next)
+		binary.LittleEndian.PutUint32(page[7:], n.prev)
+	} else {
+		page[0] = btreeInternalPage
+		binary.LittleEndian.PutUint32(page[3:], n.children[len(n.keys)])
+	}
+	binary.LittleEndian.PutUint16(page[1:], uint16(len(n.keys)))
+	for i, key := range n.keys {
+		if !n.leaf {
+			binary.LittleEndian.PutUint32(page[offset:], n.children[i])
+			offset += 4
+		}
+		binary.LittleEndian.PutUint16(page[offset:], uint16(len(key)))
+		offset += 2
+		offset += copy(page[offset:], key)
+	}
+	return nil
+}
+
+// decodeNode returns the node stored on the given page.
+func decodeNode(page *Page, pageNum uint32) (*btreeNode, error) {
+	n := &btreeNode{pageNum: pageNum}
+	switch page[0] {
+	case btreeLeafPage:
+		n.leaf = true
+		n.next = binary.LittleEndian.Uint32(page[3:])
+		n.prev = binary.LittleEndian.Uint32(page[7:])
+	case btreeInternalPage:
+	default:
+		return nil, &CorruptIndexError{pageNum, fmt.Sprintf("unknown node type %d", page[0])}
+	}
+	numKeys := int(binary.LittleEndian.Uint16(page[1:]))
+	n.keys = make([][]byte, numKeys)
+	if !n.leaf {
+		n.children = make([]uint32, numKeys+1)
+		n.children[numKeys] = binary.LittleEndian.Uint32(page[3:])
+	}
+	offset := btreeNodeHeaderSize
+	for i := range n.keys {
+		if !n.leaf {
+			if offset+4 > PageSize {
+				return nil, &CorruptIndexError{pageNum, "cells exceed page"}
+			}
+			n.children[i] = binary.LittleEndian.Uint32(page[offset:])
+			offset += 4
+		}
+		if offset+2 > PageSize {
+			return nil, &CorruptIndexError{pageNum, "cells exceed page"}
+		}
+		length := int(binary.LittleEndian.Uint16(page[offset:]))
+		offset += 2
+		if offset+length > PageSize {
+			return nil, &CorruptIndexError{pageNum, "cells exceed page"}
+		}
+		n.keys[i] = append([]byte(nil), page[offset:offset+length]...)
+		offset += length
+		if n.leaf && length <= btreeAddressSize {
+			return nil, &CorruptIndexError{pageNum, fmt.Sprintf("entry %d too short", i)}
+		}
+		if i > 0 && bytes.Compare(n.keys[i-1], n.keys[i]) >= 0 {
+			return nil, &CorruptIndexError{pageNum, fmt.Sprintf("key %d out of order", i)}
+		}
+	}
+	return n, nil
+}
+
+func (t *BTree) readNode(pageNum uint32) (*btreeNode, error) {
+	pages, err := t.file.ReadPages(pageNum, 1)
+	if err != nil {
+		return nil, err
+	}
+	return decodeNode(&(*pages)[0], pageNum)
+}
+
+func (t *BTree) writeNode(n *btreeNode) error {
+	pages := make([]Page, 1)
+	if err := n.encode(&pages[0]); err != nil {
+		return err
+	}
+	_, err := t.file.WritePages(&pages, n.pageNum)
+	return err
+}
+
+// store writes the given node, or splits it if it does not fit on its page, in which case the node
+// holding its upper half is returned.
+func (t *BTree) store(n *btreeNode) (*btreeSplit, error) {
+	if n.size() <= PageSize {
+		return nil, t.writeNode(n)
+	}
+	return t.split(n)
+}
+
+// addChild adds the node created by splitting the child at the given index of the given internal
+// node to the node, and stores the node.
+func (t *BTree) addChild(n *btreeNode, i int, split *btreeSplit) (*btreeSplit, error) {
+	n.keys = append(n.keys[:i], append([][]byte{split.separator}, n.keys[i:]...)...)
+	children := append([]uint32{split.pageNum}, n.children[i+1:]...)
+	n.children = append(n.children[:i+1], children...)
+	return t.store(n)
+}
+
+// growRoot replaces the root of the tree, which has been split, with a new root whose children are
+// the two halves of the old root.
+func (t *BTree) growRoot(split *btreeSplit) error {
+	newRoot := &btreeNode{
+		keys:     [][]byte{split.separator},
+		children: []uint32{t.root, split.pageNum},
+	}
+	var err error
+	if newRoot.pageNum, err = t.allocatePage(); err != nil {
+		return err
+	}
+	if err = t.writeNode(newRoot); err != nil {
+		return err
+	}
+	t.root = newRoot.pageNum
+	return nil
+}
+
+// encodeKey returns the key encoding of the given key values, which must be at most as many as the
+// key columns of the tree.
+func (t *BTree) encodeKey(key []any) ([]byte, error) {
+	if len(key) > len(t.orders) {
+		return nil, &KeyColumnsError{Expected: len(t.orders), Actual: len(key)}
+	}
+	encoded, err := t.comparator.EncodeKeyWithOrder(key, t.orders)
+	if err != nil {
+		return nil, err
+	}
+	if len(encoded) > MaxBTreeKeySize {
+		return nil, &KeyTooLargeError{len(encoded)}
+	}
+	return encoded, nil
+}
+
+// encodeEntry returns the entry for the given key and address. The key must have a value for each
+// key column of the tree.
+func (t *BTree) encodeEntry(key []any, address RecordAddress) ([]byte, error) {
+	if len(key) != len(t.orders) {
+		return nil, &KeyColumnsError{Expected: len(t.orders), Actual: len(key)}
+	}
+	encoded, err := t.encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return appendAddress(encoded, address), nil
+}
+
+// decodeAddress returns the record address stored at the end of the given entry.
+func decodeAddress(entry []byte) RecordAddress {
+	keyEnd := len(entry) - btreeAddressSize
+	return RecordAddress{
+		PageAddress: PageAddress{
+			FileID:  binary.BigEndian.Uint16(entry[keyEnd:]),
+			PageNum: binary.BigEndian.Uint32(entry[keyEnd+2:]),
+		},
+		SlotNum: binary.BigEndian.Uint16(entry[keyEnd+6:]),
+	}
+}
+
+// appendAddress appends the encoding of the given record address to an entry.
+func appendAddress(entry []byte, address RecordAddress) []byte {
+	var b [btreeAddressSize]byte
+	binary.BigEndian.PutUint16(b[0:], address.FileID)
+	binary.BigEndian.PutUint32(b[2:], address.PageNum)
+	binary.BigEndian.PutUint16(b[6:], address.SlotNum)
+	return append(entry, b[:]...)
+}
+
+// decodeEntry returns the key and record address of the given entry.
+func (t *BTree) decodeEntry(entry []byte) (BTreeEntry, error) {
+	keyEnd := len(entry) - btreeAddressSize
+	key, err := element.DecodeKey(entry[:keyEnd], t.orders...)
+	if err != nil {
+		return BTreeEntry{}, err
+	}
+	return BTreeEntry{key, decodeAddress(entry)}, nil
+}
+
+// prefixEnd returns the smallest byte string that is greater than every byte string starting with
+// the given prefix, or nil if there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// Insert adds an entry mapping the given key to the given record address to the tree. The key must
+// have a value for each key column. A DuplicateKeyError is returned if the tree is unique and
+// already stores the key, or if it already stores the same key and address.
+func (t *BTree) Insert(key []any, address RecordAddress) error {
+	entry, err := t.encodeEntry(key, address)
+	if err != nil {
+		return err
+	}
+	if t.unique {
+		addresses, err := t.Get(key)
+		if err != nil {
+			return err
+		}
+		if len(addresses) > 0 {
+			return &DuplicateKeyError{key}
+		}
+	}
+
+	root, err := t.readNode(t.root)
+	if err != nil {
+		return err
+	}
+	split, err := t.insert(root, entry, 0)
+	if _, ok := err.(*DuplicateKeyError); ok {
+		return &DuplicateKeyError{key}
+	}
+	if err != nil {
+		return err
+	}
+	if split != nil {
+		if err = t.growRoot(split); err != nil {
+			return err
+		}
+	}
+	t.numEntries++
+	return t.writeMeta()
+}
+
+// insert adds the given entry to the subtree rooted at the given node. If the node has to be split,
+// the node holding its upper half is returned.
+func (t *BTree) insert(n *btreeNode, entry []byte, depth int) (*btreeSplit, error) {
+	if depth > btreeMaxHeight {
+		return nil, &CorruptIndexError{n.pageNum, "tree too high"}
+	}
+	if n.leaf {
+		i, found := n.search(entry)
+		if found {
+			return nil, &DuplicateKeyError{}
+		}
+		n.keys = append(n.keys[:i], append([][]byte{entry}, n.keys[i:]...)...)
+		return t.store(n)
+	}
+	i := n.childIndex(entry)
+	child, err := t.readNode(n.children[i])
+	if err != nil {
+		return nil, err
+	}
+	split, err := t.insert(child, entry, depth+1)
+	if split == nil || err != nil {
+		return nil, err
+	}
+	return t.addChild(n, i, split)
+}
+
+// splitIndex returns the index of the first key of the upper half of the given node when it is
+// split into two halves of about the same size. Both halves hold at least one key. For an internal
+// node, the key at the index moves up to the parent and is kept in neither half.
+func splitIndex(n *btreeNode) int {
+	maxIndex := len(n.keys) - 1
+	if !n.leaf {
+		maxIndex--
+	}
+	half := n.size() / 2
+	size := btreeNodeHeaderSize
+	i := 0
+	for i < maxIndex && size+n.cellSize(i) < half {
+		size += n.cellSize(i)
+		i++
+	}
+	if i == 0 {
+		return 1
+	}
+	return i
+}
+
+// split moves the upper half of the keys of the given node to a new node.
+func (t *BTree) split(n *btreeNode) (*btreeSplit, error) {
+	pageNum, err := t.allocatePage()
+	if err != nil {
+		return nil, err
+	}
+	right := &btreeNode{pageNum: pageNum, leaf: n.leaf}
+	i := splitIndex(n)
+	split := &btreeSplit{separator: n.keys[i], pageNum: pageNum}
+	if n.leaf {
+		right.keys = append([][]byte(nil), n.keys[i:]...)
+		n.keys = n.keys[:i]
+		right.next, right.prev = n.next, n.pageNum
+		n.next = pageNum
+		if err = t.setPrev(right.next, pageNum); err != nil {
+			return nil, err
+		}
+	} else {
+		right.keys = append([][]byte(nil), n.keys[i+1:]...)
+		right.children = append([]uint32(nil), n.children[i+1:]...)
+		n.keys = n.keys[:i]
+		n.children = n.children[:i+1]
+	}
+	if err = t.writeNode(right); err != nil {
+		return nil, err
+	}
+	return split, t.writeNode(n)
+}
+
+// setPrev sets the page number of the previous leaf of the leaf with the given page number.
+func (t *BTree) setPrev(pageNum uint32, prev uint32) error {
+	if pageNum == noPage {
+		return nil
+	}
+	n, err := t.readNode(pageNum)
+	if err != nil {
+		return err
+	}
+	if !n.leaf {
+		return &CorruptIndexError{pageNum, "leaf sibling is not a leaf"}
+	}
+	n.prev = prev
+	return t.writeNode(n)
+}
+
+// Delete removes the entry mapping the given key to the given record address from the tree. It
+// returns false if the tree has no such entry.
+func (t *BTree) Delete(key []any, address RecordAddress) (bool, error) {
+	entry, err := t.encodeEntry(key, address)
+	if err != nil {
+		return false, err
+	}
+	root, err := t.readNode(t.root)
+	if err != nil {
+		return false, err
+	}
+	found, split, err := t.remove(root, entry, 0)
+	if !found || err != nil {
+		return false, err
+	}
+	if split != nil {
+		if err = t.growRoot(split); err != nil {
+			return false, err
+		}
+	} else if !root.leaf && len(root.keys) == 0 {
+		// A root with a single child is replaced by the child, so the tree shrinks in height.
+		if err = t.freePage(root.pageNum); err != nil {
+			return false, err
+		}
+		t.root = root.children[0]
+	}
+	t.numEntries--
+	return true, t.writeMeta()
+}
+
+// remove removes the given entry from the subtree rooted at the given node. Children left with too
+// few keys are merged with or refilled from a sibling; the node itself is left for its parent to
+// rebalance. Refilling a child can replace the separator before it with a longer one, so the node
+// may have to be split, in which case the node holding its upper half is returned.
+func (t *BTree) remove(n *btreeNode, entry []byte, depth int) (bool, *btreeSplit, error) {
+	if depth > btreeMaxHeight {
+		return false, nil, &CorruptIndexError{n.pageNum, "tree too high"}
+	}
+	if n.leaf {
+		i, found := n.search(entry)
+		if !found {
+			return false, nil, nil
+		}
+		n.keys = append(n.keys[:i], n.keys[i+1:]...)
+		return true, nil, t.writeNode(n)
+	}
+	i := n.childIndex(entry)
+	child, err := t.readNode(n.children[i])
+	if err != nil {
+		return false, nil, err
+	}
+	found, split, err := t.remove(child, entry, depth+1)
+	if !found || err != nil {
+		return found, nil, err
+	}
+	if split != nil {
+		split, err = t.addChild(n, i, split)
+		return true, split, err
+	}
+	if child.size() >= btreeMinNodeSize {
+		return true, nil, nil
+	}
+	split, err = t.rebalance(n, i, child)
+	return true, split, err
+}
+
+// rebalance merges the child at the given index of the given node with a sibling, or moves keys
+// from the sibling to the child if they do not fit on one page together. If the node has to be
+// split because its separator changed, the node holding its upper half is returned.
+func (t *BTree) rebalance(n *btreeNode, i int, child *btreeNode) (*btreeSplit, error) {
+	if len(n.children) < 2 {
+		return nil, nil
+	}
+	var left, right *btreeNode
+	var err error
+	separatorIndex := i
+	if i > 0 {
+		separatorIndex = i - 1
+		if left, err = t.readNode(n.children[i-1]); err != nil {
+			return nil, err
+		}
+		right = child
+	} else {
+		left = child
+		if right, err = t.readNode(n.children[1]); err != nil {
+			return nil, err
+		}
+	}
+	if left.leaf != right.leaf {
+		return nil, &CorruptIndexError{n.pageNum, "children at different heights"}
+	}
+
+	merged := &btreeNode{pageNum: left.pageNum, leaf: left.leaf, next: right.next, prev: left.prev}
+	merged.keys = append(merged.keys, left.keys...)
+	if !left.leaf {
+		merged.keys = append(merged.keys, n.keys[separatorIndex])
+		merged.children = append(append(merged.children, left.children...), right.children...)
+	}
+	merged.keys = append(merged.keys, right.keys...)
+
+	if merged.size() <= PageSize {
+		if merged.leaf {
+			if err = t.setPrev(merged.next, merged.pageNum); err != nil {
+				return nil, err
+			}
+		}
+		if err = t.writeNode(merged); err != nil {
+			return nil, err
+		}
+		if err = t.freePage(right.pageNum); err != nil {
+			return nil, err
+		}
+		n.keys = append(n.keys[:separatorIndex], n.keys[separatorIndex+1:]...)
+		n.children = append(n.children[:separatorIndex+1], n.children[separatorIndex+2:]...)
+		return nil, t.writeNode(n)
+	}
+
+	// The keys do not fit on one page, so split them evenly between the two nodes again. The new
+	// separator can be longer than the old one, so the node may no longer fit on its page.
+	j := splitIndex(merged)
+	left.keys = append([][]byte(nil), merged.keys[:j]...)
+	if merged.leaf {
+		right.keys = append([][]byte(nil), merged.keys[j:]...)
+		n.keys[separatorIndex] = merged.keys[j]
+	} else {
+		right.keys = append([][]byte(nil), merged.keys[j+1:]...)
+		left.children = append([]uint32(nil), merged.children[:j+1]...)
+		right.children = append([]uint32(nil), merged.children[j+1:]...)
+		n.keys[separatorIndex] = merged.keys[j]
+	}
+	if err = t.writeNode(left); err != nil {
+		return nil, err
+	}
+	if err = t.writeNode(right); err != nil {
+		return nil, err
+	}
+	return t.store(n)
+}
+
+// Get returns the addresses of the records stored in the tree for the given key, in the order of
+// the addresses. The key must have a value for each key column.
+func (t *BTree) Get(key []any) ([]RecordAddress, error) {
+	if len(key) != len(t.orders) {
+		return nil, &KeyColumnsError{Expected: len(t.orders), Actual: len(key)}
+	}
+	return t.lookupPrefix(key)
+}
+
+// lookupPrefix returns the addresses of the records stored in the tree for the keys that start
+// with the given values, in the order of the keys and then of the addresses.
+func (t *BTree) lookupPrefix(key []any) ([]RecordAddress, error) {
+	if len(key) == 0 {
+		return nil, &KeyColumnsError{Expected: len(t.orders), Actual: len(key)}
+	}
+	bound := &KeyBound{Key: key}
+	it, err := t.Scan(bound, bound, false)
+	if err != nil {
+		return nil, err
+	}
+	var addresses []RecordAddress
+	for {
+		entry, err := it.Next()
+		if err == io.EOF {
+			return addresses, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, entry.Address)
+	}
+}
+
+// Scan returns an iterator over the entries of the tree whose keys lie between the given bounds, in
+// the order of the keys, or in reverse order if reverse is set. A nil bound leaves the range open
+// on that side. Entries with the same key are ordered by record address.
+//
+// Bounds are compared with keys in the order of the tree, so for a key column in descending order
+// the lower bound is the greater value.
+func (t *BTree) Scan(lower *KeyBound, upper *KeyBound, reverse bool) (*BTreeIterator, error) {
+	it := &BTreeIterator{tree: t, reverse: reverse}
+	if lower != nil {
+		encoded, err := t.encodeKey(lower.Key)
+		if err != nil {
+			return nil, err
+		}
+		it.low = encoded
+		if lower.Exclusive {
+			if it.low = prefixEnd(encoded); it.low == nil {
+				return it.finish(), nil
+			}
+		}
+	}
+	if upper != nil {
+		encoded, err := t.encodeKey(upper.Key)
+		if err != nil {
+			return nil, err
+		}
+		it.high = encoded
+		if !upper.Exclusive {
+			it.high = prefixEnd(encoded)
+		} else if len(encoded) == 0 {
+			return it.finish(), nil
+		}
+	}
+
+	// Descend to the leaf holding the first entry of the range, or the last one when reversed.
+	n, err := t.readNode(t.root)
+	for depth := 0; err == nil && !n.leaf; depth++ {
+		if depth > btreeMaxHeight {
+			return nil, &CorruptIndexError{n.pageNum, "tree too high"}
+		}
+		var i int
+		switch {
+		case !reverse:
+			i = n.childIndex(it.low)
+		case it.high == nil:
+			i = len(n.keys)
+		default:
+			i, _ = n.search(it.high)
+		}
+		n, err = t.readNode(n.children[i])
+	}
+	if err != nil {
+		return nil, err
+	}
+	it.node = n
+	if !reverse {
+		it.index, _ = n.search(it.low)
+	} else if it.high == nil {
+		it.index = len(n.keys) - 1
+	} else {
+		i, _ := n.search(it.high)
+		it.index = i - 1
+	}
+	return it, nil
+}
+
+// finish makes the iterator return io.EOF from now on.
+func (it *BTreeIterator) finish() *BTreeIterator {
+	it.node = &btreeNode{leaf: true, next: noPage, prev: noPage}
+	return it
+}
+
+// Next returns the next entry of the range of the iterator. io.EOF is returned after the last
+// entry.
+func (it *BTreeIterator) Next() (BTreeEntry, error) {
+	for it.index < 0 || it.index >= len(it.node.keys) {
+		pageNum := it.node.next
+		if it.reverse {
+			pageNum = it.node.prev
+		}
+		if pageNum == noPage {
+			it.finish()
+			return BTreeEntry{}, io.EOF
+		}
+		n, err := it.tree.readNode(pageNum)
+		if err != nil {
+			return BTreeEntry{}, err
+		}
+		if !n.leaf {
+			return BTreeEntry{}, &CorruptIndexError{pageNum, "leaf sibling is not a leaf"}
+		}
+		it.node = n
+		it.index = 0
+		if it.reverse {
+			it.index = len(n.keys) - 1
+		}
+	}
+
+	entry := it.node.keys[it.index]
+	if (!it.reverse && it.high != nil && bytes.Compare(entry, it.high) >= 0) ||
+		(it.reverse && bytes.Compare(entry, it.low) < 0) {
+		it.finish()
+		return BTreeEntry{}, io.EOF
+	}
+	if it.reverse {
+		it.index--
+	} else {
+		it.index++
+	}
+	return it.tree.decodeEntry(entry)
+}
+
+// BuildBTree creates a tree with the given options in the given file and fills it with the given
+// entries, which must be sorted in the order of the tree: by key and then by record address, with
+// no key repeated if the tree is unique. The tree is built bottom-up, one level at a time, with
+// nodes filled to about 90% of a page, which is much faster than inserting the entries one by one.
+func BuildBTree(file PageFile, options BTreeOptions, entries []BTreeEntry) (*BTree, error) {
+	if len(entries) == 0 {
+		return NewBTree(file, options)
+	}
+	t, err := newBTree(file, options)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([][]byte, len(entries))
+	for i, entry := range entries {
+		if keys[i], err = t.encodeEntry(entry.Key, entry.Address); err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			continue
+		}
+		previous, current := keys[i-1], keys[i]
+		if bytes.Compare(previous, current) >= 0 {
+			return nil, &UnsortedEntriesError{i}
+		}
+		if t.unique && bytes.Equal(
+			previous[:len(previous)-btreeAddressSize], current[:len(current)-btreeAddressSize],
+		) {
+			return nil, &DuplicateKeyError{entry.Key}
+		}
+	}
+
+	// Pack the entries into leaves and then the leaves into internal nodes, level by level, until
+	// a single node is left, which becomes the root. The lowest entries of all nodes but the first
+	// separate the nodes in their parents.
+	nodes := packNodes(&btreeNode{leaf: true, keys: keys}, nil)
+	for {
+		if err = t.writeLevel(nodes); err != nil {
+			return nil, err
+		}
+		if len(nodes) == 1 {
+			break
+		}
+		parent := &btreeNode{children: make([]uint32, len(nodes)), keys: make([][]byte, 0)}
+		for i, n := range nodes {
+			parent.children[i] = n.pageNum
+			if i > 0 {
+				parent.keys = append(parent.keys, n.low)
+			}
+		}
+		nodes = packNodes(parent, nodes[0].low)
+	}
+	t.root = nodes[0].pageNum
+	t.numEntries = uint64(len(entries))
+	return t, t.writeMeta()
+}
+
+// packedNode is a node built by BuildBTree along with the lowest entry stored under it.
+type packedNode struct {
+	*btreeNode
+	low []byte
+}
+
+// packNodes splits the keys and children of the given node into nodes filled up to
+// btreeBulkFillSize bytes. low is the lowest entry stored under the given node if it is internal.
+func packNodes(n *btreeNode, low []byte) []packedNode {
+	var nodes []packedNode
+	add := func(start, end int) {
+		packed := packedNode{btreeNode: &btreeNode{leaf: n.leaf, keys: n.keys[start:end]}}
+		switch {
+		case n.leaf:
+			packed.low = n.keys[start]
+		case start == 0:
+			packed.low = low
+		default:
+			packed.low = n.keys[start-1]
+		}
+		if !n.leaf {
+			packed.children = n.children[start : end+1]
+		}
+		nodes = append(nodes, packed)
+	}
+
+	start := 0
+	size := btreeNodeHeaderSize
+	for i := range n.keys {
+		cellSize := n.cellSize(i)
+		if size+cellSize <= btreeBulkFillSize || i == start {
+			size += cellSize
+			continue
+		}
+		add(start, i)
+		size = btreeNodeHeaderSize
+		if n.leaf {
+			start = i
+			size += cellSize
+		} else {
+			// The key between the children of two internal nodes moves up to their parent.
+			start = i + 1
+		}
+	}
+	add(start, len(n.keys))
+
+	// The last node can end up nearly empty, so share the keys of the last two nodes evenly.
+	last := len(nodes) - 1
+	if last == 0 || nodes[last].size() >= btreeMinNodeSize {
+		return nodes
+	}
+	combined := &btreeNode{leaf: n.leaf}
+	combined.keys = append(combined.keys, nodes[last-1].keys...)
+	if !n.leaf {
+		combined.keys = append(combined.keys, nodes[last].low)
+		combined.children = append(combined.children, nodes[last-1].children...)
+		combined.children = append(combined.children, nodes[last].children...)
+	}
+	combined.keys = append(combined.keys, nodes[last].keys...)
+	j := splitIndex(combined)
+	nodes[last-1].keys = combined.keys[:j]
+	nodes[last].low = combined.keys[j]
+	if n.leaf {
+		nodes[last].keys = combined.keys[j:]
+	} else {
+		nodes[last].keys = combined.keys[j+1:]
+		nodes[last-1].children = combined.children[:j+1]
+		nodes[last].children = combined.children[j+1:]
+	}
+	return nodes
+}
+
+// writeLevel allocates pages for the given nodes of a level of a tree built by BuildBTree, links
+// leaves to their siblings and writes the nodes.
+func (t *BTree) writeLevel(nodes []packedNode) error {
+	pages := make([]Page, len(nodes))
+	pageNums, err := t.file.AppendPages(&pages)
+	if err != nil {
+		return err
+	}
+	for i, n := range nodes {
+		n.pageNum = pageNums[i]
+	}
+	for i, n := range nodes {
+		n.next, n.prev = noPage, noPage
+		if i > 0 {
+			n.prev = nodes[i-1].pageNum
+		}
+		if i < len(nodes)-1 {
+			n.next = nodes[i+1].pageNum
+		}
+		if err = n.encode(&pages[i]); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < len(pages); {
+		// Write runs of consecutive page numbers at once.
+		j := i + 1
+		for j < len(pages) && pageNums[j] == pageNums[j-1]+1 {
+			j++
+		}
+		run := pages[i:j]
+		if _, err = t.file.WritePages(&run, pageNums[i]); err != nil {
+			return err
+		}
+		i = j
+	}
+	return nil
+}
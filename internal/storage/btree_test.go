@@ -0,0 +1,676 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"kyadb/internal/structs/element"
+)
+
+func testAddress(i int) RecordAddress {
+	return RecordAddress{PageAddress{1, uint32(i / 100)}, uint16(i % 100)}
+}
+
+// checkBTree checks the structure of the tree: keys are ordered within and across nodes, all leaves
+// are at the same depth and linked in order, nodes other than the root are not underfull and the
+// tree holds as many entries as it reports. It returns all entries in order.
+func checkBTree(t *testing.T, tree *BTree) [][]byte {
+	t.Helper()
+	var entries [][]byte
+	var leaves []uint32
+	leafDepth := -1
+	var walk func(pageNum uint32, low, high []byte, depth int)
+	walk = func(pageNum uint32, low, high []byte, depth int) {
+		n, err := tree.readNode(pageNum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pageNum != tree.root && n.size() < btreeMinNodeSize {
+			t.Errorf("page %d: node of %d bytes is underfull", pageNum, n.size())
+		}
+		for _, key := range n.keys {
+			if (low != nil && bytes.Compare(key, low) < 0) ||
+				(high != nil && bytes.Compare(key, high) >= 0) {
+				t.Errorf("page %d: key %x outside [%x, %x)", pageNum, key, low, high)
+			}
+		}
+		if n.leaf {
+			if leafDepth >= 0 && depth != leafDepth {
+				t.Errorf("page %d: leaf at depth %d, expected %d", pageNum, depth, leafDepth)
+			}
+			leafDepth = depth
+			leaves = append(leaves, pageNum)
+			entries = append(entries, n.keys...)
+			return
+		}
+		for i, child := range n.children {
+			childLow, childHigh := low, high
+			if i > 0 {
+				childLow = n.keys[i-1]
+			}
+			if i < len(n.keys) {
+				childHigh = n.keys[i]
+			}
+			walk(child, childLow, childHigh, depth+1)
+		}
+	}
+	walk(tree.root, nil, nil, 0)
+
+	for i, pageNum := range leaves {
+		n, _ := tree.readNode(pageNum)
+		next, prev := noPage, noPage
+		if i > 0 {
+			prev = leaves[i-1]
+		}
+		if i < len(leaves)-1 {
+			next = leaves[i+1]
+		}
+		if n.next != next || n.prev != prev {
+			t.Errorf(
+				"page %d: expected links %d, %d, got %d, %d", pageNum, next, prev, n.next, n.prev,
+			)
+		}
+	}
+	if uint64(len(entries)) != tree.Len() {
+		t.Errorf("expected %d entries, got %d", tree.Len(), len(entries))
+	}
+	return entries
+}
+
+// scanAll returns the entries returned by a scan of the tree with the given bounds.
+func scanAll(t *testing.T, tree *BTree, lower, upper *KeyBound, reverse bool) []BTreeEntry {
+	t.Helper()
+	it, err := tree.Scan(lower, upper, reverse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []BTreeEntry
+	for {
+		entry, err := it.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// scanKeys returns the first key value of each entry returned by a scan of the tree.
+func scanKeys(t *testing.T, tree *BTree, lower, upper *KeyBound, reverse bool) []any {
+	t.Helper()
+	var keys []any
+	for _, entry := range scanAll(t, tree, lower, upper, reverse) {
+		keys = append(keys, entry.Key[0])
+	}
+	return keys
+}
+
+func int64Keys(values ...int64) []any {
+	keys := make([]any, len(values))
+	for i, value := range values {
+		keys[i] = value
+	}
+	return keys
+}
+
+func TestBTree_Insert(t *testing.T) {
+	t.Run(
+		"check random inserts", func(t *testing.T) {
+			file := &memoryFile{}
+			options := BTreeOptions{Orders: []element.SortOrder{element.Ascending}}
+			tree, err := NewBTree(file, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := rand.New(rand.NewSource(1))
+			for i, key := range r.Perm(5000) {
+				if err = tree.Insert([]any{int64(key)}, testAddress(i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			entries := checkBTree(t, tree)
+			if len(entries) != 5000 {
+				t.Fatalf("expected 5000 entries, got %d", len(entries))
+			}
+
+			keys := scanKeys(t, tree, nil, nil, false)
+			for i, key := range keys {
+				if key != int64(i) {
+					t.Fatalf("expected key %d at %d, got %v", i, i, key)
+				}
+			}
+			reversed := scanKeys(t, tree, nil, nil, true)
+			for i, key := range reversed {
+				if key != int64(4999-i) {
+					t.Fatalf("expected key %d at %d, got %v", 4999-i, i, key)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check non-unique keys", func(t *testing.T) {
+			tree, _ := NewBTree(&memoryFile{}, BTreeOptions{Orders: []element.SortOrder{0}})
+			for i := 0; i < 300; i++ {
+				err := tree.Insert([]any{"k" + fmt.Sprint(i%3)}, testAddress(299-i))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			addresses, err := tree.Get([]any{"k1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(addresses) != 100 {
+				t.Fatalf("expected 100 addresses, got %d", len(addresses))
+			}
+			for i := 1; i < len(addresses); i++ {
+				if addresses[i].PageNum*100+uint32(addresses[i].SlotNum) <=
+					addresses[i-1].PageNum*100+uint32(addresses[i-1].SlotNum) {
+					t.Errorf("addresses out of order: %v, %v", addresses[i-1], addresses[i])
+				}
+			}
+			err = tree.Insert([]any{"k1"}, addresses[0])
+			if _, ok := err.(*DuplicateKeyError); !ok {
+				t.Errorf("expected DuplicateKeyError, got %v", err)
+			}
+			checkBTree(t, tree)
+		},
+	)
+
+	t.Run(
+		"check unique keys", func(t *testing.T) {
+			tree, _ := NewBTree(
+				&memoryFile{}, BTreeOptions{Orders: []element.SortOrder{0}, Unique: true},
+			)
+			if err := tree.Insert([]any{int32(1)}, testAddress(1)); err != nil {
+				t.Fatal(err)
+			}
+			err := tree.Insert([]any{int32(1)}, testAddress(2))
+			if _, ok := err.(*DuplicateKeyError); !ok {
+				t.Errorf("expected DuplicateKeyError, got %v", err)
+			}
+			if err = tree.Insert([]any{int32(2)}, testAddress(2)); err != nil {
+				t.Error(err)
+			}
+			if tree.Len() != 2 {
+				t.Errorf("expected 2 entries, got %d", tree.Len())
+			}
+		},
+	)
+
+	t.Run(
+		"check large keys", func(t *testing.T) {
+			tree, _ := NewBTree(&memoryFile{}, BTreeOptions{Orders: []element.SortOrder{0}})
+			r := rand.New(rand.NewSource(2))
+			for i, key := range r.Perm(400) {
+				value := fmt.Sprintf("%04d", key) + strings.Repeat("x", r.Intn(MaxBTreeKeySize-10))
+				if err := tree.Insert([]any{value}, testAddress(i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			checkBTree(t, tree)
+
+			err := tree.Insert([]any{strings.Repeat("x", MaxBTreeKeySize)}, testAddress(0))
+			if _, ok := err.(*KeyTooLargeError); !ok {
+				t.Errorf("expected KeyTooLargeError, got %v", err)
+			}
+		},
+	)
+
+	t.Run(
+		"check key columns", func(t *testing.T) {
+			tree, _ := NewBTree(&memoryFile{}, BTreeOptions{Orders: make([]element.SortOrder, 2)})
+			for _, key := range [][]any{{int64(1)}, {int64(1), int64(2), int64(3)}} {
+				err := tree.Insert(key, testAddress(0))
+				if _, ok := err.(*KeyColumnsError); !ok {
+					t.Errorf("%v: expected KeyColumnsError, got %v", key, err)
+				}
+			}
+			if _, err := NewBTree(&memoryFile{}, BTreeOptions{}); err == nil {
+				t.Error("expected error for tree without key columns")
+			}
+		},
+	)
+}
+
+func TestBTree_Delete(t *testing.T) {
+	t.Run(
+		"check random deletes", func(t *testing.T) {
+			file := &memoryFile{}
+			tree, _ := NewBTree(file, BTreeOptions{Orders: []element.SortOrder{0}})
+			r := rand.New(rand.NewSource(3))
+			for i := 0; i < 3000; i++ {
+				value := fmt.Sprintf("%05d", i) + strings.Repeat("y", r.Intn(200))
+				if err := tree.Insert([]any{value}, testAddress(i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			entries := checkBTree(t, tree)
+			numPages := len(file.pages)
+
+			r.Shuffle(
+				len(entries), func(i, j int) { entries[i], entries[j] = entries[j], entries[i] },
+			)
+			for i, encoded := range entries {
+				entry, err := tree.decodeEntry(encoded)
+				if err != nil {
+					t.Fatal(err)
+				}
+				deleted, err := tree.Delete(entry.Key, entry.Address)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !deleted {
+					t.Fatalf("expected %v to be deleted", entry.Key)
+				}
+				if i%500 == 0 {
+					checkBTree(t, tree)
+				}
+			}
+			if remaining := checkBTree(t, tree); len(remaining) != 0 {
+				t.Errorf("expected empty tree, got %d entries", len(remaining))
+			}
+
+			// Pages freed by merges are reused by later inserts.
+			for i := 0; i < 3000; i++ {
+				value := fmt.Sprintf("%05d", i) + strings.Repeat("y", r.Intn(200))
+				if err := tree.Insert([]any{value}, testAddress(i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(file.pages) > numPages+numPages/4 {
+				t.Errorf("expected about %d pages, got %d", numPages, len(file.pages))
+			}
+		},
+	)
+
+	t.Run(
+		"check random inserts and deletes of large keys", func(t *testing.T) {
+			// Keys of varying length make separators grow when keys move between siblings, which
+			// can leave a parent too full for its page.
+			for seed := int64(1); seed <= 5; seed++ {
+				tree, _ := NewBTree(&memoryFile{}, BTreeOptions{Orders: []element.SortOrder{0}})
+				r := rand.New(rand.NewSource(seed))
+				stored := make(map[string]RecordAddress)
+				var keys []string
+				for i := 0; i < 3000; i++ {
+					if len(keys) > 0 && r.Intn(3) == 0 {
+						j := r.Intn(len(keys))
+						key := keys[j]
+						keys[j] = keys[len(keys)-1]
+						keys = keys[:len(keys)-1]
+						deleted, err := tree.Delete([]any{key}, stored[key])
+						if err != nil || !deleted {
+							t.Fatalf("seed %d, op %d: expected %.8s to be deleted, got %v, %v",
+								seed, i, key, deleted, err)
+						}
+						delete(stored, key)
+						continue
+					}
+					key := fmt.Sprintf("%08d", i) + strings.Repeat("x", r.Intn(MaxBTreeKeySize-20))
+					if err := tree.Insert([]any{key}, testAddress(i)); err != nil {
+						t.Fatalf("seed %d, op %d: %v", seed, i, err)
+					}
+					stored[key] = testAddress(i)
+					keys = append(keys, key)
+				}
+				if n := len(checkBTree(t, tree)); n != len(stored) {
+					t.Fatalf("seed %d: expected %d entries, got %d", seed, len(stored), n)
+				}
+				for key, address := range stored {
+					addresses, err := tree.Get([]any{key})
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !reflect.DeepEqual(addresses, []RecordAddress{address}) {
+						t.Fatalf("%.8s: expected %v, got %v", key, address, addresses)
+					}
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check missing entries", func(t *testing.T) {
+			tree, _ := NewBTree(&memoryFile{}, BTreeOptions{Orders: []element.SortOrder{0}})
+			_ = tree.Insert([]any{int64(1)}, testAddress(1))
+			for _, entry := range []BTreeEntry{
+				{[]any{int64(2)}, testAddress(1)}, {[]any{int64(1)}, testAddress(2)},
+			} {
+				deleted, err := tree.Delete(entry.Key, entry.Address)
+				if err != nil || deleted {
+					t.Errorf("%v: expected nothing to be deleted, got %v, %v", entry, deleted, err)
+				}
+			}
+			if tree.Len() != 1 {
+				t.Errorf("expected 1 entry, got %d", tree.Len())
+			}
+		},
+	)
+}
+
+func TestBTree_Scan(t *testing.T) {
+	tree, _ := NewBTree(&memoryFile{}, BTreeOptions{Orders: []element.SortOrder{0}})
+	for i := int64(0); i < 1000; i += 2 {
+		if err := tree.Insert([]any{i}, testAddress(int(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		lower, upper *KeyBound
+		expected     []any
+	}{
+		{"inclusive", &KeyBound{Key: []any{int64(10)}}, &KeyBound{Key: []any{int64(16)}},
+			int64Keys(10, 12, 14, 16)},
+		{"exclusive", &KeyBound{Key: []any{int64(10)}, Exclusive: true},
+			&KeyBound{Key: []any{int64(16)}, Exclusive: true}, int64Keys(12, 14)},
+		{"between keys", &KeyBound{Key: []any{int64(9)}}, &KeyBound{Key: []any{int64(13)}},
+			int64Keys(10, 12)},
+		{"open lower", nil, &KeyBound{Key: []any{int64(4)}}, int64Keys(0, 2, 4)},
+		{"open upper", &KeyBound{Key: []any{int64(994)}}, nil, int64Keys(994, 996, 998)},
+		{"empty", &KeyBound{Key: []any{int64(11)}}, &KeyBound{Key: []any{int64(11)}}, nil},
+		{"past end", &KeyBound{Key: []any{int64(1000)}}, nil, nil},
+		{"before start", nil, &KeyBound{Key: []any{int64(0)}, Exclusive: true}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(
+			"check "+tt.name, func(t *testing.T) {
+				keys := scanKeys(t, tree, tt.lower, tt.upper, false)
+				if !reflect.DeepEqual(keys, tt.expected) {
+					t.Errorf("expected %v, got %v", tt.expected, keys)
+				}
+				keys = scanKeys(t, tree, tt.lower, tt.upper, true)
+				for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+					keys[i], keys[j] = keys[j], keys[i]
+				}
+				if !reflect.DeepEqual(keys, tt.expected) {
+					t.Errorf("reversed: expected %v, got %v", tt.expected, keys)
+				}
+			},
+		)
+	}
+
+	t.Run(
+		"check composite keys and prefixes", func(t *testing.T) {
+			tree, _ := NewBTree(
+				&memoryFile{},
+				BTreeOptions{Orders: []element.SortOrder{element.Ascending, element.Descending}},
+			)
+			for _, name := range []string{"b", "a", "c"} {
+				for i := int32(0); i < 3; i++ {
+					if err := tree.Insert([]any{name, i}, testAddress(int(i))); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			var got [][]any
+			for _, entry := range scanAll(t, tree, &KeyBound{Key: []any{"b"}}, nil, false) {
+				got = append(got, entry.Key)
+			}
+			expected := [][]any{
+				{"b", int32(2)}, {"b", int32(1)}, {"b", int32(0)},
+				{"c", int32(2)}, {"c", int32(1)}, {"c", int32(0)},
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("expected %v, got %v", expected, got)
+			}
+
+			entries := scanAll(
+				t, tree, &KeyBound{Key: []any{"a"}, Exclusive: true},
+				&KeyBound{Key: []any{"b", int32(1)}}, true,
+			)
+			got = nil
+			for _, entry := range entries {
+				got = append(got, entry.Key)
+			}
+			expected = [][]any{{"b", int32(1)}, {"b", int32(2)}}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("expected %v, got %v", expected, got)
+			}
+
+			_, err := tree.Scan(&KeyBound{Key: []any{"a", int32(1), int32(2)}}, nil, false)
+			if _, ok := err.(*KeyColumnsError); !ok {
+				t.Errorf("expected KeyColumnsError, got %v", err)
+			}
+		},
+	)
+
+	t.Run(
+		"check collation and null order", func(t *testing.T) {
+			file := &memoryFile{}
+			options := BTreeOptions{
+				Orders: []element.SortOrder{element.Ascending}, Unique: true, Collation: "nocase",
+				NullsLast: true,
+			}
+			tree, err := NewBTree(file, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, key := range []any{"b", nil, "A", "c"} {
+				if err = tree.Insert([]any{key}, testAddress(i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err = tree.Insert([]any{"B"}, testAddress(4))
+			if _, ok := err.(*DuplicateKeyError); !ok {
+				t.Errorf("expected DuplicateKeyError, got %v", err)
+			}
+			if addresses, _ := tree.Get([]any{"C"}); len(addresses) != 1 ||
+				addresses[0] != testAddress(3) {
+				t.Errorf("expected address of c, got %v", addresses)
+			}
+
+			reopened, err := OpenBTree(file, tree.MetaPageNum())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reopened.collation != "nocase" || !reopened.comparator.NullsLast {
+				t.Errorf("expected options %v, got %v", options, reopened.comparator)
+			}
+			keys := scanKeys(t, reopened, &KeyBound{Key: []any{"B"}}, nil, false)
+			if !reflect.DeepEqual(keys, []any{"B", "C", nil}) {
+				t.Errorf("unexpected keys %v", keys)
+			}
+
+			_, err = NewBTree(file, BTreeOptions{Orders: options.Orders, Collation: "unknown"})
+			if err == nil {
+				t.Error("expected error for unknown collation")
+			}
+		},
+	)
+}
+
+func TestBuildBTree(t *testing.T) {
+	t.Run(
+		"check bulk build matches inserts", func(t *testing.T) {
+			options := BTreeOptions{Orders: []element.SortOrder{0}}
+			var entries []BTreeEntry
+			for i := 0; i < 20000; i++ {
+				entries = append(entries, BTreeEntry{[]any{int64(i / 3)}, testAddress(i)})
+			}
+			built, err := BuildBTree(&memoryFile{}, options, entries)
+			if err != nil {
+				t.Fatal(err)
+			}
+			builtEntries := checkBTree(t, built)
+
+			inserted, _ := NewBTree(&memoryFile{}, options)
+			for _, entry := range entries {
+				if err = inserted.Insert(entry.Key, entry.Address); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !reflect.DeepEqual(builtEntries, checkBTree(t, inserted)) {
+				t.Error("expected built and inserted trees to hold the same entries")
+			}
+
+			addresses, err := built.Get([]any{int64(100)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := []RecordAddress{testAddress(300), testAddress(301), testAddress(302)}
+			if !reflect.DeepEqual(addresses, expected) {
+				t.Errorf("expected %v, got %v", expected, addresses)
+			}
+
+			// The built tree can be modified like any other.
+			for i := 0; i < 20000; i += 2 {
+				if _, err = built.Delete(entries[i].Key, entries[i].Address); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(checkBTree(t, built)) != 10000 {
+				t.Errorf("expected 10000 entries, got %d", built.Len())
+			}
+		},
+	)
+
+	t.Run(
+		"check large keys", func(t *testing.T) {
+			var entries []BTreeEntry
+			for i := 0; i < 500; i++ {
+				key := fmt.Sprintf("%04d", i) + strings.Repeat("z", (i*37)%MaxBTreeKeySize/2)
+				entries = append(entries, BTreeEntry{[]any{key}, testAddress(i)})
+			}
+			tree, err := BuildBTree(
+				&memoryFile{}, BTreeOptions{Orders: []element.SortOrder{0}}, entries,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkBTree(t, tree)
+		},
+	)
+
+	t.Run(
+		"check invalid input", func(t *testing.T) {
+			options := BTreeOptions{Orders: []element.SortOrder{0}}
+			unsorted := []BTreeEntry{
+				{[]any{int64(1)}, testAddress(0)}, {[]any{int64(0)}, testAddress(1)},
+			}
+			_, err := BuildBTree(&memoryFile{}, options, unsorted)
+			if e, ok := err.(*UnsortedEntriesError); !ok || e.Index != 1 {
+				t.Errorf("expected UnsortedEntriesError, got %v", err)
+			}
+
+			duplicate := []BTreeEntry{
+				{[]any{int64(1)}, testAddress(0)}, {[]any{int64(1)}, testAddress(1)},
+			}
+			if _, err = BuildBTree(&memoryFile{}, options, duplicate); err != nil {
+				t.Errorf("expected duplicate keys in non-unique tree, got %v", err)
+			}
+			options.Unique = true
+			_, err = BuildBTree(&memoryFile{}, options, duplicate)
+			if _, ok := err.(*DuplicateKeyError); !ok {
+				t.Errorf("expected DuplicateKeyError, got %v", err)
+			}
+		},
+	)
+
+	t.Run(
+		"check empty input", func(t *testing.T) {
+			options := BTreeOptions{Orders: []element.SortOrder{0}}
+			tree, err := BuildBTree(&memoryFile{}, options, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keys := scanKeys(t, tree, nil, nil, false); len(keys) != 0 {
+				t.Errorf("expected no keys, got %v", keys)
+			}
+		},
+	)
+}
+
+func TestOpenBTree(t *testing.T) {
+	t.Run(
+		"check reopened tree", func(t *testing.T) {
+			file := &memoryFile{}
+			options := BTreeOptions{Orders: []element.SortOrder{element.Descending}, Unique: true}
+			tree, _ := NewBTree(file, options)
+			for i := 0; i < 2000; i++ {
+				if err := tree.Insert([]any{float64(i)}, testAddress(i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i := 0; i < 2000; i += 3 {
+				if _, err := tree.Delete([]any{float64(i)}, testAddress(i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			before := checkBTree(t, tree)
+
+			reopened, err := OpenBTree(file, tree.MetaPageNum())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(reopened.orders, options.Orders) || !reopened.unique {
+				t.Errorf(
+					"expected options %v, got %v, %v", options, reopened.orders, reopened.unique,
+				)
+			}
+			if !reflect.DeepEqual(checkBTree(t, reopened), before) {
+				t.Error("expected reopened tree to hold the same entries")
+			}
+			err = reopened.Insert([]any{float64(1)}, testAddress(5000))
+			if _, ok := err.(*DuplicateKeyError); !ok {
+				t.Errorf("expected DuplicateKeyError, got %v", err)
+			}
+			if err = reopened.Insert([]any{float64(3)}, testAddress(3)); err != nil {
+				t.Error(err)
+			}
+			keys := scanKeys(t, reopened, nil, &KeyBound{Key: []any{float64(1995)}}, false)
+			if !reflect.DeepEqual(keys, []any{float64(1999), float64(1997), float64(1996)}) {
+				t.Errorf("unexpected keys %v", keys)
+			}
+		},
+	)
+
+	t.Run(
+		"check corrupt pages", func(t *testing.T) {
+			file := &memoryFile{}
+			tree, _ := NewBTree(file, BTreeOptions{Orders: []element.SortOrder{0}})
+			_ = tree.Insert([]any{int64(1)}, testAddress(1))
+			_ = tree.Insert([]any{int64(2)}, testAddress(2))
+
+			if _, err := OpenBTree(file, tree.root); err == nil {
+				t.Error("expected error for opening a node page")
+			}
+			root := &file.pages[tree.root]
+			saved := append([]byte(nil), root[:]...)
+			root[btreeNodeHeaderSize+2] ^= 0xff
+			_, err := tree.Get([]any{int64(1)})
+			if _, ok := err.(*CorruptIndexError); !ok {
+				t.Errorf("expected CorruptIndexError, got %v", err)
+			}
+			copy(root[:], saved)
+			root[1] = 0xff
+			_, err = tree.Get([]any{int64(1)})
+			if _, ok := err.(*CorruptIndexError); !ok {
+				t.Errorf("expected CorruptIndexError, got %v", err)
+			}
+		},
+	)
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for _, tt := range []struct{ prefix, expected []byte }{
+		{[]byte{1, 2}, []byte{1, 3}},
+		{[]byte{1, 0xff}, []byte{2}},
+		{[]byte{0xff, 0xff}, nil},
+		{nil, nil},
+	} {
+		if got := prefixEnd(tt.prefix); !bytes.Equal(got, tt.expected) {
+			t.Errorf("%x: expected %x, got %x", tt.prefix, tt.expected, got)
+		}
+	}
+}
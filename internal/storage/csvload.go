@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+
+	"kyadb/internal/structs/element"
+)
+
+/*
+ * The CSV loader bulk loads rows into a database file without going through the page cache. Rows
+ * are converted to records and packed densely into fresh table pages in memory, which are appended
+ * to the file in batches. Rows that cannot be converted are reported and skipped, so a few bad rows
+ * do not abort a large load.
+ *
+ * Each CSV field holds the value of the schema field in the same column. Empty fields are stored as
+ * nulls. Arrays and maps are written as JSON, and all other values are written as text in the
+ * format used for them in JSON, without quotes.
+ */
+
+// DefaultCSVBatchSize is the default number of pages appended to the file at once.
+const DefaultCSVBatchSize = 256
+
+// PageAppender is implemented by files that pages can be appended to, such as DatabaseFile.
+type PageAppender interface {
+	AppendPages(pages *[]Page) ([]uint32, error)
+}
+
+// CSVLoader loads rows of CSV data into table pages.
+type CSVLoader struct {
+	schema   Schema
+	encoding element.Encoding
+
+	// Header is true if the first row of the data names the schema field of each column. Columns
+	// can then be in any order, and schema fields without a column are left null.
+	Header bool
+
+	// BatchSize is the number of pages appended to the file at once.
+	BatchSize int
+}
+
+// CSVRowError is returned for a row of CSV data that cannot be converted to a record.
+type CSVRowError struct {
+	Line int
+	Err  error
+}
+
+// CSVLoadResult describes the outcome of a CSV load.
+type CSVLoadResult struct {
+	// NumRows is the number of data rows read, including rows that could not be loaded.
+	NumRows int
+
+	// NumLoaded is the number of rows stored on appended pages.
+	NumLoaded int
+
+	// PageNums are the page numbers of the appended pages, in order.
+	PageNums []uint32
+
+	// RowErrors describe the rows that could not be loaded.
+	RowErrors []*CSVRowError
+}
+
+func (e *CSVRowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *CSVRowError) Unwrap() error {
+	return e.Err
+}
+
+// NewCSVLoader returns a CSVLoader that loads rows described by the given schema, storing their
+// elements in the given encoding.
+func NewCSVLoader(schema Schema, encoding element.Encoding) *CSVLoader {
+	return &CSVLoader{schema: schema, encoding: encoding, BatchSize: DefaultCSVBatchSize}
+}
+
+// columnPositions returns the element positions of the columns named by the given header row.
+func (l *CSVLoader) columnPositions(header []string) ([]ElementPosition, error) {
+	positions := make([]ElementPosition, len(header))
+	for i, name := range header {
+		position, err := l.schema.position(name)
+		if err != nil {
+			return nil, err
+		}
+		positions[i] = position
+	}
+	return positions, nil
+}
+
+// csvValue converts a CSV field to a value of the given value type. Empty fields are null.
+func csvValue(field string, valueType *ValueType) (any, error) {
+	if field == "" {
+		return nil, nil
+	}
+	switch valueType.Type {
+	case element.ArrayType, element.MapType, element.AnyType:
+		value, err := parseJSON([]byte(field))
+		if err != nil {
+			return nil, err
+		}
+		return convertJSONValue(value, valueType)
+	}
+	return parseJSONText(field, valueType)
+}
+
+// recordFromRow converts a row of CSV fields to a record.
+func (l *CSVLoader) recordFromRow(row []string, positions []ElementPosition) (*Record, error) {
+	if len(row) != len(positions) {
+		return nil, fmt.Errorf("expected %d fields, got %d", len(positions), len(row))
+	}
+	r := NewRecordWithEncoding(uint16(len(l.schema)), l.encoding)
+	for i, field := range row {
+		position := positions[i]
+		value, err := csvValue(field, &l.schema[position].ValueType)
+		if err != nil {
+			return nil, err
+		}
+		if err = r.setValue(position, value); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Load reads all rows of CSV data from r and appends them to the file on densely packed table
+// pages. Rows that cannot be converted to a record, or whose record does not fit on an empty page,
+// are skipped and reported in the RowErrors of the result.
+//
+// An error is returned if the data cannot be read or pages cannot be appended. The result then
+// describes the rows loaded before the failure; rows on pages that were not appended are not
+// counted as loaded.
+func (l *CSVLoader) Load(r io.Reader, file PageAppender) (*CSVLoadResult, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	result := &CSVLoadResult{}
+
+	positions := make([]ElementPosition, len(l.schema))
+	for i := range positions {
+		positions[i] = ElementPosition(i)
+	}
+	if l.Header {
+		header, err := reader.Read()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		if positions, err = l.columnPositions(header); err != nil {
+			return result, &CSVRowError{1, err}
+		}
+	}
+
+	batchSize := l.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultCSVBatchSize
+	}
+	batch := make([]Page, 0, batchSize)
+	var numPending int
+	flush := func() error {
+		pageNums, err := file.AppendPages(&batch)
+		result.PageNums = append(result.PageNums, pageNums...)
+		if err != nil {
+			return err
+		}
+		result.NumLoaded += numPending
+		batch, numPending = batch[:0], 0
+		return nil
+	}
+
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			result.NumRows++
+			result.RowErrors = append(result.RowErrors, &CSVRowError{parseErr.Line, parseErr.Err})
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		result.NumRows++
+		line, _ := reader.FieldPos(0)
+
+		record, err := l.recordFromRow(row, positions)
+		if err != nil {
+			result.RowErrors = append(result.RowErrors, &CSVRowError{line, err})
+			continue
+		}
+
+		// Add the record to the last page of the batch, starting a new page if it is full.
+		if len(batch) > 0 {
+			if _, err = batch[len(batch)-1].AddRecord(record); err == nil {
+				numPending++
+				continue
+			}
+		}
+		if len(batch) == batchSize {
+			if err = flush(); err != nil {
+				return result, err
+			}
+		}
+		batch = append(batch, *NewTablePage())
+		if _, err = batch[len(batch)-1].AddRecord(record); err != nil {
+			batch = batch[:len(batch)-1]
+			result.RowErrors = append(result.RowErrors, &CSVRowError{line, err})
+			continue
+		}
+		numPending++
+	}
+
+	if len(batch) > 0 {
+		if err := flush(); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
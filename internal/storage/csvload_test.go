@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"kyadb/internal/structs/element"
+)
+
+// records returns the records stored on all pages of the file in order.
+func (f *memoryFile) records(t *testing.T) []*Record {
+	var records []*Record
+	for i := range f.pages {
+		page := &f.pages[i]
+		for slotNum := uint16(0); slotNum < page.getNumSlots(); slotNum++ {
+			r, _, err := page.GetRecord(slotNum)
+			if err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+func csvTestSchema() Schema {
+	return Schema{
+		{"id", ValueType{Type: element.Int64Type}},
+		{"name", ValueType{Type: element.StringType}},
+		{"active", ValueType{Type: element.BoolType}},
+		{"tags", ValueType{Type: element.ArrayType, Elem: &ValueType{Type: element.StringType}}},
+	}
+}
+
+func TestCSVLoader_Load(t *testing.T) {
+	t.Run(
+		"check rows are loaded", func(t *testing.T) {
+			data := "1,alice,true,\"[\"\"a\"\",\"\"b\"\"]\"\n2,,false,\n"
+			file := &memoryFile{}
+			loader := NewCSVLoader(csvTestSchema(), element.CompactEncoding)
+			result, err := loader.Load(strings.NewReader(data), file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.NumRows != 2 || result.NumLoaded != 2 || len(result.RowErrors) != 0 {
+				t.Errorf("unexpected result %+v", result)
+			}
+			if !reflect.DeepEqual(result.PageNums, []uint32{0}) {
+				t.Errorf("expected page numbers [0], got %v", result.PageNums)
+			}
+
+			records := file.records(t)
+			if len(records) != 2 {
+				t.Fatalf("expected 2 records, got %d", len(records))
+			}
+			_, id, _ := records[1].GetInt64(0)
+			if id != 2 {
+				t.Errorf("expected 2, got %v", id)
+			}
+			isNull, _, _ := records[1].GetString(1)
+			if !isNull {
+				t.Error("expected null name")
+			}
+			_, tags, err := records[0].GetArray(3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedTags := element.Array{ElementType: element.StringType, Values: []any{"a", "b"}}
+			if !reflect.DeepEqual(tags, expectedTags) {
+				t.Errorf("expected %v, got %v", expectedTags, tags)
+			}
+			if records[0].Encoding() != element.CompactEncoding {
+				t.Errorf("expected compact encoding, got %v", records[0].Encoding())
+			}
+		},
+	)
+
+	t.Run(
+		"check header selects columns", func(t *testing.T) {
+			data := "active,id\ntrue,7\n"
+			file := &memoryFile{}
+			loader := NewCSVLoader(csvTestSchema(), element.WideEncoding)
+			loader.Header = true
+			if _, err := loader.Load(strings.NewReader(data), file); err != nil {
+				t.Fatal(err)
+			}
+			records := file.records(t)
+			_, id, _ := records[0].GetInt64(0)
+			_, active, _ := records[0].GetBool(2)
+			if id != 7 || !active {
+				t.Errorf("expected 7 and true, got %v and %v", id, active)
+			}
+
+			loader.Header = false
+			_, err := loader.Load(strings.NewReader("1,a,true,\n"), file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			loader.Header = true
+			_, err = loader.Load(strings.NewReader("id,unknown\n1,2\n"), file)
+			var unknownErr *UnknownFieldError
+			if !errors.As(err, &unknownErr) {
+				t.Errorf("expected UnknownFieldError, got %v", err)
+			}
+		},
+	)
+
+	t.Run(
+		"check pages are packed densely and appended in batches", func(t *testing.T) {
+			var data strings.Builder
+			for i := 0; i < 2000; i++ {
+				data.WriteString("1,name,true,\n")
+			}
+			file := &memoryFile{}
+			loader := NewCSVLoader(csvTestSchema(), element.WideEncoding)
+			loader.BatchSize = 2
+			result, err := loader.Load(strings.NewReader(data.String()), file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.NumLoaded != 2000 || len(file.records(t)) != 2000 {
+				t.Errorf("expected 2000 records, got %d", result.NumLoaded)
+			}
+			for i := 0; i < len(file.pages)-1; i++ {
+				page := &file.pages[i]
+				free := int(page.getFreeOffset()) - (4 + 8*int(page.getNumSlots()))
+				r, _, _ := page.GetRecord(0)
+				if free >= 8+int(r.Length()) {
+					t.Errorf("page %d has room for another record: %d bytes free", i, free)
+				}
+			}
+			for i, size := range file.batches {
+				if size > 2 || (size < 2 && i < len(file.batches)-1) {
+					t.Errorf("unexpected batch sizes %v", file.batches)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check row errors do not abort the load", func(t *testing.T) {
+			data := "1,a,true,\nx,b,true,\n2,c\n3,\"d,true,\n"
+			file := &memoryFile{}
+			loader := NewCSVLoader(csvTestSchema(), element.WideEncoding)
+			result, err := loader.Load(strings.NewReader(data), file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.NumRows != 4 || result.NumLoaded != 1 {
+				t.Errorf("unexpected result %+v", result)
+			}
+			var lines []int
+			for _, rowErr := range result.RowErrors {
+				lines = append(lines, rowErr.Line)
+			}
+			if !reflect.DeepEqual(lines, []int{2, 3, 4}) {
+				t.Errorf("expected errors on lines [2 3 4], got %v", lines)
+			}
+			var valueErr *InvalidJSONValueError
+			if !errors.As(result.RowErrors[0], &valueErr) {
+				t.Errorf("expected InvalidJSONValueError, got %v", result.RowErrors[0])
+			}
+		},
+	)
+
+	t.Run(
+		"check record too large for a page", func(t *testing.T) {
+			data := "1," + strings.Repeat("a", PageSize) + ",true,\n2,b,false,\n"
+			file := &memoryFile{}
+			loader := NewCSVLoader(csvTestSchema(), element.WideEncoding)
+			result, err := loader.Load(strings.NewReader(data), file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.NumLoaded != 1 || len(result.RowErrors) != 1 {
+				t.Fatalf("unexpected result %+v", result)
+			}
+			var fullErr *PageFullError
+			if !errors.As(result.RowErrors[0], &fullErr) {
+				t.Errorf("expected PageFullError, got %v", result.RowErrors[0])
+			}
+		},
+	)
+
+	t.Run(
+		"check file full", func(t *testing.T) {
+			var data strings.Builder
+			for i := 0; i < 1000; i++ {
+				data.WriteString("1,name,true,\n")
+			}
+			file := &memoryFile{maxPages: 1}
+			loader := NewCSVLoader(csvTestSchema(), element.WideEncoding)
+			loader.BatchSize = 1
+			result, err := loader.Load(strings.NewReader(data.String()), file)
+			if _, ok := err.(*FileFullError); !ok {
+				t.Fatalf("expected FileFullError, got %v", err)
+			}
+			if result.NumLoaded != len(file.records(t)) {
+				t.Errorf("expected %d loaded, got %d", len(file.records(t)), result.NumLoaded)
+			}
+		},
+	)
+}
@@ -34,6 +34,14 @@ type DatabaseFile struct {
 
 type FileFullError struct{}
 
+// PageFile is implemented by files whose pages can be read, overwritten and appended to, such as
+// DatabaseFile. Indexes store their pages in a PageFile.
+type PageFile interface {
+	PageAppender
+	ReadPages(pageNum uint32, numPages uint32) (*[]Page, error)
+	WritePages(pages *[]Page, pageNum uint32) (uint32, error)
+}
+
 func (e *FileFullError) Error() string {
 	return fmt.Sprintf("file is full, maximum number of pages allowed: %d", MaxPagesPerFile)
 }
@@ -112,12 +120,18 @@ func DeleteDatabaseFile(fileID uint16) error {
 	return os.Remove(dbFilePath)
 }
 
-// MakeDurable commits the current contents of the file to stable storage.
-func (dbFile *DatabaseFile) MakeDurable() error {
+// writeHeader writes the file's ID and number of pages to the header of the file.
+func (dbFile *DatabaseFile) writeHeader() error {
 	var header = make([]byte, 6)
 	element.WriteUint16(&header, 0, dbFile.FileId)
 	element.WriteUint32(&header, 2, dbFile.NumPages)
-	if _, err := dbFile.file.WriteAt(header, 0); err != nil {
+	_, err := dbFile.file.WriteAt(header, 0)
+	return err
+}
+
+// MakeDurable commits the current contents of the file to stable storage.
+func (dbFile *DatabaseFile) MakeDurable() error {
+	if err := dbFile.writeHeader(); err != nil {
 		return err
 	}
 	if err := dbFile.file.Sync(); err != nil {
@@ -128,23 +142,31 @@ func (dbFile *DatabaseFile) MakeDurable() error {
 
 // AppendPages adds new pages to the end of the file. It returns an array of page numbers of the
 // newly added pages. An error will be returned on the first failure. In case of failure, the
-// returned array will contain the page numbers of the pages that were successfully added.
+// returned array will contain the page numbers of the pages that were successfully added. The
+// number of pages in the file header is updated for the added pages, so that they are found when
+// the file is opened again.
 func (dbFile *DatabaseFile) AppendPages(pages *[]Page) ([]uint32, error) {
 	var pageNumbers []uint32
-	if dbFile.NumPages == MaxPagesPerFile {
-		return pageNumbers, &FileFullError{}
-	}
-
-	offset := 6 + dbFile.NumPages*PageSize
-	for i, page := range *pages {
-		if _, err := dbFile.file.WriteAt(page[:], int64(offset)); err != nil {
-			return pageNumbers, err
+	var err error
+	offset := int64(6) + int64(dbFile.NumPages)*PageSize
+	for _, page := range *pages {
+		if dbFile.NumPages == MaxPagesPerFile {
+			err = &FileFullError{}
+			break
 		}
-		pageNumbers = append(pageNumbers, dbFile.NumPages+uint32(i))
+		if _, err = dbFile.file.WriteAt(page[:], offset); err != nil {
+			break
+		}
+		pageNumbers = append(pageNumbers, dbFile.NumPages)
+		dbFile.NumPages++
 		offset += PageSize
 	}
-	dbFile.NumPages++
-	return pageNumbers, nil
+	if len(pageNumbers) > 0 {
+		if headerErr := dbFile.writeHeader(); err == nil {
+			err = headerErr
+		}
+	}
+	return pageNumbers, err
 }
 
 // WritePages writes pages starting from the given page number in the file. It returns a number of
@@ -196,6 +196,42 @@ func TestDatabaseFile_AppendPages(t *testing.T) {
 			}
 		},
 	)
+
+	t.Run(
+		"check page count survives reopening", func(t *testing.T) {
+			dbFile, err := NewDatabaseFile(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = dbFile.AppendPages(&[]Page{*NewTablePage(), *NewTablePage()})
+			if err != nil {
+				t.Error(err)
+			}
+			err = dbFile.file.Close()
+			if err != nil {
+				t.Error(err)
+			}
+
+			dbFile, err = OpenDatabaseFile(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func(file *os.File) {
+				err := file.Close()
+				if err != nil {
+					t.Error(err)
+				}
+				err = os.Remove(file.Name())
+				if err != nil {
+					t.Error(err)
+				}
+			}(dbFile.file)
+
+			if dbFile.NumPages != 2 {
+				t.Errorf("got %d pages, want 2", dbFile.NumPages)
+			}
+		},
+	)
 }
 
 func TestDatabaseFile_WritePage(t *testing.T) {
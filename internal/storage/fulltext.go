@@ -0,0 +1,695 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"kyadb/internal/structs/element"
+)
+
+/*
+ * A FullTextIndex is an inverted index of the terms of texts, stored in the pages of a PageFile.
+ * It maps each term that its Analyzer produces from the text of a record to a posting: the number
+ * of terms of the text and the positions of the term in the text.
+ *
+ * Postings are stored in a BTree whose keys have two columns: the term and a byte string holding
+ * the posting, so that all postings of a term are stored next to each other and are read with one
+ * range scan. A posting starts with 4 bytes storing the index of its chunk big-endian. To keep
+ * keys within MaxBTreeKeySize, the positions of a term are split into chunks of at most
+ * fullTextChunkPositions positions, each stored in its own entry. The first chunk stores the number
+ * of terms of the text and the number of positions of the term, each as an unsigned varint, and
+ * every chunk then stores the differences between consecutive positions as unsigned varints. The
+ * chunk index makes the chunks of the postings of a term sort by chunk, so the chunks of a record
+ * are read in order.
+ *
+ * The meta page of an index starts with a byte storing fullTextMetaPage, followed by a byte
+ * storing the version of the format, 2 unused bytes, 4 bytes storing the page number of the meta
+ * page of the postings tree, 8 bytes storing the number of indexed records and 8 bytes storing
+ * their total number of terms, all little-endian.
+ *
+ * Records are ranked by their Okapi BM25 score, summed over the terms of the query that they
+ * match.
+ */
+
+const (
+	fullTextMetaPage byte = 'T'
+
+	fullTextVersion = 1
+
+	// fullTextChunkPositions is the maximum number of positions stored in a postings entry.
+	fullTextChunkPositions = 128
+
+	// bm25K1 and bm25B are the BM25 parameters controlling how the score saturates with the
+	// frequency of a term and how much it is normalized by the length of the text.
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// FullTextIndex is an inverted index that maps the terms of texts to the records they belong to,
+// stored in the pages of a PageFile, and ranks records matching keyword and phrase queries. An
+// index is not safe for concurrent use.
+type FullTextIndex struct {
+	analyzer    *Analyzer
+	file        PageFile
+	metaPageNum uint32
+	postings    *BTree
+	numRecords  uint64
+	numTerms    uint64
+}
+
+// TextMatch is a record matched by a query of a FullTextIndex, with its BM25 score.
+type TextMatch struct {
+	Address RecordAddress
+	Score   float64
+}
+
+// textPosting is the posting of a term for a record, decoded from its entries.
+type textPosting struct {
+	numTerms     uint64
+	numPositions uint64
+	numChunks    uint32
+	positions    []uint32
+}
+
+// QuerySyntaxError is returned when a query passed to ParseTextQuery is malformed.
+type QuerySyntaxError struct {
+	Query  string
+	Offset int
+	Reason string
+}
+
+func (e *QuerySyntaxError) Error() string {
+	return fmt.Sprintf("invalid query %q at offset %d: %s", e.Query, e.Offset, e.Reason)
+}
+
+// NewFullTextIndex creates an empty index in the given file whose terms are produced by the given
+// analyzer. The pages of the index are appended to the file; MetaPageNum returns the page to open
+// the index from later.
+func NewFullTextIndex(file PageFile, analyzer *Analyzer) (*FullTextIndex, error) {
+	pageNums, err := file.AppendPages(&[]Page{{}})
+	if err != nil {
+		return nil, err
+	}
+	postings, err := NewBTree(file, BTreeOptions{Orders: make([]element.SortOrder, 2)})
+	if err != nil {
+		return nil, err
+	}
+	x := &FullTextIndex{
+		analyzer:    analyzer,
+		file:        file,
+		metaPageNum: pageNums[0],
+		postings:    postings,
+	}
+	return x, x.writeMeta()
+}
+
+// OpenFullTextIndex opens the index whose meta page is stored at the given page number of the
+// given file. The analyzer must produce the same terms as the one the index was created with.
+func OpenFullTextIndex(
+	file PageFile, metaPageNum uint32, analyzer *Analyzer,
+) (*FullTextIndex, error) {
+	pages, err := file.ReadPages(metaPageNum, 1)
+	if err != nil {
+		return nil, err
+	}
+	page := &(*pages)[0]
+	if page[0] != fullTextMetaPage {
+		return nil, &CorruptIndexError{metaPageNum, "not a meta page"}
+	}
+	if page[1] != fullTextVersion {
+		return nil, &CorruptIndexError{metaPageNum, fmt.Sprintf("unknown version %d", page[1])}
+	}
+	postings, err := OpenBTree(file, binary.LittleEndian.Uint32(page[4:]))
+	if err != nil {
+		return nil, err
+	}
+	if len(postings.orders) != 2 {
+		return nil, &CorruptIndexError{metaPageNum, "postings tree does not have 2 key columns"}
+	}
+	return &FullTextIndex{
+		analyzer:    analyzer,
+		file:        file,
+		metaPageNum: metaPageNum,
+		postings:    postings,
+		numRecords:  binary.LittleEndian.Uint64(page[8:]),
+		numTerms:    binary.LittleEndian.Uint64(page[16:]),
+	}, nil
+}
+
+// MetaPageNum returns the number of the page that the index can be opened from with
+// OpenFullTextIndex.
+func (x *FullTextIndex) MetaPageNum() uint32 {
+	return x.metaPageNum
+}
+
+// Length returns the number of records in the index.
+func (x *FullTextIndex) Length() uint64 {
+	return x.numRecords
+}
+
+// writeMeta writes the meta page of the index.
+func (x *FullTextIndex) writeMeta() error {
+	var page Page
+	page[0] = fullTextMetaPage
+	page[1] = fullTextVersion
+	binary.LittleEndian.PutUint32(page[4:], x.postings.MetaPageNum())
+	binary.LittleEndian.PutUint64(page[8:], x.numRecords)
+	binary.LittleEndian.PutUint64(page[16:], x.numTerms)
+	_, err := x.file.WritePages(&[]Page{page}, x.metaPageNum)
+	return err
+}
+
+// appendUvarint appends the unsigned varint encoding of the given value.
+func appendUvarint(b []byte, value uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], value)]...)
+}
+
+// postingKeys returns the keys of the postings entries for a text with the given terms.
+func postingKeys(tokens []Token) [][]any {
+	var terms []string
+	positions := make(map[string][]uint32)
+	for _, token := range tokens {
+		if _, ok := positions[token.Term]; !ok {
+			terms = append(terms, token.Term)
+		}
+		positions[token.Term] = append(positions[token.Term], token.Position)
+	}
+
+	var keys [][]any
+	for _, term := range terms {
+		termPositions := positions[term]
+		prev := uint32(0)
+		for chunk := 0; chunk*fullTextChunkPositions < len(termPositions); chunk++ {
+			posting := make([]byte, 4, 32)
+			binary.BigEndian.PutUint32(posting, uint32(chunk))
+			if chunk == 0 {
+				posting = appendUvarint(posting, uint64(len(tokens)))
+				posting = appendUvarint(posting, uint64(len(termPositions)))
+			}
+			end := (chunk + 1) * fullTextChunkPositions
+			if end > len(termPositions) {
+				end = len(termPositions)
+			}
+			for _, position := range termPositions[chunk*fullTextChunkPositions : end] {
+				posting = appendUvarint(posting, uint64(position-prev))
+				prev = position
+			}
+			keys = append(keys, []any{term, posting})
+		}
+	}
+	return keys
+}
+
+// Add adds the terms of the given text to the index for the record with the given address. A
+// text without terms is not indexed. A DuplicateKeyError is returned, and nothing is added, if
+// the index already stores the same text for the record.
+func (x *FullTextIndex) Add(address RecordAddress, text string) error {
+	tokens := x.analyzer.Analyze(text)
+	keys := postingKeys(tokens)
+	if len(keys) == 0 {
+		return nil
+	}
+	for _, key := range keys {
+		if err := x.postings.Insert(key, address); err != nil {
+			return err
+		}
+	}
+	x.numRecords++
+	x.numTerms += uint64(len(tokens))
+	return x.writeMeta()
+}
+
+// Remove removes the terms of the given text from the index for the record with the given
+// address. The text must be the one the record was added with; if the index does not store it
+// for the record, nothing is removed.
+func (x *FullTextIndex) Remove(address RecordAddress, text string) error {
+	tokens := x.analyzer.Analyze(text)
+	keys := postingKeys(tokens)
+	if len(keys) == 0 {
+		return nil
+	}
+	for i, key := range keys {
+		found, err := x.postings.Delete(key, address)
+		if err != nil {
+			return err
+		}
+		if !found && i == 0 {
+			return nil
+		}
+	}
+	x.numRecords--
+	x.numTerms -= uint64(len(tokens))
+	return x.writeMeta()
+}
+
+// termPostings returns the postings of the given term by record address.
+func (x *FullTextIndex) termPostings(term string) (map[RecordAddress]*textPosting, error) {
+	bound := &KeyBound{Key: []any{term}}
+	it, err := x.postings.Scan(bound, bound, false)
+	if err != nil {
+		return nil, err
+	}
+	corrupt := &CorruptIndexError{x.metaPageNum, fmt.Sprintf("invalid posting of term %q", term)}
+	postings := make(map[RecordAddress]*textPosting)
+	for {
+		entry, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, ok := entry.Key[1].([]byte)
+		if !ok || len(data) < 4 {
+			return nil, corrupt
+		}
+		chunk := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		p := postings[entry.Address]
+		if chunk == 0 {
+			if p != nil {
+				return nil, corrupt
+			}
+			p = &textPosting{}
+			if p.numTerms, ok = readUvarint(&data); !ok {
+				return nil, corrupt
+			}
+			if p.numPositions, ok = readUvarint(&data); !ok || p.numPositions > p.numTerms {
+				return nil, corrupt
+			}
+			postings[entry.Address] = p
+		} else if p == nil || chunk != p.numChunks {
+			return nil, corrupt
+		}
+		p.numChunks++
+
+		position := uint64(0)
+		if len(p.positions) > 0 {
+			position = uint64(p.positions[len(p.positions)-1])
+		}
+		for len(data) > 0 {
+			delta, ok := readUvarint(&data)
+			if position += delta; !ok || position > math.MaxUint32 ||
+				uint64(len(p.positions)) == p.numPositions {
+				return nil, corrupt
+			}
+			p.positions = append(p.positions, uint32(position))
+		}
+	}
+	for _, p := range postings {
+		if uint64(len(p.positions)) != p.numPositions {
+			return nil, corrupt
+		}
+	}
+	return postings, nil
+}
+
+// readUvarint reads an unsigned varint from the start of the given bytes and advances them past
+// it. It returns false if the bytes do not start with a varint.
+func readUvarint(data *[]byte) (uint64, bool) {
+	value, n := binary.Uvarint(*data)
+	if n <= 0 {
+		return 0, false
+	}
+	*data = (*data)[n:]
+	return value, true
+}
+
+// Search returns the records matched by the given query, ordered by decreasing score and then by
+// address. At most limit records are returned, unless limit is 0.
+func (x *FullTextIndex) Search(query TextQuery, limit int) ([]TextMatch, error) {
+	s := &textSearch{index: x, postings: make(map[string]map[RecordAddress]*textPosting)}
+	scores, err := query.evaluate(s)
+	if err != nil {
+		return nil, err
+	}
+	matches := make([]TextMatch, 0, len(scores))
+	for address, score := range scores {
+		matches = append(matches, TextMatch{address, score})
+	}
+	sort.Slice(
+		matches, func(i, j int) bool {
+			if matches[i].Score != matches[j].Score {
+				return matches[i].Score > matches[j].Score
+			}
+			return addressLess(matches[i].Address, matches[j].Address)
+		},
+	)
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// textSearch holds the state of a search of a FullTextIndex.
+type textSearch struct {
+	index    *FullTextIndex
+	postings map[string]map[RecordAddress]*textPosting
+}
+
+// termPostings returns the postings of the given term, reading them from the index only once per
+// search.
+func (s *textSearch) termPostings(term string) (map[RecordAddress]*textPosting, error) {
+	if postings, ok := s.postings[term]; ok {
+		return postings, nil
+	}
+	postings, err := s.index.termPostings(term)
+	if err == nil {
+		s.postings[term] = postings
+	}
+	return postings, err
+}
+
+// score returns the BM25 score of a term that is found in numMatches records, including a record
+// of numTerms terms where it is found frequency times.
+func (s *textSearch) score(numMatches int, frequency int, numTerms uint64) float64 {
+	n := float64(s.index.numRecords)
+	idf := math.Log(1 + (n-float64(numMatches)+0.5)/(float64(numMatches)+0.5))
+	averageTerms := float64(s.index.numTerms) / n
+	f := float64(frequency)
+	norm := 1 - bm25B + bm25B*float64(numTerms)/averageTerms
+	return idf * f * (bm25K1 + 1) / (f + bm25K1*norm)
+}
+
+// TextQuery is a query of a FullTextIndex. Queries are built from TermQuery and PhraseQuery
+// combined with AndQuery, OrQuery and NotQuery, or parsed with ParseTextQuery.
+type TextQuery interface {
+	// evaluate returns the scores of the records matched by the query, or nil if the analyzer of
+	// the index produces no terms from the query, such as a query made only of stop words.
+	evaluate(s *textSearch) (map[RecordAddress]float64, error)
+}
+
+// TermQuery matches the records whose text contains every term that the analyzer of the index
+// produces from Text, in any order.
+type TermQuery struct {
+	Text string
+}
+
+// PhraseQuery matches the records whose text contains the terms that the analyzer of the index
+// produces from Text in the same order and at the same distances from each other, so words
+// dropped by the analyzer match any word.
+type PhraseQuery struct {
+	Text string
+}
+
+// AndQuery matches the records matched by every one of its queries. Queries without terms are
+// ignored.
+type AndQuery struct {
+	Queries []TextQuery
+}
+
+// OrQuery matches the records matched by any of its queries.
+type OrQuery struct {
+	Queries []TextQuery
+}
+
+// NotQuery matches the records matched by Query that are not matched by Exclude.
+type NotQuery struct {
+	Query   TextQuery
+	Exclude TextQuery
+}
+
+func (q TermQuery) evaluate(s *textSearch) (map[RecordAddress]float64, error) {
+	var scores map[RecordAddress]float64
+	seen := make(map[string]bool)
+	for _, token := range s.index.analyzer.Analyze(q.Text) {
+		if seen[token.Term] {
+			continue
+		}
+		seen[token.Term] = true
+		postings, err := s.termPostings(token.Term)
+		if err != nil {
+			return nil, err
+		}
+		found := make(map[RecordAddress]float64)
+		for address, p := range postings {
+			score, ok := scores[address]
+			if scores == nil || ok {
+				found[address] = score + s.score(len(postings), len(p.positions), p.numTerms)
+			}
+		}
+		scores = found
+	}
+	return scores, nil
+}
+
+func (q PhraseQuery) evaluate(s *textSearch) (map[RecordAddress]float64, error) {
+	tokens := s.index.analyzer.Analyze(q.Text)
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	postings := make([]map[RecordAddress]*textPosting, len(tokens))
+	for i, token := range tokens {
+		var err error
+		if postings[i], err = s.termPostings(token.Term); err != nil {
+			return nil, err
+		}
+	}
+
+	scores := make(map[RecordAddress]float64)
+	for address, first := range postings[0] {
+		frequency := 0
+		for _, position := range first.positions {
+			if phraseAt(tokens, postings, address, position) {
+				frequency++
+			}
+		}
+		if frequency == 0 {
+			continue
+		}
+		seen := make(map[string]bool)
+		for i, token := range tokens {
+			if !seen[token.Term] {
+				seen[token.Term] = true
+				scores[address] += s.score(len(postings[i]), frequency, first.numTerms)
+			}
+		}
+	}
+	return scores, nil
+}
+
+// phraseAt returns true if the text of the record with the given address has the terms of the
+// given tokens at their distances from the first token, with the first one at the given position.
+func phraseAt(
+	tokens []Token, postings []map[RecordAddress]*textPosting, address RecordAddress,
+	start uint32,
+) bool {
+	for i := 1; i < len(tokens); i++ {
+		p := postings[i][address]
+		if p == nil {
+			return false
+		}
+		position := start + tokens[i].Position - tokens[0].Position
+		j := sort.Search(
+			len(p.positions), func(j int) bool {
+				return p.positions[j] >= position
+			},
+		)
+		if j == len(p.positions) || p.positions[j] != position {
+			return false
+		}
+	}
+	return true
+}
+
+func (q AndQuery) evaluate(s *textSearch) (map[RecordAddress]float64, error) {
+	var scores map[RecordAddress]float64
+	for _, query := range q.Queries {
+		matched, err := query.evaluate(s)
+		if err != nil {
+			return nil, err
+		}
+		if matched == nil {
+			continue
+		}
+		if scores == nil {
+			scores = matched
+			continue
+		}
+		for address, score := range scores {
+			if other, ok := matched[address]; ok {
+				scores[address] = score + other
+			} else {
+				delete(scores, address)
+			}
+		}
+	}
+	return scores, nil
+}
+
+func (q OrQuery) evaluate(s *textSearch) (map[RecordAddress]float64, error) {
+	var scores map[RecordAddress]float64
+	for _, query := range q.Queries {
+		matched, err := query.evaluate(s)
+		if err != nil {
+			return nil, err
+		}
+		if matched == nil {
+			continue
+		}
+		if scores == nil {
+			scores = make(map[RecordAddress]float64)
+		}
+		for address, score := range matched {
+			scores[address] += score
+		}
+	}
+	return scores, nil
+}
+
+func (q NotQuery) evaluate(s *textSearch) (map[RecordAddress]float64, error) {
+	scores, err := q.Query.evaluate(s)
+	if err != nil || scores == nil || q.Exclude == nil {
+		return scores, err
+	}
+	excluded, err := q.Exclude.evaluate(s)
+	if err != nil {
+		return nil, err
+	}
+	for address := range excluded {
+		delete(scores, address)
+	}
+	return scores, nil
+}
+
+// ParseTextQuery parses a query written in a simple search syntax:
+//   - words separated by spaces match records containing all of them, as a TermQuery each
+//   - text in double quotes is matched as a PhraseQuery
+//   - OR between two words or phrases matches records containing either of them, and binds more
+//     tightly than the spaces between words
+//   - a word or phrase starting with - excludes the records containing it
+//
+// For example, `"full text" search OR query -sql` matches records containing the phrase "full
+// text" and either search or query, but not sql.
+func ParseTextQuery(text string) (TextQuery, error) {
+	var clauses, alternatives, excluded []TextQuery
+	expectOperand := false
+	for offset := skipSpaces(text, 0); offset < len(text); offset = skipSpaces(text, offset) {
+		start := offset
+		negated := text[offset] == '-' && offset+1 < len(text) &&
+			skipSpaces(text, offset+1) == offset+1
+		if negated {
+			offset++
+		}
+		var query TextQuery
+		if text[offset] == '"' {
+			end := strings.IndexByte(text[offset+1:], '"')
+			if end < 0 {
+				return nil, &QuerySyntaxError{text, offset, "unterminated phrase"}
+			}
+			query = PhraseQuery{text[offset+1 : offset+1+end]}
+			offset += end + 2
+		} else {
+			end := strings.IndexFunc(
+				text[offset:], func(r rune) bool {
+					return unicode.IsSpace(r) || r == '"'
+				},
+			)
+			if end < 0 {
+				end = len(text) - offset
+			}
+			word := text[offset : offset+end]
+			offset += end
+			if word == "OR" && !negated {
+				if len(alternatives) == 0 || expectOperand {
+					return nil, &QuerySyntaxError{text, start, "OR without a left operand"}
+				}
+				expectOperand = true
+				continue
+			}
+			query = TermQuery{word}
+		}
+
+		switch {
+		case negated && expectOperand:
+			return nil, &QuerySyntaxError{text, start, "excluded operand of OR"}
+		case negated:
+			excluded = append(excluded, query)
+			continue
+		case !expectOperand && len(alternatives) > 0:
+			clauses = append(clauses, orQuery(alternatives))
+			alternatives = nil
+		}
+		alternatives = append(alternatives, query)
+		expectOperand = false
+	}
+	if expectOperand {
+		return nil, &QuerySyntaxError{text, len(text), "OR without a right operand"}
+	}
+	if len(alternatives) > 0 {
+		clauses = append(clauses, orQuery(alternatives))
+	}
+
+	var query TextQuery = AndQuery{clauses}
+	if len(clauses) == 1 {
+		query = clauses[0]
+	}
+	if len(excluded) > 0 {
+		query = NotQuery{query, orQuery(excluded)}
+	}
+	return query, nil
+}
+
+// orQuery returns the query matching any of the given queries.
+func orQuery(queries []TextQuery) TextQuery {
+	if len(queries) == 1 {
+		return queries[0]
+	}
+	return OrQuery{queries}
+}
+
+// skipSpaces returns the offset of the first character of the given text at or after the given
+// offset that is not a space, or the length of the text.
+func skipSpaces(text string, offset int) int {
+	end := strings.IndexFunc(
+		text[offset:], func(r rune) bool {
+			return !unicode.IsSpace(r)
+		},
+	)
+	if end < 0 {
+		return len(text)
+	}
+	return offset + end
+}
+
+// checkFullText returns an error if the definition of the given full-text index cannot be used
+// with the schema of the table.
+func (t *Table) checkFullText(index *tableIndex) error {
+	definition := &index.definition
+	if len(definition.Columns) != 1 {
+		return fmt.Errorf("full-text index must have 1 column, got %d", len(definition.Columns))
+	}
+	if definition.Unique || definition.Hash || definition.Elements != WholeValues ||
+		len(definition.Orders) > 0 {
+		return fmt.Errorf(
+			"full-text index cannot be unique, hash, multi-valued or have sort orders",
+		)
+	}
+	if columnType := t.schema[index.positions[0]].Type; columnType != element.StringType {
+		return &element.TypeMismatchError{Expected: element.StringType, Actual: columnType}
+	}
+	return nil
+}
+
+// Search returns the home addresses of the records matched by the given query in the full-text
+// index with the given name, with their scores, as returned by FullTextIndex.Search.
+func (t *Table) Search(tx *Tx, name string, query TextQuery, limit int) ([]TextMatch, error) {
+	if err := t.lockQuery(tx); err != nil {
+		return nil, err
+	}
+	defer t.mutex.Unlock()
+	index, err := t.readyIndex(name)
+	if err != nil {
+		return nil, err
+	}
+	if index.text == nil {
+		return nil, fmt.Errorf("index '%s' is not a full-text index", name)
+	}
+	return index.text.Search(query, limit)
+}
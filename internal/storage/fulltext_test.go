@@ -0,0 +1,434 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testTexts = []string{
+	"The quick brown fox jumps over the lazy dog",
+	"A quick brown dog outpaces a quick fox",
+	"Lazy afternoons are for sleeping dogs",
+	"The fox and the hound",
+	"Brown bread with butter",
+}
+
+// newTestFullTextIndex returns an index of testTexts, where the text with index i is stored for
+// testAddress(i).
+func newTestFullTextIndex(t *testing.T, analyzer *Analyzer) *FullTextIndex {
+	t.Helper()
+	x, err := NewFullTextIndex(&memoryFile{}, analyzer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, text := range testTexts {
+		if err = x.Add(testAddress(i), text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return x
+}
+
+// matchedAddresses returns the addresses of the given matches, in order.
+func matchedAddresses(matches []TextMatch) []RecordAddress {
+	addresses := make([]RecordAddress, len(matches))
+	for i, match := range matches {
+		addresses[i] = match.Address
+	}
+	return addresses
+}
+
+// testAddresses returns the test addresses with the given indexes.
+func testAddresses(indexes ...int) []RecordAddress {
+	addresses := make([]RecordAddress, len(indexes))
+	for i, index := range indexes {
+		addresses[i] = testAddress(index)
+	}
+	return addresses
+}
+
+func TestFullTextIndex_Search(t *testing.T) {
+	t.Run(
+		"check boolean queries", func(t *testing.T) {
+			x := newTestFullTextIndex(t, EnglishAnalyzer())
+			tests := []struct {
+				query    TextQuery
+				expected []int
+			}{
+				{TermQuery{"fox"}, []int{0, 1, 3}},
+				{TermQuery{"DOGS"}, []int{0, 1, 2}},
+				{TermQuery{"brown fox"}, []int{0, 1}},
+				{TermQuery{"the"}, []int{}},
+				{TermQuery{"cat"}, []int{}},
+				{AndQuery{[]TextQuery{TermQuery{"lazy"}, TermQuery{"the"}}}, []int{0, 2}},
+				{OrQuery{[]TextQuery{TermQuery{"hound"}, TermQuery{"bread"}}}, []int{3, 4}},
+				{NotQuery{TermQuery{"fox"}, TermQuery{"dog"}}, []int{3}},
+				{NotQuery{TermQuery{"brown"}, nil}, []int{0, 1, 4}},
+			}
+			for _, test := range tests {
+				matches, err := x.Search(test.query, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				found := sortTestAddresses(matchedAddresses(matches))
+				if !reflect.DeepEqual(found, testAddresses(test.expected...)) {
+					t.Errorf("%v: expected %v, got %v", test.query, test.expected, found)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check phrase queries", func(t *testing.T) {
+			x := newTestFullTextIndex(t, StandardAnalyzer())
+			tests := []struct {
+				phrase   string
+				expected []RecordAddress
+			}{
+				{"quick brown", testAddresses(1, 0)},
+				{"brown fox", testAddresses(0)},
+				{"fox brown", []RecordAddress{}},
+				{"over the lazy dog", testAddresses(0)},
+				{"fox and the hound", testAddresses(3)},
+				{"fox hound", []RecordAddress{}},
+				{"quick", testAddresses(1, 0)},
+			}
+			for _, test := range tests {
+				matches, err := x.Search(PhraseQuery{test.phrase}, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if found := matchedAddresses(matches); !reflect.DeepEqual(found, test.expected) {
+					t.Errorf("%q: expected %v, got %v", test.phrase, test.expected, found)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check ranking", func(t *testing.T) {
+			x := newTestFullTextIndex(t, StandardAnalyzer())
+			matches, err := x.Search(TermQuery{"quick"}, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The second text has quick twice in as many terms as the first text.
+			if !reflect.DeepEqual(matchedAddresses(matches), testAddresses(1, 0)) {
+				t.Fatalf("expected the second text first, got %v", matches)
+			}
+
+			// 5 texts of 22 terms in total, with quick in 2 of them.
+			idf := math.Log(1 + (5-2+0.5)/(2+0.5))
+			norm := 1 - bm25B + bm25B*6/(22.0/5)
+			expected := idf * 2 * (bm25K1 + 1) / (2 + bm25K1*norm)
+			if math.Abs(matches[0].Score-expected) > 1e-9 {
+				t.Errorf("expected score %v, got %v", expected, matches[0].Score)
+			}
+
+			matches, _ = x.Search(OrQuery{[]TextQuery{TermQuery{"fox"}, TermQuery{"hound"}}}, 1)
+			if !reflect.DeepEqual(matchedAddresses(matches), testAddresses(3)) {
+				t.Errorf("expected the text with both terms, got %v", matches)
+			}
+		},
+	)
+
+	t.Run(
+		"check long texts", func(t *testing.T) {
+			x, _ := NewFullTextIndex(&memoryFile{}, StandardAnalyzer())
+			words := make([]string, 1000)
+			for i := range words {
+				words[i] = fmt.Sprintf("w%d", i%7)
+			}
+			text := strings.Join(words, " ") + " end"
+			if err := x.Add(testAddress(0), text); err != nil {
+				t.Fatal(err)
+			}
+			postings, err := x.termPostings("w3")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := postings[testAddress(0)]
+			if p == nil || len(p.positions) != 143 || p.numChunks != 2 || p.numTerms != 1001 {
+				t.Fatalf("expected 143 positions in 2 chunks, got %+v", p)
+			}
+			for i, position := range p.positions {
+				if position != uint32(3+7*i) {
+					t.Fatalf("expected position %d at %d, got %d", 3+7*i, i, position)
+				}
+			}
+			matches, _ := x.Search(PhraseQuery{"w4 w5 end"}, 0)
+			if len(matches) != 1 {
+				t.Errorf("expected a match, got %v", matches)
+			}
+		},
+	)
+}
+
+// sortTestAddresses returns the given addresses in address order.
+func sortTestAddresses(addresses []RecordAddress) []RecordAddress {
+	set := make(map[RecordAddress]bool)
+	for _, address := range addresses {
+		set[address] = true
+	}
+	return sortedAddresses(set)
+}
+
+func TestFullTextIndex_Remove(t *testing.T) {
+	t.Run(
+		"check remove", func(t *testing.T) {
+			x := newTestFullTextIndex(t, StandardAnalyzer())
+			numEntries := x.postings.Len()
+			if err := x.Remove(testAddress(0), testTexts[0]); err != nil {
+				t.Fatal(err)
+			}
+			if x.Length() != 4 || x.numTerms != 15 {
+				t.Errorf("expected 4 texts of 15 terms, got %d of %d", x.Length(), x.numTerms)
+			}
+			matches, _ := x.Search(TermQuery{"lazy"}, 0)
+			if !reflect.DeepEqual(matchedAddresses(matches), testAddresses(2)) {
+				t.Errorf("expected %v, got %v", testAddress(2), matches)
+			}
+
+			// Removing a text that is not stored does nothing.
+			if err := x.Remove(testAddress(0), testTexts[0]); err != nil {
+				t.Fatal(err)
+			}
+			if x.Length() != 4 {
+				t.Errorf("expected 4 texts, got %d", x.Length())
+			}
+			if err := x.Add(testAddress(0), testTexts[0]); err != nil {
+				t.Fatal(err)
+			}
+			if x.postings.Len() != numEntries || x.Length() != 5 || x.numTerms != 22 {
+				t.Errorf(
+					"expected %d entries for 5 texts of 22 terms, got %d for %d of %d",
+					numEntries, x.postings.Len(), x.Length(), x.numTerms,
+				)
+			}
+		},
+	)
+
+	t.Run(
+		"check duplicate add", func(t *testing.T) {
+			x := newTestFullTextIndex(t, StandardAnalyzer())
+			err := x.Add(testAddress(1), testTexts[1])
+			if _, ok := err.(*DuplicateKeyError); !ok {
+				t.Errorf("expected DuplicateKeyError, got %v", err)
+			}
+			if err = x.Add(testAddress(5), "the and of"); err != nil {
+				t.Fatal(err)
+			}
+			if x.Length() != 5 {
+				t.Errorf("expected 5 texts, got %d", x.Length())
+			}
+		},
+	)
+}
+
+func TestOpenFullTextIndex(t *testing.T) {
+	t.Run(
+		"check reopen", func(t *testing.T) {
+			x := newTestFullTextIndex(t, StandardAnalyzer())
+			reopened, err := OpenFullTextIndex(x.file, x.MetaPageNum(), StandardAnalyzer())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reopened.Length() != 5 || reopened.numTerms != x.numTerms {
+				t.Errorf("expected 5 texts, got %d", reopened.Length())
+			}
+			expected, _ := x.Search(TermQuery{"brown"}, 0)
+			matches, err := reopened.Search(TermQuery{"brown"}, 0)
+			if err != nil || !reflect.DeepEqual(matches, expected) {
+				t.Errorf("expected %v, got %v, %v", expected, matches, err)
+			}
+		},
+	)
+
+	t.Run(
+		"check corrupt index", func(t *testing.T) {
+			x := newTestFullTextIndex(t, StandardAnalyzer())
+			if _, err := OpenFullTextIndex(x.file, x.postings.MetaPageNum(), nil); err == nil {
+				t.Error("expected error for postings meta page")
+			}
+			_ = x.postings.Insert([]any{"fox", []byte{0, 0, 0, 1}}, testAddress(9))
+			_, err := x.Search(TermQuery{"fox"}, 0)
+			if _, ok := err.(*CorruptIndexError); !ok {
+				t.Errorf("expected CorruptIndexError, got %v", err)
+			}
+		},
+	)
+}
+
+func TestParseTextQuery(t *testing.T) {
+	t.Run(
+		"check queries", func(t *testing.T) {
+			tests := []struct {
+				text     string
+				expected TextQuery
+			}{
+				{"fox", TermQuery{"fox"}},
+				{
+					`  quick "brown fox"  `,
+					AndQuery{[]TextQuery{TermQuery{"quick"}, PhraseQuery{"brown fox"}}},
+				},
+				{
+					"a OR b c",
+					AndQuery{
+						[]TextQuery{
+							OrQuery{[]TextQuery{TermQuery{"a"}, TermQuery{"b"}}}, TermQuery{"c"},
+						},
+					},
+				},
+				{
+					`fox -dog -"lazy cat"`,
+					NotQuery{
+						TermQuery{"fox"},
+						OrQuery{[]TextQuery{TermQuery{"dog"}, PhraseQuery{"lazy cat"}}},
+					},
+				},
+				{
+					"a - b or",
+					AndQuery{
+						[]TextQuery{TermQuery{"a"}, TermQuery{"-"}, TermQuery{"b"}, TermQuery{"or"}},
+					},
+				},
+				{"", AndQuery{}},
+			}
+			for _, test := range tests {
+				query, err := ParseTextQuery(test.text)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(query, test.expected) {
+					t.Errorf("%q: expected %#v, got %#v", test.text, test.expected, query)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check syntax errors", func(t *testing.T) {
+			tests := map[string]int{
+				`a "b c`:    2,
+				"OR a":      0,
+				"a OR":      4,
+				"a OR OR b": 5,
+				"a OR -b":   5,
+			}
+			for text, offset := range tests {
+				_, err := ParseTextQuery(text)
+				if e, ok := err.(*QuerySyntaxError); !ok || e.Offset != offset {
+					t.Errorf("%q: expected error at %d, got %v", text, offset, err)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check search with parsed query", func(t *testing.T) {
+			x := newTestFullTextIndex(t, EnglishAnalyzer())
+			query, _ := ParseTextQuery(`"quick brown" -jumping bread OR dog`)
+			matches, err := x.Search(query, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(matchedAddresses(matches), testAddresses(1)) {
+				t.Errorf("expected %v, got %v", testAddress(1), matches)
+			}
+		},
+	)
+}
+
+func TestTable_Search(t *testing.T) {
+	bioIndex := IndexDefinition{Name: "bio", Columns: []string{"bio"}, Analyzer: EnglishAnalyzer()}
+
+	t.Run(
+		"check index maintained on writes", func(t *testing.T) {
+			table := newTestTable(t, nameIndex, bioIndex)
+			var addresses []RecordAddress
+			for i, text := range testTexts {
+				record := testTableRecordWithBio(t, int64(i), "n", nil, text)
+				address, err := table.Insert(nil, record)
+				if err != nil {
+					t.Fatal(err)
+				}
+				addresses = append(addresses, address)
+			}
+			checkTableIndexes(t, table)
+			matches, err := table.Search(nil, "bio", TermQuery{"fox"}, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(matches) != 3 {
+				t.Errorf("expected 3 matches, got %v", matches)
+			}
+
+			record := testTableRecordWithBio(t, 0, "n", nil, "A sleepy cat")
+			if err = table.Update(nil, addresses[0], record); err != nil {
+				t.Fatal(err)
+			}
+			if err = table.Delete(nil, addresses[3]); err != nil {
+				t.Fatal(err)
+			}
+			matches, _ = table.Search(nil, "bio", TermQuery{"fox"}, 0)
+			if !reflect.DeepEqual(matchedAddresses(matches), addresses[1:2]) {
+				t.Errorf("expected %v, got %v", addresses[1:2], matches)
+			}
+			matches, _ = table.Search(nil, "bio", TermQuery{"sleeping"}, 0)
+			if len(matches) != 1 || matches[0].Address != addresses[2] {
+				t.Errorf("expected %v, got %v", addresses[2], matches)
+			}
+			if text := table.indexes[1].text; text.Length() != 4 {
+				t.Errorf("expected 4 texts, got %d", text.Length())
+			}
+		},
+	)
+
+	t.Run(
+		"check index of existing records", func(t *testing.T) {
+			table := newTestTable(t)
+			for i, text := range testTexts {
+				record := testTableRecordWithBio(t, int64(i), "n", nil, text)
+				if _, err := table.Insert(nil, record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := table.CreateIndex(nil, bioIndex); err != nil {
+				t.Fatal(err)
+			}
+			matches, err := table.Search(nil, "bio", PhraseQuery{"quick brown fox"}, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(matches) != 1 {
+				t.Errorf("expected 1 match, got %v", matches)
+			}
+		},
+	)
+
+	t.Run(
+		"check invalid definitions", func(t *testing.T) {
+			table := newTestTable(t, nameIndex)
+			definitions := []IndexDefinition{
+				{Name: "a", Columns: []string{"bio", "name"}, Analyzer: NewAnalyzer()},
+				{Name: "b", Columns: []string{"bio"}, Analyzer: NewAnalyzer(), Unique: true},
+				{Name: "c", Columns: []string{"id"}, Analyzer: NewAnalyzer()},
+			}
+			for _, definition := range definitions {
+				if err := table.CreateIndex(nil, definition); err == nil {
+					t.Errorf("%s: expected error", definition.Name)
+				}
+			}
+			if _, err := table.Search(nil, "name", TermQuery{"a"}, 0); err == nil {
+				t.Error("expected error for index that is not full-text")
+			}
+			_ = table.CreateIndex(nil, bioIndex)
+			if _, err := table.Lookup(nil, "bio", []any{"a"}); err == nil {
+				t.Error("expected error for lookup in full-text index")
+			}
+		},
+	)
+}
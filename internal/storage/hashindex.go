@@ -0,0 +1,504 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+
+	"kyadb/internal/structs/element"
+)
+
+/*
+ * A HashIndex is a linear hash index stored in the pages of a PageFile. It maps keys made of one or
+ * more element values to the address of a record, like a hashmap.HashMap whose entries live on
+ * disk.
+ *
+ * The entries of the index are distributed over buckets by the 64-bit FNV-1a hash of the key
+ * encoding of their key, as returned by element.EncodeKey. With 2^level + split buckets, an entry
+ * is stored in the bucket given by the lowest level bits of its hash, unless that bucket is below
+ * split, in which case it is given by the lowest level+1 bits. Whenever the entries take more than
+ * hashFillSize bytes per bucket on average, bucket split is split into itself and bucket
+ * 2^level + split, so the index grows one bucket at a time. Buckets are not merged when entries are
+ * removed.
+ *
+ * The meta page of an index starts with a byte storing hashMetaPage, followed by a byte storing the
+ * version of the format, a byte storing the number of key columns, a byte storing the level, 4
+ * bytes storing the split bucket, 4 bytes storing the page number of the first directory page, 4
+ * bytes storing the page number of the first page of the free list, 8 bytes storing the number of
+ * entries and 8 bytes storing the number of bytes taken by the cells of the entries.
+ *
+ * The directory maps bucket numbers to the page numbers of the first pages of the buckets. A
+ * directory page starts with a byte storing hashDirectoryPage, 2 bytes storing the number of
+ * buckets on the page and 4 bytes storing the page number of the next directory page, or noPage,
+ * followed by the 4-byte page numbers of the buckets.
+ *
+ * A bucket is a chain of pages that starts with a page stored in the directory and continues with
+ * overflow pages. A bucket page starts with a byte storing hashBucketPage, 2 bytes storing the
+ * number of cells on the page and 4 bytes storing the page number of the next page of the chain,
+ * or noPage. Each cell stores the length of an entry in 2 bytes followed by the entry, which is the
+ * key encoding of its key followed by its record address, encoded as in a BTree entry.
+ *
+ * All integers are stored little-endian except for the record addresses in entries.
+ */
+
+const (
+	hashMetaPage      byte = 'H'
+	hashDirectoryPage byte = 'D'
+	hashBucketPage    byte = 'B'
+
+	hashVersion = 1
+
+	hashPageHeaderSize = 7
+
+	// hashDirectoryCapacity is the number of bucket page numbers stored on a directory page.
+	hashDirectoryCapacity = (PageSize - hashPageHeaderSize) / 4
+
+	// hashFillSize is the average number of bytes of cells per bucket above which a bucket is
+	// split.
+	hashFillSize = PageSize * 3 / 4
+
+	// hashMaxLevel bounds the number of buckets of an index to 2^hashMaxLevel.
+	hashMaxLevel = 31
+)
+
+// HashIndex is a linear hash index stored in the pages of a PageFile, which maps keys made of
+// element values to record addresses. It supports equality lookups only; BTree supports ranges of
+// keys. An index is not safe for concurrent use.
+type HashIndex struct {
+	file        PageFile
+	metaPageNum uint32
+	numColumns  int
+	level       uint8
+	split       uint32
+	buckets     []uint32
+	directory   []uint32
+	freeList    uint32
+	numEntries  uint64
+	numBytes    uint64
+}
+
+// hashBucket is a bucket of a HashIndex, decoded from its chain of pages.
+type hashBucket struct {
+	pageNums []uint32
+	entries  [][]byte
+}
+
+// KeyNotFoundError is returned when an index does not store the given key.
+type KeyNotFoundError struct {
+	Key []any
+}
+
+func (e *KeyNotFoundError) Error() string {
+	return fmt.Sprintf("key not found in index: %v", e.Key)
+}
+
+// NewHashIndex creates an empty index with the given number of key columns in the given file. The
+// pages of the index are appended to the file; MetaPageNum returns the page to open the index
+// from later.
+func NewHashIndex(file PageFile, numColumns int) (*HashIndex, error) {
+	if numColumns <= 0 || numColumns > MaxBTreeKeyColumns {
+		return nil, fmt.Errorf(
+			"number of key columns must be between 1 and %d, got %d",
+			MaxBTreeKeyColumns, numColumns,
+		)
+	}
+	pageNums, err := file.AppendPages(&[]Page{{}})
+	if err != nil {
+		return nil, err
+	}
+	h := &HashIndex{
+		file:        file,
+		metaPageNum: pageNums[0],
+		numColumns:  numColumns,
+		freeList:    noPage,
+	}
+	if err = h.addBucket(&hashBucket{}); err != nil {
+		return nil, err
+	}
+	return h, h.writeMeta()
+}
+
+// OpenHashIndex opens the index whose meta page is stored at the given page number of the given
+// file.
+func OpenHashIndex(file PageFile, metaPageNum uint32) (*HashIndex, error) {
+	pages, err := file.ReadPages(metaPageNum, 1)
+	if err != nil {
+		return nil, err
+	}
+	page := &(*pages)[0]
+	if page[0] != hashMetaPage {
+		return nil, &CorruptIndexError{metaPageNum, "not a meta page"}
+	}
+	if page[1] != hashVersion {
+		return nil, &CorruptIndexError{metaPageNum, fmt.Sprintf("unknown version %d", page[1])}
+	}
+	h := &HashIndex{
+		file:        file,
+		metaPageNum: metaPageNum,
+		numColumns:  int(page[2]),
+		level:       page[3],
+		split:       binary.LittleEndian.Uint32(page[4:]),
+		freeList:    binary.LittleEndian.Uint32(page[12:]),
+		numEntries:  binary.LittleEndian.Uint64(page[16:]),
+		numBytes:    binary.LittleEndian.Uint64(page[24:]),
+	}
+	if h.numColumns == 0 || h.numColumns > MaxBTreeKeyColumns {
+		return nil, &CorruptIndexError{
+			metaPageNum, fmt.Sprintf("invalid number of key columns %d", h.numColumns),
+		}
+	}
+	if h.level > hashMaxLevel || uint64(h.split) >= 1<<h.level {
+		return nil, &CorruptIndexError{
+			metaPageNum, fmt.Sprintf("invalid level %d and split %d", h.level, h.split),
+		}
+	}
+
+	numBuckets := h.numBuckets()
+	for pageNum := binary.LittleEndian.Uint32(page[8:]); pageNum != noPage; {
+		if uint64(len(h.buckets)) >= numBuckets {
+			return nil, &CorruptIndexError{pageNum, "directory has too many pages"}
+		}
+		pages, err := file.ReadPages(pageNum, 1)
+		if err != nil {
+			return nil, err
+		}
+		page := &(*pages)[0]
+		count := int(binary.LittleEndian.Uint16(page[1:]))
+		if page[0] != hashDirectoryPage || count > hashDirectoryCapacity {
+			return nil, &CorruptIndexError{pageNum, "not a directory page"}
+		}
+		h.directory = append(h.directory, pageNum)
+		for i := 0; i < count; i++ {
+			offset := hashPageHeaderSize + 4*i
+			h.buckets = append(h.buckets, binary.LittleEndian.Uint32(page[offset:]))
+		}
+		pageNum = binary.LittleEndian.Uint32(page[3:])
+	}
+	if uint64(len(h.buckets)) != numBuckets {
+		return nil, &CorruptIndexError{
+			metaPageNum,
+			fmt.Sprintf("directory has %d buckets, expected %d", len(h.buckets), numBuckets),
+		}
+	}
+	return h, nil
+}
+
+// MetaPageNum returns the number of the page that the index can be opened from with
+// OpenHashIndex.
+func (h *HashIndex) MetaPageNum() uint32 {
+	return h.metaPageNum
+}
+
+// Length returns the number of entries in the index.
+func (h *HashIndex) Length() uint64 {
+	return h.numEntries
+}
+
+// numBuckets returns the number of buckets of the index according to its level and split bucket.
+func (h *HashIndex) numBuckets() uint64 {
+	return 1<<h.level + uint64(h.split)
+}
+
+// writeMeta writes the meta page of the index.
+func (h *HashIndex) writeMeta() error {
+	var page Page
+	page[0] = hashMetaPage
+	page[1] = hashVersion
+	page[2] = byte(h.numColumns)
+	page[3] = h.level
+	binary.LittleEndian.PutUint32(page[4:], h.split)
+	binary.LittleEndian.PutUint32(page[8:], h.directory[0])
+	binary.LittleEndian.PutUint32(page[12:], h.freeList)
+	binary.LittleEndian.PutUint64(page[16:], h.numEntries)
+	binary.LittleEndian.PutUint64(page[24:], h.numBytes)
+	_, err := h.file.WritePages(&[]Page{page}, h.metaPageNum)
+	return err
+}
+
+// writeDirectoryPage writes the directory page with the given index in the directory.
+func (h *HashIndex) writeDirectoryPage(i int) error {
+	var page Page
+	page[0] = hashDirectoryPage
+	next := noPage
+	if i+1 < len(h.directory) {
+		next = h.directory[i+1]
+	}
+	binary.LittleEndian.PutUint32(page[3:], next)
+	buckets := h.buckets[i*hashDirectoryCapacity:]
+	if len(buckets) > hashDirectoryCapacity {
+		buckets = buckets[:hashDirectoryCapacity]
+	}
+	binary.LittleEndian.PutUint16(page[1:], uint16(len(buckets)))
+	for j, pageNum := range buckets {
+		binary.LittleEndian.PutUint32(page[hashPageHeaderSize+4*j:], pageNum)
+	}
+	_, err := h.file.WritePages(&[]Page{page}, h.directory[i])
+	return err
+}
+
+// addBucket writes the given bucket, whose pages are allocated as needed, and adds it to the
+// directory as the last bucket.
+func (h *HashIndex) addBucket(b *hashBucket) error {
+	if err := h.writeBucket(b); err != nil {
+		return err
+	}
+	h.buckets = append(h.buckets, b.pageNums[0])
+	i := (len(h.buckets) - 1) / hashDirectoryCapacity
+	if i < len(h.directory) {
+		return h.writeDirectoryPage(i)
+	}
+	pageNum, err := allocateIndexPage(h.file, &h.freeList)
+	if err != nil {
+		return err
+	}
+	h.directory = append(h.directory, pageNum)
+	if i > 0 {
+		// Link the new page from the page before it.
+		if err = h.writeDirectoryPage(i - 1); err != nil {
+			return err
+		}
+	}
+	return h.writeDirectoryPage(i)
+}
+
+// hashKey returns the hash of the given key encoding.
+func hashKey(key []byte) uint64 {
+	hash64 := fnv.New64a()
+	_, _ = hash64.Write(key)
+	return hash64.Sum64()
+}
+
+// bucketIndex returns the index of the bucket that stores the entries of the given key encoding.
+func (h *HashIndex) bucketIndex(key []byte) int {
+	hashed := hashKey(key)
+	i := hashed & (1<<h.level - 1)
+	if i < uint64(h.split) {
+		i = hashed & (1<<(h.level+1) - 1)
+	}
+	return int(i)
+}
+
+// encodeKey returns the key encoding of the given key, which must have a value for each key column
+// of the index.
+func (h *HashIndex) encodeKey(key []any) ([]byte, error) {
+	if len(key) != h.numColumns {
+		return nil, &KeyColumnsError{Expected: h.numColumns, Actual: len(key)}
+	}
+	encoded, err := element.EncodeKey(key...)
+	if err != nil {
+		return nil, err
+	}
+	if len(encoded) > MaxBTreeKeySize {
+		return nil, &KeyTooLargeError{len(encoded)}
+	}
+	return encoded, nil
+}
+
+// readBucket returns the bucket with the given index.
+func (h *HashIndex) readBucket(i int) (*hashBucket, error) {
+	b := &hashBucket{}
+	seen := make(map[uint32]bool)
+	for pageNum := h.buckets[i]; pageNum != noPage; {
+		if seen[pageNum] {
+			return nil, &CorruptIndexError{pageNum, "bucket pages form a cycle"}
+		}
+		seen[pageNum] = true
+		pages, err := h.file.ReadPages(pageNum, 1)
+		if err != nil {
+			return nil, err
+		}
+		page := &(*pages)[0]
+		if page[0] != hashBucketPage {
+			return nil, &CorruptIndexError{pageNum, fmt.Sprintf("unknown page type %d", page[0])}
+		}
+		b.pageNums = append(b.pageNums, pageNum)
+		numCells := int(binary.LittleEndian.Uint16(page[1:]))
+		offset := hashPageHeaderSize
+		for j := 0; j < numCells; j++ {
+			if offset+2 > PageSize {
+				return nil, &CorruptIndexError{pageNum, "cells exceed page"}
+			}
+			length := int(binary.LittleEndian.Uint16(page[offset:]))
+			offset += 2
+			if offset+length > PageSize {
+				return nil, &CorruptIndexError{pageNum, "cells exceed page"}
+			}
+			if length <= btreeAddressSize {
+				return nil, &CorruptIndexError{pageNum, fmt.Sprintf("entry %d too short", j)}
+			}
+			b.entries = append(b.entries, append([]byte(nil), page[offset:offset+length]...))
+			offset += length
+		}
+		pageNum = binary.LittleEndian.Uint32(page[3:])
+	}
+	return b, nil
+}
+
+// writeBucket packs the entries of the given bucket into its chain of pages, allocating overflow
+// pages when the entries do not fit and freeing the overflow pages that are no longer needed.
+func (h *HashIndex) writeBucket(b *hashBucket) error {
+	var pages []Page
+	offset := PageSize
+	for _, entry := range b.entries {
+		if offset+2+len(entry) > PageSize {
+			pages = append(pages, Page{hashBucketPage})
+			offset = hashPageHeaderSize
+		}
+		page := &pages[len(pages)-1]
+		binary.LittleEndian.PutUint16(page[1:], binary.LittleEndian.Uint16(page[1:])+1)
+		binary.LittleEndian.PutUint16(page[offset:], uint16(len(entry)))
+		offset += 2
+		offset += copy(page[offset:], entry)
+	}
+	if len(pages) == 0 {
+		pages = append(pages, Page{hashBucketPage})
+	}
+
+	for len(b.pageNums) < len(pages) {
+		pageNum, err := allocateIndexPage(h.file, &h.freeList)
+		if err != nil {
+			return err
+		}
+		b.pageNums = append(b.pageNums, pageNum)
+	}
+	for _, pageNum := range b.pageNums[len(pages):] {
+		if err := freeIndexPage(h.file, &h.freeList, pageNum); err != nil {
+			return err
+		}
+	}
+	b.pageNums = b.pageNums[:len(pages)]
+
+	for i := range pages {
+		next := noPage
+		if i+1 < len(pages) {
+			next = b.pageNums[i+1]
+		}
+		binary.LittleEndian.PutUint32(pages[i][3:], next)
+		if _, err := h.file.WritePages(&[]Page{pages[i]}, b.pageNums[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// find returns the index of the entry of the bucket with the given key encoding, or -1 if there is
+// none.
+func (b *hashBucket) find(key []byte) int {
+	for i, entry := range b.entries {
+		if len(entry) == len(key)+btreeAddressSize && bytes.Equal(entry[:len(key)], key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// lookup returns the bucket that stores the given key and the index of its entry in the bucket,
+// or -1 if the key is not stored.
+func (h *HashIndex) lookup(key []any) (*hashBucket, []byte, int, error) {
+	encoded, err := h.encodeKey(key)
+	if err != nil {
+		return nil, nil, -1, err
+	}
+	b, err := h.readBucket(h.bucketIndex(encoded))
+	if err != nil {
+		return nil, nil, -1, err
+	}
+	return b, encoded, b.find(encoded), nil
+}
+
+// Set maps the given key to the given record address, replacing the address the key was mapped to
+// before. The key must have a value for each key column.
+func (h *HashIndex) Set(key []any, address RecordAddress) error {
+	b, encoded, i, err := h.lookup(key)
+	if err != nil {
+		return err
+	}
+	entry := appendAddress(encoded, address)
+	if i >= 0 {
+		b.entries[i] = entry
+		return h.writeBucket(b)
+	}
+	b.entries = append(b.entries, entry)
+	if err = h.writeBucket(b); err != nil {
+		return err
+	}
+	h.numEntries++
+	h.numBytes += uint64(2 + len(entry))
+	if h.numBytes > h.numBuckets()*hashFillSize && h.level < hashMaxLevel {
+		if err = h.splitBucket(); err != nil {
+			return err
+		}
+	}
+	return h.writeMeta()
+}
+
+// splitBucket splits the split bucket into itself and a new bucket, moving the entries whose hash
+// has bit level set to the new bucket, and advances the split bucket.
+func (h *HashIndex) splitBucket() error {
+	b, err := h.readBucket(int(h.split))
+	if err != nil {
+		return err
+	}
+	entries := b.entries
+	b.entries = nil
+	newBucket := &hashBucket{}
+	for _, entry := range entries {
+		if hashKey(entry[:len(entry)-btreeAddressSize])&(1<<h.level) == 0 {
+			b.entries = append(b.entries, entry)
+		} else {
+			newBucket.entries = append(newBucket.entries, entry)
+		}
+	}
+	if err = h.writeBucket(b); err != nil {
+		return err
+	}
+	if err = h.addBucket(newBucket); err != nil {
+		return err
+	}
+	h.split++
+	if uint64(h.split) == 1<<h.level {
+		h.level++
+		h.split = 0
+	}
+	return nil
+}
+
+// Has returns true if the index stores the given key.
+func (h *HashIndex) Has(key []any) (bool, error) {
+	_, _, i, err := h.lookup(key)
+	return i >= 0, err
+}
+
+// Get returns the record address that the given key is mapped to. A KeyNotFoundError is returned
+// if the index does not store the key.
+func (h *HashIndex) Get(key []any) (RecordAddress, error) {
+	b, _, i, err := h.lookup(key)
+	if err != nil {
+		return RecordAddress{}, err
+	}
+	if i < 0 {
+		return RecordAddress{}, &KeyNotFoundError{key}
+	}
+	return decodeAddress(b.entries[i]), nil
+}
+
+// Pop removes the given key from the index and returns the record address it was mapped to. A
+// KeyNotFoundError is returned if the index does not store the key.
+func (h *HashIndex) Pop(key []any) (RecordAddress, error) {
+	b, _, i, err := h.lookup(key)
+	if err != nil {
+		return RecordAddress{}, err
+	}
+	if i < 0 {
+		return RecordAddress{}, &KeyNotFoundError{key}
+	}
+	entry := b.entries[i]
+	b.entries = append(b.entries[:i], b.entries[i+1:]...)
+	if err = h.writeBucket(b); err != nil {
+		return RecordAddress{}, err
+	}
+	h.numEntries--
+	h.numBytes -= uint64(2 + len(entry))
+	return decodeAddress(entry), h.writeMeta()
+}
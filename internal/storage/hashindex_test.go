@@ -0,0 +1,322 @@
+package storage
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// checkHashIndex checks that every entry of the index is stored in the bucket its key hashes to
+// and that the index holds as many entries and bytes as it reports. It returns the number of pages
+// used by buckets.
+func checkHashIndex(t *testing.T, h *HashIndex) int {
+	t.Helper()
+	if uint64(len(h.buckets)) != h.numBuckets() {
+		t.Fatalf("expected %d buckets, got %d", h.numBuckets(), len(h.buckets))
+	}
+	var numEntries, numBytes uint64
+	numPages := 0
+	for i := range h.buckets {
+		b, err := h.readBucket(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		numPages += len(b.pageNums)
+		for _, entry := range b.entries {
+			key := entry[:len(entry)-btreeAddressSize]
+			if j := h.bucketIndex(key); j != i {
+				t.Errorf("key %x stored in bucket %d, expected %d", key, i, j)
+			}
+			if b.find(key) < 0 {
+				t.Errorf("key %x not found in bucket %d", key, i)
+			}
+			numEntries++
+			numBytes += uint64(2 + len(entry))
+		}
+	}
+	if numEntries != h.Length() || numBytes != h.numBytes {
+		t.Errorf(
+			"expected %d entries of %d bytes, got %d of %d",
+			h.Length(), h.numBytes, numEntries, numBytes,
+		)
+	}
+	return numPages
+}
+
+func TestHashIndex_Set(t *testing.T) {
+	t.Run(
+		"check set and get", func(t *testing.T) {
+			h, err := NewHashIndex(&memoryFile{}, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 5000; i++ {
+				if err = h.Set([]any{int64(i), fmt.Sprint(i)}, testAddress(i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if h.Length() != 5000 {
+				t.Errorf("expected 5000 entries, got %d", h.Length())
+			}
+			if len(h.buckets) < 2 {
+				t.Errorf("expected buckets to be split, got %d buckets", len(h.buckets))
+			}
+			checkHashIndex(t, h)
+			for i := 0; i < 5000; i++ {
+				address, err := h.Get([]any{int64(i), fmt.Sprint(i)})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if address != testAddress(i) {
+					t.Errorf("%d: expected %v, got %v", i, testAddress(i), address)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check set replaces address", func(t *testing.T) {
+			h, _ := NewHashIndex(&memoryFile{}, 1)
+			for i := 0; i < 3; i++ {
+				if err := h.Set([]any{"key"}, testAddress(i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if h.Length() != 1 {
+				t.Errorf("expected 1 entry, got %d", h.Length())
+			}
+			if address, _ := h.Get([]any{"key"}); address != testAddress(2) {
+				t.Errorf("expected %v, got %v", testAddress(2), address)
+			}
+			checkHashIndex(t, h)
+		},
+	)
+
+	t.Run(
+		"check overflow pages", func(t *testing.T) {
+			h, _ := NewHashIndex(&memoryFile{}, 1)
+			long := strings.Repeat("x", 1500)
+			for i := 0; i < 200; i++ {
+				if err := h.Set([]any{long + fmt.Sprint(i)}, testAddress(i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			numPages := checkHashIndex(t, h)
+			if numPages <= len(h.buckets) {
+				t.Errorf("expected overflow pages, got %d for %d buckets", numPages, len(h.buckets))
+			}
+			for i := 0; i < 200; i++ {
+				if ok, err := h.Has([]any{long + fmt.Sprint(i)}); !ok || err != nil {
+					t.Errorf("%d: expected key, got %v, %v", i, ok, err)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check directory pages", func(t *testing.T) {
+			h, _ := NewHashIndex(&memoryFile{}, 1)
+			for i := 0; len(h.buckets) <= hashDirectoryCapacity; i++ {
+				key := []any{strings.Repeat("y", 100) + fmt.Sprint(i)}
+				if err := h.Set(key, testAddress(i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(h.directory) != 2 {
+				t.Errorf("expected 2 directory pages, got %d", len(h.directory))
+			}
+			reopened, err := OpenHashIndex(h.file, h.MetaPageNum())
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkHashIndex(t, reopened)
+		},
+	)
+
+	t.Run(
+		"check invalid keys", func(t *testing.T) {
+			h, _ := NewHashIndex(&memoryFile{}, 2)
+			err := h.Set([]any{int32(1)}, testAddress(0))
+			if _, ok := err.(*KeyColumnsError); !ok {
+				t.Errorf("expected KeyColumnsError, got %v", err)
+			}
+			err = h.Set([]any{int32(1), strings.Repeat("z", MaxBTreeKeySize)}, testAddress(0))
+			if _, ok := err.(*KeyTooLargeError); !ok {
+				t.Errorf("expected KeyTooLargeError, got %v", err)
+			}
+			if _, err = h.Has([]any{struct{}{}, 1}); err == nil {
+				t.Error("expected error for unsupported value")
+			}
+			if _, err = NewHashIndex(&memoryFile{}, 0); err == nil {
+				t.Error("expected error for no key columns")
+			}
+		},
+	)
+}
+
+func TestHashIndex_Pop(t *testing.T) {
+	t.Run(
+		"check pop", func(t *testing.T) {
+			h, _ := NewHashIndex(&memoryFile{}, 1)
+			for i := 0; i < 1000; i++ {
+				if err := h.Set([]any{int64(i)}, testAddress(i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i := 0; i < 1000; i += 2 {
+				address, err := h.Pop([]any{int64(i)})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if address != testAddress(i) {
+					t.Errorf("%d: expected %v, got %v", i, testAddress(i), address)
+				}
+			}
+			if h.Length() != 500 {
+				t.Errorf("expected 500 entries, got %d", h.Length())
+			}
+			checkHashIndex(t, h)
+			for i := 0; i < 1000; i++ {
+				ok, err := h.Has([]any{int64(i)})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ok != (i%2 == 1) {
+					t.Errorf("%d: expected %v, got %v", i, i%2 == 1, ok)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check missing key", func(t *testing.T) {
+			h, _ := NewHashIndex(&memoryFile{}, 1)
+			_ = h.Set([]any{"a"}, testAddress(0))
+			if _, err := h.Pop([]any{"b"}); err == nil {
+				t.Error("expected error for missing key")
+			} else if _, ok := err.(*KeyNotFoundError); !ok {
+				t.Errorf("expected KeyNotFoundError, got %v", err)
+			}
+			if _, err := h.Get([]any{"b"}); err == nil {
+				t.Error("expected error for missing key")
+			}
+			if _, err := h.Pop([]any{"a"}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := h.Pop([]any{"a"}); err == nil {
+				t.Error("expected error for popped key")
+			}
+		},
+	)
+
+	t.Run(
+		"check freed overflow pages are reused", func(t *testing.T) {
+			file := &memoryFile{}
+			h, _ := NewHashIndex(file, 1)
+			long := strings.Repeat("x", 1500)
+			for i := 0; i < 200; i++ {
+				_ = h.Set([]any{long + fmt.Sprint(i)}, testAddress(i))
+			}
+			numPages := len(file.pages)
+			for i := 0; i < 200; i++ {
+				if _, err := h.Pop([]any{long + fmt.Sprint(i)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if bucketPages := checkHashIndex(t, h); bucketPages != len(h.buckets) {
+				t.Errorf("expected %d bucket pages, got %d", len(h.buckets), bucketPages)
+			}
+			for i := 0; i < 200; i++ {
+				_ = h.Set([]any{long + fmt.Sprint(i)}, testAddress(i))
+			}
+			if len(file.pages) != numPages {
+				t.Errorf("expected %d pages, got %d", numPages, len(file.pages))
+			}
+			checkHashIndex(t, h)
+		},
+	)
+
+	t.Run(
+		"check random operations", func(t *testing.T) {
+			r := rand.New(rand.NewSource(42))
+			h, _ := NewHashIndex(&memoryFile{}, 1)
+			expected := make(map[string]RecordAddress)
+			for i := 0; i < 20000; i++ {
+				key := fmt.Sprint(r.Intn(3000))
+				if r.Intn(3) == 0 {
+					_, err := h.Pop([]any{key})
+					if _, ok := expected[key]; ok != (err == nil) {
+						t.Fatalf("%s: expected key %v, got %v", key, ok, err)
+					}
+					delete(expected, key)
+					continue
+				}
+				if err := h.Set([]any{key}, testAddress(i)); err != nil {
+					t.Fatal(err)
+				}
+				expected[key] = testAddress(i)
+			}
+			if h.Length() != uint64(len(expected)) {
+				t.Errorf("expected %d entries, got %d", len(expected), h.Length())
+			}
+			checkHashIndex(t, h)
+			for key, address := range expected {
+				if actual, err := h.Get([]any{key}); err != nil || actual != address {
+					t.Errorf("%s: expected %v, got %v, %v", key, address, actual, err)
+				}
+			}
+		},
+	)
+}
+
+func TestOpenHashIndex(t *testing.T) {
+	t.Run(
+		"check reopen", func(t *testing.T) {
+			file := &memoryFile{}
+			_, _ = file.AppendPages(&[]Page{{}})
+			h, err := NewHashIndex(file, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 2000; i++ {
+				_ = h.Set([]any{uint32(i)}, testAddress(i))
+			}
+			reopened, err := OpenHashIndex(file, h.MetaPageNum())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reopened.Length() != 2000 || reopened.level != h.level || reopened.split != h.split {
+				t.Errorf(
+					"expected %d entries, level %d and split %d, got %d, %d and %d",
+					h.Length(), h.level, h.split,
+					reopened.Length(), reopened.level, reopened.split,
+				)
+			}
+			checkHashIndex(t, reopened)
+			if address, err := reopened.Get([]any{uint32(1234)}); address != testAddress(1234) {
+				t.Errorf("expected %v, got %v, %v", testAddress(1234), address, err)
+			}
+		},
+	)
+
+	t.Run(
+		"check corrupt index", func(t *testing.T) {
+			file := &memoryFile{}
+			h, _ := NewHashIndex(file, 1)
+			if _, err := OpenHashIndex(file, h.buckets[0]); err == nil {
+				t.Error("expected error for bucket page")
+			}
+			file.pages[h.MetaPageNum()][3] = 5
+			_, err := OpenHashIndex(file, h.MetaPageNum())
+			if _, ok := err.(*CorruptIndexError); !ok {
+				t.Errorf("expected CorruptIndexError, got %v", err)
+			}
+			file.pages[h.MetaPageNum()][3] = 0
+			file.pages[h.buckets[0]][0] = indexFreePage
+			if _, err = h.Get([]any{"a"}); err == nil {
+				t.Error("expected error for free bucket page")
+			}
+		},
+	)
+}
@@ -0,0 +1,53 @@
+package storage
+
+import "encoding/binary"
+
+/*
+ * Indexes keep the pages they no longer use in a free list, so that the pages are reused before
+ * new pages are appended to the file. A page of a free list starts with a byte storing
+ * indexFreePage, followed by 4 bytes storing the page number of the next page of the list
+ * little-endian, or noPage for the last page. The index stores the page number of the first page.
+ */
+
+const (
+	indexFreePage byte = 'F'
+
+	// noPage is the page number stored in place of a missing page.
+	noPage = ^uint32(0)
+)
+
+// allocateIndexPage returns the number of a page that an index can use, taken from the free list
+// whose first page is stored in freeList, or appended to the file if the list is empty.
+func allocateIndexPage(file PageFile, freeList *uint32) (uint32, error) {
+	if *freeList == noPage {
+		pageNums, err := file.AppendPages(&[]Page{{}})
+		if err != nil {
+			return 0, err
+		}
+		return pageNums[0], nil
+	}
+	pageNum := *freeList
+	pages, err := file.ReadPages(pageNum, 1)
+	if err != nil {
+		return 0, err
+	}
+	page := &(*pages)[0]
+	if page[0] != indexFreePage {
+		return 0, &CorruptIndexError{pageNum, "free list page is in use"}
+	}
+	*freeList = binary.LittleEndian.Uint32(page[1:])
+	return pageNum, nil
+}
+
+// freeIndexPage adds the page with the given number to the free list whose first page is stored in
+// freeList.
+func freeIndexPage(file PageFile, freeList *uint32, pageNum uint32) error {
+	var page Page
+	page[0] = indexFreePage
+	binary.LittleEndian.PutUint32(page[1:], *freeList)
+	if _, err := file.WritePages(&[]Page{page}, pageNum); err != nil {
+		return err
+	}
+	*freeList = pageNum
+	return nil
+}
@@ -0,0 +1,674 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"kyadb/internal/structs/element"
+)
+
+// Records are converted to JSON objects with one member per schema field, in schema order. Values
+// are converted as follows:
+//
+//   - null element positions (offset 0) become null
+//   - integers and floats become numbers; NaN and infinite floats become the strings "NaN",
+//     "Infinity" and "-Infinity"
+//   - bools and strings become booleans and strings
+//   - bytes become base64 encoded strings
+//   - UUIDs become strings in their canonical form
+//   - decimals become numbers with exactly Scale digits after the decimal point
+//   - times and zoned times become RFC 3339 strings, in UTC for times and in their stored time zone
+//     offset for zoned times
+//   - dates become YYYY-MM-DD strings and durations become strings such as "1h30m"
+//   - arrays become arrays and maps become objects, whose member names are the map keys formatted
+//     like the values above
+//
+// A record does not store the types of its elements, so converting a record to JSON needs a schema.
+// Converting JSON to a record can use a schema, or infer one from the JSON values: numbers become
+// int64 values if they are integers and float64 values otherwise, arrays and objects become arrays
+// and string keyed maps whose element type is shared by all their values, or element.AnyType if
+// the values differ, and nulls become fields of element.NullType.
+
+// InvalidJSONValueError is returned when a JSON value cannot be converted to the type that the
+// schema expects.
+type InvalidJSONValueError struct {
+	value     any
+	valueType element.Type
+}
+
+// NDJSONError is returned when a line of newline-delimited JSON cannot be converted to a record.
+type NDJSONError struct {
+	Line int
+	Err  error
+}
+
+func (e *InvalidJSONValueError) Error() string {
+	typeName, err := element.NameForType(e.valueType)
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("cannot convert JSON value %v to %s", e.value, typeName)
+}
+
+func (e *NDJSONError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *NDJSONError) Unwrap() error {
+	return e.Err
+}
+
+// jsonMember is a member of a JSON object. Objects are decoded as slices of members to keep the
+// order of their members.
+type jsonMember struct {
+	name  string
+	value any
+}
+
+type jsonObject []jsonMember
+
+// decodeJSON decodes the next JSON value from the decoder. Objects are decoded as jsonObject,
+// arrays as []any and numbers as json.Number.
+func decodeJSON(dec *json.Decoder) (any, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		object := jsonObject{}
+		for dec.More() {
+			nameToken, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			object = append(object, jsonMember{nameToken.(string), value})
+		}
+		_, err = dec.Token()
+		return object, err
+	case json.Delim('['):
+		array := []any{}
+		for dec.More() {
+			value, err := decodeJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		_, err = dec.Token()
+		return array, err
+	}
+	return token, nil
+}
+
+// parseJSON decodes the single JSON value in the given data.
+func parseJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	value, err := decodeJSON(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after JSON value")
+	}
+	return value, nil
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	// Encode terminates the value with a newline.
+	buf.Truncate(buf.Len() - 1)
+}
+
+// formatFloat formats a float as a JSON number, or as a string for values JSON cannot represent.
+func formatFloat(f float64, bitSize int) (string, bool) {
+	switch {
+	case math.IsNaN(f):
+		return "NaN", false
+	case math.IsInf(f, 1):
+		return "Infinity", false
+	case math.IsInf(f, -1):
+		return "-Infinity", false
+	}
+	return strconv.FormatFloat(f, 'g', -1, bitSize), true
+}
+
+// formatJSONText returns the given primitive value formatted as text. The second return value is
+// true if the text is a valid JSON number.
+func formatJSONText(value any) (string, bool, error) {
+	switch v := value.(type) {
+	case uint8, uint16, uint32, uint64, int8, int16, int32, int64:
+		return fmt.Sprint(v), true, nil
+	case float32:
+		text, isNumber := formatFloat(float64(v), 32)
+		return text, isNumber, nil
+	case float64:
+		text, isNumber := formatFloat(v, 64)
+		return text, isNumber, nil
+	case bool:
+		return strconv.FormatBool(v), false, nil
+	case string:
+		return v, false, nil
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v), false, nil
+	case element.UUID:
+		return v.String(), false, nil
+	case element.Decimal:
+		return v.String(), true, nil
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano), false, nil
+	case element.Date:
+		return v.String(), false, nil
+	case time.Duration:
+		return v.String(), false, nil
+	case element.ZonedTime:
+		return v.Format(time.RFC3339Nano), false, nil
+	}
+	return "", false, fmt.Errorf("unsupported type %T", value)
+}
+
+// writeJSONValue writes the given value as JSON.
+func writeJSONValue(buf *bytes.Buffer, value any) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case element.Array:
+		buf.WriteByte('[')
+		for i, elem := range v.Values {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSONValue(buf, elem); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case element.Map:
+		buf.WriteByte('{')
+		for i, key := range element.SortedKeys(v.Data) {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			name, _, err := formatJSONText(key)
+			if key == nil {
+				name, err = "null", nil
+			}
+			if err != nil {
+				return err
+			}
+			writeJSONString(buf, name)
+			buf.WriteByte(':')
+			if err = writeJSONValue(buf, v.Data[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		text, isNumber, err := formatJSONText(value)
+		if err != nil {
+			return err
+		}
+		if isNumber {
+			buf.WriteString(text)
+		} else {
+			writeJSONString(buf, text)
+		}
+	}
+	return nil
+}
+
+// writeRecordJSON writes the given record as a JSON object with a member for each schema field.
+// Fields beyond the element positions of the record are written as null.
+func writeRecordJSON(buf *bytes.Buffer, r *Record, schema Schema) error {
+	buf.WriteByte('{')
+	for i, field := range schema {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeJSONString(buf, field.Name)
+		buf.WriteByte(':')
+		position := ElementPosition(i)
+		if position >= r.numElements() {
+			buf.WriteString("null")
+			continue
+		}
+		_, value, err := r.getValue(position, field.Type)
+		if err != nil {
+			return err
+		}
+		if err = writeJSONValue(buf, value); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// RecordToJSON returns the record as a JSON object with a member for each field of the schema.
+func RecordToJSON(r *Record, schema Schema) ([]byte, error) {
+	if err := r.checkHeader(); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := writeRecordJSON(&buf, r, schema); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// inferJSONValue converts a decoded JSON value to an element value, inferring its type.
+func inferJSONValue(value any) (any, ValueType) {
+	switch v := value.(type) {
+	case nil:
+		return nil, ValueType{Type: element.NullType}
+	case bool:
+		return v, ValueType{Type: element.BoolType}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, ValueType{Type: element.Int64Type}
+		}
+		f, _ := v.Float64()
+		return f, ValueType{Type: element.Float64Type}
+	case string:
+		return v, ValueType{Type: element.StringType}
+	case []any:
+		values := make([]any, len(v))
+		valueTypes := make([]ValueType, len(v))
+		for i, elem := range v {
+			values[i], valueTypes[i] = inferJSONValue(elem)
+		}
+		elemType := commonValueType(valueTypes)
+		return element.Array{ElementType: elemType.Type, Values: values},
+			ValueType{Type: element.ArrayType, Elem: &elemType}
+	case jsonObject:
+		data := make(map[any]any, len(v))
+		valueTypes := make([]ValueType, 0, len(v))
+		for _, member := range v {
+			var valueType ValueType
+			data[member.name], valueType = inferJSONValue(member.value)
+			valueTypes = append(valueTypes, valueType)
+		}
+		valueType := commonValueType(valueTypes)
+		return element.Map{KeyType: element.StringType, ValueType: valueType.Type, Data: data},
+			ValueType{Type: element.MapType, Key: element.StringType, Elem: &valueType}
+	}
+	return nil, ValueType{Type: element.NullType}
+}
+
+// commonValueType returns the value type shared by all the given value types, or element.AnyType
+// if they differ, contain nulls or are empty.
+func commonValueType(valueTypes []ValueType) ValueType {
+	if len(valueTypes) == 0 {
+		return ValueType{Type: element.AnyType}
+	}
+	for _, valueType := range valueTypes {
+		if valueType.Type != valueTypes[0].Type || valueType.Type == element.NullType {
+			return ValueType{Type: element.AnyType}
+		}
+	}
+	return ValueType{Type: valueTypes[0].Type}
+}
+
+// jsonText returns the text of a JSON string or number.
+func jsonText(value any) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	}
+	return "", false
+}
+
+// parseJSONText converts the given text, a JSON string, a JSON number or a JSON object member name,
+// to a primitive value of the given value type.
+func parseJSONText(text string, valueType *ValueType) (any, error) {
+	var value any
+	var err error
+	switch valueType.Type {
+	case element.Uint8Type:
+		var u uint64
+		u, err = strconv.ParseUint(text, 10, 8)
+		value = uint8(u)
+	case element.Uint16Type:
+		var u uint64
+		u, err = strconv.ParseUint(text, 10, 16)
+		value = uint16(u)
+	case element.Uint32Type:
+		var u uint64
+		u, err = strconv.ParseUint(text, 10, 32)
+		value = uint32(u)
+	case element.Uint64Type:
+		value, err = strconv.ParseUint(text, 10, 64)
+	case element.Int8Type:
+		var i int64
+		i, err = strconv.ParseInt(text, 10, 8)
+		value = int8(i)
+	case element.Int16Type:
+		var i int64
+		i, err = strconv.ParseInt(text, 10, 16)
+		value = int16(i)
+	case element.Int32Type:
+		var i int64
+		i, err = strconv.ParseInt(text, 10, 32)
+		value = int32(i)
+	case element.Int64Type:
+		value, err = strconv.ParseInt(text, 10, 64)
+	case element.Float32Type, element.Float64Type:
+		var f float64
+		switch text {
+		case "NaN":
+			f = math.NaN()
+		case "Infinity":
+			f = math.Inf(1)
+		case "-Infinity":
+			f = math.Inf(-1)
+		default:
+			if valueType.Type == element.Float32Type {
+				f, err = strconv.ParseFloat(text, 32)
+			} else {
+				f, err = strconv.ParseFloat(text, 64)
+			}
+		}
+		if valueType.Type == element.Float32Type {
+			value = float32(f)
+		} else {
+			value = f
+		}
+	case element.BoolType:
+		value, err = strconv.ParseBool(text)
+	case element.StringType:
+		value = text
+	case element.BytesType:
+		value, err = base64.StdEncoding.DecodeString(text)
+	case element.UUIDType:
+		value, err = element.ParseUUID(text)
+	case element.DecimalType:
+		precision, scale := valueType.Precision, valueType.Scale
+		if precision == 0 {
+			precision = element.MaxDecimalPrecision
+			if _, fractional, found := strings.Cut(text, "."); found {
+				scale = uint8(len(fractional))
+			}
+		}
+		value, err = element.ParseDecimal(text, precision, scale)
+	case element.TimeType:
+		value, err = time.Parse(time.RFC3339Nano, text)
+	case element.DateType:
+		var t time.Time
+		t, err = time.Parse("2006-01-02", text)
+		value = element.DateOf(t)
+	case element.DurationType:
+		value, err = time.ParseDuration(text)
+	case element.ZonedTimeType:
+		var t time.Time
+		t, err = time.Parse(time.RFC3339Nano, text)
+		value = element.ZonedTime{Time: t}
+	default:
+		err = &InvalidJSONValueError{text, valueType.Type}
+	}
+	if err != nil {
+		return nil, &InvalidJSONValueError{text, valueType.Type}
+	}
+	return value, nil
+}
+
+// convertJSONValue converts a decoded JSON value to an element value of the given value type.
+// The type is inferred from the value if valueType is nil or element.AnyType.
+func convertJSONValue(value any, valueType *ValueType) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if valueType == nil || valueType.Type == element.AnyType {
+		converted, _ := inferJSONValue(value)
+		return converted, nil
+	}
+	switch valueType.Type {
+	case element.ArrayType:
+		array, ok := value.([]any)
+		if !ok {
+			return nil, &InvalidJSONValueError{value, valueType.Type}
+		}
+		if valueType.Elem == nil {
+			converted, _ := inferJSONValue(array)
+			return converted, nil
+		}
+		values := make([]any, len(array))
+		for i, elem := range array {
+			var err error
+			if values[i], err = convertJSONValue(elem, valueType.Elem); err != nil {
+				return nil, err
+			}
+		}
+		return element.Array{ElementType: valueType.Elem.Type, Values: values}, nil
+	case element.MapType:
+		object, ok := value.(jsonObject)
+		if !ok {
+			return nil, &InvalidJSONValueError{value, valueType.Type}
+		}
+		keyType := ValueType{Type: valueType.Key}
+		if keyType.Type == element.NullType {
+			keyType.Type = element.StringType
+		}
+		elemType := valueType.Elem
+		if elemType == nil {
+			_, inferred := inferJSONValue(object)
+			elemType = inferred.Elem
+		}
+		data := make(map[any]any, len(object))
+		for _, member := range object {
+			var key any
+			var err error
+			if keyType.Type == element.AnyType {
+				key = member.name
+			} else if key, err = parseJSONText(member.name, &keyType); err != nil {
+				return nil, err
+			}
+			if data[key], err = convertJSONValue(member.value, elemType); err != nil {
+				return nil, err
+			}
+		}
+		return element.Map{KeyType: keyType.Type, ValueType: elemType.Type, Data: data}, nil
+	case element.BoolType:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+		return nil, &InvalidJSONValueError{value, valueType.Type}
+	}
+	text, ok := jsonText(value)
+	if !ok {
+		return nil, &InvalidJSONValueError{value, valueType.Type}
+	}
+	return parseJSONText(text, valueType)
+}
+
+// recordFromJSONObject converts a decoded JSON object to a record. Members whose names are not
+// part of the schema are returned as an UnknownFieldError, unless extend is true, in which case
+// they are added to the returned schema with an inferred type. With extend, fields of
+// element.NullType also take the inferred type of the first non-null value given for them.
+func recordFromJSONObject(
+	object jsonObject, schema Schema, extend bool, encoding element.Encoding,
+) (*Record, Schema, error) {
+	values := make([]any, len(schema), len(schema)+len(object))
+	for _, member := range object {
+		position, err := schema.position(member.name)
+		if err != nil && !extend {
+			return nil, schema, err
+		}
+		if err != nil || (extend && schema[position].Type == element.NullType) {
+			value, valueType := inferJSONValue(member.value)
+			if err != nil {
+				schema = append(schema, Field{member.name, valueType})
+				values = append(values, value)
+				continue
+			}
+			schema[position].ValueType = valueType
+		}
+		values[position], err = convertJSONValue(member.value, &schema[position].ValueType)
+		if err != nil {
+			return nil, schema, err
+		}
+	}
+
+	r := NewRecordWithEncoding(uint16(len(schema)), encoding)
+	for i, value := range values {
+		if err := r.setValue(ElementPosition(i), value); err != nil {
+			return nil, schema, err
+		}
+	}
+	return r, schema, nil
+}
+
+// RecordFromJSON converts a JSON object to a record whose elements are stored in the given
+// encoding, with the value of each member stored at the element position of the schema field with
+// the member's name. Fields without a member are left null.
+//
+// If the schema is nil, a schema with a field for each member, in the order of the members, is
+// inferred from the JSON values. The schema used is returned along with the record.
+func RecordFromJSON(
+	data []byte, schema Schema, encoding element.Encoding,
+) (*Record, Schema, error) {
+	value, err := parseJSON(data)
+	if err != nil {
+		return nil, schema, err
+	}
+	object, ok := value.(jsonObject)
+	if !ok {
+		return nil, schema, &InvalidJSONValueError{value, element.MapType}
+	}
+	if schema == nil {
+		return recordFromJSONObject(object, Schema{}, true, encoding)
+	}
+	return recordFromJSONObject(object, schema, false, encoding)
+}
+
+// NDJSONWriter writes records as newline-delimited JSON, one JSON object per line.
+type NDJSONWriter struct {
+	w      *bufio.Writer
+	schema Schema
+	buf    bytes.Buffer
+}
+
+// NewNDJSONWriter returns an NDJSONWriter that writes records described by the given schema to w.
+// Flush must be called after the last record is written.
+func NewNDJSONWriter(w io.Writer, schema Schema) *NDJSONWriter {
+	return &NDJSONWriter{w: bufio.NewWriter(w), schema: schema}
+}
+
+// Write writes the given record as a line of JSON.
+func (w *NDJSONWriter) Write(r *Record) error {
+	if err := r.checkHeader(); err != nil {
+		return err
+	}
+	w.buf.Reset()
+	if err := writeRecordJSON(&w.buf, r, w.schema); err != nil {
+		return err
+	}
+	w.buf.WriteByte('\n')
+	_, err := w.w.Write(w.buf.Bytes())
+	return err
+}
+
+// WritePage writes the records stored on the given page in slot order. Deleted records and records
+// that have been moved to another page are skipped.
+func (w *NDJSONWriter) WritePage(p *TablePage) error {
+	for slotNum := uint16(0); slotNum < p.getNumSlots(); slotNum++ {
+		r, forwarded, err := p.GetRecord(slotNum)
+		if _, deleted := err.(*RecordDeletedError); deleted || forwarded != nil {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = w.Write(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (w *NDJSONWriter) Flush() error {
+	return w.w.Flush()
+}
+
+// NDJSONReader reads records from newline-delimited JSON, one JSON object per line.
+type NDJSONReader struct {
+	r        *bufio.Reader
+	schema   Schema
+	extend   bool
+	encoding element.Encoding
+	line     int
+}
+
+// NewNDJSONReader returns an NDJSONReader that reads records from r, storing their elements in the
+// given encoding.
+//
+// If the schema is nil, it is inferred from the lines as they are read: members with new names are
+// added to the schema and fields that have only been null so far take the type of their first
+// non-null value. Records read before a field was added have fewer element positions than the
+// final schema; the missing positions are treated as null when the records are written as JSON.
+func NewNDJSONReader(r io.Reader, schema Schema, encoding element.Encoding) *NDJSONReader {
+	return &NDJSONReader{
+		r:        bufio.NewReader(r),
+		schema:   schema,
+		extend:   schema == nil,
+		encoding: encoding,
+	}
+}
+
+// Schema returns the schema of the records read so far.
+func (r *NDJSONReader) Schema() Schema {
+	return r.schema
+}
+
+// Read returns the record on the next non-empty line. io.EOF is returned when there are no more
+// lines. Errors converting a line are returned as an NDJSONError.
+func (r *NDJSONReader) Read() (*Record, error) {
+	for {
+		data, err := r.r.ReadBytes('\n')
+		if len(bytes.TrimSpace(data)) == 0 {
+			if err != nil {
+				return nil, err
+			}
+			r.line++
+			continue
+		}
+		r.line++
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		value, err := parseJSON(data)
+		if err != nil {
+			return nil, &NDJSONError{r.line, err}
+		}
+		object, ok := value.(jsonObject)
+		if !ok {
+			return nil, &NDJSONError{r.line, &InvalidJSONValueError{value, element.MapType}}
+		}
+		record, schema, err := recordFromJSONObject(object, r.schema, r.extend, r.encoding)
+		if err != nil {
+			return nil, &NDJSONError{r.line, err}
+		}
+		r.schema = schema
+		return record, nil
+	}
+}
@@ -0,0 +1,390 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"kyadb/internal/structs/element"
+)
+
+func jsonTestSchema() Schema {
+	return Schema{
+		{"u8", ValueType{Type: element.Uint8Type}},
+		{"u16", ValueType{Type: element.Uint16Type}},
+		{"u32", ValueType{Type: element.Uint32Type}},
+		{"u64", ValueType{Type: element.Uint64Type}},
+		{"i8", ValueType{Type: element.Int8Type}},
+		{"i16", ValueType{Type: element.Int16Type}},
+		{"i32", ValueType{Type: element.Int32Type}},
+		{"i64", ValueType{Type: element.Int64Type}},
+		{"f32", ValueType{Type: element.Float32Type}},
+		{"f64", ValueType{Type: element.Float64Type}},
+		{"bool", ValueType{Type: element.BoolType}},
+		{"string", ValueType{Type: element.StringType}},
+		{"bytes", ValueType{Type: element.BytesType}},
+		{"uuid", ValueType{Type: element.UUIDType}},
+		{"decimal", ValueType{Type: element.DecimalType, Precision: 10, Scale: 2}},
+		{"time", ValueType{Type: element.TimeType}},
+		{"date", ValueType{Type: element.DateType}},
+		{"duration", ValueType{Type: element.DurationType}},
+		{"zoned", ValueType{Type: element.ZonedTimeType}},
+		{"array", ValueType{Type: element.ArrayType}},
+		{"map", ValueType{Type: element.MapType}},
+		{"null", ValueType{Type: element.StringType}},
+	}
+}
+
+func jsonTestRecord(t *testing.T, encoding element.Encoding) *Record {
+	r := NewRecordWithEncoding(22, encoding)
+	r.SetUint8(0, 255)
+	r.SetUint16(1, 65535)
+	r.SetUint32(2, 1<<32-1)
+	r.SetUint64(3, 1<<64-1)
+	r.SetInt8(4, -128)
+	r.SetInt16(5, -32768)
+	r.SetInt32(6, -1<<31)
+	r.SetInt64(7, -1<<63)
+	r.SetFloat32(8, 1.5)
+	r.SetFloat64(9, math.Inf(-1))
+	r.SetBool(10, true)
+	if err := r.SetString(11, "<héllo \"world\">\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetBytes(12, []byte{0, 1, 254, 255}); err != nil {
+		t.Fatal(err)
+	}
+	uuid, _ := element.ParseUUID("123e4567-e89b-12d3-a456-426614174000")
+	r.SetUUID(13, uuid)
+	decimal, _ := element.ParseDecimal("-12.30", 10, 2)
+	r.SetDecimal(14, decimal)
+	if err := r.SetTime(15, time.Date(2023, 4, 5, 6, 7, 8, 9, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	r.SetDate(16, element.DateOf(time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC)))
+	r.SetDuration(17, 90*time.Minute+time.Nanosecond)
+	zone := time.FixedZone("", -(5*3600 + 30*60))
+	r.SetZonedTime(18, element.ZonedTime{Time: time.Date(2023, 4, 5, 6, 7, 8, 0, zone)})
+	err := r.SetArray(
+		19, element.Array{
+			ElementType: element.AnyType,
+			Values: []any{
+				nil, int64(1), "a", element.Array{
+					ElementType: element.Float64Type,
+					Values:      []any{0.5, math.NaN()},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.SetMap(
+		20, element.Map{
+			KeyType:   element.StringType,
+			ValueType: element.MapType,
+			Data: map[any]any{
+				"b": element.Map{
+					KeyType:   element.Int64Type,
+					ValueType: element.BoolType,
+					Data:      map[any]any{int64(2): true},
+				},
+				"a": element.Map{
+					KeyType:   element.StringType,
+					ValueType: element.AnyType,
+					Data:      map[any]any{},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+const jsonTestRecordJSON = `{"u8":255,"u16":65535,"u32":4294967295,"u64":18446744073709551615,` +
+	`"i8":-128,"i16":-32768,"i32":-2147483648,"i64":-9223372036854775808,"f32":1.5,` +
+	`"f64":"-Infinity","bool":true,"string":"<héllo \"world\">\n","bytes":"AAH+/w==",` +
+	`"uuid":"123e4567-e89b-12d3-a456-426614174000","decimal":-12.30,` +
+	`"time":"2023-04-05T06:07:08.000000009Z","date":"1969-12-31","duration":"1h30m0.000000001s",` +
+	`"zoned":"2023-04-05T06:07:08-05:30","array":[null,1,"a",[0.5,"NaN"]],` +
+	`"map":{"a":{},"b":{"2":true}},"null":null}`
+
+func TestRecordToJSON(t *testing.T) {
+	t.Run(
+		"check all element types", func(t *testing.T) {
+			for _, encoding := range []element.Encoding{
+				element.LegacyEncoding, element.WideEncoding, element.CompactEncoding,
+			} {
+				data, err := RecordToJSON(jsonTestRecord(t, encoding), jsonTestSchema())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != jsonTestRecordJSON {
+					t.Errorf("expected %s, got %s", jsonTestRecordJSON, data)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check fields past the end of the record", func(t *testing.T) {
+			r := NewRecord(1)
+			r.SetBool(0, false)
+			schema := Schema{
+				{"a", ValueType{Type: element.BoolType}}, {"b", ValueType{Type: element.Int32Type}},
+			}
+			data, err := RecordToJSON(r, schema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := `{"a":false,"b":null}`
+			if string(data) != expected {
+				t.Errorf("expected %s, got %s", expected, data)
+			}
+		},
+	)
+}
+
+func TestRecordFromJSON(t *testing.T) {
+	t.Run(
+		"check round trip of all element types", func(t *testing.T) {
+			schema := jsonTestSchema()
+			schema[19].Elem = &ValueType{Type: element.AnyType}
+			mapValues := ValueType{Type: element.MapType, Key: element.AnyType}
+			schema[20].Elem = &mapValues
+			for _, encoding := range []element.Encoding{
+				element.LegacyEncoding, element.WideEncoding, element.CompactEncoding,
+			} {
+				r, _, err := RecordFromJSON([]byte(jsonTestRecordJSON), schema, encoding)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if r.Encoding() != encoding {
+					t.Errorf("expected encoding %v, got %v", encoding, r.Encoding())
+				}
+				data, err := RecordToJSON(r, schema)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != jsonTestRecordJSON {
+					t.Errorf("expected %s, got %s", jsonTestRecordJSON, data)
+				}
+
+				expected := jsonTestRecord(t, encoding)
+				for _, position := range []ElementPosition{3, 7, 11, 12, 13, 14, 16, 17} {
+					_, expectedValue, _ := expected.getValue(position, schema[position].Type)
+					_, value, err := r.getValue(position, schema[position].Type)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !reflect.DeepEqual(value, expectedValue) {
+						t.Errorf("expected %v, got %v", expectedValue, value)
+					}
+				}
+				_, zoned, _ := r.GetZonedTime(18)
+				_, expectedZoned, _ := expected.GetZonedTime(18)
+				_, offset := zoned.Zone()
+				if !zoned.Equal(expectedZoned.Time) || offset != -(5*3600+30*60) {
+					t.Errorf("expected %v, got %v", expectedZoned, zoned)
+				}
+				isNull, _, _ := r.GetString(21)
+				if !isNull {
+					t.Error("expected null")
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check inferred schema", func(t *testing.T) {
+			data := `{"id":1,"score":2.5,"name":"a","ok":true,"tags":["x","y"],` +
+				`"attrs":{"k":1,"l":"m"},"none":null,"empty":[]}`
+			r, schema, err := RecordFromJSON([]byte(data), nil, element.CompactEncoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var types []element.Type
+			for _, field := range schema {
+				types = append(types, field.Type)
+			}
+			expectedTypes := []element.Type{
+				element.Int64Type, element.Float64Type, element.StringType, element.BoolType,
+				element.ArrayType, element.MapType, element.NullType, element.ArrayType,
+			}
+			if !reflect.DeepEqual(types, expectedTypes) {
+				t.Errorf("expected types %v, got %v", expectedTypes, types)
+			}
+			_, tags, err := r.GetArray(4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedTags := element.Array{ElementType: element.StringType, Values: []any{"x", "y"}}
+			if !reflect.DeepEqual(tags, expectedTags) {
+				t.Errorf("expected %v, got %v", expectedTags, tags)
+			}
+			_, attrs, err := r.GetMap(5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attrs.ValueType != element.AnyType {
+				t.Errorf("expected value type %v, got %v", element.AnyType, attrs.ValueType)
+			}
+			exported, err := RecordToJSON(r, schema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(exported) != data {
+				t.Errorf("expected %s, got %s", data, exported)
+			}
+		},
+	)
+
+	t.Run(
+		"check invalid values", func(t *testing.T) {
+			schema := Schema{
+				{"a", ValueType{Type: element.Uint8Type}},
+				{"b", ValueType{Type: element.ArrayType}},
+				{"c", ValueType{Type: element.TimeType}},
+			}
+			for _, data := range []string{
+				`{"a":256}`, `{"a":-1}`, `{"a":"x"}`, `{"b":{}}`, `{"c":"yesterday"}`, `[1]`,
+			} {
+				_, _, err := RecordFromJSON([]byte(data), schema, element.WideEncoding)
+				if _, ok := err.(*InvalidJSONValueError); !ok {
+					t.Errorf("%s: expected InvalidJSONValueError, got %v", data, err)
+				}
+			}
+			_, _, err := RecordFromJSON([]byte(`{"d":1}`), schema, element.WideEncoding)
+			if _, ok := err.(*UnknownFieldError); !ok {
+				t.Errorf("expected UnknownFieldError, got %v", err)
+			}
+			_, _, err = RecordFromJSON([]byte(`{"a":1} {}`), schema, element.WideEncoding)
+			if err == nil {
+				t.Error("expected error")
+			}
+		},
+	)
+}
+
+func TestNDJSON(t *testing.T) {
+	t.Run(
+		"check round trip of a page", func(t *testing.T) {
+			schema := Schema{
+				{"id", ValueType{Type: element.Int32Type}},
+				{"name", ValueType{Type: element.StringType}},
+			}
+			page := NewTablePage()
+			for i, name := range []string{"a", "b", "c"} {
+				r := NewRecord(2)
+				r.SetInt32(0, int32(i))
+				if err := r.SetString(1, name); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := page.AddRecord(r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			page.DeleteRecord(1)
+
+			var buf bytes.Buffer
+			w := NewNDJSONWriter(&buf, schema)
+			if err := w.WritePage(page); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			expected := "{\"id\":0,\"name\":\"a\"}\n{\"id\":2,\"name\":\"c\"}\n"
+			if buf.String() != expected {
+				t.Errorf("expected %q, got %q", expected, buf.String())
+			}
+
+			reader := NewNDJSONReader(&buf, schema, element.LegacyEncoding)
+			var names []string
+			for {
+				r, err := reader.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, name, err := r.GetString(1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, name)
+			}
+			if !reflect.DeepEqual(names, []string{"a", "c"}) {
+				t.Errorf("expected [a c], got %v", names)
+			}
+		},
+	)
+
+	t.Run(
+		"check inferred schema", func(t *testing.T) {
+			input := "{\"a\":null}\n\n{\"a\":1,\"b\":\"x\"}\n{\"b\":\"y\"}"
+			reader := NewNDJSONReader(strings.NewReader(input), nil, element.WideEncoding)
+			var records []*Record
+			for {
+				r, err := reader.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				records = append(records, r)
+			}
+			expectedSchema := Schema{
+				{"a", ValueType{Type: element.Int64Type}},
+				{"b", ValueType{Type: element.StringType}},
+			}
+			if !reflect.DeepEqual(reader.Schema(), expectedSchema) {
+				t.Errorf("expected schema %v, got %v", expectedSchema, reader.Schema())
+			}
+
+			var buf bytes.Buffer
+			w := NewNDJSONWriter(&buf, reader.Schema())
+			for _, r := range records {
+				if err := w.Write(r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			expected := "{\"a\":null,\"b\":null}\n{\"a\":1,\"b\":\"x\"}\n{\"a\":null,\"b\":\"y\"}\n"
+			if buf.String() != expected {
+				t.Errorf("expected %q, got %q", expected, buf.String())
+			}
+		},
+	)
+
+	t.Run(
+		"check line number of errors", func(t *testing.T) {
+			schema := Schema{{"a", ValueType{Type: element.BoolType}}}
+			input := "{\"a\":true}\n\n{\"a\":1}\n"
+			reader := NewNDJSONReader(strings.NewReader(input), schema, element.WideEncoding)
+			if _, err := reader.Read(); err != nil {
+				t.Fatal(err)
+			}
+			_, err := reader.Read()
+			var ndjsonErr *NDJSONError
+			if !errors.As(err, &ndjsonErr) || ndjsonErr.Line != 3 {
+				t.Fatalf("expected NDJSONError on line 3, got %v", err)
+			}
+			var valueErr *InvalidJSONValueError
+			if !errors.As(err, &valueErr) {
+				t.Errorf("expected InvalidJSONValueError, got %v", err)
+			}
+		},
+	)
+}
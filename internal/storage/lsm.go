@@ -0,0 +1,1203 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+/*
+ * An LSMTree is a log-structured merge tree: a sorted map from byte string keys to byte string
+ * values that buffers writes in memory and writes them to disk in sorted runs, the SSTables, stored
+ * in the pages of a PageFile.
+ *
+ * Writes go to the memtable, a sorted table of the latest entries held in memory. Once it holds
+ * MemtableSize bytes, it is frozen and a background goroutine writes it to a new SSTable on level
+ * 0, while writes go to a new memtable. Deleting a key writes a tombstone, which hides the older
+ * values of the key until a compaction drops it.
+ *
+ * The background goroutine also compacts the SSTables, merging them into fewer and larger tables
+ * on deeper levels. The newer entries of a key are always on lower levels, or earlier in the list
+ * of tables of a level, so a lookup reads the memtables and then the tables in that order and
+ * stops at the first entry of the key. The bloom filter of a table lets lookups skip the tables
+ * that do not store the key.
+ *
+ * With LeveledCompaction, the tables of each level from level 1 on have disjoint key ranges, and
+ * each level holds up to LevelRatio times more bytes than the one above it. When level 0 has
+ * LevelTables tables, they are merged with the overlapping tables of level 1 into tables of about
+ * TableSize bytes. When a deeper level is over its size, one of its tables, taken in key order, is
+ * merged with the overlapping tables of the next level. With TieredCompaction, each level holds up
+ * to LevelTables runs with overlapping key ranges, which are merged into a single table on the
+ * next level once the level is full. Leveled compaction reads fewer tables per lookup, and tiered
+ * compaction rewrites entries less often.
+ *
+ * A compaction drops tombstones when no table that is not merged can hold an older entry of the
+ * key.
+ *
+ * The manifest of a tree lists the tables of each level. It is stored on a chain of pages whose
+ * first page is the meta page of the tree. It starts with a byte storing the version of the
+ * format and a byte storing the compaction strategy, followed by LevelTables, LevelRatio,
+ * MemtableSize and TableSize as unsigned varints, 8 bytes storing the false positive rate as
+ * float64 bits, 4 bytes storing the page number of the first page of the free list, and the
+ * largest key written to the tables, prefixed with its length as an unsigned varint. Then for each
+ * of the lsmMaxLevels levels, it stores the number of tables as an unsigned varint and 4 bytes
+ * storing the number of the first meta page of each table, newest first. The pages of compacted
+ * tables are added to the free list and reused.
+ *
+ * The entries of the memtables are lost if the tree is not flushed or closed.
+ */
+
+// CompactionStrategy selects how an LSMTree merges its SSTables.
+type CompactionStrategy uint8
+
+const (
+	// LeveledCompaction keeps the tables of each level but level 0 disjoint, and merges tables
+	// into the next level as soon as a level is over its size.
+	LeveledCompaction CompactionStrategy = iota
+
+	// TieredCompaction lets a level accumulate tables with overlapping keys, and merges them all
+	// into the next level once it is full.
+	TieredCompaction
+)
+
+const (
+	lsmManifestPage byte = 'R'
+
+	lsmVersion = 1
+
+	// lsmMaxLevels is the number of levels of a tree. The last level has no size limit.
+	lsmMaxLevels = 7
+
+	// lsmEntryOverhead is the number of bytes that an entry of a memtable is counted for on top
+	// of its key and value.
+	lsmEntryOverhead = 32
+)
+
+// Defaults of the options of an LSMTree.
+const (
+	DefaultMemtableSize = 1 << 20
+	DefaultLSMTableSize = 2 << 20
+	DefaultLevelTables  = 4
+	DefaultLevelRatio   = 10
+)
+
+// LSMOptions describes how an LSMTree buffers and compacts its entries.
+type LSMOptions struct {
+	// MemtableSize is the number of bytes of entries that the memtable holds before it is written
+	// to an SSTable. Zero means DefaultMemtableSize.
+	MemtableSize int
+
+	// TableSize is the size in bytes at which leveled compaction starts a new SSTable. Zero
+	// means DefaultLSMTableSize.
+	TableSize int
+
+	// Compaction is the strategy that merges the SSTables.
+	Compaction CompactionStrategy
+
+	// LevelTables is the number of tables of level 0, or of any level with TieredCompaction,
+	// that triggers a compaction. Zero means DefaultLevelTables.
+	LevelTables int
+
+	// LevelRatio is the ratio between the sizes of consecutive levels with LeveledCompaction.
+	// Level 1 holds LevelRatio times TableSize bytes. Zero means DefaultLevelRatio.
+	LevelRatio int
+
+	// FalsePositiveRate is the false positive rate of the bloom filters of the SSTables. Zero
+	// means DefaultFalsePositiveRate.
+	FalsePositiveRate float64
+}
+
+// LSMTree is a log-structured merge tree of byte string keys and values stored in the pages of a
+// PageFile, which compacts its SSTables in a background goroutine. A tree is safe for concurrent
+// use. Close stops its goroutine.
+type LSMTree struct {
+	file          PageFile
+	metaPageNum   uint32
+	options       LSMOptions
+	manifestPages []uint32
+	freeList      uint32
+	maxKey        []byte
+
+	mutex       sync.Mutex
+	changed     *sync.Cond
+	memtable    *memtable
+	frozen      *memtable
+	levels      [lsmMaxLevels][]*sstable
+	compactKeys [lsmMaxLevels][]byte
+	err         error
+	closed      bool
+	done        chan struct{}
+}
+
+// memtable is a sorted table of the latest entries of an LSMTree, held in memory.
+type memtable struct {
+	entries []lsmEntry
+	size    int
+	maxKey  []byte
+}
+
+// memtableIterator iterates over the entries of a memtable in key order.
+type memtableIterator struct {
+	entries []lsmEntry
+	pos     int
+}
+
+// lsmIterator iterates over the entries of a memtable or of SSTables in key order.
+type lsmIterator interface {
+	// next returns the next entry, or false after the last entry.
+	next() (lsmEntry, bool, error)
+}
+
+// mergeIterator iterates in key order over the entries of other iterators, which are given from
+// newest to oldest. Of the entries of a key, only the newest is returned.
+type mergeIterator struct {
+	sources []lsmIterator
+	heads   []lsmEntry
+	valid   []bool
+}
+
+// lsmCompaction is a merge of the tables of an LSMTree into a level.
+type lsmCompaction struct {
+	level          int
+	output         int
+	inputs         []*sstable
+	split          bool
+	dropTombstones bool
+}
+
+// syncFile is a PageFile that serializes the calls to another PageFile, so that the background
+// goroutine of an LSMTree can use the file along with the other structures stored in it.
+type syncFile struct {
+	mutex sync.Mutex
+	file  PageFile
+}
+
+// TreeClosedError is returned when an LSMTree is used after it was closed.
+type TreeClosedError struct{}
+
+func (e *TreeClosedError) Error() string {
+	return "LSM tree is closed"
+}
+
+// newSyncFile returns the given file with its calls serialized.
+func newSyncFile(file PageFile) PageFile {
+	if _, ok := file.(*syncFile); ok {
+		return file
+	}
+	return &syncFile{file: file}
+}
+
+func (f *syncFile) AppendPages(pages *[]Page) ([]uint32, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.AppendPages(pages)
+}
+
+func (f *syncFile) ReadPages(pageNum uint32, numPages uint32) (*[]Page, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.ReadPages(pageNum, numPages)
+}
+
+func (f *syncFile) WritePages(pages *[]Page, pageNum uint32) (uint32, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.WritePages(pages, pageNum)
+}
+
+// withDefaults returns the options with zero values replaced by their defaults, or an error if
+// they are invalid.
+func (o LSMOptions) withDefaults() (LSMOptions, error) {
+	if o.MemtableSize == 0 {
+		o.MemtableSize = DefaultMemtableSize
+	}
+	if o.TableSize == 0 {
+		o.TableSize = DefaultLSMTableSize
+	}
+	if o.LevelTables == 0 {
+		o.LevelTables = DefaultLevelTables
+	}
+	if o.LevelRatio == 0 {
+		o.LevelRatio = DefaultLevelRatio
+	}
+	if o.FalsePositiveRate == 0 {
+		o.FalsePositiveRate = DefaultFalsePositiveRate
+	}
+	switch {
+	case o.Compaction > TieredCompaction:
+		return o, fmt.Errorf("unknown compaction strategy %d", o.Compaction)
+	case o.MemtableSize < 0 || o.TableSize < PageSize:
+		return o, fmt.Errorf(
+			"invalid memtable size %d or table size %d", o.MemtableSize, o.TableSize,
+		)
+	case o.LevelTables < 2 || o.LevelRatio < 2:
+		return o, fmt.Errorf(
+			"LevelTables and LevelRatio must be at least 2, got %d and %d",
+			o.LevelTables, o.LevelRatio,
+		)
+	case !(o.FalsePositiveRate > 0 && o.FalsePositiveRate < 1):
+		return o, fmt.Errorf("invalid false positive rate %v", o.FalsePositiveRate)
+	}
+	return o, nil
+}
+
+// NewLSMTree creates an empty tree with the given options in the given file, and starts its
+// background goroutine. The pages of the tree are appended to the file; MetaPageNum returns the
+// page to open the tree from later. The file must not be used by other goroutines except through
+// the tree, or through File.
+func NewLSMTree(file PageFile, options LSMOptions) (*LSMTree, error) {
+	options, err := options.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	file = newSyncFile(file)
+	pageNums, err := file.AppendPages(&[]Page{{}})
+	if err != nil {
+		return nil, err
+	}
+	t := newLSMTree(file, pageNums[0], options)
+	t.manifestPages = pageNums
+	if err = t.writeManifest(); err != nil {
+		return nil, err
+	}
+	go t.run()
+	return t, nil
+}
+
+// newLSMTree returns an empty tree with the given options and meta page.
+func newLSMTree(file PageFile, metaPageNum uint32, options LSMOptions) *LSMTree {
+	t := &LSMTree{
+		file:        file,
+		metaPageNum: metaPageNum,
+		options:     options,
+		freeList:    noPage,
+		memtable:    &memtable{},
+		done:        make(chan struct{}),
+	}
+	t.changed = sync.NewCond(&t.mutex)
+	return t
+}
+
+// OpenLSMTree opens the tree whose meta page is stored at the given page number of the given
+// file, reads the block indexes and bloom filters of its tables into memory, and starts its
+// background goroutine.
+func OpenLSMTree(file PageFile, metaPageNum uint32) (*LSMTree, error) {
+	file = newSyncFile(file)
+	data, manifestPages, err := readChain(file, metaPageNum, lsmManifestPage)
+	if err != nil {
+		return nil, err
+	}
+	corrupt := &CorruptIndexError{metaPageNum, "invalid manifest"}
+	if len(data) < 2 {
+		return nil, corrupt
+	}
+	if data[0] != lsmVersion {
+		return nil, &CorruptIndexError{metaPageNum, fmt.Sprintf("unknown version %d", data[0])}
+	}
+	options := LSMOptions{Compaction: CompactionStrategy(data[1])}
+	data = data[2:]
+	for _, option := range []*int{
+		&options.LevelTables, &options.LevelRatio, &options.MemtableSize, &options.TableSize,
+	} {
+		value, ok := readUvarint(&data)
+		if !ok || value == 0 || value > math.MaxInt32 {
+			return nil, corrupt
+		}
+		*option = int(value)
+	}
+	if len(data) < 12 {
+		return nil, corrupt
+	}
+	options.FalsePositiveRate = math.Float64frombits(binary.LittleEndian.Uint64(data))
+	if options, err = options.withDefaults(); err != nil {
+		return nil, &CorruptIndexError{metaPageNum, err.Error()}
+	}
+	t := newLSMTree(file, metaPageNum, options)
+	t.manifestPages = manifestPages
+	t.freeList = binary.LittleEndian.Uint32(data[8:])
+	data = data[12:]
+	keyLength, ok := readUvarint(&data)
+	if !ok || keyLength > uint64(len(data)) {
+		return nil, corrupt
+	}
+	if keyLength > 0 {
+		t.maxKey = append([]byte{}, data[:keyLength]...)
+	}
+	data = data[keyLength:]
+	for level := range t.levels {
+		numTables, ok := readUvarint(&data)
+		if !ok || numTables > uint64(len(data))/4 {
+			return nil, corrupt
+		}
+		for i := uint64(0); i < numTables; i++ {
+			table, err := openSSTable(file, binary.LittleEndian.Uint32(data))
+			if err != nil {
+				return nil, err
+			}
+			t.levels[level] = append(t.levels[level], table)
+			data = data[4:]
+		}
+	}
+	go t.run()
+	return t, nil
+}
+
+// MetaPageNum returns the number of the page that the tree can be opened from with OpenLSMTree.
+func (t *LSMTree) MetaPageNum() uint32 {
+	return t.metaPageNum
+}
+
+// Options returns the options of the tree, with defaults filled in.
+func (t *LSMTree) Options() LSMOptions {
+	return t.options
+}
+
+// File returns the file of the tree, through which the other structures stored in the file must
+// use it while the tree is open.
+func (t *LSMTree) File() PageFile {
+	return t.file
+}
+
+// Levels returns the number of tables of each level of the tree, up to the deepest level with
+// tables.
+func (t *LSMTree) Levels() []int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	numLevels := 0
+	for level, tables := range t.levels {
+		if len(tables) > 0 {
+			numLevels = level + 1
+		}
+	}
+	levels := make([]int, numLevels)
+	for level := range levels {
+		levels[level] = len(t.levels[level])
+	}
+	return levels
+}
+
+// writeManifest writes the manifest of the tree.
+func (t *LSMTree) writeManifest() error {
+	encode := func() []byte {
+		data := []byte{lsmVersion, byte(t.options.Compaction)}
+		for _, option := range []int{
+			t.options.LevelTables, t.options.LevelRatio, t.options.MemtableSize,
+			t.options.TableSize,
+		} {
+			data = appendUvarint(data, uint64(option))
+		}
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(t.options.FalsePositiveRate))
+		data = appendUint32(append(data, buf[:]...), t.freeList)
+		data = append(appendUvarint(data, uint64(len(t.maxKey))), t.maxKey...)
+		for _, tables := range t.levels {
+			data = appendUvarint(data, uint64(len(tables)))
+			for _, table := range tables {
+				data = appendUint32(data, table.metaPageNum)
+			}
+		}
+		return data
+	}
+	// Size the chain first, since taking pages from the free list or adding pages to it changes
+	// the manifest.
+	chainSize := len(encode()) + 4
+	numPages := (chainSize + PageSize - chainPageHeaderSize - 1) / (PageSize - chainPageHeaderSize)
+	for len(t.manifestPages) > numPages {
+		last := len(t.manifestPages) - 1
+		if err := freeIndexPage(t.file, &t.freeList, t.manifestPages[last]); err != nil {
+			return err
+		}
+		t.manifestPages = t.manifestPages[:last]
+	}
+	for len(t.manifestPages) < numPages {
+		pageNum, err := allocateIndexPage(t.file, &t.freeList)
+		if err != nil {
+			return err
+		}
+		t.manifestPages = append(t.manifestPages, pageNum)
+	}
+	_, err := writeChain(t.file, &t.freeList, lsmManifestPage, encode(), t.manifestPages)
+	return err
+}
+
+// find returns the index of the first entry of the memtable whose key is at least the given key,
+// and whether its key is the given key.
+func (m *memtable) find(key []byte) (int, bool) {
+	i := sort.Search(
+		len(m.entries), func(i int) bool { return bytes.Compare(m.entries[i].key, key) >= 0 },
+	)
+	return i, i < len(m.entries) && bytes.Equal(m.entries[i].key, key)
+}
+
+// put adds the given entry to the memtable, replacing the entry of its key.
+func (m *memtable) put(e lsmEntry) {
+	m.size += len(e.key) + len(e.value) + lsmEntryOverhead
+	if m.maxKey == nil || bytes.Compare(e.key, m.maxKey) > 0 {
+		m.maxKey = e.key
+	}
+	i, found := m.find(e.key)
+	if found {
+		m.size -= len(m.entries[i].key) + len(m.entries[i].value) + lsmEntryOverhead
+		m.entries[i] = e
+		return
+	}
+	if i == len(m.entries) {
+		// Keys are usually added in increasing order.
+		m.entries = append(m.entries, e)
+		return
+	}
+	m.entries = append(m.entries, lsmEntry{})
+	copy(m.entries[i+1:], m.entries[i:])
+	m.entries[i] = e
+}
+
+// get returns the entry of the memtable with the given key, if there is one.
+func (m *memtable) get(key []byte) (lsmEntry, bool) {
+	if i, found := m.find(key); found {
+		return m.entries[i], true
+	}
+	return lsmEntry{}, false
+}
+
+// iterator returns an iterator over the entries of the memtable whose key is at least the given
+// key.
+func (m *memtable) iterator(start []byte) *memtableIterator {
+	i, _ := m.find(start)
+	return &memtableIterator{m.entries, i}
+}
+
+func (it *memtableIterator) next() (lsmEntry, bool, error) {
+	if it.pos == len(it.entries) {
+		return lsmEntry{}, false, nil
+	}
+	it.pos++
+	return it.entries[it.pos-1], true, nil
+}
+
+// newMergeIterator returns an iterator over the entries of the given iterators, from newest to
+// oldest.
+func newMergeIterator(sources []lsmIterator) (*mergeIterator, error) {
+	it := &mergeIterator{
+		sources: sources,
+		heads:   make([]lsmEntry, len(sources)),
+		valid:   make([]bool, len(sources)),
+	}
+	for i := range sources {
+		if err := it.advance(i); err != nil {
+			return nil, err
+		}
+	}
+	return it, nil
+}
+
+// advance reads the next entry of the source with the given index.
+func (it *mergeIterator) advance(i int) error {
+	var err error
+	it.heads[i], it.valid[i], err = it.sources[i].next()
+	return err
+}
+
+func (it *mergeIterator) next() (lsmEntry, bool, error) {
+	first := -1
+	for i, valid := range it.valid {
+		if valid && (first < 0 || bytes.Compare(it.heads[i].key, it.heads[first].key) < 0) {
+			first = i
+		}
+	}
+	if first < 0 {
+		return lsmEntry{}, false, nil
+	}
+	e := it.heads[first]
+	for i, valid := range it.valid {
+		if valid && bytes.Equal(it.heads[i].key, e.key) {
+			if err := it.advance(i); err != nil {
+				return lsmEntry{}, false, err
+			}
+		}
+	}
+	return e, true, nil
+}
+
+// usable returns an error if the tree is closed or if its background goroutine failed.
+func (t *LSMTree) usable() error {
+	if t.err != nil {
+		return t.err
+	}
+	if t.closed {
+		return &TreeClosedError{}
+	}
+	return nil
+}
+
+// Put sets the value of the given key. The key and the value are copied.
+func (t *LSMTree) Put(key, value []byte) error {
+	return t.write(
+		lsmEntry{append([]byte{}, key...), append([]byte{}, value...), false},
+	)
+}
+
+// Delete removes the given key from the tree, if it is stored.
+func (t *LSMTree) Delete(key []byte) error {
+	return t.write(lsmEntry{append([]byte{}, key...), nil, true})
+}
+
+// write adds the given entry to the memtable, and freezes the memtable if it is full. Writes wait
+// while a frozen memtable is being written to an SSTable, so that memory use stays bounded.
+func (t *LSMTree) write(e lsmEntry) error {
+	if len(e.key)+len(e.value) > MaxLSMEntrySize {
+		return fmt.Errorf(
+			"entry of %d bytes is larger than %d bytes", len(e.key)+len(e.value), MaxLSMEntrySize,
+		)
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err := t.usable(); err != nil {
+		return err
+	}
+	t.memtable.put(e)
+	if t.memtable.size < t.options.MemtableSize {
+		return nil
+	}
+	return t.freeze()
+}
+
+// freeze hands the memtable over to the background goroutine, after waiting for the previous
+// frozen memtable to be written.
+func (t *LSMTree) freeze() error {
+	for t.frozen != nil && t.err == nil {
+		t.changed.Wait()
+	}
+	if t.err != nil {
+		return t.err
+	}
+	t.frozen, t.memtable = t.memtable, &memtable{}
+	t.changed.Broadcast()
+	return nil
+}
+
+// Get returns the value of the given key, or false if the key is not stored.
+func (t *LSMTree) Get(key []byte) ([]byte, bool, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err := t.usable(); err != nil {
+		return nil, false, err
+	}
+	e, found := t.memtable.get(key)
+	if !found && t.frozen != nil {
+		e, found = t.frozen.get(key)
+	}
+	for level := 0; !found && level < lsmMaxLevels; level++ {
+		for _, table := range t.levels[level] {
+			var err error
+			if e, found, err = table.get(t.file, key); err != nil {
+				return nil, false, err
+			}
+			if found {
+				break
+			}
+		}
+	}
+	if !found || e.deleted {
+		return nil, false, nil
+	}
+	return e.value, true, nil
+}
+
+// Scan calls fn with each key of the tree that is at least the given key, or with every key if it
+// is nil, and its value, in key order, stopping at the first error returned by fn. fn must not use
+// the tree.
+func (t *LSMTree) Scan(start []byte, fn func(key, value []byte) error) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err := t.usable(); err != nil {
+		return err
+	}
+	sources := []lsmIterator{t.memtable.iterator(start)}
+	if t.frozen != nil {
+		sources = append(sources, t.frozen.iterator(start))
+	}
+	for _, tables := range t.levels {
+		for _, table := range tables {
+			if bytes.Compare(table.lastKey, start) < 0 {
+				continue
+			}
+			it, err := table.iterator(t.file, start)
+			if err != nil {
+				return err
+			}
+			sources = append(sources, it)
+		}
+	}
+	it, err := newMergeIterator(sources)
+	if err != nil {
+		return err
+	}
+	for {
+		e, ok, err := it.next()
+		if err != nil || !ok {
+			return err
+		}
+		if e.deleted {
+			continue
+		}
+		if err = fn(e.key, e.value); err != nil {
+			return err
+		}
+	}
+}
+
+// MaxKey returns the largest key written to the tree, including keys that were deleted since, or
+// nil if no key was written. After the tree is opened again, it is the largest key written before
+// the last flush.
+func (t *LSMTree) MaxKey() []byte {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	maxKey := t.maxKey
+	for _, m := range []*memtable{t.frozen, t.memtable} {
+		if m != nil && bytes.Compare(m.maxKey, maxKey) > 0 {
+			maxKey = m.maxKey
+		}
+	}
+	return append([]byte(nil), maxKey...)
+}
+
+// Flush writes the entries of the memtable to an SSTable, and waits until they are written.
+func (t *LSMTree) Flush() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err := t.usable(); err != nil {
+		return err
+	}
+	if len(t.memtable.entries) > 0 {
+		if err := t.freeze(); err != nil {
+			return err
+		}
+	}
+	for t.frozen != nil && t.err == nil {
+		t.changed.Wait()
+	}
+	return t.err
+}
+
+// WaitForCompactions waits until the tree has no frozen memtable to write and no compaction to
+// run.
+func (t *LSMTree) WaitForCompactions() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for {
+		if err := t.usable(); err != nil {
+			return err
+		}
+		if t.frozen == nil && t.pickCompaction() == nil {
+			return nil
+		}
+		t.changed.Wait()
+	}
+}
+
+// Close flushes the memtable and stops the background goroutine. Compactions that are due run
+// when the tree is opened again.
+func (t *LSMTree) Close() error {
+	err := t.Flush()
+	t.mutex.Lock()
+	if _, ok := err.(*TreeClosedError); ok {
+		t.mutex.Unlock()
+		return err
+	}
+	t.closed = true
+	t.changed.Broadcast()
+	t.mutex.Unlock()
+	<-t.done
+	return err
+}
+
+// run writes frozen memtables to SSTables and compacts the tables until the tree is closed or an
+// error occurs, which makes every later use of the tree fail.
+func (t *LSMTree) run() {
+	defer close(t.done)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for t.err == nil {
+		if t.frozen != nil {
+			t.err = t.flushFrozen()
+		} else if c := t.pickCompaction(); c != nil && !t.closed {
+			t.err = t.compact(c)
+		} else if t.closed {
+			return
+		} else {
+			t.changed.Wait()
+			continue
+		}
+		t.changed.Broadcast()
+	}
+	t.changed.Broadcast()
+}
+
+// flushFrozen writes the frozen memtable to a new SSTable on level 0. The mutex of the tree is
+// released while the table is written.
+func (t *LSMTree) flushFrozen() error {
+	frozen := t.frozen
+	t.mutex.Unlock()
+	tables, err := t.writeTables(frozen.iterator(nil), false, false)
+	t.mutex.Lock()
+	if err != nil {
+		return err
+	}
+	t.levels[0] = append(tables, t.levels[0]...)
+	if bytes.Compare(frozen.maxKey, t.maxKey) > 0 {
+		t.maxKey = frozen.maxKey
+	}
+	t.frozen = nil
+	return t.writeManifest()
+}
+
+// writeTables writes the entries of the given iterator to new SSTables, starting a new table every
+// TableSize bytes if split is set, and leaving tombstones out if dropTombstones is set.
+func (t *LSMTree) writeTables(it lsmIterator, split, dropTombstones bool) ([]*sstable, error) {
+	var tables []*sstable
+	w := newSSTableWriter(t.file, &t.freeList, t.options.FalsePositiveRate)
+	for {
+		e, ok, err := it.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		if e.deleted && dropTombstones {
+			continue
+		}
+		if split && w.size() >= t.options.TableSize {
+			table, err := w.finish()
+			if err != nil {
+				return nil, err
+			}
+			tables = append(tables, table)
+			w = newSSTableWriter(t.file, &t.freeList, t.options.FalsePositiveRate)
+		}
+		if err = w.add(e); err != nil {
+			return nil, err
+		}
+	}
+	table, err := w.finish()
+	if err != nil || table == nil {
+		return tables, err
+	}
+	return append(tables, table), nil
+}
+
+// levelSize returns the number of bytes of the data pages of the tables of the given level.
+func (t *LSMTree) levelSize(level int) int {
+	size := 0
+	for _, table := range t.levels[level] {
+		size += len(table.pageNums) * PageSize
+	}
+	return size
+}
+
+// maxLevelSize returns the number of bytes that the given level of a tree with leveled compaction
+// holds before its tables are merged into the next level.
+func (t *LSMTree) maxLevelSize(level int) int {
+	if level == lsmMaxLevels-1 {
+		return math.MaxInt
+	}
+	size := t.options.TableSize
+	for i := 0; i < level; i++ {
+		if size > math.MaxInt/t.options.LevelRatio {
+			return math.MaxInt
+		}
+		size *= t.options.LevelRatio
+	}
+	return size
+}
+
+// keyRange returns the smallest and the largest key of the given tables.
+func keyRange(tables []*sstable) ([]byte, []byte) {
+	first, last := tables[0].firstKeys[0], tables[0].lastKey
+	for _, table := range tables[1:] {
+		if bytes.Compare(table.firstKeys[0], first) < 0 {
+			first = table.firstKeys[0]
+		}
+		if bytes.Compare(table.lastKey, last) > 0 {
+			last = table.lastKey
+		}
+	}
+	return first, last
+}
+
+// pickCompaction returns the compaction that the tree needs next, or nil if it needs none.
+func (t *LSMTree) pickCompaction() *lsmCompaction {
+	var c *lsmCompaction
+	if t.options.Compaction == TieredCompaction {
+		for level, tables := range t.levels {
+			if len(tables) >= t.options.LevelTables {
+				output := level + 1
+				if output == lsmMaxLevels {
+					output = level
+				}
+				c = &lsmCompaction{level: level, output: output, inputs: tables}
+				break
+			}
+		}
+	} else if len(t.levels[0]) >= t.options.LevelTables {
+		c = &lsmCompaction{level: 0, output: 1, inputs: t.levels[0], split: true}
+	} else {
+		for level := 1; level < lsmMaxLevels-1; level++ {
+			if t.levelSize(level) <= t.maxLevelSize(level) {
+				continue
+			}
+			// Take the tables of the level, which are sorted by key, in turn.
+			tables := t.levels[level]
+			table := tables[0]
+			for _, other := range tables {
+				if bytes.Compare(other.firstKeys[0], t.compactKeys[level]) > 0 {
+					table = other
+					break
+				}
+			}
+			c = &lsmCompaction{
+				level: level, output: level + 1, inputs: []*sstable{table}, split: true,
+			}
+			break
+		}
+	}
+	if c == nil {
+		return nil
+	}
+
+	c.inputs = append([]*sstable(nil), c.inputs...)
+	first, last := keyRange(c.inputs)
+	if c.split {
+		// The merged tables must not overlap the other tables of the output level.
+		for _, table := range t.levels[c.output] {
+			if table.overlaps(first, last) {
+				c.inputs = append(c.inputs, table)
+			}
+		}
+		first, last = keyRange(c.inputs)
+	}
+	inputs := make(map[*sstable]bool, len(c.inputs))
+	for _, table := range c.inputs {
+		inputs[table] = true
+	}
+	c.dropTombstones = true
+	for level := c.output; level < lsmMaxLevels; level++ {
+		for _, table := range t.levels[level] {
+			if !inputs[table] && table.overlaps(first, last) {
+				c.dropTombstones = false
+			}
+		}
+	}
+	return c
+}
+
+// compact runs the given compaction. The mutex of the tree is released while the tables are
+// merged.
+func (t *LSMTree) compact(c *lsmCompaction) error {
+	if c.split && c.level > 0 {
+		t.compactKeys[c.level] = c.inputs[0].lastKey
+	}
+	t.mutex.Unlock()
+	outputs, err := t.merge(c)
+	t.mutex.Lock()
+	if err != nil {
+		return err
+	}
+
+	inputs := make(map[*sstable]bool, len(c.inputs))
+	for _, table := range c.inputs {
+		inputs[table] = true
+	}
+	for level, tables := range t.levels {
+		var kept []*sstable
+		for _, table := range tables {
+			if !inputs[table] {
+				kept = append(kept, table)
+			}
+		}
+		t.levels[level] = kept
+	}
+	if c.split {
+		t.levels[c.output] = append(t.levels[c.output], outputs...)
+		tables := t.levels[c.output]
+		sort.Slice(
+			tables, func(i, j int) bool {
+				return bytes.Compare(tables[i].firstKeys[0], tables[j].firstKeys[0]) < 0
+			},
+		)
+	} else {
+		t.levels[c.output] = append(outputs, t.levels[c.output]...)
+	}
+	// The manifest no longer lists the merged tables before their pages are reused.
+	if err = t.writeManifest(); err != nil {
+		return err
+	}
+	for _, table := range c.inputs {
+		for _, pageNum := range table.pages() {
+			if err = freeIndexPage(t.file, &t.freeList, pageNum); err != nil {
+				return err
+			}
+		}
+	}
+	return t.writeManifest()
+}
+
+// merge writes the entries of the input tables of the given compaction to new tables.
+func (t *LSMTree) merge(c *lsmCompaction) ([]*sstable, error) {
+	sources := make([]lsmIterator, len(c.inputs))
+	for i, table := range c.inputs {
+		var err error
+		if sources[i], err = table.iterator(t.file, nil); err != nil {
+			return nil, err
+		}
+	}
+	it, err := newMergeIterator(sources)
+	if err != nil {
+		return nil, err
+	}
+	return t.writeTables(it, c.split, c.dropTombstones)
+}
+
+// lsmScanChunk is the number of rows of a table stored in an LSMTree that an online build reads
+// while the table is locked.
+const lsmScanChunk = 256
+
+// errChunkFull stops the scan of a chunk of rows.
+var errChunkFull = errors.New("chunk is full")
+
+// newLSMTable returns a table with the given schema whose records are stored in the given tree.
+func newLSMTable(schema Schema, fileID uint16, tree *LSMTree) *Table {
+	t := newTable(schema, tree.File(), fileID)
+	t.lsm = tree
+	return t
+}
+
+// OpenLSMTable returns a table with the given schema whose records are stored in the LSMTree whose
+// meta page has the given page number, as returned by LSMMetaPageNum, in the file with the given
+// ID. Row numbers continue after the largest row number written to the tree, so the numbers of
+// deleted rows are not used again. The table has no indexes: OpenIndex opens the indexes stored in
+// the file, and CreateIndex builds new ones from the stored records. The table must be closed with
+// Close.
+func OpenLSMTable(
+	schema Schema, file PageFile, fileID uint16, metaPageNum uint32,
+) (*Table, error) {
+	if fileID == 0xffff {
+		return nil, fmt.Errorf("invalid file ID %d", fileID)
+	}
+	tree, err := OpenLSMTree(file, metaPageNum)
+	if err != nil {
+		return nil, err
+	}
+	t := newLSMTable(schema, fileID, tree)
+	if key := tree.MaxKey(); key != nil {
+		if len(key) != 8 {
+			tree.Close()
+			return nil, &CorruptIndexError{metaPageNum, "invalid row key"}
+		}
+		t.nextRowID = binary.BigEndian.Uint64(key) + 1
+	}
+	return t, nil
+}
+
+// LSMMetaPageNum returns the number of the meta page of the LSMTree of a table created with
+// LSMEngine, which OpenLSMTable opens the table from.
+func (t *Table) LSMMetaPageNum() (uint32, error) {
+	if t.lsm == nil {
+		return 0, fmt.Errorf("table is not stored in an LSM tree")
+	}
+	return t.lsm.MetaPageNum(), nil
+}
+
+// rowKey returns the key of the row with the given home address in the LSMTree of its table: its
+// row number stored in 8 bytes big-endian, so that rows sort by home address.
+func rowKey(address RecordAddress) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(address.PageNum)<<16|uint64(address.SlotNum))
+	return key
+}
+
+// rowAddress returns the home address of the row with the given key.
+func (t *Table) rowAddress(key []byte) RecordAddress {
+	rowID := binary.BigEndian.Uint64(key)
+	return t.address(uint32(rowID>>16), uint16(rowID))
+}
+
+// recordBytes returns the bytes of the given record after compacting it.
+func recordBytes(record *Record) []byte {
+	record.Compact()
+	return (*record)[:record.Length()]
+}
+
+// decodeRow returns a copy of the record stored as the value of the row with the given home
+// address.
+func decodeRow(address RecordAddress, value []byte) (*Record, error) {
+	record := Record(append([]byte(nil), value...))
+	if err := record.Validate(); err != nil {
+		return nil, &CorruptTableError{address, err.Error()}
+	}
+	record = record[:record.Length()]
+	return &record, nil
+}
+
+// getRow returns the record of the row with the given home address.
+func (t *Table) getRow(address RecordAddress) (*Record, error) {
+	if address.FileID != t.fileID {
+		return nil, &InvalidAddressError{address}
+	}
+	value, found, err := t.lsm.Get(rowKey(address))
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, &InvalidAddressError{address}
+	}
+	return decodeRow(address, value)
+}
+
+// insertRow adds the given record to the LSMTree of the table as a new row, and returns its home
+// address.
+func (t *Table) insertRow(record *Record) (RecordAddress, error) {
+	if t.nextRowID >= 1<<48 {
+		return RecordAddress{}, fmt.Errorf("table has no row numbers left")
+	}
+	address := t.address(uint32(t.nextRowID>>16), uint16(t.nextRowID))
+	if err := t.lsm.Put(rowKey(address), recordBytes(record)); err != nil {
+		return RecordAddress{}, err
+	}
+	t.nextRowID++
+	t.keepRow(address, nil)
+	return address, nil
+}
+
+// keepRow keeps the given record of the row with the given home address, or nil if the row did
+// not exist, for rolling back the transaction that holds the table, unless the transaction already
+// wrote the row.
+func (t *Table) keepRow(address RecordAddress, record *Record) {
+	if t.undo == nil {
+		return
+	}
+	if t.undo.rows == nil {
+		t.undo.rows = make(map[RecordAddress]*Record)
+	}
+	if _, ok := t.undo.rows[address]; !ok {
+		t.undo.rows[address] = record
+	}
+}
+
+// restoreRows writes back the given records of the rows of the table, as kept by keepRow, and
+// restores their index entries. The entries of every row are removed before any is inserted, so
+// that unique keys that moved between rows can be inserted again.
+func (t *Table) restoreRows(rows map[RecordAddress]*Record) error {
+	removed := make(map[RecordAddress][][][]any, len(rows))
+	added := make(map[RecordAddress][][][]any, len(rows))
+	for address, record := range rows {
+		current, err := t.getRow(address)
+		if _, ok := err.(*InvalidAddressError); ok {
+			current, err = nil, nil
+		}
+		if err != nil {
+			return err
+		}
+		oldKeys, err := t.rowKeys(current)
+		if err != nil {
+			return err
+		}
+		newKeys, err := t.rowKeys(record)
+		if err != nil {
+			return err
+		}
+		removed[address] = make([][][]any, len(t.indexes))
+		added[address] = make([][][]any, len(t.indexes))
+		for i, index := range t.indexes {
+			removed[address][i], added[address][i], err = keyChanges(
+				oldKeys[i], newKeys[i], index.comparator,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for address, keys := range removed {
+		for i, index := range t.indexes {
+			for _, key := range keys[i] {
+				if err := index.remove(key, address); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for address, keys := range added {
+		for i, index := range t.indexes {
+			for _, key := range keys[i] {
+				if err := index.insert(key, address); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for address, record := range rows {
+		var err error
+		if record == nil {
+			err = t.lsm.Delete(rowKey(address))
+		} else {
+			err = t.lsm.Put(rowKey(address), recordBytes(record))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rowKeys returns the keys that each index of the table stores for the given record of a row, or
+// no keys for a nil record.
+func (t *Table) rowKeys(record *Record) ([][][]any, error) {
+	if record == nil {
+		return make([][][]any, len(t.indexes)), nil
+	}
+	return t.keys(record)
+}
+
+// scanRows calls fn with the home address and the record of each row of the table whose key is
+// at least the given key, in key order, stopping at the first error returned by fn.
+func (t *Table) scanRows(
+	start []byte, fn func(address RecordAddress, record *Record) error,
+) error {
+	return t.lsm.Scan(
+		start, func(key, value []byte) error {
+			address := t.rowAddress(key)
+			record, err := decodeRow(address, value)
+			if err != nil {
+				return err
+			}
+			return fn(address, record)
+		},
+	)
+}
+
+// scanRowChunk calls fn with the home address and the record of each of the next lsmScanChunk
+// rows after the given cursor, and advances the cursor. It returns true if no rows were left after
+// the chunk.
+func (t *Table) scanRowChunk(
+	cursor *tableCursor, fn func(address RecordAddress, record *Record) error,
+) (bool, error) {
+	numRows := 0
+	err := t.scanRows(
+		cursor.next, func(address RecordAddress, record *Record) error {
+			if numRows == lsmScanChunk {
+				cursor.next = rowKey(address)
+				return errChunkFull
+			}
+			numRows++
+			return fn(address, record)
+		},
+	)
+	if err == errChunkFull {
+		return false, nil
+	}
+	return true, err
+}
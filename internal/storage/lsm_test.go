@@ -0,0 +1,519 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// testLSMOptions returns options with small memtables and tables, so that tests compact often.
+func testLSMOptions(compaction CompactionStrategy) LSMOptions {
+	return LSMOptions{
+		MemtableSize: 8 * 1024,
+		TableSize:    2 * PageSize,
+		Compaction:   compaction,
+		LevelTables:  2,
+		LevelRatio:   2,
+	}
+}
+
+// checkLSMTree checks that the tables of the tree do not share pages, and that the tables of each
+// level but level 0 are sorted and disjoint with LeveledCompaction.
+func checkLSMTree(t *testing.T, tree *LSMTree) {
+	t.Helper()
+	if err := tree.WaitForCompactions(); err != nil {
+		t.Fatal(err)
+	}
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+	used := make(map[uint32]string)
+	use := func(pageNum uint32, owner string) {
+		if other, ok := used[pageNum]; ok {
+			t.Errorf("page %d used by %s and %s", pageNum, other, owner)
+		}
+		used[pageNum] = owner
+	}
+	for _, pageNum := range tree.manifestPages {
+		use(pageNum, "manifest")
+	}
+	for pageNum := tree.freeList; pageNum != noPage; {
+		use(pageNum, "free list")
+		pages, err := tree.file.ReadPages(pageNum, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pageNum = binary.LittleEndian.Uint32((*pages)[0][1:])
+	}
+	for level, tables := range tree.levels {
+		for i, table := range tables {
+			for _, pageNum := range table.pages() {
+				use(pageNum, fmt.Sprintf("table %d of level %d", i, level))
+			}
+			if tree.options.Compaction == LeveledCompaction && level > 0 && i > 0 &&
+				bytes.Compare(tables[i-1].lastKey, table.firstKeys[0]) >= 0 {
+				t.Errorf("level %d: tables %d and %d overlap", level, i-1, i)
+			}
+		}
+	}
+}
+
+// checkLSMContents checks that the tree stores exactly the given values by key.
+func checkLSMContents(t *testing.T, tree *LSMTree, values map[string][]byte) {
+	t.Helper()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var scanned []string
+	err := tree.Scan(
+		nil, func(key, value []byte) error {
+			scanned = append(scanned, string(key))
+			if !bytes.Equal(value, values[string(key)]) {
+				t.Errorf("%s: expected %d bytes, got %d", key, len(values[string(key)]), len(value))
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) == 0 {
+		keys = nil
+	}
+	if !reflect.DeepEqual(scanned, keys) {
+		t.Errorf("expected %d keys, scanned %d", len(keys), len(scanned))
+	}
+	for _, key := range keys {
+		value, found, err := tree.Get([]byte(key))
+		if err != nil || !found || !bytes.Equal(value, values[key]) {
+			t.Fatalf("%s: expected value, got %v, %v", key, found, err)
+		}
+	}
+}
+
+// applyRandomWrites puts and deletes random keys of the tree, and applies the same writes to the
+// given values.
+func applyRandomWrites(t *testing.T, tree *LSMTree, values map[string][]byte, seed int64) {
+	t.Helper()
+	r := rand.New(rand.NewSource(seed))
+	for i := 0; i < 20000; i++ {
+		key := fmt.Sprintf("key %04d", r.Intn(3000))
+		if r.Intn(4) == 0 {
+			if err := tree.Delete([]byte(key)); err != nil {
+				t.Fatal(err)
+			}
+			delete(values, key)
+			continue
+		}
+		value := make([]byte, r.Intn(200))
+		r.Read(value)
+		if err := tree.Put([]byte(key), value); err != nil {
+			t.Fatal(err)
+		}
+		values[key] = value
+	}
+}
+
+func TestLSMTree(t *testing.T) {
+	for _, compaction := range []CompactionStrategy{LeveledCompaction, TieredCompaction} {
+		t.Run(
+			fmt.Sprintf("check random writes with strategy %d", compaction),
+			func(t *testing.T) {
+				file := &memoryFile{}
+				tree, err := NewLSMTree(file, testLSMOptions(compaction))
+				if err != nil {
+					t.Fatal(err)
+				}
+				values := make(map[string][]byte)
+				applyRandomWrites(t, tree, values, int64(compaction))
+				checkLSMContents(t, tree, values)
+				checkLSMTree(t, tree)
+				if levels := tree.Levels(); len(levels) < 3 {
+					t.Errorf("expected at least 3 levels, got %v", levels)
+				}
+				for _, key := range []string{"a", "key 3000", "key 0000 "} {
+					if _, found, _ := tree.Get([]byte(key)); found {
+						t.Errorf("expected no value for %s", key)
+					}
+				}
+
+				if err = tree.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if tree, err = OpenLSMTree(file, tree.MetaPageNum()); err != nil {
+					t.Fatal(err)
+				}
+				options, _ := testLSMOptions(compaction).withDefaults()
+				if tree.Options() != options {
+					t.Errorf("expected options, got %+v", tree.Options())
+				}
+				checkLSMContents(t, tree, values)
+				applyRandomWrites(t, tree, values, 10+int64(compaction))
+				checkLSMContents(t, tree, values)
+				checkLSMTree(t, tree)
+				if err = tree.Close(); err != nil {
+					t.Fatal(err)
+				}
+			},
+		)
+	}
+
+	t.Run(
+		"check scan from key", func(t *testing.T) {
+			tree, err := NewLSMTree(&memoryFile{}, testLSMOptions(LeveledCompaction))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tree.Close()
+			for i := 0; i < 1000; i++ {
+				if err = tree.Put([]byte(fmt.Sprintf("%03d", i)), []byte{byte(i)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var keys []string
+			err = tree.Scan(
+				[]byte("990"), func(key, value []byte) error {
+					keys = append(keys, string(key))
+					return nil
+				},
+			)
+			if err != nil || !reflect.DeepEqual(keys, []string{"990", "991", "992", "993", "994",
+				"995", "996", "997", "998", "999"}) {
+				t.Errorf("expected keys from 990, got %v, %v", keys, err)
+			}
+		},
+	)
+
+	t.Run(
+		"check tombstones are dropped", func(t *testing.T) {
+			file := &memoryFile{}
+			tree, err := NewLSMTree(file, testLSMOptions(LeveledCompaction))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tree.Close()
+			for i := 0; i < 100; i++ {
+				if err = tree.Put([]byte(fmt.Sprint(i)), []byte("value")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = tree.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if levels := tree.Levels(); !reflect.DeepEqual(levels, []int{1}) {
+				t.Errorf("expected 1 table on level 0, got %v", levels)
+			}
+			for i := 0; i < 100; i++ {
+				if err = tree.Delete([]byte(fmt.Sprint(i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = tree.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			checkLSMTree(t, tree)
+			if levels := tree.Levels(); len(levels) != 0 {
+				t.Errorf("expected no tables, got %v", levels)
+			}
+			checkLSMContents(t, tree, nil)
+			if key := tree.MaxKey(); string(key) != "99" {
+				t.Errorf("expected max key 99, got %q", key)
+			}
+			if err = tree.Close(); err != nil {
+				t.Fatal(err)
+			}
+			tree, err = OpenLSMTree(file, tree.MetaPageNum())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key := tree.MaxKey(); string(key) != "99" {
+				t.Errorf("expected max key 99 after reopening, got %q", key)
+			}
+			if err = tree.Close(); err != nil {
+				t.Fatal(err)
+			}
+		},
+	)
+
+	t.Run(
+		"check concurrent use", func(t *testing.T) {
+			tree, err := NewLSMTree(&memoryFile{}, testLSMOptions(TieredCompaction))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var wg sync.WaitGroup
+			for g := 0; g < 4; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					for i := 0; i < 2000; i++ {
+						key := []byte(fmt.Sprintf("%d-%04d", g, i))
+						if err := tree.Put(key, key); err != nil {
+							t.Error(err)
+							return
+						}
+						if value, found, err := tree.Get(key); !found || err != nil ||
+							!bytes.Equal(value, key) {
+							t.Errorf("%s: expected value, got %v, %v", key, found, err)
+							return
+						}
+					}
+				}(g)
+			}
+			wg.Wait()
+			numKeys := 0
+			err = tree.Scan(
+				nil, func(key, value []byte) error {
+					numKeys++
+					return nil
+				},
+			)
+			if err != nil || numKeys != 8000 {
+				t.Errorf("expected 8000 keys, got %d, %v", numKeys, err)
+			}
+			checkLSMTree(t, tree)
+			if err = tree.Close(); err != nil {
+				t.Fatal(err)
+			}
+		},
+	)
+
+	t.Run(
+		"check errors", func(t *testing.T) {
+			if _, err := NewLSMTree(&memoryFile{}, LSMOptions{LevelTables: 1}); err == nil {
+				t.Error("expected error for invalid options")
+			}
+			tree, err := NewLSMTree(&memoryFile{}, LSMOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = tree.Put(nil, make([]byte, MaxLSMEntrySize+1)); err == nil {
+				t.Error("expected error for an entry that does not fit on a page")
+			}
+			if err = tree.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := tree.Put([]byte("a"), nil).(*TreeClosedError); !ok {
+				t.Error("expected TreeClosedError")
+			}
+			if _, ok := tree.Close().(*TreeClosedError); !ok {
+				t.Error("expected TreeClosedError")
+			}
+		},
+	)
+}
+
+// newTestLSMTable returns an empty table of testTableSchema stored in an LSMTree.
+func newTestLSMTable(t *testing.T, file PageFile) *Table {
+	t.Helper()
+	table, err := CreateTable(
+		testTableSchema, file, 1,
+		TableOptions{Engine: LSMEngine, LSM: testLSMOptions(LeveledCompaction)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func TestTable_LSMEngine(t *testing.T) {
+	t.Run(
+		"check records and indexes", func(t *testing.T) {
+			file := &memoryFile{}
+			table := newTestLSMTable(t, file)
+			if table.Engine() != LSMEngine {
+				t.Error("expected LSMEngine")
+			}
+			if err := table.CreateIndex(nil, nameIndex); err != nil {
+				t.Fatal(err)
+			}
+			var addresses []RecordAddress
+			for i := 0; i < 3000; i++ {
+				address, err := table.Insert(
+					nil, testTableRecord(t, int64(i), fmt.Sprint(i%10), fmt.Sprint(i)),
+				)
+				if err != nil {
+					t.Fatal(err)
+				}
+				addresses = append(addresses, address)
+			}
+			if addresses[1000] != table.address(0, 1000) {
+				t.Errorf("expected the address of row 1000, got %v", addresses[1000])
+			}
+			if err := table.CreateIndex(nil, emailIndex); err != nil {
+				t.Fatal(err)
+			}
+			for i, address := range addresses {
+				switch i % 3 {
+				case 0:
+					record := testTableRecordWithBio(t, int64(i), "x", fmt.Sprint(i), "bio")
+					if err := table.Update(nil, address, record); err != nil {
+						t.Fatal(err)
+					}
+				case 1:
+					if err := table.Delete(nil, address); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			checkTableIndexes(t, table)
+			if found, _ := table.Lookup(nil, "name", []any{"x"}); len(found) != 1000 {
+				t.Errorf("expected 1000 records, got %d", len(found))
+			}
+			if _, ok := table.Delete(nil, addresses[1]).(*InvalidAddressError); !ok {
+				t.Error("expected InvalidAddressError for a deleted record")
+			}
+			record, err := table.Get(nil, addresses[2])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, name, _ := record.GetString(1); name != "2" {
+				t.Errorf("expected name 2, got %s", name)
+			}
+
+			var scanned []RecordAddress
+			err = table.Scan(
+				nil, func(address RecordAddress, record *Record) error {
+					scanned = append(scanned, address)
+					return nil
+				},
+			)
+			if err != nil || len(scanned) != 2000 ||
+				!sort.SliceIsSorted(scanned, func(i, j int) bool {
+					return addressLess(scanned[i], scanned[j])
+				}) {
+				t.Errorf("expected 2000 sorted addresses, got %d, %v", len(scanned), err)
+			}
+			filter := FilterDefinition{Name: "f", Columns: []string{"id"}}
+			if err = table.CreateFilter(nil, filter); err == nil {
+				t.Error("expected error for a filter of a table stored in an LSM tree")
+			}
+			if err = table.Delete(nil, addresses[2999]); err != nil {
+				t.Fatal(err)
+			}
+
+			metaPageNum, err := table.LSMMetaPageNum()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = table.Close(); err != nil {
+				t.Fatal(err)
+			}
+			table, err = OpenLSMTable(testTableSchema, file, 1, metaPageNum)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer table.Close()
+			if len(scanTable(t, table)) != 1999 {
+				t.Error("expected 1999 records after reopening")
+			}
+			address, err := table.Insert(nil, testTableRecord(t, 3000, "new", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if address != table.address(0, 3000) {
+				t.Errorf("expected the row address after the deleted row, got %v", address)
+			}
+		},
+	)
+
+	t.Run(
+		"check transactions", func(t *testing.T) {
+			table := newTestLSMTable(t, &memoryFile{})
+			defer table.Close()
+			for _, definition := range []IndexDefinition{nameIndex, emailIndex} {
+				if err := table.CreateIndex(nil, definition); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var addresses []RecordAddress
+			for i := 0; i < 3; i++ {
+				email := fmt.Sprintf("%d@example.com", i)
+				address, err := table.Insert(nil, testTableRecord(t, int64(i), "a", email))
+				if err != nil {
+					t.Fatal(err)
+				}
+				addresses = append(addresses, address)
+			}
+			before := scanTable(t, table)
+			m, err := NewTxManager(&memoryFile{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tx, _ := m.Begin()
+			inserted, err := table.Insert(tx, testTableRecord(t, 3, "b", "3@example.com"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The email of row 0 moves to row 1.
+			writes := []struct {
+				address RecordAddress
+				record  *Record
+			}{
+				{addresses[1], testTableRecord(t, 1, "b", "x@example.com")},
+				{addresses[0], testTableRecord(t, 0, "b", "1@example.com")},
+				{inserted, testTableRecord(t, 3, "c", "3@example.com")},
+			}
+			for _, w := range writes {
+				if err = table.Update(tx, w.address, w.record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = table.Delete(tx, addresses[2]); err != nil {
+				t.Fatal(err)
+			}
+			found, err := table.Lookup(tx, "name", []any{"b"})
+			if err != nil || !reflect.DeepEqual(found, addresses[:2]) {
+				t.Errorf("expected %v in the transaction, got %v, %v", addresses[:2], found, err)
+			}
+			if err = tx.Rollback(); err != nil {
+				t.Fatal(err)
+			}
+			if after := scanTable(t, table); !reflect.DeepEqual(after, before) {
+				t.Errorf("expected the records before the transaction, got %v", after)
+			}
+			checkTableIndexes(t, table)
+			if found, _ := table.Lookup(nil, "name", []any{"a"}); len(found) != 3 {
+				t.Errorf("expected 3 records, got %v", found)
+			}
+
+			tx, _ = m.Begin()
+			if _, err = table.Insert(tx, testTableRecord(t, 4, "d", nil)); err != nil {
+				t.Fatal(err)
+			}
+			if err = tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			if found, _ := table.Lookup(nil, "name", []any{"d"}); len(found) != 1 {
+				t.Errorf("expected the committed record, got %v", found)
+			}
+			checkTableIndexes(t, table)
+		},
+	)
+
+	t.Run(
+		"check heap engine", func(t *testing.T) {
+			table, err := CreateTable(testTableSchema, &memoryFile{}, 1, TableOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if table.Engine() != HeapEngine || table.Close() != nil {
+				t.Error("expected an open heap table")
+			}
+			if _, err = table.LSMMetaPageNum(); err == nil {
+				t.Error("expected error for a heap table")
+			}
+			_, err = CreateTable(testTableSchema, &memoryFile{}, 1, TableOptions{Engine: 2})
+			if err == nil {
+				t.Error("expected error for an unknown engine")
+			}
+		},
+	)
+}
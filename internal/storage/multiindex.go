@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+
+	"kyadb/internal/structs/element"
+)
+
+/*
+ * A multi-valued index stores an entry for each element of the array or map in its column, all
+ * pointing to the home address of the record. It answers containment queries, such as which
+ * records have a given tag in an array of tags or a given key in a map of attributes.
+ *
+ * Elements of a value that are equal by the collation of the index are stored once, and null
+ * elements of arrays are not stored. A record whose column is null has no entries. Multi-valued
+ * indexes are always BTree indexes and cannot be unique.
+ */
+
+// IndexElements selects the values of a column that an index stores entries for.
+type IndexElements uint8
+
+const (
+	// WholeValues stores an entry for the values of the columns of each record.
+	WholeValues IndexElements = iota
+
+	// ArrayElements stores an entry for each element of an array column.
+	ArrayElements
+
+	// MapKeys stores an entry for each key of a map column.
+	MapKeys
+
+	// MapEntries stores an entry for each key of a map column with the value of the key, so keys
+	// of the index have two values: the map key and the map value.
+	MapEntries
+)
+
+func (e IndexElements) String() string {
+	switch e {
+	case WholeValues:
+		return "whole values"
+	case ArrayElements:
+		return "array elements"
+	case MapKeys:
+		return "map keys"
+	case MapEntries:
+		return "map entries"
+	}
+	return fmt.Sprintf("IndexElements(%d)", uint8(e))
+}
+
+// checkMultiValued returns an error if the definition of the given multi-valued index cannot be
+// used with the schema of the table.
+func (t *Table) checkMultiValued(index *tableIndex) error {
+	definition := &index.definition
+	if len(definition.Columns) != 1 {
+		return fmt.Errorf(
+			"index of %v must have 1 column, got %d", definition.Elements, len(definition.Columns),
+		)
+	}
+	if definition.Unique || definition.Hash {
+		return fmt.Errorf("index of %v cannot be unique or hash", definition.Elements)
+	}
+	expected := element.ArrayType
+	switch definition.Elements {
+	case ArrayElements:
+	case MapKeys, MapEntries:
+		expected = element.MapType
+	default:
+		return fmt.Errorf("unknown index elements %v", definition.Elements)
+	}
+	if columnType := t.schema[index.positions[0]].Type; columnType != expected &&
+		columnType != element.AnyType {
+		return &element.TypeMismatchError{Expected: expected, Actual: columnType}
+	}
+	return nil
+}
+
+// elementKeys returns the keys of the given elements of the given value, without keys that are
+// equal by the given comparator.
+func elementKeys(
+	value any, elements IndexElements, comparator element.Comparator,
+) ([][]any, error) {
+	var keys [][]any
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case element.Array:
+		if elements != ArrayElements {
+			return nil, fmt.Errorf("cannot index %v of an array", elements)
+		}
+		for _, elem := range v.Values {
+			if elem != nil {
+				keys = append(keys, []any{elem})
+			}
+		}
+	case element.Map:
+		if elements == ArrayElements {
+			return nil, fmt.Errorf("cannot index %v of a map", elements)
+		}
+		for _, key := range element.SortedKeys(v.Data) {
+			if elements == MapKeys {
+				keys = append(keys, []any{key})
+			} else {
+				keys = append(keys, []any{key, v.Data[key]})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("cannot index %v of %T value", elements, value)
+	}
+
+	encoded, err := encodeKeys(keys, comparator)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(keys))
+	unique := keys[:0]
+	for i, key := range keys {
+		if !seen[encoded[i]] {
+			seen[encoded[i]] = true
+			unique = append(unique, key)
+		}
+	}
+	return unique, nil
+}
+
+// ContainsAll returns the home addresses of the records for which the index with the given name
+// stores every one of the given keys, in address order. For a multi-valued index, these are the
+// records whose column contains all the given elements. Keys are matched as by Lookup, so for an
+// index of map entries a key with only a map key matches the entries of the map key with any value.
+func (t *Table) ContainsAll(tx *Tx, name string, keys ...[]any) ([]RecordAddress, error) {
+	if err := t.lockQuery(tx); err != nil {
+		return nil, err
+	}
+	defer t.mutex.Unlock()
+	index, err := t.readyIndex(name)
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	var matches map[RecordAddress]bool
+	for _, key := range keys {
+		addresses, err := index.lookup(key)
+		if err != nil {
+			return nil, err
+		}
+		found := make(map[RecordAddress]bool, len(addresses))
+		for _, address := range addresses {
+			if matches == nil || matches[address] {
+				found[address] = true
+			}
+		}
+		if matches = found; len(matches) == 0 {
+			break
+		}
+	}
+	return sortedAddresses(matches), nil
+}
+
+// ContainsAny returns the home addresses of the records for which the index with the given name
+// stores at least one of the given keys, in address order. Keys are matched as by ContainsAll.
+func (t *Table) ContainsAny(tx *Tx, name string, keys ...[]any) ([]RecordAddress, error) {
+	if err := t.lockQuery(tx); err != nil {
+		return nil, err
+	}
+	defer t.mutex.Unlock()
+	index, err := t.readyIndex(name)
+	if err != nil {
+		return nil, err
+	}
+	matches := make(map[RecordAddress]bool)
+	for _, key := range keys {
+		addresses, err := index.lookup(key)
+		if err != nil {
+			return nil, err
+		}
+		for _, address := range addresses {
+			matches[address] = true
+		}
+	}
+	return sortedAddresses(matches), nil
+}
+
+// sortedAddresses returns the addresses in the given set ordered by file, page and slot.
+func sortedAddresses(set map[RecordAddress]bool) []RecordAddress {
+	addresses := make([]RecordAddress, 0, len(set))
+	for address := range set {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(
+		addresses, func(i, j int) bool {
+			return addressLess(addresses[i], addresses[j])
+		},
+	)
+	return addresses
+}
+
+// addressLess returns true if the first address is ordered before the second by file, page and
+// slot.
+func addressLess(a, b RecordAddress) bool {
+	if a.FileID != b.FileID {
+		return a.FileID < b.FileID
+	}
+	if a.PageNum != b.PageNum {
+		return a.PageNum < b.PageNum
+	}
+	return a.SlotNum < b.SlotNum
+}
@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"kyadb/internal/structs/element"
+)
+
+var testTagsSchema = Schema{
+	{Name: "id", ValueType: ValueType{Type: element.Int64Type}},
+	{
+		Name:      "tags",
+		ValueType: ValueType{Type: element.ArrayType, Elem: &ValueType{Type: element.AnyType}},
+	},
+	{
+		Name: "attrs",
+		ValueType: ValueType{
+			Type: element.MapType, Key: element.StringType,
+			Elem: &ValueType{Type: element.AnyType},
+		},
+	},
+}
+
+var (
+	tagsIndex = IndexDefinition{
+		Name: "tags", Columns: []string{"tags"}, Elements: ArrayElements,
+	}
+	attrKeysIndex = IndexDefinition{
+		Name: "keys", Columns: []string{"attrs"}, Elements: MapKeys,
+	}
+	attrEntryIndex = IndexDefinition{
+		Name: "entries", Columns: []string{"attrs"}, Elements: MapEntries,
+	}
+)
+
+// testTagsRecord returns a record of testTagsSchema, whose tags and attribute values can be null.
+// nil tags or attributes are stored as null.
+func testTagsRecord(t *testing.T, id int64, tags []any, attrs map[any]any) *Record {
+	t.Helper()
+	r := NewRecord(uint16(len(testTagsSchema)))
+	values := []any{id, nil, nil}
+	if tags != nil {
+		values[1] = element.Array{ElementType: element.AnyType, Values: tags}
+	}
+	if attrs != nil {
+		values[2] = element.Map{
+			KeyType: element.StringType, ValueType: element.AnyType, Data: attrs,
+		}
+	}
+	for i, value := range values {
+		if err := r.setValue(ElementPosition(i), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func newTestTagsTable(t *testing.T) (*Table, []RecordAddress) {
+	t.Helper()
+	table, err := OpenTable(testTagsSchema, &memoryFile{}, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, definition := range []IndexDefinition{tagsIndex, attrKeysIndex, attrEntryIndex} {
+		if err = table.CreateIndex(nil, definition); err != nil {
+			t.Fatal(err)
+		}
+	}
+	records := []*Record{
+		testTagsRecord(t, 0, []any{"red", "blue"}, map[any]any{"size": int32(1)}),
+		testTagsRecord(t, 1, []any{"blue", "blue", nil}, map[any]any{"size": int32(2), "w": nil}),
+		testTagsRecord(t, 2, []any{}, map[any]any{}),
+		testTagsRecord(t, 3, nil, nil),
+		testTagsRecord(t, 4, []any{"green", "red"}, map[any]any{"w": int32(1)}),
+	}
+	var addresses []RecordAddress
+	for _, record := range records {
+		address, err := table.Insert(nil, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addresses = append(addresses, address)
+	}
+	return table, addresses
+}
+
+func TestTable_ContainsAll(t *testing.T) {
+	t.Run(
+		"check array elements", func(t *testing.T) {
+			table, addresses := newTestTagsTable(t)
+			checkTableIndexes(t, table)
+			tests := []struct {
+				keys     [][]any
+				expected []RecordAddress
+			}{
+				{[][]any{{"red"}}, []RecordAddress{addresses[0], addresses[4]}},
+				{[][]any{{"blue"}}, []RecordAddress{addresses[0], addresses[1]}},
+				{[][]any{{"red"}, {"blue"}}, []RecordAddress{addresses[0]}},
+				{[][]any{{"red"}, {"yellow"}}, []RecordAddress{}},
+				{nil, nil},
+			}
+			for _, test := range tests {
+				found, err := table.ContainsAll(nil, "tags", test.keys...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(found, test.expected) {
+					t.Errorf("%v: expected %v, got %v", test.keys, test.expected, found)
+				}
+			}
+			if table.indexes[0].btree.Len() != 5 {
+				t.Errorf("expected 5 entries, got %d", table.indexes[0].btree.Len())
+			}
+		},
+	)
+
+	t.Run(
+		"check map keys and entries", func(t *testing.T) {
+			table, addresses := newTestTagsTable(t)
+			found, _ := table.ContainsAll(nil, "keys", []any{"size"}, []any{"w"})
+			if !reflect.DeepEqual(found, []RecordAddress{addresses[1]}) {
+				t.Errorf("expected %v, got %v", addresses[1], found)
+			}
+			found, _ = table.ContainsAll(nil, "entries", []any{"w", int32(1)})
+			if !reflect.DeepEqual(found, []RecordAddress{addresses[4]}) {
+				t.Errorf("expected %v, got %v", addresses[4], found)
+			}
+			found, _ = table.ContainsAll(nil, "entries", []any{"w", nil})
+			if !reflect.DeepEqual(found, []RecordAddress{addresses[1]}) {
+				t.Errorf("expected %v, got %v", addresses[1], found)
+			}
+			found, _ = table.ContainsAll(nil, "entries", []any{"w"})
+			if !reflect.DeepEqual(found, []RecordAddress{addresses[1], addresses[4]}) {
+				t.Errorf("expected records with key w, got %v", found)
+			}
+		},
+	)
+
+	t.Run(
+		"check updates and deletes", func(t *testing.T) {
+			table, addresses := newTestTagsTable(t)
+			record := testTagsRecord(t, 0, []any{"blue", "yellow"}, map[any]any{"size": int32(3)})
+			if err := table.Update(nil, addresses[0], record); err != nil {
+				t.Fatal(err)
+			}
+			if err := table.Delete(nil, addresses[1]); err != nil {
+				t.Fatal(err)
+			}
+			checkTableIndexes(t, table)
+			found, _ := table.ContainsAny(nil, "tags", []any{"red"}, []any{"blue"})
+			if !reflect.DeepEqual(found, []RecordAddress{addresses[0], addresses[4]}) {
+				t.Errorf("expected records with red or blue tags, got %v", found)
+			}
+			found, _ = table.ContainsAll(nil, "entries", []any{"size", int32(3)})
+			if !reflect.DeepEqual(found, []RecordAddress{addresses[0]}) {
+				t.Errorf("expected %v, got %v", addresses[0], found)
+			}
+			found, _ = table.ContainsAll(nil, "entries", []any{"size", int32(1)})
+			if len(found) != 0 {
+				t.Errorf("expected no records, got %v", found)
+			}
+		},
+	)
+
+	t.Run(
+		"check collated elements", func(t *testing.T) {
+			table, err := OpenTable(testTagsSchema, &memoryFile{}, 1, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			definition := tagsIndex
+			definition.Collation = "nocase"
+			if err = table.CreateIndex(nil, definition); err != nil {
+				t.Fatal(err)
+			}
+			record := testTagsRecord(t, 0, []any{"Go", "GO", "go", "Rust"}, nil)
+			address, err := table.Insert(nil, record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if table.indexes[0].btree.Len() != 2 {
+				t.Errorf("expected 2 entries, got %d", table.indexes[0].btree.Len())
+			}
+			record = testTagsRecord(t, 0, []any{"go"}, nil)
+			if err = table.Update(nil, address, record); err != nil {
+				t.Fatal(err)
+			}
+			found, err := table.ContainsAll(nil, "tags", []any{"gO"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(found, []RecordAddress{address}) {
+				t.Errorf("expected %v, got %v", address, found)
+			}
+			if table.indexes[0].btree.Len() != 1 {
+				t.Errorf("expected 1 entry, got %d", table.indexes[0].btree.Len())
+			}
+			checkTableIndexes(t, table)
+		},
+	)
+
+	t.Run(
+		"check index of existing records", func(t *testing.T) {
+			table, addresses := newTestTagsTable(t)
+			definition := IndexDefinition{
+				Name: "tags desc", Columns: []string{"tags"}, Elements: ArrayElements,
+				Orders: []element.SortOrder{element.Descending},
+			}
+			if err := table.CreateIndex(nil, definition); err != nil {
+				t.Fatal(err)
+			}
+			checkTableIndexes(t, table)
+			found, _ := table.ContainsAny(nil, "tags desc", []any{"green"})
+			if !reflect.DeepEqual(found, []RecordAddress{addresses[4]}) {
+				t.Errorf("expected %v, got %v", addresses[4], found)
+			}
+		},
+	)
+
+	t.Run(
+		"check invalid definitions", func(t *testing.T) {
+			table, _ := newTestTagsTable(t)
+			definitions := []IndexDefinition{
+				{Name: "a", Columns: []string{"tags", "id"}, Elements: ArrayElements},
+				{Name: "b", Columns: []string{"tags"}, Elements: ArrayElements, Unique: true},
+				{Name: "c", Columns: []string{"attrs"}, Elements: ArrayElements},
+				{Name: "d", Columns: []string{"tags"}, Elements: MapKeys},
+				{Name: "e", Columns: []string{"tags"}, Elements: IndexElements(9)},
+				{
+					Name: "f", Columns: []string{"attrs"}, Elements: MapEntries,
+					Orders: make([]element.SortOrder, 3),
+				},
+			}
+			for _, definition := range definitions {
+				if err := table.CreateIndex(nil, definition); err == nil {
+					t.Errorf("%s: expected error", definition.Name)
+				}
+			}
+			if _, err := table.ContainsAll(nil, "missing", []any{"a"}); err == nil {
+				t.Error("expected error for unknown index")
+			}
+		},
+	)
+}
@@ -0,0 +1,558 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+/*
+ * A versioned table, created with TableOptions.Versions or opened with OpenVersionedTable, keeps
+ * the versions of its records that readers may still need, so that reading the table does not wait
+ * for the transaction that writes it. Its records are written in transactions of its TxManager
+ * only, and its file should not be written outside them.
+ *
+ * Every slot of a versioned table stores a version of a record as a record with a single bytes
+ * element: 8 bytes storing the ID of the transaction that created the version, 8 bytes storing
+ * the ID of the transaction that deleted the version or replaced it by a newer one, or 0, 8 bytes
+ * storing the slot entry of the address of the previous version of the record, or noVersion, 8
+ * bytes storing the slot entry of the home address of the record if the version is not stored at
+ * its home address, or noVersion, and then the bytes of the record itself. As the header has a
+ * fixed width, stamping a version with the transaction that deletes it rewrites it in place.
+ *
+ * The newest version of a record is stored at its home address, or at the address its home slot
+ * is forwarded to, and its older versions form a chain from it, newest first, linked with slot
+ * entries like forwarded addresses. An update copies the version it replaces to another slot,
+ * stamped with the updating transaction, and links the new version to the copy, while a delete
+ * only stamps the newest version. A version that a transaction replaces or deletes is not kept if
+ * the same transaction created it, as no other transaction could ever see it.
+ *
+ * Reads outside a writing transaction see the table through a snapshot of the transactions that
+ * were committed when the snapshot was taken. They read the committed pages of the file instead
+ * of waiting for the transaction that holds the table, and return the newest version of each
+ * record that a transaction of the snapshot created, unless a transaction of the snapshot deleted
+ * it. Vacuum removes the versions that were replaced or deleted by a transaction that all current
+ * and future snapshots see, as none of them can see those versions anymore.
+ *
+ * All integers are stored little-endian.
+ */
+
+const (
+	versionHeaderSize = 32
+
+	// noVersion is stored instead of the slot entry of an address that a version does not have.
+	// It is not the slot entry of any address, as file ID 0xffff is not a valid file ID.
+	noVersion slotEntry = math.MaxUint64
+)
+
+// snapshot is the set of transactions whose writes a reader sees: the transactions whose ID is
+// below xmax, other than active, which was not committed yet when the snapshot was taken. All
+// transactions whose ID is below xmin were committed or rolled back by then.
+type snapshot struct {
+	xmin   uint64
+	xmax   uint64
+	active uint64
+}
+
+// recordVersion is a version of a record of a versioned table.
+type recordVersion struct {
+	creator  uint64
+	deleter  uint64
+	previous slotEntry
+	home     slotEntry
+	record   *Record
+}
+
+// sees returns true if the writes of the transaction with the given ID are in the snapshot.
+// Transactions that were rolled back are in the snapshot too, but they leave no writes behind.
+func (s *snapshot) sees(id uint64) bool {
+	return id < s.xmax && id != s.active
+}
+
+// BeginRead starts a read-only transaction, which reads versioned tables through a snapshot of the
+// transactions committed so far, without waiting for the active transaction. Its ID is 0, and it
+// can be committed or rolled back at any time, while other transactions begin and end. Versions
+// that the snapshot needs are kept until then.
+func (m *TxManager) BeginRead() *Tx {
+	return &Tx{
+		manager:  m,
+		readOnly: true,
+		snapshot: m.takeSnapshot(),
+		views:    make(map[PageFile]*txFile),
+	}
+}
+
+// takeSnapshot returns a snapshot of the transactions committed so far, which keeps the versions
+// that it needs from being removed until it is released with dropSnapshot.
+func (m *TxManager) takeSnapshot() *snapshot {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s := &snapshot{xmin: m.nextID, xmax: m.nextID, active: m.activeID}
+	if m.activeID != 0 {
+		s.xmin = m.activeID
+	}
+	m.snapshots[s] = true
+	return s
+}
+
+// dropSnapshot releases the given snapshot.
+func (m *TxManager) dropSnapshot(s *snapshot) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.snapshots, s)
+}
+
+// horizon returns the ID below which all transactions are seen by every snapshot, including the
+// snapshots taken from now on.
+func (m *TxManager) horizon() uint64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	horizon := m.nextID
+	for s := range m.snapshots {
+		if s.xmin < horizon {
+			horizon = s.xmin
+		}
+	}
+	return horizon
+}
+
+// encode returns the record that stores the version in a slot.
+func (v *recordVersion) encode() (*Record, error) {
+	b := make([]byte, versionHeaderSize)
+	binary.LittleEndian.PutUint64(b[0:], v.creator)
+	binary.LittleEndian.PutUint64(b[8:], v.deleter)
+	binary.LittleEndian.PutUint64(b[16:], uint64(v.previous))
+	binary.LittleEndian.PutUint64(b[24:], uint64(v.home))
+	stored := NewRecord(1)
+	if err := stored.SetBytes(0, append(b, recordBytes(v.record)...)); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// decodeVersion returns the version stored by the given record at the given address, with a copy
+// of its record.
+func decodeVersion(at RecordAddress, stored *Record) (*recordVersion, error) {
+	isNull, b, err := stored.GetBytes(0)
+	if err == nil && (isNull || len(b) < versionHeaderSize) {
+		err = fmt.Errorf("record version is too short")
+	}
+	if err != nil {
+		return nil, &CorruptTableError{at, err.Error()}
+	}
+	record, err := decodeRow(at, b[versionHeaderSize:])
+	if err != nil {
+		return nil, err
+	}
+	return &recordVersion{
+		creator:  binary.LittleEndian.Uint64(b[0:]),
+		deleter:  binary.LittleEndian.Uint64(b[8:]),
+		previous: slotEntry(binary.LittleEndian.Uint64(b[16:])),
+		home:     slotEntry(binary.LittleEndian.Uint64(b[24:])),
+		record:   record,
+	}, nil
+}
+
+// homeEntry returns the home field of a version of the record with the given home address that is
+// stored at the given address.
+func homeEntry(address RecordAddress, at RecordAddress) slotEntry {
+	if at == address {
+		return noVersion
+	}
+	return recordAddressToSlotEntry(address)
+}
+
+// OpenVersionedTable returns a versioned table, like OpenTable, whose record versions are stored
+// on the table pages with the given page numbers of a file of the given TxManager, such as the
+// pages of a table created with TableOptions.Versions.
+func OpenVersionedTable(
+	schema Schema, file PageFile, fileID uint16, pageNums []uint32, manager *TxManager,
+) (*Table, error) {
+	if _, ok := manager.indexes[file]; !ok {
+		return nil, fmt.Errorf("file is not managed by the transaction manager")
+	}
+	t, err := OpenTable(schema, file, fileID, pageNums)
+	if err != nil {
+		return nil, err
+	}
+	t.versions, t.file.versions = manager, manager
+	return t, nil
+}
+
+// storedRecord returns the record to store for the given record when it is inserted: the record
+// itself, or its first version in a versioned table.
+func (t *Table) storedRecord(record *Record) (*Record, error) {
+	if t.versions == nil {
+		return record, nil
+	}
+	version := &recordVersion{
+		creator: t.tx.id, previous: noVersion, home: noVersion, record: record,
+	}
+	return version.encode()
+}
+
+// readVersion returns the version stored at the given address of the given file, which must have
+// the given home field.
+func (t *Table) readVersion(
+	file PageFile, at RecordAddress, home slotEntry,
+) (*recordVersion, error) {
+	if at.FileID != t.fileID {
+		return nil, &CorruptTableError{at, fmt.Sprintf("version linked to invalid address %v", at)}
+	}
+	pages, err := file.ReadPages(at.PageNum, 1)
+	if err != nil {
+		return nil, err
+	}
+	page := &(*pages)[0]
+	if at.SlotNum >= page.getNumSlots() {
+		return nil, &CorruptTableError{at, "version linked to missing slot"}
+	}
+	stored, forwarded, err := page.GetRecord(at.SlotNum)
+	if err != nil {
+		return nil, err
+	}
+	if forwarded != nil {
+		return nil, &CorruptTableError{at, "version forwarded"}
+	}
+	version, err := decodeVersion(at, stored)
+	if err != nil {
+		return nil, err
+	}
+	if version.home != home {
+		return nil, &CorruptTableError{at, "version of another record"}
+	}
+	return version, nil
+}
+
+// writeVersion rewrites the version stored at the given address in place.
+func (t *Table) writeVersion(at RecordAddress, version *recordVersion) error {
+	stored, err := version.encode()
+	if err != nil {
+		return err
+	}
+	page, err := t.readPage(at.PageNum)
+	if err != nil {
+		return err
+	}
+	if _, err = page.UpdateRecord(at.SlotNum, stored); err != nil {
+		return err
+	}
+	return t.writePage(at.PageNum, page)
+}
+
+// newest returns the newest version of the record with the given home address in the given file,
+// and the address it is stored at. An InvalidAddressError is returned if the slot at the address
+// stores an older version of a record or the newest version of a record moved from its home.
+func (t *Table) newest(
+	file PageFile, address RecordAddress,
+) (*recordVersion, RecordAddress, error) {
+	pages, err := file.ReadPages(address.PageNum, 1)
+	if err != nil {
+		return nil, address, err
+	}
+	page := &(*pages)[0]
+	if address.SlotNum >= page.getNumSlots() {
+		return nil, address, &InvalidAddressError{address}
+	}
+	stored, forwarded, err := page.GetRecord(address.SlotNum)
+	if err != nil {
+		return nil, address, err
+	}
+	if forwarded != nil {
+		version, err := t.readVersion(file, *forwarded, recordAddressToSlotEntry(address))
+		return version, *forwarded, err
+	}
+	version, err := decodeVersion(address, stored)
+	if err != nil {
+		return nil, address, err
+	}
+	if version.home != noVersion {
+		return nil, address, &InvalidAddressError{address}
+	}
+	return version, address, nil
+}
+
+// locateVersion returns the record of the newest version of the record with the given home
+// address, and the address it is stored at, unless the record was deleted.
+func (t *Table) locateVersion(address RecordAddress) (*Record, RecordAddress, error) {
+	version, at, err := t.newest(t.file, address)
+	if err != nil {
+		return nil, address, err
+	}
+	if version.deleter != 0 {
+		return nil, address, &RecordDeletedError{address.SlotNum}
+	}
+	return version.record, at, nil
+}
+
+// supersede returns the version of the given record that replaces the newest version of the
+// record with the given home address, stored at the given address, in the transaction that holds
+// the table. The replaced version is first copied to another slot, unless the transaction created
+// it.
+func (t *Table) supersede(
+	address RecordAddress, at RecordAddress, record *Record,
+) (*recordVersion, error) {
+	old, err := t.readVersion(t.file, at, homeEntry(address, at))
+	if err != nil {
+		return nil, err
+	}
+	version := &recordVersion{
+		creator: t.tx.id, previous: old.previous, home: homeEntry(address, at), record: record,
+	}
+	if old.creator == t.tx.id {
+		return version, nil
+	}
+	old.deleter, old.home = t.tx.id, recordAddressToSlotEntry(address)
+	stored, err := old.encode()
+	if err != nil {
+		return nil, err
+	}
+	copyAt, err := t.placeRecord(stored, false)
+	if err != nil {
+		return nil, err
+	}
+	version.previous = recordAddressToSlotEntry(copyAt)
+	return version, nil
+}
+
+// deleteVersion deletes the record with the given home address, whose newest version is stored at
+// the given address, in the transaction that holds the table. The version is stamped with the
+// transaction, unless no other transaction can see the record, which is removed right away.
+func (t *Table) deleteVersion(address RecordAddress, at RecordAddress) error {
+	version, err := t.readVersion(t.file, at, homeEntry(address, at))
+	if err != nil {
+		return err
+	}
+	if version.creator == t.tx.id && version.previous == noVersion {
+		return t.removeRecord(address, at)
+	}
+	version.deleter = t.tx.id
+	return t.writeVersion(at, version)
+}
+
+// snapshotOf returns the snapshot that a read in the given transaction, which is read-only or nil,
+// reads the table through. A nil transaction reads through a new snapshot, which the caller must
+// release with dropSnapshot.
+func (t *Table) snapshotOf(tx *Tx) (*snapshot, error) {
+	if tx == nil {
+		return t.versions.takeSnapshot(), nil
+	}
+	if tx.manager != t.versions {
+		return nil, fmt.Errorf("transaction is not of the manager of the versioned table")
+	}
+	if err := tx.check(); err != nil {
+		return nil, err
+	}
+	return tx.snapshot, nil
+}
+
+// visible returns the record of the newest version of the record with the given home address that
+// the given snapshot sees in the committed pages of the file. A RecordDeletedError is returned if
+// the snapshot sees no version, or sees the record deleted.
+func (t *Table) visible(s *snapshot, address RecordAddress) (*Record, error) {
+	version, _, err := t.newest(t.file.PageFile, address)
+	if err != nil {
+		return nil, err
+	}
+	for !s.sees(version.creator) {
+		if version.previous == noVersion {
+			return nil, &RecordDeletedError{address.SlotNum}
+		}
+		version, err = t.readVersion(
+			t.file.PageFile,
+			slotEntryToRecordAddress(version.previous),
+			recordAddressToSlotEntry(address),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if version.deleter != 0 && s.sees(version.deleter) {
+		return nil, &RecordDeletedError{address.SlotNum}
+	}
+	return version.record, nil
+}
+
+// getVersion returns the record with the given home address as seen by the snapshot of the given
+// transaction, which is read-only or nil.
+func (t *Table) getVersion(tx *Tx, address RecordAddress) (*Record, error) {
+	s, err := t.snapshotOf(tx)
+	if err != nil {
+		return nil, err
+	}
+	if tx == nil {
+		defer t.versions.dropSnapshot(s)
+	}
+	t.mutex.Lock()
+	valid := address.FileID == t.fileID && t.hasPage(address.PageNum)
+	t.mutex.Unlock()
+	if !valid {
+		return nil, &InvalidAddressError{address}
+	}
+	t.versions.pages.RLock()
+	defer t.versions.pages.RUnlock()
+	return t.visible(s, address)
+}
+
+// scanVersions calls fn with the home address and the record of each record of the table as seen
+// by the snapshot of the given transaction, which is read-only or nil, like Scan. The committed
+// pages are read one page at a time, and fn is called after each page is read.
+func (t *Table) scanVersions(
+	tx *Tx, fn func(address RecordAddress, record *Record) error,
+) error {
+	s, err := t.snapshotOf(tx)
+	if err != nil {
+		return err
+	}
+	if tx == nil {
+		defer t.versions.dropSnapshot(s)
+	}
+	t.mutex.Lock()
+	pageNums := append([]uint32(nil), t.pageNums...)
+	t.mutex.Unlock()
+
+	for _, pageNum := range pageNums {
+		addresses, records, err := t.scanVersionPage(s, pageNum)
+		if err != nil {
+			return err
+		}
+		for i, address := range addresses {
+			if err = fn(address, records[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// scanVersionPage returns the home addresses and the records of the records whose home address is
+// on the page with the given number, as seen by the given snapshot.
+func (t *Table) scanVersionPage(
+	s *snapshot, pageNum uint32,
+) ([]RecordAddress, []*Record, error) {
+	t.versions.pages.RLock()
+	defer t.versions.pages.RUnlock()
+	pages, err := t.file.PageFile.ReadPages(pageNum, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	var addresses []RecordAddress
+	var records []*Record
+	for slotNum := uint16(0); slotNum < (*pages)[0].getNumSlots(); slotNum++ {
+		address := t.address(pageNum, slotNum)
+		record, err := t.visible(s, address)
+		if isMissing(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		addresses = append(addresses, address)
+		records = append(records, record)
+	}
+	return addresses, records, nil
+}
+
+// isMissing returns true if the given error shows that a slot stores no record that is visible at
+// its address: the record was deleted, or the slot stores a version of a record whose home address
+// is elsewhere.
+func isMissing(err error) bool {
+	switch err.(type) {
+	case *RecordDeletedError, *InvalidAddressError:
+		return true
+	}
+	return false
+}
+
+// Vacuum removes the versions of the records of a versioned table that no snapshot can see
+// anymore, in the given transaction: the versions replaced or deleted by a transaction that every
+// snapshot sees. Records deleted by such a transaction are removed from their slots. It returns
+// the number of versions removed.
+func (t *Table) Vacuum(tx *Tx) (int, error) {
+	if t.versions == nil {
+		return 0, fmt.Errorf("table is not versioned")
+	}
+	if err := t.lockWrite(tx); err != nil {
+		return 0, err
+	}
+	defer t.mutex.Unlock()
+
+	horizon := t.versions.horizon()
+	removed := 0
+	for _, pageNum := range t.pageNums {
+		page, err := t.readPage(pageNum)
+		if err != nil {
+			return removed, err
+		}
+		for slotNum := uint16(0); slotNum < page.getNumSlots(); slotNum++ {
+			address := t.address(pageNum, slotNum)
+			if t.moved[address] {
+				continue
+			}
+			version, at, err := t.newest(t.file, address)
+			if isMissing(err) {
+				continue
+			}
+			if err != nil {
+				return removed, err
+			}
+			n, err := t.vacuumRecord(address, at, version, horizon)
+			removed += n
+			if err != nil {
+				return removed, err
+			}
+		}
+	}
+	return removed, nil
+}
+
+// vacuumRecord removes the versions of the record with the given home address that were replaced
+// or deleted by a transaction whose ID is below the given horizon, given its newest version stored
+// at the given address, and returns the number of versions removed.
+func (t *Table) vacuumRecord(
+	address RecordAddress, at RecordAddress, version *recordVersion, horizon uint64,
+) (int, error) {
+	if version.deleter != 0 && version.deleter < horizon {
+		removed, err := t.removeVersions(address, version.previous)
+		if err != nil {
+			return removed, err
+		}
+		return removed + 1, t.removeRecord(address, at)
+	}
+	for version.previous != noVersion {
+		previousAt := slotEntryToRecordAddress(version.previous)
+		previous, err := t.readVersion(t.file, previousAt, recordAddressToSlotEntry(address))
+		if err != nil {
+			return 0, err
+		}
+		if previous.deleter < horizon {
+			entry := version.previous
+			version.previous = noVersion
+			if err = t.writeVersion(at, version); err != nil {
+				return 0, err
+			}
+			return t.removeVersions(address, entry)
+		}
+		version, at = previous, previousAt
+	}
+	return 0, nil
+}
+
+// removeVersions removes the older versions of the record with the given home address, starting
+// from the version whose address has the given slot entry, and returns the number removed.
+func (t *Table) removeVersions(address RecordAddress, entry slotEntry) (int, error) {
+	removed := 0
+	for entry != noVersion {
+		at := slotEntryToRecordAddress(entry)
+		version, err := t.readVersion(t.file, at, recordAddressToSlotEntry(address))
+		if err != nil {
+			return removed, err
+		}
+		if err = t.deleteSlot(at); err != nil {
+			return removed, err
+		}
+		removed++
+		entry = version.previous
+	}
+	return removed, nil
+}
@@ -0,0 +1,416 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newTestVersionedTable(t *testing.T) (*Table, *TxManager) {
+	t.Helper()
+	file := &memoryFile{}
+	m, err := NewTxManager(&memoryFile{}, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := CreateTable(testTableSchema, file, 1, TableOptions{Versions: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, definition := range []IndexDefinition{nameIndex, emailIndex} {
+		if err = table.CreateIndex(nil, definition); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return table, m
+}
+
+// insertTestRecords inserts the given number of records into the table in one transaction.
+func insertTestRecords(t *testing.T, table *Table, m *TxManager, n int) []RecordAddress {
+	t.Helper()
+	tx, err := m.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var addresses []RecordAddress
+	for i := 0; i < n; i++ {
+		email := fmt.Sprintf("%d@example.com", i)
+		address, err := table.Insert(tx, testTableRecord(t, int64(i), "a", email))
+		if err != nil {
+			t.Fatal(err)
+		}
+		addresses = append(addresses, address)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return addresses
+}
+
+// scanTableIn returns the records of the table scanned in the given transaction.
+func scanTableIn(t *testing.T, table *Table, tx *Tx) map[RecordAddress]*Record {
+	t.Helper()
+	records := make(map[RecordAddress]*Record)
+	err := table.Scan(
+		tx, func(address RecordAddress, record *Record) error {
+			records[address] = record
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+// numStoredSlots returns the number of slots of the pages of the table that store a record.
+func numStoredSlots(t *testing.T, table *Table) int {
+	t.Helper()
+	n := 0
+	for _, pageNum := range table.pageNums {
+		page, err := table.readPage(pageNum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for slotNum := uint16(0); slotNum < page.getNumSlots(); slotNum++ {
+			if record, _, _ := page.GetRecord(slotNum); record != nil {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestTable_Versions(t *testing.T) {
+	t.Run(
+		"check snapshot reads", func(t *testing.T) {
+			table, m := newTestVersionedTable(t)
+			addresses := insertTestRecords(t, table, m, 10)
+			reader := m.BeginRead()
+			defer reader.Rollback()
+			before := scanTableIn(t, table, reader)
+			if len(before) != 10 {
+				t.Fatalf("expected 10 records, got %d", len(before))
+			}
+
+			tx, _ := m.Begin()
+			bio := strings.Repeat("x", PageSize-512)
+			moved := testTableRecordWithBio(t, 0, "b", "0@example.com", bio)
+			if err := table.Update(tx, addresses[0], moved); err != nil {
+				t.Fatal(err)
+			}
+			if err := table.Update(tx, addresses[1], testTableRecord(t, 1, "b", nil)); err != nil {
+				t.Fatal(err)
+			}
+			if err := table.Delete(tx, addresses[2]); err != nil {
+				t.Fatal(err)
+			}
+			inserted, err := table.Insert(tx, testTableRecord(t, 10, "b", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = table.Get(tx, addresses[2]); err == nil {
+				t.Error("expected the record deleted in the transaction")
+			}
+			if records := scanTableIn(t, table, tx); len(records) != 10 {
+				t.Errorf("expected 10 records in the transaction, got %d", len(records))
+			}
+			if records := scanTableIn(t, table, nil); !reflect.DeepEqual(records, before) {
+				t.Error("expected the committed records outside the transaction")
+			}
+			if err = tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+
+			if records := scanTableIn(t, table, reader); !reflect.DeepEqual(records, before) {
+				t.Error("expected the records of the snapshot")
+			}
+			if _, err = table.Get(reader, inserted); err == nil {
+				t.Error("expected the inserted record to be invisible to the snapshot")
+			}
+			if record, err := table.Get(reader, addresses[2]); err != nil ||
+				!reflect.DeepEqual(record, before[addresses[2]]) {
+				t.Errorf("expected the deleted record in the snapshot, got %v", err)
+			}
+			after := scanTableIn(t, table, nil)
+			if len(after) != 10 || after[inserted] == nil || after[addresses[2]] != nil {
+				t.Errorf("expected the committed records, got %d", len(after))
+			}
+			if record, _ := table.Get(nil, addresses[0]); !reflect.DeepEqual(record, moved) ||
+				len(table.moved) != 1 {
+				t.Error("expected the moved record")
+			}
+			if _, err = table.Get(nil, addresses[2]); err == nil {
+				t.Error("expected the deleted record to be gone")
+			}
+			checkTableIndexes(t, table)
+
+			reopened, err := OpenVersionedTable(
+				testTableSchema, table.file.PageFile, 1, table.pageNums, m,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if records := scanTableIn(t, reopened, reader); !reflect.DeepEqual(records, before) {
+				t.Error("expected the records of the snapshot in the reopened table")
+			}
+			if records := scanTableIn(t, reopened, nil); !reflect.DeepEqual(records, after) {
+				t.Error("expected the committed records in the reopened table")
+			}
+		},
+	)
+
+	t.Run(
+		"check reads do not wait for the transaction", func(t *testing.T) {
+			table, m := newTestVersionedTable(t)
+			addresses := insertTestRecords(t, table, m, 1)
+			tx, _ := m.Begin()
+			if err := table.Delete(tx, addresses[0]); err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan error)
+			go func() {
+				_, err := table.Get(nil, addresses[0])
+				if err == nil {
+					err = table.Scan(nil, func(RecordAddress, *Record) error { return nil })
+				}
+				done <- err
+			}()
+			if err := <-done; err != nil {
+				t.Error(err)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		},
+	)
+
+	t.Run(
+		"check concurrent readers", func(t *testing.T) {
+			table, m := newTestVersionedTable(t)
+			addresses := insertTestRecords(t, table, m, 50)
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 10; j++ {
+						reader := m.BeginRead()
+						var scans []map[RecordAddress]*Record
+						for k := 0; k < 2; k++ {
+							records := make(map[RecordAddress]*Record)
+							err := table.Scan(
+								reader, func(address RecordAddress, record *Record) error {
+									records[address] = record
+									return nil
+								},
+							)
+							if err != nil {
+								t.Error(err)
+							}
+							scans = append(scans, records)
+						}
+						if len(scans[0]) != 50 || !reflect.DeepEqual(scans[0], scans[1]) {
+							t.Error("expected the same records in both scans of a snapshot")
+						}
+						reader.Commit()
+					}
+				}()
+			}
+			for i := 0; i < 20; i++ {
+				tx, _ := m.Begin()
+				for j, address := range addresses {
+					name := fmt.Sprintf("n%d", i)
+					record := testTableRecord(t, int64(j), name, fmt.Sprintf("%d@example.com", j))
+					if err := table.Update(tx, address, record); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if _, err := table.Vacuum(tx); err != nil {
+					t.Fatal(err)
+				}
+				if err := tx.Commit(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			wg.Wait()
+		},
+	)
+
+	t.Run(
+		"check vacuum", func(t *testing.T) {
+			table, m := newTestVersionedTable(t)
+			addresses := insertTestRecords(t, table, m, 2)
+			reader := m.BeginRead()
+			before, _ := table.Get(reader, addresses[0])
+			bio := strings.Repeat("x", PageSize)
+			for i := 1; i <= 3; i++ {
+				tx, _ := m.Begin()
+				record := testTableRecordWithBio(t, 0, "a", "0@example.com", bio[:i*PageSize/4])
+				if err := table.Update(tx, addresses[0], record); err != nil {
+					t.Fatal(err)
+				}
+				if err := tx.Commit(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if n := numStoredSlots(t, table); n != 5 {
+				t.Errorf("expected 2 records and 3 older versions, got %d slots", n)
+			}
+
+			vacuum := func(expected int) {
+				t.Helper()
+				tx, _ := m.Begin()
+				removed, err := table.Vacuum(tx)
+				if err != nil || removed != expected {
+					t.Errorf("expected %d versions removed, got %d, %v", expected, removed, err)
+				}
+				if err = tx.Commit(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			vacuum(0)
+			if record, err := table.Get(reader, addresses[0]); err != nil ||
+				!reflect.DeepEqual(record, before) {
+				t.Errorf("expected the record of the snapshot, got %v", err)
+			}
+			if err := reader.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			vacuum(3)
+			if n := numStoredSlots(t, table); n != 2 || len(table.moved) != 1 {
+				t.Errorf("expected 2 records, one of them moved, got %d slots", n)
+			}
+
+			tx, _ := m.Begin()
+			for _, address := range addresses {
+				if err := table.Delete(tx, address); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			vacuum(2)
+			if n := numStoredSlots(t, table); n != 0 || len(table.moved) != 0 {
+				t.Errorf("expected no records, got %d slots", n)
+			}
+			if records := scanTableIn(t, table, nil); len(records) != 0 {
+				t.Errorf("expected no records, got %d", len(records))
+			}
+		},
+	)
+
+	t.Run(
+		"check versions of one transaction", func(t *testing.T) {
+			table, m := newTestVersionedTable(t)
+			tx, _ := m.Begin()
+			address, err := table.Insert(tx, testTableRecord(t, 1, "a", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 3; i++ {
+				if err = table.Update(tx, address, testTableRecord(t, 1, "b", nil)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			other, err := table.Insert(tx, testTableRecord(t, 2, "a", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = table.Delete(tx, other); err != nil {
+				t.Fatal(err)
+			}
+			if err = tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			if n := numStoredSlots(t, table); n != 1 {
+				t.Errorf("expected only the newest version, got %d slots", n)
+			}
+		},
+	)
+
+	t.Run(
+		"check rollback", func(t *testing.T) {
+			table, m := newTestVersionedTable(t)
+			addresses := insertTestRecords(t, table, m, 10)
+			before := scanTableIn(t, table, nil)
+			tx, _ := m.Begin()
+			for _, address := range addresses[:5] {
+				if err := table.Update(tx, address, testTableRecord(t, 0, "b", nil)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := table.Delete(tx, addresses[5]); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.Rollback(); err != nil {
+				t.Fatal(err)
+			}
+			if records := scanTableIn(t, table, nil); !reflect.DeepEqual(records, before) {
+				t.Error("expected the records from before the transaction")
+			}
+			checkTableIndexes(t, table)
+			tx, _ = m.Begin()
+			defer tx.Rollback()
+			if removed, err := table.Vacuum(tx); err != nil || removed != 0 {
+				t.Errorf("expected no versions removed, got %d, %v", removed, err)
+			}
+		},
+	)
+
+	t.Run(
+		"check errors", func(t *testing.T) {
+			table, m := newTestVersionedTable(t)
+			if _, err := table.Insert(nil, testTableRecord(t, 1, "a", nil)); err == nil {
+				t.Error("expected error for a write outside a transaction")
+			}
+			reader := m.BeginRead()
+			if _, err := table.Insert(reader, testTableRecord(t, 1, "a", nil)); err == nil {
+				t.Error("expected error for a write in a read-only transaction")
+			}
+			if _, err := reader.File(table.file.PageFile); err == nil {
+				t.Error("expected error for the file of a read-only transaction")
+			}
+			for _, tx := range []*Tx{nil, reader} {
+				if _, err := table.Lookup(tx, "name", []any{"a"}); err == nil {
+					t.Error("expected error for an index query in a snapshot read")
+				}
+			}
+			if err := reader.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			err := table.Scan(reader, func(RecordAddress, *Record) error { return nil })
+			if _, ok := err.(*TxDoneError); !ok {
+				t.Errorf("expected TxDoneError, got %v", err)
+			}
+
+			other, err := NewTxManager(&memoryFile{}, table.file.PageFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx, _ := other.Begin()
+			if _, err = table.Insert(tx, testTableRecord(t, 1, "a", nil)); err == nil {
+				t.Error("expected error for a transaction of another manager")
+			}
+			tx.Rollback()
+			if _, err = table.Get(other.BeginRead(), table.address(0, 0)); err == nil {
+				t.Error("expected error for a snapshot of another manager")
+			}
+
+			if _, err = newTestTable(t).Vacuum(nil); err == nil {
+				t.Error("expected error for a table that is not versioned")
+			}
+			_, err = OpenVersionedTable(testTableSchema, &memoryFile{}, 1, nil, m)
+			if err == nil {
+				t.Error("expected error for a file that is not managed")
+			}
+			options := TableOptions{Engine: LSMEngine, Versions: m}
+			if _, err = CreateTable(testTableSchema, &memoryFile{}, 1, options); err == nil {
+				t.Error("expected error for a versioned table stored in an LSM tree")
+			}
+		},
+	)
+}
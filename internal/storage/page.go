@@ -15,8 +15,8 @@ type PageAddress struct {
 
 // PageFullError is returned when an operation cannot be completed because the page is full.
 type PageFullError struct {
-	Available uint16
-	Needed    uint16
+	Available uint32
+	Needed    uint32
 }
 
 func (e *PageFullError) Error() string {
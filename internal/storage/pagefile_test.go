@@ -0,0 +1,39 @@
+package storage
+
+import "io"
+
+// memoryFile is a PageFile that keeps its pages in memory, for the tests of structures stored in
+// pages.
+type memoryFile struct {
+	pages    []Page
+	batches  []int
+	maxPages int
+}
+
+func (f *memoryFile) AppendPages(pages *[]Page) ([]uint32, error) {
+	var pageNums []uint32
+	for _, page := range *pages {
+		if f.maxPages > 0 && len(f.pages) == f.maxPages {
+			return pageNums, &FileFullError{}
+		}
+		pageNums = append(pageNums, uint32(len(f.pages)))
+		f.pages = append(f.pages, page)
+	}
+	f.batches = append(f.batches, len(*pages))
+	return pageNums, nil
+}
+
+func (f *memoryFile) ReadPages(pageNum uint32, numPages uint32) (*[]Page, error) {
+	if uint64(pageNum)+uint64(numPages) > uint64(len(f.pages)) {
+		return nil, io.EOF
+	}
+	pages := append([]Page(nil), f.pages[pageNum:pageNum+numPages]...)
+	return &pages, nil
+}
+
+func (f *memoryFile) WritePages(pages *[]Page, pageNum uint32) (uint32, error) {
+	if uint64(pageNum)+uint64(len(*pages)) > uint64(len(f.pages)) {
+		return 0, io.EOF
+	}
+	return uint32(copy(f.pages[pageNum:], *pages)), nil
+}
@@ -4,19 +4,37 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math"
+	"math/bits"
+	"sort"
 	"time"
 
 	"kyadb/internal/structs/element"
 )
 
+/*
+ * Records store a fixed number of elements. An untagged record starts with 2 bytes storing the
+ * length of the record, followed by 2 bytes storing the length of the rest of the header, followed
+ * by 2 bytes per element position storing the offset of the element within the record. An offset
+ * of 0 means that the element is null.
+ * A tagged record starts with two zero bytes followed by a byte storing the element encoding of the
+ * record. The length, header length and offsets that follow are stored with as many bytes as the
+ * encoding uses for the lengths of its elements, i.e. 4 bytes each for element.WideEncoding.
+ * A tagged record can use the null bitmap layout instead, which is flagged in the encoding byte.
+ * Its header length is followed by the number of element positions, a null bitmap with a bit set
+ * for every position that was explicitly set to null, a value bitmap with a bit set for every
+ * position that holds a value, and the offsets of the values only, in the order of their
+ * positions. A position whose bits are both clear has never been set.
+ * The elements are stored back to back after the header.
+ */
+
 type Record element.Bytes
 type ElementPosition = uint16
 
 // WriteOverflowError is returned when there is not enough space in the record to write the given
 // Data.
 type WriteOverflowError struct {
-	availableBytes uint16
-	requiredBytes  uint16
+	availableBytes uint32
+	requiredBytes  uint32
 	data           any
 }
 
@@ -36,6 +54,23 @@ type InvalidValueTypeError struct {
 	valueType element.Type
 }
 
+// CorruptRecordError is returned when a record cannot be decoded because its bytes are truncated or
+// malformed.
+type CorruptRecordError = element.CorruptRecordError
+
+// PositionOutOfRangeError is returned when a value is requested at an element position that the
+// record does not have.
+type PositionOutOfRangeError struct {
+	position    ElementPosition
+	numElements uint16
+}
+
+func (e *PositionOutOfRangeError) Error() string {
+	return fmt.Sprintf(
+		"element position %d out of range for record with %d elements", e.position, e.numElements,
+	)
+}
+
 func (e *WriteOverflowError) Error() string {
 	return fmt.Sprintf("not enough space to write %v bytes for %v", e.requiredBytes, e.data)
 }
@@ -64,20 +99,355 @@ func (e *InvalidValueTypeError) Error() string {
 	return fmt.Sprintf("invalid map value type '%s'", valueTypeName)
 }
 
-func (r *Record) setLength(length uint16) {
-	binary.LittleEndian.PutUint16((*r)[0:2], length)
+// taggedPrefixLength is the number of bytes that precede the length field of a tagged record. A
+// tagged record starts with two zero bytes, which can never be the length of an untagged record,
+// followed by the element encoding used by the record.
+const taggedPrefixLength = 3
+
+// nullBitmapLayout is the flag set in the encoding byte of a tagged record that uses the null
+// bitmap layout.
+const nullBitmapLayout = 0x40
+
+// isTagged returns true if the record starts with the tagged record prefix.
+func (r *Record) isTagged() bool {
+	return len(*r) > 2 && (*r)[0] == 0 && (*r)[1] == 0
+}
+
+// hasNullBitmap returns true if the record uses the null bitmap layout.
+func (r *Record) hasNullBitmap() bool {
+	return r.isTagged() && (*r)[2]&nullBitmapLayout != 0
+}
+
+// lengthFieldOffset returns the offset of the length field of the record.
+func (r *Record) lengthFieldOffset() uint32 {
+	if r.isTagged() {
+		return taggedPrefixLength
+	}
+	return 0
+}
+
+// fieldSize returns the number of bytes used by the length, header length and offset fields of the
+// record.
+func (r *Record) fieldSize() uint32 {
+	return element.LengthFieldSize(r.Encoding())
+}
+
+func (r *Record) readField(offset uint32) uint32 {
+	value, _, _ := element.ReadLength((*element.Bytes)(r), offset, r.Encoding())
+	return value
+}
+
+func (r *Record) writeField(offset uint32, value uint32) {
+	element.WriteLength((*element.Bytes)(r), offset, value, r.Encoding())
+}
+
+func (r *Record) setLength(length uint32) {
+	r.writeField(r.lengthFieldOffset(), length)
+}
+
+func (r *Record) headerLength() uint32 {
+	return r.readField(r.lengthFieldOffset() + r.fieldSize())
+}
+
+func (r *Record) setHeaderLength(headerLength uint32) {
+	r.writeField(r.lengthFieldOffset()+r.fieldSize(), headerLength)
+}
+
+// headerEnd returns the offset right after the last element offset stored in the header.
+func (r *Record) headerEnd() uint32 {
+	return r.lengthFieldOffset() + r.fieldSize() + r.headerLength()
+}
+
+// countFieldOffset returns the offset of the field storing the number of element positions of a
+// record with a null bitmap.
+func (r *Record) countFieldOffset() uint32 {
+	return r.lengthFieldOffset() + 2*r.fieldSize()
+}
+
+// bitmapSize returns the number of bytes taken by each bitmap of a record with a null bitmap.
+func (r *Record) bitmapSize() uint32 {
+	return (uint32(r.numElements()) + 7) / 8
+}
+
+func (r *Record) nullBitmapOffset() uint32 {
+	return r.countFieldOffset() + r.fieldSize()
+}
+
+func (r *Record) valueBitmapOffset() uint32 {
+	return r.nullBitmapOffset() + r.bitmapSize()
+}
+
+func (r *Record) isBitSet(bitmapOffset uint32, position ElementPosition) bool {
+	return (*r)[bitmapOffset+uint32(position/8)]&(1<<(position%8)) != 0
+}
+
+func (r *Record) setBit(bitmapOffset uint32, position ElementPosition, set bool) {
+	if set {
+		(*r)[bitmapOffset+uint32(position/8)] |= 1 << (position % 8)
+	} else {
+		(*r)[bitmapOffset+uint32(position/8)] &^= 1 << (position % 8)
+	}
+}
+
+// countBits returns the number of bits set in the bitmap at the given offset for the element
+// positions before end.
+func (r *Record) countBits(bitmapOffset uint32, end ElementPosition) uint32 {
+	var count int
+	for _, b := range (*r)[bitmapOffset : bitmapOffset+uint32(end/8)] {
+		count += bits.OnesCount8(b)
+	}
+	if end%8 != 0 {
+		count += bits.OnesCount8((*r)[bitmapOffset+uint32(end/8)] & (1<<(end%8) - 1))
+	}
+	return uint32(count)
+}
+
+// offsetFieldOffset returns the offset of the field storing the offset of the element at the given
+// position. In a record with a null bitmap, the position must hold a value.
+func (r *Record) offsetFieldOffset(position ElementPosition) uint32 {
+	if r.hasNullBitmap() {
+		valueBitmapOffset := r.valueBitmapOffset()
+		return valueBitmapOffset + r.bitmapSize() +
+			r.fieldSize()*r.countBits(valueBitmapOffset, position)
+	}
+	return r.lengthFieldOffset() + r.fieldSize()*(2+uint32(position))
+}
+
+func (r *Record) offsetForPosition(position ElementPosition) uint32 {
+	if r.hasNullBitmap() && !r.isBitSet(r.valueBitmapOffset(), position) {
+		return 0
+	}
+	return r.readField(r.offsetFieldOffset(position))
+}
+
+func (r *Record) setOffset(position ElementPosition, offset uint32) {
+	r.writeField(r.offsetFieldOffset(position), offset)
+}
+
+// numElements returns the number of element positions in the record.
+func (r *Record) numElements() uint16 {
+	if r.hasNullBitmap() {
+		return uint16(r.readField(r.countFieldOffset()))
+	}
+	return uint16((r.headerLength() - r.fieldSize()) / r.fieldSize())
+}
+
+// checkHeader returns a CorruptRecordError if the length and header length fields of the record
+// cannot be read or are inconsistent with each other or with the bytes of the record.
+func (r *Record) checkHeader() error {
+	if r.isTagged() {
+		if (*r)[2]&^(element.WideEncoding|element.CompactEncoding|nullBitmapLayout) != 0 {
+			return &CorruptRecordError{
+				Offset: 2, Reason: fmt.Sprintf("unknown encoding %d", (*r)[2]),
+			}
+		}
+	}
+	fieldSize := r.fieldSize()
+	if uint64(len(*r)) < uint64(r.lengthFieldOffset()+2*fieldSize) {
+		return &CorruptRecordError{Offset: 0, Reason: "record shorter than its header"}
+	}
+	length := r.Length()
+	if uint64(length) > uint64(len(*r)) {
+		return &CorruptRecordError{
+			Offset: r.lengthFieldOffset(),
+			Reason: fmt.Sprintf("record length %d exceeds %d available bytes", length, len(*r)),
+		}
+	}
+	if r.hasNullBitmap() {
+		return r.checkNullBitmapHeader(length)
+	}
+	headerLength := r.headerLength()
+	if headerLength < fieldSize || headerLength%fieldSize != 0 ||
+		uint64(r.lengthFieldOffset()+fieldSize)+uint64(headerLength) > uint64(length) {
+		return &CorruptRecordError{
+			Offset: r.lengthFieldOffset() + fieldSize,
+			Reason: fmt.Sprintf("invalid header length %d", headerLength),
+		}
+	}
+	return nil
+}
+
+// checkNullBitmapHeader works like checkHeader for the part of the header of a record with a null
+// bitmap that follows the length field.
+func (r *Record) checkNullBitmapHeader(length uint32) error {
+	fieldSize := r.fieldSize()
+	countFieldOffset := r.countFieldOffset()
+	if uint64(countFieldOffset)+uint64(fieldSize) > uint64(length) {
+		return &CorruptRecordError{Offset: 0, Reason: "record shorter than its header"}
+	}
+	numElements := r.readField(countFieldOffset)
+	if numElements > math.MaxUint16 {
+		return &CorruptRecordError{
+			Offset: countFieldOffset,
+			Reason: fmt.Sprintf("invalid number of elements %d", numElements),
+		}
+	}
+	bitmapSize := (numElements + 7) / 8
+	headerLength := r.headerLength()
+	minHeaderLength := 2*fieldSize + 2*bitmapSize
+	if headerLength < minHeaderLength || (headerLength-minHeaderLength)%fieldSize != 0 ||
+		uint64(r.lengthFieldOffset()+fieldSize)+uint64(headerLength) > uint64(length) {
+		return &CorruptRecordError{
+			Offset: r.lengthFieldOffset() + fieldSize,
+			Reason: fmt.Sprintf("invalid header length %d", headerLength),
+		}
+	}
+
+	nullBitmap := (*r)[r.nullBitmapOffset():][:bitmapSize]
+	valueBitmap := (*r)[r.valueBitmapOffset():][:bitmapSize]
+	var numValues uint32
+	for i := range valueBitmap {
+		if nullBitmap[i]&valueBitmap[i] != 0 {
+			return &CorruptRecordError{
+				Offset: r.nullBitmapOffset() + uint32(i),
+				Reason: "element both null and set",
+			}
+		}
+		numValues += uint32(bits.OnesCount8(valueBitmap[i]))
+	}
+	if numElements%8 != 0 {
+		unused := byte(0xff) << (numElements % 8)
+		if (nullBitmap[bitmapSize-1]|valueBitmap[bitmapSize-1])&unused != 0 {
+			return &CorruptRecordError{
+				Offset: r.nullBitmapOffset(), Reason: "bit set for missing element position",
+			}
+		}
+	}
+	if numValues != (headerLength-minHeaderLength)/fieldSize {
+		return &CorruptRecordError{
+			Offset: r.lengthFieldOffset() + fieldSize,
+			Reason: fmt.Sprintf(
+				"header length %d does not match %d element offsets", headerLength, numValues,
+			),
+		}
+	}
+	return nil
+}
+
+// elementOffset works like offsetForPosition, but returns an error instead of panicking or reading
+// past the header if the header of the record is corrupt, if the record does not have the given
+// element position or if the offset stored for it points outside the record.
+func (r *Record) elementOffset(position ElementPosition) (uint32, error) {
+	if err := r.checkHeader(); err != nil {
+		return 0, err
+	}
+	if position >= r.numElements() {
+		return 0, &PositionOutOfRangeError{position, r.numElements()}
+	}
+	offset := r.offsetForPosition(position)
+	hasValue := offset != 0 || (r.hasNullBitmap() && r.isBitSet(r.valueBitmapOffset(), position))
+	if hasValue && (offset < r.headerEnd() || offset >= r.Length()) {
+		return 0, &CorruptRecordError{
+			Offset: r.offsetFieldOffset(position),
+			Reason: fmt.Sprintf("element offset %d outside record data", offset),
+		}
+	}
+	return offset, nil
 }
 
-func (r *Record) setHeaderLength(headerLength uint16) {
-	binary.LittleEndian.PutUint16((*r)[2:4], headerLength)
+// Validate returns a CorruptRecordError if the header of the record is truncated or malformed, or
+// if any element offset points outside the record. Records built with NewRecord and the Set methods
+// are always valid; Validate is meant for records read from pages, whose bytes may be damaged.
+// The element values themselves are checked as they are decoded by the Get methods.
+func (r *Record) Validate() error {
+	if err := r.checkHeader(); err != nil {
+		return err
+	}
+	for position := uint16(0); position < r.numElements(); position++ {
+		if _, err := r.elementOffset(position); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (r *Record) offsetForPosition(position ElementPosition) uint16 {
-	return binary.LittleEndian.Uint16((*r)[4+2*position : 6+2*position])
+// releaseBytes removes numBytes bytes starting at the given offset from the record. Elements stored
+// after the removed bytes are moved towards the header and their offsets are updated, so that the
+// record stays densely packed.
+func (r *Record) releaseBytes(offset uint32, numBytes uint32) {
+	if numBytes == 0 {
+		return
+	}
+	length := r.Length()
+	copy((*r)[offset:], (*r)[offset+numBytes:length])
+	*r = (*r)[:length-numBytes]
+	r.setLength(length - numBytes)
+	for position := uint16(0); position < r.numElements(); position++ {
+		elementOffset := r.offsetForPosition(position)
+		if elementOffset > offset {
+			r.setOffset(position, elementOffset-numBytes)
+		}
+	}
 }
 
-func (r *Record) setOffset(position ElementPosition, offset uint16) {
-	binary.LittleEndian.PutUint16((*r)[4+2*position:6+2*position], offset)
+// reserveBytes inserts numBytes zero bytes at the given offset into the record. Elements stored at
+// or after the offset are moved away from the header and their offsets are updated.
+func (r *Record) reserveBytes(offset uint32, numBytes uint32) {
+	length := r.Length()
+	*r = append(*r, make([]byte, numBytes)...)
+	copy((*r)[offset+numBytes:], (*r)[offset:length])
+	for i := offset; i < offset+numBytes; i++ {
+		(*r)[i] = 0
+	}
+	r.setLength(length + numBytes)
+	for position := uint16(0); position < r.numElements(); position++ {
+		elementOffset := r.offsetForPosition(position)
+		if elementOffset >= offset {
+			r.setOffset(position, elementOffset+numBytes)
+		}
+	}
+}
+
+// elementEnd returns the offset right after the element stored at the given offset. Elements are
+// stored back to back, so this is the offset of the element stored after it, or the end of the
+// record for the last element.
+func (r *Record) elementEnd(offset uint32) uint32 {
+	end := r.Length()
+	for position := uint16(0); position < r.numElements(); position++ {
+		elementOffset := r.offsetForPosition(position)
+		if elementOffset > offset && elementOffset < end {
+			end = elementOffset
+		}
+	}
+	return end
+}
+
+// appendElement appends numBytes zero bytes for the value at the given element position to the
+// record and returns their offset. The position must not hold a value yet. In a record with a null
+// bitmap, an offset field is added to the header for the position first.
+func (r *Record) appendElement(position ElementPosition, numBytes uint32) uint32 {
+	if r.hasNullBitmap() {
+		r.setBit(r.nullBitmapOffset(), position, false)
+		r.setBit(r.valueBitmapOffset(), position, true)
+		fieldSize := r.fieldSize()
+		r.reserveBytes(r.offsetFieldOffset(position), fieldSize)
+		r.setHeaderLength(r.headerLength() + fieldSize)
+	}
+	offset := r.Length()
+	*r = append(*r, make([]byte, numBytes)...)
+	r.setLength(offset + numBytes)
+	r.setOffset(position, offset)
+	return offset
+}
+
+// removeElement releases the bytes of the value stored at the given element position, leaving the
+// position unset. In a record with a null bitmap, the offset field of the position is removed from
+// the header as well.
+func (r *Record) removeElement(position ElementPosition) {
+	offset := r.offsetForPosition(position)
+	if offset == 0 {
+		return
+	}
+	end := r.elementEnd(offset)
+	r.setOffset(position, 0)
+	r.releaseBytes(offset, end-offset)
+	if r.hasNullBitmap() {
+		fieldSize := r.fieldSize()
+		offsetFieldOffset := r.offsetFieldOffset(position)
+		r.setBit(r.valueBitmapOffset(), position, false)
+		r.releaseBytes(offsetFieldOffset, fieldSize)
+		r.setHeaderLength(r.headerLength() - fieldSize)
+	}
 }
 
 // NewRecord takes in the number of elements that will be stored in a record and returns a record
@@ -93,115 +463,346 @@ func NewRecord(numElements uint16) *Record {
 	return &r
 }
 
+// NewRecordWithEncoding works like NewRecord but returns a tagged record whose elements are stored
+// in the given encoding. The length, header length and offsets of a tagged record use as many bytes
+// as the lengths of its elements, so a record using element.WideEncoding can grow beyond 64 KiB.
+//
+// For element.LegacyEncoding an untagged record, as returned by NewRecord, is returned.
+func NewRecordWithEncoding(numElements uint16, encoding element.Encoding) *Record {
+	if encoding == element.LegacyEncoding {
+		return NewRecord(numElements)
+	}
+	fieldSize := element.LengthFieldSize(encoding)
+	headerLength := fieldSize + fieldSize*uint32(numElements)
+	length := taggedPrefixLength + fieldSize + headerLength
+	r := Record(make([]byte, length))
+	r[2] = encoding
+	r.setLength(length)
+	r.setHeaderLength(headerLength)
+	return &r
+}
+
+// NewRecordWithNullBitmap works like NewRecordWithEncoding but returns a tagged record that uses
+// the null bitmap layout, for any encoding. The header of such a record stores 2 bits per element
+// position and offsets only for the positions that hold values, so null positions take no offset
+// field, and positions explicitly set to null with SetNull can be told apart from positions that
+// were never set.
+func NewRecordWithNullBitmap(numElements uint16, encoding element.Encoding) *Record {
+	fieldSize := element.LengthFieldSize(encoding)
+	bitmapSize := (uint32(numElements) + 7) / 8
+	headerLength := 2*fieldSize + 2*bitmapSize
+	length := taggedPrefixLength + fieldSize + headerLength
+	r := Record(make([]byte, length))
+	r[2] = encoding | nullBitmapLayout
+	r.setLength(length)
+	r.setHeaderLength(headerLength)
+	r.writeField(r.countFieldOffset(), uint32(numElements))
+	return &r
+}
+
+// Encoding returns the element encoding used by the record.
+func (r *Record) Encoding() element.Encoding {
+	if r.isTagged() {
+		return (*r)[2] &^ nullBitmapLayout
+	}
+	return element.LegacyEncoding
+}
+
 // Length returns the length of the record in bytes.
-func (r *Record) Length() uint16 {
-	return binary.LittleEndian.Uint16((*r)[0:2])
+func (r *Record) Length() uint32 {
+	return r.readField(r.lengthFieldOffset())
 }
 
-// SetUint32 saves the given uint32 value at the given element position in the record.
-func (r *Record) SetUint32(position ElementPosition, value uint32) {
-	offset := r.offsetForPosition(position)
-	if offset == 0 {
-		offset = r.Length()
-		r.setLength(offset + 4)
-		r.setOffset(position, offset)
-		*r = append(*r, make([]byte, 4)...)
+// Compact re-lays out the elements of the record densely, in the order of their element positions,
+// and rewrites the offset table accordingly. The record is copied into a buffer of exactly
+// Length() bytes, dropping the bytes before the first element and after the end of the record.
+//
+// The record does not store the types of its elements, so the size of an element is taken as the
+// distance between its offset and the offset of the element stored after it, or the end of the
+// record for the last element. Bytes left after a value by a shrinking update inside that distance
+// are kept; CompactTypes drops them as well.
+func (r *Record) Compact() {
+	r.relayout(r.elementEnds())
+}
+
+// CompactTypes re-lays out the elements of the record densely like Compact, but sizes the element
+// at each position by skipping its value as a value of the type at that position in types, so
+// that bytes left after any value are dropped too. Elements at positions past the end of types are
+// sized like Compact does. An error is returned, and the record is not changed, if a value cannot
+// be skipped.
+func (r *Record) CompactTypes(types []element.Type) error {
+	ends := r.elementEnds()
+	for position, elemType := range types {
+		if position >= int(r.numElements()) {
+			break
+		}
+		offset := r.offsetForPosition(ElementPosition(position))
+		if offset == 0 {
+			continue
+		}
+		end, err := element.SkipValue((*element.Bytes)(r), offset, elemType, r.Encoding())
+		if err != nil {
+			return err
+		}
+		if end > ends[position] {
+			return &CorruptRecordError{
+				Offset: offset,
+				Reason: fmt.Sprintf("element %d overlaps the next element", position),
+			}
+		}
+		ends[position] = end
 	}
-	element.WriteUint32((*element.Bytes)(r), offset, value)
+	r.relayout(ends)
+	return nil
 }
 
-// SetUint64 saves the given uint64 value at the given element position in the record.
-func (r *Record) SetUint64(position ElementPosition, value uint64) {
+// elementEnds returns the end of the element at each position of the record, taken as the offset
+// of the element stored after it or the end of the record, or 0 for positions without a value.
+//
+// Elements are stored back to back, so the size of an element is at most the distance between its
+// offset and the offset of the element stored after it.
+func (r *Record) elementEnds() []uint32 {
+	numElements := r.numElements()
+	offsets := make([]uint32, 0, numElements)
+	for position := uint16(0); position < numElements; position++ {
+		if offset := r.offsetForPosition(position); offset != 0 {
+			offsets = append(offsets, offset)
+		}
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	length := r.Length()
+	ends := make([]uint32, numElements)
+	for position := uint16(0); position < numElements; position++ {
+		offset := r.offsetForPosition(position)
+		if offset == 0 {
+			continue
+		}
+		i := sort.Search(len(offsets), func(i int) bool { return offsets[i] > offset })
+		if i == len(offsets) {
+			ends[position] = length
+		} else {
+			ends[position] = offsets[i]
+		}
+	}
+	return ends
+}
+
+// relayout copies the elements of the record, each ending at the given end of its position, back
+// to back after the header into a new buffer, in the order of their element positions.
+func (r *Record) relayout(ends []uint32) {
+	headerEnd := r.headerEnd()
+	compacted := Record(make([]byte, headerEnd, r.Length()))
+	copy(compacted, (*r)[:headerEnd])
+	for position := uint16(0); position < r.numElements(); position++ {
+		offset := r.offsetForPosition(position)
+		if offset == 0 {
+			continue
+		}
+		compacted.setOffset(position, uint32(len(compacted)))
+		compacted = append(compacted, (*r)[offset:ends[position]]...)
+	}
+	compacted.setLength(uint32(len(compacted)))
+	*r = compacted
+}
+
+// setPrimitive saves the given fixed-width or varint value of the given element type at the given
+// element position in the record. If the existing value at the position takes a different number
+// of bytes than the new value, the elements stored after it are moved accordingly. An error is
+// returned, and the record is not changed, if the value cannot be encoded as the element type or
+// the existing value cannot be read.
+func (r *Record) setPrimitive(position ElementPosition, value any, elemType element.Type) error {
+	numBytes, err := element.BytesNeededForPrimitive(value, r.Encoding())
+	if err != nil {
+		return err
+	}
+	encoded := make(element.Bytes, numBytes)
+	if _, err = element.WritePrimitive(&encoded, 0, value, elemType, r.Encoding()); err != nil {
+		return err
+	}
 	offset := r.offsetForPosition(position)
 	if offset == 0 {
-		offset = r.Length()
-		r.setLength(offset + 8)
-		r.setOffset(position, offset)
-		*r = append(*r, make([]byte, 8)...)
+		offset = r.appendElement(position, numBytes)
+		copy((*r)[offset:], encoded)
+		return nil
+	}
+	_, currentEnd, err := element.ReadPrimitive(
+		(*element.Bytes)(r), offset, elemType, r.Encoding(),
+	)
+	if err != nil {
+		return err
+	}
+	if currentEnd < offset {
+		return &CorruptRecordError{Offset: offset, Reason: "value ends before its offset"}
+	}
+	currentBytes := currentEnd - offset
+	if currentBytes < numBytes {
+		r.reserveBytes(currentEnd, numBytes-currentBytes)
 	}
-	element.WriteUint64((*element.Bytes)(r), offset, value)
+	copy((*r)[offset:], encoded)
+	if currentBytes > numBytes {
+		r.releaseBytes(offset+numBytes, currentBytes-numBytes)
+	}
+	return nil
+}
+
+// SetUint8 saves the given uint8 value at the given element position in the record.
+func (r *Record) SetUint8(position ElementPosition, value uint8) error {
+	return r.setPrimitive(position, value, element.Uint8Type)
+}
+
+// SetUint16 saves the given uint16 value at the given element position in the record.
+func (r *Record) SetUint16(position ElementPosition, value uint16) error {
+	return r.setPrimitive(position, value, element.Uint16Type)
+}
+
+// SetUint32 saves the given uint32 value at the given element position in the record.
+func (r *Record) SetUint32(position ElementPosition, value uint32) error {
+	return r.setPrimitive(position, value, element.Uint32Type)
+}
+
+// SetUint64 saves the given uint64 value at the given element position in the record.
+func (r *Record) SetUint64(position ElementPosition, value uint64) error {
+	return r.setPrimitive(position, value, element.Uint64Type)
+}
+
+// SetInt8 saves the given int8 value at the given element position in the record.
+func (r *Record) SetInt8(position ElementPosition, value int8) error {
+	return r.setPrimitive(position, value, element.Int8Type)
+}
+
+// SetInt16 saves the given int16 value at the given element position in the record.
+func (r *Record) SetInt16(position ElementPosition, value int16) error {
+	return r.setPrimitive(position, value, element.Int16Type)
 }
 
 // SetInt32 saves the given int32 value at the given element position in the record.
-func (r *Record) SetInt32(position ElementPosition, value int32) {
-	r.SetUint32(position, uint32(value))
+func (r *Record) SetInt32(position ElementPosition, value int32) error {
+	return r.setPrimitive(position, value, element.Int32Type)
 }
 
 // SetInt64 saves the given int64 value at the given element position in the record.
-func (r *Record) SetInt64(position ElementPosition, value int64) {
-	r.SetUint64(position, uint64(value))
+func (r *Record) SetInt64(position ElementPosition, value int64) error {
+	return r.setPrimitive(position, value, element.Int64Type)
 }
 
 // SetFloat32 saves the given float32 value at the given element position in the record.
-func (r *Record) SetFloat32(position ElementPosition, value float32) {
-	r.SetUint32(position, math.Float32bits(value))
+func (r *Record) SetFloat32(position ElementPosition, value float32) error {
+	return r.setPrimitive(position, value, element.Float32Type)
 }
 
 // SetFloat64 saves the given float64 value at the given element position in the record.
-func (r *Record) SetFloat64(position ElementPosition, value float64) {
-	r.SetUint64(position, math.Float64bits(value))
+func (r *Record) SetFloat64(position ElementPosition, value float64) error {
+	return r.setPrimitive(position, value, element.Float64Type)
+}
+
+// SetUUID saves the given UUID value at the given element position in the record.
+func (r *Record) SetUUID(position ElementPosition, value element.UUID) error {
+	return r.setPrimitive(position, value, element.UUIDType)
+}
+
+// SetDecimal saves the given Decimal value at the given element position in the record.
+func (r *Record) SetDecimal(position ElementPosition, value element.Decimal) error {
+	return r.setPrimitive(position, value, element.DecimalType)
 }
 
 // SetBool saves the given bool value at the given element position in the record.
-func (r *Record) SetBool(position ElementPosition, value bool) {
-	offset := r.offsetForPosition(position)
-	if offset == 0 {
-		offset = r.Length()
-		r.setLength(offset + 1)
-		r.setOffset(position, offset)
-		*r = append(*r, byte(0))
+func (r *Record) SetBool(position ElementPosition, value bool) error {
+	return r.setPrimitive(position, value, element.BoolType)
+}
+
+// SetTime saves the given time value at the given element position in the record. Times are stored
+// with nanosecond precision, so a TimeOutOfRangeError is returned for times before the year 1678 or
+// after the year 2262. Use SetZonedTime to store such times.
+func (r *Record) SetTime(position ElementPosition, value time.Time) error {
+	if err := element.CheckTimeRange(value); err != nil {
+		return err
 	}
-	element.WriteBool((*element.Bytes)(r), offset, value)
+	return r.setPrimitive(position, value, element.TimeType)
 }
 
-// SetTime saves the given time value at the given element position in the record.
-func (r *Record) SetTime(position ElementPosition, value time.Time) {
-	r.SetUint64(position, uint64(value.UnixNano()))
+// SetDate saves the given date value at the given element position in the record.
+func (r *Record) SetDate(position ElementPosition, value element.Date) error {
+	return r.setPrimitive(position, value, element.DateType)
 }
 
-// SetString saves the given string value at the given element position in the record.
-//
-// If a string value is already stored at the given element position and the incoming value is
-// smaller or equal to the length of the existing string, the existing string is overwritten with
-// the new value. If the incoming value is larger than the length of the existing string, a
-// WriteOverflowError is returned.
-func (r *Record) SetString(position ElementPosition, value string) error {
+// SetDuration saves the given duration value at the given element position in the record.
+func (r *Record) SetDuration(position ElementPosition, value time.Duration) error {
+	return r.setPrimitive(position, value, element.DurationType)
+}
+
+// SetZonedTime saves the given time value at the given element position in the record, along with
+// the offset of its time zone from UTC.
+func (r *Record) SetZonedTime(position ElementPosition, value element.ZonedTime) error {
+	return r.setPrimitive(position, value, element.ZonedTimeType)
+}
+
+// setLengthPrefixed saves the given string or bytes value at the given element position in the
+// record, as described for SetString.
+func (r *Record) setLengthPrefixed(
+	position ElementPosition, value any, elemType element.Type,
+) error {
+	numBytes, err := element.BytesNeededForPrimitive(value, r.Encoding())
+	if err != nil {
+		return err
+	}
 	offset := r.offsetForPosition(position)
 	if offset == 0 {
-		offset = r.Length()
-		numBytes := element.BytesNeededForString(value)
-		*r = append(*r, make([]byte, numBytes)...)
-		element.WriteString((*element.Bytes)(r), offset, value)
-		r.setOffset(position, offset)
-		r.setLength(offset + numBytes)
+		offset = r.appendElement(position, numBytes)
+		_, err = element.WritePrimitive((*element.Bytes)(r), offset, value, elemType, r.Encoding())
+		if err != nil {
+			r.removeElement(position)
+			return err
+		}
 	} else {
-		currentLength := binary.LittleEndian.Uint16((*r)[offset : offset+2])
-		requiredLength := uint16(len(value))
+		currentLength, _, err := element.ReadLength((*element.Bytes)(r), offset, r.Encoding())
+		if err != nil {
+			return err
+		}
+		requiredLength := numBytes - element.LengthFieldSize(r.Encoding())
 		if currentLength < requiredLength {
 			return &WriteOverflowError{
 				currentLength, requiredLength, value,
 			}
 		}
-		element.WriteString((*element.Bytes)(r), offset, value)
+		_, err = element.WritePrimitive((*element.Bytes)(r), offset, value, elemType, r.Encoding())
+		if err != nil {
+			return err
+		}
+		r.releaseBytes(offset+numBytes, currentLength-requiredLength)
 	}
 	return nil
 }
 
-// SetArray saves the given Array value at the given element position in the record. Arrays cannot
-// have other arrays and maps as elements.
+// SetString saves the given string value at the given element position in the record.
 //
-// If an Array value is already stored at the given element position and the incoming value is
-// smaller or equal to the length of the existing Array, the existing Array is overwritten with the
-// new value. If the incoming value is larger than the length of the existing array, a
-// WriteOverflowError is returned.
+// If a string value is already stored at the given element position and the incoming value is
+// smaller or equal to the length of the existing string, the existing string is overwritten with
+// the new value and any bytes no longer needed are released from the record. If the incoming value
+// is larger than the length of the existing string, a WriteOverflowError is returned.
+func (r *Record) SetString(position ElementPosition, value string) error {
+	return r.setLengthPrefixed(position, value, element.StringType)
+}
+
+// SetBytes saves the given binary value at the given element position in the record. Existing
+// values are overwritten in the same way as by SetString.
+func (r *Record) SetBytes(position ElementPosition, value []byte) error {
+	return r.setLengthPrefixed(position, value, element.BytesType)
+}
+
+// SetArray saves the given Array value at the given element position in the record. Arrays can
+// have other arrays and maps as elements, nested up to element.MaxNestingDepth levels deep. Arrays
+// of AnyType elements store the type of each value alongside it and so can hold values of
+// different types, including nil.
+//
+// If an Array value is already stored at the given element position and the incoming value needs
+// fewer or the same number of bytes as the existing Array, the existing Array is overwritten with
+// the new value and any bytes no longer needed are released from the record. If the incoming value
+// needs more bytes than the existing Array, a WriteOverflowError is returned.
 //
 // If the type of incoming Array element type does not match the existing Array element type,
 // a TypeMismatchError is returned.
 func (r *Record) SetArray(position ElementPosition, a element.Array) error {
-	if a.ElementType == element.ArrayType {
-		return &InvalidElementTypeError{a.ElementType}
-	}
-	if a.ElementType == element.MapType {
+	if a.ElementType == element.NullType {
 		return &InvalidElementTypeError{a.ElementType}
 	}
 	if a.Values == nil {
@@ -210,46 +811,52 @@ func (r *Record) SetArray(position ElementPosition, a element.Array) error {
 
 	offset := r.offsetForPosition(position)
 	if offset == 0 {
-		offset = r.Length()
-		numBytes, err := element.BytesNeededForArray(a)
+		numBytes, err := element.BytesNeededForArray(a, r.Encoding())
 		if err != nil {
 			return err
 		}
-		*r = append(*r, make([]byte, numBytes)...)
-		_, err = element.WriteArray((*element.Bytes)(r), offset, a)
+		offset = r.appendElement(position, numBytes)
+		_, err = element.WriteArray((*element.Bytes)(r), offset, a, r.Encoding())
 		if err != nil {
+			r.removeElement(position)
 			return err
 		}
-		r.setOffset(position, offset)
-		r.setLength(offset + numBytes)
 	} else {
-		currentElementType := (*r)[offset+2]
+		currentElementType := (*r)[offset+r.fieldSize()]
 		if currentElementType != a.ElementType {
 			return &element.TypeMismatchError{Expected: currentElementType, Actual: a.ElementType}
 		}
-		currentLength := binary.LittleEndian.Uint16((*r)[offset : offset+2])
-		requiredLength := uint16(len(a.Values))
+		_, currentEnd, err := element.ReadArray((*element.Bytes)(r), offset, r.Encoding())
+		if err != nil {
+			return err
+		}
+		currentLength := currentEnd - offset
+		requiredLength, err := element.BytesNeededForArray(a, r.Encoding())
+		if err != nil {
+			return err
+		}
 		if currentLength < requiredLength {
 			return &WriteOverflowError{
 				currentLength, requiredLength, a,
 			}
 		}
-		_, err := element.WriteArray((*element.Bytes)(r), offset, a)
+		_, err = element.WriteArray((*element.Bytes)(r), offset, a, r.Encoding())
 		if err != nil {
 			return err
 		}
+		r.releaseBytes(offset+requiredLength, currentLength-requiredLength)
 	}
 	return nil
 }
 
 // SetMap saves the given Map value at the given element position in the record. Maps cannot have
-// arrays and other maps as keys. Maps cannot have other maps as values. Maps can have arrays as
-// values.
+// arrays, other maps or bytes as keys. Maps can have arrays and other maps as values, nested up to
+// element.MaxNestingDepth levels deep.
 //
-// If a Map value is already stored at the given element position and the incoming value is smaller
-// or equal to the length of the existing Map, the existing Map is overwritten with the new value.
-// If the incoming value is larger than the length of the existing Map, a WriteOverflowError is
-// returned.
+// If a Map value is already stored at the given element position and the incoming value needs fewer
+// or the same number of bytes as the existing Map, the existing Map is overwritten with the new
+// value and any bytes no longer needed are released from the record. If the incoming value needs
+// more bytes than the existing Map, a WriteOverflowError is returned.
 //
 // If the type of incoming Map key and value types do not match the existing Map key and value
 // types, a TypeMismatchError is returned.
@@ -260,7 +867,10 @@ func (r *Record) SetMap(position ElementPosition, m element.Map) error {
 	if m.KeyType == element.MapType {
 		return &InvalidKeyTypeError{m.KeyType}
 	}
-	if m.ValueType == element.MapType {
+	if m.KeyType == element.BytesType {
+		return &InvalidKeyTypeError{m.KeyType}
+	}
+	if m.ValueType == element.NullType {
 		return &InvalidValueTypeError{m.ValueType}
 	}
 	if m.Data == nil {
@@ -269,150 +879,324 @@ func (r *Record) SetMap(position ElementPosition, m element.Map) error {
 
 	offset := r.offsetForPosition(position)
 	if offset == 0 {
-		offset = r.Length()
-		numBytes, err := element.BytesNeededForMap(m)
+		numBytes, err := element.BytesNeededForMap(m, r.Encoding())
 		if err != nil {
 			return err
 		}
-		*r = append(*r, make([]byte, numBytes)...)
-		_, err = element.WriteMap((*element.Bytes)(r), offset, m)
+		offset = r.appendElement(position, numBytes)
+		_, err = element.WriteMap((*element.Bytes)(r), offset, m, r.Encoding())
 		if err != nil {
+			r.removeElement(position)
 			return err
 		}
-		r.setOffset(position, offset)
-		r.setLength(offset + numBytes)
 	} else {
-		currentKeyType := (*r)[offset+2]
-		if currentKeyType != m.KeyType {
-			err := &element.TypeMismatchError{Expected: currentKeyType, Actual: m.KeyType}
+		current, err := element.NewMapView((*element.Bytes)(r), offset, r.Encoding())
+		if err != nil {
+			return err
+		}
+		if current.KeyType() != m.KeyType {
+			err := &element.TypeMismatchError{Expected: current.KeyType(), Actual: m.KeyType}
 			return fmt.Errorf("key type mismatch: %w", err)
 		}
-		currentValueType := (*r)[offset+3]
-		if currentValueType != m.ValueType {
-			err := &element.TypeMismatchError{Expected: currentValueType, Actual: m.ValueType}
+		if current.ValueType() != m.ValueType {
+			err := &element.TypeMismatchError{Expected: current.ValueType(), Actual: m.ValueType}
 			return fmt.Errorf("value type mismatch: %w", err)
 		}
-		currentLength := binary.LittleEndian.Uint16((*r)[offset : offset+2])
-		requiredLength := uint16(len(m.Data))
+		_, currentEnd, err := element.ReadMap((*element.Bytes)(r), offset, r.Encoding())
+		if err != nil {
+			return err
+		}
+		currentLength := currentEnd - offset
+		requiredLength, err := element.BytesNeededForMap(m, r.Encoding())
+		if err != nil {
+			return err
+		}
 		if currentLength < requiredLength {
 			return &WriteOverflowError{
 				currentLength, requiredLength, m,
 			}
 		}
-		_, err := element.WriteMap((*element.Bytes)(r), offset, m)
+		_, err = element.WriteMap((*element.Bytes)(r), offset, m, r.Encoding())
 		if err != nil {
 			return err
 		}
+		r.releaseBytes(offset+requiredLength, currentLength-requiredLength)
 	}
 	return nil
 }
 
+// SetNull sets the value at the given element position in the record to null, releasing the bytes
+// of any value stored there. A record with a null bitmap marks the position as explicitly null, as
+// reported by IsSet; in other records it cannot be told apart from a position that was never set.
+func (r *Record) SetNull(position ElementPosition) error {
+	if position >= r.numElements() {
+		return &PositionOutOfRangeError{position, r.numElements()}
+	}
+	r.removeElement(position)
+	if r.hasNullBitmap() {
+		r.setBit(r.nullBitmapOffset(), position, true)
+	}
+	return nil
+}
+
+// IsSet returns true if a value or an explicit null is stored at the given element position in
+// the record. Only records with a null bitmap store explicit nulls, so for other records IsSet
+// returns false for null positions whether or not SetNull was called for them.
+func (r *Record) IsSet(position ElementPosition) (bool, error) {
+	offset, err := r.elementOffset(position)
+	if offset != 0 || err != nil {
+		return err == nil, err
+	}
+	return r.hasNullBitmap() && r.isBitSet(r.nullBitmapOffset(), position), nil
+}
+
+// getPrimitive returns the fixed-width or varint value of the given element type stored at the
+// given element position in the record.
+func (r *Record) getPrimitive(position ElementPosition, elemType element.Type) (bool, any, error) {
+	offset, err := r.elementOffset(position)
+	if offset == 0 || err != nil {
+		return offset == 0, nil, err
+	}
+	value, _, err := element.ReadPrimitive((*element.Bytes)(r), offset, elemType, r.Encoding())
+	return false, value, err
+}
+
+// GetUint8 returns the uint8 value stored at the given element position in the record.
+func (r *Record) GetUint8(position ElementPosition) (isNull bool, value uint8, err error) {
+	isNull, v, err := r.getPrimitive(position, element.Uint8Type)
+	if !isNull && err == nil {
+		value = v.(uint8)
+	}
+	return isNull, value, err
+}
+
+// GetUint16 returns the uint16 value stored at the given element position in the record.
+func (r *Record) GetUint16(position ElementPosition) (isNull bool, value uint16, err error) {
+	isNull, v, err := r.getPrimitive(position, element.Uint16Type)
+	if !isNull && err == nil {
+		value = v.(uint16)
+	}
+	return isNull, value, err
+}
+
 // GetUint32 returns the uint32 value stored at the given element position in the record.
-func (r *Record) GetUint32(position ElementPosition) (isNull bool, value uint32) {
-	offset := r.offsetForPosition(position)
-	isNull = offset == 0
-	if !isNull {
-		value = binary.LittleEndian.Uint32((*r)[offset : offset+4])
+func (r *Record) GetUint32(position ElementPosition) (isNull bool, value uint32, err error) {
+	isNull, v, err := r.getPrimitive(position, element.Uint32Type)
+	if !isNull && err == nil {
+		value = v.(uint32)
 	}
-	return isNull, value
+	return isNull, value, err
 }
 
 // GetUint64 returns the uint64 value stored at the given element position in the record.
-func (r *Record) GetUint64(position ElementPosition) (isNull bool, value uint64) {
-	offset := r.offsetForPosition(position)
-	isNull = offset == 0
-	if !isNull {
-		value = binary.LittleEndian.Uint64((*r)[offset : offset+8])
+func (r *Record) GetUint64(position ElementPosition) (isNull bool, value uint64, err error) {
+	isNull, v, err := r.getPrimitive(position, element.Uint64Type)
+	if !isNull && err == nil {
+		value = v.(uint64)
+	}
+	return isNull, value, err
+}
+
+// GetInt8 returns the int8 value stored at the given element position in the record.
+func (r *Record) GetInt8(position ElementPosition) (isNull bool, value int8, err error) {
+	isNull, v, err := r.getPrimitive(position, element.Int8Type)
+	if !isNull && err == nil {
+		value = v.(int8)
 	}
-	return isNull, value
+	return isNull, value, err
+}
+
+// GetInt16 returns the int16 value stored at the given element position in the record.
+func (r *Record) GetInt16(position ElementPosition) (isNull bool, value int16, err error) {
+	isNull, v, err := r.getPrimitive(position, element.Int16Type)
+	if !isNull && err == nil {
+		value = v.(int16)
+	}
+	return isNull, value, err
 }
 
 // GetInt32 returns the int32 value stored at the given element position in the record.
-func (r *Record) GetInt32(position ElementPosition) (isNull bool, value int32) {
-	offset := r.offsetForPosition(position)
-	isNull = offset == 0
-	if !isNull {
-		value = int32(binary.LittleEndian.Uint32((*r)[offset : offset+4]))
+func (r *Record) GetInt32(position ElementPosition) (isNull bool, value int32, err error) {
+	isNull, v, err := r.getPrimitive(position, element.Int32Type)
+	if !isNull && err == nil {
+		value = v.(int32)
 	}
-	return isNull, value
+	return isNull, value, err
 }
 
 // GetInt64 returns the int64 value stored at the given element position in the record.
-func (r *Record) GetInt64(position ElementPosition) (isNull bool, value int64) {
-	offset := r.offsetForPosition(position)
-	isNull = offset == 0
-	if !isNull {
-		value = int64(binary.LittleEndian.Uint64((*r)[offset : offset+8]))
+func (r *Record) GetInt64(position ElementPosition) (isNull bool, value int64, err error) {
+	isNull, v, err := r.getPrimitive(position, element.Int64Type)
+	if !isNull && err == nil {
+		value = v.(int64)
 	}
-	return isNull, value
+	return isNull, value, err
 }
 
 // GetFloat32 returns the float32 value stored at the given element position in the record.
-func (r *Record) GetFloat32(position ElementPosition) (isNull bool, value float32) {
-	offset := r.offsetForPosition(position)
-	isNull = offset == 0
-	if !isNull {
-		value = math.Float32frombits(binary.LittleEndian.Uint32((*r)[offset : offset+4]))
+func (r *Record) GetFloat32(position ElementPosition) (isNull bool, value float32, err error) {
+	isNull, v, err := r.getPrimitive(position, element.Float32Type)
+	if !isNull && err == nil {
+		value = v.(float32)
 	}
-	return isNull, value
+	return isNull, value, err
 }
 
 // GetFloat64 returns the float64 value stored at the given element position in the record.
-func (r *Record) GetFloat64(position ElementPosition) (isNull bool, value float64) {
-	offset := r.offsetForPosition(position)
-	isNull = offset == 0
-	if !isNull {
-		value = math.Float64frombits(binary.LittleEndian.Uint64((*r)[offset : offset+8]))
+func (r *Record) GetFloat64(position ElementPosition) (isNull bool, value float64, err error) {
+	isNull, v, err := r.getPrimitive(position, element.Float64Type)
+	if !isNull && err == nil {
+		value = v.(float64)
+	}
+	return isNull, value, err
+}
+
+// GetUUID returns the UUID value stored at the given element position in the record.
+func (r *Record) GetUUID(position ElementPosition) (isNull bool, value element.UUID, err error) {
+	isNull, v, err := r.getPrimitive(position, element.UUIDType)
+	if !isNull && err == nil {
+		value = v.(element.UUID)
 	}
-	return isNull, value
+	return isNull, value, err
+}
+
+// GetDecimal returns the Decimal value stored at the given element position in the record.
+func (r *Record) GetDecimal(
+	position ElementPosition,
+) (isNull bool, value element.Decimal, err error) {
+	isNull, v, err := r.getPrimitive(position, element.DecimalType)
+	if !isNull && err == nil {
+		value = v.(element.Decimal)
+	}
+	return isNull, value, err
 }
 
 // GetBool returns the bool value stored at the given element position in the record.
-func (r *Record) GetBool(position ElementPosition) (isNull bool, value bool) {
-	offset := r.offsetForPosition(position)
+func (r *Record) GetBool(position ElementPosition) (isNull bool, value bool, err error) {
+	offset, err := r.elementOffset(position)
 	isNull = offset == 0
-	if !isNull {
+	if !isNull && err == nil {
 		value = (*r)[offset] != 0
 	}
-	return isNull, value
+	return isNull, value, err
 }
 
 // GetTime returns the Timestamp value stored at the given element position in the record.
-func (r *Record) GetTime(position ElementPosition) (isNull bool, value time.Time) {
-	offset := r.offsetForPosition(position)
-	isNull = offset == 0
-	if !isNull {
-		value = time.Unix(0, int64(binary.LittleEndian.Uint64((*r)[offset:offset+8])))
+func (r *Record) GetTime(position ElementPosition) (isNull bool, value time.Time, err error) {
+	isNull, v, err := r.getPrimitive(position, element.TimeType)
+	if !isNull && err == nil {
+		value = v.(time.Time)
+	}
+	return isNull, value, err
+}
+
+// GetDate returns the date value stored at the given element position in the record.
+func (r *Record) GetDate(position ElementPosition) (isNull bool, value element.Date, err error) {
+	isNull, v, err := r.getPrimitive(position, element.DateType)
+	if !isNull && err == nil {
+		value = v.(element.Date)
 	}
-	return isNull, value
+	return isNull, value, err
+}
+
+// GetDuration returns the duration value stored at the given element position in the record.
+func (r *Record) GetDuration(
+	position ElementPosition,
+) (isNull bool, value time.Duration, err error) {
+	isNull, v, err := r.getPrimitive(position, element.DurationType)
+	if !isNull && err == nil {
+		value = v.(time.Duration)
+	}
+	return isNull, value, err
+}
+
+// GetZonedTime returns the time value stored at the given element position in the record, in a
+// time zone with the offset from UTC the time was stored with.
+func (r *Record) GetZonedTime(
+	position ElementPosition,
+) (isNull bool, value element.ZonedTime, err error) {
+	isNull, v, err := r.getPrimitive(position, element.ZonedTimeType)
+	if !isNull && err == nil {
+		value = v.(element.ZonedTime)
+	}
+	return isNull, value, err
 }
 
 // GetString returns the string value stored at the given element position in the record.
-func (r *Record) GetString(position ElementPosition) (isNull bool, value string) {
-	offset := r.offsetForPosition(position)
+func (r *Record) GetString(position ElementPosition) (isNull bool, value string, err error) {
+	offset, err := r.elementOffset(position)
 	isNull = offset == 0
-	if !isNull {
-		value, _ = element.ReadString((*element.Bytes)(r), offset)
+	if !isNull && err == nil {
+		value, _, err = element.ReadString((*element.Bytes)(r), offset, r.Encoding())
 	}
-	return isNull, value
+	return isNull, value, err
+}
+
+// GetBytes returns a copy of the binary value stored at the given element position in the record.
+func (r *Record) GetBytes(position ElementPosition) (isNull bool, value []byte, err error) {
+	isNull, v, err := r.getPrimitive(position, element.BytesType)
+	if !isNull && err == nil {
+		value = v.([]byte)
+	}
+	return isNull, value, err
 }
 
 // GetArray returns the Array value stored at the given element position in the record.
 func (r *Record) GetArray(position ElementPosition) (isNull bool, value element.Array, err error) {
-	offset := r.offsetForPosition(position)
+	offset, err := r.elementOffset(position)
 	isNull = offset == 0
-	if !isNull {
-		value, _, err = element.ReadArray((*element.Bytes)(r), offset)
+	if !isNull && err == nil {
+		value, _, err = element.ReadArray((*element.Bytes)(r), offset, r.Encoding())
 	}
 	return isNull, value, err
 }
 
 // GetMap returns the Map value stored at the given element position in the record.
 func (r *Record) GetMap(position ElementPosition) (isNull bool, value element.Map, err error) {
-	offset := r.offsetForPosition(position)
+	offset, err := r.elementOffset(position)
+	isNull = offset == 0
+	if !isNull && err == nil {
+		value, _, err = element.ReadMap((*element.Bytes)(r), offset, r.Encoding())
+	}
+	return isNull, value, err
+}
+
+// GetArrayView returns a view of the Array stored at the given element position in the record,
+// which reads the values of the array from the record bytes as they are needed. The view must not
+// be used after the record is modified.
+func (r *Record) GetArrayView(
+	position ElementPosition,
+) (isNull bool, value element.ArrayView, err error) {
+	offset, err := r.elementOffset(position)
+	isNull = offset == 0
+	if !isNull && err == nil {
+		value, err = element.NewArrayView((*element.Bytes)(r), offset, r.Encoding())
+	}
+	return isNull, value, err
+}
+
+// GetMapView returns a view of the Map stored at the given element position in the record, which
+// reads the entries of the map from the record bytes as they are needed. The view must not be
+// used after the record is modified.
+func (r *Record) GetMapView(
+	position ElementPosition,
+) (isNull bool, value element.MapView, err error) {
+	offset, err := r.elementOffset(position)
 	isNull = offset == 0
-	if !isNull {
-		value, _, err = element.ReadMap((*element.Bytes)(r), offset)
+	if !isNull && err == nil {
+		value, err = element.NewMapView((*element.Bytes)(r), offset, r.Encoding())
 	}
 	return isNull, value, err
 }
+
+// GetMapValue returns the value stored for the given key in the Map stored at the given element
+// position in the record, without decoding the whole map. found is false if no Map is stored at
+// the position or if the Map does not have the key.
+func (r *Record) GetMapValue(position ElementPosition, key any) (found bool, value any, err error) {
+	offset, err := r.elementOffset(position)
+	if offset == 0 || err != nil {
+		return false, nil, err
+	}
+	value, found, err = element.LookupMapKey((*element.Bytes)(r), offset, key, r.Encoding())
+	return found, value, err
+}
@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"io"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -42,11 +44,203 @@ func TestNewRecord(t *testing.T) {
 	checkRecordBytes(t, r, 4, []byte{0, 0, 0, 0, 0, 0})
 }
 
+func TestNewRecordWithEncoding(t *testing.T) {
+	t.Run(
+		"check legacy encoding", func(t *testing.T) {
+			r := NewRecordWithEncoding(5, element.LegacyEncoding)
+
+			checkRecordLength(t, r, 14)
+			checkRecordBytes(t, r, 0, []byte{14, 0})
+			checkRecordBytes(t, r, 2, []byte{12, 0})
+		},
+	)
+
+	t.Run(
+		"check wide encoding", func(t *testing.T) {
+			r := NewRecordWithEncoding(2, element.WideEncoding)
+
+			checkRecordLength(t, r, 19)
+			checkRecordBytes(t, r, 0, []byte{0, 0, element.WideEncoding})
+			checkRecordBytes(t, r, 3, []byte{19, 0, 0, 0})
+			checkRecordBytes(t, r, 7, []byte{12, 0, 0, 0})
+			checkRecordBytes(t, r, 11, []byte{0, 0, 0, 0, 0, 0, 0, 0})
+			if r.Encoding() != element.WideEncoding {
+				t.Errorf("expected encoding %d, got %d", element.WideEncoding, r.Encoding())
+			}
+		},
+	)
+
+	t.Run(
+		"check wide string", func(t *testing.T) {
+			r := NewRecordWithEncoding(2, element.WideEncoding)
+			err := r.SetString(0, "hello")
+			if err != nil {
+				t.Error(err)
+			}
+			r.SetUint32(1, 10)
+
+			checkRecordLength(t, r, 32)
+			checkRecordBytes(t, r, 11, []byte{19, 0, 0, 0, 28, 0, 0, 0})
+			checkRecordBytes(t, r, 19, []byte{5, 0, 0, 0, 104, 101, 108, 108, 111})
+			checkRecordBytes(t, r, 28, []byte{10, 0, 0, 0})
+		},
+	)
+
+	t.Run(
+		"check record larger than 64 KiB", func(t *testing.T) {
+			r := NewRecordWithEncoding(3, element.WideEncoding)
+			want := string(make([]byte, 100000))
+			err := r.SetString(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+			wantArray := element.Array{ElementType: element.Int64Type, Values: make([]any, 10000)}
+			for i := range wantArray.Values {
+				wantArray.Values[i] = int64(i)
+			}
+			err = r.SetArray(1, wantArray)
+			if err != nil {
+				t.Error(err)
+			}
+			wantMap := element.Map{
+				KeyType:   element.StringType,
+				ValueType: element.ArrayType,
+				Data:      map[any]any{"a": wantArray},
+			}
+			err = r.SetMap(2, wantMap)
+			if err != nil {
+				t.Error(err)
+			}
+
+			if r.Length() != uint32(len(*r)) || r.Length() < 200000 {
+				t.Errorf("unexpected record length %d", r.Length())
+			}
+			isNull, got, err := r.GetString(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull || got != want {
+				t.Error("expected long string to round trip")
+			}
+			isNull, gotArray, err := r.GetArray(1)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull || !reflect.DeepEqual(gotArray, wantArray) {
+				t.Error("expected long array to round trip")
+			}
+			isNull, gotMap, err := r.GetMap(2)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull || !reflect.DeepEqual(gotMap, wantMap) {
+				t.Error("expected map of long arrays to round trip")
+			}
+		},
+	)
+
+	t.Run(
+		"check compact integers", func(t *testing.T) {
+			r := NewRecordWithEncoding(4, element.CompactEncoding)
+			r.SetUint64(0, 1)
+			r.SetInt64(1, -1)
+			r.SetInt32(2, 300)
+			r.SetTime(3, time.Unix(0, 64))
+
+			checkRecordLength(t, r, 21)
+			checkRecordBytes(t, r, 0, []byte{0, 0, element.CompactEncoding, 21, 0, 10, 0})
+			checkRecordBytes(t, r, 7, []byte{15, 0, 16, 0, 17, 0, 19, 0})
+			checkRecordBytes(t, r, 15, []byte{1, 1, 216, 4, 128, 1})
+
+			_, gotUint64, err := r.GetUint64(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if gotUint64 != 1 {
+				t.Errorf("got %v, want %v", gotUint64, 1)
+			}
+			_, gotInt64, err := r.GetInt64(1)
+			if err != nil {
+				t.Error(err)
+			}
+			if gotInt64 != -1 {
+				t.Errorf("got %v, want %v", gotInt64, -1)
+			}
+			_, gotInt32, err := r.GetInt32(2)
+			if err != nil {
+				t.Error(err)
+			}
+			if gotInt32 != 300 {
+				t.Errorf("got %v, want %v", gotInt32, 300)
+			}
+			_, gotTime, err := r.GetTime(3)
+			if err != nil {
+				t.Error(err)
+			}
+			if !gotTime.Equal(time.Unix(0, 64)) {
+				t.Errorf("got %v, want %v", gotTime, time.Unix(0, 64))
+			}
+		},
+	)
+
+	t.Run(
+		"check compact integer update", func(t *testing.T) {
+			r := NewRecordWithEncoding(2, element.CompactEncoding)
+			r.SetUint32(0, 1)
+			err := r.SetString(1, "hi")
+			if err != nil {
+				t.Error(err)
+			}
+
+			r.SetUint32(0, 4294967295)
+			checkRecordLength(t, r, 20)
+			checkRecordBytes(t, r, 7, []byte{11, 0, 16, 0})
+			checkRecordBytes(t, r, 11, []byte{255, 255, 255, 255, 15, 2, 0, 104, 105})
+
+			r.SetUint32(0, 2)
+			checkRecordLength(t, r, 16)
+			checkRecordBytes(t, r, 7, []byte{11, 0, 12, 0})
+			checkRecordBytes(t, r, 11, []byte{2, 2, 0, 104, 105})
+		},
+	)
+
+	t.Run(
+		"check compact time array", func(t *testing.T) {
+			r := NewRecordWithEncoding(1, element.CompactEncoding)
+			start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+			want := element.Array{
+				ElementType: element.TimeType,
+				Values: []any{
+					start, start.Add(time.Microsecond), start.Add(-time.Microsecond),
+				},
+			}
+			err := r.SetArray(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+
+			// The first time takes 9 bytes, the deltas of +1000 and -2000 take 2 bytes each.
+			checkRecordLength(t, r, 9+3+9+2+2)
+			checkRecordBytes(t, r, 21, []byte{208, 15, 159, 31})
+
+			_, got, err := r.GetArray(0)
+			if err != nil {
+				t.Error(err)
+			}
+			for i, value := range got.Values {
+				if !value.(time.Time).Equal(want.Values[i].(time.Time)) {
+					t.Errorf("got %v, want %v", value, want.Values[i])
+				}
+			}
+		},
+	)
+}
+
 func TestRecord_Length(t *testing.T) {
 	t.Run(
 		"length zero", func(t *testing.T) {
 			r := Record(make([]byte, 2))
-			want := uint16(0)
+			want := uint32(0)
 			got := r.Length()
 			if got != want {
 				t.Errorf("expected length %d, got %d", want, got)
@@ -58,7 +252,7 @@ func TestRecord_Length(t *testing.T) {
 		"length non-zero", func(t *testing.T) {
 			r := NewRecord(2)
 			got := r.Length()
-			want := uint16(8)
+			want := uint32(8)
 			if got != want {
 				t.Errorf("expected length %d, got %d", want, got)
 			}
@@ -113,6 +307,25 @@ func TestRecord_SetUint32(t *testing.T) {
 			checkRecordBytes(t, r, 6, []byte{20, 0, 0, 0})
 		},
 	)
+
+	t.Run(
+		"check existing value that cannot be read", func(t *testing.T) {
+			r := NewRecordWithEncoding(1, element.CompactEncoding)
+			if err := r.SetUint32(0, 300); err != nil {
+				t.Fatal(err)
+			}
+			// Set the continuation bit of the last byte of the varint, so that it runs past the
+			// end of the record.
+			(*r)[r.Length()-1] |= 0x80
+			before := append(Record(nil), *r...)
+			if err := r.SetUint32(0, 1); err == nil {
+				t.Error("expected error for a value that cannot be read")
+			}
+			if !reflect.DeepEqual(*r, before) {
+				t.Error("expected the record to be unchanged")
+			}
+		},
+	)
 }
 
 func TestRecord_SetUint64(t *testing.T) {
@@ -364,8 +577,12 @@ func TestRecord_SetBool(t *testing.T) {
 	t.Run(
 		"check two elements", func(t *testing.T) {
 			r := NewRecord(2)
-			r.SetBool(0, true)
-			r.SetBool(1, false)
+			if err := r.SetBool(0, true); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.SetBool(1, false); err != nil {
+				t.Fatal(err)
+			}
 
 			checkRecordLength(t, r, 10)
 			checkRecordBytes(t, r, 4, []byte{8, 0, 9, 0})
@@ -376,14 +593,31 @@ func TestRecord_SetBool(t *testing.T) {
 	t.Run(
 		"check element update", func(t *testing.T) {
 			r := NewRecord(1)
-			r.SetBool(0, true)
-			r.SetBool(0, false)
+			if err := r.SetBool(0, true); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.SetBool(0, false); err != nil {
+				t.Fatal(err)
+			}
 
 			checkRecordLength(t, r, 7)
 			checkRecordBytes(t, r, 4, []byte{6, 0})
 			checkRecordBytes(t, r, 6, []byte{0})
 		},
 	)
+
+	t.Run(
+		"check truncated element", func(t *testing.T) {
+			r := NewRecord(1)
+			if err := r.SetBool(0, true); err != nil {
+				t.Fatal(err)
+			}
+			*r = (*r)[:len(*r)-1]
+			if err := r.SetBool(0, false); err == nil {
+				t.Error("expected error for an element past the end of the record")
+			}
+		},
+	)
 }
 
 func TestRecord_SetTime(t *testing.T) {
@@ -411,519 +645,1978 @@ func TestRecord_SetTime(t *testing.T) {
 			checkRecordBytes(t, r, 6, []byte{0, 120, 231, 11, 253, 49, 33, 23})
 		},
 	)
-}
 
-func TestRecord_SetString(t *testing.T) {
 	t.Run(
-		"check empty string", func(t *testing.T) {
-			r := NewRecord(1)
-			err := r.SetString(0, "")
-			if err != nil {
-				t.Error(err)
+		"check time out of range", func(t *testing.T) {
+			r := NewRecord(2)
+			err := r.SetTime(0, time.Date(1600, 1, 1, 0, 0, 0, 0, time.UTC))
+			if err == nil {
+				t.Error("expected error when setting time before 1678")
+			}
+			err = r.SetTime(1, time.Date(2300, 1, 1, 0, 0, 0, 0, time.UTC))
+			if err == nil {
+				t.Error("expected error when setting time after 2262")
 			}
-
 			checkRecordLength(t, r, 8)
-			checkRecordBytes(t, r, 4, []byte{6, 0})
-			checkRecordBytes(t, r, 6, []byte{0, 0})
 		},
 	)
+}
 
+func TestRecord_SetDate(t *testing.T) {
 	t.Run(
-		"check two elements", func(t *testing.T) {
-			r := NewRecord(2)
-			err := r.SetString(0, "hello")
-			if err != nil {
-				t.Error(err)
+		"check round trip", func(t *testing.T) {
+			dates := []time.Time{
+				time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(1969, 12, 31, 23, 59, 59, 0, time.UTC),
+				time.Date(1066, 10, 14, 12, 0, 0, 0, time.UTC),
+				time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC),
 			}
-			err = r.SetString(1, "world")
-			if err != nil {
-				t.Error(err)
+			for _, date := range dates {
+				r := NewRecord(1)
+				r.SetDate(0, element.DateOf(date))
+				isNull, got, err := r.GetDate(0)
+				if err != nil {
+					t.Error(err)
+				}
+				if isNull {
+					t.Error("expected non-null value")
+				}
+				want := date.Format("2006-01-02")
+				if got.String() != want {
+					t.Errorf("got %v, want %v", got, want)
+				}
 			}
-
-			checkRecordLength(t, r, 22)
-			checkRecordBytes(t, r, 4, []byte{8, 0, 15, 0})
-			checkRecordBytes(t, r, 8, []byte{5, 0, 104, 101, 108, 108, 111})
-			checkRecordBytes(t, r, 15, []byte{5, 0, 119, 111, 114, 108, 100})
 		},
 	)
 
 	t.Run(
-		"check string with null character", func(t *testing.T) {
-			r := NewRecord(1)
-			err := r.SetString(0, "hello\x00world")
-			if err != nil {
-				t.Error(err)
+		"check date in time zone", func(t *testing.T) {
+			zone := time.FixedZone("", -8*60*60)
+			date := element.DateOf(time.Date(2022, 12, 31, 20, 0, 0, 0, zone))
+			if date.String() != "2022-12-31" {
+				t.Errorf("got %v, want %v", date, "2022-12-31")
 			}
-
-			checkRecordLength(t, r, 19)
-			checkRecordBytes(t, r, 4, []byte{6, 0})
-			checkRecordBytes(
-				t, r, 6, []byte{11, 0, 104, 101, 108, 108, 111, 0, 119, 111, 114, 108, 100},
-			)
 		},
 	)
 
 	t.Run(
-		"check emoji", func(t *testing.T) {
+		"check bytes", func(t *testing.T) {
 			r := NewRecord(1)
-			err := r.SetString(0, "😀")
-			if err != nil {
-				t.Error(err)
+			r.SetDate(0, element.DateOf(time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC)))
+
+			checkRecordLength(t, r, 10)
+			checkRecordBytes(t, r, 6, []byte{255, 255, 255, 255})
+		},
+	)
+}
+
+func TestRecord_SetDuration(t *testing.T) {
+	t.Run(
+		"check round trip", func(t *testing.T) {
+			durations := []time.Duration{0, -time.Nanosecond, 90 * time.Minute, 1<<63 - 1}
+			for _, encoding := range []element.Encoding{
+				element.LegacyEncoding, element.CompactEncoding,
+			} {
+				for _, want := range durations {
+					r := NewRecordWithEncoding(1, encoding)
+					r.SetDuration(0, want)
+					isNull, got, err := r.GetDuration(0)
+					if err != nil {
+						t.Error(err)
+					}
+					if isNull {
+						t.Error("expected non-null value")
+					}
+					if got != want {
+						t.Errorf("got %v, want %v", got, want)
+					}
+				}
 			}
+		},
+	)
+}
 
-			checkRecordLength(t, r, 12)
-			checkRecordBytes(t, r, 4, []byte{6, 0})
-			checkRecordBytes(t, r, 6, []byte{4, 0, 240, 159, 152, 128})
+func TestRecord_SetZonedTime(t *testing.T) {
+	t.Run(
+		"check round trip", func(t *testing.T) {
+			times := []time.Time{
+				time.Date(2022, 10, 25, 3, 25, 0, 123, time.FixedZone("IST", 5*60*60+30*60)),
+				time.Date(1492, 10, 12, 0, 0, 0, 0, time.FixedZone("", -4*60*60)),
+				time.Date(3000, 1, 1, 0, 0, 0, 999999999, time.UTC),
+			}
+			for _, want := range times {
+				r := NewRecord(1)
+				r.SetZonedTime(0, element.ZonedTime{Time: want})
+				isNull, got, err := r.GetZonedTime(0)
+				if err != nil {
+					t.Error(err)
+				}
+				if isNull {
+					t.Error("expected non-null value")
+				}
+				if !got.Equal(want) {
+					t.Errorf("got %v, want %v", got, want)
+				}
+				_, gotOffset := got.Zone()
+				_, wantOffset := want.Zone()
+				if gotOffset != wantOffset {
+					t.Errorf("got offset %v, want %v", gotOffset, wantOffset)
+				}
+			}
 		},
 	)
+}
 
+func TestRecord_SetInt8(t *testing.T) {
 	t.Run(
-		"check element update", func(t *testing.T) {
-			r := NewRecord(1)
-			err := r.SetString(0, "hello")
+		"check two elements", func(t *testing.T) {
+			r := NewRecord(2)
+			r.SetInt8(0, -128)
+			r.SetUint8(1, 255)
+
+			checkRecordLength(t, r, 10)
+			checkRecordBytes(t, r, 4, []byte{8, 0, 9, 0})
+			checkRecordBytes(t, r, 8, []byte{128, 255})
+
+			_, gotInt8, err := r.GetInt8(0)
 			if err != nil {
 				t.Error(err)
 			}
-			err = r.SetString(0, "world")
+			if gotInt8 != -128 {
+				t.Errorf("got %v, want %v", gotInt8, -128)
+			}
+			_, gotUint8, err := r.GetUint8(1)
 			if err != nil {
 				t.Error(err)
 			}
-			checkRecordLength(t, r, 13)
-			checkRecordBytes(t, r, 4, []byte{6, 0})
-			checkRecordBytes(t, r, 6, []byte{5, 0, 119, 111, 114, 108, 100})
+			if gotUint8 != 255 {
+				t.Errorf("got %v, want %v", gotUint8, 255)
+			}
 		},
 	)
+}
 
+func TestRecord_SetInt16(t *testing.T) {
 	t.Run(
-		"check write overflows", func(t *testing.T) {
-			r := NewRecord(1)
-			err := r.SetString(0, "hello")
+		"check two elements", func(t *testing.T) {
+			r := NewRecord(2)
+			r.SetInt16(0, -2)
+			r.SetUint16(1, 65535)
+
+			checkRecordLength(t, r, 12)
+			checkRecordBytes(t, r, 4, []byte{8, 0, 10, 0})
+			checkRecordBytes(t, r, 8, []byte{254, 255, 255, 255})
+
+			_, gotInt16, err := r.GetInt16(0)
 			if err != nil {
 				t.Error(err)
 			}
-			err = r.SetString(0, "world!")
-			if err == nil {
-				t.Error("expected error when writing over a shorter string")
+			if gotInt16 != -2 {
+				t.Errorf("got %v, want %v", gotInt16, -2)
 			}
-		},
-	)
-}
-
-func TestRecord_SetArray(t *testing.T) {
-	t.Run(
-		"check empty array", func(t *testing.T) {
-			r := NewRecord(1)
-			err := r.SetArray(0, element.Array{element.Int32Type, []any{}})
+			_, gotUint16, err := r.GetUint16(1)
 			if err != nil {
 				t.Error(err)
 			}
-
-			checkRecordLength(t, r, 9)
-			checkRecordBytes(t, r, 4, []byte{6, 0})
-			checkRecordBytes(t, r, 6, []byte{0, 0, element.Int32Type})
+			if gotUint16 != 65535 {
+				t.Errorf("got %v, want %v", gotUint16, 65535)
+			}
 		},
 	)
+}
 
+func TestRecord_SetUUID(t *testing.T) {
 	t.Run(
-		"check array with one element", func(t *testing.T) {
-			r := NewRecord(1)
-			err := r.SetArray(0, element.Array{element.Int32Type, []any{1}})
+		"check basic set", func(t *testing.T) {
+			want, err := element.ParseUUID("123e4567-e89b-12d3-a456-426614174000")
 			if err != nil {
 				t.Error(err)
 			}
+			r := NewRecord(1)
+			r.SetUUID(0, want)
 
-			checkRecordLength(t, r, 13)
-			checkRecordBytes(t, r, 4, []byte{6, 0})
-			checkRecordBytes(t, r, 6, []byte{1, 0, element.Int32Type, 1, 0, 0, 0})
-		},
-	)
+			checkRecordLength(t, r, 22)
+			checkRecordBytes(t, r, 6, []byte{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b})
 
-	t.Run(
-		"check array with two elements", func(t *testing.T) {
-			r := NewRecord(1)
-			err := r.SetArray(0, element.Array{element.Int32Type, []any{1, 2}})
+			isNull, got, err := r.GetUUID(0)
 			if err != nil {
 				t.Error(err)
 			}
-
-			checkRecordLength(t, r, 17)
-			checkRecordBytes(t, r, 4, []byte{6, 0})
-			checkRecordBytes(t, r, 6, []byte{2, 0, element.Int32Type, 1, 0, 0, 0, 2, 0, 0, 0})
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			if got.String() != "123e4567-e89b-12d3-a456-426614174000" {
+				t.Errorf("unexpected uuid string %s", got.String())
+			}
 		},
 	)
 
 	t.Run(
-		"check array with two elements of different types", func(t *testing.T) {
-			r := NewRecord(1)
-			err := r.SetArray(0, element.Array{element.Int32Type, []any{1, "hello"}})
+		"check invalid uuid", func(t *testing.T) {
+			_, err := element.ParseUUID("123e4567e89b12d3a456426614174000")
 			if err == nil {
-				t.Error("expected error when setting array with different types")
+				t.Error("expected error when parsing uuid without dashes")
 			}
 		},
 	)
+}
 
+func TestRecord_SetDecimal(t *testing.T) {
 	t.Run(
-		"check two arrays", func(t *testing.T) {
-			r := NewRecord(2)
-			err := r.SetArray(0, element.Array{element.Int32Type, []any{1, 2}})
+		"check basic set", func(t *testing.T) {
+			want, err := element.ParseDecimal("-12.3", 5, 2)
 			if err != nil {
 				t.Error(err)
 			}
-			err = r.SetArray(1, element.Array{element.Int32Type, []any{3, 4}})
+			r := NewRecord(1)
+			r.SetDecimal(0, want)
+
+			checkRecordLength(t, r, 16)
+			checkRecordBytes(t, r, 6, []byte{5, 2, 0x32, 0xfb, 255, 255, 255, 255, 255, 255})
+
+			isNull, got, err := r.GetDecimal(0)
 			if err != nil {
 				t.Error(err)
 			}
-
-			checkRecordLength(t, r, 30)
-			checkRecordBytes(t, r, 4, []byte{8, 0, 19, 0})
-			checkRecordBytes(t, r, 8, []byte{2, 0, element.Int32Type, 1, 0, 0, 0, 2, 0, 0, 0})
-			checkRecordBytes(t, r, 19, []byte{2, 0, element.Int32Type, 3, 0, 0, 0, 4, 0, 0, 0})
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			if got.String() != "-12.30" {
+				t.Errorf("unexpected decimal string %s", got.String())
+			}
 		},
 	)
 
 	t.Run(
-		"check element update", func(t *testing.T) {
-			r := NewRecord(1)
-			err := r.SetArray(0, element.Array{element.Int32Type, []any{1, 2}})
+		"check small decimal string", func(t *testing.T) {
+			d, err := element.NewDecimal(5, 3, 3)
 			if err != nil {
 				t.Error(err)
 			}
-			err = r.SetArray(0, element.Array{element.Int32Type, []any{3, 4}})
+			if d.String() != "0.005" {
+				t.Errorf("unexpected decimal string %s", d.String())
+			}
+		},
+	)
+
+	t.Run(
+		"check precision overflow", func(t *testing.T) {
+			_, err := element.ParseDecimal("1234.5", 4, 1)
+			if err == nil {
+				t.Error("expected error when parsing decimal with too many digits")
+			}
+			_, err = element.ParseDecimal("1.234", 4, 2)
+			if err == nil {
+				t.Error("expected error when parsing decimal with too many fractional digits")
+			}
+		},
+	)
+
+	t.Run(
+		"check invalid decimals are rejected", func(t *testing.T) {
+			decimals := []element.Decimal{
+				{Value: 1, Precision: 0, Scale: 0},
+				{Value: 1, Precision: element.MaxDecimalPrecision + 1, Scale: 0},
+				{Value: 1, Precision: 2, Scale: 3},
+				{Value: 12345, Precision: 4, Scale: 1},
+			}
+			for _, d := range decimals {
+				r := NewRecord(2)
+				err := r.SetDecimal(0, d)
+				if _, ok := err.(*element.InvalidDecimalError); !ok {
+					t.Errorf("%+v: expected InvalidDecimalError, got %v", d, err)
+				}
+				array := element.Array{ElementType: element.DecimalType, Values: []any{d}}
+				err = r.SetArray(1, array)
+				if _, ok := err.(*element.InvalidDecimalError); !ok {
+					t.Errorf("%+v: expected InvalidDecimalError in array, got %v", d, err)
+				}
+				if isNull, _, _ := r.GetDecimal(0); !isNull {
+					t.Errorf("%+v: expected no decimal to be stored", d)
+				}
+			}
+		},
+	)
+}
+
+func TestRecord_SetBytes(t *testing.T) {
+	t.Run(
+		"check basic set", func(t *testing.T) {
+			r := NewRecord(1)
+			err := r.SetBytes(0, []byte{0, 1, 255})
 			if err != nil {
 				t.Error(err)
 			}
 
-			checkRecordLength(t, r, 17)
-			checkRecordBytes(t, r, 4, []byte{6, 0})
-			checkRecordBytes(t, r, 6, []byte{2, 0, element.Int32Type, 3, 0, 0, 0, 4, 0, 0, 0})
+			checkRecordLength(t, r, 11)
+			checkRecordBytes(t, r, 6, []byte{3, 0, 0, 1, 255})
+
+			isNull, got, err := r.GetBytes(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if !reflect.DeepEqual(got, []byte{0, 1, 255}) {
+				t.Errorf("got %v, want %v", got, []byte{0, 1, 255})
+			}
 		},
 	)
 
 	t.Run(
 		"check write overflows", func(t *testing.T) {
 			r := NewRecord(1)
-			err := r.SetArray(0, element.Array{element.Int32Type, []any{1, 2}})
+			err := r.SetBytes(0, []byte{1})
 			if err != nil {
 				t.Error(err)
 			}
-			err = r.SetArray(0, element.Array{element.Int32Type, []any{3, 4, 5}})
+			err = r.SetBytes(0, []byte{1, 2})
 			if err == nil {
-				t.Error("expected error when writing over a shorter array")
+				t.Error("expected error when writing over shorter bytes")
 			}
 		},
 	)
 
 	t.Run(
-		"check array followed by string", func(t *testing.T) {
-			r := NewRecord(2)
-			err := r.SetArray(0, element.Array{element.Int32Type, []any{1, 2}})
-			if err != nil {
-				t.Error(err)
+		"check map with bytes keys", func(t *testing.T) {
+			r := NewRecord(1)
+			err := r.SetMap(0, element.Map{
+				KeyType:   element.BytesType,
+				ValueType: element.Int32Type,
+				Data:      map[any]any{},
+			})
+			if err == nil {
+				t.Error("expected error when setting map with bytes keys")
 			}
-			err = r.SetString(1, "hello")
+		},
+	)
+}
+
+func TestRecord_SetString(t *testing.T) {
+	t.Run(
+		"check empty string", func(t *testing.T) {
+			r := NewRecord(1)
+			err := r.SetString(0, "")
 			if err != nil {
 				t.Error(err)
 			}
 
-			checkRecordLength(t, r, 26)
-			checkRecordBytes(t, r, 4, []byte{8, 0, 19, 0})
-			checkRecordBytes(t, r, 8, []byte{2, 0, element.Int32Type, 1, 0, 0, 0, 2, 0, 0, 0})
-			checkRecordBytes(t, r, 19, []byte{5, 0, 104, 101, 108, 108, 111})
+			checkRecordLength(t, r, 8)
+			checkRecordBytes(t, r, 4, []byte{6, 0})
+			checkRecordBytes(t, r, 6, []byte{0, 0})
 		},
 	)
 
 	t.Run(
-		"check string followed by array", func(t *testing.T) {
+		"check two elements", func(t *testing.T) {
 			r := NewRecord(2)
 			err := r.SetString(0, "hello")
 			if err != nil {
 				t.Error(err)
 			}
-			err = r.SetArray(1, element.Array{element.Int32Type, []any{1, 2}})
+			err = r.SetString(1, "world")
 			if err != nil {
 				t.Error(err)
 			}
 
-			checkRecordLength(t, r, 26)
+			checkRecordLength(t, r, 22)
 			checkRecordBytes(t, r, 4, []byte{8, 0, 15, 0})
 			checkRecordBytes(t, r, 8, []byte{5, 0, 104, 101, 108, 108, 111})
-			checkRecordBytes(t, r, 15, []byte{2, 0, element.Int32Type, 1, 0, 0, 0, 2, 0, 0, 0})
+			checkRecordBytes(t, r, 15, []byte{5, 0, 119, 111, 114, 108, 100})
 		},
 	)
 
 	t.Run(
-		"check array with array element", func(t *testing.T) {
+		"check string with null character", func(t *testing.T) {
 			r := NewRecord(1)
-			err := r.SetArray(
-				0,
-				element.Array{
-					element.ArrayType,
-					[]any{element.Array{element.Int32Type, []any{1, 2}}},
-				},
-			)
-			if err == nil {
-				t.Error("expected error when setting array with array elements")
+			err := r.SetString(0, "hello\x00world")
+			if err != nil {
+				t.Error(err)
 			}
+
+			checkRecordLength(t, r, 19)
+			checkRecordBytes(t, r, 4, []byte{6, 0})
+			checkRecordBytes(
+				t, r, 6, []byte{11, 0, 104, 101, 108, 108, 111, 0, 119, 111, 114, 108, 100},
+			)
 		},
 	)
 
 	t.Run(
-		"check array with nil value", func(t *testing.T) {
+		"check emoji", func(t *testing.T) {
 			r := NewRecord(1)
-			err := r.SetArray(0, element.Array{element.Int32Type, nil})
+			err := r.SetString(0, "😀")
 			if err != nil {
 				t.Error(err)
 			}
 
-			checkRecordLength(t, r, 6)
-			checkRecordBytes(t, r, 4, []byte{0, 0})
+			checkRecordLength(t, r, 12)
+			checkRecordBytes(t, r, 4, []byte{6, 0})
+			checkRecordBytes(t, r, 6, []byte{4, 0, 240, 159, 152, 128})
 		},
 	)
-}
 
-func TestRecord_SetMap(t *testing.T) {
 	t.Run(
-		"check empty map", func(t *testing.T) {
+		"check element update", func(t *testing.T) {
 			r := NewRecord(1)
-			err := r.SetMap(0, element.Map{element.Int32Type, element.Int32Type, map[any]any{}})
+			err := r.SetString(0, "hello")
 			if err != nil {
 				t.Error(err)
 			}
-
-			checkRecordLength(t, r, 10)
+			err = r.SetString(0, "world")
+			if err != nil {
+				t.Error(err)
+			}
+			checkRecordLength(t, r, 13)
 			checkRecordBytes(t, r, 4, []byte{6, 0})
-			checkRecordBytes(t, r, 6, []byte{0, 0, element.Int32Type, element.Int32Type})
+			checkRecordBytes(t, r, 6, []byte{5, 0, 119, 111, 114, 108, 100})
 		},
 	)
 
 	t.Run(
-		"check map with one element", func(t *testing.T) {
+		"check write overflows", func(t *testing.T) {
 			r := NewRecord(1)
-			err := r.SetMap(0, element.Map{element.Int32Type, element.Int32Type, map[any]any{1: 2}})
+			err := r.SetString(0, "hello")
 			if err != nil {
 				t.Error(err)
 			}
+			err = r.SetString(0, "world!")
+			if err == nil {
+				t.Error("expected error when writing over a shorter string")
+			}
+		},
+	)
 
-			checkRecordLength(t, r, 18)
-			checkRecordBytes(t, r, 4, []byte{6, 0})
-			checkRecordBytes(
-				t, r, 6, []byte{1, 0, element.Int32Type, element.Int32Type, 1, 0, 0, 0, 2, 0, 0, 0},
-			)
+	t.Run(
+		"check shorter update releases bytes", func(t *testing.T) {
+			r := NewRecord(2)
+			err := r.SetString(0, "hello")
+			if err != nil {
+				t.Error(err)
+			}
+			r.SetUint32(1, 10)
+			err = r.SetString(0, "hi")
+			if err != nil {
+				t.Error(err)
+			}
+
+			checkRecordLength(t, r, 16)
+			checkRecordBytes(t, r, 0, []byte{16, 0})
+			checkRecordBytes(t, r, 4, []byte{8, 0, 12, 0})
+			checkRecordBytes(t, r, 8, []byte{2, 0, 104, 105})
+			checkRecordBytes(t, r, 12, []byte{10, 0, 0, 0})
 		},
 	)
 
 	t.Run(
-		"check map with two key-value pairs", func(t *testing.T) {
+		"check truncated string", func(t *testing.T) {
 			r := NewRecord(1)
-			err := r.SetMap(
-				0,
-				element.Map{element.Int32Type, element.Int32Type, map[any]any{1: 2, 3: 4}},
-			)
+			if err := r.SetString(0, "hello"); err != nil {
+				t.Fatal(err)
+			}
+			*r = (*r)[:7]
+			err := r.SetString(0, "hi")
+			if _, ok := err.(*CorruptRecordError); !ok {
+				t.Errorf("expected CorruptRecordError, got %v", err)
+			}
+		},
+	)
+}
+
+func TestRecord_SetArray(t *testing.T) {
+	t.Run(
+		"check empty array", func(t *testing.T) {
+			r := NewRecord(1)
+			err := r.SetArray(0, element.Array{ElementType: element.Int32Type, Values: []any{}})
 			if err != nil {
 				t.Error(err)
 			}
 
-			checkRecordLength(t, r, 26)
+			checkRecordLength(t, r, 9)
 			checkRecordBytes(t, r, 4, []byte{6, 0})
-			checkRecordBytes(t, r, 6, []byte{2, 0, element.Int32Type, element.Int32Type})
-			checkRecordBytesOneOf(
-				t, r, 10, [][]byte{
-					{
-						1, 0, 0, 0,
-						2, 0, 0, 0,
-						3, 0, 0, 0,
-						4, 0, 0, 0,
-					},
-					{
-						3, 0, 0, 0,
-						4, 0, 0, 0,
-						1, 0, 0, 0,
-						2, 0, 0, 0,
-					},
-				},
-			)
+			checkRecordBytes(t, r, 6, []byte{0, 0, element.Int32Type})
 		},
 	)
 
 	t.Run(
-		"check map with string keys", func(t *testing.T) {
+		"check array with one element", func(t *testing.T) {
 			r := NewRecord(1)
-			err := r.SetMap(
-				0, element.Map{
-					element.StringType,
-					element.Int32Type, map[any]any{"a": 1, "b": 2},
-				},
-			)
+			err := r.SetArray(0, element.Array{ElementType: element.Int32Type, Values: []any{1}})
 			if err != nil {
 				t.Error(err)
 			}
 
-			checkRecordLength(t, r, 24)
+			checkRecordLength(t, r, 13)
 			checkRecordBytes(t, r, 4, []byte{6, 0})
-			checkRecordBytes(t, r, 6, []byte{2, 0, element.StringType, element.Int32Type})
-			checkRecordBytesOneOf(
-				t, r, 10, [][]byte{
-					{
-						1, 0, 97,
-						1, 0, 0, 0,
-						1, 0, 98,
-						2, 0, 0, 0,
-					},
-					{
-						1, 0, 98,
-						2, 0, 0, 0,
-						1, 0, 97,
-						1, 0, 0, 0,
-					},
-				},
-			)
+			checkRecordBytes(t, r, 6, []byte{1, 0, element.Int32Type, 1, 0, 0, 0})
 		},
 	)
 
 	t.Run(
-		"check map with array values", func(t *testing.T) {
+		"check array with two elements", func(t *testing.T) {
 			r := NewRecord(1)
-			err := r.SetMap(
-				0,
-				element.Map{
-					element.StringType,
-					element.ArrayType,
-					map[any]any{"a": element.Array{element.Int32Type, []any{1, 2}}},
-				},
-			)
+			err := r.SetArray(0, element.Array{ElementType: element.Int32Type, Values: []any{1, 2}})
 			if err != nil {
 				t.Error(err)
 			}
 
-			checkRecordLength(t, r, 24)
+			checkRecordLength(t, r, 17)
 			checkRecordBytes(t, r, 4, []byte{6, 0})
-			checkRecordBytes(t, r, 6, []byte{1, 0, element.StringType, element.ArrayType})
-			checkRecordBytes(
-				t, r, 10, []byte{1, 0, 97, 2, 0, element.Int32Type, 1, 0, 0, 0, 2, 0, 0, 0},
-			)
+			checkRecordBytes(t, r, 6, []byte{2, 0, element.Int32Type, 1, 0, 0, 0, 2, 0, 0, 0})
 		},
 	)
 
 	t.Run(
-		"check map with map values", func(t *testing.T) {
+		"check array with two elements of different types", func(t *testing.T) {
 			r := NewRecord(1)
-			err := r.SetMap(
-				0,
-				element.Map{
-					element.StringType,
-					element.MapType,
-					map[any]any{
-						"a": element.Map{
-							element.Int32Type,
-							element.Int32Type, map[any]any{1: 2},
-						},
-					},
-				},
-			)
+			err := r.SetArray(0, element.Array{
+				ElementType: element.Int32Type,
+				Values:      []any{1, "hello"},
+			})
 			if err == nil {
-				t.Error("expected error when setting map with map values")
+				t.Error("expected error when setting array with different types")
 			}
 		},
 	)
 
 	t.Run(
-		"check map with different key types", func(t *testing.T) {
-			r := NewRecord(1)
-			err := r.SetMap(
-				0, element.Map{
-					element.StringType,
-					element.Int32Type, map[any]any{"a": 1, 2: 3},
-				},
-			)
-			if err == nil {
-				t.Error("expected error when setting map with different key types")
+		"check two arrays", func(t *testing.T) {
+			r := NewRecord(2)
+			err := r.SetArray(0, element.Array{ElementType: element.Int32Type, Values: []any{1, 2}})
+			if err != nil {
+				t.Error(err)
+			}
+			err = r.SetArray(1, element.Array{ElementType: element.Int32Type, Values: []any{3, 4}})
+			if err != nil {
+				t.Error(err)
 			}
+
+			checkRecordLength(t, r, 30)
+			checkRecordBytes(t, r, 4, []byte{8, 0, 19, 0})
+			checkRecordBytes(t, r, 8, []byte{2, 0, element.Int32Type, 1, 0, 0, 0, 2, 0, 0, 0})
+			checkRecordBytes(t, r, 19, []byte{2, 0, element.Int32Type, 3, 0, 0, 0, 4, 0, 0, 0})
 		},
 	)
 
 	t.Run(
-		"check map with different value types", func(t *testing.T) {
+		"check element update", func(t *testing.T) {
 			r := NewRecord(1)
-			err := r.SetMap(
-				0, element.Map{
-					element.StringType,
-					element.Int32Type, map[any]any{"a": 1, "b": "c"},
-				},
-			)
-			if err == nil {
-				t.Error("expected error when setting map with different value types")
+			err := r.SetArray(0, element.Array{ElementType: element.Int32Type, Values: []any{1, 2}})
+			if err != nil {
+				t.Error(err)
+			}
+			err = r.SetArray(0, element.Array{ElementType: element.Int32Type, Values: []any{3, 4}})
+			if err != nil {
+				t.Error(err)
 			}
+
+			checkRecordLength(t, r, 17)
+			checkRecordBytes(t, r, 4, []byte{6, 0})
+			checkRecordBytes(t, r, 6, []byte{2, 0, element.Int32Type, 3, 0, 0, 0, 4, 0, 0, 0})
 		},
 	)
 
 	t.Run(
-		"check map with nil value", func(t *testing.T) {
+		"check write overflows", func(t *testing.T) {
 			r := NewRecord(1)
-			err := r.SetMap(0, element.Map{element.StringType, element.Int32Type, nil})
+			err := r.SetArray(0, element.Array{ElementType: element.Int32Type, Values: []any{1, 2}})
 			if err != nil {
 				t.Error(err)
 			}
-
-			checkRecordLength(t, r, 6)
-			checkRecordBytes(t, r, 4, []byte{0, 0})
+			err = r.SetArray(0, element.Array{
+				ElementType: element.Int32Type,
+				Values:      []any{3, 4, 5},
+			})
+			if err == nil {
+				t.Error("expected error when writing over a shorter array")
+			}
 		},
 	)
-}
 
-func TestRecord_GetUint32(t *testing.T) {
 	t.Run(
-		"check basic get", func(t *testing.T) {
+		"check write overflows with longer strings", func(t *testing.T) {
 			r := NewRecord(1)
-			want := uint32(10)
-			r.SetUint32(0, want)
-			isNull, got := r.GetUint32(0)
-			if isNull {
-				t.Error("expected non-null value")
+			err := r.SetArray(0, element.Array{
+				ElementType: element.StringType,
+				Values:      []any{"a", "b"},
+			})
+			if err != nil {
+				t.Error(err)
 			}
-			if got != want {
-				t.Errorf("got %d, want %d", got, want)
+			err = r.SetArray(0, element.Array{
+				ElementType: element.StringType,
+				Values:      []any{"hello", "world"},
+			})
+			if err == nil {
+				t.Error("expected error when writing over an array with shorter strings")
 			}
 		},
 	)
 
 	t.Run(
-		"check two fields", func(t *testing.T) {
+		"check shorter update releases bytes", func(t *testing.T) {
 			r := NewRecord(2)
-			want := uint32(10)
-			r.SetUint32(0, want)
-			isNull, got := r.GetUint32(0)
-			if isNull {
+			err := r.SetArray(0, element.Array{ElementType: element.Int32Type, Values: []any{1, 2}})
+			if err != nil {
+				t.Error(err)
+			}
+			err = r.SetString(1, "hello")
+			if err != nil {
+				t.Error(err)
+			}
+			err = r.SetArray(0, element.Array{ElementType: element.Int32Type, Values: []any{3}})
+			if err != nil {
+				t.Error(err)
+			}
+
+			checkRecordLength(t, r, 22)
+			checkRecordBytes(t, r, 4, []byte{8, 0, 15, 0})
+			checkRecordBytes(t, r, 8, []byte{1, 0, element.Int32Type, 3, 0, 0, 0})
+			checkRecordBytes(t, r, 15, []byte{5, 0, 104, 101, 108, 108, 111})
+		},
+	)
+
+	t.Run(
+		"check array followed by string", func(t *testing.T) {
+			r := NewRecord(2)
+			err := r.SetArray(0, element.Array{ElementType: element.Int32Type, Values: []any{1, 2}})
+			if err != nil {
+				t.Error(err)
+			}
+			err = r.SetString(1, "hello")
+			if err != nil {
+				t.Error(err)
+			}
+
+			checkRecordLength(t, r, 26)
+			checkRecordBytes(t, r, 4, []byte{8, 0, 19, 0})
+			checkRecordBytes(t, r, 8, []byte{2, 0, element.Int32Type, 1, 0, 0, 0, 2, 0, 0, 0})
+			checkRecordBytes(t, r, 19, []byte{5, 0, 104, 101, 108, 108, 111})
+		},
+	)
+
+	t.Run(
+		"check string followed by array", func(t *testing.T) {
+			r := NewRecord(2)
+			err := r.SetString(0, "hello")
+			if err != nil {
+				t.Error(err)
+			}
+			err = r.SetArray(1, element.Array{ElementType: element.Int32Type, Values: []any{1, 2}})
+			if err != nil {
+				t.Error(err)
+			}
+
+			checkRecordLength(t, r, 26)
+			checkRecordBytes(t, r, 4, []byte{8, 0, 15, 0})
+			checkRecordBytes(t, r, 8, []byte{5, 0, 104, 101, 108, 108, 111})
+			checkRecordBytes(t, r, 15, []byte{2, 0, element.Int32Type, 1, 0, 0, 0, 2, 0, 0, 0})
+		},
+	)
+
+	t.Run(
+		"check array with array element", func(t *testing.T) {
+			r := NewRecord(1)
+			err := r.SetArray(
+				0,
+				element.Array{
+					ElementType: element.ArrayType,
+					Values: []any{element.Array{
+						ElementType: element.Int32Type,
+						Values:      []any{int32(1), int32(2)},
+					}},
+				},
+			)
+			if err != nil {
+				t.Error(err)
+			}
+
+			checkRecordLength(t, r, 20)
+			checkRecordBytes(t, r, 6, []byte{1, 0, 'a', 2, 0, 'i', 1, 0, 0, 0, 2, 0, 0, 0})
+		},
+	)
+
+	t.Run(
+		"check array with nested arrays and maps", func(t *testing.T) {
+			r := NewRecord(1)
+			want := element.Array{
+				ElementType: element.ArrayType,
+				Values: []any{
+					element.Array{
+						ElementType: element.MapType,
+						Values: []any{
+							element.Map{
+								KeyType:   element.StringType,
+								ValueType: element.ArrayType,
+								Data: map[any]any{
+									"a": element.Array{
+										ElementType: element.Int64Type,
+										Values:      []any{int64(1)},
+									},
+								},
+							},
+						},
+					},
+					element.Array{ElementType: element.MapType, Values: []any{}},
+				},
+			}
+			err := r.SetArray(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+
+			isNull, got, err := r.GetArray(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull || !reflect.DeepEqual(got, want) {
+				t.Errorf("expected %v, got %v", want, got)
+			}
+		},
+	)
+
+	t.Run(
+		"check array with any elements", func(t *testing.T) {
+			r := NewRecord(1)
+			err := r.SetArray(
+				0,
+				element.Array{
+					ElementType: element.AnyType,
+					Values: []any{
+						int32(1), "a", nil,
+						element.Array{ElementType: element.BoolType, Values: []any{true}},
+					},
+				},
+			)
+			if err != nil {
+				t.Error(err)
+			}
+
+			checkRecordLength(t, r, 24)
+			checkRecordBytes(
+				t, r, 6, []byte{
+					4, 0, 'x', 'i', 1, 0, 0, 0, 's', 1, 0, 'a', 0, 'a', 1, 0, 'b', 1,
+				},
+			)
+
+			want := element.Array{
+				ElementType: element.AnyType,
+				Values: []any{
+					int32(1), "a", nil,
+					element.Array{ElementType: element.BoolType, Values: []any{true}},
+				},
+			}
+			isNull, got, err := r.GetArray(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull || !reflect.DeepEqual(got, want) {
+				t.Errorf("expected %v, got %v", want, got)
+			}
+		},
+	)
+
+	t.Run(
+		"check array with null element type", func(t *testing.T) {
+			r := NewRecord(1)
+			err := r.SetArray(0, element.Array{ElementType: element.NullType, Values: []any{nil}})
+			if err == nil {
+				t.Error("expected error when setting array with null element type")
+			}
+		},
+	)
+
+	t.Run(
+		"check array nested too deeply", func(t *testing.T) {
+			r := NewRecord(1)
+			a := element.Array{ElementType: element.Int32Type, Values: []any{int32(1)}}
+			for i := 0; i < element.MaxNestingDepth; i++ {
+				a = element.Array{ElementType: element.ArrayType, Values: []any{a}}
+			}
+			err := r.SetArray(0, a)
+			if _, ok := err.(*element.NestingDepthError); !ok {
+				t.Errorf("expected NestingDepthError, got %v", err)
+			}
+			checkRecordLength(t, r, 6)
+		},
+	)
+
+	t.Run(
+		"check array nested to maximum depth", func(t *testing.T) {
+			r := NewRecord(1)
+			a := element.Array{ElementType: element.Int32Type, Values: []any{int32(1)}}
+			for i := 1; i < element.MaxNestingDepth; i++ {
+				a = element.Array{ElementType: element.ArrayType, Values: []any{a}}
+			}
+			err := r.SetArray(0, a)
+			if err != nil {
+				t.Error(err)
+			}
+
+			_, got, err := r.GetArray(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if !reflect.DeepEqual(got, a) {
+				t.Errorf("expected %v, got %v", a, got)
+			}
+		},
+	)
+
+	t.Run(
+		"check nested array update", func(t *testing.T) {
+			r := NewRecord(1)
+			err := r.SetArray(
+				0,
+				element.Array{
+					ElementType: element.ArrayType,
+					Values: []any{element.Array{
+						ElementType: element.StringType,
+						Values:      []any{"abc", "def"},
+					}},
+				},
+			)
+			if err != nil {
+				t.Error(err)
+			}
+
+			err = r.SetArray(
+				0,
+				element.Array{
+					ElementType: element.ArrayType,
+					Values: []any{element.Array{
+						ElementType: element.StringType,
+						Values:      []any{"abc"},
+					}},
+				},
+			)
+			if err != nil {
+				t.Error(err)
+			}
+			checkRecordLength(t, r, 17)
+
+			err = r.SetArray(
+				0,
+				element.Array{
+					ElementType: element.ArrayType,
+					Values: []any{element.Array{
+						ElementType: element.StringType,
+						Values:      []any{"abc", "def"},
+					}},
+				},
+			)
+			if _, ok := err.(*WriteOverflowError); !ok {
+				t.Errorf("expected WriteOverflowError, got %v", err)
+			}
+		},
+	)
+
+	t.Run(
+		"check array with nil value", func(t *testing.T) {
+			r := NewRecord(1)
+			err := r.SetArray(0, element.Array{ElementType: element.Int32Type, Values: nil})
+			if err != nil {
+				t.Error(err)
+			}
+
+			checkRecordLength(t, r, 6)
+			checkRecordBytes(t, r, 4, []byte{0, 0})
+		},
+	)
+}
+
+// sortedMapFlag is the flag that marks the key type byte of maps whose entries are sorted by key.
+const sortedMapFlag = 0x80
+
+func TestRecord_SetMap(t *testing.T) {
+	t.Run(
+		"check empty map", func(t *testing.T) {
+			r := NewRecord(1)
+			err := r.SetMap(0, element.Map{
+				KeyType:   element.Int32Type,
+				ValueType: element.Int32Type,
+				Data:      map[any]any{},
+			})
+			if err != nil {
+				t.Error(err)
+			}
+
+			checkRecordLength(t, r, 10)
+			checkRecordBytes(t, r, 4, []byte{6, 0})
+			checkRecordBytes(
+				t, r, 6, []byte{0, 0, element.Int32Type | sortedMapFlag, element.Int32Type},
+			)
+		},
+	)
+
+	t.Run(
+		"check map with one element", func(t *testing.T) {
+			r := NewRecord(1)
+			err := r.SetMap(0, element.Map{
+				KeyType:   element.Int32Type,
+				ValueType: element.Int32Type,
+				Data:      map[any]any{1: 2},
+			})
+			if err != nil {
+				t.Error(err)
+			}
+
+			checkRecordLength(t, r, 18)
+			checkRecordBytes(t, r, 4, []byte{6, 0})
+			checkRecordBytes(
+				t, r, 6, []byte{
+					1, 0, element.Int32Type | sortedMapFlag, element.Int32Type,
+					1, 0, 0, 0, 2, 0, 0, 0,
+				},
+			)
+		},
+	)
+
+	t.Run(
+		"check map with two key-value pairs", func(t *testing.T) {
+			r := NewRecord(1)
+			err := r.SetMap(
+				0,
+				element.Map{
+					KeyType:   element.Int32Type,
+					ValueType: element.Int32Type,
+					Data:      map[any]any{1: 2, 3: 4},
+				},
+			)
+			if err != nil {
+				t.Error(err)
+			}
+
+			checkRecordLength(t, r, 26)
+			checkRecordBytes(t, r, 4, []byte{6, 0})
+			checkRecordBytes(
+				t, r, 6, []byte{2, 0, element.Int32Type | sortedMapFlag, element.Int32Type},
+			)
+			checkRecordBytes(
+				t, r, 10, []byte{
+					1, 0, 0, 0,
+					2, 0, 0, 0,
+					3, 0, 0, 0,
+					4, 0, 0, 0,
+				},
+			)
+		},
+	)
+
+	t.Run(
+		"check map with string keys", func(t *testing.T) {
+			r := NewRecord(1)
+			err := r.SetMap(
+				0, element.Map{
+					KeyType:   element.StringType,
+					ValueType: element.Int32Type,
+					Data:      map[any]any{"a": 1, "b": 2},
+				},
+			)
+			if err != nil {
+				t.Error(err)
+			}
+
+			// The entries of string keys vary in size, so they are indexed by their offsets.
+			checkRecordLength(t, r, 28)
+			checkRecordBytes(t, r, 4, []byte{6, 0})
+			checkRecordBytes(
+				t, r, 6, []byte{2, 0, element.StringType | sortedMapFlag, element.Int32Type},
+			)
+			checkRecordBytes(
+				t, r, 10, []byte{
+					0, 0, 7, 0,
+					1, 0, 97,
+					1, 0, 0, 0,
+					1, 0, 98,
+					2, 0, 0, 0,
+				},
+			)
+		},
+	)
+
+	t.Run(
+		"check map with array values", func(t *testing.T) {
+			r := NewRecord(1)
+			err := r.SetMap(
+				0,
+				element.Map{
+					KeyType:   element.StringType,
+					ValueType: element.ArrayType,
+					Data: map[any]any{"a": element.Array{
+						ElementType: element.Int32Type,
+						Values:      []any{1, 2},
+					}},
+				},
+			)
+			if err != nil {
+				t.Error(err)
+			}
+
+			checkRecordLength(t, r, 26)
+			checkRecordBytes(t, r, 4, []byte{6, 0})
+			checkRecordBytes(
+				t, r, 6, []byte{1, 0, element.StringType | sortedMapFlag, element.ArrayType},
+			)
+			checkRecordBytes(
+				t, r, 10, []byte{0, 0, 1, 0, 97, 2, 0, element.Int32Type, 1, 0, 0, 0, 2, 0, 0, 0},
+			)
+		},
+	)
+
+	t.Run(
+		"check map with map values", func(t *testing.T) {
+			r := NewRecord(1)
+			want := element.Map{
+				KeyType:   element.StringType,
+				ValueType: element.MapType,
+				Data: map[any]any{
+					"a": element.Map{
+						KeyType:   element.Int32Type,
+						ValueType: element.Int32Type,
+						Data:      map[any]any{int32(1): int32(2)},
+					},
+				},
+			}
+			err := r.SetMap(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+
+			checkRecordLength(t, r, 27)
+			checkRecordBytes(
+				t, r, 6, []byte{
+					1, 0, 's' | sortedMapFlag, 'm', 0, 0,
+					1, 0, 'a', 1, 0, 'i' | sortedMapFlag, 'i', 1, 0, 0, 0, 2, 0, 0, 0,
+				},
+			)
+
+			isNull, got, err := r.GetMap(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull || !reflect.DeepEqual(got, want) {
+				t.Errorf("expected %v, got %v", want, got)
+			}
+		},
+	)
+
+	t.Run(
+		"check map with any values", func(t *testing.T) {
+			r := NewRecord(1)
+			want := element.Map{
+				KeyType:   element.StringType,
+				ValueType: element.AnyType,
+				Data: map[any]any{
+					"a": nil,
+					"b": 1.5,
+					"c": element.Map{
+						KeyType:   element.StringType,
+						ValueType: element.AnyType,
+						Data:      map[any]any{"d": "e"},
+					},
+				},
+			}
+			err := r.SetMap(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+
+			isNull, got, err := r.GetMap(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull || !reflect.DeepEqual(got, want) {
+				t.Errorf("expected %v, got %v", want, got)
+			}
+		},
+	)
+
+	t.Run(
+		"check map nested too deeply", func(t *testing.T) {
+			r := NewRecord(1)
+			m := element.Map{
+				KeyType:   element.StringType,
+				ValueType: element.Int32Type,
+				Data:      map[any]any{"a": int32(1)},
+			}
+			for i := 0; i < element.MaxNestingDepth; i++ {
+				m = element.Map{
+					KeyType:   element.StringType,
+					ValueType: element.MapType,
+					Data:      map[any]any{"a": m},
+				}
+			}
+			err := r.SetMap(0, m)
+			if _, ok := err.(*element.NestingDepthError); !ok {
+				t.Errorf("expected NestingDepthError, got %v", err)
+			}
+		},
+	)
+
+	t.Run(
+		"check map encoding is deterministic", func(t *testing.T) {
+			data := map[any]any{}
+			for i := 0; i < 20; i++ {
+				data[string(rune('a'+i))] = int32(i)
+			}
+			r := NewRecord(1)
+			err := r.SetMap(0, element.Map{
+				KeyType:   element.StringType,
+				ValueType: element.Int32Type,
+				Data:      data,
+			})
+			if err != nil {
+				t.Error(err)
+			}
+			checkRecordBytes(t, r, 10, []byte{0, 0, 7, 0, 14, 0})
+			checkRecordBytes(t, r, 50, []byte{1, 0, 'a', 0, 0, 0, 0, 1, 0, 'b', 1, 0, 0, 0})
+
+			for i := 0; i < 10; i++ {
+				other := NewRecord(1)
+				err = other.SetMap(0, element.Map{
+					KeyType:   element.StringType,
+					ValueType: element.Int32Type,
+					Data:      data,
+				})
+				if err != nil {
+					t.Error(err)
+				}
+				if string(*other) != string(*r) {
+					t.Fatalf("expected equal maps to be encoded as equal bytes")
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check map keys are sorted", func(t *testing.T) {
+			r := NewRecord(1)
+			err := r.SetMap(
+				0,
+				element.Map{
+					KeyType:   element.Int16Type,
+					ValueType: element.BoolType,
+					Data:      map[any]any{int16(3): true, int16(-1): false, int16(0): true},
+				},
+			)
+			if err != nil {
+				t.Error(err)
+			}
+			checkRecordBytes(t, r, 10, []byte{255, 255, 0, 0, 0, 1, 3, 0, 1})
+		},
+	)
+
+	t.Run(
+		"check map with different key types", func(t *testing.T) {
+			r := NewRecord(1)
+			err := r.SetMap(
+				0, element.Map{
+					KeyType:   element.StringType,
+					ValueType: element.Int32Type,
+					Data:      map[any]any{"a": 1, 2: 3},
+				},
+			)
+			if err == nil {
+				t.Error("expected error when setting map with different key types")
+			}
+		},
+	)
+
+	t.Run(
+		"check map with different value types", func(t *testing.T) {
+			r := NewRecord(1)
+			err := r.SetMap(
+				0, element.Map{
+					KeyType:   element.StringType,
+					ValueType: element.Int32Type,
+					Data:      map[any]any{"a": 1, "b": "c"},
+				},
+			)
+			if err == nil {
+				t.Error("expected error when setting map with different value types")
+			}
+		},
+	)
+
+	t.Run(
+		"check map with nil value", func(t *testing.T) {
+			r := NewRecord(1)
+			err := r.SetMap(0, element.Map{
+				KeyType:   element.StringType,
+				ValueType: element.Int32Type,
+				Data:      nil,
+			})
+			if err != nil {
+				t.Error(err)
+			}
+
+			checkRecordLength(t, r, 6)
+			checkRecordBytes(t, r, 4, []byte{0, 0})
+		},
+	)
+}
+
+func TestRecord_Compact(t *testing.T) {
+	t.Run(
+		"check elements are laid out in position order", func(t *testing.T) {
+			r := NewRecord(3)
+			err := r.SetString(2, "hello")
+			if err != nil {
+				t.Error(err)
+			}
+			r.SetUint32(0, 10)
+			r.Compact()
+
+			checkRecordLength(t, r, 21)
+			checkRecordBytes(t, r, 0, []byte{21, 0})
+			checkRecordBytes(t, r, 4, []byte{10, 0, 0, 0, 14, 0})
+			checkRecordBytes(t, r, 10, []byte{10, 0, 0, 0})
+			checkRecordBytes(t, r, 14, []byte{5, 0, 104, 101, 108, 108, 111})
+		},
+	)
+
+	t.Run(
+		"check bytes past the record length are dropped", func(t *testing.T) {
+			r := NewRecord(1)
+			r.SetBool(0, true)
+			*r = append(*r, 0, 0, 0)
+			r.Compact()
+
+			checkRecordLength(t, r, 7)
+			checkRecordBytes(t, r, 4, []byte{6, 0, 1})
+		},
+	)
+
+	t.Run(
+		"check values are preserved", func(t *testing.T) {
+			r := NewRecord(4)
+			want := element.Map{
+				KeyType:   element.StringType,
+				ValueType: element.Int32Type,
+				Data:      map[any]any{"a": int32(1)},
+			}
+			err := r.SetMap(3, want)
+			if err != nil {
+				t.Error(err)
+			}
+			err = r.SetString(1, "hello world")
+			if err != nil {
+				t.Error(err)
+			}
+			err = r.SetString(1, "hello")
+			if err != nil {
+				t.Error(err)
+			}
+			r.SetInt64(0, -1)
+			r.Compact()
+
+			checkRecordLength(t, r, int(r.Length()))
+			isNull, gotInt, err := r.GetInt64(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull || gotInt != -1 {
+				t.Errorf("got %v, want %v", gotInt, -1)
+			}
+			isNull, gotString, err := r.GetString(1)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull || gotString != "hello" {
+				t.Errorf("got %v, want %v", gotString, "hello")
+			}
+			isNull, _, err = r.GetBool(2)
+			if err != nil {
+				t.Error(err)
+			}
+			if !isNull {
+				t.Error("expected null value")
+			}
+			isNull, gotMap, err := r.GetMap(3)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull || !reflect.DeepEqual(gotMap, want) {
+				t.Errorf("got %v, want %v", gotMap, want)
+			}
+		},
+	)
+
+	t.Run(
+		"check slack after values is dropped with types", func(t *testing.T) {
+			r := NewRecord(2)
+			if err := r.SetString(0, "hello world"); err != nil {
+				t.Fatal(err)
+			}
+			r.SetUint32(1, 7)
+			// Shrink the string in place, leaving slack after it like older shrinking updates.
+			element.WriteString((*element.Bytes)(r), r.offsetForPosition(0), "hello", r.Encoding())
+			r.Compact()
+			checkRecordLength(t, r, 25)
+
+			types := []element.Type{element.StringType, element.Uint32Type}
+			if err := r.CompactTypes(types); err != nil {
+				t.Fatal(err)
+			}
+			checkRecordLength(t, r, 19)
+			if _, value, _ := r.GetString(0); value != "hello" {
+				t.Errorf("got %v, want %v", value, "hello")
+			}
+			if _, value, _ := r.GetUint32(1); value != 7 {
+				t.Errorf("got %v, want %v", value, 7)
+			}
+		},
+	)
+
+	t.Run(
+		"check values nested to maximum depth", func(t *testing.T) {
+			r := NewRecord(2)
+			a := element.Array{ElementType: element.Int32Type, Values: []any{int32(1)}}
+			m := element.Map{
+				KeyType:   element.StringType,
+				ValueType: element.Int32Type,
+				Data:      map[any]any{"a": int32(1)},
+			}
+			for i := 1; i < element.MaxNestingDepth; i++ {
+				a = element.Array{ElementType: element.ArrayType, Values: []any{a}}
+				m = element.Map{
+					KeyType:   element.StringType,
+					ValueType: element.MapType,
+					Data:      map[any]any{"a": m},
+				}
+			}
+			if err := r.SetArray(0, a); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.SetMap(1, m); err != nil {
+				t.Fatal(err)
+			}
+
+			types := []element.Type{element.ArrayType, element.MapType}
+			if err := r.CompactTypes(types); err != nil {
+				t.Fatal(err)
+			}
+			if _, got, err := r.GetArray(0); err != nil || !reflect.DeepEqual(got, a) {
+				t.Errorf("expected %v, got %v, %v", a, got, err)
+			}
+			if _, got, err := r.GetMap(1); err != nil || !reflect.DeepEqual(got, m) {
+				t.Errorf("expected %v, got %v, %v", m, got, err)
+			}
+		},
+	)
+
+	t.Run(
+		"check values that cannot be skipped", func(t *testing.T) {
+			r := NewRecord(1)
+			r.SetUint32(0, 7)
+			before := append(Record(nil), *r...)
+			if err := r.CompactTypes([]element.Type{'?'}); err == nil {
+				t.Error("expected error for an unknown element type")
+			}
+			if !reflect.DeepEqual(*r, before) {
+				t.Error("expected the record to be unchanged")
+			}
+		},
+	)
+}
+
+func TestRecord_GetUint32(t *testing.T) {
+	t.Run(
+		"check basic get", func(t *testing.T) {
+			r := NewRecord(1)
+			want := uint32(10)
+			r.SetUint32(0, want)
+			isNull, got, err := r.GetUint32(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+		},
+	)
+
+	t.Run(
+		"check two fields", func(t *testing.T) {
+			r := NewRecord(2)
+			want := uint32(10)
+			r.SetUint32(0, want)
+			isNull, got, err := r.GetUint32(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+
+			want = uint32(20)
+			r.SetUint32(1, want)
+			isNull, got, err = r.GetUint32(1)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+		},
+	)
+}
+
+func TestRecord_GetUint64(t *testing.T) {
+	t.Run(
+		"check basic get", func(t *testing.T) {
+			r := NewRecord(1)
+			want := uint64(10)
+			r.SetUint64(0, want)
+			isNull, got, err := r.GetUint64(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+		},
+	)
+
+	t.Run(
+		"check two fields", func(t *testing.T) {
+			r := NewRecord(2)
+			want := uint64(10)
+			r.SetUint64(0, want)
+			isNull, got, err := r.GetUint64(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+
+			want = uint64(20)
+			r.SetUint64(1, want)
+			isNull, got, err = r.GetUint64(1)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+		},
+	)
+}
+
+func TestRecord_GetInt32(t *testing.T) {
+	t.Run(
+		"check basic get", func(t *testing.T) {
+			r := NewRecord(1)
+			want := int32(10)
+			r.SetInt32(0, want)
+			isNull, got, err := r.GetInt32(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+		},
+	)
+
+	t.Run(
+		"check two fields", func(t *testing.T) {
+			r := NewRecord(2)
+			want := int32(10)
+			r.SetInt32(0, want)
+			isNull, got, err := r.GetInt32(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+
+			want = int32(20)
+			r.SetInt32(1, want)
+			isNull, got, err = r.GetInt32(1)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+		},
+	)
+}
+
+func TestRecord_GetInt64(t *testing.T) {
+	t.Run(
+		"check basic get", func(t *testing.T) {
+			r := NewRecord(1)
+			want := int64(10)
+			r.SetInt64(0, want)
+			isNull, got, err := r.GetInt64(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+		},
+	)
+
+	t.Run(
+		"check two fields", func(t *testing.T) {
+			r := NewRecord(2)
+			want := int64(10)
+			r.SetInt64(0, want)
+			isNull, got, err := r.GetInt64(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+
+			want = int64(20)
+			r.SetInt64(1, want)
+			isNull, got, err = r.GetInt64(1)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+		},
+	)
+}
+
+func TestRecord_GetFloat32(t *testing.T) {
+	t.Run(
+		"check basic get", func(t *testing.T) {
+			r := NewRecord(1)
+			want := float32(10.01)
+			r.SetFloat32(0, want)
+			isNull, got, err := r.GetFloat32(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %f, want %f", got, want)
+			}
+		},
+	)
+
+	t.Run(
+		"check two fields", func(t *testing.T) {
+			r := NewRecord(2)
+			want := float32(10.01)
+			r.SetFloat32(0, want)
+			isNull, got, err := r.GetFloat32(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %f, want %f", got, want)
+			}
+
+			want = float32(20.02)
+			r.SetFloat32(1, want)
+			isNull, got, err = r.GetFloat32(1)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %f, want %f", got, want)
+			}
+		},
+	)
+}
+
+func TestRecord_GetFloat64(t *testing.T) {
+	t.Run(
+		"check basic get", func(t *testing.T) {
+			r := NewRecord(1)
+			want := 10.01
+			r.SetFloat64(0, want)
+			isNull, got, err := r.GetFloat64(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %f, want %f", got, want)
+			}
+		},
+	)
+
+	t.Run(
+		"check two fields", func(t *testing.T) {
+			r := NewRecord(2)
+			want := 10.01
+			r.SetFloat64(0, want)
+			isNull, got, err := r.GetFloat64(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %f, want %f", got, want)
+			}
+
+			want = 20.02
+			r.SetFloat64(1, want)
+			isNull, got, err = r.GetFloat64(1)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
 				t.Error("expected non-null value")
 			}
 			if got != want {
-				t.Errorf("got %d, want %d", got, want)
+				t.Errorf("got %f, want %f", got, want)
 			}
+		},
+	)
+}
 
-			want = uint32(20)
-			r.SetUint32(1, want)
-			isNull, got = r.GetUint32(1)
+func TestRecord_GetBool(t *testing.T) {
+	t.Run(
+		"check basic get", func(t *testing.T) {
+			r := NewRecord(1)
+			if err := r.SetBool(0, true); err != nil {
+				t.Fatal(err)
+			}
+			isNull, got, err := r.GetBool(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != true {
+				t.Errorf("got %t, want %t", got, true)
+			}
+		},
+	)
+
+	t.Run(
+		"check two fields", func(t *testing.T) {
+			r := NewRecord(2)
+			if err := r.SetBool(0, true); err != nil {
+				t.Fatal(err)
+			}
+			isNull, got, err := r.GetBool(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != true {
+				t.Errorf("got %t, want %t", got, true)
+			}
+
+			if err := r.SetBool(1, false); err != nil {
+				t.Fatal(err)
+			}
+			isNull, got, err = r.GetBool(1)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != false {
+				t.Errorf("got %t, want %t", got, false)
+			}
+		},
+	)
+}
+
+func TestRecord_GetTime(t *testing.T) {
+	t.Run(
+		"check basic get", func(t *testing.T) {
+			r := NewRecord(1)
+			want := time.Now().AddDate(0, 0, 1)
+			r.SetTime(0, want)
+			isNull, got, err := r.GetTime(0)
+			if err != nil {
+				t.Error(err)
+			}
 			if isNull {
 				t.Error("expected non-null value")
 			}
 			if got != want {
-				t.Errorf("got %d, want %d", got, want)
+				t.Errorf("got %v, want %v", got, want)
+			}
+		},
+	)
+
+	t.Run(
+		"check two fields", func(t *testing.T) {
+			r := NewRecord(2)
+			want := time.Now().AddDate(0, 0, 1)
+			r.SetTime(0, want)
+			isNull, got, err := r.GetTime(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+
+			want = time.Now().AddDate(0, 0, 2)
+			r.SetTime(1, want)
+			isNull, got, err = r.GetTime(1)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %v, want %v", got, want)
 			}
 		},
 	)
 }
 
-func TestRecord_GetUint64(t *testing.T) {
+func TestRecord_GetString(t *testing.T) {
 	t.Run(
 		"check basic get", func(t *testing.T) {
 			r := NewRecord(1)
-			want := uint64(10)
-			r.SetUint64(0, want)
-			isNull, got := r.GetUint64(0)
+			want := "hello"
+			err := r.SetString(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err := r.GetString(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		},
+	)
+
+	t.Run(
+		"check two fields", func(t *testing.T) {
+			r := NewRecord(2)
+			want := "hello"
+			err := r.SetString(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err := r.GetString(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+
+			want = "world"
+			err = r.SetString(1, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err = r.GetString(1)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		},
+	)
+
+	t.Run(
+		"check empty string", func(t *testing.T) {
+			r := NewRecord(1)
+			want := ""
+			err := r.SetString(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err := r.GetString(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		},
+	)
+
+	t.Run(
+		"check string with null byte", func(t *testing.T) {
+			r := NewRecord(1)
+			want := "hello\x00world"
+			err := r.SetString(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err := r.GetString(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		},
+	)
+
+	t.Run(
+		"check emoji", func(t *testing.T) {
+			r := NewRecord(1)
+			want := "👍"
+			err := r.SetString(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err := r.GetString(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		},
+	)
+
+	t.Run(
+		"check get after update", func(t *testing.T) {
+			r := NewRecord(1)
+			want := "hello"
+			err := r.SetString(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err := r.GetString(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+
+			want = "guys"
+			err = r.SetString(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err = r.GetString(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		},
+	)
+}
+
+func TestRecord_GetArray(t *testing.T) {
+	t.Run(
+		"check basic get", func(t *testing.T) {
+			r := NewRecord(1)
+			want := element.Array{ElementType: element.StringType, Values: []any{"hello", "world"}}
+			err := r.SetArray(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err := r.GetArray(0)
+			if err != nil {
+				t.Error(err)
+			}
 			if isNull {
 				t.Error("expected non-null value")
 			}
-			if got != want {
-				t.Errorf("got %d, want %d", got, want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
 			}
 		},
 	)
@@ -931,125 +2624,174 @@ func TestRecord_GetUint64(t *testing.T) {
 	t.Run(
 		"check two fields", func(t *testing.T) {
 			r := NewRecord(2)
-			want := uint64(10)
-			r.SetUint64(0, want)
-			isNull, got := r.GetUint64(0)
+			want := element.Array{ElementType: element.StringType, Values: []any{"hello", "world"}}
+			err := r.SetArray(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err := r.GetArray(0)
+			if err != nil {
+				t.Error(err)
+			}
 			if isNull {
 				t.Error("expected non-null value")
 			}
-			if got != want {
-				t.Errorf("got %d, want %d", got, want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
 			}
 
-			want = uint64(20)
-			r.SetUint64(1, want)
-			isNull, got = r.GetUint64(1)
+			want = element.Array{ElementType: element.StringType, Values: []any{"foo", "bar"}}
+			err = r.SetArray(1, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err = r.GetArray(1)
+			if err != nil {
+				t.Error(err)
+			}
 			if isNull {
 				t.Error("expected non-null value")
 			}
-			if got != want {
-				t.Errorf("got %d, want %d", got, want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
 			}
 		},
 	)
-}
 
-func TestRecord_GetInt32(t *testing.T) {
 	t.Run(
-		"check basic get", func(t *testing.T) {
+		"check empty array", func(t *testing.T) {
 			r := NewRecord(1)
-			want := int32(10)
-			r.SetInt32(0, want)
-			isNull, got := r.GetInt32(0)
+			want := element.Array{ElementType: element.StringType, Values: []any{}}
+			err := r.SetArray(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err := r.GetArray(0)
+			if err != nil {
+				t.Error(err)
+			}
 			if isNull {
 				t.Error("expected non-null value")
 			}
-			if got != want {
-				t.Errorf("got %d, want %d", got, want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
 			}
 		},
 	)
 
 	t.Run(
-		"check two fields", func(t *testing.T) {
-			r := NewRecord(2)
-			want := int32(10)
-			r.SetInt32(0, want)
-			isNull, got := r.GetInt32(0)
-			if isNull {
-				t.Error("expected non-null value")
+		"check array with null byte", func(t *testing.T) {
+			r := NewRecord(1)
+			want := element.Array{
+				ElementType: element.StringType,
+				Values:      []any{"hello\x00world", "foo\x00bar"},
 			}
-			if got != want {
-				t.Errorf("got %d, want %d", got, want)
+			err := r.SetArray(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err := r.GetArray(0)
+			if err != nil {
+				t.Error(err)
 			}
-
-			want = int32(20)
-			r.SetInt32(1, want)
-			isNull, got = r.GetInt32(1)
 			if isNull {
 				t.Error("expected non-null value")
 			}
-			if got != want {
-				t.Errorf("got %d, want %d", got, want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
 			}
 		},
 	)
-}
 
-func TestRecord_GetInt64(t *testing.T) {
 	t.Run(
-		"check basic get", func(t *testing.T) {
+		"check get after update", func(t *testing.T) {
 			r := NewRecord(1)
-			want := int64(10)
-			r.SetInt64(0, want)
-			isNull, got := r.GetInt64(0)
+			want := element.Array{ElementType: element.StringType, Values: []any{"hello", "world"}}
+			err := r.SetArray(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err := r.GetArray(0)
+			if err != nil {
+				t.Error(err)
+			}
 			if isNull {
 				t.Error("expected non-null value")
 			}
-			if got != want {
-				t.Errorf("got %d, want %d", got, want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+
+			want = element.Array{ElementType: element.StringType, Values: []any{"foo", "bar"}}
+			err = r.SetArray(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err = r.GetArray(0)
+			if err != nil {
+				t.Error(err)
+			}
+			if isNull {
+				t.Error("expected non-null value")
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
 			}
 		},
 	)
 
 	t.Run(
-		"check two fields", func(t *testing.T) {
+		"check first array is null but second is not", func(t *testing.T) {
 			r := NewRecord(2)
-			want := int64(10)
-			r.SetInt64(0, want)
-			isNull, got := r.GetInt64(0)
+			want := element.Array{ElementType: element.StringType, Values: []any{"hello", "world"}}
+			err := r.SetArray(1, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err := r.GetArray(1)
+			if err != nil {
+				t.Error(err)
+			}
 			if isNull {
 				t.Error("expected non-null value")
 			}
-			if got != want {
-				t.Errorf("got %d, want %d", got, want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
 			}
 
-			want = int64(20)
-			r.SetInt64(1, want)
-			isNull, got = r.GetInt64(1)
-			if isNull {
-				t.Error("expected non-null value")
+			isNull, got, err = r.GetArray(0)
+			if err != nil {
+				t.Error(err)
 			}
-			if got != want {
-				t.Errorf("got %d, want %d", got, want)
+			if !isNull {
+				t.Errorf("expected null value, but got %v", got)
 			}
 		},
 	)
 }
 
-func TestRecord_GetFloat32(t *testing.T) {
+func TestRecord_GetMap(t *testing.T) {
 	t.Run(
-		"check basic get", func(t *testing.T) {
+		"check single field", func(t *testing.T) {
 			r := NewRecord(1)
-			want := float32(10.01)
-			r.SetFloat32(0, want)
-			isNull, got := r.GetFloat32(0)
+			want := element.Map{
+				KeyType:   element.StringType,
+				ValueType: element.StringType,
+				Data:      map[any]any{"hello": "world"},
+			}
+			err := r.SetMap(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err := r.GetMap(0)
+			if err != nil {
+				t.Error(err)
+			}
 			if isNull {
 				t.Error("expected non-null value")
 			}
-			if got != want {
-				t.Errorf("got %f, want %f", got, want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
 			}
 		},
 	)
@@ -1057,641 +2799,1178 @@ func TestRecord_GetFloat32(t *testing.T) {
 	t.Run(
 		"check two fields", func(t *testing.T) {
 			r := NewRecord(2)
-			want := float32(10.01)
-			r.SetFloat32(0, want)
-			isNull, got := r.GetFloat32(0)
+			want := element.Map{
+				KeyType:   element.StringType,
+				ValueType: element.StringType,
+				Data:      map[any]any{"hello": "world"},
+			}
+			err := r.SetMap(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err := r.GetMap(0)
+			if err != nil {
+				t.Error(err)
+			}
 			if isNull {
 				t.Error("expected non-null value")
 			}
-			if got != want {
-				t.Errorf("got %f, want %f", got, want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
 			}
 
-			want = float32(20.02)
-			r.SetFloat32(1, want)
-			isNull, got = r.GetFloat32(1)
+			want = element.Map{
+				KeyType:   element.StringType,
+				ValueType: element.StringType,
+				Data:      map[any]any{"foo": "bar"},
+			}
+			err = r.SetMap(1, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err = r.GetMap(1)
+			if err != nil {
+				t.Error(err)
+			}
 			if isNull {
 				t.Error("expected non-null value")
 			}
-			if got != want {
-				t.Errorf("got %f, want %f", got, want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
 			}
 		},
 	)
-}
 
-func TestRecord_GetFloat64(t *testing.T) {
 	t.Run(
-		"check basic get", func(t *testing.T) {
+		"check empty map", func(t *testing.T) {
 			r := NewRecord(1)
-			want := 10.01
-			r.SetFloat64(0, want)
-			isNull, got := r.GetFloat64(0)
+			want := element.Map{
+				KeyType:   element.StringType,
+				ValueType: element.StringType,
+				Data:      map[any]any{},
+			}
+			err := r.SetMap(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err := r.GetMap(0)
+			if err != nil {
+				t.Error(err)
+			}
 			if isNull {
 				t.Error("expected non-null value")
 			}
-			if got != want {
-				t.Errorf("got %f, want %f", got, want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
 			}
 		},
 	)
 
 	t.Run(
-		"check two fields", func(t *testing.T) {
-			r := NewRecord(2)
-			want := 10.01
-			r.SetFloat64(0, want)
-			isNull, got := r.GetFloat64(0)
-			if isNull {
-				t.Error("expected non-null value")
+		"check get after update", func(t *testing.T) {
+			r := NewRecord(1)
+			want := element.Map{
+				KeyType:   element.StringType,
+				ValueType: element.StringType,
+				Data:      map[any]any{"hello": "world"},
 			}
-			if got != want {
-				t.Errorf("got %f, want %f", got, want)
+			err := r.SetMap(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err := r.GetMap(0)
+			if err != nil {
+				t.Error(err)
 			}
-
-			want = 20.02
-			r.SetFloat64(1, want)
-			isNull, got = r.GetFloat64(1)
 			if isNull {
 				t.Error("expected non-null value")
 			}
-			if got != want {
-				t.Errorf("got %f, want %f", got, want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
 			}
-		},
-	)
-}
 
-func TestRecord_GetBool(t *testing.T) {
-	t.Run(
-		"check basic get", func(t *testing.T) {
-			r := NewRecord(1)
-			r.SetBool(0, true)
-			isNull, got := r.GetBool(0)
+			want = element.Map{
+				KeyType:   element.StringType,
+				ValueType: element.StringType,
+				Data:      map[any]any{"foo": "bar"},
+			}
+			err = r.SetMap(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err = r.GetMap(0)
+			if err != nil {
+				t.Error(err)
+			}
 			if isNull {
 				t.Error("expected non-null value")
 			}
-			if got != true {
-				t.Errorf("got %t, want %t", got, true)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
 			}
 		},
 	)
 
 	t.Run(
-		"check two fields", func(t *testing.T) {
+		"check first map is null but second is not", func(t *testing.T) {
 			r := NewRecord(2)
-			r.SetBool(0, true)
-			isNull, got := r.GetBool(0)
+			want := element.Map{
+				KeyType:   element.StringType,
+				ValueType: element.StringType,
+				Data:      map[any]any{"hello": "world"},
+			}
+			err := r.SetMap(1, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err := r.GetMap(1)
+			if err != nil {
+				t.Error(err)
+			}
 			if isNull {
 				t.Error("expected non-null value")
 			}
-			if got != true {
-				t.Errorf("got %t, want %t", got, true)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
 			}
 
-			r.SetBool(1, false)
-			isNull, got = r.GetBool(1)
-			if isNull {
-				t.Error("expected non-null value")
+			isNull, got, err = r.GetMap(0)
+			if err != nil {
+				t.Error(err)
 			}
-			if got != false {
-				t.Errorf("got %t, want %t", got, false)
+			if !isNull {
+				t.Errorf("expected null value, but got %v", got)
 			}
 		},
 	)
-}
 
-func TestRecord_GetTime(t *testing.T) {
 	t.Run(
-		"check basic get", func(t *testing.T) {
+		"check map with array values", func(t *testing.T) {
 			r := NewRecord(1)
-			want := time.Now().AddDate(0, 0, 1)
-			r.SetTime(0, want)
-			isNull, got := r.GetTime(0)
+			want := element.Map{
+				KeyType:   element.StringType,
+				ValueType: element.ArrayType,
+				Data: map[any]any{
+					"hello": element.Array{
+						ElementType: element.StringType,
+						Values:      []any{"world", "foo", "bar"},
+					},
+				},
+			}
+			err := r.SetMap(0, want)
+			if err != nil {
+				t.Error(err)
+			}
+			isNull, got, err := r.GetMap(0)
+			if err != nil {
+				t.Error(err)
+			}
 			if isNull {
 				t.Error("expected non-null value")
 			}
-			if got != want {
+			if !reflect.DeepEqual(got, want) {
 				t.Errorf("got %v, want %v", got, want)
 			}
 		},
 	)
+}
 
+func TestRecord_GetMapValue(t *testing.T) {
 	t.Run(
-		"check two fields", func(t *testing.T) {
-			r := NewRecord(2)
-			want := time.Now().AddDate(0, 0, 1)
-			r.SetTime(0, want)
-			isNull, got := r.GetTime(0)
-			if isNull {
-				t.Error("expected non-null value")
+		"check fixed size entries", func(t *testing.T) {
+			r := NewRecord(1)
+			data := map[any]any{}
+			for i := int32(-50); i < 50; i++ {
+				data[i] = float64(i) / 2
 			}
-			if got != want {
-				t.Errorf("got %v, want %v", got, want)
+			err := r.SetMap(0, element.Map{
+				KeyType:   element.Int32Type,
+				ValueType: element.Float64Type,
+				Data:      data,
+			})
+			if err != nil {
+				t.Error(err)
 			}
 
-			want = time.Now().AddDate(0, 0, 2)
-			r.SetTime(1, want)
-			isNull, got = r.GetTime(1)
-			if isNull {
-				t.Error("expected non-null value")
+			for key, want := range data {
+				found, got, err := r.GetMapValue(0, key)
+				if err != nil {
+					t.Error(err)
+				}
+				if !found || got != want {
+					t.Errorf("expected %v for key %v, got %v", want, key, got)
+				}
 			}
-			if got != want {
-				t.Errorf("got %v, want %v", got, want)
+			found, _, err := r.GetMapValue(0, int32(50))
+			if err != nil {
+				t.Error(err)
+			}
+			if found {
+				t.Error("expected missing key not to be found")
 			}
 		},
 	)
-}
 
-func TestRecord_GetString(t *testing.T) {
 	t.Run(
-		"check basic get", func(t *testing.T) {
-			r := NewRecord(1)
-			want := "hello"
-			err := r.SetString(0, want)
+		"check variable size entries", func(t *testing.T) {
+			r := NewRecordWithEncoding(1, element.CompactEncoding)
+			want := element.Array{
+				ElementType: element.Int64Type,
+				Values:      []any{int64(1), int64(1000)},
+			}
+			err := r.SetMap(
+				0,
+				element.Map{
+					KeyType:   element.StringType,
+					ValueType: element.AnyType,
+					Data: map[any]any{
+						"a": "apple",
+						"b": element.Map{
+							KeyType:   element.Int32Type,
+							ValueType: element.StringType,
+							Data:      map[any]any{int32(1): "x"},
+						},
+						"c": want,
+						"e": nil,
+					},
+				},
+			)
 			if err != nil {
 				t.Error(err)
 			}
-			isNull, got := r.GetString(0)
-			if isNull {
-				t.Error("expected non-null value")
+
+			found, got, err := r.GetMapValue(0, "c")
+			if err != nil {
+				t.Error(err)
 			}
-			if got != want {
-				t.Errorf("got %v, want %v", got, want)
+			if !found || !reflect.DeepEqual(got, want) {
+				t.Errorf("expected %v, got %v", want, got)
+			}
+			found, got, err = r.GetMapValue(0, "e")
+			if err != nil {
+				t.Error(err)
+			}
+			if !found || got != nil {
+				t.Errorf("expected nil value, got %v", got)
+			}
+			found, _, err = r.GetMapValue(0, "d")
+			if err != nil {
+				t.Error(err)
+			}
+			if found {
+				t.Error("expected missing key not to be found")
 			}
 		},
 	)
 
 	t.Run(
-		"check two fields", func(t *testing.T) {
-			r := NewRecord(2)
-			want := "hello"
-			err := r.SetString(0, want)
+		"check any keys", func(t *testing.T) {
+			r := NewRecord(1)
+			err := r.SetMap(
+				0,
+				element.Map{
+					KeyType:   element.AnyType,
+					ValueType: element.Int32Type,
+					Data:      map[any]any{"a": int32(1), int32(2): int32(2), true: int32(3)},
+				},
+			)
 			if err != nil {
 				t.Error(err)
 			}
-			isNull, got := r.GetString(0)
-			if isNull {
-				t.Error("expected non-null value")
+
+			found, got, err := r.GetMapValue(0, true)
+			if err != nil {
+				t.Error(err)
 			}
-			if got != want {
-				t.Errorf("got %v, want %v", got, want)
+			if !found || got != int32(3) {
+				t.Errorf("expected 3, got %v", got)
 			}
+		},
+	)
 
-			want = "world"
-			err = r.SetString(1, want)
+	t.Run(
+		"check null map", func(t *testing.T) {
+			r := NewRecord(1)
+			found, _, err := r.GetMapValue(0, "a")
 			if err != nil {
 				t.Error(err)
 			}
-			isNull, got = r.GetString(1)
-			if isNull {
-				t.Error("expected non-null value")
-			}
-			if got != want {
-				t.Errorf("got %v, want %v", got, want)
+			if found {
+				t.Error("expected key of null map not to be found")
 			}
 		},
 	)
 
 	t.Run(
-		"check empty string", func(t *testing.T) {
+		"check key type mismatch", func(t *testing.T) {
 			r := NewRecord(1)
-			want := ""
-			err := r.SetString(0, want)
+			err := r.SetMap(
+				0, element.Map{
+					KeyType:   element.StringType,
+					ValueType: element.StringType,
+					Data:      map[any]any{"a": "b"},
+				},
+			)
 			if err != nil {
 				t.Error(err)
 			}
-			isNull, got := r.GetString(0)
-			if isNull {
-				t.Error("expected non-null value")
-			}
-			if got != want {
-				t.Errorf("got %v, want %v", got, want)
+			_, _, err = r.GetMapValue(0, int32(1))
+			if _, ok := err.(*element.TypeMismatchError); !ok {
+				t.Errorf("expected TypeMismatchError, got %v", err)
 			}
 		},
 	)
+}
 
+func TestRecord_GetArrayView(t *testing.T) {
 	t.Run(
-		"check string with null byte", func(t *testing.T) {
+		"check null array", func(t *testing.T) {
 			r := NewRecord(1)
-			want := "hello\x00world"
-			err := r.SetString(0, want)
+			isNull, _, err := r.GetArrayView(0)
 			if err != nil {
 				t.Error(err)
 			}
-			isNull, got := r.GetString(0)
-			if isNull {
-				t.Error("expected non-null value")
-			}
-			if got != want {
-				t.Errorf("got %v, want %v", got, want)
+			if !isNull {
+				t.Error("expected null value")
 			}
 		},
 	)
 
 	t.Run(
-		"check emoji", func(t *testing.T) {
+		"check fixed size values", func(t *testing.T) {
 			r := NewRecord(1)
-			want := "👍"
-			err := r.SetString(0, want)
+			err := r.SetArray(
+				0, element.Array{
+					ElementType: element.Int64Type,
+					Values:      []any{int64(1), int64(-2), int64(3)},
+				},
+			)
 			if err != nil {
 				t.Error(err)
 			}
-			isNull, got := r.GetString(0)
-			if isNull {
-				t.Error("expected non-null value")
+
+			_, view, err := r.GetArrayView(0)
+			if err != nil {
+				t.Error(err)
 			}
-			if got != want {
-				t.Errorf("got %v, want %v", got, want)
+			if view.Len() != 3 || view.ElementType() != element.Int64Type {
+				t.Errorf(
+					"expected 3 int64 values, got %d of type %c", view.Len(), view.ElementType(),
+				)
+			}
+			got, err := view.Int64At(1)
+			if err != nil {
+				t.Error(err)
+			}
+			if got != -2 {
+				t.Errorf("expected -2, got %d", got)
+			}
+			value, err := view.At(2)
+			if err != nil {
+				t.Error(err)
+			}
+			if value != int64(3) {
+				t.Errorf("expected 3, got %v", value)
+			}
+			_, err = view.Int64At(3)
+			if _, ok := err.(*element.IndexOutOfRangeError); !ok {
+				t.Errorf("expected IndexOutOfRangeError, got %v", err)
+			}
+			_, err = view.Int32At(0)
+			if _, ok := err.(*element.TypeMismatchError); !ok {
+				t.Errorf("expected TypeMismatchError, got %v", err)
 			}
 		},
 	)
 
 	t.Run(
-		"check get after update", func(t *testing.T) {
-			r := NewRecord(1)
-			want := "hello"
-			err := r.SetString(0, want)
+		"check variable size values", func(t *testing.T) {
+			r := NewRecordWithEncoding(1, element.CompactEncoding)
+			err := r.SetArray(
+				0, element.Array{
+					ElementType: element.AnyType,
+					Values: []any{
+						"a", int32(300), nil,
+						element.Array{ElementType: element.StringType, Values: []any{"b", "c"}},
+						element.Map{
+							KeyType:   element.StringType,
+							ValueType: element.Int32Type,
+							Data:      map[any]any{"d": int32(4)},
+						},
+					},
+				},
+			)
 			if err != nil {
 				t.Error(err)
 			}
-			isNull, got := r.GetString(0)
-			if isNull {
-				t.Error("expected non-null value")
+
+			_, view, err := r.GetArrayView(0)
+			if err != nil {
+				t.Error(err)
 			}
-			if got != want {
-				t.Errorf("got %v, want %v", got, want)
+			got, err := view.Int32At(1)
+			if err != nil {
+				t.Error(err)
+			}
+			if got != 300 {
+				t.Errorf("expected 300, got %d", got)
 			}
+			value, err := view.At(2)
+			if err != nil {
+				t.Error(err)
+			}
+			if value != nil {
+				t.Errorf("expected nil, got %v", value)
+			}
+			nested, err := view.ArrayAt(3)
+			if err != nil {
+				t.Error(err)
+			}
+			str, err := nested.StringAt(1)
+			if err != nil {
+				t.Error(err)
+			}
+			if str != "c" {
+				t.Errorf("expected c, got %s", str)
+			}
+			nestedMap, err := view.MapAt(4)
+			if err != nil {
+				t.Error(err)
+			}
+			value, found, err := nestedMap.Get("d")
+			if err != nil {
+				t.Error(err)
+			}
+			if !found || value != int32(4) {
+				t.Errorf("expected 4, got %v", value)
+			}
+		},
+	)
 
-			want = "guys"
-			err = r.SetString(0, want)
+	t.Run(
+		"check time values", func(t *testing.T) {
+			r := NewRecordWithEncoding(1, element.CompactEncoding)
+			times := []any{time.Unix(100, 0), time.Unix(200, 5), time.Unix(150, 0)}
+			err := r.SetArray(0, element.Array{ElementType: element.TimeType, Values: times})
 			if err != nil {
 				t.Error(err)
 			}
-			isNull, got = r.GetString(0)
-			if isNull {
-				t.Error("expected non-null value")
+
+			_, view, err := r.GetArrayView(0)
+			if err != nil {
+				t.Error(err)
 			}
-			if got != want {
-				t.Errorf("got %v, want %v", got, want)
+			got, err := view.TimeAt(2)
+			if err != nil {
+				t.Error(err)
+			}
+			if !got.Equal(times[2].(time.Time)) {
+				t.Errorf("expected %v, got %v", times[2], got)
 			}
 		},
 	)
-}
 
-func TestRecord_GetArray(t *testing.T) {
 	t.Run(
-		"check basic get", func(t *testing.T) {
+		"check range", func(t *testing.T) {
 			r := NewRecord(1)
-			want := element.Array{element.StringType, []any{"hello", "world"}}
-			err := r.SetArray(0, want)
+			want := []any{"a", "b", "c"}
+			err := r.SetArray(0, element.Array{ElementType: element.StringType, Values: want})
 			if err != nil {
 				t.Error(err)
 			}
-			isNull, got, err := r.GetArray(0)
+
+			_, view, err := r.GetArrayView(0)
 			if err != nil {
 				t.Error(err)
 			}
-			if isNull {
-				t.Error("expected non-null value")
+			var got []any
+			err = view.Range(
+				func(i int, value element.ValueView) bool {
+					str, err := value.String()
+					if err != nil {
+						t.Error(err)
+					}
+					got = append(got, str)
+					return i < 1
+				},
+			)
+			if err != nil {
+				t.Error(err)
 			}
-			if !reflect.DeepEqual(got, want) {
-				t.Errorf("got %v, want %v", got, want)
+			if !reflect.DeepEqual(got, want[:2]) {
+				t.Errorf("expected %v, got %v", want[:2], got)
 			}
 		},
 	)
 
 	t.Run(
-		"check two fields", func(t *testing.T) {
-			r := NewRecord(2)
-			want := element.Array{element.StringType, []any{"hello", "world"}}
-			err := r.SetArray(0, want)
+		"check iterator", func(t *testing.T) {
+			r := NewRecordWithEncoding(1, element.CompactEncoding)
+			times := []any{time.Unix(100, 0), time.Unix(200, 5), time.Unix(150, 0)}
+			err := r.SetArray(
+				0, element.Array{
+					ElementType: element.AnyType,
+					Values: []any{
+						element.Array{ElementType: element.TimeType, Values: times}, nil, int64(-7),
+					},
+				},
+			)
 			if err != nil {
 				t.Error(err)
 			}
-			isNull, got, err := r.GetArray(0)
+
+			_, view, err := r.GetArrayView(0)
 			if err != nil {
 				t.Error(err)
 			}
-			if isNull {
-				t.Error("expected non-null value")
+			it := view.Iterator()
+			_, value, err := it.Next()
+			if err != nil {
+				t.Fatal(err)
 			}
-			if !reflect.DeepEqual(got, want) {
-				t.Errorf("got %v, want %v", got, want)
+			nested, err := value.Array()
+			if err != nil {
+				t.Fatal(err)
+			}
+			nestedIt := nested.Iterator()
+			for i := range times {
+				j, value, err := nestedIt.Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := value.Time()
+				if err != nil {
+					t.Error(err)
+				}
+				if j != i || !got.Equal(times[i].(time.Time)) {
+					t.Errorf("expected %v at %d, got %v at %d", times[i], i, got, j)
+				}
+			}
+			if _, _, err = nestedIt.Next(); err != io.EOF {
+				t.Errorf("expected io.EOF, got %v", err)
 			}
 
-			want = element.Array{element.StringType, []any{"foo", "bar"}}
-			err = r.SetArray(1, want)
-			if err != nil {
-				t.Error(err)
+			_, value, err = it.Next()
+			if err != nil || value.Type() != element.NullType {
+				t.Errorf("expected null value, got type %c, error %v", value.Type(), err)
 			}
-			isNull, got, err = r.GetArray(1)
+			i, value, err := it.Next()
 			if err != nil {
-				t.Error(err)
+				t.Fatal(err)
 			}
-			if isNull {
-				t.Error("expected non-null value")
+			if got, err := value.Int64(); i != 2 || got != -7 || err != nil {
+				t.Errorf("expected -7 at 2, got %v at %d, error %v", got, i, err)
 			}
-			if !reflect.DeepEqual(got, want) {
-				t.Errorf("got %v, want %v", got, want)
+			if _, _, err = it.Next(); err != io.EOF {
+				t.Errorf("expected io.EOF, got %v", err)
 			}
 		},
 	)
 
 	t.Run(
-		"check empty array", func(t *testing.T) {
-			r := NewRecord(1)
-			want := element.Array{element.StringType, []any{}}
-			err := r.SetArray(0, want)
+		"check corrupt varint", func(t *testing.T) {
+			r := NewRecordWithEncoding(1, element.CompactEncoding)
+			err := r.SetArray(
+				0, element.Array{ElementType: element.Int32Type, Values: []any{int32(1)}},
+			)
 			if err != nil {
 				t.Error(err)
 			}
-			isNull, got, err := r.GetArray(0)
+			_, view, err := r.GetArrayView(0)
 			if err != nil {
 				t.Error(err)
 			}
-			if isNull {
-				t.Error("expected non-null value")
+
+			// A varint that does not end before the end of the bytes is truncated.
+			(*r)[len(*r)-1] = 0x80
+			_, err = view.Int32At(0)
+			if _, ok := err.(*element.CorruptRecordError); !ok {
+				t.Errorf("expected CorruptRecordError, got %v", err)
 			}
-			if !reflect.DeepEqual(got, want) {
-				t.Errorf("got %v, want %v", got, want)
+			// A complete varint too large for an int32 is out of range.
+			*r = append(*r, 0x80, 0x80, 0x80, 0x80, 0x7f)
+			r.setLength(uint32(len(*r)))
+			_, view, _ = r.GetArrayView(0)
+			_, err = view.Int32At(0)
+			if _, ok := err.(*element.CorruptRecordError); !ok {
+				t.Errorf("expected CorruptRecordError, got %v", err)
 			}
 		},
 	)
 
 	t.Run(
-		"check array with null byte", func(t *testing.T) {
+		"check typed access does not allocate", func(t *testing.T) {
 			r := NewRecord(1)
-			want := element.Array{element.StringType, []any{"hello\x00world", "foo\x00bar"}}
-			err := r.SetArray(0, want)
+			err := r.SetArray(0, element.Array{
+				ElementType: element.Float64Type,
+				Values:      []any{1.5, 2.5, 3.5},
+			})
 			if err != nil {
 				t.Error(err)
 			}
-			isNull, got, err := r.GetArray(0)
+
+			allocs := testing.AllocsPerRun(
+				100, func() {
+					_, view, _ := r.GetArrayView(0)
+					_, _ = view.Float64At(2)
+				},
+			)
+			if allocs != 0 {
+				t.Errorf("expected no allocations, got %v", allocs)
+			}
+		},
+	)
+}
+
+func TestRecord_GetMapView(t *testing.T) {
+	t.Run(
+		"check null map", func(t *testing.T) {
+			r := NewRecord(1)
+			isNull, _, err := r.GetMapView(0)
 			if err != nil {
 				t.Error(err)
 			}
-			if isNull {
-				t.Error("expected non-null value")
-			}
-			if !reflect.DeepEqual(got, want) {
-				t.Errorf("got %v, want %v", got, want)
+			if !isNull {
+				t.Error("expected null value")
 			}
 		},
 	)
 
 	t.Run(
-		"check get after update", func(t *testing.T) {
+		"check nested maps", func(t *testing.T) {
 			r := NewRecord(1)
-			want := element.Array{element.StringType, []any{"hello", "world"}}
-			err := r.SetArray(0, want)
+			err := r.SetMap(
+				0, element.Map{
+					KeyType:   element.StringType,
+					ValueType: element.MapType,
+					Data: map[any]any{
+						"a": element.Map{
+							KeyType:   element.StringType,
+							ValueType: element.ArrayType,
+							Data: map[any]any{"b": element.Array{
+								ElementType: element.BoolType,
+								Values:      []any{false, true},
+							}},
+						},
+					},
+				},
+			)
 			if err != nil {
 				t.Error(err)
 			}
-			isNull, got, err := r.GetArray(0)
+
+			_, view, err := r.GetMapView(0)
 			if err != nil {
 				t.Error(err)
 			}
-			if isNull {
-				t.Error("expected non-null value")
+			if view.Len() != 1 || view.KeyType() != element.StringType ||
+				view.ValueType() != element.MapType {
+				t.Errorf("unexpected map view %v", view)
 			}
-			if !reflect.DeepEqual(got, want) {
-				t.Errorf("got %v, want %v", got, want)
+			inner, found, err := view.GetMap("a")
+			if err != nil || !found {
+				t.Errorf("expected inner map, got error %v", err)
 			}
-
-			want = element.Array{element.StringType, []any{"foo", "bar"}}
-			err = r.SetArray(0, want)
-			if err != nil {
-				t.Error(err)
+			array, found, err := inner.GetArray("b")
+			if err != nil || !found {
+				t.Errorf("expected array, got error %v", err)
 			}
-			isNull, got, err = r.GetArray(0)
+			got, err := array.BoolAt(1)
 			if err != nil {
 				t.Error(err)
 			}
-			if isNull {
-				t.Error("expected non-null value")
+			if !got {
+				t.Error("expected true")
 			}
-			if !reflect.DeepEqual(got, want) {
-				t.Errorf("got %v, want %v", got, want)
+			_, found, err = inner.GetArray("c")
+			if err != nil || found {
+				t.Errorf("expected missing key not to be found, got error %v", err)
 			}
 		},
 	)
 
 	t.Run(
-		"check first array is null but second is not", func(t *testing.T) {
-			r := NewRecord(2)
-			want := element.Array{element.StringType, []any{"hello", "world"}}
-			err := r.SetArray(1, want)
+		"check range", func(t *testing.T) {
+			r := NewRecord(1)
+			want := map[any]any{int32(3): "c", int32(1): "a", int32(2): "b"}
+			err := r.SetMap(0, element.Map{
+				KeyType:   element.Int32Type,
+				ValueType: element.StringType,
+				Data:      want,
+			})
 			if err != nil {
 				t.Error(err)
 			}
-			isNull, got, err := r.GetArray(1)
+
+			_, view, err := r.GetMapView(0)
 			if err != nil {
 				t.Error(err)
 			}
-			if isNull {
-				t.Error("expected non-null value")
-			}
-			if !reflect.DeepEqual(got, want) {
-				t.Errorf("got %v, want %v", got, want)
-			}
-
-			isNull, got, err = r.GetArray(0)
+			var keys []any
+			got := map[any]any{}
+			err = view.Range(
+				func(keyView, valueView element.ValueView) bool {
+					key, err := keyView.Int32()
+					if err != nil {
+						t.Error(err)
+					}
+					value, err := valueView.String()
+					if err != nil {
+						t.Error(err)
+					}
+					keys = append(keys, key)
+					got[key] = value
+					return true
+				},
+			)
 			if err != nil {
 				t.Error(err)
 			}
-			if !isNull {
-				t.Errorf("expected null value, but got %v", got)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("expected %v, got %v", want, got)
+			}
+			if !reflect.DeepEqual(keys, []any{int32(1), int32(2), int32(3)}) {
+				t.Errorf("expected keys in order, got %v", keys)
 			}
 		},
 	)
 }
 
-func TestRecord_GetMap(t *testing.T) {
+func BenchmarkRecord_Encoding(b *testing.B) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	times := make([]any, 32)
+	for i := range times {
+		times[i] = start.Add(time.Duration(i) * time.Second)
+	}
+	encodings := map[string]element.Encoding{
+		"legacy":  element.LegacyEncoding,
+		"compact": element.CompactEncoding,
+	}
+	for name, encoding := range encodings {
+		b.Run(
+			name, func(b *testing.B) {
+				var r *Record
+				for i := 0; i < b.N; i++ {
+					r = NewRecordWithEncoding(5, encoding)
+					errs := []error{
+						r.SetUint64(0, uint64(i%1000)),
+						r.SetInt64(1, -int64(i%100)),
+						r.SetInt32(2, int32(i%10)),
+						r.SetTime(3, start),
+						r.SetArray(4, element.Array{ElementType: element.TimeType, Values: times}),
+					}
+					for _, err := range errs {
+						if err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+				b.ReportMetric(float64(r.Length()), "bytes/record")
+			},
+		)
+	}
+}
+
+func TestNewRecordWithNullBitmap(t *testing.T) {
 	t.Run(
-		"check single field", func(t *testing.T) {
-			r := NewRecord(1)
-			want := element.Map{
-				element.StringType,
-				element.StringType,
-				map[any]any{"hello": "world"},
-			}
-			err := r.SetMap(0, want)
-			if err != nil {
-				t.Error(err)
+		"check legacy encoding", func(t *testing.T) {
+			r := NewRecordWithNullBitmap(4, element.LegacyEncoding)
+
+			checkRecordLength(t, r, 11)
+			checkRecordBytes(t, r, 0, []byte{0, 0, nullBitmapLayout, 11, 0, 6, 0, 4, 0, 0, 0})
+			if r.Encoding() != element.LegacyEncoding {
+				t.Errorf("expected encoding %d, got %d", element.LegacyEncoding, r.Encoding())
 			}
-			isNull, got, err := r.GetMap(0)
-			if err != nil {
+			if err := r.Validate(); err != nil {
 				t.Error(err)
 			}
-			if isNull {
-				t.Error("expected non-null value")
-			}
-			if !reflect.DeepEqual(got, want) {
-				t.Errorf("got %v, want %v", got, want)
+		},
+	)
+
+	t.Run(
+		"check wide encoding", func(t *testing.T) {
+			r := NewRecordWithNullBitmap(9, element.WideEncoding)
+
+			checkRecordLength(t, r, 19)
+			checkRecordBytes(t, r, 0, []byte{0, 0, nullBitmapLayout | element.WideEncoding})
+			checkRecordBytes(t, r, 3, []byte{19, 0, 0, 0, 12, 0, 0, 0, 9, 0, 0, 0, 0, 0, 0, 0})
+			if r.Encoding() != element.WideEncoding {
+				t.Errorf("expected encoding %d, got %d", element.WideEncoding, r.Encoding())
 			}
 		},
 	)
 
 	t.Run(
-		"check two fields", func(t *testing.T) {
-			r := NewRecord(2)
-			want := element.Map{
-				element.StringType,
-				element.StringType,
-				map[any]any{"hello": "world"},
+		"check offsets are stored for values only", func(t *testing.T) {
+			r := NewRecordWithNullBitmap(4, element.LegacyEncoding)
+			r.SetInt32(1, 7)
+			checkRecordLength(t, r, 17)
+			checkRecordBytes(t, r, 3, []byte{17, 0, 8, 0, 4, 0, 0, 2, 13, 0, 7, 0, 0, 0})
+
+			if err := r.SetNull(2); err != nil {
+				t.Fatal(err)
 			}
-			err := r.SetMap(0, want)
-			if err != nil {
-				t.Error(err)
+			if err := r.SetString(0, "hi"); err != nil {
+				t.Fatal(err)
 			}
-			isNull, got, err := r.GetMap(0)
-			if err != nil {
+			checkRecordLength(t, r, 23)
+			checkRecordBytes(t, r, 3, []byte{23, 0, 10, 0, 4, 0, 4, 3, 19, 0, 15, 0})
+			checkRecordBytes(t, r, 15, []byte{7, 0, 0, 0, 2, 0, 104, 105})
+
+			if err := r.SetNull(1); err != nil {
+				t.Fatal(err)
+			}
+			checkRecordLength(t, r, 17)
+			checkRecordBytes(t, r, 3, []byte{17, 0, 8, 0, 4, 0, 6, 1, 13, 0, 2, 0, 104, 105})
+			if err := r.Validate(); err != nil {
 				t.Error(err)
 			}
-			if isNull {
-				t.Error("expected non-null value")
+		},
+	)
+
+	t.Run(
+		"check values across bitmap bytes", func(t *testing.T) {
+			r := NewRecordWithNullBitmap(20, element.WideEncoding|element.CompactEncoding)
+			for position := ElementPosition(19); position < 20; position-- {
+				switch position % 3 {
+				case 0:
+					r.SetInt64(position, int64(position)*1000)
+				case 1:
+					err := r.SetString(position, strings.Repeat("x", int(position)))
+					if err != nil {
+						t.Fatal(err)
+					}
+				default:
+					if err := r.SetNull(position); err != nil {
+						t.Fatal(err)
+					}
+				}
 			}
-			if !reflect.DeepEqual(got, want) {
-				t.Errorf("got %v, want %v", got, want)
+			r.Compact()
+			if err := r.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			for position := ElementPosition(0); position < 20; position++ {
+				switch position % 3 {
+				case 0:
+					isNull, got, err := r.GetInt64(position)
+					if err != nil || isNull || got != int64(position)*1000 {
+						t.Errorf("%d: got %v, %v, %v", position, isNull, got, err)
+					}
+				case 1:
+					isNull, got, err := r.GetString(position)
+					if err != nil || isNull || got != strings.Repeat("x", int(position)) {
+						t.Errorf("%d: got %v, %q, %v", position, isNull, got, err)
+					}
+				default:
+					isNull, _, err := r.GetString(position)
+					if err != nil || !isNull {
+						t.Errorf("%d: expected null, got %v, %v", position, isNull, err)
+					}
+				}
 			}
+		},
+	)
 
-			want = element.Map{element.StringType, element.StringType, map[any]any{"foo": "bar"}}
-			err = r.SetMap(1, want)
+	t.Run(
+		"check table page round trip", func(t *testing.T) {
+			r := NewRecordWithNullBitmap(3, element.LegacyEncoding)
+			if err := r.SetString(2, "abc"); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.SetNull(0); err != nil {
+				t.Fatal(err)
+			}
+			p := NewTablePage()
+			slotNum, err := p.AddRecord(r)
 			if err != nil {
-				t.Error(err)
+				t.Fatal(err)
 			}
-			isNull, got, err = r.GetMap(1)
+			got, _, err := p.GetRecord(slotNum)
 			if err != nil {
-				t.Error(err)
+				t.Fatal(err)
 			}
-			if isNull {
-				t.Error("expected non-null value")
+			for position, want := range []bool{true, false, true} {
+				isSet, err := got.IsSet(ElementPosition(position))
+				if err != nil || isSet != want {
+					t.Errorf("%d: expected %v, got %v, %v", position, want, isSet, err)
+				}
 			}
-			if !reflect.DeepEqual(got, want) {
-				t.Errorf("got %v, want %v", got, want)
+			if _, value, err := got.GetString(2); err != nil || value != "abc" {
+				t.Errorf("expected abc, got %q, %v", value, err)
 			}
 		},
 	)
 
 	t.Run(
-		"check empty map", func(t *testing.T) {
-			r := NewRecord(1)
-			want := element.Map{element.StringType, element.StringType, map[any]any{}}
-			err := r.SetMap(0, want)
-			if err != nil {
-				t.Error(err)
+		"check corrupt bitmaps", func(t *testing.T) {
+			for name, corrupt := range map[string]func(r Record){
+				"null and value bit":      func(r Record) { r[9] = 2 },
+				"bit past last position":  func(r Record) { r[9] = 0x10 },
+				"missing offset":          func(r Record) { r[10] = 3 },
+				"header length too short": func(r Record) { r[5] = 4 },
+				"too many elements":       func(r Record) { r[7] = 0xff },
+				"zero offset for value":   func(r Record) { r[11] = 0 },
+			} {
+				r := NewRecordWithNullBitmap(4, element.LegacyEncoding)
+				r.SetInt32(1, 7)
+				corrupt(*r)
+				err := r.Validate()
+				if _, ok := err.(*CorruptRecordError); !ok {
+					t.Errorf("%s: expected CorruptRecordError, got %v", name, err)
+				}
 			}
-			isNull, got, err := r.GetMap(0)
-			if err != nil {
-				t.Error(err)
+		},
+	)
+}
+
+func TestRecord_SetNull(t *testing.T) {
+	t.Run(
+		"check untagged record", func(t *testing.T) {
+			r := NewRecord(2)
+			if err := r.SetString(0, "abc"); err != nil {
+				t.Fatal(err)
 			}
-			if isNull {
-				t.Error("expected non-null value")
+			r.SetInt32(1, 5)
+			if err := r.SetNull(0); err != nil {
+				t.Fatal(err)
 			}
-			if !reflect.DeepEqual(got, want) {
-				t.Errorf("got %v, want %v", got, want)
+
+			checkRecordLength(t, r, 12)
+			checkRecordBytes(t, r, 0, []byte{12, 0, 6, 0, 0, 0, 8, 0, 5, 0, 0, 0})
+			isNull, _, err := r.GetString(0)
+			if err != nil || !isNull {
+				t.Errorf("expected null, got %v, %v", isNull, err)
+			}
+			isSet, err := r.IsSet(0)
+			if err != nil || isSet {
+				t.Errorf("expected position to be unset, got %v, %v", isSet, err)
+			}
+			if _, value, _ := r.GetInt32(1); value != 5 {
+				t.Errorf("expected 5, got %d", value)
 			}
 		},
 	)
 
 	t.Run(
-		"check get after update", func(t *testing.T) {
-			r := NewRecord(1)
-			want := element.Map{
-				element.StringType,
-				element.StringType,
-				map[any]any{"hello": "world"},
+		"check explicit null", func(t *testing.T) {
+			r := NewRecordWithNullBitmap(2, element.CompactEncoding)
+			for position := ElementPosition(0); position < 2; position++ {
+				isSet, err := r.IsSet(position)
+				if err != nil || isSet {
+					t.Errorf("%d: expected position to be unset, got %v, %v", position, isSet, err)
+				}
 			}
-			err := r.SetMap(0, want)
-			if err != nil {
-				t.Error(err)
+			if err := r.SetNull(1); err != nil {
+				t.Fatal(err)
 			}
-			isNull, got, err := r.GetMap(0)
-			if err != nil {
-				t.Error(err)
+			isSet, err := r.IsSet(1)
+			if err != nil || !isSet {
+				t.Errorf("expected explicit null, got %v, %v", isSet, err)
 			}
-			if isNull {
-				t.Error("expected non-null value")
+			isNull, _, err := r.GetMap(1)
+			if err != nil || !isNull {
+				t.Errorf("expected null, got %v, %v", isNull, err)
 			}
-			if !reflect.DeepEqual(got, want) {
-				t.Errorf("got %v, want %v", got, want)
+			if isSet, _ = r.IsSet(0); isSet {
+				t.Error("expected position 0 to stay unset")
 			}
+		},
+	)
 
-			want = element.Map{element.StringType, element.StringType, map[any]any{"foo": "bar"}}
-			err = r.SetMap(0, want)
-			if err != nil {
-				t.Error(err)
+	t.Run(
+		"check value after null", func(t *testing.T) {
+			r := NewRecordWithNullBitmap(2, element.LegacyEncoding)
+			if err := r.SetNull(0); err != nil {
+				t.Fatal(err)
 			}
-			isNull, got, err = r.GetMap(0)
-			if err != nil {
-				t.Error(err)
+			if err := r.SetBool(0, true); err != nil {
+				t.Fatal(err)
 			}
-			if isNull {
-				t.Error("expected non-null value")
+			isNull, value, err := r.GetBool(0)
+			if err != nil || isNull || !value {
+				t.Errorf("expected true, got %v, %v, %v", isNull, value, err)
 			}
-			if !reflect.DeepEqual(got, want) {
-				t.Errorf("got %v, want %v", got, want)
+			if err = r.Validate(); err != nil {
+				t.Error(err)
 			}
 		},
 	)
 
 	t.Run(
-		"check first map is null but second is not", func(t *testing.T) {
-			r := NewRecord(2)
-			want := element.Map{
-				element.StringType,
-				element.StringType,
-				map[any]any{"hello": "world"},
+		"check failed write leaves position unset", func(t *testing.T) {
+			r := NewRecordWithNullBitmap(1, element.LegacyEncoding)
+			length := r.Length()
+			err := r.SetArray(0, element.Array{ElementType: element.Int32Type, Values: []any{"x"}})
+			if err == nil {
+				t.Fatal("expected error for string in int32 array")
 			}
-			err := r.SetMap(1, want)
-			if err != nil {
-				t.Error(err)
+			if r.Length() != length || uint32(len(*r)) != length {
+				t.Errorf("expected length %d, got %d", length, r.Length())
 			}
-			isNull, got, err := r.GetMap(1)
-			if err != nil {
+			if err = r.Validate(); err != nil {
 				t.Error(err)
 			}
-			if isNull {
-				t.Error("expected non-null value")
-			}
-			if !reflect.DeepEqual(got, want) {
-				t.Errorf("got %v, want %v", got, want)
-			}
+		},
+	)
 
-			isNull, got, err = r.GetMap(0)
-			if err != nil {
-				t.Error(err)
+	t.Run(
+		"check position out of range", func(t *testing.T) {
+			err := NewRecordWithNullBitmap(2, element.LegacyEncoding).SetNull(2)
+			if _, ok := err.(*PositionOutOfRangeError); !ok {
+				t.Errorf("expected PositionOutOfRangeError, got %v", err)
 			}
-			if !isNull {
-				t.Errorf("expected null value, but got %v", got)
+		},
+	)
+}
+
+func TestRecord_Validate(t *testing.T) {
+	valid := NewRecordWithEncoding(2, element.WideEncoding)
+	err := valid.SetString(0, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid.SetInt64(1, 42)
+
+	corrupt := func(modify func(r Record) Record) *Record {
+		r := make(Record, len(*valid))
+		copy(r, *valid)
+		r = modify(r)
+		return &r
+	}
+
+	t.Run(
+		"check valid record", func(t *testing.T) {
+			if err := valid.Validate(); err != nil {
+				t.Error(err)
+			}
+		},
+	)
+
+	for _, tt := range []struct {
+		name   string
+		record *Record
+	}{
+		{"empty", corrupt(func(r Record) Record { return r[:0] })},
+		{"truncated header", corrupt(func(r Record) Record { return r[:6] })},
+		{"truncated data", corrupt(func(r Record) Record { return r[:len(r)-1] })},
+		{"unknown encoding", corrupt(func(r Record) Record { r[2] = 0x80; return r })},
+		{"header longer than record", corrupt(func(r Record) Record { r[7] = 0xff; return r })},
+		{"odd header length", corrupt(func(r Record) Record { r[7] = 13; return r })},
+		{"offset inside header", corrupt(func(r Record) Record { r[11] = 1; return r })},
+		{"offset past end", corrupt(func(r Record) Record { r[14] = 0xff; return r })},
+	} {
+		t.Run(
+			"check "+tt.name, func(t *testing.T) {
+				err := tt.record.Validate()
+				if _, ok := err.(*CorruptRecordError); !ok {
+					t.Errorf("expected CorruptRecordError, got %v", err)
+				}
+				_, _, err = tt.record.GetString(0)
+				if _, ok := err.(*CorruptRecordError); !ok {
+					t.Errorf("expected CorruptRecordError, got %v", err)
+				}
+			},
+		)
+	}
+
+	t.Run(
+		"check position out of range", func(t *testing.T) {
+			_, _, err := valid.GetInt64(2)
+			if _, ok := err.(*PositionOutOfRangeError); !ok {
+				t.Errorf("expected PositionOutOfRangeError, got %v", err)
 			}
 		},
 	)
 
 	t.Run(
-		"check map with array values", func(t *testing.T) {
+		"check element past end", func(t *testing.T) {
 			r := NewRecord(1)
-			want := element.Map{
-				element.StringType,
-				element.ArrayType,
-				map[any]any{
-					"hello": element.Array{element.StringType, []any{"world", "foo", "bar"}},
-				},
-			}
-			err := r.SetMap(0, want)
+			err := r.SetString(0, "hello")
 			if err != nil {
-				t.Error(err)
+				t.Fatal(err)
 			}
-			isNull, got, err := r.GetMap(0)
-			if err != nil {
-				t.Error(err)
+			// Claim a longer string than the record holds.
+			(*r)[6] = 10
+
+			_, _, err = r.GetString(0)
+			if _, ok := err.(*CorruptRecordError); !ok {
+				t.Errorf("expected CorruptRecordError, got %v", err)
 			}
-			if isNull {
-				t.Error("expected non-null value")
+			_, _, err = r.GetInt64(0)
+			if _, ok := err.(*CorruptRecordError); !ok {
+				t.Errorf("expected CorruptRecordError, got %v", err)
 			}
-			if !reflect.DeepEqual(got, want) {
-				t.Errorf("got %v, want %v", got, want)
+		},
+	)
+}
+
+func FuzzRecord_Get(f *testing.F) {
+	for _, encoding := range []element.Encoding{
+		element.LegacyEncoding, element.WideEncoding, element.CompactEncoding,
+	} {
+		r := NewRecordWithEncoding(4, encoding)
+		if err := r.SetString(0, "hello"); err != nil {
+			f.Fatal(err)
+		}
+		r.SetInt64(1, -42)
+		if err := r.SetArray(2, element.Array{
+			ElementType: element.StringType,
+			Values:      []any{"a", "b"},
+		}); err != nil {
+			f.Fatal(err)
+		}
+		err := r.SetMap(3, element.Map{
+			KeyType:   element.StringType,
+			ValueType: element.Int32Type,
+			Data:      map[any]any{"a": 1},
+		})
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add([]byte(*r))
+
+		r = NewRecordWithNullBitmap(4, encoding)
+		if err := r.SetString(2, "hello"); err != nil {
+			f.Fatal(err)
+		}
+		r.SetInt64(0, -42)
+		if err := r.SetNull(1); err != nil {
+			f.Fatal(err)
+		}
+		f.Add([]byte(*r))
+	}
+
+	f.Fuzz(
+		func(t *testing.T, data []byte) {
+			r := Record(data)
+			_ = r.Validate()
+			for position := ElementPosition(0); position < 5; position++ {
+				_, _, _ = r.GetUint8(position)
+				_, _, _ = r.GetUint16(position)
+				_, _, _ = r.GetUint32(position)
+				_, _, _ = r.GetUint64(position)
+				_, _, _ = r.GetInt8(position)
+				_, _, _ = r.GetInt16(position)
+				_, _, _ = r.GetInt32(position)
+				_, _, _ = r.GetInt64(position)
+				_, _, _ = r.GetFloat32(position)
+				_, _, _ = r.GetFloat64(position)
+				_, _, _ = r.GetUUID(position)
+				_, _, _ = r.GetDecimal(position)
+				_, _, _ = r.GetBool(position)
+				_, _, _ = r.GetTime(position)
+				_, _, _ = r.GetDate(position)
+				_, _, _ = r.GetDuration(position)
+				_, _, _ = r.GetZonedTime(position)
+				_, _, _ = r.GetString(position)
+				_, _, _ = r.GetBytes(position)
+				_, _, _ = r.GetArray(position)
+				_, _, _ = r.GetMap(position)
+				_, _, _ = r.GetArrayView(position)
+				_, _, _ = r.GetMapView(position)
+				_, _, _ = r.GetMapValue(position, "a")
+				_, _ = r.IsSet(position)
 			}
 		},
 	)
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"kyadb/internal/structs/element"
+)
+
+// ValueType describes the type of a value stored in a record. Arrays and maps store the types of
+// their elements, keys and values alongside them, so Elem and Key are only needed when a value is
+// built from a representation without types, such as JSON.
+type ValueType struct {
+	Type element.Type
+
+	// Key is the key type of a Map. Zero means element.StringType.
+	Key element.Type
+
+	// Elem is the type of the elements of an Array or of the values of a Map. nil means that the
+	// type is inferred from the values, falling back to element.AnyType if they differ.
+	Elem *ValueType
+
+	// Precision and Scale are used for Decimal values. Zero means that they are taken from the
+	// value itself.
+	Precision uint8
+	Scale     uint8
+}
+
+// Field describes the value stored at an element position of a record.
+type Field struct {
+	Name string
+	ValueType
+}
+
+// Schema describes the element positions of a record. The field at index i describes the value at
+// element position i.
+type Schema []Field
+
+// UnknownFieldError is returned when a field name is not part of the schema.
+type UnknownFieldError struct {
+	name string
+}
+
+func (e *UnknownFieldError) Error() string {
+	return fmt.Sprintf("unknown field '%s'", e.name)
+}
+
+// position returns the element position of the field with the given name.
+func (s Schema) position(name string) (ElementPosition, error) {
+	for i, field := range s {
+		if field.Name == name {
+			return ElementPosition(i), nil
+		}
+	}
+	return 0, &UnknownFieldError{name}
+}
+
+// types returns the element type of each field of the schema.
+func (s Schema) types() []element.Type {
+	types := make([]element.Type, len(s))
+	for i, field := range s {
+		types[i] = field.Type
+	}
+	return types
+}
+
+// getValue returns the value of the given element type stored at the given element position in the
+// record.
+func (r *Record) getValue(position ElementPosition, elemType element.Type) (bool, any, error) {
+	var isNull bool
+	var value any
+	var err error
+	switch elemType {
+	case element.ArrayType:
+		isNull, value, err = r.GetArray(position)
+	case element.MapType:
+		isNull, value, err = r.GetMap(position)
+	case element.BoolType:
+		isNull, value, err = r.GetBool(position)
+	default:
+		return r.getPrimitive(position, elemType)
+	}
+	if isNull || err != nil {
+		return isNull, nil, err
+	}
+	return false, value, nil
+}
+
+// setValue saves the given value at the given element position in the record, using the Set method
+// for the type of the value.
+func (r *Record) setValue(position ElementPosition, value any) error {
+	switch v := value.(type) {
+	case nil:
+		return r.SetNull(position)
+	case uint8:
+		return r.SetUint8(position, v)
+	case uint16:
+		return r.SetUint16(position, v)
+	case uint32:
+		return r.SetUint32(position, v)
+	case uint64:
+		return r.SetUint64(position, v)
+	case int8:
+		return r.SetInt8(position, v)
+	case int16:
+		return r.SetInt16(position, v)
+	case int32:
+		return r.SetInt32(position, v)
+	case int64:
+		return r.SetInt64(position, v)
+	case float32:
+		return r.SetFloat32(position, v)
+	case float64:
+		return r.SetFloat64(position, v)
+	case bool:
+		return r.SetBool(position, v)
+	case string:
+		return r.SetString(position, v)
+	case []byte:
+		return r.SetBytes(position, v)
+	case element.UUID:
+		return r.SetUUID(position, v)
+	case element.Decimal:
+		return r.SetDecimal(position, v)
+	case time.Time:
+		return r.SetTime(position, v)
+	case element.Date:
+		return r.SetDate(position, v)
+	case time.Duration:
+		return r.SetDuration(position, v)
+	case element.ZonedTime:
+		return r.SetZonedTime(position, v)
+	case element.Array:
+		return r.SetArray(position, v)
+	case element.Map:
+		return r.SetMap(position, v)
+	default:
+		return fmt.Errorf("unsupported type %T", value)
+	}
+}
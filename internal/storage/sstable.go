@@ -0,0 +1,449 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+/*
+ * An SSTable is an immutable sorted run of the entries of an LSMTree, stored in the pages of a
+ * PageFile. Each entry is a key and either a value or a tombstone marking the key as deleted.
+ *
+ * The entries are stored in key order on data pages. A data page starts with a byte storing
+ * sstableDataPage and 2 bytes storing the number of entries on the page, followed by the entries
+ * back to back. An entry is the length of its key as an unsigned varint, the key, a byte of flags,
+ * the length of its value as an unsigned varint and the value.
+ *
+ * The block index and the bloom filter of an SSTable are stored on a chain of meta pages, whose
+ * first page identifies the table. Chain pages start with a byte storing their type and 4 bytes
+ * storing the page number of the next page of the chain, or noPage, and the data of the chain
+ * fills the rest of the pages, starting with 4 bytes storing its length. The meta data of an
+ * SSTable is the number of entries and the number of data pages, then for each data page 4 bytes
+ * storing its page number and its first key, then the last key of the table, a byte storing the
+ * number of hashes of the bloom filter and the bits of the filter. Counts, lengths and keys are
+ * stored as unsigned varints and length-prefixed bytes.
+ *
+ * All fixed-size integers are stored little-endian.
+ */
+
+const (
+	sstableDataPage byte = 'S'
+	sstableMetaPage byte = 'O'
+
+	sstableDataHeaderSize = 3
+	chainPageHeaderSize   = 5
+
+	sstableDeletedFlag = 1 << 0
+)
+
+// MaxLSMEntrySize is the largest number of bytes of the key and the value of an entry of an
+// LSMTree together.
+const MaxLSMEntrySize = PageSize - sstableDataHeaderSize - 2*binary.MaxVarintLen32 - 1
+
+// lsmEntry is an entry of an LSMTree: a key and a value, or a tombstone if the key is deleted.
+type lsmEntry struct {
+	key     []byte
+	value   []byte
+	deleted bool
+}
+
+// sstable is an SSTable, with its block index and bloom filter held in memory.
+type sstable struct {
+	metaPageNum uint32
+	metaPages   []uint32
+	pageNums    []uint32
+	firstKeys   [][]byte
+	lastKey     []byte
+	filter      *BloomFilter
+	numEntries  int
+}
+
+// sstableWriter writes the entries of a new SSTable, given in key order, to data pages taken from
+// a free list.
+type sstableWriter struct {
+	file              PageFile
+	freeList          *uint32
+	falsePositiveRate float64
+	table             *sstable
+	page              Page
+	offset            int
+	numOnPage         int
+	keys              [][]byte
+}
+
+// sstableIterator iterates over the entries of an SSTable in key order.
+type sstableIterator struct {
+	file    PageFile
+	table   *sstable
+	block   int
+	entries []lsmEntry
+	pos     int
+}
+
+// encodedSize returns the number of bytes of the entry on a data page.
+func (e *lsmEntry) encodedSize() int {
+	return uvarintSize(uint64(len(e.key))) + len(e.key) + 1 +
+		uvarintSize(uint64(len(e.value))) + len(e.value)
+}
+
+// uvarintSize returns the number of bytes of the unsigned varint encoding of the given value.
+func uvarintSize(value uint64) int {
+	n := 1
+	for ; value >= 0x80; value >>= 7 {
+		n++
+	}
+	return n
+}
+
+// appendUint32 appends the given value stored in 4 bytes little-endian.
+func appendUint32(b []byte, value uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], value)
+	return append(b, buf[:]...)
+}
+
+// appendBytes appends the given bytes prefixed by their length as an unsigned varint.
+func appendBytes(b []byte, data []byte) []byte {
+	return append(appendUvarint(b, uint64(len(data))), data...)
+}
+
+// readBytes reads bytes prefixed by their length as an unsigned varint from the start of the given
+// bytes and advances them past them. It returns false if the bytes are truncated.
+func readBytes(data *[]byte) ([]byte, bool) {
+	n, ok := readUvarint(data)
+	if !ok || n > uint64(len(*data)) {
+		return nil, false
+	}
+	b := (*data)[:n:n]
+	*data = (*data)[n:]
+	return b, true
+}
+
+// writeChain writes the given data to a chain of pages of the given type, reusing the given pages
+// in order and taking more pages from the free list, or adding the pages that are not needed to
+// it. It returns the page numbers of the chain.
+func writeChain(
+	file PageFile, freeList *uint32, pageType byte, data []byte, pageNums []uint32,
+) ([]uint32, error) {
+	data = append(appendUint32(nil, uint32(len(data))), data...)
+	numPages := (len(data) + PageSize - chainPageHeaderSize - 1) / (PageSize - chainPageHeaderSize)
+	pageNums = append([]uint32(nil), pageNums...)
+	for len(pageNums) > numPages {
+		last := len(pageNums) - 1
+		if err := freeIndexPage(file, freeList, pageNums[last]); err != nil {
+			return nil, err
+		}
+		pageNums = pageNums[:last]
+	}
+	for len(pageNums) < numPages {
+		pageNum, err := allocateIndexPage(file, freeList)
+		if err != nil {
+			return nil, err
+		}
+		pageNums = append(pageNums, pageNum)
+	}
+	for i, pageNum := range pageNums {
+		var page Page
+		page[0] = pageType
+		next := noPage
+		if i+1 < len(pageNums) {
+			next = pageNums[i+1]
+		}
+		binary.LittleEndian.PutUint32(page[1:], next)
+		data = data[copy(page[chainPageHeaderSize:], data):]
+		if _, err := file.WritePages(&[]Page{page}, pageNum); err != nil {
+			return nil, err
+		}
+	}
+	return pageNums, nil
+}
+
+// readChain returns the data stored on the chain of pages of the given type whose first page has
+// the given page number, and the page numbers of the chain.
+func readChain(file PageFile, pageNum uint32, pageType byte) ([]byte, []uint32, error) {
+	first := pageNum
+	var data []byte
+	var pageNums []uint32
+	length := -1
+	for pageNum != noPage {
+		if length >= 0 && len(data) >= length {
+			return nil, nil, &CorruptIndexError{pageNum, "chain has too many pages"}
+		}
+		pages, err := file.ReadPages(pageNum, 1)
+		if err != nil {
+			return nil, nil, err
+		}
+		page := &(*pages)[0]
+		if page[0] != pageType {
+			return nil, nil, &CorruptIndexError{
+				pageNum, fmt.Sprintf("expected page of type %q, got %q", pageType, page[0]),
+			}
+		}
+		pageNums = append(pageNums, pageNum)
+		content := page[chainPageHeaderSize:]
+		if length < 0 {
+			length = int(binary.LittleEndian.Uint32(content))
+			content = content[4:]
+		}
+		data = append(data, content...)
+		pageNum = binary.LittleEndian.Uint32(page[1:])
+	}
+	if length < 0 || len(data) < length {
+		return nil, nil, &CorruptIndexError{first, "chain is truncated"}
+	}
+	return data[:length], pageNums, nil
+}
+
+// newSSTableWriter returns a writer of a new SSTable whose bloom filter has the given false
+// positive rate.
+func newSSTableWriter(
+	file PageFile, freeList *uint32, falsePositiveRate float64,
+) *sstableWriter {
+	w := &sstableWriter{
+		file:              file,
+		freeList:          freeList,
+		falsePositiveRate: falsePositiveRate,
+		table:             &sstable{},
+	}
+	w.resetPage()
+	return w
+}
+
+// resetPage starts a new data page.
+func (w *sstableWriter) resetPage() {
+	w.page = Page{}
+	w.page[0] = sstableDataPage
+	w.offset = sstableDataHeaderSize
+	w.numOnPage = 0
+}
+
+// size returns the number of bytes of data pages written so far, counting the current page.
+func (w *sstableWriter) size() int {
+	return len(w.table.pageNums)*PageSize + w.offset
+}
+
+// add adds the given entry, whose key must be greater than the key of the previous entry.
+func (w *sstableWriter) add(e lsmEntry) error {
+	size := e.encodedSize()
+	if size > PageSize-sstableDataHeaderSize {
+		return fmt.Errorf("entry of %d bytes does not fit on a page", size)
+	}
+	if w.offset+size > PageSize {
+		if err := w.writePage(); err != nil {
+			return err
+		}
+	}
+	if w.numOnPage == 0 {
+		w.table.firstKeys = append(w.table.firstKeys, e.key)
+	}
+	b := appendBytes(w.page[w.offset:w.offset], e.key)
+	var flags byte
+	if e.deleted {
+		flags |= sstableDeletedFlag
+	}
+	b = appendBytes(append(b, flags), e.value)
+	w.offset += len(b)
+	w.numOnPage++
+	w.keys = append(w.keys, e.key)
+	w.table.lastKey = e.key
+	w.table.numEntries++
+	return nil
+}
+
+// writePage writes the current data page and starts a new one.
+func (w *sstableWriter) writePage() error {
+	binary.LittleEndian.PutUint16(w.page[1:], uint16(w.numOnPage))
+	pageNum, err := allocateIndexPage(w.file, w.freeList)
+	if err != nil {
+		return err
+	}
+	if _, err = w.file.WritePages(&[]Page{w.page}, pageNum); err != nil {
+		return err
+	}
+	w.table.pageNums = append(w.table.pageNums, pageNum)
+	w.resetPage()
+	return nil
+}
+
+// finish writes the last data page and the meta pages of the table, and returns the table, or nil
+// if no entries were added.
+func (w *sstableWriter) finish() (*sstable, error) {
+	if w.table.numEntries == 0 {
+		return nil, nil
+	}
+	if w.numOnPage > 0 {
+		if err := w.writePage(); err != nil {
+			return nil, err
+		}
+	}
+	s := w.table
+	s.filter = NewBloomFilter(len(w.keys), w.falsePositiveRate)
+	for _, key := range w.keys {
+		s.filter.Add(key)
+	}
+
+	data := appendUvarint(nil, uint64(s.numEntries))
+	data = appendUvarint(data, uint64(len(s.pageNums)))
+	for i, pageNum := range s.pageNums {
+		data = appendUint32(data, pageNum)
+		data = appendBytes(data, s.firstKeys[i])
+	}
+	data = appendBytes(data, s.lastKey)
+	data = append(data, byte(s.filter.numHashes))
+	data = appendBytes(data, s.filter.bits)
+	var err error
+	s.metaPages, err = writeChain(w.file, w.freeList, sstableMetaPage, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.metaPageNum = s.metaPages[0]
+	return s, nil
+}
+
+// openSSTable reads the block index and the bloom filter of the table whose first meta page has
+// the given page number.
+func openSSTable(file PageFile, metaPageNum uint32) (*sstable, error) {
+	data, metaPages, err := readChain(file, metaPageNum, sstableMetaPage)
+	if err != nil {
+		return nil, err
+	}
+	corrupt := &CorruptIndexError{metaPageNum, "invalid table meta data"}
+	s := &sstable{metaPageNum: metaPageNum, metaPages: metaPages}
+	numEntries, ok := readUvarint(&data)
+	if !ok {
+		return nil, corrupt
+	}
+	numPages, ok := readUvarint(&data)
+	if !ok || numPages == 0 || numPages > numEntries || numPages > uint64(len(data)) {
+		return nil, corrupt
+	}
+	s.numEntries = int(numEntries)
+	for i := uint64(0); i < numPages; i++ {
+		if len(data) < 4 {
+			return nil, corrupt
+		}
+		s.pageNums = append(s.pageNums, binary.LittleEndian.Uint32(data))
+		data = data[4:]
+		key, ok := readBytes(&data)
+		if !ok {
+			return nil, corrupt
+		}
+		s.firstKeys = append(s.firstKeys, key)
+	}
+	if s.lastKey, ok = readBytes(&data); !ok || len(data) == 0 {
+		return nil, corrupt
+	}
+	numHashes := int(data[0])
+	data = data[1:]
+	bits, ok := readBytes(&data)
+	if !ok || numHashes == 0 || numHashes > filterMaxHashes || len(bits) == 0 {
+		return nil, corrupt
+	}
+	s.filter = &BloomFilter{bits, numHashes}
+	return s, nil
+}
+
+// pages returns the page numbers of all pages of the table.
+func (s *sstable) pages() []uint32 {
+	return append(append([]uint32(nil), s.pageNums...), s.metaPages...)
+}
+
+// overlaps returns true if the keys of the table overlap the range from first to last.
+func (s *sstable) overlaps(first, last []byte) bool {
+	return bytes.Compare(s.firstKeys[0], last) <= 0 && bytes.Compare(first, s.lastKey) <= 0
+}
+
+// readBlock returns the entries stored on the data page with the given index.
+func (s *sstable) readBlock(file PageFile, block int) ([]lsmEntry, error) {
+	pageNum := s.pageNums[block]
+	pages, err := file.ReadPages(pageNum, 1)
+	if err != nil {
+		return nil, err
+	}
+	page := &(*pages)[0]
+	if page[0] != sstableDataPage {
+		return nil, &CorruptIndexError{pageNum, "not a data page"}
+	}
+	numEntries := int(binary.LittleEndian.Uint16(page[1:]))
+	entries := make([]lsmEntry, 0, numEntries)
+	data := page[sstableDataHeaderSize:]
+	for i := 0; i < numEntries; i++ {
+		key, ok := readBytes(&data)
+		if !ok || len(data) == 0 {
+			return nil, &CorruptIndexError{pageNum, "truncated entry"}
+		}
+		flags := data[0]
+		data = data[1:]
+		value, ok := readBytes(&data)
+		if !ok {
+			return nil, &CorruptIndexError{pageNum, "truncated entry"}
+		}
+		entries = append(entries, lsmEntry{key, value, flags&sstableDeletedFlag != 0})
+	}
+	return entries, nil
+}
+
+// get returns the entry of the table with the given key, if there is one.
+func (s *sstable) get(file PageFile, key []byte) (lsmEntry, bool, error) {
+	if !s.overlaps(key, key) || !s.filter.MayContain(key) {
+		return lsmEntry{}, false, nil
+	}
+	block := sort.Search(
+		len(s.firstKeys), func(i int) bool { return bytes.Compare(s.firstKeys[i], key) > 0 },
+	) - 1
+	entries, err := s.readBlock(file, block)
+	if err != nil {
+		return lsmEntry{}, false, err
+	}
+	i := sort.Search(
+		len(entries), func(i int) bool { return bytes.Compare(entries[i].key, key) >= 0 },
+	)
+	if i < len(entries) && bytes.Equal(entries[i].key, key) {
+		return entries[i], true, nil
+	}
+	return lsmEntry{}, false, nil
+}
+
+// iterator returns an iterator over the entries of the table whose key is at least the given
+// key, or all entries if the key is nil.
+func (s *sstable) iterator(file PageFile, start []byte) (*sstableIterator, error) {
+	it := &sstableIterator{file: file, table: s}
+	if start != nil {
+		it.block = sort.Search(
+			len(s.firstKeys), func(i int) bool { return bytes.Compare(s.firstKeys[i], start) > 0 },
+		) - 1
+		if it.block < 0 {
+			it.block = 0
+		}
+	}
+	var err error
+	if it.entries, err = s.readBlock(file, it.block); err != nil {
+		return nil, err
+	}
+	if start != nil {
+		it.pos = sort.Search(
+			len(it.entries),
+			func(i int) bool { return bytes.Compare(it.entries[i].key, start) >= 0 },
+		)
+	}
+	return it, nil
+}
+
+// next returns the next entry of the table, or false after the last entry.
+func (it *sstableIterator) next() (lsmEntry, bool, error) {
+	for it.pos == len(it.entries) {
+		if it.block+1 >= len(it.table.pageNums) {
+			return lsmEntry{}, false, nil
+		}
+		it.block++
+		var err error
+		if it.entries, err = it.table.readBlock(it.file, it.block); err != nil {
+			return lsmEntry{}, false, err
+		}
+		it.pos = 0
+	}
+	it.pos++
+	return it.entries[it.pos-1], true, nil
+}
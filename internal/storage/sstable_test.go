@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// testEntries returns n entries with increasing keys, values of varying sizes and a tombstone for
+// every seventh key.
+func testEntries(n int) []lsmEntry {
+	entries := make([]lsmEntry, n)
+	for i := range entries {
+		entries[i].key = []byte(fmt.Sprintf("key %06d", i))
+		if i%7 == 0 {
+			entries[i].deleted = true
+		} else {
+			entries[i].value = bytes.Repeat([]byte{byte(i)}, i%300)
+		}
+	}
+	return entries
+}
+
+// writeTestTable writes the given entries to a new SSTable.
+func writeTestTable(t *testing.T, file PageFile, entries []lsmEntry) *sstable {
+	t.Helper()
+	freeList := noPage
+	w := newSSTableWriter(file, &freeList, 0.01)
+	for _, e := range entries {
+		if err := w.add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	table, err := w.finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+// readTestTable returns the entries of the given table whose key is at least the given key.
+func readTestTable(t *testing.T, file PageFile, table *sstable, start []byte) []lsmEntry {
+	t.Helper()
+	it, err := table.iterator(file, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []lsmEntry
+	for {
+		e, ok, err := it.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return entries
+		}
+		entries = append(entries, e)
+	}
+}
+
+// sameEntries returns true if the given entries have the same keys, values and tombstones.
+func sameEntries(a, b []lsmEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i].key, b[i].key) || !bytes.Equal(a[i].value, b[i].value) ||
+			a[i].deleted != b[i].deleted {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSSTable(t *testing.T) {
+	t.Run(
+		"check entries", func(t *testing.T) {
+			file := &memoryFile{}
+			entries := testEntries(10000)
+			table := writeTestTable(t, file, entries)
+			if len(table.pageNums) < 100 || len(table.metaPages) != 2 {
+				t.Errorf(
+					"expected many data pages and 2 meta pages, got %d and %d",
+					len(table.pageNums), len(table.metaPages),
+				)
+			}
+			if got := readTestTable(t, file, table, nil); !sameEntries(got, entries) {
+				t.Error("expected all entries")
+			}
+			got := readTestTable(t, file, table, []byte("key 004000"))
+			if !sameEntries(got, entries[4000:]) {
+				t.Error("expected entries from key 4000")
+			}
+			got = readTestTable(t, file, table, []byte("key 0040005"))
+			if !sameEntries(got, entries[4001:]) {
+				t.Error("expected entries after key 4000")
+			}
+			if got = readTestTable(t, file, table, []byte("l")); got != nil {
+				t.Errorf("expected no entries, got %d", len(got))
+			}
+
+			for i, e := range entries {
+				found, ok, err := table.get(file, e.key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !ok || !sameEntries([]lsmEntry{found}, entries[i:i+1]) {
+					t.Fatalf("expected entry %d, got %v", i, found)
+				}
+			}
+			for _, key := range []string{"a", "key 0000005", "key 999999", "z"} {
+				if _, ok, _ := table.get(file, []byte(key)); ok {
+					t.Errorf("expected no entry for %s", key)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check open", func(t *testing.T) {
+			file := &memoryFile{}
+			table := writeTestTable(t, file, testEntries(3000))
+			opened, err := openSSTable(file, table.metaPageNum)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(opened, table) {
+				t.Error("expected the opened table to equal the written one")
+			}
+			if _, err = openSSTable(file, table.pageNums[0]); err == nil {
+				t.Error("expected error for a data page opened as meta page")
+			}
+		},
+	)
+
+	t.Run(
+		"check large entries", func(t *testing.T) {
+			file := &memoryFile{}
+			value := make([]byte, MaxLSMEntrySize-1)
+			table := writeTestTable(t, file, []lsmEntry{{[]byte("a"), value, false}})
+			if len(table.pageNums) != 1 {
+				t.Errorf("expected 1 data page, got %d", len(table.pageNums))
+			}
+			freeList := noPage
+			w := newSSTableWriter(file, &freeList, 0.01)
+			if err := w.add(lsmEntry{[]byte("a"), make([]byte, PageSize), false}); err == nil {
+				t.Error("expected error for an entry larger than a page")
+			}
+			if table, err := w.finish(); table != nil || err != nil {
+				t.Errorf("expected no table, got %v, %v", table, err)
+			}
+		},
+	)
+}
+
+func TestChain(t *testing.T) {
+	t.Run(
+		"check write and read", func(t *testing.T) {
+			file := &memoryFile{}
+			freeList := noPage
+			data := bytes.Repeat([]byte("0123456789"), 2000)
+			pageNums, err := writeChain(file, &freeList, 'X', data, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pageNums) != 3 {
+				t.Errorf("expected 3 pages, got %d", len(pageNums))
+			}
+			read, readPages, err := readChain(file, pageNums[0], 'X')
+			if err != nil || !bytes.Equal(read, data) || !reflect.DeepEqual(readPages, pageNums) {
+				t.Fatalf("expected written data, got %d bytes, %v", len(read), err)
+			}
+
+			// Shrinking the chain frees pages.
+			if pageNums, err = writeChain(file, &freeList, 'X', data[:10], pageNums); err != nil {
+				t.Fatal(err)
+			}
+			if len(pageNums) != 1 || freeList == noPage {
+				t.Errorf("expected 1 page and a free list, got %d", len(pageNums))
+			}
+			if read, _, err = readChain(file, pageNums[0], 'X'); !bytes.Equal(read, data[:10]) {
+				t.Errorf("expected 10 bytes, got %d, %v", len(read), err)
+			}
+			if _, _, err = readChain(file, pageNums[0], 'Y'); err == nil {
+				t.Error("expected error for a page of another type")
+			}
+		},
+	)
+}
@@ -0,0 +1,1509 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"kyadb/internal/structs/element"
+)
+
+/*
+ * A Table stores records on table pages of a PageFile and keeps the secondary indexes of the table
+ * up to date as records are inserted, updated and deleted.
+ *
+ * Every record is identified by its home address: the address of the slot it was added to. When an
+ * updated record no longer fits on its page, it is added to another page and the slot at its home
+ * address is set to the new address with SetForwardedAddress. If it moves again, the forwarded
+ * address is replaced, so a record is always found within one hop of its home address. Index
+ * entries store home addresses, so moving a record does not change any index.
+ *
+ * Unique indexes do not store keys that contain a null value, so any number of records can have a
+ * null key.
+ *
+ * A table created with LSMEngine stores its records in an LSMTree instead, keyed by a row number
+ * that is assigned when a record is inserted and never changes. The home address of such a record
+ * is made of its row number: the page number holds its upper 32 bits and the slot number its lower
+ * 16 bits. Writes only add entries to the memtable of the tree, so they never rewrite table pages,
+ * but they are not durable until the memtable is written to an SSTable.
+ *
+ * Record operations and queries, and the changes to the indexes and filters of a table, take the
+ * transaction of a TxManager that they are part of, or nil to run outside any transaction. The
+ * first one in a transaction makes the transaction hold the table until it is committed or rolled
+ * back: the pages of the table and of its indexes are then read and written through the view of
+ * the file of the table in the transaction, and other operations wait until the transaction ends.
+ * When it is rolled back, the pages and moved records of the table are restored, the indexes and
+ * filters created or dropped by the transaction are dropped or added back, and the indexes and
+ * filters are opened again from their meta pages, which the transaction did not write to the file.
+ *
+ * A transaction holds a table stored in an LSMTree in the same way, but writes its rows and the
+ * pages of its indexes right away, as the tree is not read and written through a view. The record
+ * of each row is kept before the transaction first writes the row, and rolling the transaction
+ * back writes the kept records back and restores their index entries. The writes of a committed
+ * transaction are only durable once the memtable of the tree is flushed, as for LSMEngine.
+ *
+ * A versioned table keeps older versions of its records for readers, as described in mvcc.go. Get
+ * and Scan outside a transaction, or in a read-only transaction, read a snapshot of the committed
+ * versions without waiting for the transaction that holds the table, while its records are only
+ * written in transactions. The indexes and filters of a versioned table only store the keys of
+ * the newest versions, so they cannot answer snapshot reads: Lookup and the other queries of its
+ * indexes and filters must be run in a transaction that writes.
+ */
+
+// TableEngine selects how a table stores its records.
+type TableEngine uint8
+
+const (
+	// HeapEngine stores records on slotted table pages, updating them in place.
+	HeapEngine TableEngine = iota
+
+	// LSMEngine stores records in an LSMTree, which suits write-heavy workloads. Unlike records
+	// written to table pages, the records written since the memtable of the tree was last
+	// flushed are only held in memory, and are lost if the process stops before the table is
+	// closed. The tree has no write-ahead log.
+	LSMEngine
+)
+
+// TableOptions describes how a new table stores its records.
+type TableOptions struct {
+	// Engine is the storage engine of the table.
+	Engine TableEngine
+
+	// LSM holds the options of the LSMTree of a table created with LSMEngine.
+	LSM LSMOptions
+
+	// Versions makes a table created with HeapEngine a versioned table whose records are written
+	// in transactions of the given manager, which must manage the file of the table.
+	Versions *TxManager
+}
+
+// IndexDefinition describes a secondary index of a table.
+type IndexDefinition struct {
+	// Name identifies the index within its table.
+	Name string
+
+	// Columns are the names of the schema fields that make up the key of the index, in order.
+	Columns []string
+
+	// Orders holds the sort order of each key column of a BTree index. Columns without an order
+	// are in ascending order.
+	Orders []element.SortOrder
+
+	// Unique rejects records whose key is already stored in the index for another record.
+	Unique bool
+
+	// Collation is the name of a collation registered with element.RegisterCollation that the
+	// string keys of a BTree index are ordered and compared by, as by element.Comparator. String
+	// keys are compared bytewise if it is empty.
+	Collation string
+
+	// NullsLast orders null keys of a BTree index after all other keys instead of before them.
+	NullsLast bool
+
+	// Hash stores the index in a HashIndex instead of a BTree, which supports equality lookups
+	// only. Hash indexes must be unique.
+	Hash bool
+
+	// Elements makes the index multi-valued, storing an entry for each element of the array or
+	// map in its only column instead of one for the whole value.
+	Elements IndexElements
+
+	// Analyzer makes the index a FullTextIndex of its only column, which must be a string column,
+	// storing the terms that the analyzer produces from the text of each record. Full-text indexes
+	// are queried with Search.
+	Analyzer *Analyzer
+
+	// Vector makes the index a VectorIndex with the given options of its only column, which must
+	// be an array of float32 values. Vector indexes are queried with Nearest.
+	Vector *VectorIndexOptions
+}
+
+// Table stores the records of a table, described by a schema, on table pages of a PageFile or in
+// an LSMTree, along with secondary indexes and filters stored in the same file. A table is safe
+// for concurrent use.
+type Table struct {
+	mutex     sync.Mutex
+	released  *sync.Cond
+	schema    Schema
+	file      *tableFile
+	fileID    uint16
+	pageNums  []uint32
+	pages     map[uint32]int
+	moved     map[RecordAddress]bool
+	lsm       *LSMTree
+	nextRowID uint64
+	indexes   []*tableIndex
+	filters   []*tableFilter
+	tx        *Tx
+	undo      *tableUndo
+	versions  *TxManager
+}
+
+// tableFile is the file of a table. While a transaction holds the table, its pages are read and
+// written through the view of the file in the transaction. The file of a versioned table is
+// written outside transactions while holding the pages lock of its manager, as snapshot reads
+// read it without locking the table.
+type tableFile struct {
+	PageFile
+	view     PageFile
+	versions *TxManager
+}
+
+// tableUndo holds what is needed to restore a table when the transaction that holds it is rolled
+// back: the number of pages of the table and whether each record moved by the transaction was
+// moved before, and the indexes and filters of the table. For a table stored in an LSMTree, it
+// holds the record of each row written by the transaction before its first write, or nil for a row
+// that the transaction inserted.
+type tableUndo struct {
+	numPages int
+	moved    map[RecordAddress]bool
+	indexes  []*tableIndex
+	filters  []*tableFilter
+	rows     map[RecordAddress]*Record
+}
+
+// tableCursor is the position of an online build in the records of a table: the index of the
+// next page of a table stored on table pages, or the key of the next row of a table stored in an
+// LSMTree.
+type tableCursor struct {
+	page int
+	next []byte
+}
+
+// tableIndex is a secondary index of a table, stored in a BTree, a HashIndex, a FullTextIndex or a
+// VectorIndex.
+type tableIndex struct {
+	definition IndexDefinition
+	positions  []ElementPosition
+	comparator element.Comparator
+	btree      *BTree
+	hash       *HashIndex
+	text       *FullTextIndex
+	vector     *VectorIndex
+	ready      bool
+}
+
+// InvalidAddressError is returned when an address is not the home address of a record of a table.
+type InvalidAddressError struct {
+	Address RecordAddress
+}
+
+// CorruptTableError is returned when the slot at the home address of a record of a table forwards
+// it to an address that cannot hold it.
+type CorruptTableError struct {
+	Address RecordAddress
+	Reason  string
+}
+
+// IndexExistsError is returned when an index is created with the name of an existing index.
+type IndexExistsError struct {
+	Name string
+}
+
+// UnknownIndexError is returned when a table has no index with the given name.
+type UnknownIndexError struct {
+	Name string
+}
+
+// IndexNotReadyError is returned when an index that is still being built is used for a lookup.
+type IndexNotReadyError struct {
+	Name string
+}
+
+func (e *InvalidAddressError) Error() string {
+	return fmt.Sprintf("%v is not the address of a record of the table", e.Address)
+}
+
+func (e *CorruptTableError) Error() string {
+	return fmt.Sprintf("corrupt table record %v: %s", e.Address, e.Reason)
+}
+
+func (e *IndexExistsError) Error() string {
+	return fmt.Sprintf("index '%s' already exists", e.Name)
+}
+
+func (e *UnknownIndexError) Error() string {
+	return fmt.Sprintf("unknown index '%s'", e.Name)
+}
+
+func (e *IndexNotReadyError) Error() string {
+	return fmt.Sprintf("index '%s' is still being built", e.Name)
+}
+
+// OpenTable returns a table with the given schema whose records are stored on the table pages with
+// the given page numbers of the file with the given ID, such as the pages appended by a
+// CSVLoader. Records are added to the last page and to pages appended to the file. The table has
+// no indexes: OpenIndex opens the indexes stored in the file, and CreateIndex builds new ones from
+// the stored records.
+func OpenTable(schema Schema, file PageFile, fileID uint16, pageNums []uint32) (*Table, error) {
+	if fileID == 0xffff {
+		return nil, fmt.Errorf("invalid file ID %d", fileID)
+	}
+	t := newTable(schema, file, fileID)
+	t.pageNums = append([]uint32(nil), pageNums...)
+	for i, pageNum := range pageNums {
+		t.pages[pageNum] = i
+	}
+	// Find the records that were moved away from their home address, so that they are not
+	// mistaken for records at their home address.
+	for _, pageNum := range pageNums {
+		page, err := t.readPage(pageNum)
+		if err != nil {
+			return nil, err
+		}
+		for slotNum := uint16(0); slotNum < page.getNumSlots(); slotNum++ {
+			if entry := page.getSlot(slotNum); entry.isForwardedAddress() {
+				t.moved[slotEntryToRecordAddress(entry)] = true
+			}
+		}
+	}
+	return t, nil
+}
+
+// newTable returns a table with the given schema, stored in the file with the given ID, that has
+// no pages.
+func newTable(schema Schema, file PageFile, fileID uint16) *Table {
+	t := &Table{
+		schema: schema,
+		file:   &tableFile{PageFile: file},
+		fileID: fileID,
+		pages:  make(map[uint32]int),
+		moved:  make(map[RecordAddress]bool),
+	}
+	t.released = sync.NewCond(&t.mutex)
+	return t
+}
+
+// CreateTable returns an empty table with the given schema whose records are stored in the file
+// with the given ID by the engine of the given options. The table must be closed with Close, which
+// writes the records that a table created with LSMEngine still holds in memory.
+func CreateTable(
+	schema Schema, file PageFile, fileID uint16, options TableOptions,
+) (*Table, error) {
+	switch options.Engine {
+	case HeapEngine:
+		if options.Versions != nil {
+			return OpenVersionedTable(schema, file, fileID, nil, options.Versions)
+		}
+		return OpenTable(schema, file, fileID, nil)
+	case LSMEngine:
+		if options.Versions != nil {
+			return nil, fmt.Errorf("versioned tables need a table stored on table pages")
+		}
+		if fileID == 0xffff {
+			return nil, fmt.Errorf("invalid file ID %d", fileID)
+		}
+		tree, err := NewLSMTree(file, options.LSM)
+		if err != nil {
+			return nil, err
+		}
+		return newLSMTable(schema, fileID, tree), nil
+	}
+	return nil, fmt.Errorf("unknown table engine %d", options.Engine)
+}
+
+// Engine returns the storage engine of the table.
+func (t *Table) Engine() TableEngine {
+	if t.lsm != nil {
+		return LSMEngine
+	}
+	return HeapEngine
+}
+
+// Close flushes the records of a table stored in an LSMTree and stops the background goroutine
+// of the tree. It does nothing for a table stored on table pages.
+func (t *Table) Close() error {
+	if t.lsm != nil {
+		return t.lsm.Close()
+	}
+	return nil
+}
+
+// Schema returns the schema of the records of the table.
+func (t *Table) Schema() Schema {
+	return t.schema
+}
+
+// ReadPages reads pages of the file, through the view of the transaction that holds the table, if
+// any.
+func (f *tableFile) ReadPages(pageNum uint32, numPages uint32) (*[]Page, error) {
+	if f.view != nil {
+		return f.view.ReadPages(pageNum, numPages)
+	}
+	return f.PageFile.ReadPages(pageNum, numPages)
+}
+
+// WritePages writes pages of the file, through the view of the transaction that holds the table,
+// if any.
+func (f *tableFile) WritePages(pages *[]Page, pageNum uint32) (uint32, error) {
+	if f.view != nil {
+		return f.view.WritePages(pages, pageNum)
+	}
+	if f.versions != nil {
+		f.versions.pages.Lock()
+		defer f.versions.pages.Unlock()
+	}
+	return f.PageFile.WritePages(pages, pageNum)
+}
+
+// AppendPages appends pages to the file, through the view of the transaction that holds the
+// table, if any.
+func (f *tableFile) AppendPages(pages *[]Page) ([]uint32, error) {
+	if f.view != nil {
+		return f.view.AppendPages(pages)
+	}
+	if f.versions != nil {
+		f.versions.pages.Lock()
+		defer f.versions.pages.Unlock()
+	}
+	return f.PageFile.AppendPages(pages)
+}
+
+// wait locks the table once no transaction holds it.
+func (t *Table) wait() {
+	t.mutex.Lock()
+	for t.tx != nil {
+		t.released.Wait()
+	}
+}
+
+// lock locks the table for an operation in the given transaction, or outside any transaction if
+// tx is nil or read-only, once no other transaction holds it, and makes the transaction hold the
+// table. The table is not locked if an error is returned.
+func (t *Table) lock(tx *Tx) error {
+	if tx != nil && tx.readOnly {
+		if err := tx.check(); err != nil {
+			return err
+		}
+		tx = nil
+	}
+	t.mutex.Lock()
+	for t.tx != nil && t.tx != tx {
+		t.released.Wait()
+	}
+	if tx == nil || t.tx == tx {
+		return nil
+	}
+	if err := t.hold(tx); err != nil {
+		t.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// lockSchema locks the table like lock for a change to its indexes or filters, which must not be
+// in a read-only transaction.
+func (t *Table) lockSchema(tx *Tx) error {
+	if tx != nil && tx.readOnly {
+		return fmt.Errorf("read-only transactions cannot change tables")
+	}
+	return t.lock(tx)
+}
+
+// lockQuery locks the table like lock for a query of its indexes or filters, which must be in a
+// transaction that writes for a versioned table.
+func (t *Table) lockQuery(tx *Tx) error {
+	if t.versions != nil && (tx == nil || tx.readOnly) {
+		return fmt.Errorf(
+			"index and filter queries of versioned tables need a transaction that writes",
+		)
+	}
+	return t.lock(tx)
+}
+
+// lockWrite locks the table like lock for an operation that writes to it, which must not be in a
+// read-only transaction, or outside any transaction for a versioned table.
+func (t *Table) lockWrite(tx *Tx) error {
+	if tx != nil && tx.readOnly {
+		return fmt.Errorf("read-only transactions cannot write to tables")
+	}
+	if tx == nil && t.versions != nil {
+		return fmt.Errorf("versioned tables are only written in transactions")
+	}
+	return t.lock(tx)
+}
+
+// hold makes the given transaction hold the table until it is committed or rolled back.
+func (t *Table) hold(tx *Tx) error {
+	if t.versions != nil && tx.manager != t.versions {
+		return fmt.Errorf("transaction is not of the manager of the versioned table")
+	}
+	var view PageFile
+	if t.lsm == nil {
+		var err error
+		if view, err = tx.File(t.file.PageFile); err != nil {
+			return err
+		}
+	}
+	if err := tx.onEnd(t.release); err != nil {
+		return err
+	}
+	t.tx, t.file.view = tx, view
+	t.undo = &tableUndo{
+		numPages: len(t.pageNums),
+		moved:    make(map[RecordAddress]bool),
+		indexes:  append([]*tableIndex(nil), t.indexes...),
+		filters:  append([]*tableFilter(nil), t.filters...),
+	}
+	return nil
+}
+
+// release ends the hold of a transaction on the table when the transaction is committed or rolled
+// back, restoring the table if it is rolled back.
+func (t *Table) release(committed bool) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	defer t.released.Broadcast()
+	undo := t.undo
+	t.tx, t.file.view, t.undo = nil, nil, nil
+	if committed {
+		return nil
+	}
+	if t.lsm != nil {
+		// The rows are restored in the indexes that the transaction kept up to date.
+		err := t.restoreRows(undo.rows)
+		t.indexes, t.filters = undo.indexes, undo.filters
+		return err
+	}
+	t.indexes, t.filters = undo.indexes, undo.filters
+
+	for _, pageNum := range t.pageNums[undo.numPages:] {
+		delete(t.pages, pageNum)
+	}
+	t.pageNums = t.pageNums[:undo.numPages]
+	for address, moved := range undo.moved {
+		if moved {
+			t.moved[address] = true
+		} else {
+			delete(t.moved, address)
+		}
+	}
+	for _, index := range t.indexes {
+		if err := index.reopen(t.file); err != nil {
+			return err
+		}
+	}
+	for _, filter := range t.filters {
+		pageFilter, err := OpenPageFilter(t.file, filter.filter.MetaPageNum())
+		if err != nil {
+			return err
+		}
+		filter.filter = pageFilter
+	}
+	return nil
+}
+
+// setMoved sets whether the record at the given address was moved away from its home address,
+// keeping what it was before for the transaction that holds the table, if any.
+func (t *Table) setMoved(address RecordAddress, moved bool) {
+	if t.undo != nil {
+		if _, ok := t.undo.moved[address]; !ok {
+			t.undo.moved[address] = t.moved[address]
+		}
+	}
+	if moved {
+		t.moved[address] = true
+	} else {
+		delete(t.moved, address)
+	}
+}
+
+func (t *Table) readPage(pageNum uint32) (*TablePage, error) {
+	pages, err := t.file.ReadPages(pageNum, 1)
+	if err != nil {
+		return nil, err
+	}
+	return &(*pages)[0], nil
+}
+
+func (t *Table) writePage(pageNum uint32, page *TablePage) error {
+	_, err := t.file.WritePages(&[]Page{*page}, pageNum)
+	return err
+}
+
+// address returns the address of the given slot of the page with the given number.
+func (t *Table) address(pageNum uint32, slotNum uint16) RecordAddress {
+	return RecordAddress{PageAddress{t.fileID, pageNum}, slotNum}
+}
+
+// hasPage returns true if the page with the given number is a page of the table.
+func (t *Table) hasPage(pageNum uint32) bool {
+	_, ok := t.pages[pageNum]
+	return ok
+}
+
+// locate returns the record whose home address is the given address, and the address it is
+// stored at.
+func (t *Table) locate(address RecordAddress) (*Record, RecordAddress, error) {
+	if t.lsm != nil {
+		record, err := t.getRow(address)
+		return record, address, err
+	}
+	if address.FileID != t.fileID || !t.hasPage(address.PageNum) || t.moved[address] {
+		return nil, address, &InvalidAddressError{address}
+	}
+	if t.versions != nil {
+		return t.locateVersion(address)
+	}
+	page, err := t.readPage(address.PageNum)
+	if err != nil {
+		return nil, address, err
+	}
+	if address.SlotNum >= page.getNumSlots() {
+		return nil, address, &InvalidAddressError{address}
+	}
+	record, forwarded, err := page.GetRecord(address.SlotNum)
+	if forwarded == nil || err != nil {
+		return record, address, err
+	}
+
+	at := *forwarded
+	if at.FileID != t.fileID || !t.hasPage(at.PageNum) || !t.moved[at] {
+		return nil, address, &CorruptTableError{
+			address, fmt.Sprintf("record forwarded to invalid address %v", at),
+		}
+	}
+	page, err = t.readPage(at.PageNum)
+	if err != nil {
+		return nil, address, err
+	}
+	record, forwarded, err = page.GetRecord(at.SlotNum)
+	if forwarded != nil {
+		return nil, address, &CorruptTableError{address, "record forwarded twice"}
+	}
+	return record, at, err
+}
+
+// placeRecord adds the given record to the last page of the table, or to a page appended to the
+// file if it does not fit, and returns its address. A record that is forwarded to is never added
+// to page 0 of file 0, whose addresses cannot be forwarded to.
+func (t *Table) placeRecord(record *Record, forwarded bool) (RecordAddress, error) {
+	last := len(t.pageNums) - 1
+	if last >= 0 && forwarded && t.fileID == 0 && t.pageNums[last] == 0 {
+		last = -1
+	}
+	if last >= 0 {
+		pageNum := t.pageNums[last]
+		page, err := t.readPage(pageNum)
+		if err != nil {
+			return RecordAddress{}, err
+		}
+		slotNum, err := page.AddRecord(record)
+		if err == nil {
+			return t.address(pageNum, slotNum), t.writePage(pageNum, page)
+		}
+		if _, ok := err.(*PageFullError); !ok {
+			return RecordAddress{}, err
+		}
+	}
+
+	page := NewTablePage()
+	slotNum, err := page.AddRecord(record)
+	if err != nil {
+		return RecordAddress{}, err
+	}
+	pageNums, err := t.file.AppendPages(&[]Page{*page})
+	if err != nil {
+		return RecordAddress{}, err
+	}
+	t.pages[pageNums[0]] = len(t.pageNums)
+	t.pageNums = append(t.pageNums, pageNums[0])
+	return t.address(pageNums[0], slotNum), nil
+}
+
+// deleteSlot deletes the record stored at the given address.
+func (t *Table) deleteSlot(address RecordAddress) error {
+	page, err := t.readPage(address.PageNum)
+	if err != nil {
+		return err
+	}
+	page.DeleteRecord(address.SlotNum)
+	return t.writePage(address.PageNum, page)
+}
+
+// keys returns the keys that each index of the table stores for the given record.
+func (t *Table) keys(record *Record) ([][][]any, error) {
+	keys := make([][][]any, len(t.indexes))
+	for i, index := range t.indexes {
+		var err error
+		if keys[i], err = t.indexKeys(index, record); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// indexKeys returns the keys that the given index stores for the given record: the values of the
+// columns of the index, or the keys of the elements of its column for a multi-valued index.
+func (t *Table) indexKeys(index *tableIndex, record *Record) ([][]any, error) {
+	values := make([]any, len(index.positions))
+	for i, position := range index.positions {
+		_, value, err := record.getValue(position, t.schema[position].Type)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	if index.vector != nil {
+		key, err := vectorKey(values[0])
+		return [][]any{{key}}, err
+	}
+	if index.definition.Elements == WholeValues {
+		return [][]any{values}, nil
+	}
+	return elementKeys(values[0], index.definition.Elements, index.comparator)
+}
+
+// compacted returns a copy of the given record compacted with CompactTypes using the types of the
+// schema of the table.
+func (t *Table) compacted(record *Record) (*Record, error) {
+	compacted := *record
+	if err := compacted.CompactTypes(t.schema.types()); err != nil {
+		return nil, err
+	}
+	return &compacted, nil
+}
+
+// Insert adds the given record to the table and to its indexes, and returns the home address of
+// the record. A DuplicateKeyError is returned, and the record is not added, if a unique index
+// already stores the key of the record. If storing the record or one of its keys fails, the steps
+// already done are undone, so that the table is left as it was.
+//
+// A copy of the record compacted with CompactTypes using the types of the schema is stored, as for
+// records passed to Update. The given record is not changed.
+func (t *Table) Insert(tx *Tx, record *Record) (RecordAddress, error) {
+	if err := t.lockWrite(tx); err != nil {
+		return RecordAddress{}, err
+	}
+	defer t.mutex.Unlock()
+
+	record, err := t.compacted(record)
+	if err != nil {
+		return RecordAddress{}, err
+	}
+	keys, err := t.keys(record)
+	if err != nil {
+		return RecordAddress{}, err
+	}
+	for i, index := range t.indexes {
+		for _, key := range keys[i] {
+			if err = index.check(key, nil); err != nil {
+				return RecordAddress{}, err
+			}
+		}
+	}
+	var address RecordAddress
+	if t.lsm != nil {
+		address, err = t.insertRow(record)
+	} else {
+		var stored *Record
+		if stored, err = t.storedRecord(record); err != nil {
+			return RecordAddress{}, err
+		}
+		address, err = t.placeRecord(stored, false)
+	}
+	if err != nil {
+		return RecordAddress{}, err
+	}
+	undo := []func() error{func() error {
+		if t.lsm != nil {
+			return t.lsm.Delete(rowKey(address))
+		}
+		return t.removeRecord(address, address)
+	}}
+	for i, index := range t.indexes {
+		for _, key := range keys[i] {
+			if err = index.insert(key, address); err != nil {
+				return RecordAddress{}, undoSteps(err, undo)
+			}
+			index, key := index, key
+			undo = append(undo, func() error { return index.remove(key, address) })
+		}
+	}
+	if err = t.addFilterKeys(address, record); err != nil {
+		return RecordAddress{}, undoSteps(err, undo)
+	}
+	return address, nil
+}
+
+// undoSteps calls the given functions, which each undo a completed step of a write that failed
+// with the given error, in reverse order, and returns the error. The functions that follow one
+// that fails are still called, but the table may then be left partly written.
+func undoSteps(err error, undo []func() error) error {
+	for i := len(undo) - 1; i >= 0; i-- {
+		_ = undo[i]()
+	}
+	return err
+}
+
+// Get returns the record with the given home address.
+func (t *Table) Get(tx *Tx, address RecordAddress) (*Record, error) {
+	if t.versions != nil && (tx == nil || tx.readOnly) {
+		return t.getVersion(tx, address)
+	}
+	if err := t.lock(tx); err != nil {
+		return nil, err
+	}
+	defer t.mutex.Unlock()
+	record, _, err := t.locate(address)
+	return record, err
+}
+
+// Update replaces the record with the given home address by the given record, and updates the
+// index entries whose key changed. The record keeps its home address, even if it has to move to
+// another page. A DuplicateKeyError is returned, and the record is not updated, if a unique index
+// already stores the new key for another record. As in Insert, a failed update is undone.
+func (t *Table) Update(tx *Tx, address RecordAddress, record *Record) error {
+	if err := t.lockWrite(tx); err != nil {
+		return err
+	}
+	defer t.mutex.Unlock()
+
+	record, err := t.compacted(record)
+	if err != nil {
+		return err
+	}
+	existing, at, err := t.locate(address)
+	if err != nil {
+		return err
+	}
+	oldKeys, err := t.keys(existing)
+	if err != nil {
+		return err
+	}
+	newKeys, err := t.keys(record)
+	if err != nil {
+		return err
+	}
+	removed := make([][][]any, len(t.indexes))
+	added := make([][][]any, len(t.indexes))
+	for i, index := range t.indexes {
+		removed[i], added[i], err = keyChanges(oldKeys[i], newKeys[i], index.comparator)
+		if err != nil {
+			return err
+		}
+		for _, key := range added[i] {
+			if err = index.check(key, &address); err != nil {
+				return err
+			}
+		}
+	}
+
+	if t.lsm != nil {
+		t.keepRow(address, existing)
+	}
+	if err = t.rewrite(address, at, record); err != nil {
+		return err
+	}
+	undo := []func() error{func() error {
+		_, at, err := t.locate(address)
+		if err != nil {
+			return err
+		}
+		return t.rewrite(address, at, existing)
+	}}
+	for i, index := range t.indexes {
+		for _, key := range removed[i] {
+			if err = index.remove(key, address); err != nil {
+				return undoSteps(err, undo)
+			}
+			index, key := index, key
+			undo = append(undo, func() error { return index.insert(key, address) })
+		}
+		for _, key := range added[i] {
+			if err = index.insert(key, address); err != nil {
+				return undoSteps(err, undo)
+			}
+			index, key := index, key
+			undo = append(undo, func() error { return index.remove(key, address) })
+		}
+	}
+	if err = t.updateFilterKeys(address, existing, record); err != nil {
+		return undoSteps(err, undo)
+	}
+	return nil
+}
+
+// rewrite writes the given record in place of the record with the given home address that is
+// stored at the given address, moving it to another page if it no longer fits. In a versioned
+// table, the given record becomes the newest version of the record.
+func (t *Table) rewrite(address RecordAddress, at RecordAddress, record *Record) error {
+	if t.lsm != nil {
+		return t.lsm.Put(rowKey(address), recordBytes(record))
+	}
+	stored := record
+	var version *recordVersion
+	if t.versions != nil {
+		var err error
+		if version, err = t.supersede(address, at, record); err != nil {
+			return err
+		}
+		if stored, err = version.encode(); err != nil {
+			return err
+		}
+	}
+	page, err := t.readPage(at.PageNum)
+	if err != nil {
+		return err
+	}
+	_, err = page.UpdateRecord(at.SlotNum, stored)
+	if err == nil {
+		return t.writePage(at.PageNum, page)
+	}
+	if _, ok := err.(*PageFullError); !ok {
+		return err
+	}
+
+	if version != nil && at == address {
+		// The version is no longer stored at its home address.
+		version.home = recordAddressToSlotEntry(address)
+		if stored, err = version.encode(); err != nil {
+			return err
+		}
+	}
+	newAt, err := t.placeRecord(stored, true)
+	if err != nil {
+		return err
+	}
+	if at != address {
+		if err = t.deleteSlot(at); err != nil {
+			return err
+		}
+		t.setMoved(at, false)
+	}
+	home, err := t.readPage(address.PageNum)
+	if err != nil {
+		return err
+	}
+	if err = home.SetForwardedAddress(address.SlotNum, newAt); err != nil {
+		return err
+	}
+	t.setMoved(newAt, true)
+	return t.writePage(address.PageNum, home)
+}
+
+// keyChanges returns the keys of oldKeys that are not in newKeys and the keys of newKeys that are
+// not in oldKeys, with keys compared by the given comparator.
+func keyChanges(oldKeys, newKeys [][]any, comparator element.Comparator) ([][]any, [][]any, error) {
+	oldEncoded, err := encodeKeys(oldKeys, comparator)
+	if err != nil {
+		return nil, nil, err
+	}
+	newEncoded, err := encodeKeys(newKeys, comparator)
+	if err != nil {
+		return nil, nil, err
+	}
+	inOld := make(map[string]bool, len(oldKeys))
+	for _, encoded := range oldEncoded {
+		inOld[encoded] = true
+	}
+	inNew := make(map[string]bool, len(newKeys))
+	for _, encoded := range newEncoded {
+		inNew[encoded] = true
+	}
+
+	var removed, added [][]any
+	for i, key := range oldKeys {
+		if !inNew[oldEncoded[i]] {
+			removed = append(removed, key)
+		}
+	}
+	for i, key := range newKeys {
+		if !inOld[newEncoded[i]] {
+			added = append(added, key)
+		}
+	}
+	return removed, added, nil
+}
+
+// encodeKeys returns the key encoding of each of the given keys with the given comparator, in
+// ascending order.
+func encodeKeys(keys [][]any, comparator element.Comparator) ([]string, error) {
+	encoded := make([]string, len(keys))
+	for i, key := range keys {
+		b, err := comparator.EncodeKeyWithOrder(key, nil)
+		if err != nil {
+			return nil, err
+		}
+		encoded[i] = string(b)
+	}
+	return encoded, nil
+}
+
+// Delete removes the record with the given home address from the table and from its indexes. The
+// removed index entries are inserted again if the record cannot be removed.
+func (t *Table) Delete(tx *Tx, address RecordAddress) error {
+	if err := t.lockWrite(tx); err != nil {
+		return err
+	}
+	defer t.mutex.Unlock()
+
+	record, at, err := t.locate(address)
+	if err != nil {
+		return err
+	}
+	keys, err := t.keys(record)
+	if err != nil {
+		return err
+	}
+	var undo []func() error
+	for i, index := range t.indexes {
+		for _, key := range keys[i] {
+			if err = index.remove(key, address); err != nil {
+				return undoSteps(err, undo)
+			}
+			index, key := index, key
+			undo = append(undo, func() error { return index.insert(key, address) })
+		}
+	}
+	switch {
+	case t.lsm != nil:
+		t.keepRow(address, record)
+		err = t.lsm.Delete(rowKey(address))
+	case t.versions != nil:
+		err = t.deleteVersion(address, at)
+	default:
+		err = t.deleteSlot(address)
+	}
+	if err != nil {
+		return undoSteps(err, undo)
+	}
+	if t.lsm != nil {
+		return nil
+	}
+	if t.versions == nil && at != address {
+		// The record is gone once its home slot is deleted, so its index entries stay removed
+		// even if the slot it moved to cannot be deleted.
+		if err = t.deleteSlot(at); err != nil {
+			return err
+		}
+		t.setMoved(at, false)
+	}
+	return t.removeFilterKeys(address)
+}
+
+// removeRecord deletes the slot of the record with the given home address, and the slot it is
+// stored at if it was moved. The home slot is deleted first, so that the record is gone even if
+// the other slot cannot be deleted.
+func (t *Table) removeRecord(address RecordAddress, at RecordAddress) error {
+	if err := t.deleteSlot(address); err != nil {
+		return err
+	}
+	if at != address {
+		if err := t.deleteSlot(at); err != nil {
+			return err
+		}
+		t.setMoved(at, false)
+	}
+	return nil
+}
+
+// Scan calls fn with the home address and the record of each record of the table, in the order of
+// the pages and slots of the home addresses, stopping at the first error returned by fn. fn must
+// not modify the table.
+func (t *Table) Scan(tx *Tx, fn func(address RecordAddress, record *Record) error) error {
+	if t.versions != nil && (tx == nil || tx.readOnly) {
+		return t.scanVersions(tx, fn)
+	}
+	if err := t.lock(tx); err != nil {
+		return err
+	}
+	defer t.mutex.Unlock()
+	if t.lsm != nil {
+		return t.scanRows(nil, fn)
+	}
+	for i := 0; i < len(t.pageNums); i++ {
+		if err := t.scanPage(t.pageNums[i], fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanChunk calls fn with the home address and the record of each record of the next chunk of
+// records after the given cursor, one page or lsmScanChunk rows, and advances the cursor. It
+// returns true if there were no records left.
+func (t *Table) scanChunk(
+	cursor *tableCursor, fn func(address RecordAddress, record *Record) error,
+) (bool, error) {
+	if t.lsm != nil {
+		return t.scanRowChunk(cursor, fn)
+	}
+	if cursor.page == len(t.pageNums) {
+		return true, nil
+	}
+	cursor.page++
+	return false, t.scanPage(t.pageNums[cursor.page-1], fn)
+}
+
+// scanPage calls fn with the home address and the record of each record whose home address is on
+// the page with the given number.
+func (t *Table) scanPage(
+	pageNum uint32, fn func(address RecordAddress, record *Record) error,
+) error {
+	page, err := t.readPage(pageNum)
+	if err != nil {
+		return err
+	}
+	for slotNum := uint16(0); slotNum < page.getNumSlots(); slotNum++ {
+		address := t.address(pageNum, slotNum)
+		if t.moved[address] {
+			continue
+		}
+		record, forwarded, err := page.GetRecord(slotNum)
+		if err == nil && (forwarded != nil || t.versions != nil) {
+			record, _, err = t.locate(address)
+			if isMissing(err) {
+				continue
+			}
+		}
+		if _, ok := err.(*RecordDeletedError); ok {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(address, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateIndex creates a secondary index of the table with the given definition and builds it from
+// the records stored in the table. The index is built online: the table is locked for one chunk of
+// records at a time, and records inserted, updated and deleted in between are added to and
+// removed from the index as usual. Lookups return an IndexNotReadyError until the index is built.
+//
+// If the records do not satisfy a unique index, a DuplicateKeyError is returned and the index is
+// dropped.
+//
+// In a transaction, the whole index is built while the transaction holds the table, and it is
+// dropped again if the transaction is rolled back.
+func (t *Table) CreateIndex(tx *Tx, definition IndexDefinition) error {
+	if err := t.lockSchema(tx); err != nil {
+		return err
+	}
+	index, err := t.newIndex(definition)
+	if err == nil {
+		t.indexes = append(t.indexes, index)
+	}
+	t.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	var cursor tableCursor
+	for {
+		if err := t.lockSchema(tx); err != nil {
+			t.wait()
+			t.dropIndex(index)
+			t.mutex.Unlock()
+			return err
+		}
+		done, err := t.scanChunk(
+			&cursor, func(address RecordAddress, record *Record) error {
+				return t.buildEntries(index, address, record)
+			},
+		)
+		if err != nil {
+			t.dropIndex(index)
+		} else if done {
+			index.ready = true
+		}
+		t.mutex.Unlock()
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// newIndex returns an empty index with the given definition.
+func (t *Table) newIndex(definition IndexDefinition) (*tableIndex, error) {
+	index, err := t.defineIndex(definition)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case definition.Vector != nil:
+		index.vector, err = NewVectorIndex(t.file, *definition.Vector)
+	case definition.Analyzer != nil:
+		index.text, err = NewFullTextIndex(t.file, definition.Analyzer)
+	case definition.Hash:
+		index.hash, err = NewHashIndex(t.file, len(definition.Columns))
+	default:
+		options := BTreeOptions{
+			Orders: keyOrders(definition), Unique: definition.Unique,
+			Collation: definition.Collation, NullsLast: definition.NullsLast,
+		}
+		index.btree, err = NewBTree(t.file, options)
+	}
+	return index, err
+}
+
+// defineIndex checks the given definition against the schema and the other indexes of the table,
+// and returns an index with the definition that is not stored anywhere yet.
+func (t *Table) defineIndex(definition IndexDefinition) (*tableIndex, error) {
+	for _, index := range t.indexes {
+		if index.definition.Name == definition.Name {
+			return nil, &IndexExistsError{definition.Name}
+		}
+	}
+	index := &tableIndex{
+		definition: definition,
+		positions:  make([]ElementPosition, len(definition.Columns)),
+	}
+	for i, name := range definition.Columns {
+		position, err := t.schema.position(name)
+		if err != nil {
+			return nil, err
+		}
+		index.positions[i] = position
+	}
+	if definition.Collation != "" || definition.NullsLast {
+		if definition.Hash || definition.Analyzer != nil || definition.Vector != nil {
+			return nil, fmt.Errorf(
+				"index '%s' with a collation or null order must be a BTree index", definition.Name,
+			)
+		}
+		var err error
+		if index.comparator, err = newComparator(
+			definition.Collation, definition.NullsLast,
+		); err != nil {
+			return nil, err
+		}
+	}
+	if definition.Vector != nil {
+		return index, t.checkVector(index)
+	}
+	if definition.Analyzer != nil {
+		return index, t.checkFullText(index)
+	}
+	if definition.Elements != WholeValues {
+		if err := t.checkMultiValued(index); err != nil {
+			return nil, err
+		}
+	}
+	if numColumns := len(keyOrders(definition)); len(definition.Orders) > numColumns {
+		return nil, fmt.Errorf(
+			"index has %d sort orders for %d key columns", len(definition.Orders), numColumns,
+		)
+	}
+	if definition.Hash && !definition.Unique {
+		return nil, fmt.Errorf("hash index '%s' must be unique", definition.Name)
+	}
+	return index, nil
+}
+
+// keyOrders returns the sort order of each key column of a BTree index with the given definition.
+// An index of the entries of a map has two key columns: the key and the value of an entry.
+func keyOrders(definition IndexDefinition) []element.SortOrder {
+	numColumns := len(definition.Columns)
+	if definition.Elements == MapEntries {
+		numColumns = 2
+	}
+	orders := make([]element.SortOrder, numColumns)
+	copy(orders, definition.Orders)
+	return orders
+}
+
+// IndexMetaPageNum returns the number of the meta page of the index with the given name, which
+// OpenIndex opens the index from when the table is opened again.
+func (t *Table) IndexMetaPageNum(name string) (uint32, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, index := range t.indexes {
+		if index.definition.Name == name {
+			if !index.ready {
+				return 0, &IndexNotReadyError{name}
+			}
+			return index.metaPageNum(), nil
+		}
+	}
+	return 0, &UnknownIndexError{name}
+}
+
+// OpenIndex adds the index with the given definition whose BTree, HashIndex, FullTextIndex or
+// VectorIndex is stored at the given meta page of the file of the table, as returned by
+// IndexMetaPageNum, without building it again. The index must have been built for the records of
+// the table, and kept up to date with every write to the table since. The vector options of the
+// definition are those of the stored index.
+func (t *Table) OpenIndex(definition IndexDefinition, metaPageNum uint32) error {
+	t.wait()
+	defer t.mutex.Unlock()
+	var vector *VectorIndex
+	if definition.Vector != nil {
+		var err error
+		if vector, err = OpenVectorIndex(t.file, metaPageNum); err != nil {
+			return err
+		}
+		options := vector.Options()
+		definition.Vector = &options
+	}
+	index, err := t.defineIndex(definition)
+	if err != nil {
+		return err
+	}
+	if vector != nil {
+		index.vector = vector
+	} else if err = index.open(t.file, metaPageNum); err != nil {
+		return err
+	}
+	if !index.matches() {
+		return fmt.Errorf("index '%s' does not match the stored index", definition.Name)
+	}
+	index.ready = true
+	t.indexes = append(t.indexes, index)
+	return nil
+}
+
+// buildEntries adds the entries for the given record to an index that is being built, unless the
+// index already stores them.
+func (t *Table) buildEntries(index *tableIndex, address RecordAddress, record *Record) error {
+	keys, err := t.indexKeys(index, record)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = index.check(key, &address); err != nil {
+			return err
+		}
+		err = index.insert(key, address)
+		if _, ok := err.(*DuplicateKeyError); ok {
+			// The record was added to the index by a write after the build started.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reopen opens the index again from its meta page in the given file.
+func (i *tableIndex) reopen(file PageFile) error {
+	return i.open(file, i.metaPageNum())
+}
+
+// metaPageNum returns the number of the meta page of the index.
+func (i *tableIndex) metaPageNum() uint32 {
+	switch {
+	case i.text != nil:
+		return i.text.MetaPageNum()
+	case i.vector != nil:
+		return i.vector.MetaPageNum()
+	case i.btree != nil:
+		return i.btree.MetaPageNum()
+	}
+	return i.hash.MetaPageNum()
+}
+
+// open opens the index of the kind given by its definition from the given meta page of the given
+// file.
+func (i *tableIndex) open(file PageFile, metaPageNum uint32) error {
+	var err error
+	switch {
+	case i.definition.Vector != nil:
+		i.vector, err = OpenVectorIndex(file, metaPageNum)
+	case i.definition.Analyzer != nil:
+		i.text, err = OpenFullTextIndex(file, metaPageNum, i.definition.Analyzer)
+	case i.definition.Hash:
+		i.hash, err = OpenHashIndex(file, metaPageNum)
+	default:
+		i.btree, err = OpenBTree(file, metaPageNum)
+	}
+	return err
+}
+
+// matches returns true if the opened index stores keys as its definition describes.
+func (i *tableIndex) matches() bool {
+	switch {
+	case i.btree != nil:
+		return i.btree.unique == i.definition.Unique &&
+			reflect.DeepEqual(i.btree.orders, keyOrders(i.definition)) &&
+			i.btree.collation == i.definition.Collation &&
+			i.btree.comparator.NullsLast == i.definition.NullsLast
+	case i.hash != nil:
+		return i.hash.numColumns == len(i.definition.Columns)
+	}
+	return true
+}
+
+// dropIndex removes the given index from the table. Its pages are not reclaimed.
+func (t *Table) dropIndex(index *tableIndex) {
+	for i, other := range t.indexes {
+		if other == index {
+			t.indexes = append(t.indexes[:i], t.indexes[i+1:]...)
+			return
+		}
+	}
+}
+
+// DropIndex removes the index with the given name from the table. The pages of the index are not
+// reclaimed. In a transaction, the index is added back if the transaction is rolled back.
+func (t *Table) DropIndex(tx *Tx, name string) error {
+	if err := t.lockSchema(tx); err != nil {
+		return err
+	}
+	defer t.mutex.Unlock()
+	for _, index := range t.indexes {
+		if index.definition.Name == name {
+			t.dropIndex(index)
+			return nil
+		}
+	}
+	return &UnknownIndexError{name}
+}
+
+// Indexes returns the definitions of the indexes of the table, in the order they were created.
+func (t *Table) Indexes() []IndexDefinition {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	definitions := make([]IndexDefinition, len(t.indexes))
+	for i, index := range t.indexes {
+		definitions[i] = index.definition
+	}
+	return definitions
+}
+
+// Lookup returns the home addresses of the records whose key in the index with the given name is
+// the given key. The key must have a value for each key column of the index, of the type of the
+// column, except for a BTree index, where the records whose key starts with the given values are
+// returned for a shorter key.
+func (t *Table) Lookup(tx *Tx, name string, key []any) ([]RecordAddress, error) {
+	if err := t.lockQuery(tx); err != nil {
+		return nil, err
+	}
+	defer t.mutex.Unlock()
+	index, err := t.readyIndex(name)
+	if err != nil {
+		return nil, err
+	}
+	return index.lookup(key)
+}
+
+// readyIndex returns the index with the given name, which must be built.
+func (t *Table) readyIndex(name string) (*tableIndex, error) {
+	for _, index := range t.indexes {
+		if index.definition.Name != name {
+			continue
+		}
+		if !index.ready {
+			return nil, &IndexNotReadyError{name}
+		}
+		return index, nil
+	}
+	return nil, &UnknownIndexError{name}
+}
+
+// hasNull returns true if the given key contains a null value.
+func hasNull(key []any) bool {
+	for _, value := range key {
+		if value == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// stored returns true if the index stores entries for the given key. Full-text and vector
+// indexes do not store null values.
+func (i *tableIndex) stored(key []any) bool {
+	return !(i.definition.Unique || i.text != nil || i.vector != nil) || !hasNull(key)
+}
+
+// lookup returns the addresses that the index stores for the given key.
+func (i *tableIndex) lookup(key []any) ([]RecordAddress, error) {
+	if i.text != nil || i.vector != nil {
+		return nil, fmt.Errorf("index '%s' does not support lookups", i.definition.Name)
+	}
+	if i.btree != nil {
+		return i.btree.lookupPrefix(key)
+	}
+	address, err := i.hash.Get(key)
+	if _, ok := err.(*KeyNotFoundError); ok {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []RecordAddress{address}, nil
+}
+
+// check returns an error if the given key cannot be added to the index for a record. For a unique
+// index, a DuplicateKeyError is returned if the index stores the key for a record other than the
+// one with the given address, or for any record if the address is nil.
+func (i *tableIndex) check(key []any, address *RecordAddress) error {
+	var err error
+	switch {
+	case i.text != nil:
+		return nil
+	case i.vector != nil:
+		if !i.stored(key) {
+			return nil
+		}
+		_, err = i.vector.prepare(keyVector(key[0].([]byte)))
+		return err
+	case i.btree != nil:
+		_, err = i.btree.encodeEntry(key, RecordAddress{})
+	default:
+		_, err = i.hash.encodeKey(key)
+	}
+	if err != nil || !i.definition.Unique || !i.stored(key) {
+		return err
+	}
+	addresses, err := i.lookup(key)
+	if err != nil {
+		return err
+	}
+	for _, other := range addresses {
+		if address == nil || other != *address {
+			return &DuplicateKeyError{key}
+		}
+	}
+	return nil
+}
+
+// insert adds an entry mapping the given key to the given address to the index.
+func (i *tableIndex) insert(key []any, address RecordAddress) error {
+	if !i.stored(key) {
+		return nil
+	}
+	if i.text != nil {
+		return i.text.Add(address, key[0].(string))
+	}
+	if i.vector != nil {
+		return i.vector.Insert(address, keyVector(key[0].([]byte)))
+	}
+	if i.btree != nil {
+		return i.btree.Insert(key, address)
+	}
+	return i.hash.Set(key, address)
+}
+
+// remove removes the entry mapping the given key to the given address from the index.
+func (i *tableIndex) remove(key []any, address RecordAddress) error {
+	if !i.stored(key) {
+		return nil
+	}
+	if i.text != nil {
+		return i.text.Remove(address, key[0].(string))
+	}
+	if i.vector != nil {
+		_, err := i.vector.Delete(address)
+		return err
+	}
+	if i.btree != nil {
+		_, err := i.btree.Delete(key, address)
+		return err
+	}
+	stored, err := i.hash.Get(key)
+	if _, ok := err.(*KeyNotFoundError); ok {
+		return nil
+	}
+	if err != nil || stored != address {
+		return err
+	}
+	_, err = i.hash.Pop(key)
+	return err
+}
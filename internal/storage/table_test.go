@@ -0,0 +1,689 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"kyadb/internal/structs/element"
+)
+
+var testTableSchema = Schema{
+	{Name: "id", ValueType: ValueType{Type: element.Int64Type}},
+	{Name: "name", ValueType: ValueType{Type: element.StringType}},
+	{Name: "email", ValueType: ValueType{Type: element.StringType}},
+	{Name: "bio", ValueType: ValueType{Type: element.StringType}},
+}
+
+// testTableRecord returns a record of testTableSchema without a bio. A nil email is stored as
+// null.
+func testTableRecord(t *testing.T, id int64, name string, email any) *Record {
+	t.Helper()
+	return testTableRecordWithBio(t, id, name, email, "")
+}
+
+// testTableRecordWithBio returns a record of testTableSchema.
+func testTableRecordWithBio(t *testing.T, id int64, name string, email any, bio string) *Record {
+	t.Helper()
+	r := NewRecord(uint16(len(testTableSchema)))
+	for i, value := range []any{id, name, email, bio} {
+		if err := r.setValue(ElementPosition(i), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func newTestTable(t *testing.T, indexes ...IndexDefinition) *Table {
+	t.Helper()
+	table, err := OpenTable(testTableSchema, &memoryFile{}, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, definition := range indexes {
+		if err = table.CreateIndex(nil, definition); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return table
+}
+
+var (
+	nameIndex  = IndexDefinition{Name: "name", Columns: []string{"name"}}
+	emailIndex = IndexDefinition{
+		Name: "email", Columns: []string{"email"}, Unique: true, Hash: true,
+	}
+)
+
+// indexFailingFile is a memoryFile whose writes to the pages of an index fail once fail is set.
+type indexFailingFile struct {
+	memoryFile
+	from, to uint32
+	fail     bool
+}
+
+func (f *indexFailingFile) WritePages(pages *[]Page, pageNum uint32) (uint32, error) {
+	if f.fail && pageNum < f.to && pageNum+uint32(len(*pages)) > f.from {
+		return 0, fmt.Errorf("write to page %d failed", pageNum)
+	}
+	return f.memoryFile.WritePages(pages, pageNum)
+}
+
+// newFailingTestTable returns a table with nameIndex and emailIndex whose file fails writes to the
+// pages of emailIndex once fail is set.
+func newFailingTestTable(t *testing.T) (*Table, *indexFailingFile) {
+	t.Helper()
+	file := &indexFailingFile{}
+	table, err := OpenTable(testTableSchema, file, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = table.CreateIndex(nil, nameIndex); err != nil {
+		t.Fatal(err)
+	}
+	file.from = uint32(len(file.pages))
+	if err = table.CreateIndex(nil, emailIndex); err != nil {
+		t.Fatal(err)
+	}
+	file.to = uint32(len(file.pages))
+	return table, file
+}
+
+// scanTable returns the records of the table by home address.
+func scanTable(t *testing.T, table *Table) map[RecordAddress]*Record {
+	t.Helper()
+	records := make(map[RecordAddress]*Record)
+	err := table.Scan(
+		nil, func(address RecordAddress, record *Record) error {
+			if _, ok := records[address]; ok {
+				t.Errorf("%v: record scanned twice", address)
+			}
+			records[address] = record
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+// checkTableIndexes checks that every index of the table stores exactly the entries for the
+// records of the table.
+func checkTableIndexes(t *testing.T, table *Table) {
+	t.Helper()
+	records := scanTable(t, table)
+	var tx *Tx
+	if table.versions != nil {
+		// The indexes of a versioned table are only queried in transactions that write.
+		var err error
+		if tx, err = table.versions.Begin(); err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+	}
+	for _, index := range table.indexes {
+		if index.text != nil || index.vector != nil {
+			continue
+		}
+		numEntries := 0
+		for address, record := range records {
+			keys, err := table.indexKeys(index, record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range keys {
+				if !index.stored(key) {
+					continue
+				}
+				numEntries++
+				addresses, err := table.Lookup(tx, index.definition.Name, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				found := false
+				for _, other := range addresses {
+					found = found || other == address
+				}
+				if !found {
+					t.Errorf("%s: no entry for %v at %v", index.definition.Name, key, address)
+				}
+			}
+		}
+		length := index.hash.Length
+		if index.btree != nil {
+			length = index.btree.Len
+		}
+		if length() != uint64(numEntries) {
+			t.Errorf(
+				"%s: expected %d entries, got %d", index.definition.Name, numEntries, length(),
+			)
+		}
+	}
+}
+
+func TestTable_Insert(t *testing.T) {
+	t.Run(
+		"check insert and lookup", func(t *testing.T) {
+			table := newTestTable(t, nameIndex, emailIndex)
+			var addresses []RecordAddress
+			for i := 0; i < 500; i++ {
+				email := fmt.Sprintf("user%d@example.com", i)
+				record := testTableRecord(t, int64(i), fmt.Sprint(i%10), email)
+				address, err := table.Insert(nil, record)
+				if err != nil {
+					t.Fatal(err)
+				}
+				addresses = append(addresses, address)
+			}
+			checkTableIndexes(t, table)
+
+			found, err := table.Lookup(nil, "name", []any{"3"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(found) != 50 {
+				t.Errorf("expected 50 records, got %d", len(found))
+			}
+			found, _ = table.Lookup(nil, "email", []any{"user123@example.com"})
+			if !reflect.DeepEqual(found, []RecordAddress{addresses[123]}) {
+				t.Errorf("expected %v, got %v", addresses[123], found)
+			}
+			record, err := table.Get(nil, found[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, id, _ := record.GetInt64(0); id != 123 {
+				t.Errorf("expected record 123, got %d", id)
+			}
+		},
+	)
+
+	t.Run(
+		"check unique index rejects duplicate", func(t *testing.T) {
+			table := newTestTable(t, nameIndex, emailIndex)
+			record := testTableRecord(t, 1, "a", "a@example.com")
+			if _, err := table.Insert(nil, record); err != nil {
+				t.Fatal(err)
+			}
+			_, err := table.Insert(nil, testTableRecord(t, 2, "b", "a@example.com"))
+			if _, ok := err.(*DuplicateKeyError); !ok {
+				t.Errorf("expected DuplicateKeyError, got %v", err)
+			}
+			if records := scanTable(t, table); len(records) != 1 {
+				t.Errorf("expected 1 record, got %d", len(records))
+			}
+			checkTableIndexes(t, table)
+		},
+	)
+
+	t.Run(
+		"check null keys in unique index", func(t *testing.T) {
+			table := newTestTable(t, emailIndex)
+			for i := 0; i < 3; i++ {
+				if _, err := table.Insert(nil, testTableRecord(t, int64(i), "a", nil)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if records := scanTable(t, table); len(records) != 3 {
+				t.Errorf("expected 3 records, got %d", len(records))
+			}
+			checkTableIndexes(t, table)
+		},
+	)
+
+	t.Run(
+		"check collated index", func(t *testing.T) {
+			table := newTestTable(
+				t, IndexDefinition{
+					Name: "name", Columns: []string{"name"}, Unique: true, Collation: "nocase",
+					NullsLast: true,
+				},
+			)
+			address, err := table.Insert(nil, testTableRecord(t, 1, "Ann", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = table.Insert(nil, testTableRecord(t, 2, "ANN", nil))
+			if _, ok := err.(*DuplicateKeyError); !ok {
+				t.Errorf("expected DuplicateKeyError, got %v", err)
+			}
+			if err = table.Update(nil, address, testTableRecord(t, 1, "ann", nil)); err != nil {
+				t.Fatal(err)
+			}
+			found, err := table.Lookup(nil, "name", []any{"aNN"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(found, []RecordAddress{address}) {
+				t.Errorf("expected %v, got %v", address, found)
+			}
+			checkTableIndexes(t, table)
+		},
+	)
+
+	t.Run(
+		"check failed insert is undone", func(t *testing.T) {
+			table, file := newFailingTestTable(t)
+			file.fail = true
+			_, err := table.Insert(nil, testTableRecord(t, 1, "a", "a@example.com"))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			file.fail = false
+			if records := scanTable(t, table); len(records) != 0 {
+				t.Errorf("expected no records, got %d", len(records))
+			}
+			checkTableIndexes(t, table)
+			if _, err = table.Insert(nil, testTableRecord(t, 1, "a", "a@example.com")); err != nil {
+				t.Fatal(err)
+			}
+			checkTableIndexes(t, table)
+		},
+	)
+}
+
+func TestTable_Update(t *testing.T) {
+	t.Run(
+		"check update changes index entries", func(t *testing.T) {
+			table := newTestTable(t, nameIndex, emailIndex)
+			address, _ := table.Insert(nil, testTableRecord(t, 1, "a", "a@example.com"))
+			other, _ := table.Insert(nil, testTableRecord(t, 2, "b", "b@example.com"))
+			err := table.Update(nil, address, testTableRecord(t, 1, "c", "a@example.com"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found, _ := table.Lookup(nil, "name", []any{"a"}); len(found) != 0 {
+				t.Errorf("expected no records, got %v", found)
+			}
+			if found, _ := table.Lookup(nil, "name", []any{"c"}); !reflect.DeepEqual(
+				found, []RecordAddress{address},
+			) {
+				t.Errorf("expected %v, got %v", address, found)
+			}
+
+			err = table.Update(nil, other, testTableRecord(t, 2, "b", "a@example.com"))
+			if _, ok := err.(*DuplicateKeyError); !ok {
+				t.Errorf("expected DuplicateKeyError, got %v", err)
+			}
+			record, _ := table.Get(nil, other)
+			if _, email, _ := record.GetString(2); email != "b@example.com" {
+				t.Errorf("expected record not to be updated, got %s", email)
+			}
+			checkTableIndexes(t, table)
+		},
+	)
+
+	t.Run(
+		"check moved records keep their address", func(t *testing.T) {
+			table := newTestTable(t, nameIndex, emailIndex)
+			var addresses []RecordAddress
+			for i := 0; i < 200; i++ {
+				email := fmt.Sprintf("%d@example.com", i)
+				address, err := table.Insert(nil, testTableRecord(t, int64(i), "short", email))
+				if err != nil {
+					t.Fatal(err)
+				}
+				addresses = append(addresses, address)
+			}
+			file := table.file.PageFile.(*memoryFile)
+			for round := 1; round <= 3; round++ {
+				bio := strings.Repeat("b", 2000*round)
+				for _, i := range []int{0, 5, 7} {
+					email := fmt.Sprintf("%d@example.com", i)
+					record := testTableRecordWithBio(t, int64(i), "long", email, bio)
+					if err := table.Update(nil, addresses[i], record); err != nil {
+						t.Fatal(err)
+					}
+				}
+				for _, i := range []int{0, 5, 7} {
+					record, err := table.Get(nil, addresses[i])
+					if err != nil {
+						t.Fatal(err)
+					}
+					if _, value, _ := record.GetString(3); value != bio {
+						t.Errorf("%d: expected updated bio, got %d bytes", i, len(value))
+					}
+				}
+				found, err := table.Lookup(nil, "name", []any{"long"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				expected := []RecordAddress{addresses[0], addresses[5], addresses[7]}
+				if !reflect.DeepEqual(found, expected) {
+					t.Errorf("round %d: expected %v, got %v", round, expected, found)
+				}
+			}
+			if len(table.moved) != 3 {
+				t.Errorf("expected 3 moved records, got %d", len(table.moved))
+			}
+			if records := scanTable(t, table); len(records) != 200 {
+				t.Errorf("expected 200 records, got %d", len(records))
+			}
+			checkTableIndexes(t, table)
+
+			reopened, err := OpenTable(testTableSchema, file, 1, table.pageNums)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(reopened.moved, table.moved) {
+				t.Errorf("expected moved records %v, got %v", table.moved, reopened.moved)
+			}
+			if records := scanTable(t, reopened); len(records) != 200 {
+				t.Errorf("expected 200 records, got %d", len(records))
+			}
+		},
+	)
+
+	t.Run(
+		"check failed update is undone", func(t *testing.T) {
+			table, file := newFailingTestTable(t)
+			address, err := table.Insert(nil, testTableRecord(t, 1, "a", "a@example.com"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.fail = true
+			bio := strings.Repeat("b", PageSize/2)
+			record := testTableRecordWithBio(t, 1, "b", "b@example.com", bio)
+			if err = table.Update(nil, address, record); err == nil {
+				t.Fatal("expected error")
+			}
+			file.fail = false
+			record, err = table.Get(nil, address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, name, _ := record.GetString(1); name != "a" {
+				t.Errorf("expected record not to be updated, got %s", name)
+			}
+			if found, _ := table.Lookup(nil, "name", []any{"a"}); !reflect.DeepEqual(
+				found, []RecordAddress{address},
+			) {
+				t.Errorf("expected %v, got %v", address, found)
+			}
+			checkTableIndexes(t, table)
+		},
+	)
+}
+
+func TestTable_Delete(t *testing.T) {
+	t.Run(
+		"check delete removes index entries", func(t *testing.T) {
+			table := newTestTable(t, nameIndex, emailIndex)
+			filler := strings.Repeat("a", 5000)
+			record := testTableRecordWithBio(t, 1, "a", "a@example.com", filler)
+			address, _ := table.Insert(nil, record)
+			moved, _ := table.Insert(nil, testTableRecord(t, 2, "b", "b@example.com"))
+			bio := strings.Repeat("b", PageSize/2)
+			err := table.Update(nil, moved, testTableRecordWithBio(t, 2, "b", "b@example.com", bio))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(table.moved) != 1 {
+				t.Errorf("expected 1 moved record, got %d", len(table.moved))
+			}
+			for _, a := range []RecordAddress{address, moved} {
+				if err := table.Delete(nil, a); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := table.Get(nil, address); err == nil {
+				t.Error("expected error for deleted record")
+			}
+			for _, key := range []string{"a", "b"} {
+				if found, _ := table.Lookup(nil, "name", []any{key}); len(found) != 0 {
+					t.Errorf("expected no records, got %v", found)
+				}
+			}
+			if len(table.moved) != 0 {
+				t.Errorf("expected no moved records, got %v", table.moved)
+			}
+			if records := scanTable(t, table); len(records) != 0 {
+				t.Errorf("expected no records, got %d", len(records))
+			}
+			checkTableIndexes(t, table)
+
+			record = testTableRecord(t, 3, "c", "a@example.com")
+			if _, err := table.Insert(nil, record); err != nil {
+				t.Errorf("expected email of deleted record to be reusable, got %v", err)
+			}
+		},
+	)
+
+	t.Run(
+		"check invalid addresses", func(t *testing.T) {
+			table := newTestTable(t)
+			address, _ := table.Insert(nil, testTableRecord(t, 1, "a", "a@example.com"))
+			invalid := []RecordAddress{
+				{PageAddress{2, address.PageNum}, 0},
+				{PageAddress{1, address.PageNum + 1}, 0},
+				{PageAddress{1, address.PageNum}, 1},
+			}
+			for _, a := range invalid {
+				if err := table.Delete(nil, a); err == nil {
+					t.Errorf("%v: expected error", a)
+				} else if _, ok := err.(*InvalidAddressError); !ok {
+					t.Errorf("%v: expected InvalidAddressError, got %v", a, err)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check failed delete is undone", func(t *testing.T) {
+			table, file := newFailingTestTable(t)
+			address, err := table.Insert(nil, testTableRecord(t, 1, "a", "a@example.com"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.fail = true
+			if err = table.Delete(nil, address); err == nil {
+				t.Fatal("expected error")
+			}
+			file.fail = false
+			if _, err = table.Get(nil, address); err != nil {
+				t.Errorf("expected record not to be deleted, got %v", err)
+			}
+			checkTableIndexes(t, table)
+		},
+	)
+}
+
+func TestTable_CreateIndex(t *testing.T) {
+	t.Run(
+		"check index of loaded table", func(t *testing.T) {
+			file := &memoryFile{}
+			var data strings.Builder
+			for i := 0; i < 1000; i++ {
+				fmt.Fprintf(&data, "%d,n%d,e%d,\n", i, i%7, i)
+			}
+			result, err := NewCSVLoader(testTableSchema, element.LegacyEncoding).Load(
+				strings.NewReader(data.String()), file,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			table, err := OpenTable(testTableSchema, file, 1, result.PageNums)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, definition := range []IndexDefinition{nameIndex, emailIndex} {
+				if err = table.CreateIndex(nil, definition); err != nil {
+					t.Fatal(err)
+				}
+			}
+			checkTableIndexes(t, table)
+			if found, _ := table.Lookup(nil, "name", []any{"n3"}); len(found) != 143 {
+				t.Errorf("expected 143 records, got %d", len(found))
+			}
+			if !reflect.DeepEqual(table.Indexes(), []IndexDefinition{nameIndex, emailIndex}) {
+				t.Errorf("expected index definitions, got %v", table.Indexes())
+			}
+		},
+	)
+
+	t.Run(
+		"check unique index on duplicate keys", func(t *testing.T) {
+			table := newTestTable(t)
+			for i := 0; i < 10; i++ {
+				_, _ = table.Insert(nil, testTableRecord(t, int64(i), "a", fmt.Sprint(i%9)))
+			}
+			err := table.CreateIndex(nil, emailIndex)
+			if _, ok := err.(*DuplicateKeyError); !ok {
+				t.Errorf("expected DuplicateKeyError, got %v", err)
+			}
+			if len(table.Indexes()) != 0 {
+				t.Errorf("expected index to be dropped, got %v", table.Indexes())
+			}
+			if _, err = table.Lookup(nil, "email", []any{"1"}); err == nil {
+				t.Error("expected error for dropped index")
+			}
+		},
+	)
+
+	t.Run(
+		"check invalid definitions", func(t *testing.T) {
+			table := newTestTable(t, nameIndex)
+			definitions := []IndexDefinition{
+				nameIndex,
+				{Name: "missing", Columns: []string{"missing"}},
+				{Name: "hash", Columns: []string{"name"}, Hash: true},
+				{Name: "orders", Columns: []string{"name"}, Orders: make([]element.SortOrder, 2)},
+				{Name: "collation", Columns: []string{"name"}, Collation: "unknown"},
+				{
+					Name: "hash collation", Columns: []string{"name"}, Unique: true, Hash: true,
+					Collation: "nocase",
+				},
+				{Name: "none"},
+			}
+			for _, definition := range definitions {
+				if err := table.CreateIndex(nil, definition); err == nil {
+					t.Errorf("%s: expected error", definition.Name)
+				}
+			}
+			if err := table.DropIndex(nil, "name"); err != nil {
+				t.Error(err)
+			}
+			if _, ok := table.DropIndex(nil, "name").(*UnknownIndexError); !ok {
+				t.Error("expected UnknownIndexError")
+			}
+		},
+	)
+
+	t.Run(
+		"check online build", func(t *testing.T) {
+			table := newTestTable(t)
+			for i := 0; i < 2000; i++ {
+				record := testTableRecord(t, int64(i), fmt.Sprint(i%10), fmt.Sprint(i))
+				_, _ = table.Insert(nil, record)
+			}
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 2000; i < 2500; i++ {
+					address, err := table.Insert(
+						nil, testTableRecord(t, int64(i), fmt.Sprint(i%10), fmt.Sprint(i)),
+					)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					bio := strings.Repeat("x", i%3*1000)
+					name := fmt.Sprint(i % 3)
+					record := testTableRecordWithBio(t, int64(i), name, fmt.Sprint(i), bio)
+					if err = table.Update(nil, address, record); err != nil {
+						t.Error(err)
+						return
+					}
+					if i%5 == 0 {
+						if err = table.Delete(nil, address); err != nil {
+							t.Error(err)
+							return
+						}
+					}
+				}
+			}()
+			for _, definition := range []IndexDefinition{nameIndex, emailIndex} {
+				if err := table.CreateIndex(nil, definition); err != nil {
+					t.Fatal(err)
+				}
+			}
+			wg.Wait()
+			checkTableIndexes(t, table)
+			if found, _ := table.Lookup(nil, "name", []any{"0"}); len(found) != 200+134 {
+				t.Errorf("expected 334 records, got %d", len(found))
+			}
+		},
+	)
+}
+
+func TestTable_OpenIndex(t *testing.T) {
+	t.Run(
+		"check indexes opened without a rebuild", func(t *testing.T) {
+			table := newTestTable(t, nameIndex, emailIndex)
+			for i := 0; i < 100; i++ {
+				email := fmt.Sprintf("%d@example.com", i)
+				record := testTableRecord(t, int64(i), fmt.Sprint(i%10), email)
+				if _, err := table.Insert(nil, record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			reopened, err := OpenTable(testTableSchema, table.file.PageFile, 1, table.pageNums)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, definition := range []IndexDefinition{nameIndex, emailIndex} {
+				metaPageNum, err := table.IndexMetaPageNum(definition.Name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err = reopened.OpenIndex(definition, metaPageNum); err != nil {
+					t.Fatal(err)
+				}
+			}
+			checkTableIndexes(t, reopened)
+			if found, _ := reopened.Lookup(nil, "name", []any{"3"}); len(found) != 10 {
+				t.Errorf("expected 10 records, got %d", len(found))
+			}
+			_, err = reopened.Insert(nil, testTableRecord(t, 100, "3", "3@example.com"))
+			if _, ok := err.(*DuplicateKeyError); !ok {
+				t.Errorf("expected DuplicateKeyError, got %v", err)
+			}
+		},
+	)
+
+	t.Run(
+		"check errors", func(t *testing.T) {
+			table := newTestTable(t, nameIndex)
+			if _, err := table.IndexMetaPageNum("email"); err == nil {
+				t.Error("expected error for an unknown index")
+			}
+			metaPageNum, err := table.IndexMetaPageNum("name")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = table.OpenIndex(nameIndex, metaPageNum); err == nil {
+				t.Error("expected error for an index that exists")
+			}
+			unique := IndexDefinition{Name: "unique", Columns: []string{"name"}, Unique: true}
+			if err = table.OpenIndex(unique, metaPageNum); err == nil {
+				t.Error("expected error for a definition that does not match the index")
+			}
+			nocase := IndexDefinition{
+				Name: "nocase", Columns: []string{"name"}, Collation: "nocase",
+			}
+			if err = table.OpenIndex(nocase, metaPageNum); err == nil {
+				t.Error("expected error for a collation that does not match the index")
+			}
+			if err = table.OpenIndex(emailIndex, metaPageNum); err == nil {
+				t.Error("expected error for a meta page of another kind of index")
+			}
+			if len(table.Indexes()) != 1 {
+				t.Errorf("expected 1 index, got %d", len(table.Indexes()))
+			}
+		},
+	)
+}
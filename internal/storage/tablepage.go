@@ -34,6 +34,19 @@ func (e *RecordDeletedError) Error() string {
 	return fmt.Sprintf("record at slot=%d has been deleted", e.SlotNum)
 }
 
+// InvalidForwardedAddressError is returned when a record is forwarded to an address on page 0 of
+// file 0, which cannot be told apart from an offset within a page.
+type InvalidForwardedAddressError struct {
+	Address RecordAddress
+}
+
+func (e *InvalidForwardedAddressError) Error() string {
+	return fmt.Sprintf(
+		"cannot forward record to file=%d page=%d slot=%d", e.Address.FileID, e.Address.PageNum,
+		e.Address.SlotNum,
+	)
+}
+
 // slotEntry is the value stored in each slot of a TablePage. It can store the offset of a record
 // within a page or the forwarded address of the record within a file. The first 2 bytes represent
 // the file number and the next 4 bytes represent the page number. The last 4 bytes represent the
@@ -59,11 +72,11 @@ func slotEntryToRecordAddress(offset slotEntry) RecordAddress {
 	}
 }
 
-// isForwardedAddress returns true if a slotEntry represents a forwarded address. If the first two
-// bytes are max uint16, then the slot entry is a not a forwarded address. We use max uint16 because
-// the file ID is stored as uint16, but no file ID is ever max uint16.
+// isForwardedAddress returns true if a slotEntry represents a forwarded address. Offsets within a
+// page are less than PageSize and fit in the last 2 bytes, so a slot entry with any other bit set
+// is a forwarded address. As a result, records cannot be forwarded to page 0 of file 0.
 func (s slotEntry) isForwardedAddress() bool {
-	return s>>48 == 0xffff
+	return s>>16 != 0
 }
 
 // setNumSlots sets the number of slots in the page.
@@ -112,26 +125,33 @@ func NewTablePage() *TablePage {
 }
 
 // AddRecord adds a record to the page. It returns the slot number of the record.
+//
+// A compacted copy of the record is stored, as by Compact, so that no slack bytes are stored. The
+// given record is not changed.
 func (p *TablePage) AddRecord(record *Record) (uint16, error) {
+	compacted := *record
+	compacted.Compact()
+	record = &compacted
+
 	// Get the free offset.
 	offset := p.getFreeOffset()
 
 	// Get the number of slots.
 	numSlots := p.getNumSlots()
 
-	// Calculate the new free offset.
-	newOffset := offset - record.Length()
-
 	// Check if the page has enough space for the record.
 	headerLength := 4 + 8*numSlots
 	newHeaderEnd := headerLength + 8
-	if newOffset < newHeaderEnd {
+	if uint32(offset) < uint32(newHeaderEnd)+record.Length() {
 		return 0, &PageFullError{
-			Available: offset - newHeaderEnd,
+			Available: uint32(offset - newHeaderEnd),
 			Needed:    record.Length(),
 		}
 	}
 
+	// Calculate the new free offset.
+	newOffset := offset - uint16(record.Length())
+
 	// Write the record to the page.
 	copy(p[newOffset:offset], *record)
 
@@ -150,7 +170,8 @@ func (p *TablePage) AddRecord(record *Record) (uint16, error) {
 // If the record is no longer on the page but has been moved to another page then the second return
 // value is set to a non-nil address value instead.
 //
-// If the record has been deleted then RecordDeletedError is returned.
+// If the record has been deleted then RecordDeletedError is returned. If the bytes of the record
+// are damaged then CorruptRecordError is returned.
 func (p *TablePage) GetRecord(slotNum uint16) (*Record, *RecordAddress, error) {
 	// Get the slot entry value.
 	entry := p.getSlot(slotNum)
@@ -167,14 +188,35 @@ func (p *TablePage) GetRecord(slotNum uint16) (*Record, *RecordAddress, error) {
 	}
 
 	// Otherwise return the record at the entry.
-	recordLength := binary.LittleEndian.Uint16(p[entry : entry+2])
-	record := Record(p[entry : uint16(entry)+recordLength])
+	record, err := p.recordAt(entry)
+	if err != nil {
+		return nil, nil, err
+	}
 	return &record, nil, nil
 }
 
-// SetForwardedAddress sets the slot entry to a forwarded address.
-func (p *TablePage) SetForwardedAddress(slotNum uint16, addr RecordAddress) {
-	p.setSlot(slotNum, recordAddressToSlotEntry(addr))
+// recordAt returns the record stored at the offset of the given slot entry, after checking that
+// its header is intact. CorruptRecordError is returned if it is not.
+func (p *TablePage) recordAt(entry slotEntry) (Record, error) {
+	if entry >= PageSize {
+		return nil, &CorruptRecordError{Offset: uint32(entry), Reason: "offset past end of page"}
+	}
+	record := Record(p[entry:])
+	if err := record.Validate(); err != nil {
+		return nil, err
+	}
+	return record[:record.Length()], nil
+}
+
+// SetForwardedAddress sets the slot entry to a forwarded address. InvalidForwardedAddressError is
+// returned if the address is on page 0 of file 0.
+func (p *TablePage) SetForwardedAddress(slotNum uint16, addr RecordAddress) error {
+	entry := recordAddressToSlotEntry(addr)
+	if !entry.isForwardedAddress() {
+		return &InvalidForwardedAddressError{addr}
+	}
+	p.setSlot(slotNum, entry)
+	return nil
 }
 
 // UpdateRecord updates a record at the given slot number.
@@ -183,13 +225,20 @@ func (p *TablePage) SetForwardedAddress(slotNum uint16, addr RecordAddress) {
 // value is set to a non-nil address value instead. This new address should be used to update the
 // record.
 //
-// If the record has been deleted then RecordDeletedError is returned.
+// If the record has been deleted then RecordDeletedError is returned. If the bytes of the stored
+// record are damaged then CorruptRecordError is returned.
 //
 // If the updated record is too large to fit on the page then PageFullError is returned. When
 // PageFullError is returned the record is not updated. It is the caller's responsibility to add the
 // updated record to a new page and update the record's slot entry to its new address on this page
 // using the SetForwardedAddress method.
+//
+// As in AddRecord, a compacted copy of the record is stored and the given record is not changed.
 func (p *TablePage) UpdateRecord(slotNum uint16, record *Record) (*RecordAddress, error) {
+	compacted := *record
+	compacted.Compact()
+	record = &compacted
+
 	// Get the slot entry value.
 	entry := p.getSlot(slotNum)
 
@@ -205,29 +254,33 @@ func (p *TablePage) UpdateRecord(slotNum uint16, record *Record) (*RecordAddress
 	}
 
 	// Otherwise update the record at the entry.
-	recordLength := binary.LittleEndian.Uint16(p[entry : entry+2])
+	existing, err := p.recordAt(entry)
+	if err != nil {
+		return nil, err
+	}
+	recordLength := existing.Length()
 	if recordLength < record.Length() {
 		// If the new record is larger than the existing one, then we need to move the record to a
 		// new location on the page and update the slot entry.
 		offset := p.getFreeOffset()
-		newOffset := offset - record.Length()
 
 		// Check if the page has enough space for the record.
 		numSlots := p.getNumSlots()
 		headerLength := 4 + 8*numSlots
 		newHeaderEnd := headerLength + 8
-		if newOffset < newHeaderEnd {
+		if uint32(offset) < uint32(newHeaderEnd)+record.Length() {
 			return nil, &PageFullError{
-				Available: offset - newHeaderEnd,
+				Available: uint32(offset - newHeaderEnd),
 				Needed:    record.Length(),
 			}
 		}
 
-		copy(p[newOffset:], *record)
+		newOffset := offset - uint16(record.Length())
+		copy(p[newOffset:offset], *record)
 		p.setSlot(slotNum, slotEntry(newOffset))
 		p.setFreeOffset(newOffset)
 	}
-	copy(p[entry:uint16(entry)+recordLength], *record)
+	copy(p[entry:uint32(entry)+recordLength], *record)
 	return nil, nil
 }
 
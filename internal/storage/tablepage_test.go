@@ -17,8 +17,9 @@ func TestTablePage_AddRecord(t *testing.T) {
 			}
 			err = r1.SetMap(
 				1, element.Map{
-					element.StringType,
-					element.Int32Type, map[any]any{"a": 1, "b": 2},
+					KeyType:   element.StringType,
+					ValueType: element.Int32Type,
+					Data:      map[any]any{"a": 1, "b": 2},
 				},
 			)
 			if err != nil {
@@ -29,7 +30,10 @@ func TestTablePage_AddRecord(t *testing.T) {
 			r2.SetInt32(0, 123)
 			err = r2.SetArray(
 				1,
-				element.Array{element.StringType, []any{"foo", "bar", "hello", "world"}},
+				element.Array{
+					ElementType: element.StringType,
+					Values:      []any{"foo", "bar", "hello", "world"},
+				},
 			)
 			if err != nil {
 				t.Error(err)
@@ -99,6 +103,106 @@ func TestTablePage_AddRecord(t *testing.T) {
 			}
 		},
 	)
+
+	t.Run(
+		"check record larger than a page", func(t *testing.T) {
+			page := NewTablePage()
+
+			r := NewRecordWithEncoding(1, element.WideEncoding)
+			err := r.SetString(0, string(make([]byte, 2*PageSize)))
+			if err != nil {
+				t.Error(err)
+			}
+
+			_, err = page.AddRecord(r)
+			if err == nil {
+				t.Error("expected page full error")
+			}
+		},
+	)
+
+	t.Run(
+		"check wide record", func(t *testing.T) {
+			page := NewTablePage()
+
+			r := NewRecordWithEncoding(2, element.WideEncoding)
+			err := r.SetString(0, "hello")
+			if err != nil {
+				t.Error(err)
+			}
+			r.SetInt64(1, -1)
+
+			slot, err := page.AddRecord(r)
+			if err != nil {
+				t.Error(err)
+			}
+			got, _, err := page.GetRecord(slot)
+			if err != nil {
+				t.Error(err)
+			}
+			if !reflect.DeepEqual(got, r) {
+				t.Errorf("expected record %v, got %v", r, got)
+			}
+		},
+	)
+
+	t.Run(
+		"check a compacted copy is stored", func(t *testing.T) {
+			page := NewTablePage()
+
+			r := NewRecord(3)
+			err := r.SetString(2, "hello")
+			if err != nil {
+				t.Error(err)
+			}
+			r.SetUint32(0, 7)
+			*r = append(*r, 0, 0, 0)
+			before := append(Record(nil), *r...)
+			compacted := append(Record(nil), *r...)
+			compacted.Compact()
+
+			slot, err := page.AddRecord(r)
+			if err != nil {
+				t.Error(err)
+			}
+			if !reflect.DeepEqual(*r, before) {
+				t.Errorf("expected record %v, got %v", before, *r)
+			}
+			got, _, err := page.GetRecord(slot)
+			if err != nil {
+				t.Error(err)
+			}
+			if !reflect.DeepEqual(*got, compacted) {
+				t.Errorf("expected record %v, got %v", compacted, *got)
+			}
+			if offset := page.getFreeOffset(); int(offset) != PageSize-len(compacted) {
+				t.Errorf("expected %d bytes stored, got %d", len(compacted), PageSize-int(offset))
+			}
+
+			r = NewRecord(3)
+			err = r.SetString(2, "hello world")
+			if err != nil {
+				t.Error(err)
+			}
+			r.SetUint32(0, 8)
+			before = append(Record(nil), *r...)
+			compacted = append(Record(nil), *r...)
+			compacted.Compact()
+			if _, err = page.UpdateRecord(slot, r); err != nil {
+				t.Error(err)
+			}
+			if !reflect.DeepEqual(*r, before) {
+				t.Errorf("expected record %v, got %v", before, *r)
+			}
+			got, _, err = page.GetRecord(slot)
+			if err != nil {
+				t.Error(err)
+			}
+			if !reflect.DeepEqual(*got, compacted) {
+				t.Errorf("expected record %v, got %v", compacted, *got)
+			}
+		},
+	)
 }
 
 func TestTablePage_GetRecord(t *testing.T) {
@@ -111,8 +215,9 @@ func TestTablePage_GetRecord(t *testing.T) {
 			}
 			err = r1.SetMap(
 				1, element.Map{
-					element.StringType,
-					element.Int32Type, map[any]any{"a": 1, "b": 2},
+					KeyType:   element.StringType,
+					ValueType: element.Int32Type,
+					Data:      map[any]any{"a": 1, "b": 2},
 				},
 			)
 			if err != nil {
@@ -123,7 +228,10 @@ func TestTablePage_GetRecord(t *testing.T) {
 			r2.SetInt32(0, 123)
 			err = r2.SetArray(
 				1,
-				element.Array{element.StringType, []any{"foo", "bar", "hello", "world"}},
+				element.Array{
+					ElementType: element.StringType,
+					Values:      []any{"foo", "bar", "hello", "world"},
+				},
 			)
 			if err != nil {
 				t.Error(err)
@@ -176,6 +284,30 @@ func TestTablePage_GetRecord(t *testing.T) {
 			}
 		},
 	)
+
+	t.Run(
+		"check corrupt record", func(t *testing.T) {
+			r := NewRecord(1)
+			err := r.SetString(0, "hello")
+			if err != nil {
+				t.Error(err)
+			}
+
+			page := NewTablePage()
+			slotNum, err := page.AddRecord(r)
+			if err != nil {
+				t.Error(err)
+			}
+			entry := page.getSlot(slotNum)
+			page[entry] = 0xff
+			page[entry+1] = 0xff
+
+			_, _, err = page.GetRecord(slotNum)
+			if _, ok := err.(*CorruptRecordError); !ok {
+				t.Errorf("expected CorruptRecordError, got %v", err)
+			}
+		},
+	)
 }
 
 func TestTablePage_SetForwardedAddress(t *testing.T) {
@@ -190,13 +322,55 @@ func TestTablePage_SetForwardedAddress(t *testing.T) {
 				return
 			}
 			want := RecordAddress{PageAddress: PageAddress{FileID: 0, PageNum: 1}, SlotNum: 2}
-			page.SetForwardedAddress(slotNum, want)
+			if err = page.SetForwardedAddress(slotNum, want); err != nil {
+				t.Fatal(err)
+			}
 			got := slotEntryToRecordAddress(page.getSlot(slotNum))
 			if got != want {
 				t.Errorf("expected forwarded address %v, got %v", want, got)
 			}
 		},
 	)
+
+	t.Run(
+		"check address on page 0 of file 0 is rejected", func(t *testing.T) {
+			page := NewTablePage()
+			slotNum, err := page.AddRecord(NewRecord(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			address := RecordAddress{SlotNum: 5}
+			err = page.SetForwardedAddress(slotNum, address)
+			if _, ok := err.(*InvalidForwardedAddressError); !ok {
+				t.Errorf("expected InvalidForwardedAddressError, got %v", err)
+			}
+			if _, got, err := page.GetRecord(slotNum); got != nil || err != nil {
+				t.Errorf("expected record to stay on the page, got %v, %v", got, err)
+			}
+		},
+	)
+
+	t.Run(
+		"check forwarded address is returned for record", func(t *testing.T) {
+			page := NewTablePage()
+			slotNum, err := page.AddRecord(NewRecord(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := RecordAddress{PageAddress: PageAddress{FileID: 3, PageNum: 7}, SlotNum: 9}
+			if err = page.SetForwardedAddress(slotNum, want); err != nil {
+				t.Fatal(err)
+			}
+			record, got, err := page.GetRecord(slotNum)
+			if record != nil || got == nil || *got != want || err != nil {
+				t.Errorf("expected forwarded address %v, got %v, %v, %v", want, record, got, err)
+			}
+			got, err = page.UpdateRecord(slotNum, NewRecord(1))
+			if got == nil || *got != want || err != nil {
+				t.Errorf("expected forwarded address %v, got %v, %v", want, got, err)
+			}
+		},
+	)
 }
 
 func TestTablePage_UpdateRecord(t *testing.T) {
@@ -217,13 +391,20 @@ func TestTablePage_UpdateRecord(t *testing.T) {
 			}
 
 			r3 := NewRecord(2)
-			err = r3.SetArray(0, element.Array{element.Int32Type, []any{1, 2, 3}})
+			err = r3.SetArray(0, element.Array{
+				ElementType: element.Int32Type,
+				Values:      []any{1, 2, 3},
+			})
 			if err != nil {
 				t.Error(err)
 			}
 			err = r3.SetMap(
 				1,
-				element.Map{element.StringType, element.Int32Type, map[any]any{"a": 1, "b": 2}},
+				element.Map{
+					KeyType:   element.StringType,
+					ValueType: element.Int32Type,
+					Data:      map[any]any{"a": 1, "b": 2},
+				},
 			)
 			if err != nil {
 				t.Error(err)
@@ -272,13 +453,20 @@ func TestTablePage_UpdateRecord(t *testing.T) {
 				t.Errorf("expected r2 %v, got %v", r2, got)
 			}
 
-			err = r3.SetArray(0, element.Array{element.Int32Type, []any{4, 5, 6}})
+			err = r3.SetArray(0, element.Array{
+				ElementType: element.Int32Type,
+				Values:      []any{4, 5, 6},
+			})
 			if err != nil {
 				t.Error(err)
 			}
 			err = r3.SetMap(
 				1,
-				element.Map{element.StringType, element.Int32Type, map[any]any{"c": 3, "d": 4}},
+				element.Map{
+					KeyType:   element.StringType,
+					ValueType: element.Int32Type,
+					Data:      map[any]any{"c": 3, "d": 4},
+				},
 			)
 			if err != nil {
 				t.Error(err)
@@ -314,13 +502,20 @@ func TestTablePage_UpdateRecord(t *testing.T) {
 			}
 
 			r3 := NewRecord(2)
-			err = r3.SetArray(0, element.Array{element.Int32Type, []any{1, 2, 3}})
+			err = r3.SetArray(0, element.Array{
+				ElementType: element.Int32Type,
+				Values:      []any{1, 2, 3},
+			})
 			if err != nil {
 				t.Error(err)
 			}
 			err = r3.SetMap(
 				1,
-				element.Map{element.StringType, element.Int32Type, map[any]any{"a": 1, "b": 2}},
+				element.Map{
+					KeyType:   element.StringType,
+					ValueType: element.Int32Type,
+					Data:      map[any]any{"a": 1, "b": 2},
+				},
 			)
 			if err != nil {
 				t.Error(err)
@@ -414,6 +609,33 @@ func TestTablePage_UpdateRecord(t *testing.T) {
 			}
 		},
 	)
+
+	t.Run(
+		"check corrupt record", func(t *testing.T) {
+			r := NewRecord(1)
+			if err := r.SetString(0, "hello"); err != nil {
+				t.Fatal(err)
+			}
+			page := NewTablePage()
+			slotNum, err := page.AddRecord(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entry := page.getSlot(slotNum)
+			page[entry] = 0xff
+			page[entry+1] = 0xff
+			_, err = page.UpdateRecord(slotNum, NewRecord(1))
+			if _, ok := err.(*CorruptRecordError); !ok {
+				t.Errorf("expected CorruptRecordError, got %v", err)
+			}
+
+			page.setSlot(slotNum, PageSize+8)
+			_, err = page.UpdateRecord(slotNum, NewRecord(1))
+			if _, ok := err.(*CorruptRecordError); !ok {
+				t.Errorf("expected CorruptRecordError for offset past the page, got %v", err)
+			}
+		},
+	)
 }
 
 func TestTablePage_DeleteRecord(t *testing.T) {
@@ -436,13 +658,20 @@ func TestTablePage_DeleteRecord(t *testing.T) {
 			}
 
 			r3 := NewRecord(2)
-			err = r3.SetArray(0, element.Array{element.Int32Type, []any{1, 2, 3}})
+			err = r3.SetArray(0, element.Array{
+				ElementType: element.Int32Type,
+				Values:      []any{1, 2, 3},
+			})
 			if err != nil {
 				t.Error(err)
 			}
 			err = r3.SetMap(
 				1,
-				element.Map{element.StringType, element.Int32Type, map[any]any{"a": 1, "b": 2}},
+				element.Map{
+					KeyType:   element.StringType,
+					ValueType: element.Int32Type,
+					Data:      map[any]any{"a": 1, "b": 2},
+				},
 			)
 			if err != nil {
 				t.Error(err)
@@ -0,0 +1,555 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+/*
+ * A TxManager groups the page writes to a set of PageFiles into transactions, which are committed
+ * atomically and durably or rolled back as a whole. One transaction writes at a time: Begin waits
+ * until the active transaction is committed or rolled back.
+ *
+ * A transaction reads and writes the pages of a file through a view of the file returned by
+ * Tx.File. Written pages are kept in memory by the view, so the file itself is not changed before
+ * the transaction commits, and rolling the transaction back only discards them. Pages appended by
+ * a transaction are appended to the file as empty pages right away, so that they get their page
+ * numbers; they stay in the file, unused, if the transaction is rolled back.
+ *
+ * Read-only transactions, begun with BeginRead, do not wait for the active transaction: they hold a
+ * snapshot of the transactions committed when they began, through which they read versioned
+ * tables. The manager tracks the snapshots that are held, so that the versions they need are kept.
+ *
+ * Commits are made durable with a redo log, which is a PageFile of its own. Page 0 of the log is
+ * its header: a byte storing txLogHeaderPage, a byte storing the version of the format, 4 bytes
+ * storing the number of pages of the log, 8 bytes storing the ID of the next transaction, 8 bytes
+ * storing the ID of the committed transaction whose pages are stored in the log, or 0 if there is
+ * none, and 4 bytes storing the number of those pages.
+ *
+ * The header is followed by the descriptor pages of the committed pages, each starting with a
+ * byte storing txLogDescriptorPage and 2 bytes storing the number of descriptors on the page. A
+ * descriptor is made of 2 bytes storing the index of the file of a page in the files of the
+ * manager and 4 bytes storing its page number. The descriptor pages are followed by the committed
+ * pages, in the order of their descriptors.
+ *
+ * A transaction is committed in these steps:
+ *  1. The files that the transaction appended pages to are made durable, so that their pages
+ *     exist after a crash.
+ *  2. The pages written by the transaction are written to the log after the header, and the log
+ *     is made durable.
+ *  3. The header is written with the ID of the transaction and the log is made durable. This is
+ *     the point at which the transaction is committed.
+ *  4. The pages are written to their files, and the files are made durable.
+ *  5. The header is written without a committed transaction and the log is made durable.
+ *
+ * OpenTxManager repeats steps 4 and 5 if the header stores a committed transaction, so a crash
+ * at any point leaves the files with either all or none of the writes of a transaction. Files are
+ * made durable with MakeDurable if they implement it, as DatabaseFile does.
+ *
+ * All integers are stored little-endian.
+ */
+
+const (
+	txLogHeaderPage     byte = 'W'
+	txLogDescriptorPage byte = 'J'
+
+	txLogVersion        = 1
+	txDescriptorSize    = 6
+	txDescriptorsOnPage = (PageSize - 3) / txDescriptorSize
+)
+
+// TxManager assigns IDs to transactions over a set of files and commits them through a redo log.
+// A manager is safe for concurrent use.
+type TxManager struct {
+	log    PageFile
+	files  []PageFile
+	active sync.Mutex
+
+	// pages is held for reading while pages of the files are read, and for writing while pages
+	// are appended to them or written to them, by committing or outside transactions.
+	pages sync.RWMutex
+
+	mutex     sync.Mutex
+	indexes   map[PageFile]uint16
+	logPages  uint32
+	nextID    uint64
+	activeID  uint64
+	snapshots map[*snapshot]bool
+	err       error
+}
+
+// Tx is a transaction of a TxManager. It is safe for concurrent use, but must not be used after
+// Commit or Rollback.
+type Tx struct {
+	manager  *TxManager
+	id       uint64
+	readOnly bool
+	snapshot *snapshot
+	mutex    sync.Mutex
+	views    map[PageFile]*txFile
+	order    []*txFile
+	hooks    []func(committed bool) error
+	done     bool
+}
+
+// txFile is the view of a file in a transaction. It implements PageFile.
+type txFile struct {
+	tx       *Tx
+	file     PageFile
+	index    uint16
+	pages    map[uint32]*Page
+	pageNums []uint32
+	appended bool
+}
+
+// TxDoneError is returned when a transaction is used after it was committed or rolled back.
+type TxDoneError struct {
+	ID uint64
+}
+
+// CorruptLogError is returned when the redo log of a TxManager cannot be read.
+type CorruptLogError struct {
+	PageNum uint32
+	Reason  string
+}
+
+func (e *TxDoneError) Error() string {
+	return fmt.Sprintf("transaction %d is already committed or rolled back", e.ID)
+}
+
+func (e *CorruptLogError) Error() string {
+	return fmt.Sprintf("corrupt transaction log page %d: %s", e.PageNum, e.Reason)
+}
+
+// durableFile is implemented by files whose writes can be made durable, such as DatabaseFile.
+type durableFile interface {
+	MakeDurable() error
+}
+
+// makeDurable makes the writes to the given file durable, if it supports it.
+func makeDurable(file PageFile) error {
+	if d, ok := file.(durableFile); ok {
+		return d.MakeDurable()
+	}
+	return nil
+}
+
+// NewTxManager returns a manager of transactions over the given files whose log is stored in the
+// given file, which must be empty. The files must be passed in the same order to OpenTxManager.
+func NewTxManager(log PageFile, files ...PageFile) (*TxManager, error) {
+	m, err := newTxManager(log, files)
+	if err != nil {
+		return nil, err
+	}
+	pageNums, err := log.AppendPages(&[]Page{{}})
+	if err != nil {
+		return nil, err
+	}
+	if pageNums[0] != 0 {
+		return nil, fmt.Errorf("transaction log file must be empty, got %d pages", pageNums[0])
+	}
+	m.logPages, m.nextID = 1, 1
+	if err = m.writeHeader(0, 0); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// OpenTxManager returns a manager of transactions over the given files whose log is stored in the
+// given file, as created by NewTxManager with the same files. The writes of a transaction that
+// was committed but not written to the files before a crash are written to them.
+func OpenTxManager(log PageFile, files ...PageFile) (*TxManager, error) {
+	m, err := newTxManager(log, files)
+	if err != nil {
+		return nil, err
+	}
+	pages, err := log.ReadPages(0, 1)
+	if err != nil {
+		return nil, err
+	}
+	page := &(*pages)[0]
+	if page[0] != txLogHeaderPage {
+		return nil, &CorruptLogError{0, "not a header page"}
+	}
+	if page[1] != txLogVersion {
+		return nil, &CorruptLogError{0, fmt.Sprintf("unknown version %d", page[1])}
+	}
+	m.logPages = binary.LittleEndian.Uint32(page[2:])
+	m.nextID = binary.LittleEndian.Uint64(page[6:])
+	if committed := binary.LittleEndian.Uint64(page[14:]); committed != 0 {
+		if err = m.redo(binary.LittleEndian.Uint32(page[22:])); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// newTxManager returns a manager of transactions over the given files whose log is not read or
+// written yet.
+func newTxManager(log PageFile, files []PageFile) (*TxManager, error) {
+	if len(files) > 0xffff {
+		return nil, fmt.Errorf("too many files for a transaction manager: %d", len(files))
+	}
+	m := &TxManager{
+		log:       log,
+		files:     append([]PageFile(nil), files...),
+		indexes:   make(map[PageFile]uint16, len(files)),
+		snapshots: make(map[*snapshot]bool),
+	}
+	for i, file := range files {
+		if _, ok := m.indexes[file]; ok || file == log {
+			return nil, fmt.Errorf("file %d of the transaction manager is passed twice", i)
+		}
+		m.indexes[file] = uint16(i)
+	}
+	return m, nil
+}
+
+// writeHeader writes the header of the log, storing the ID of the committed transaction whose
+// given number of pages are stored in the log, and makes the log durable.
+func (m *TxManager) writeHeader(committed uint64, numPages uint32) error {
+	var page Page
+	page[0] = txLogHeaderPage
+	page[1] = txLogVersion
+	binary.LittleEndian.PutUint32(page[2:], m.logPages)
+	binary.LittleEndian.PutUint64(page[6:], m.nextID)
+	binary.LittleEndian.PutUint64(page[14:], committed)
+	binary.LittleEndian.PutUint32(page[22:], numPages)
+	if _, err := m.log.WritePages(&[]Page{page}, 0); err != nil {
+		return err
+	}
+	return makeDurable(m.log)
+}
+
+// Begin starts a transaction, waiting until the active transaction, if any, is committed or
+// rolled back. A goroutine must not begin a transaction while it has one that is still active.
+func (m *TxManager) Begin() (*Tx, error) {
+	m.active.Lock()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.err != nil {
+		m.active.Unlock()
+		return nil, m.err
+	}
+	tx := &Tx{manager: m, id: m.nextID, views: make(map[PageFile]*txFile)}
+	tx.snapshot = &snapshot{xmin: tx.id, xmax: tx.id + 1}
+	m.snapshots[tx.snapshot] = true
+	m.activeID = tx.id
+	m.nextID++
+	return tx, nil
+}
+
+// ID returns the ID of the transaction, or 0 for a read-only transaction. IDs increase with every
+// transaction that a manager begins, including across reopening the manager once a transaction
+// that writes was committed.
+func (tx *Tx) ID() uint64 {
+	return tx.id
+}
+
+// File returns the view of the given file of the manager in the transaction. Pages written to the
+// view are only written to the file when the transaction commits.
+func (tx *Tx) File(file PageFile) (PageFile, error) {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+	if tx.done {
+		return nil, &TxDoneError{tx.id}
+	}
+	if tx.readOnly {
+		return nil, fmt.Errorf("read-only transactions cannot write pages")
+	}
+	if view, ok := tx.views[file]; ok {
+		return view, nil
+	}
+	index, ok := tx.manager.indexes[file]
+	if !ok {
+		return nil, fmt.Errorf("file is not managed by the transaction manager")
+	}
+	view := &txFile{tx: tx, file: file, index: index, pages: make(map[uint32]*Page)}
+	tx.views[file] = view
+	tx.order = append(tx.order, view)
+	return view, nil
+}
+
+// onEnd registers a function that is called when the transaction is committed or rolled back,
+// before another transaction can begin.
+func (tx *Tx) onEnd(fn func(committed bool) error) error {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+	if tx.done {
+		return &TxDoneError{tx.id}
+	}
+	tx.hooks = append(tx.hooks, fn)
+	return nil
+}
+
+// check returns a TxDoneError if the transaction was committed or rolled back.
+func (tx *Tx) check() error {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+	if tx.done {
+		return &TxDoneError{tx.id}
+	}
+	return nil
+}
+
+// end marks the transaction as done, or returns a TxDoneError if it already is.
+func (tx *Tx) end() error {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+	if tx.done {
+		return &TxDoneError{tx.id}
+	}
+	tx.done = true
+	return nil
+}
+
+// finish calls the functions registered with onEnd, releases the snapshot of the transaction and
+// lets the next transaction begin. It returns the given error, or else the first error returned
+// by a function.
+func (tx *Tx) finish(committed bool, err error) error {
+	for _, fn := range tx.hooks {
+		if hookErr := fn(committed); err == nil {
+			err = hookErr
+		}
+	}
+	m := tx.manager
+	m.mutex.Lock()
+	delete(m.snapshots, tx.snapshot)
+	if !tx.readOnly {
+		m.activeID = 0
+	}
+	m.mutex.Unlock()
+	if !tx.readOnly {
+		m.active.Unlock()
+	}
+	return err
+}
+
+// Commit writes the pages written by the transaction to their files, atomically and durably. If
+// an error is returned before the transaction is committed, it is rolled back. If it is returned
+// after, the manager fails every later Begin, and the files are brought up to date when the
+// manager is opened again with OpenTxManager.
+func (tx *Tx) Commit() error {
+	if err := tx.end(); err != nil {
+		return err
+	}
+	if tx.readOnly {
+		return tx.finish(true, nil)
+	}
+	committed, err := tx.manager.commit(tx)
+	return tx.finish(committed, err)
+}
+
+// Rollback discards the pages written by the transaction.
+func (tx *Tx) Rollback() error {
+	if err := tx.end(); err != nil {
+		return err
+	}
+	return tx.finish(false, nil)
+}
+
+// commit writes the pages written by the given transaction to their files through the log. It
+// returns true if the transaction was committed.
+func (m *TxManager) commit(tx *Tx) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.err != nil {
+		return false, m.err
+	}
+	var descriptors, images []Page
+	var descriptor *Page
+	for _, view := range tx.order {
+		if view.appended {
+			if err := makeDurable(view.file); err != nil {
+				return false, err
+			}
+		}
+		for _, pageNum := range view.pageNums {
+			if len(images)%txDescriptorsOnPage == 0 {
+				descriptors = append(descriptors, Page{txLogDescriptorPage})
+				descriptor = &descriptors[len(descriptors)-1]
+			}
+			offset := 3 + (len(images)%txDescriptorsOnPage)*txDescriptorSize
+			binary.LittleEndian.PutUint16(descriptor[offset:], view.index)
+			binary.LittleEndian.PutUint32(descriptor[offset+2:], pageNum)
+			binary.LittleEndian.PutUint16(
+				descriptor[1:], uint16(len(images)%txDescriptorsOnPage+1),
+			)
+			images = append(images, *view.pages[pageNum])
+		}
+	}
+	if len(images) == 0 {
+		return true, nil
+	}
+
+	if err := m.writeLog(append(descriptors, images...)); err != nil {
+		return false, err
+	}
+	if err := m.writeHeader(tx.id, uint32(len(images))); err != nil {
+		return false, err
+	}
+	m.pages.Lock()
+	err := m.apply(descriptors, images)
+	m.pages.Unlock()
+	if err != nil {
+		m.err = err
+		return true, err
+	}
+	if err := m.writeHeader(0, 0); err != nil {
+		m.err = err
+		return true, err
+	}
+	return true, nil
+}
+
+// writeLog writes the given pages to the log after its header, and makes the log durable. Pages
+// are appended to the log when it is too short, and kept for the logs of later commits.
+func (m *TxManager) writeLog(pages []Page) error {
+	if missing := 1 + len(pages) - int(m.logPages); missing > 0 {
+		empty := make([]Page, missing)
+		pageNums, err := m.log.AppendPages(&empty)
+		if len(pageNums) > 0 {
+			// Pages appended to the log before a crash are not counted by the header, so the log
+			// may have more pages than it counts.
+			m.logPages = pageNums[len(pageNums)-1] + 1
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := m.log.WritePages(&pages, 1); err != nil {
+		return err
+	}
+	return makeDurable(m.log)
+}
+
+// apply writes the given committed pages to the files that the given descriptor pages list, and
+// makes the files durable.
+func (m *TxManager) apply(descriptors []Page, images []Page) error {
+	written := make([]bool, len(m.files))
+	for i := range images {
+		descriptor := &descriptors[i/txDescriptorsOnPage]
+		offset := 3 + (i%txDescriptorsOnPage)*txDescriptorSize
+		index := binary.LittleEndian.Uint16(descriptor[offset:])
+		if int(index) >= len(m.files) {
+			return &CorruptLogError{
+				uint32(1 + i/txDescriptorsOnPage), fmt.Sprintf("unknown file %d", index),
+			}
+		}
+		pageNum := binary.LittleEndian.Uint32(descriptor[offset+2:])
+		if _, err := m.files[index].WritePages(&[]Page{images[i]}, pageNum); err != nil {
+			return err
+		}
+		written[index] = true
+	}
+	for i, file := range m.files {
+		if written[i] {
+			if err := makeDurable(file); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// redo writes the given number of committed pages stored in the log to their files, and clears
+// the committed transaction from the header of the log.
+func (m *TxManager) redo(numPages uint32) error {
+	numDescriptors := (numPages + txDescriptorsOnPage - 1) / txDescriptorsOnPage
+	if 1+numDescriptors+numPages > m.logPages {
+		return &CorruptLogError{0, fmt.Sprintf("log has too few pages for %d pages", numPages)}
+	}
+	descriptors, err := m.log.ReadPages(1, numDescriptors)
+	if err != nil {
+		return err
+	}
+	for i := range *descriptors {
+		descriptor := &(*descriptors)[i]
+		count := binary.LittleEndian.Uint16(descriptor[1:])
+		expected := numPages - uint32(i)*txDescriptorsOnPage
+		if expected > txDescriptorsOnPage {
+			expected = txDescriptorsOnPage
+		}
+		if descriptor[0] != txLogDescriptorPage || uint32(count) != expected {
+			return &CorruptLogError{uint32(1 + i), "invalid descriptor page"}
+		}
+	}
+	images, err := m.log.ReadPages(1+numDescriptors, numPages)
+	if err != nil {
+		return err
+	}
+	if err = m.apply(*descriptors, *images); err != nil {
+		return err
+	}
+	return m.writeHeader(0, 0)
+}
+
+// ReadPages reads a range of pages of the file, as written by the transaction.
+func (f *txFile) ReadPages(pageNum uint32, numPages uint32) (*[]Page, error) {
+	f.tx.mutex.Lock()
+	defer f.tx.mutex.Unlock()
+	if f.tx.done {
+		return nil, &TxDoneError{f.tx.id}
+	}
+	f.tx.manager.pages.RLock()
+	pages, err := f.file.ReadPages(pageNum, numPages)
+	f.tx.manager.pages.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	for i := range *pages {
+		if page, ok := f.pages[pageNum+uint32(i)]; ok {
+			(*pages)[i] = *page
+		}
+	}
+	return pages, nil
+}
+
+// WritePages writes pages of the file in the transaction. Like the file, it returns an error for
+// pages that are not in the file.
+func (f *txFile) WritePages(pages *[]Page, pageNum uint32) (uint32, error) {
+	f.tx.mutex.Lock()
+	defer f.tx.mutex.Unlock()
+	if f.tx.done {
+		return 0, &TxDoneError{f.tx.id}
+	}
+	for i := range *pages {
+		num := pageNum + uint32(i)
+		if page, ok := f.pages[num]; ok {
+			*page = (*pages)[i]
+			continue
+		}
+		f.tx.manager.pages.RLock()
+		_, err := f.file.ReadPages(num, 1)
+		f.tx.manager.pages.RUnlock()
+		if err != nil {
+			return uint32(i), err
+		}
+		page := (*pages)[i]
+		f.pages[num] = &page
+		f.pageNums = append(f.pageNums, num)
+	}
+	return uint32(len(*pages)), nil
+}
+
+// AppendPages appends empty pages to the file and writes the given pages to them in the
+// transaction.
+func (f *txFile) AppendPages(pages *[]Page) ([]uint32, error) {
+	f.tx.mutex.Lock()
+	defer f.tx.mutex.Unlock()
+	if f.tx.done {
+		return nil, &TxDoneError{f.tx.id}
+	}
+	empty := make([]Page, len(*pages))
+	f.tx.manager.pages.Lock()
+	pageNums, err := f.file.AppendPages(&empty)
+	f.tx.manager.pages.Unlock()
+	for i, num := range pageNums {
+		page := (*pages)[i]
+		f.pages[num] = &page
+		f.pageNums = append(f.pageNums, num)
+		f.appended = true
+	}
+	return pageNums, err
+}
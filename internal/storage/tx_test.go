@@ -0,0 +1,447 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// failingFile is a memoryFile whose page writes fail once fail is set.
+type failingFile struct {
+	memoryFile
+	fail bool
+}
+
+func (f *failingFile) WritePages(pages *[]Page, pageNum uint32) (uint32, error) {
+	if f.fail {
+		return 0, errors.New("write failed")
+	}
+	return f.memoryFile.WritePages(pages, pageNum)
+}
+
+// testPage returns a page filled with the given byte.
+func testPage(b byte) Page {
+	var page Page
+	for i := range page {
+		page[i] = b
+	}
+	return page
+}
+
+// writeTestPages writes a page filled with the page number plus the given byte to each of the
+// given pages of the view.
+func writeTestPages(t *testing.T, view PageFile, pageNums []uint32, b byte) {
+	t.Helper()
+	for _, pageNum := range pageNums {
+		if _, err := view.WritePages(&[]Page{testPage(byte(pageNum) + b)}, pageNum); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkTestPages checks that each of the given pages of the file is filled with the page number
+// plus the given byte.
+func checkTestPages(t *testing.T, file PageFile, pageNums []uint32, b byte) {
+	t.Helper()
+	for _, pageNum := range pageNums {
+		pages, err := file.ReadPages(pageNum, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (*pages)[0] != testPage(byte(pageNum)+b) {
+			t.Fatalf("page %d: expected %d, got %d", pageNum, byte(pageNum)+b, (*pages)[0][0])
+		}
+	}
+}
+
+func TestTxManager(t *testing.T) {
+	t.Run(
+		"check commit", func(t *testing.T) {
+			log, data := &memoryFile{}, &memoryFile{pages: make([]Page, 4)}
+			m, err := NewTxManager(log, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx, err := m.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			view, err := tx.File(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeTestPages(t, view, []uint32{1, 3}, 10)
+			pageNums, err := view.AppendPages(&[]Page{testPage(14), testPage(15)})
+			if err != nil || !reflect.DeepEqual(pageNums, []uint32{4, 5}) {
+				t.Fatalf("expected pages 4 and 5, got %v, %v", pageNums, err)
+			}
+			checkTestPages(t, view, []uint32{1, 3, 4, 5}, 10)
+			for pageNum, page := range data.pages {
+				if page != (Page{}) {
+					t.Errorf("page %d: expected no change before the commit", pageNum)
+				}
+			}
+			if _, err = view.WritePages(&[]Page{{}}, 6); err == nil {
+				t.Error("expected error for a page that is not in the file")
+			}
+
+			if err = tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			checkTestPages(t, data, []uint32{1, 3, 4, 5}, 10)
+			if data.pages[0] != (Page{}) || data.pages[2] != (Page{}) {
+				t.Error("expected pages that were not written to be unchanged")
+			}
+			if committed := binary.LittleEndian.Uint64(log.pages[0][14:]); committed != 0 {
+				t.Errorf("expected no committed transaction in the log, got %d", committed)
+			}
+			if _, ok := tx.Commit().(*TxDoneError); !ok {
+				t.Error("expected TxDoneError")
+			}
+			if _, err = view.ReadPages(0, 1); err == nil {
+				t.Error("expected error for a view of a committed transaction")
+			}
+		},
+	)
+
+	t.Run(
+		"check rollback", func(t *testing.T) {
+			data := &memoryFile{pages: make([]Page, 2)}
+			m, err := NewTxManager(&memoryFile{}, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx, _ := m.Begin()
+			view, _ := tx.File(data)
+			writeTestPages(t, view, []uint32{0, 1}, 1)
+			if err = tx.Rollback(); err != nil {
+				t.Fatal(err)
+			}
+			if data.pages[0] != (Page{}) || data.pages[1] != (Page{}) {
+				t.Error("expected rolled back pages to be unchanged")
+			}
+			if _, ok := tx.Rollback().(*TxDoneError); !ok {
+				t.Error("expected TxDoneError")
+			}
+			if _, err = view.WritePages(&[]Page{{}}, 0); err == nil {
+				t.Error("expected error for a view of a rolled back transaction")
+			}
+			if _, err = tx.File(data); err == nil {
+				t.Error("expected error for a rolled back transaction")
+			}
+
+			next, err := m.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next.ID() != tx.ID()+1 {
+				t.Errorf("expected ID %d, got %d", tx.ID()+1, next.ID())
+			}
+			if _, err = next.File(&memoryFile{}); err == nil {
+				t.Error("expected error for a file that is not managed")
+			}
+		},
+	)
+
+	t.Run(
+		"check many pages", func(t *testing.T) {
+			log, data := &memoryFile{}, &memoryFile{pages: make([]Page, 3000)}
+			m, err := NewTxManager(log, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pageNums := make([]uint32, len(data.pages))
+			for i := range pageNums {
+				pageNums[i] = uint32(i)
+			}
+			for round, numPages := range []int{3000, 10, 2000} {
+				tx, _ := m.Begin()
+				view, _ := tx.File(data)
+				writeTestPages(t, view, pageNums[:numPages], byte(round))
+				if err = tx.Commit(); err != nil {
+					t.Fatal(err)
+				}
+				checkTestPages(t, data, pageNums[:numPages], byte(round))
+			}
+			if len(log.pages) != 1+3+3000 {
+				t.Errorf("expected a header, 3 descriptor and 3000 pages, got %d", len(log.pages))
+			}
+		},
+	)
+
+	t.Run(
+		"check recovery", func(t *testing.T) {
+			log, data := &memoryFile{}, &failingFile{memoryFile: memoryFile{pages: make([]Page, 4)}}
+			m, err := NewTxManager(log, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx, _ := m.Begin()
+			view, _ := tx.File(data)
+			writeTestPages(t, view, []uint32{0, 2}, 5)
+			data.fail = true
+			if err = tx.Commit(); err == nil {
+				t.Fatal("expected error for failed writes")
+			}
+			if _, err = m.Begin(); err == nil {
+				t.Error("expected error for a failed manager")
+			}
+
+			data.fail = false
+			if m, err = OpenTxManager(log, data); err != nil {
+				t.Fatal(err)
+			}
+			checkTestPages(t, data, []uint32{0, 2}, 5)
+			next, err := m.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next.ID() <= tx.ID() {
+				t.Errorf("expected an ID after %d, got %d", tx.ID(), next.ID())
+			}
+			if _, err = OpenTxManager(data, log); err == nil {
+				t.Error("expected error for a file that is not a log")
+			}
+		},
+	)
+
+	t.Run(
+		"check begin waits", func(t *testing.T) {
+			data := &memoryFile{pages: make([]Page, 1)}
+			m, err := NewTxManager(&memoryFile{}, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx, _ := m.Begin()
+			view, _ := tx.File(data)
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				next, err := m.Begin()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				defer next.Rollback()
+				view, _ := next.File(data)
+				checkTestPages(t, view, []uint32{0}, 7)
+			}()
+			writeTestPages(t, view, []uint32{0}, 7)
+			if err = tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			wg.Wait()
+		},
+	)
+}
+
+// newTestTxTable returns a table of testTableSchema with the name and email indexes, and a
+// filter by name, whose file is managed by the returned manager.
+func newTestTxTable(t *testing.T) (*Table, *TxManager) {
+	t.Helper()
+	table := newTestTable(t, nameIndex, emailIndex)
+	err := table.CreateFilter(nil, FilterDefinition{Name: "f", Columns: []string{"name"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewTxManager(&memoryFile{}, table.file.PageFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table, m
+}
+
+func TestTable_Tx(t *testing.T) {
+	t.Run(
+		"check commit and rollback", func(t *testing.T) {
+			table, m := newTestTxTable(t)
+			var addresses []RecordAddress
+			for i := 0; i < 100; i++ {
+				email := fmt.Sprintf("%d@example.com", i)
+				address, err := table.Insert(nil, testTableRecord(t, int64(i), "a", email))
+				if err != nil {
+					t.Fatal(err)
+				}
+				addresses = append(addresses, address)
+			}
+			before := scanTable(t, table)
+			numPages := len(table.file.PageFile.(*memoryFile).pages)
+
+			for _, commit := range []bool{false, true} {
+				tx, err := m.Begin()
+				if err != nil {
+					t.Fatal(err)
+				}
+				for i := 0; i < 500; i++ {
+					email := fmt.Sprintf("new%d@example.com", i)
+					record := testTableRecord(t, int64(i), "b", email)
+					if _, err = table.Insert(tx, record); err != nil {
+						t.Fatal(err)
+					}
+				}
+				bio := strings.Repeat("x", PageSize/2)
+				record := testTableRecordWithBio(t, 0, "c", "0@example.com", bio)
+				if err = table.Update(tx, addresses[0], record); err != nil {
+					t.Fatal(err)
+				}
+				if err = table.Delete(tx, addresses[1]); err != nil {
+					t.Fatal(err)
+				}
+				if found, _ := table.Lookup(tx, "name", []any{"b"}); len(found) != 500 {
+					t.Errorf("expected 500 records in the transaction, got %d", len(found))
+				}
+				if commit {
+					err = tx.Commit()
+				} else {
+					err = tx.Rollback()
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				checkTableIndexes(t, table)
+				records := scanTable(t, table)
+				if !commit {
+					if !reflect.DeepEqual(records, before) || len(table.moved) != 0 {
+						t.Error("expected the records from before the transaction")
+					}
+					if len(table.file.PageFile.(*memoryFile).pages) == numPages {
+						t.Error("expected pages appended by the transaction to stay in the file")
+					}
+					continue
+				}
+				if len(records) != 599 || len(table.moved) != 1 {
+					t.Errorf("expected 599 records and 1 moved, got %d", len(records))
+				}
+				if found, _ := table.Lookup(nil, "name", []any{"c"}); len(found) != 1 {
+					t.Errorf("expected the updated record, got %v", found)
+				}
+				numScanned := 0
+				err = table.ScanKey(
+					nil, "f", []any{"b"}, func(address RecordAddress, record *Record) error {
+						numScanned++
+						return nil
+					},
+				)
+				if err != nil || numScanned != 500 {
+					t.Errorf("expected 500 records, got %d, %v", numScanned, err)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check index and filter changes", func(t *testing.T) {
+			table, m := newTestTxTable(t)
+			_, err := table.Insert(nil, testTableRecord(t, 1, "a", "a@example.com"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			indexes, filters := table.Indexes(), table.Filters()
+			idIndex := IndexDefinition{Name: "id", Columns: []string{"id"}}
+			idFilter := FilterDefinition{Name: "id", Columns: []string{"id"}}
+
+			for _, commit := range []bool{false, true} {
+				tx, err := m.Begin()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err = table.CreateIndex(tx, idIndex); err != nil {
+					t.Fatal(err)
+				}
+				if err = table.CreateFilter(tx, idFilter); err != nil {
+					t.Fatal(err)
+				}
+				if err = table.DropIndex(tx, "name"); err != nil {
+					t.Fatal(err)
+				}
+				if err = table.DropFilter(tx, "f"); err != nil {
+					t.Fatal(err)
+				}
+				address, err := table.Insert(tx, testTableRecord(t, 2, "b", "b@example.com"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				found, err := table.Lookup(tx, "id", []any{int64(2)})
+				if err != nil || !reflect.DeepEqual(found, []RecordAddress{address}) {
+					t.Errorf("expected %v in the transaction, got %v, %v", address, found, err)
+				}
+				if commit {
+					err = tx.Commit()
+				} else {
+					err = tx.Rollback()
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				checkTableIndexes(t, table)
+				if !commit {
+					if !reflect.DeepEqual(table.Indexes(), indexes) ||
+						!reflect.DeepEqual(table.Filters(), filters) {
+						t.Error("expected the indexes and filters from before the transaction")
+					}
+					continue
+				}
+				expected := []IndexDefinition{emailIndex, idIndex}
+				if !reflect.DeepEqual(table.Indexes(), expected) {
+					t.Errorf("expected indexes %v, got %v", expected, table.Indexes())
+				}
+				if !reflect.DeepEqual(table.Filters(), []FilterDefinition{idFilter}) {
+					t.Errorf("expected filter %v, got %v", idFilter, table.Filters())
+				}
+			}
+			tx := m.BeginRead()
+			defer tx.Rollback()
+			if err := table.DropIndex(tx, "id"); err == nil {
+				t.Error("expected error for a read-only transaction")
+			}
+		},
+	)
+
+	t.Run(
+		"check operations wait for the transaction", func(t *testing.T) {
+			table, m := newTestTxTable(t)
+			tx, _ := m.Begin()
+			if _, err := table.Insert(tx, testTableRecord(t, 1, "a", "a@example.com")); err != nil {
+				t.Fatal(err)
+			}
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := table.Insert(nil, testTableRecord(t, 2, "a", "a@example.com"))
+				if _, ok := err.(*DuplicateKeyError); !ok {
+					t.Errorf("expected DuplicateKeyError after the commit, got %v", err)
+				}
+			}()
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			wg.Wait()
+			if _, err := table.Insert(tx, testTableRecord(t, 3, "a", nil)); err == nil {
+				t.Error("expected error for a committed transaction")
+			}
+		},
+	)
+
+	t.Run(
+		"check errors", func(t *testing.T) {
+			table := newTestTable(t)
+			m, err := NewTxManager(&memoryFile{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx, _ := m.Begin()
+			defer tx.Rollback()
+			if _, err = table.Insert(tx, testTableRecord(t, 1, "a", nil)); err == nil {
+				t.Error("expected error for a file that is not managed")
+			}
+		},
+	)
+}
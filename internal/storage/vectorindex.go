@@ -0,0 +1,921 @@
+package storage
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+
+	"kyadb/internal/structs/element"
+)
+
+/*
+ * A VectorIndex is a hierarchical navigable small world (HNSW) graph of vectors, stored in the
+ * pages of a PageFile. Every vector is a node of the graph, which is made of layers: all nodes are
+ * on layer 0, and a node is on the layers up to its level, drawn at random when it is added so
+ * that each layer has about Neighbors times fewer nodes than the one below. On each layer, a node
+ * is linked to up to Neighbors close nodes, or twice as many on layer 0. A search starts from the
+ * entry point, the node with the highest level, descends greedily towards the query through the
+ * upper layers and then explores the closest nodes of layer 0 for the nearest neighbors.
+ *
+ * Deleting a node unlinks it from the graph: every node linked to it selects its neighbors on that
+ * layer again from its other neighbors and those of the deleted node, which keeps the graph
+ * connected around the gap. This scans every node of the index. The deleted node is then marked as
+ * deleted and its slot is reused by the next node added, so an index holds no more nodes than the
+ * most vectors it has stored at once, and updating a vector with Delete and Insert does not grow
+ * it.
+ *
+ * The graph is held in memory, read from the pages of the index when it is opened, and every
+ * change is written through to the pages of the nodes it modifies.
+ *
+ * The meta page of an index starts with a byte storing vectorMetaPage, followed by a byte storing
+ * the version of the format, a byte storing the metric, a byte storing the highest level of the
+ * graph, 2 bytes each storing the number of dimensions, the number of neighbors, EfConstruction
+ * and EfSearch, and 4 bytes each storing the entry point, the page number of the first node page,
+ * the number of nodes and the number of deleted nodes, whose slots are free.
+ *
+ * Nodes are numbered in the order they are added and stored in fixed-size slots on a chain of node
+ * pages. A node page starts with a byte storing vectorNodePage and 4 bytes storing the page
+ * number of the next node page, or noPage. A node slot stores the record address of the node as in
+ * a BTree entry, a byte storing the level of the node, a byte of flags and the values of the
+ * vector as float32 bits, followed by the neighbors of the node on each layer up to vectorMaxLevel:
+ * 2 bytes storing the number of neighbors and room for 4 bytes per neighbor storing its node
+ * number.
+ *
+ * All integers are stored little-endian except for the record addresses.
+ */
+
+// VectorMetric is the distance function of a VectorIndex.
+type VectorMetric uint8
+
+const (
+	// EuclideanDistance is the Euclidean distance between vectors.
+	EuclideanDistance VectorMetric = iota
+
+	// CosineDistance is 1 minus the cosine of the angle between vectors, from 0 for vectors with
+	// the same direction to 2 for opposite vectors. Vectors are normalized when they are added.
+	CosineDistance
+
+	// DotProduct is the negated dot product of vectors, so that vectors with a larger dot product
+	// are closer.
+	DotProduct
+)
+
+const (
+	vectorMetaPage byte = 'V'
+	vectorNodePage byte = 'N'
+
+	vectorVersion = 1
+
+	vectorPageHeaderSize = 5
+	vectorNodeHeaderSize = btreeAddressSize + 2
+	vectorDeletedFlag    = 1 << 0
+
+	// vectorMaxLevel is the highest level of a node. Levels above it are so unlikely that
+	// capping them does not change the shape of the graph.
+	vectorMaxLevel = 4
+
+	// noNode is the node number stored in place of a missing node.
+	noNode = ^uint32(0)
+)
+
+// Defaults of the options of a VectorIndex.
+const (
+	DefaultVectorNeighbors      = 16
+	DefaultVectorEfConstruction = 200
+	DefaultVectorEfSearch       = 50
+)
+
+// VectorIndexOptions describes the vectors of a VectorIndex and how its graph is built.
+type VectorIndexOptions struct {
+	// Dimensions is the number of values of every vector of the index.
+	Dimensions int
+
+	// Metric is the distance function between vectors.
+	Metric VectorMetric
+
+	// Neighbors is the number of neighbors that a node is linked to on each layer but layer 0,
+	// where it is linked to twice as many. More neighbors make searches more accurate and slower.
+	// Zero means DefaultVectorNeighbors.
+	Neighbors int
+
+	// EfConstruction is the number of candidates that the neighbors of a new node are chosen
+	// from. Zero means DefaultVectorEfConstruction.
+	EfConstruction int
+
+	// EfSearch is the minimum number of candidates that the nearest neighbors of a query are
+	// chosen from. Zero means DefaultVectorEfSearch.
+	EfSearch int
+}
+
+// VectorIndex is an HNSW graph of vectors stored in the pages of a PageFile, which finds the
+// records whose vectors are nearest to a query vector. Searches are approximate: a near vector
+// can be missed, more rarely with larger EfSearch. An index is not safe for concurrent use.
+type VectorIndex struct {
+	file        PageFile
+	metaPageNum uint32
+	options     VectorIndexOptions
+	nodeSize    int
+	nodesOnPage int
+	nodePages   []uint32
+	nodes       []*vectorNode
+	addresses   map[RecordAddress]uint32
+	entry       uint32
+	maxLevel    uint8
+	numDeleted  uint32
+	free        []uint32
+}
+
+// VectorMatch is a record found by a search of a VectorIndex, with the distance of its vector to
+// the query.
+type VectorMatch struct {
+	Address  RecordAddress
+	Distance float32
+}
+
+// vectorNode is a node of a VectorIndex.
+type vectorNode struct {
+	address RecordAddress
+	level   uint8
+	deleted bool
+	vector  []float32
+	links   [][]uint32
+}
+
+// vectorCandidate is a node of a VectorIndex along with its distance to a query.
+type vectorCandidate struct {
+	node     uint32
+	distance float32
+}
+
+// candidateHeap is a heap of candidates, with the nearest first, or the farthest if farthest is
+// set.
+type candidateHeap struct {
+	candidates []vectorCandidate
+	farthest   bool
+}
+
+// InvalidVectorError is returned when a vector cannot be stored in or searched for in an index.
+type InvalidVectorError struct {
+	Reason string
+}
+
+func (e *InvalidVectorError) Error() string {
+	return fmt.Sprintf("invalid vector: %s", e.Reason)
+}
+
+func (m VectorMetric) String() string {
+	switch m {
+	case EuclideanDistance:
+		return "euclidean distance"
+	case CosineDistance:
+		return "cosine distance"
+	case DotProduct:
+		return "dot product"
+	}
+	return fmt.Sprintf("VectorMetric(%d)", uint8(m))
+}
+
+func (h *candidateHeap) Len() int {
+	return len(h.candidates)
+}
+
+func (h *candidateHeap) Less(i, j int) bool {
+	if h.farthest {
+		return h.candidates[i].distance > h.candidates[j].distance
+	}
+	return h.candidates[i].distance < h.candidates[j].distance
+}
+
+func (h *candidateHeap) Swap(i, j int) {
+	h.candidates[i], h.candidates[j] = h.candidates[j], h.candidates[i]
+}
+
+func (h *candidateHeap) Push(x any) {
+	h.candidates = append(h.candidates, x.(vectorCandidate))
+}
+
+func (h *candidateHeap) Pop() any {
+	last := h.candidates[len(h.candidates)-1]
+	h.candidates = h.candidates[:len(h.candidates)-1]
+	return last
+}
+
+// withDefaults returns the options with zero values replaced by their defaults, or an error if
+// they are invalid.
+func (o VectorIndexOptions) withDefaults() (VectorIndexOptions, error) {
+	if o.Neighbors == 0 {
+		o.Neighbors = DefaultVectorNeighbors
+	}
+	if o.EfConstruction == 0 {
+		o.EfConstruction = DefaultVectorEfConstruction
+	}
+	if o.EfSearch == 0 {
+		o.EfSearch = DefaultVectorEfSearch
+	}
+	switch {
+	case o.Metric > DotProduct:
+		return o, fmt.Errorf("unknown vector metric %v", o.Metric)
+	case o.Dimensions <= 0:
+		return o, fmt.Errorf("vectors must have at least 1 dimension, got %d", o.Dimensions)
+	case o.Neighbors < 2 || o.Neighbors > math.MaxUint16:
+		return o, fmt.Errorf("number of neighbors must be at least 2, got %d", o.Neighbors)
+	case o.EfConstruction < 1 || o.EfConstruction > math.MaxUint16 ||
+		o.EfSearch < 1 || o.EfSearch > math.MaxUint16:
+		return o, fmt.Errorf(
+			"EfConstruction and EfSearch must be between 1 and %d, got %d and %d",
+			math.MaxUint16, o.EfConstruction, o.EfSearch,
+		)
+	}
+	if vectorNodeSize(o) > PageSize-vectorPageHeaderSize {
+		return o, fmt.Errorf(
+			"nodes of %d dimensions and %d neighbors do not fit on a page",
+			o.Dimensions, o.Neighbors,
+		)
+	}
+	return o, nil
+}
+
+// vectorNodeSize returns the size of a node slot of an index with the given options.
+func vectorNodeSize(options VectorIndexOptions) int {
+	size := vectorNodeHeaderSize + 4*options.Dimensions
+	for level := 0; level <= vectorMaxLevel; level++ {
+		size += 2 + 4*maxVectorNeighbors(options, level)
+	}
+	return size
+}
+
+// maxVectorNeighbors returns the number of neighbors that a node is linked to on the given layer.
+func maxVectorNeighbors(options VectorIndexOptions, level int) int {
+	if level == 0 {
+		return 2 * options.Neighbors
+	}
+	return options.Neighbors
+}
+
+// NewVectorIndex creates an empty index with the given options in the given file. The pages of
+// the index are appended to the file; MetaPageNum returns the page to open the index from later.
+func NewVectorIndex(file PageFile, options VectorIndexOptions) (*VectorIndex, error) {
+	options, err := options.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	pageNums, err := file.AppendPages(&[]Page{{}})
+	if err != nil {
+		return nil, err
+	}
+	x := newVectorIndex(file, pageNums[0], options)
+	return x, x.writeMeta()
+}
+
+// newVectorIndex returns an empty index with the given options and meta page.
+func newVectorIndex(file PageFile, metaPageNum uint32, options VectorIndexOptions) *VectorIndex {
+	nodeSize := vectorNodeSize(options)
+	return &VectorIndex{
+		file:        file,
+		metaPageNum: metaPageNum,
+		options:     options,
+		nodeSize:    nodeSize,
+		nodesOnPage: (PageSize - vectorPageHeaderSize) / nodeSize,
+		addresses:   make(map[RecordAddress]uint32),
+		entry:       noNode,
+	}
+}
+
+// OpenVectorIndex opens the index whose meta page is stored at the given page number of the given
+// file, and reads its graph into memory.
+func OpenVectorIndex(file PageFile, metaPageNum uint32) (*VectorIndex, error) {
+	pages, err := file.ReadPages(metaPageNum, 1)
+	if err != nil {
+		return nil, err
+	}
+	page := &(*pages)[0]
+	if page[0] != vectorMetaPage {
+		return nil, &CorruptIndexError{metaPageNum, "not a meta page"}
+	}
+	if page[1] != vectorVersion {
+		return nil, &CorruptIndexError{metaPageNum, fmt.Sprintf("unknown version %d", page[1])}
+	}
+	options, err := VectorIndexOptions{
+		Metric:         VectorMetric(page[2]),
+		Dimensions:     int(binary.LittleEndian.Uint16(page[4:])),
+		Neighbors:      int(binary.LittleEndian.Uint16(page[6:])),
+		EfConstruction: int(binary.LittleEndian.Uint16(page[8:])),
+		EfSearch:       int(binary.LittleEndian.Uint16(page[10:])),
+	}.withDefaults()
+	if err != nil {
+		return nil, &CorruptIndexError{metaPageNum, err.Error()}
+	}
+	x := newVectorIndex(file, metaPageNum, options)
+	x.maxLevel = page[3]
+	x.entry = binary.LittleEndian.Uint32(page[12:])
+	numNodes := binary.LittleEndian.Uint32(page[20:])
+	x.numDeleted = binary.LittleEndian.Uint32(page[24:])
+	if x.maxLevel > vectorMaxLevel || x.numDeleted > numNodes ||
+		(x.entry == noNode) != (numNodes == x.numDeleted) ||
+		(x.entry != noNode && x.entry >= numNodes) {
+		return nil, &CorruptIndexError{metaPageNum, "invalid graph"}
+	}
+
+	x.nodes = make([]*vectorNode, 0, numNodes)
+	for pageNum := binary.LittleEndian.Uint32(page[16:]); pageNum != noPage; {
+		if len(x.nodes) == int(numNodes) {
+			return nil, &CorruptIndexError{pageNum, "too many node pages"}
+		}
+		pages, err := file.ReadPages(pageNum, 1)
+		if err != nil {
+			return nil, err
+		}
+		page := &(*pages)[0]
+		if page[0] != vectorNodePage {
+			return nil, &CorruptIndexError{pageNum, "not a node page"}
+		}
+		x.nodePages = append(x.nodePages, pageNum)
+		for i := 0; i < x.nodesOnPage && len(x.nodes) < int(numNodes); i++ {
+			offset := vectorPageHeaderSize + i*x.nodeSize
+			n, err := x.decodeNode(page[offset:offset+x.nodeSize], numNodes)
+			if err != nil {
+				return nil, &CorruptIndexError{pageNum, err.Error()}
+			}
+			if n.deleted {
+				x.free = append(x.free, uint32(len(x.nodes)))
+			} else {
+				x.addresses[n.address] = uint32(len(x.nodes))
+			}
+			x.nodes = append(x.nodes, n)
+		}
+		pageNum = binary.LittleEndian.Uint32(page[1:])
+	}
+	if len(x.nodes) != int(numNodes) || len(x.addresses) != int(numNodes-x.numDeleted) {
+		return nil, &CorruptIndexError{metaPageNum, "missing or duplicate nodes"}
+	}
+	if x.entry != noNode && (x.nodes[x.entry].level != x.maxLevel || x.nodes[x.entry].deleted) {
+		return nil, &CorruptIndexError{metaPageNum, "entry point is not on the highest level"}
+	}
+	return x, nil
+}
+
+// MetaPageNum returns the number of the page that the index can be opened from with
+// OpenVectorIndex.
+func (x *VectorIndex) MetaPageNum() uint32 {
+	return x.metaPageNum
+}
+
+// Length returns the number of vectors in the index.
+func (x *VectorIndex) Length() int {
+	return len(x.addresses)
+}
+
+// Options returns the options of the index, with defaults filled in.
+func (x *VectorIndex) Options() VectorIndexOptions {
+	return x.options
+}
+
+// writeMeta writes the meta page of the index.
+func (x *VectorIndex) writeMeta() error {
+	var page Page
+	page[0] = vectorMetaPage
+	page[1] = vectorVersion
+	page[2] = byte(x.options.Metric)
+	page[3] = x.maxLevel
+	binary.LittleEndian.PutUint16(page[4:], uint16(x.options.Dimensions))
+	binary.LittleEndian.PutUint16(page[6:], uint16(x.options.Neighbors))
+	binary.LittleEndian.PutUint16(page[8:], uint16(x.options.EfConstruction))
+	binary.LittleEndian.PutUint16(page[10:], uint16(x.options.EfSearch))
+	binary.LittleEndian.PutUint32(page[12:], x.entry)
+	firstPage := noPage
+	if len(x.nodePages) > 0 {
+		firstPage = x.nodePages[0]
+	}
+	binary.LittleEndian.PutUint32(page[16:], firstPage)
+	binary.LittleEndian.PutUint32(page[20:], uint32(len(x.nodes)))
+	binary.LittleEndian.PutUint32(page[24:], x.numDeleted)
+	_, err := x.file.WritePages(&[]Page{page}, x.metaPageNum)
+	return err
+}
+
+// encodeNode stores the given node in the given slot.
+func (x *VectorIndex) encodeNode(slot []byte, n *vectorNode) {
+	copy(slot, appendAddress(nil, n.address))
+	slot[btreeAddressSize] = n.level
+	if n.deleted {
+		slot[btreeAddressSize+1] = vectorDeletedFlag
+	}
+	offset := vectorNodeHeaderSize
+	for _, value := range n.vector {
+		binary.LittleEndian.PutUint32(slot[offset:], math.Float32bits(value))
+		offset += 4
+	}
+	for level := 0; level <= vectorMaxLevel; level++ {
+		var links []uint32
+		if level <= int(n.level) {
+			links = n.links[level]
+		}
+		binary.LittleEndian.PutUint16(slot[offset:], uint16(len(links)))
+		for i, link := range links {
+			binary.LittleEndian.PutUint32(slot[offset+2+4*i:], link)
+		}
+		offset += 2 + 4*maxVectorNeighbors(x.options, level)
+	}
+}
+
+// decodeNode returns the node stored in the given slot of an index with the given number of
+// nodes.
+func (x *VectorIndex) decodeNode(slot []byte, numNodes uint32) (*vectorNode, error) {
+	n := &vectorNode{
+		address: decodeAddress(slot[:btreeAddressSize]),
+		level:   slot[btreeAddressSize],
+		deleted: slot[btreeAddressSize+1]&vectorDeletedFlag != 0,
+		vector:  make([]float32, x.options.Dimensions),
+	}
+	if n.level > vectorMaxLevel {
+		return nil, fmt.Errorf("invalid node level %d", n.level)
+	}
+	offset := vectorNodeHeaderSize
+	for i := range n.vector {
+		n.vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(slot[offset:]))
+		offset += 4
+	}
+	n.links = make([][]uint32, n.level+1)
+	for level := range n.links {
+		count := int(binary.LittleEndian.Uint16(slot[offset:]))
+		if count > maxVectorNeighbors(x.options, level) {
+			return nil, fmt.Errorf("too many neighbors on layer %d", level)
+		}
+		n.links[level] = make([]uint32, count)
+		for i := range n.links[level] {
+			link := binary.LittleEndian.Uint32(slot[offset+2+4*i:])
+			if link >= numNodes {
+				return nil, fmt.Errorf("invalid neighbor %d", link)
+			}
+			n.links[level][i] = link
+		}
+		offset += 2 + 4*maxVectorNeighbors(x.options, level)
+	}
+	return n, nil
+}
+
+// writeNodes writes the pages of the given nodes, and the meta page of the index.
+func (x *VectorIndex) writeNodes(nodes map[uint32]bool) error {
+	pageIndexes := make(map[int]bool)
+	for node := range nodes {
+		pageIndexes[int(node)/x.nodesOnPage] = true
+	}
+	for len(x.nodePages)*x.nodesOnPage < len(x.nodes) {
+		pageNums, err := x.file.AppendPages(&[]Page{{}})
+		if err != nil {
+			return err
+		}
+		if len(x.nodePages) > 0 {
+			// The previous page links to the new one.
+			pageIndexes[len(x.nodePages)-1] = true
+		}
+		pageIndexes[len(x.nodePages)] = true
+		x.nodePages = append(x.nodePages, pageNums[0])
+	}
+
+	sorted := make([]int, 0, len(pageIndexes))
+	for i := range pageIndexes {
+		sorted = append(sorted, i)
+	}
+	sort.Ints(sorted)
+	for _, i := range sorted {
+		var page Page
+		page[0] = vectorNodePage
+		next := noPage
+		if i+1 < len(x.nodePages) {
+			next = x.nodePages[i+1]
+		}
+		binary.LittleEndian.PutUint32(page[1:], next)
+		for j := 0; j < x.nodesOnPage && i*x.nodesOnPage+j < len(x.nodes); j++ {
+			offset := vectorPageHeaderSize + j*x.nodeSize
+			x.encodeNode(page[offset:offset+x.nodeSize], x.nodes[i*x.nodesOnPage+j])
+		}
+		if _, err := x.file.WritePages(&[]Page{page}, x.nodePages[i]); err != nil {
+			return err
+		}
+	}
+	return x.writeMeta()
+}
+
+// prepare returns the given vector as stored in the index, or an error if it cannot be stored.
+func (x *VectorIndex) prepare(vector []float32) ([]float32, error) {
+	if len(vector) != x.options.Dimensions {
+		return nil, &InvalidVectorError{
+			fmt.Sprintf("expected %d dimensions, got %d", x.options.Dimensions, len(vector)),
+		}
+	}
+	prepared := make([]float32, len(vector))
+	var norm float64
+	for i, value := range vector {
+		if math.IsNaN(float64(value)) || math.IsInf(float64(value), 0) {
+			return nil, &InvalidVectorError{fmt.Sprintf("value %v at %d", value, i)}
+		}
+		prepared[i] = value
+		norm += float64(value) * float64(value)
+	}
+	if x.options.Metric == CosineDistance {
+		if norm == 0 {
+			return nil, &InvalidVectorError{"zero vector has no direction"}
+		}
+		norm = math.Sqrt(norm)
+		for i := range prepared {
+			prepared[i] = float32(float64(prepared[i]) / norm)
+		}
+	}
+	return prepared, nil
+}
+
+// distance returns the distance between two prepared vectors as used to compare distances: the
+// Euclidean distance is squared.
+func (x *VectorIndex) distance(a, b []float32) float32 {
+	var sum float32
+	if x.options.Metric == EuclideanDistance {
+		for i := range a {
+			d := a[i] - b[i]
+			sum += d * d
+		}
+		return sum
+	}
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	if x.options.Metric == CosineDistance {
+		return 1 - sum
+	}
+	return -sum
+}
+
+// vectorLevel returns the level of the node with the given number, drawn from an exponentially
+// decaying distribution by hashing the node number, so that each level has about neighbors times
+// fewer nodes than the one below.
+func vectorLevel(node uint32, neighbors int) uint8 {
+	// splitmix64 spreads consecutive numbers uniformly over 64 bits.
+	z := uint64(node) + 0x9e3779b97f4a7c15
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	z ^= z >> 31
+	u := (float64(z>>11) + 0.5) / (1 << 53)
+	level := -math.Log(u) / math.Log(float64(neighbors))
+	if level >= vectorMaxLevel {
+		return vectorMaxLevel
+	}
+	return uint8(level)
+}
+
+// closest returns the node nearest to the given vector found by moving greedily from the given
+// node to nearer neighbors on the given layer.
+func (x *VectorIndex) closest(vector []float32, node uint32, level int) vectorCandidate {
+	best := vectorCandidate{node, x.distance(vector, x.nodes[node].vector)}
+	for moved := true; moved; {
+		moved = false
+		for _, link := range x.nodes[best.node].links[level] {
+			if d := x.distance(vector, x.nodes[link].vector); d < best.distance {
+				best = vectorCandidate{link, d}
+				moved = true
+			}
+		}
+	}
+	return best
+}
+
+// searchLayer returns up to ef nodes nearest to the given vector found by exploring the given
+// layer from the given nodes, ordered by distance.
+func (x *VectorIndex) searchLayer(
+	vector []float32, from []uint32, ef int, level int,
+) []vectorCandidate {
+	visited := make(map[uint32]bool)
+	candidates := &candidateHeap{}
+	found := &candidateHeap{farthest: true}
+	add := func(node uint32, distance float32) {
+		heap.Push(candidates, vectorCandidate{node, distance})
+		heap.Push(found, vectorCandidate{node, distance})
+		if found.Len() > ef {
+			heap.Pop(found)
+		}
+	}
+	for _, node := range from {
+		visited[node] = true
+		add(node, x.distance(vector, x.nodes[node].vector))
+	}
+	for candidates.Len() > 0 {
+		c := heap.Pop(candidates).(vectorCandidate)
+		if found.Len() >= ef && c.distance > found.candidates[0].distance {
+			break
+		}
+		for _, link := range x.nodes[c.node].links[level] {
+			if visited[link] {
+				continue
+			}
+			visited[link] = true
+			d := x.distance(vector, x.nodes[link].vector)
+			if found.Len() < ef || d < found.candidates[0].distance {
+				add(link, d)
+			}
+		}
+	}
+	x.sortCandidates(found.candidates)
+	return found.candidates
+}
+
+// sortCandidates sorts the given candidates by distance, and then by record address.
+func (x *VectorIndex) sortCandidates(candidates []vectorCandidate) {
+	sort.Slice(
+		candidates, func(i, j int) bool {
+			if candidates[i].distance != candidates[j].distance {
+				return candidates[i].distance < candidates[j].distance
+			}
+			a, b := x.nodes[candidates[i].node].address, x.nodes[candidates[j].node].address
+			return addressLess(a, b)
+		},
+	)
+}
+
+// selectNeighbors returns up to max of the given candidates, sorted by distance, as neighbors. A
+// candidate is preferred if it is nearer than every neighbor selected before it, which spreads
+// neighbors in all directions; the others fill the remaining places.
+func (x *VectorIndex) selectNeighbors(candidates []vectorCandidate, max int) []uint32 {
+	var selected []uint32
+	var skipped []uint32
+	for _, c := range candidates {
+		if len(selected) == max {
+			break
+		}
+		preferred := true
+		for _, other := range selected {
+			d := x.distance(x.nodes[c.node].vector, x.nodes[other].vector)
+			if d < c.distance {
+				preferred = false
+				break
+			}
+		}
+		if preferred {
+			selected = append(selected, c.node)
+		} else {
+			skipped = append(skipped, c.node)
+		}
+	}
+	for _, node := range skipped {
+		if len(selected) == max {
+			break
+		}
+		selected = append(selected, node)
+	}
+	return selected
+}
+
+// link adds a link from a node to another on the given layer. If the node has too many neighbors,
+// they are selected again.
+func (x *VectorIndex) link(from uint32, to uint32, level int) {
+	n := x.nodes[from]
+	n.links[level] = append(n.links[level], to)
+	if len(n.links[level]) > maxVectorNeighbors(x.options, level) {
+		x.relink(from, n.links[level], level)
+	}
+}
+
+// relink selects the neighbors of a node on the given layer again from the given nodes.
+func (x *VectorIndex) relink(from uint32, nodes []uint32, level int) {
+	n := x.nodes[from]
+	candidates := make([]vectorCandidate, len(nodes))
+	for i, node := range nodes {
+		candidates[i] = vectorCandidate{node, x.distance(n.vector, x.nodes[node].vector)}
+	}
+	x.sortCandidates(candidates)
+	n.links[level] = x.selectNeighbors(candidates, maxVectorNeighbors(x.options, level))
+}
+
+// unlink removes the link from a node to a deleted node on the given layer, if any, and selects
+// the neighbors of the node again from its other neighbors and those of the deleted node. It
+// returns true if the node was linked to the deleted node.
+func (x *VectorIndex) unlink(from uint32, deleted uint32, level int) bool {
+	links := x.nodes[from].links[level]
+	i := 0
+	for i < len(links) && links[i] != deleted {
+		i++
+	}
+	if i == len(links) {
+		return false
+	}
+	seen := map[uint32]bool{from: true, deleted: true}
+	var nodes []uint32
+	for _, node := range append(links, x.nodes[deleted].links[level]...) {
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	x.relink(from, nodes, level)
+	return true
+}
+
+// Insert adds the given vector to the index for the record with the given address. A
+// DuplicateKeyError is returned if the index already stores a vector for the record.
+func (x *VectorIndex) Insert(address RecordAddress, vector []float32) error {
+	if _, ok := x.addresses[address]; ok {
+		return &DuplicateKeyError{[]any{address}}
+	}
+	prepared, err := x.prepare(vector)
+	if err != nil {
+		return err
+	}
+	node := uint32(len(x.nodes))
+	if len(x.free) > 0 {
+		node = x.free[len(x.free)-1]
+	} else if node == noNode {
+		return fmt.Errorf("vector index is full")
+	}
+	n := &vectorNode{
+		address: address,
+		level:   vectorLevel(node, x.options.Neighbors),
+		vector:  prepared,
+	}
+	n.links = make([][]uint32, n.level+1)
+	if len(x.free) > 0 {
+		x.free = x.free[:len(x.free)-1]
+		x.nodes[node] = n
+		x.numDeleted--
+	} else {
+		x.nodes = append(x.nodes, n)
+	}
+	x.addresses[address] = node
+	modified := map[uint32]bool{node: true}
+
+	if x.entry != noNode {
+		entry := x.entry
+		for level := int(x.maxLevel); level > int(n.level); level-- {
+			entry = x.closest(prepared, entry, level).node
+		}
+		from := []uint32{entry}
+		for level := int(min8(n.level, x.maxLevel)); level >= 0; level-- {
+			found := x.searchLayer(prepared, from, x.options.EfConstruction, level)
+			n.links[level] = x.selectNeighbors(found, x.options.Neighbors)
+			for _, neighbor := range n.links[level] {
+				x.link(neighbor, node, level)
+				modified[neighbor] = true
+			}
+			from = from[:0]
+			for _, c := range found {
+				from = append(from, c.node)
+			}
+		}
+	}
+	if x.entry == noNode || n.level > x.maxLevel {
+		x.entry = node
+		x.maxLevel = n.level
+	}
+	return x.writeNodes(modified)
+}
+
+// min8 returns the smaller of two levels.
+func min8(a, b uint8) uint8 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Delete removes the vector of the record with the given address from the index, unlinking its
+// node from the graph so that its slot can be reused. It returns false if the index does not store
+// a vector for the record.
+func (x *VectorIndex) Delete(address RecordAddress) (bool, error) {
+	node, ok := x.addresses[address]
+	if !ok {
+		return false, nil
+	}
+	n := x.nodes[node]
+	modified := map[uint32]bool{node: true}
+	for other, o := range x.nodes {
+		if o.deleted || uint32(other) == node {
+			continue
+		}
+		for level := 0; level <= int(min8(o.level, n.level)); level++ {
+			if x.unlink(uint32(other), node, level) {
+				modified[uint32(other)] = true
+			}
+		}
+	}
+	n.deleted = true
+	for level := range n.links {
+		n.links[level] = []uint32{}
+	}
+	delete(x.addresses, address)
+	x.free = append(x.free, node)
+	x.numDeleted++
+	if node == x.entry {
+		x.entry, x.maxLevel = noNode, 0
+		for other, o := range x.nodes {
+			if !o.deleted && (x.entry == noNode || o.level > x.maxLevel) {
+				x.entry, x.maxLevel = uint32(other), o.level
+			}
+		}
+	}
+	return true, x.writeNodes(modified)
+}
+
+// Search returns the k records whose vectors are nearest to the given vector, ordered by distance
+// and then by address. Distances are given by the metric of the index.
+func (x *VectorIndex) Search(vector []float32, k int) ([]VectorMatch, error) {
+	prepared, err := x.prepare(vector)
+	if err != nil || k <= 0 || x.entry == noNode {
+		return nil, err
+	}
+	entry := x.entry
+	for level := int(x.maxLevel); level > 0; level-- {
+		entry = x.closest(prepared, entry, level).node
+	}
+	ef := x.options.EfSearch
+	if k > ef {
+		ef = k
+	}
+	found := x.searchLayer(prepared, []uint32{entry}, ef, 0)
+	if len(found) > k {
+		found = found[:k]
+	}
+	matches := make([]VectorMatch, len(found))
+	for i, c := range found {
+		distance := c.distance
+		if x.options.Metric == EuclideanDistance {
+			distance = float32(math.Sqrt(float64(distance)))
+		}
+		matches[i] = VectorMatch{x.nodes[c.node].address, distance}
+	}
+	return matches, nil
+}
+
+// vectorKey returns the key that a vector index of a table stores for the given value of its
+// column: the vector encoded as little-endian float32 bits, which can be compared as a key, or nil
+// for a null value.
+func vectorKey(value any) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	array, ok := value.(element.Array)
+	if !ok || array.ElementType != element.Float32Type {
+		actual, _ := element.TypeForValue(value)
+		if ok {
+			actual = array.ElementType
+		}
+		return nil, &element.TypeMismatchError{Expected: element.Float32Type, Actual: actual}
+	}
+	key := make([]byte, 4*len(array.Values))
+	for i, value := range array.Values {
+		f, ok := value.(float32)
+		if !ok {
+			return nil, &InvalidVectorError{fmt.Sprintf("null value at %d", i)}
+		}
+		binary.LittleEndian.PutUint32(key[4*i:], math.Float32bits(f))
+	}
+	return key, nil
+}
+
+// keyVector returns the vector encoded in a key returned by vectorKey.
+func keyVector(key []byte) []float32 {
+	vector := make([]float32, len(key)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(key[4*i:]))
+	}
+	return vector
+}
+
+// checkVector returns an error if the definition of the given vector index cannot be used with
+// the schema of the table.
+func (t *Table) checkVector(index *tableIndex) error {
+	definition := &index.definition
+	if len(definition.Columns) != 1 {
+		return fmt.Errorf("vector index must have 1 column, got %d", len(definition.Columns))
+	}
+	if definition.Unique || definition.Hash || definition.Elements != WholeValues ||
+		len(definition.Orders) > 0 || definition.Analyzer != nil {
+		return fmt.Errorf(
+			"vector index cannot be unique, hash, multi-valued, full-text or have sort orders",
+		)
+	}
+	field := t.schema[index.positions[0]]
+	if field.Type != element.ArrayType {
+		return &element.TypeMismatchError{Expected: element.ArrayType, Actual: field.Type}
+	}
+	if field.Elem != nil && field.Elem.Type != element.Float32Type {
+		return &element.TypeMismatchError{Expected: element.Float32Type, Actual: field.Elem.Type}
+	}
+	return nil
+}
+
+// Nearest returns the home addresses of the k records whose vectors are nearest to the given
+// vector in the vector index with the given name, with their distances, as returned by
+// VectorIndex.Search.
+func (t *Table) Nearest(tx *Tx, name string, vector []float32, k int) ([]VectorMatch, error) {
+	if err := t.lockQuery(tx); err != nil {
+		return nil, err
+	}
+	defer t.mutex.Unlock()
+	index, err := t.readyIndex(name)
+	if err != nil {
+		return nil, err
+	}
+	if index.vector == nil {
+		return nil, fmt.Errorf("index '%s' is not a vector index", name)
+	}
+	return index.vector.Search(vector, k)
+}
@@ -0,0 +1,535 @@
+package storage
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"kyadb/internal/structs/element"
+)
+
+// randomVectors returns n random vectors with the given number of dimensions.
+func randomVectors(r *rand.Rand, n int, dimensions int) [][]float32 {
+	vectors := make([][]float32, n)
+	for i := range vectors {
+		vectors[i] = make([]float32, dimensions)
+		for j := range vectors[i] {
+			vectors[i][j] = r.Float32()*2 - 1
+		}
+	}
+	return vectors
+}
+
+// newTestVectorIndex returns an index with the given options storing the given vectors, where
+// vector i is stored for testAddress(i).
+func newTestVectorIndex(
+	t *testing.T, options VectorIndexOptions, vectors [][]float32,
+) *VectorIndex {
+	t.Helper()
+	x, err := NewVectorIndex(&memoryFile{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, vector := range vectors {
+		if err = x.Insert(testAddress(i), vector); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return x
+}
+
+// exactNearest returns the indexes of the k vectors nearest to the query by Euclidean distance,
+// leaving out the given indexes.
+func exactNearest(vectors [][]float32, query []float32, k int, excluded map[int]bool) []int {
+	var nearest []int
+	distances := make([]float64, len(vectors))
+	for i, vector := range vectors {
+		for j := range vector {
+			d := float64(vector[j] - query[j])
+			distances[i] += d * d
+		}
+		if !excluded[i] {
+			nearest = append(nearest, i)
+		}
+	}
+	sort.Slice(
+		nearest, func(i, j int) bool {
+			return distances[nearest[i]] < distances[nearest[j]]
+		},
+	)
+	return nearest[:k]
+}
+
+// checkRecall checks that searches of the index find at least the given fraction of the exact
+// nearest neighbors of random queries.
+func checkRecall(
+	t *testing.T, x *VectorIndex, vectors [][]float32, excluded map[int]bool, minRecall float64,
+) {
+	t.Helper()
+	r := rand.New(rand.NewSource(7))
+	found, total := 0, 0
+	for _, query := range randomVectors(r, 50, x.Options().Dimensions) {
+		matches, err := x.Search(query, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		matched := make(map[RecordAddress]bool)
+		for i, match := range matches {
+			matched[match.Address] = true
+			if i > 0 && match.Distance < matches[i-1].Distance {
+				t.Fatalf("matches are not ordered by distance: %v", matches)
+			}
+		}
+		for _, i := range exactNearest(vectors, query, 10, excluded) {
+			if matched[testAddress(i)] {
+				found++
+			}
+			total++
+		}
+	}
+	if recall := float64(found) / float64(total); recall < minRecall {
+		t.Errorf("expected recall of at least %v, got %v", minRecall, recall)
+	}
+}
+
+func TestVectorIndex_Search(t *testing.T) {
+	t.Run(
+		"check recall", func(t *testing.T) {
+			r := rand.New(rand.NewSource(42))
+			vectors := randomVectors(r, 2000, 16)
+			x := newTestVectorIndex(t, VectorIndexOptions{Dimensions: 16}, vectors)
+			if x.Length() != 2000 || x.maxLevel == 0 {
+				t.Errorf("expected 2000 vectors on several layers, got %d", x.Length())
+			}
+			checkRecall(t, x, vectors, nil, 0.95)
+
+			matches, _ := x.Search(vectors[123], 1)
+			if len(matches) != 1 || matches[0].Address != testAddress(123) ||
+				matches[0].Distance != 0 {
+				t.Errorf("expected the vector itself, got %v", matches)
+			}
+		},
+	)
+
+	t.Run(
+		"check metrics", func(t *testing.T) {
+			vectors := [][]float32{{1, 0}, {0, 2}, {-3, 0}, {1, 1}}
+			tests := []struct {
+				metric    VectorMetric
+				expected  []RecordAddress
+				distances []float32
+			}{
+				{
+					EuclideanDistance, testAddresses(0, 3, 1, 2),
+					[]float32{1, float32(math.Sqrt2), float32(math.Sqrt(8)), 5},
+				},
+				{
+					CosineDistance, testAddresses(0, 3, 1, 2),
+					[]float32{0, 1 - float32(math.Sqrt(0.5)), 1, 2},
+				},
+				{DotProduct, testAddresses(0, 3, 1, 2), []float32{-2, -2, 0, 6}},
+			}
+			for _, test := range tests {
+				options := VectorIndexOptions{Dimensions: 2, Metric: test.metric}
+				x := newTestVectorIndex(t, options, vectors)
+				matches, err := x.Search([]float32{2, 0}, 10)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var addresses []RecordAddress
+				for i, match := range matches {
+					addresses = append(addresses, match.Address)
+					if math.Abs(float64(match.Distance-test.distances[i])) > 1e-6 {
+						t.Errorf(
+							"%v: expected distance %v, got %v",
+							test.metric, test.distances[i], match.Distance,
+						)
+					}
+				}
+				if !reflect.DeepEqual(addresses, test.expected) {
+					t.Errorf("%v: expected %v, got %v", test.metric, test.expected, addresses)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check invalid vectors", func(t *testing.T) {
+			options := VectorIndexOptions{Dimensions: 3, Metric: CosineDistance}
+			x, _ := NewVectorIndex(&memoryFile{}, options)
+			invalid := [][]float32{
+				{1, 2}, {1, 2, 3, 4}, {0, 0, 0}, {float32(math.NaN()), 0, 1},
+				{float32(math.Inf(1)), 0, 1},
+			}
+			for _, vector := range invalid {
+				err := x.Insert(testAddress(0), vector)
+				if _, ok := err.(*InvalidVectorError); !ok {
+					t.Errorf("%v: expected InvalidVectorError, got %v", vector, err)
+				}
+			}
+			if _, err := x.Search([]float32{1}, 1); err == nil {
+				t.Error("expected error for query of 1 dimension")
+			}
+			if matches, err := x.Search([]float32{1, 0, 0}, 5); len(matches) != 0 || err != nil {
+				t.Errorf("expected no matches in empty index, got %v, %v", matches, err)
+			}
+			_ = x.Insert(testAddress(0), []float32{1, 0, 0})
+			if err := x.Insert(testAddress(0), []float32{0, 1, 0}); err == nil {
+				t.Error("expected error for duplicate address")
+			} else if _, ok := err.(*DuplicateKeyError); !ok {
+				t.Errorf("expected DuplicateKeyError, got %v", err)
+			}
+		},
+	)
+
+	t.Run(
+		"check invalid options", func(t *testing.T) {
+			options := []VectorIndexOptions{
+				{},
+				{Dimensions: 4, Metric: 3},
+				{Dimensions: 4, Neighbors: 1},
+				{Dimensions: 4, EfSearch: -1},
+				{Dimensions: 2000},
+			}
+			for _, o := range options {
+				if _, err := NewVectorIndex(&memoryFile{}, o); err == nil {
+					t.Errorf("%+v: expected error", o)
+				}
+			}
+		},
+	)
+}
+
+func TestVectorIndex_Delete(t *testing.T) {
+	t.Run(
+		"check delete", func(t *testing.T) {
+			r := rand.New(rand.NewSource(1))
+			vectors := randomVectors(r, 1000, 8)
+			x := newTestVectorIndex(t, VectorIndexOptions{Dimensions: 8}, vectors)
+			excluded := make(map[int]bool)
+			for i := 0; i < 1000; i += 3 {
+				deleted, err := x.Delete(testAddress(i))
+				if err != nil || !deleted {
+					t.Fatalf("%d: expected vector to be deleted, got %v, %v", i, deleted, err)
+				}
+				excluded[i] = true
+			}
+			if deleted, _ := x.Delete(testAddress(0)); deleted {
+				t.Error("expected vector to be deleted only once")
+			}
+			if x.Length() != 666 {
+				t.Errorf("expected 666 vectors, got %d", x.Length())
+			}
+			checkRecall(t, x, vectors, excluded, 0.9)
+			matches, _ := x.Search(vectors[0], 1000)
+			for _, match := range matches {
+				if match.Address == testAddress(0) {
+					t.Fatal("deleted vector found")
+				}
+			}
+			if len(matches) != 666 {
+				t.Errorf("expected all 666 vectors, got %d", len(matches))
+			}
+
+			// Vectors inserted after deletes are found.
+			if err := x.Insert(testAddress(0), vectors[0]); err != nil {
+				t.Fatal(err)
+			}
+			matches, _ = x.Search(vectors[0], 1)
+			if len(matches) != 1 || matches[0].Address != testAddress(0) {
+				t.Errorf("expected %v, got %v", testAddress(0), matches)
+			}
+		},
+	)
+
+	t.Run(
+		"check updates reuse deleted nodes", func(t *testing.T) {
+			r := rand.New(rand.NewSource(2))
+			vectors := randomVectors(r, 500, 8)
+			x := newTestVectorIndex(t, VectorIndexOptions{Dimensions: 8}, vectors)
+			numPages := len(x.nodePages)
+			for round := 0; round < 4; round++ {
+				updated := randomVectors(r, 500, 8)
+				for i := round; i < 500; i += 2 {
+					if deleted, err := x.Delete(testAddress(i)); err != nil || !deleted {
+						t.Fatalf("%d: expected vector to be deleted, got %v, %v", i, deleted, err)
+					}
+					if err := x.Insert(testAddress(i), updated[i]); err != nil {
+						t.Fatal(err)
+					}
+					vectors[i] = updated[i]
+				}
+			}
+			if len(x.nodes) != 500 || len(x.nodePages) != numPages || x.numDeleted != 0 {
+				t.Errorf(
+					"expected 500 nodes on %d pages, got %d on %d with %d deleted",
+					numPages, len(x.nodes), len(x.nodePages), x.numDeleted,
+				)
+			}
+			checkRecall(t, x, vectors, nil, 0.9)
+
+			for i := 0; i < 500; i += 4 {
+				_, _ = x.Delete(testAddress(i))
+			}
+			for node, n := range x.nodes {
+				for level, links := range n.links {
+					for _, link := range links {
+						if x.nodes[link].deleted {
+							t.Fatalf("node %d links to deleted node %d on %d", node, link, level)
+						}
+					}
+				}
+			}
+			if x.nodes[x.entry].deleted {
+				t.Error("expected the entry point not to be deleted")
+			}
+		},
+	)
+
+	t.Run(
+		"check insert after deleting all vectors", func(t *testing.T) {
+			vectors := [][]float32{{1, 1}, {2, 2}, {3, 3}}
+			x := newTestVectorIndex(t, VectorIndexOptions{Dimensions: 2}, vectors)
+			for i := range vectors {
+				_, _ = x.Delete(testAddress(i))
+			}
+			if matches, _ := x.Search([]float32{1, 1}, 3); len(matches) != 0 {
+				t.Errorf("expected no matches, got %v", matches)
+			}
+			if _, err := OpenVectorIndex(x.file, x.MetaPageNum()); err != nil {
+				t.Errorf("expected an index without vectors to open, got %v", err)
+			}
+			_ = x.Insert(testAddress(5), []float32{9, 9})
+			matches, _ := x.Search([]float32{1, 1}, 3)
+			if len(matches) != 1 || matches[0].Address != testAddress(5) {
+				t.Errorf("expected %v, got %v", testAddress(5), matches)
+			}
+			if len(x.nodes) != 3 {
+				t.Errorf("expected a deleted node to be reused, got %d nodes", len(x.nodes))
+			}
+		},
+	)
+}
+
+func TestOpenVectorIndex(t *testing.T) {
+	t.Run(
+		"check reopen", func(t *testing.T) {
+			r := rand.New(rand.NewSource(3))
+			vectors := randomVectors(r, 500, 32)
+			options := VectorIndexOptions{Dimensions: 32, Metric: CosineDistance, Neighbors: 8}
+			x := newTestVectorIndex(t, options, vectors)
+			for i := 0; i < 500; i += 5 {
+				_, _ = x.Delete(testAddress(i))
+			}
+			if len(x.nodePages) < 2 {
+				t.Fatalf("expected several node pages, got %d", len(x.nodePages))
+			}
+			reopened, err := OpenVectorIndex(x.file, x.MetaPageNum())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(reopened.nodes, x.nodes) ||
+				!reflect.DeepEqual(reopened.addresses, x.addresses) ||
+				reopened.entry != x.entry || reopened.maxLevel != x.maxLevel ||
+				reopened.Options() != x.Options() {
+				t.Error("reopened index differs")
+			}
+			for _, query := range randomVectors(r, 10, 32) {
+				expected, _ := x.Search(query, 5)
+				matches, err := reopened.Search(query, 5)
+				if err != nil || !reflect.DeepEqual(matches, expected) {
+					t.Errorf("expected %v, got %v, %v", expected, matches, err)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check corrupt index", func(t *testing.T) {
+			x := newTestVectorIndex(t, VectorIndexOptions{Dimensions: 2}, [][]float32{{1, 2}})
+			if _, err := OpenVectorIndex(x.file, x.nodePages[0]); err == nil {
+				t.Error("expected error for node page")
+			}
+			file := x.file.(*memoryFile)
+			// The first node claims a neighbor that does not exist.
+			links := vectorPageHeaderSize + vectorNodeHeaderSize + 8
+			file.pages[x.nodePages[0]][links] = 1
+			file.pages[x.nodePages[0]][links+2] = 5
+			_, err := OpenVectorIndex(x.file, x.MetaPageNum())
+			if _, ok := err.(*CorruptIndexError); !ok {
+				t.Errorf("expected CorruptIndexError, got %v", err)
+			}
+		},
+	)
+}
+
+var testVectorSchema = Schema{
+	{Name: "id", ValueType: ValueType{Type: element.Int64Type}},
+	{
+		Name:      "embedding",
+		ValueType: ValueType{Type: element.ArrayType, Elem: &ValueType{Type: element.Float32Type}},
+	},
+}
+
+// testVectorRecord returns a record of testVectorSchema. A nil vector is stored as null.
+func testVectorRecord(t *testing.T, id int64, vector []float32) *Record {
+	t.Helper()
+	r := NewRecord(uint16(len(testVectorSchema)))
+	values := []any{id, nil}
+	if vector != nil {
+		array := element.Array{ElementType: element.Float32Type}
+		for _, value := range vector {
+			array.Values = append(array.Values, value)
+		}
+		values[1] = array
+	}
+	for i, value := range values {
+		if err := r.setValue(ElementPosition(i), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func TestTable_Nearest(t *testing.T) {
+	embeddingIndex := IndexDefinition{
+		Name: "embedding", Columns: []string{"embedding"},
+		Vector: &VectorIndexOptions{Dimensions: 2},
+	}
+
+	t.Run(
+		"check index maintained on writes", func(t *testing.T) {
+			table, err := OpenTable(testVectorSchema, &memoryFile{}, 1, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = table.CreateIndex(nil, embeddingIndex); err != nil {
+				t.Fatal(err)
+			}
+			var addresses []RecordAddress
+			for i, vector := range [][]float32{{0, 0}, {1, 0}, nil, {5, 5}} {
+				address, err := table.Insert(nil, testVectorRecord(t, int64(i), vector))
+				if err != nil {
+					t.Fatal(err)
+				}
+				addresses = append(addresses, address)
+			}
+			matches, err := table.Nearest(nil, "embedding", []float32{1, 1}, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := []VectorMatch{{addresses[1], 1}, {addresses[0], float32(math.Sqrt2)}}
+			if !reflect.DeepEqual(matches, expected) {
+				t.Errorf("expected %v, got %v", expected, matches)
+			}
+
+			record := testVectorRecord(t, 3, []float32{1, 2})
+			if err = table.Update(nil, addresses[3], record); err != nil {
+				t.Fatal(err)
+			}
+			if err = table.Delete(nil, addresses[1]); err != nil {
+				t.Fatal(err)
+			}
+			matches, _ = table.Nearest(nil, "embedding", []float32{1, 1}, 5)
+			expected = []VectorMatch{{addresses[3], 1}, {addresses[0], float32(math.Sqrt2)}}
+			if !reflect.DeepEqual(matches, expected) {
+				t.Errorf("expected %v, got %v", expected, matches)
+			}
+
+			_, err = table.Insert(nil, testVectorRecord(t, 4, []float32{1, 2, 3}))
+			if _, ok := err.(*InvalidVectorError); !ok {
+				t.Errorf("expected InvalidVectorError, got %v", err)
+			}
+			if records := scanTable(t, table); len(records) != 3 {
+				t.Errorf("expected 3 records, got %d", len(records))
+			}
+		},
+	)
+
+	t.Run(
+		"check index opened again", func(t *testing.T) {
+			table, err := OpenTable(testVectorSchema, &memoryFile{}, 1, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = table.CreateIndex(nil, embeddingIndex); err != nil {
+				t.Fatal(err)
+			}
+			for i, vector := range [][]float32{{0, 0}, {1, 0}, {5, 5}} {
+				if _, err = table.Insert(nil, testVectorRecord(t, int64(i), vector)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, _ := table.Nearest(nil, "embedding", []float32{1, 1}, 2)
+			metaPageNum, err := table.IndexMetaPageNum("embedding")
+			if err != nil {
+				t.Fatal(err)
+			}
+			reopened, err := OpenTable(
+				testVectorSchema, table.file.PageFile, 1, table.pageNums,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			definition := IndexDefinition{
+				Name: "embedding", Columns: []string{"embedding"}, Vector: &VectorIndexOptions{},
+			}
+			if err = reopened.OpenIndex(definition, metaPageNum); err != nil {
+				t.Fatal(err)
+			}
+			matches, err := reopened.Nearest(nil, "embedding", []float32{1, 1}, 2)
+			if err != nil || !reflect.DeepEqual(matches, expected) {
+				t.Errorf("expected %v, got %v, %v", expected, matches, err)
+			}
+			if options := reopened.Indexes()[0].Vector; options.Dimensions != 2 {
+				t.Errorf("expected the options of the stored index, got %v", options)
+			}
+		},
+	)
+
+	t.Run(
+		"check index of existing records", func(t *testing.T) {
+			table, _ := OpenTable(testVectorSchema, &memoryFile{}, 1, nil)
+			for i := 0; i < 100; i++ {
+				record := testVectorRecord(t, int64(i), []float32{float32(i), 0})
+				if _, err := table.Insert(nil, record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := table.CreateIndex(nil, embeddingIndex); err != nil {
+				t.Fatal(err)
+			}
+			matches, err := table.Nearest(nil, "embedding", []float32{41.9, 1}, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			record, _ := table.Get(nil, matches[0].Address)
+			if _, id, _ := record.GetInt64(0); id != 42 {
+				t.Errorf("expected record 42, got %d", id)
+			}
+		},
+	)
+
+	t.Run(
+		"check invalid definitions", func(t *testing.T) {
+			table, _ := OpenTable(testVectorSchema, &memoryFile{}, 1, nil)
+			options := &VectorIndexOptions{Dimensions: 2}
+			definitions := []IndexDefinition{
+				{Name: "a", Columns: []string{"id"}, Vector: options},
+				{Name: "b", Columns: []string{"embedding", "id"}, Vector: options},
+				{Name: "c", Columns: []string{"embedding"}, Vector: options, Unique: true},
+				{Name: "d", Columns: []string{"embedding"}, Vector: &VectorIndexOptions{}},
+			}
+			for _, definition := range definitions {
+				if err := table.CreateIndex(nil, definition); err == nil {
+					t.Errorf("%s: expected error", definition.Name)
+				}
+			}
+			if _, err := table.Nearest(nil, "a", []float32{1, 1}, 1); err == nil {
+				t.Error("expected error for unknown index")
+			}
+		},
+	)
+}
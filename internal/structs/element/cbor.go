@@ -0,0 +1,625 @@
+package element
+
+import (
+	"fmt"
+	"math"
+	"time"
+	"unicode/utf8"
+)
+
+/*
+ * Values are encoded in CBOR as follows:
+ *   - int64 values use the unsigned and negative integer major types, and integers written without
+ *     a tag by other encoders decode as int64, or as uint64 if they are larger than math.MaxInt64
+ *   - float32 and float64 values use single and double precision floats; half precision floats
+ *     written by other encoders decode as float32
+ *   - nulls, bools, strings, byte slices, arrays and maps use the matching CBOR major types, with
+ *     map entries in key order; indefinite length strings, arrays and maps are decoded
+ *   - time.Time values use tag 0 with an RFC 3339 string in UTC; tag 1 is also decoded
+ *   - UUIDs use tag 37 with the 16 bytes of the UUID
+ *   - Dates use tag 100 with the number of days since 1970-01-01; tag 1004 is also decoded
+ *   - the other integer types, Decimal, Duration and ZonedTime values have no CBOR equivalent that
+ *     keeps their type. They use tag 27513 with an array holding the element type byte followed by
+ *     the parts of the value: the integer for integer types, the unscaled value, precision and
+ *     scale for Decimal, the number of nanoseconds for Duration and an RFC 3339 string with the
+ *     offset of the time zone for ZonedTime
+ * Other tags are ignored when decoding, and the tagged value is decoded as if it had no tag.
+ */
+
+const (
+	cborFormat = "CBOR"
+
+	cborUnsigned = 0
+	cborNegative = 1
+	cborBytes    = 2
+	cborString   = 3
+	cborArray    = 4
+	cborMap      = 5
+	cborTag      = 6
+	cborSimple   = 7
+
+	cborIndefinite = 31
+
+	cborTimeStringTag = 0
+	cborEpochTimeTag  = 1
+	cborUUIDTag       = 37
+	cborDaysTag       = 100
+	cborDateStringTag = 1004
+	cborTypedValueTag = 27513
+)
+
+// cborBreak is returned by readCBORItem for the break code that ends an indefinite length item.
+type cborBreak struct{}
+
+// EncodeCBOR returns the CBOR encoding of the given value, which can be of any element type.
+func EncodeCBOR(value any) ([]byte, error) {
+	return appendCBOR(nil, value, 0)
+}
+
+// DecodeCBOR returns the value encoded in the given CBOR data, which must hold exactly one value.
+// DecodeError is returned if the data is malformed.
+func DecodeCBOR(data []byte) (any, error) {
+	r := &codecReader{format: cborFormat, data: data}
+	value, err := r.readCBOR(0)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.checkEnd(); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// appendCBORHead appends the initial byte of an item of the given major type and its argument, in
+// the fewest bytes possible.
+func appendCBORHead(b []byte, major byte, argument uint64) []byte {
+	switch {
+	case argument < 24:
+		return append(b, major<<5|byte(argument))
+	case argument <= math.MaxUint8:
+		return append(b, major<<5|24, byte(argument))
+	case argument <= math.MaxUint16:
+		return appendUint(append(b, major<<5|25), argument, 2)
+	case argument <= math.MaxUint32:
+		return appendUint(append(b, major<<5|26), argument, 4)
+	}
+	return appendUint(append(b, major<<5|27), argument, 8)
+}
+
+func appendCBORInt(b []byte, value int64) []byte {
+	if value < 0 {
+		return appendCBORHead(b, cborNegative, uint64(-1-value))
+	}
+	return appendCBORHead(b, cborUnsigned, uint64(value))
+}
+
+// appendCBORTyped appends a value of the given type with tag 27513.
+func appendCBORTyped(b []byte, elemType Type, parts ...any) ([]byte, error) {
+	b = appendCBORHead(b, cborTag, cborTypedValueTag)
+	b = appendCBORHead(b, cborArray, uint64(len(parts)+1))
+	b = appendCBORHead(b, cborUnsigned, uint64(elemType))
+	for _, part := range parts {
+		var err error
+		switch p := part.(type) {
+		case uint64:
+			b = appendCBORHead(b, cborUnsigned, p)
+		case int64:
+			b = appendCBORInt(b, p)
+		default:
+			if b, err = appendCBOR(b, part, 0); err != nil {
+				return b, err
+			}
+		}
+	}
+	return b, nil
+}
+
+func appendCBOR(b []byte, value any, depth int) ([]byte, error) {
+	var err error
+	switch v := value.(type) {
+	case nil:
+		b = append(b, cborSimple<<5|22)
+	case bool:
+		if v {
+			b = append(b, cborSimple<<5|21)
+		} else {
+			b = append(b, cborSimple<<5|20)
+		}
+	case uint8:
+		return appendCBORTyped(b, Uint8Type, uint64(v))
+	case uint16:
+		return appendCBORTyped(b, Uint16Type, uint64(v))
+	case uint:
+		return appendCBORTyped(b, Uint32Type, uint64(uint32(v)))
+	case uint32:
+		return appendCBORTyped(b, Uint32Type, uint64(v))
+	case uint64:
+		return appendCBORTyped(b, Uint64Type, v)
+	case int8:
+		return appendCBORTyped(b, Int8Type, int64(v))
+	case int16:
+		return appendCBORTyped(b, Int16Type, int64(v))
+	case int:
+		return appendCBORTyped(b, Int32Type, int64(int32(v)))
+	case int32:
+		return appendCBORTyped(b, Int32Type, int64(v))
+	case int64:
+		b = appendCBORInt(b, v)
+	case float32:
+		b = appendUint(append(b, cborSimple<<5|26), uint64(math.Float32bits(v)), 4)
+	case float64:
+		b = appendUint(append(b, cborSimple<<5|27), math.Float64bits(v), 8)
+	case string:
+		b = append(appendCBORHead(b, cborString, uint64(len(v))), v...)
+	case []byte:
+		b = append(appendCBORHead(b, cborBytes, uint64(len(v))), v...)
+	case UUID:
+		b = appendCBORHead(b, cborTag, cborUUIDTag)
+		b = append(appendCBORHead(b, cborBytes, uint64(len(v))), v[:]...)
+	case Decimal:
+		return appendCBORTyped(
+			b, DecimalType, v.Value, uint64(v.Precision), uint64(v.Scale),
+		)
+	case time.Time:
+		if err = checkRFC3339Year(v.UTC()); err != nil {
+			return b, err
+		}
+		b = appendCBORHead(b, cborTag, cborTimeStringTag)
+		return appendCBOR(b, v.UTC().Format(time.RFC3339Nano), depth)
+	case Date:
+		b = appendCBORInt(appendCBORHead(b, cborTag, cborDaysTag), int64(v))
+	case time.Duration:
+		return appendCBORTyped(b, DurationType, int64(v))
+	case ZonedTime:
+		if err = checkRFC3339Year(v.Time); err != nil {
+			return b, err
+		}
+		return appendCBORTyped(b, ZonedTimeType, v.Format(time.RFC3339Nano))
+	case Array:
+		if depth++; depth > MaxNestingDepth {
+			return b, &NestingDepthError{depth}
+		}
+		b = appendCBORHead(b, cborArray, uint64(len(v.Values)))
+		for _, elem := range v.Values {
+			if err = checkValueType(v.ElementType, elem); err != nil {
+				return b, err
+			}
+			if b, err = appendCBOR(b, elem, depth); err != nil {
+				return b, err
+			}
+		}
+	case Map:
+		if depth++; depth > MaxNestingDepth {
+			return b, &NestingDepthError{depth}
+		}
+		b = appendCBORHead(b, cborMap, uint64(len(v.Data)))
+		for _, key := range SortedKeys(v.Data) {
+			if err = checkValueType(v.KeyType, key); err != nil {
+				return b, err
+			}
+			if err = checkValueType(v.ValueType, v.Data[key]); err != nil {
+				return b, err
+			}
+			if b, err = appendCBOR(b, key, depth); err != nil {
+				return b, err
+			}
+			if b, err = appendCBOR(b, v.Data[key], depth); err != nil {
+				return b, err
+			}
+		}
+	default:
+		return b, fmt.Errorf("unsupported type %T", value)
+	}
+	return b, nil
+}
+
+// readCBOR decodes the next value.
+func (r *codecReader) readCBOR(depth int) (any, error) {
+	start := r.offset
+	value, err := r.readCBORItem(depth, false)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := value.(cborBreak); ok {
+		r.offset = start
+		return nil, r.errorf("unexpected break")
+	}
+	return value, nil
+}
+
+// readCBORHead reads the initial byte of an item and its argument. indefinite is true if the item
+// has an indefinite length, or is the break code.
+func (r *codecReader) readCBORHead() (major byte, argument uint64, indefinite bool, err error) {
+	initial, err := r.readByte()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	major, info := initial>>5, initial&0x1f
+	switch {
+	case info < 24:
+		return major, uint64(info), false, nil
+	case info <= 27:
+		argument, err = r.readUint(1 << (info - 24))
+		return major, argument, false, err
+	case info == cborIndefinite && major != cborUnsigned && major != cborNegative &&
+		major != cborTag:
+		return major, 0, true, nil
+	}
+	r.offset--
+	return 0, 0, false, r.errorf("invalid initial byte 0x%02x", initial)
+}
+
+// readCBORItem decodes the next item, which can be the break code. inTag is true if the item is the
+// content of a tag that is decoded.
+func (r *codecReader) readCBORItem(depth int, inTag bool) (any, error) {
+	start := r.offset
+	major, argument, indefinite, err := r.readCBORHead()
+	if err != nil {
+		return nil, err
+	}
+	// Skip tags that are not decoded, so that their content is decoded as if it had no tag.
+	for major == cborTag && !isCBORTagDecoded(argument) {
+		start = r.offset
+		if major, argument, indefinite, err = r.readCBORHead(); err != nil {
+			return nil, err
+		}
+	}
+
+	switch major {
+	case cborUnsigned:
+		if argument > math.MaxInt64 {
+			return argument, nil
+		}
+		return int64(argument), nil
+	case cborNegative:
+		if argument > math.MaxInt64 {
+			r.offset = start
+			return nil, r.errorf("negative integer out of range")
+		}
+		return -1 - int64(argument), nil
+	case cborBytes, cborString:
+		b, err := r.readCBORString(major, argument, indefinite)
+		if err != nil {
+			return nil, err
+		}
+		if major == cborBytes {
+			return b, nil
+		}
+		if !utf8.Valid(b) {
+			r.offset = start
+			return nil, r.errorf("invalid UTF-8 in string")
+		}
+		return string(b), nil
+	case cborArray:
+		return r.readCBORArray(argument, indefinite, depth)
+	case cborMap:
+		return r.readCBORMap(argument, indefinite, depth)
+	case cborTag:
+		if inTag {
+			r.offset = start
+			return nil, r.errorf("tag %d inside a tag", argument)
+		}
+		var content any
+		if argument == cborTypedValueTag {
+			content, err = r.readCBORTypedParts()
+		} else {
+			content, err = r.readCBORItem(depth, true)
+		}
+		if err != nil {
+			return nil, err
+		}
+		value, err := cborTagged(argument, content)
+		if err != nil {
+			r.offset = start
+			return nil, r.errorf("tag %d: %v", argument, err)
+		}
+		return value, nil
+	}
+
+	switch size := r.offset - start; {
+	case indefinite:
+		return cborBreak{}, nil
+	case size == 3:
+		return halfToFloat32(uint16(argument)), nil
+	case size == 5:
+		return math.Float32frombits(uint32(argument)), nil
+	case size == 9:
+		return math.Float64frombits(argument), nil
+	case size == 1 && argument == 20:
+		return false, nil
+	case size == 1 && argument == 21:
+		return true, nil
+	case size == 1 && (argument == 22 || argument == 23):
+		return nil, nil
+	}
+	r.offset = start
+	return nil, r.errorf("unsupported simple value %d", argument)
+}
+
+// readCBORString reads the bytes of a byte string or text string. Indefinite length strings are
+// made of chunks of the same major type.
+func (r *codecReader) readCBORString(major byte, length uint64, indefinite bool) ([]byte, error) {
+	if !indefinite {
+		b, err := r.next(length)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, b...), nil
+	}
+	b := []byte{}
+	for {
+		start := r.offset
+		chunkMajor, chunkLength, chunkIndefinite, err := r.readCBORHead()
+		if err != nil {
+			return nil, err
+		}
+		if chunkMajor == cborSimple && chunkIndefinite {
+			return b, nil
+		}
+		if chunkMajor != major || chunkIndefinite {
+			r.offset = start
+			return nil, r.errorf("invalid chunk in indefinite length string")
+		}
+		chunk, err := r.next(chunkLength)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, chunk...)
+	}
+}
+
+func (r *codecReader) readCBORArray(count uint64, indefinite bool, depth int) (Array, error) {
+	if depth++; depth > MaxNestingDepth {
+		return Array{}, &NestingDepthError{depth}
+	}
+	if !indefinite {
+		if err := r.checkCount(count, 1); err != nil {
+			return Array{}, err
+		}
+	}
+	values := make([]any, 0, count)
+	for indefinite || uint64(len(values)) < count {
+		value, err := r.readCBORItem(depth, false)
+		if err != nil {
+			return Array{}, err
+		}
+		if _, ok := value.(cborBreak); ok {
+			if !indefinite {
+				return Array{}, r.errorf("unexpected break")
+			}
+			break
+		}
+		values = append(values, value)
+	}
+	return Array{typeOfValues(values), values}, nil
+}
+
+func (r *codecReader) readCBORMap(count uint64, indefinite bool, depth int) (Map, error) {
+	if depth++; depth > MaxNestingDepth {
+		return Map{}, &NestingDepthError{depth}
+	}
+	if !indefinite {
+		if err := r.checkCount(count, 2); err != nil {
+			return Map{}, err
+		}
+	}
+	keys := make([]any, 0, count)
+	values := make([]any, 0, count)
+	for indefinite || uint64(len(keys)) < count {
+		key, err := r.readCBORItem(depth, false)
+		if err != nil {
+			return Map{}, err
+		}
+		if _, ok := key.(cborBreak); ok {
+			if !indefinite {
+				return Map{}, r.errorf("unexpected break")
+			}
+			break
+		}
+		value, err := r.readCBOR(depth)
+		if err != nil {
+			return Map{}, err
+		}
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+	return r.decodedMap(keys, values)
+}
+
+// readCBORTypedParts reads the array holding the element type and parts of a value stored with tag
+// 27513. The array is not counted as a level of nesting, and its items must be integers or strings.
+func (r *codecReader) readCBORTypedParts() ([]any, error) {
+	start := r.offset
+	major, count, indefinite, err := r.readCBORHead()
+	if err != nil {
+		return nil, err
+	}
+	if major != cborArray || indefinite || count < 1 || count > 4 {
+		r.offset = start
+		return nil, r.errorf("invalid typed value")
+	}
+	parts := make([]any, count)
+	for i := range parts {
+		start = r.offset
+		if major, _, _, err = r.readCBORHead(); err != nil {
+			return nil, err
+		}
+		r.offset = start
+		if major != cborUnsigned && major != cborNegative && major != cborString {
+			return nil, r.errorf("invalid part of typed value")
+		}
+		if parts[i], err = r.readCBORItem(0, true); err != nil {
+			return nil, err
+		}
+	}
+	return parts, nil
+}
+
+func isCBORTagDecoded(tag uint64) bool {
+	switch tag {
+	case cborTimeStringTag, cborEpochTimeTag, cborUUIDTag, cborDaysTag, cborDateStringTag,
+		cborTypedValueTag:
+		return true
+	}
+	return false
+}
+
+// cborTagged returns the value of a decoded tag with the given content.
+func cborTagged(tag uint64, content any) (any, error) {
+	switch tag {
+	case cborTimeStringTag:
+		if s, ok := content.(string); ok {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return nil, err
+			}
+			return time.Unix(t.Unix(), int64(t.Nanosecond())), nil
+		}
+	case cborEpochTimeTag:
+		switch v := content.(type) {
+		case int64:
+			t := time.Unix(v, 0)
+			return t, checkRFC3339Year(t.UTC())
+		case float32:
+			return epochFloatTime(float64(v))
+		case float64:
+			return epochFloatTime(v)
+		}
+	case cborUUIDTag:
+		if b, ok := content.([]byte); ok && len(b) == 16 {
+			var u UUID
+			copy(u[:], b)
+			return u, nil
+		}
+	case cborDaysTag:
+		if days, ok := content.(int64); ok && days >= math.MinInt32 && days <= math.MaxInt32 {
+			return Date(days), nil
+		}
+	case cborDateStringTag:
+		if s, ok := content.(string); ok {
+			t, err := time.Parse("2006-01-02", s)
+			if err != nil {
+				return nil, err
+			}
+			return DateOf(t), nil
+		}
+	case cborTypedValueTag:
+		return cborTypedValue(content.([]any))
+	}
+	return nil, fmt.Errorf("invalid content %v", content)
+}
+
+// epochFloatTime returns the time for the given number of seconds since the Unix epoch.
+func epochFloatTime(seconds float64) (time.Time, error) {
+	if math.IsNaN(seconds) || math.IsInf(seconds, 0) || math.Abs(seconds) > 1<<62 {
+		return time.Time{}, fmt.Errorf("invalid epoch time %v", seconds)
+	}
+	whole, fraction := math.Modf(seconds)
+	t := time.Unix(int64(whole), int64(fraction*1e9))
+	return t, checkRFC3339Year(t.UTC())
+}
+
+// checkRFC3339Year returns an error if the year of the given time cannot be written in RFC 3339,
+// which only allows the years 0 to 9999.
+func checkRFC3339Year(t time.Time) error {
+	if year := t.Year(); year < 0 || year > 9999 {
+		return fmt.Errorf("year %d of time %v is outside the RFC 3339 range", year, t)
+	}
+	return nil
+}
+
+// cborTypedValue returns the value of the element type and parts stored with tag 27513.
+func cborTypedValue(parts []any) (any, error) {
+	elemType, ok := parts[0].(int64)
+	if !ok {
+		return nil, fmt.Errorf("invalid element type %v", parts[0])
+	}
+	parts = parts[1:]
+	ints := make([]int64, len(parts))
+	for i, part := range parts {
+		ints[i], _ = part.(int64)
+	}
+	inRange := func(min int64, max int64) bool {
+		if len(parts) != 1 {
+			return false
+		}
+		_, ok := parts[0].(int64)
+		return ok && ints[0] >= min && ints[0] <= max
+	}
+
+	switch Type(elemType) {
+	case Uint8Type:
+		if inRange(0, math.MaxUint8) {
+			return uint8(ints[0]), nil
+		}
+	case Uint16Type:
+		if inRange(0, math.MaxUint16) {
+			return uint16(ints[0]), nil
+		}
+	case Uint32Type:
+		if inRange(0, math.MaxUint32) {
+			return uint32(ints[0]), nil
+		}
+	case Uint64Type:
+		if len(parts) == 1 {
+			if u, ok := parts[0].(uint64); ok {
+				return u, nil
+			}
+		}
+		if inRange(0, math.MaxInt64) {
+			return uint64(ints[0]), nil
+		}
+	case Int8Type:
+		if inRange(math.MinInt8, math.MaxInt8) {
+			return int8(ints[0]), nil
+		}
+	case Int16Type:
+		if inRange(math.MinInt16, math.MaxInt16) {
+			return int16(ints[0]), nil
+		}
+	case Int32Type:
+		if inRange(math.MinInt32, math.MaxInt32) {
+			return int32(ints[0]), nil
+		}
+	case DurationType:
+		if inRange(math.MinInt64, math.MaxInt64) {
+			return time.Duration(ints[0]), nil
+		}
+	case DecimalType:
+		if len(parts) == 3 && ints[1] >= 0 && ints[1] <= math.MaxUint8 && ints[2] >= 0 &&
+			ints[2] <= math.MaxUint8 {
+			return NewDecimal(ints[0], uint8(ints[1]), uint8(ints[2]))
+		}
+	case ZonedTimeType:
+		if len(parts) == 1 {
+			if s, ok := parts[0].(string); ok {
+				t, err := time.Parse(time.RFC3339Nano, s)
+				if err != nil {
+					return nil, err
+				}
+				_, zoneOffset := t.Zone()
+				return newZonedTime(t.Unix(), int64(t.Nanosecond()), zoneOffset), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("invalid value %v for element type %d", parts, elemType)
+}
+
+// halfToFloat32 converts an IEEE 754 half precision float to a float32.
+func halfToFloat32(half uint16) float32 {
+	sign := uint32(half>>15) << 31
+	exponent := uint32(half>>10) & 0x1f
+	mantissa := uint32(half) & 0x3ff
+	switch exponent {
+	case 0:
+		// Zero or a subnormal number, which is mantissa * 2^-24.
+		value := float32(mantissa) / (1 << 24)
+		if sign != 0 {
+			return -value
+		}
+		return value
+	case 0x1f:
+		return math.Float32frombits(sign | 0xff<<23 | mantissa<<13)
+	}
+	return math.Float32frombits(sign | (exponent+127-15)<<23 | mantissa<<13)
+}
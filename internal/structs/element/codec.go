@@ -0,0 +1,157 @@
+package element
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// The MessagePack and CBOR codecs translate element values to and from formats that other services
+// can read. Neither format stores the element type of an array or the key and value types of a map,
+// so decoded arrays and maps take the type shared by all their values, or AnyType if the values
+// have different types, include nulls, or there are no values.
+
+// DecodeError is returned when MessagePack or CBOR data cannot be decoded, because it is truncated
+// or malformed or holds values that have no element type.
+type DecodeError struct {
+	Format string
+	Offset int
+	Reason string
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("invalid %s data at offset %d: %s", e.Format, e.Offset, e.Reason)
+}
+
+// codecReader reads the bytes of encoded data in order.
+type codecReader struct {
+	format string
+	data   []byte
+	offset int
+}
+
+// errorf returns a DecodeError for the current offset.
+func (r *codecReader) errorf(format string, args ...any) error {
+	return &DecodeError{r.format, r.offset, fmt.Sprintf(format, args...)}
+}
+
+// next returns the next n bytes.
+func (r *codecReader) next(n uint64) ([]byte, error) {
+	if n > uint64(len(r.data)-r.offset) {
+		return nil, r.errorf("%d bytes needed but only %d available", n, len(r.data)-r.offset)
+	}
+	b := r.data[r.offset : r.offset+int(n)]
+	r.offset += int(n)
+	return b, nil
+}
+
+// readByte returns the next byte.
+func (r *codecReader) readByte() (byte, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// readUint returns the next size bytes as a big-endian unsigned integer. Size is 1, 2, 4 or 8.
+func (r *codecReader) readUint(size int) (uint64, error) {
+	b, err := r.next(uint64(size))
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// checkCount returns a DecodeError if count items of at least minSize bytes each cannot fit in the
+// remaining data. It keeps corrupt counts from causing huge allocations.
+func (r *codecReader) checkCount(count uint64, minSize uint64) error {
+	if count > uint64(len(r.data)-r.offset)/minSize {
+		return r.errorf("count %d exceeds the remaining %d bytes", count, len(r.data)-r.offset)
+	}
+	return nil
+}
+
+// checkEnd returns a DecodeError if there is data left after the decoded value.
+func (r *codecReader) checkEnd() error {
+	if r.offset != len(r.data) {
+		return r.errorf("%d bytes left after value", len(r.data)-r.offset)
+	}
+	return nil
+}
+
+// appendUint appends the value as a big-endian unsigned integer of the given size.
+func appendUint(b []byte, value uint64, size int) []byte {
+	for shift := 8 * (size - 1); shift >= 0; shift -= 8 {
+		b = append(b, byte(value>>shift))
+	}
+	return b
+}
+
+// typeOfValues returns the element type shared by all the given values, or AnyType if the values
+// have different types, include nulls, or there are no values.
+func typeOfValues(values []any) Type {
+	if len(values) == 0 {
+		return AnyType
+	}
+	elemType, _ := TypeForValue(values[0])
+	for _, value := range values[1:] {
+		if valueType, _ := TypeForValue(value); valueType != elemType {
+			return AnyType
+		}
+	}
+	if elemType == NullType {
+		return AnyType
+	}
+	return elemType
+}
+
+// decodedMap returns a Map of the given keys and values.
+func (r *codecReader) decodedMap(keys []any, values []any) (Map, error) {
+	data := make(map[any]any, len(keys))
+	for i, key := range keys {
+		keyType, _ := TypeForValue(key)
+		if !isKeyType(keyType) {
+			name, _ := NameForType(keyType)
+			return Map{}, r.errorf("%s cannot be a map key", name)
+		}
+		if isNaN(key) {
+			return Map{}, r.errorf("NaN cannot be a map key")
+		}
+		data[key] = values[i]
+	}
+	return Map{typeOfValues(keys), typeOfValues(values), data}, nil
+}
+
+// checkValueType returns a TypeMismatchError if the value cannot be stored in an array or map whose
+// values are of the given type.
+func checkValueType(elemType Type, value any) error {
+	if elemType == AnyType {
+		if _, err := TypeForValue(value); err != nil {
+			return err
+		}
+		return nil
+	}
+	if valueType, err := TypeForValue(value); err != nil || valueType != elemType {
+		return typeMismatch(elemType, value)
+	}
+	return nil
+}
+
+// isNaN returns true if the value is a float NaN.
+func isNaN(value any) bool {
+	switch v := value.(type) {
+	case float32:
+		return v != v
+	case float64:
+		return v != v
+	}
+	return false
+}
@@ -0,0 +1,367 @@
+package element
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type codec struct {
+	name   string
+	encode func(any) ([]byte, error)
+	decode func([]byte) (any, error)
+}
+
+var codecs = []codec{
+	{"MessagePack", EncodeMsgpack, DecodeMsgpack},
+	{"CBOR", EncodeCBOR, DecodeCBOR},
+}
+
+// codecTestValues returns a value of every element type.
+func codecTestValues() []any {
+	uuid, _ := ParseUUID("123e4567-e89b-12d3-a456-426614174000")
+	return []any{
+		nil, true, false,
+		uint8(255), uint16(65535), uint32(1<<32 - 1), uint64(1<<64 - 1), uint64(7),
+		int8(-128), int16(-32768), int32(-1 << 31), int64(-1 << 63), int64(1<<63 - 1), int64(5),
+		float32(1.5), math.MaxFloat64, "", "hello wörld", string(make([]byte, 300)),
+		[]byte{}, []byte{0, 1, 2}, make([]byte, 70000), uuid, Decimal{-12345, 10, 3},
+		time.Unix(1680674828, 123456789), time.Unix(-1, 0), Date(-1), Date(19000),
+		time.Duration(-90 * time.Minute), newZonedTime(1680674828, 5, -(5*3600 + 30*60)),
+		newZonedTime(0, 0, 0),
+		Array{Int16Type, []any{int16(1), int16(-2)}},
+		Array{AnyType, []any{nil, "a", int64(1)}},
+		Array{StringType, make([]any, 0)},
+		Array{ArrayType, []any{Array{TimeType, []any{time.Unix(1, 0)}}}},
+		Map{StringType, Uint8Type, map[any]any{"a": uint8(1), "b": uint8(2)}},
+		Map{AnyType, AnyType, map[any]any{nil: "x", int32(1): Date(3), "c": nil}},
+		Map{DateType, MapType, map[any]any{
+			Date(1): Map{UUIDType, Float32Type, map[any]any{uuid: float32(0.5)}},
+		}},
+	}
+}
+
+// expectedDecoded returns the value that decoding the encoding of the given value returns. The
+// element types of arrays and maps are inferred from their values when they are decoded.
+func expectedDecoded(value any) any {
+	switch v := value.(type) {
+	case Array:
+		values := make([]any, len(v.Values))
+		for i, elem := range v.Values {
+			values[i] = expectedDecoded(elem)
+		}
+		return Array{typeOfValues(values), values}
+	case Map:
+		var keys, values []any
+		data := make(map[any]any, len(v.Data))
+		for key, elem := range v.Data {
+			keys = append(keys, key)
+			values = append(values, expectedDecoded(elem))
+			data[key] = values[len(values)-1]
+		}
+		return Map{typeOfValues(keys), typeOfValues(values), data}
+	case ZonedTime:
+		return v
+	case time.Time:
+		return time.Unix(v.Unix(), int64(v.Nanosecond()))
+	}
+	return value
+}
+
+func TestCodecs(t *testing.T) {
+	for _, c := range codecs {
+		t.Run(
+			"check round trip of all element types with "+c.name, func(t *testing.T) {
+				for _, value := range codecTestValues() {
+					data, err := c.encode(value)
+					if err != nil {
+						t.Fatalf("%v: %v", value, err)
+					}
+					decoded, err := c.decode(data)
+					if err != nil {
+						t.Fatalf("%v: %v", value, err)
+					}
+					expected := expectedDecoded(value)
+					if z, ok := expected.(ZonedTime); ok {
+						_, expectedOffset := z.Zone()
+						_, offset := decoded.(ZonedTime).Zone()
+						if !decoded.(ZonedTime).Equal(z.Time) || offset != expectedOffset {
+							t.Errorf("expected %v, got %v", expected, decoded)
+						}
+						continue
+					}
+					if !reflect.DeepEqual(decoded, expected) {
+						t.Errorf("expected %#v, got %#v", expected, decoded)
+					}
+				}
+			},
+		)
+
+		t.Run(
+			"check NaN with "+c.name, func(t *testing.T) {
+				data, err := c.encode(math.NaN())
+				if err != nil {
+					t.Fatal(err)
+				}
+				decoded, err := c.decode(data)
+				if f, ok := decoded.(float64); err != nil || !ok || !math.IsNaN(f) {
+					t.Errorf("expected NaN, got %v, %v", decoded, err)
+				}
+			},
+		)
+
+		t.Run(
+			"check map encoding is deterministic with "+c.name, func(t *testing.T) {
+				m := Map{Int64Type, BoolType, map[any]any{}}
+				for i := 0; i < 100; i++ {
+					m.Data[int64(i)] = i%2 == 0
+				}
+				first, _ := c.encode(m)
+				for i := 0; i < 10; i++ {
+					if data, _ := c.encode(m); !bytes.Equal(data, first) {
+						t.Fatal("map encoding differs between calls")
+					}
+				}
+			},
+		)
+
+		t.Run(
+			"check invalid values with "+c.name, func(t *testing.T) {
+				_, err := c.encode(Array{Int32Type, []any{"a"}})
+				if _, ok := err.(*TypeMismatchError); !ok {
+					t.Errorf("expected TypeMismatchError, got %v", err)
+				}
+				_, err = c.encode(struct{}{})
+				if err == nil {
+					t.Error("expected error for unsupported type")
+				}
+				var nested any = int64(1)
+				for i := 0; i <= MaxNestingDepth; i++ {
+					nested = Array{AnyType, []any{nested}}
+				}
+				_, err = c.encode(nested)
+				if _, ok := err.(*NestingDepthError); !ok {
+					t.Errorf("expected NestingDepthError, got %v", err)
+				}
+			},
+		)
+
+		t.Run(
+			"check truncated data with "+c.name, func(t *testing.T) {
+				for _, value := range codecTestValues() {
+					data, _ := c.encode(value)
+					for i := 0; i < len(data) && i < 100; i++ {
+						if _, err := c.decode(data[:i]); err == nil {
+							t.Fatalf("%v: expected error for %d of %d bytes", value, i, len(data))
+						}
+					}
+					if _, err := c.decode(append(data, 0)); err == nil {
+						t.Fatalf("%v: expected error for trailing byte", value)
+					}
+				}
+			},
+		)
+	}
+}
+
+func TestMsgpack(t *testing.T) {
+	t.Run(
+		"check integer formats", func(t *testing.T) {
+			for _, test := range []struct {
+				value    any
+				expected []byte
+			}{
+				{uint8(1), []byte{0xcc, 1}},
+				{uint16(1), []byte{0xcd, 0, 1}},
+				{int32(-1), []byte{0xd2, 0xff, 0xff, 0xff, 0xff}},
+				{int64(1), []byte{0xd3, 0, 0, 0, 0, 0, 0, 0, 1}},
+				{"ab", []byte{0xa2, 'a', 'b'}},
+				{Array{BoolType, []any{true}}, []byte{0x91, 0xc3}},
+			} {
+				data, err := EncodeMsgpack(test.value)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(data, test.expected) {
+					t.Errorf("%v: expected %x, got %x", test.value, test.expected, data)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check values from other encoders", func(t *testing.T) {
+			for _, test := range []struct {
+				data     []byte
+				expected any
+			}{
+				{[]byte{0x05}, int64(5)},
+				{[]byte{0xff}, int64(-1)},
+				{[]byte{0xd6, 0xff, 0, 0, 0, 10}, time.Unix(10, 0)},
+				{[]byte{0xd7, 0xff, 0, 0, 0, 4, 0, 0, 0, 10}, time.Unix(10, 1)},
+				{
+					[]byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0xa1, 'c'},
+					Map{StringType, AnyType, map[any]any{"a": int64(1), "b": "c"}},
+				},
+			} {
+				value, err := DecodeMsgpack(test.data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(value, test.expected) {
+					t.Errorf("%x: expected %v, got %v", test.data, test.expected, value)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check invalid data", func(t *testing.T) {
+			for _, data := range [][]byte{
+				{0xc1},
+				{0xd4, 0x7f, 0},
+				{0xdd, 0xff, 0xff, 0xff, 0xff},
+				{0x81, 0xc4, 1, 'a', 0xc0},
+				{0xc7, 10, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+				{0x81, 0xcb, 0xff, 0xf8, 0, 0, 0, 0, 0, 0, 0xc0},
+			} {
+				_, err := DecodeMsgpack(data)
+				if _, ok := err.(*DecodeError); !ok {
+					t.Errorf("%x: expected DecodeError, got %v", data, err)
+				}
+			}
+		},
+	)
+}
+
+func TestCBOR(t *testing.T) {
+	t.Run(
+		"check values from other encoders", func(t *testing.T) {
+			for _, test := range []struct {
+				data     []byte
+				expected any
+			}{
+				// Examples from appendix A of RFC 8949.
+				{[]byte{0x17}, int64(23)},
+				{[]byte{0x38, 0x63}, int64(-100)},
+				{[]byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, uint64(1<<64 - 1)},
+				{[]byte{0xf9, 0x3c, 0x00}, float32(1)},
+				{[]byte{0xf9, 0xc4, 0x00}, float32(-4)},
+				{[]byte{0xf9, 0x00, 0x01}, float32(5.960464477539063e-8)},
+				{[]byte{0xf9, 0x7c, 0x00}, float32(math.Inf(1))},
+				{[]byte{0xf7}, nil},
+				{[]byte{0xc1, 0x1a, 0x51, 0x4b, 0x67, 0xb0}, time.Unix(1363896240, 0)},
+				{
+					[]byte{0xc1, 0xfb, 0x41, 0xd4, 0x52, 0xd9, 0xec, 0x20, 0, 0},
+					time.Unix(1363896240, 5e8),
+				},
+				{
+					append([]byte{0xc0, 0x74}, "2013-03-21T20:04:00Z"...),
+					time.Unix(1363896240, 0),
+				},
+				{[]byte{0xd8, 0x20, 0x61, 'a'}, "a"},
+				{[]byte{0x5f, 0x42, 1, 2, 0x41, 3, 0xff}, []byte{1, 2, 3}},
+				{[]byte{0x7f, 0x61, 'a', 0x61, 'b', 0xff}, "ab"},
+				{[]byte{0x9f, 0x01, 0x02, 0xff}, Array{Int64Type, []any{int64(1), int64(2)}}},
+				{
+					[]byte{0xbf, 0x61, 'a', 0x01, 0xff},
+					Map{StringType, Int64Type, map[any]any{"a": int64(1)}},
+				},
+				{append([]byte{0xd9, 0x03, 0xec, 0x6a}, "1970-01-02"...), Date(1)},
+			} {
+				value, err := DecodeCBOR(test.data)
+				if err != nil {
+					t.Fatalf("%x: %v", test.data, err)
+				}
+				if !reflect.DeepEqual(value, test.expected) {
+					t.Errorf("%x: expected %#v, got %#v", test.data, test.expected, value)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check standard tags", func(t *testing.T) {
+			data, _ := EncodeCBOR(Date(1))
+			if expected := []byte{0xd8, 0x64, 0x01}; !bytes.Equal(data, expected) {
+				t.Errorf("expected %x, got %x", expected, data)
+			}
+			data, _ = EncodeCBOR(UUID{1})
+			if expected := []byte{0xd8, 0x25, 0x50, 1}; !bytes.HasPrefix(data, expected) {
+				t.Errorf("expected prefix %x, got %x", expected, data)
+			}
+		},
+	)
+
+	t.Run(
+		"check invalid data", func(t *testing.T) {
+			for _, data := range [][]byte{
+				{0x1c},
+				{0xff},
+				{0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+				{0x62, 0xff, 0xfe},
+				{0x5f, 0x61, 'a', 0xff},
+				{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+				{0xa1, 0x41, 'a', 0x01},
+				{0xc0, 0x01},
+				{0xc0, 0xc0, 0x60},
+				{0xd9, 0x6b, 0x79, 0x82, 0x18, 'C', 0x19, 0x01, 0x00},
+				{0xd9, 0x6b, 0x79, 0x82, 0x18, 'C', 0x81, 0x01},
+				{0xf8, 0x10},
+				{0xc1, 0x3b, 0x00, 0x10, 0, 0, 0, 0, 0, 0},
+			} {
+				_, err := DecodeCBOR(data)
+				if _, ok := err.(*DecodeError); !ok {
+					t.Errorf("%x: expected DecodeError, got %v", data, err)
+				}
+			}
+		},
+	)
+}
+
+func addCodecSeeds(f *testing.F, encode func(any) ([]byte, error)) {
+	for _, value := range codecTestValues() {
+		data, err := encode(value)
+		if err != nil {
+			f.Fatal(err)
+		}
+		if len(data) < 1000 {
+			f.Add(data)
+		}
+	}
+}
+
+// checkReencode checks that a decoded value encodes to data that decodes to an equal value.
+func checkReencode(t *testing.T, c codec, value any) {
+	data, err := c.encode(value)
+	if err != nil {
+		t.Fatalf("cannot encode decoded value %#v: %v", value, err)
+	}
+	if _, err = c.decode(data); err != nil {
+		t.Fatalf("cannot decode encoded value %#v: %v", value, err)
+	}
+}
+
+func FuzzDecodeMsgpack(f *testing.F) {
+	addCodecSeeds(f, EncodeMsgpack)
+	f.Fuzz(
+		func(t *testing.T, data []byte) {
+			if value, err := DecodeMsgpack(data); err == nil {
+				checkReencode(t, codecs[0], value)
+			}
+		},
+	)
+}
+
+func FuzzDecodeCBOR(f *testing.F) {
+	addCodecSeeds(f, EncodeCBOR)
+	f.Fuzz(
+		func(t *testing.T, data []byte) {
+			if value, err := DecodeCBOR(data); err == nil {
+				checkReencode(t, codecs[1], value)
+			}
+		},
+	)
+}
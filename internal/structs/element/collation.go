@@ -0,0 +1,137 @@
+package element
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Collation defines the order of strings.
+type Collation interface {
+	// Compare returns -1, 0 or 1 depending on whether string a is ordered before, equal to or after
+	// string b.
+	Compare(a, b string) int
+
+	// Key returns a string whose bytewise order matches the order of the collation: the keys of two
+	// strings compare the same way under strings.Compare as the strings do under Compare. Keys are
+	// stored in indexes in place of the strings themselves.
+	Key(s string) string
+}
+
+type binaryCollation struct{}
+
+type caseInsensitiveCollation struct{}
+
+type normalizedCollation struct {
+	fold bool
+}
+
+var (
+	// BinaryCollation orders strings bytewise, which for valid UTF-8 is the order of their code
+	// points.
+	BinaryCollation Collation = binaryCollation{}
+
+	// CaseInsensitiveCollation orders strings by their code points after Unicode simple case
+	// folding, so that "Go" and "GO" are equal.
+	CaseInsensitiveCollation Collation = caseInsensitiveCollation{}
+
+	// NormalizedCollation orders strings bytewise after converting them to Unicode normalization
+	// form NFC, so that canonically equivalent strings such as "é" written as one code point or as
+	// "e" followed by a combining accent are equal.
+	NormalizedCollation Collation = normalizedCollation{}
+
+	// NormalizedCaseInsensitiveCollation orders strings as NormalizedCollation does after Unicode
+	// simple case folding.
+	NormalizedCaseInsensitiveCollation Collation = normalizedCollation{fold: true}
+)
+
+var (
+	collationsMutex sync.RWMutex
+	collations      = map[string]Collation{
+		"binary":     BinaryCollation,
+		"nocase":     CaseInsensitiveCollation,
+		"nfc":        NormalizedCollation,
+		"nfc_nocase": NormalizedCaseInsensitiveCollation,
+	}
+)
+
+// RegisterCollation makes a collation available under the given name, replacing any collation
+// registered under the same name before.
+func RegisterCollation(name string, collation Collation) {
+	collationsMutex.Lock()
+	defer collationsMutex.Unlock()
+	collations[name] = collation
+}
+
+// LookupCollation returns the collation registered under the given name. The second return value
+// is false if there is no such collation.
+func LookupCollation(name string) (Collation, bool) {
+	collationsMutex.RLock()
+	defer collationsMutex.RUnlock()
+	collation, ok := collations[name]
+	return collation, ok
+}
+
+func (binaryCollation) Compare(a, b string) int {
+	return strings.Compare(a, b)
+}
+
+func (binaryCollation) Key(s string) string {
+	return s
+}
+
+// foldRune returns the smallest rune that is equivalent to r under Unicode simple case folding.
+func foldRune(r rune) rune {
+	smallest := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < smallest {
+			smallest = f
+		}
+	}
+	return smallest
+}
+
+// foldString returns the string with every rune replaced by the result of foldRune.
+func foldString(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		b.WriteRune(foldRune(r))
+	}
+	return b.String()
+}
+
+func (caseInsensitiveCollation) Compare(a, b string) int {
+	for a != "" && b != "" {
+		runeA, sizeA := utf8.DecodeRuneInString(a)
+		runeB, sizeB := utf8.DecodeRuneInString(b)
+		if c := compareOrdered(int(foldRune(runeA)), int(foldRune(runeB))); c != 0 {
+			return c
+		}
+		a, b = a[sizeA:], b[sizeB:]
+	}
+	return compareOrdered(len(a), len(b))
+}
+
+func (caseInsensitiveCollation) Key(s string) string {
+	return foldString(s)
+}
+
+func (c normalizedCollation) Compare(a, b string) int {
+	if !c.fold && norm.NFC.IsNormalString(a) && norm.NFC.IsNormalString(b) {
+		return strings.Compare(a, b)
+	}
+	return strings.Compare(c.Key(a), c.Key(b))
+}
+
+func (c normalizedCollation) Key(s string) string {
+	if c.fold {
+		// Folding can produce strings that are not normalized, so normalize both before and
+		// after.
+		return norm.NFC.String(foldString(norm.NFD.String(s)))
+	}
+	return norm.NFC.String(s)
+}
@@ -0,0 +1,342 @@
+package element
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// Values are compared with the following rules:
+//
+//   - null is ordered before every other value, or after every other value if NullsLast is set
+//   - integers, floats and decimals of any width are compared by their numeric value, so int32(1)
+//     equals int64(1) and uint64(math.MaxUint64) is greater than every int64
+//   - NaN is equal to NaN and ordered before every other number, and -0 equals 0
+//   - strings are compared with the collation of the comparator
+//   - bools are ordered false before true; byte slices and UUIDs are compared bytewise
+//   - times and zoned times are compared by the instant they represent and then by the offset of
+//     their time zone, with times treated as zoned times in UTC
+//   - dates and durations are compared numerically
+//   - arrays are compared value by value, with an array ordered before the arrays it is a prefix of
+//   - maps are compared entry by entry in key order, first by key and then by value, with a map
+//     ordered before the maps whose entries it is a prefix of
+//
+// Values of AnyType can be of different types. Values that are not covered by the rules above are
+// ordered by kind: null, bool, number, string, bytes, UUID, date, time, duration, array and map.
+
+// Comparator compares element values.
+type Comparator struct {
+	// Collation is the collation that strings are compared with. nil means BinaryCollation.
+	Collation Collation
+
+	// NullsLast orders null after every other value instead of before.
+	NullsLast bool
+}
+
+// valueKind groups the element types whose values can be compared with each other.
+type valueKind uint8
+
+const (
+	nullKind valueKind = iota
+	boolKind
+	numberKind
+	stringKind
+	bytesKind
+	uuidKind
+	dateKind
+	timeKind
+	durationKind
+	arrayKind
+	mapKind
+)
+
+func kindForType(elemType Type) valueKind {
+	switch elemType {
+	case NullType:
+		return nullKind
+	case BoolType:
+		return boolKind
+	case StringType:
+		return stringKind
+	case BytesType:
+		return bytesKind
+	case UUIDType:
+		return uuidKind
+	case DateType:
+		return dateKind
+	case TimeType, ZonedTimeType:
+		return timeKind
+	case DurationType:
+		return durationKind
+	case ArrayType:
+		return arrayKind
+	case MapType:
+		return mapKind
+	}
+	return numberKind
+}
+
+// Compare returns -1, 0 or 1 depending on whether value a is ordered before, equal to or after
+// value b, using BinaryCollation for strings and ordering null first. Both values must be of the
+// given element type or nil, unless the type is AnyType.
+func Compare(a, b any, elemType Type) (int, error) {
+	return Comparator{}.Compare(a, b, elemType)
+}
+
+// Compare returns -1, 0 or 1 depending on whether value a is ordered before, equal to or after
+// value b. Both values must be of the given element type or nil, unless the type is AnyType.
+func (c Comparator) Compare(a, b any, elemType Type) (int, error) {
+	typeA, err := c.checkType(a, elemType)
+	if err != nil {
+		return 0, err
+	}
+	typeB, err := c.checkType(b, elemType)
+	if err != nil {
+		return 0, err
+	}
+	return c.compare(a, b, typeA, typeB)
+}
+
+// checkType returns the type of the value after checking that it can be compared as a value of the
+// given element type.
+func (c Comparator) checkType(value any, elemType Type) (Type, error) {
+	valueType, err := TypeForValue(value)
+	if err != nil {
+		return NullType, err
+	}
+	if valueType != NullType && elemType != AnyType && valueType != elemType {
+		return NullType, typeMismatch(elemType, value)
+	}
+	return valueType, nil
+}
+
+func (c Comparator) compare(a, b any, typeA, typeB Type) (int, error) {
+	kindA, kindB := kindForType(typeA), kindForType(typeB)
+	if kindA != kindB {
+		if c.NullsLast && (kindA == nullKind || kindB == nullKind) {
+			return compareOrdered(int(kindB), int(kindA)), nil
+		}
+		return compareOrdered(int(kindA), int(kindB)), nil
+	}
+
+	switch kindA {
+	case nullKind:
+		return 0, nil
+	case boolKind:
+		return compareKeys(a, b), nil
+	case numberKind:
+		return compareNumbers(a, b, typeA, typeB), nil
+	case stringKind:
+		collation := c.Collation
+		if collation == nil {
+			collation = BinaryCollation
+		}
+		return collation.Compare(a.(string), b.(string)), nil
+	case bytesKind:
+		return bytes.Compare(a.([]byte), b.([]byte)), nil
+	case uuidKind, dateKind, durationKind:
+		return compareKeys(a, b), nil
+	case timeKind:
+		return compareTimes(a, b), nil
+	case arrayKind:
+		return c.compareArrays(a.(Array), b.(Array))
+	}
+	return c.compareMaps(a.(Map), b.(Map))
+}
+
+// numberClass returns 0 for integers, 1 for floats and 2 for decimals.
+func numberClass(elemType Type) int {
+	switch elemType {
+	case Float32Type, Float64Type:
+		return 1
+	case DecimalType:
+		return 2
+	}
+	return 0
+}
+
+// isUnsignedType returns true for the unsigned integer types.
+func isUnsignedType(elemType Type) bool {
+	switch elemType {
+	case Uint8Type, Uint16Type, Uint32Type, Uint64Type:
+		return true
+	}
+	return false
+}
+
+// floatValue returns the given float32 or float64 value as a float64.
+func floatValue(value any) float64 {
+	if f, ok := value.(float32); ok {
+		return float64(f)
+	}
+	return value.(float64)
+}
+
+// ratValue returns the exact value of the given finite number.
+func ratValue(value any, elemType Type) *big.Rat {
+	switch numberClass(elemType) {
+	case 1:
+		return new(big.Rat).SetFloat64(floatValue(value))
+	case 2:
+		d := value.(Decimal)
+		scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(d.Scale)), nil)
+		return new(big.Rat).SetFrac(big.NewInt(d.Value), scale)
+	}
+	if isUnsignedType(elemType) {
+		return new(big.Rat).SetUint64(unsignedValue(value))
+	}
+	return new(big.Rat).SetInt64(signedValue(value))
+}
+
+// compareNumbers compares two integers, floats or decimals by their numeric value.
+func compareNumbers(a, b any, typeA, typeB Type) int {
+	classA, classB := numberClass(typeA), numberClass(typeB)
+	switch {
+	case classA == 0 && classB == 0:
+		unsignedA, unsignedB := isUnsignedType(typeA), isUnsignedType(typeB)
+		switch {
+		case unsignedA && unsignedB:
+			return compareOrdered(unsignedValue(a), unsignedValue(b))
+		case !unsignedA && !unsignedB:
+			return compareOrdered(signedValue(a), signedValue(b))
+		}
+	case classA == 1 && classB == 1:
+		return compareFloats(floatValue(a), floatValue(b))
+	case classA == 2 && classB == 2:
+		return compareDecimals(a.(Decimal), b.(Decimal))
+	}
+
+	// A float that is not finite is ordered before or after every number of another class.
+	if classA == 1 {
+		if c := nonFiniteOrder(floatValue(a)); c != 0 {
+			return c
+		}
+	}
+	if classB == 1 {
+		if c := nonFiniteOrder(floatValue(b)); c != 0 {
+			return -c
+		}
+	}
+	return ratValue(a, typeA).Cmp(ratValue(b, typeB))
+}
+
+// nonFiniteOrder returns -1 for NaN and negative infinity, 1 for positive infinity and 0 for finite
+// floats.
+func nonFiniteOrder(f float64) int {
+	switch {
+	case math.IsNaN(f) || math.IsInf(f, -1):
+		return -1
+	case math.IsInf(f, 1):
+		return 1
+	}
+	return 0
+}
+
+// compareTimes compares two times or zoned times by their instant and then by their zone offset.
+func compareTimes(a, b any) int {
+	zonedA, zonedB := zonedTimeValue(a), zonedTimeValue(b)
+	if c := compareOrdered(zonedA.Unix(), zonedB.Unix()); c != 0 {
+		return c
+	}
+	if c := compareOrdered(zonedA.Nanosecond(), zonedB.Nanosecond()); c != 0 {
+		return c
+	}
+	_, offsetA := zonedA.Zone()
+	_, offsetB := zonedB.Zone()
+	return compareOrdered(offsetA, offsetB)
+}
+
+// zonedTimeValue returns the given time or zoned time as a zoned time. Times are in UTC.
+func zonedTimeValue(value any) ZonedTime {
+	if t, ok := value.(time.Time); ok {
+		return ZonedTime{t.UTC()}
+	}
+	return value.(ZonedTime)
+}
+
+func (c Comparator) compareArrays(a, b Array) (int, error) {
+	for i := 0; i < len(a.Values) && i < len(b.Values); i++ {
+		result, err := c.Compare(a.Values[i], b.Values[i], AnyType)
+		if result != 0 || err != nil {
+			return result, err
+		}
+	}
+	return compareOrdered(len(a.Values), len(b.Values)), nil
+}
+
+func (c Comparator) compareMaps(a, b Map) (int, error) {
+	keysA, keysB := SortedKeys(a.Data), SortedKeys(b.Data)
+	for i := 0; i < len(keysA) && i < len(keysB); i++ {
+		result, err := c.Compare(keysA[i], keysB[i], AnyType)
+		if result != 0 || err != nil {
+			return result, err
+		}
+		result, err = c.Compare(a.Data[keysA[i]], b.Data[keysB[i]], AnyType)
+		if result != 0 || err != nil {
+			return result, err
+		}
+	}
+	return compareOrdered(len(keysA), len(keysB)), nil
+}
+
+// Sort sorts the given values of the given element type in ascending order. The sort is stable.
+func (c Comparator) Sort(values []any, elemType Type) error {
+	for _, value := range values {
+		if _, err := c.checkType(value, elemType); err != nil {
+			return err
+		}
+	}
+	var err error
+	sort.SliceStable(
+		values, func(i, j int) bool {
+			result, compareErr := c.Compare(values[i], values[j], elemType)
+			if compareErr != nil && err == nil {
+				err = compareErr
+			}
+			return result < 0
+		},
+	)
+	return err
+}
+
+// AppendKey appends the key encoding of the given value in the given order to key, like the
+// AppendKey function. Strings are replaced by the key of the comparator's collation, and nulls are
+// ordered after all other values if NullsLast is set, so that the order of the keys matches the
+// order of the comparator. A string decoded from such a key is the collation key of the original
+// string.
+func (c Comparator) AppendKey(key []byte, value any, order SortOrder) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		if c.NullsLast {
+			if order == Descending {
+				return append(key, ^byte(nullsLastKeyType)), nil
+			}
+			return append(key, nullsLastKeyType), nil
+		}
+	case string:
+		if c.Collation != nil {
+			value = c.Collation.Key(v)
+		}
+	}
+	return AppendKey(key, value, order)
+}
+
+// EncodeKeyWithOrder returns the key encoding of the tuple of the given values, each in the sort
+// order at the same index in orders, like the EncodeKeyWithOrder function but with the key
+// encoding of the comparator.
+func (c Comparator) EncodeKeyWithOrder(values []any, orders []SortOrder) ([]byte, error) {
+	var key []byte
+	for i, value := range values {
+		order := Ascending
+		if i < len(orders) {
+			order = orders[i]
+		}
+		var err error
+		if key, err = c.AppendKey(key, value, order); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
@@ -0,0 +1,313 @@
+package element
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompare(t *testing.T) {
+	t.Run(
+		"check order of values of each type", func(t *testing.T) {
+			for _, values := range keyTestValues() {
+				elemType, _ := TypeForValue(values[0])
+				for i, a := range values {
+					for j, b := range values {
+						c, err := Compare(a, b, elemType)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if expected := compareOrdered(i, j); c != expected {
+							t.Errorf("%v, %v: expected %d, got %d", a, b, expected, c)
+						}
+					}
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check null ordering", func(t *testing.T) {
+			if c, _ := Compare(nil, int32(-5), Int32Type); c != -1 {
+				t.Errorf("expected null first, got %d", c)
+			}
+			if c, _ := Compare(nil, nil, Int32Type); c != 0 {
+				t.Errorf("expected nulls to be equal, got %d", c)
+			}
+			comparator := Comparator{NullsLast: true}
+			if c, _ := comparator.Compare(nil, "", StringType); c != 1 {
+				t.Errorf("expected null last, got %d", c)
+			}
+			if c, _ := comparator.Compare(false, nil, AnyType); c != -1 {
+				t.Errorf("expected null last, got %d", c)
+			}
+		},
+	)
+
+	t.Run(
+		"check numeric promotion", func(t *testing.T) {
+			tests := []struct {
+				a, b     any
+				expected int
+			}{
+				{int32(1), int64(1), 0},
+				{uint8(200), int8(-1), 1},
+				{uint64(math.MaxUint64), int64(math.MaxInt64), 1},
+				{int64(-1), uint64(0), -1},
+				{int64(1<<53 + 1), float64(1 << 53), 1},
+				{int64(math.MaxInt64), float64(1 << 63), -1},
+				{uint64(math.MaxUint64), float64(1 << 64), -1},
+				{int32(2), 2.5, -1},
+				{float32(0.1), 0.1, 1},
+				{Decimal{150, 3, 2}, 1.5, 0},
+				{Decimal{1, 2, 1}, float32(0.1), -1},
+				{Decimal{-1, 18, 18}, int8(0), -1},
+				{Decimal{200, 3, 2}, uint16(2), 0},
+				{math.Copysign(0, -1), int32(0), 0},
+				{math.Inf(1), uint64(math.MaxUint64), 1},
+				{Decimal{-999999999999999999, 18, 0}, math.Inf(-1), 1},
+			}
+			for _, test := range tests {
+				c, err := Compare(test.a, test.b, AnyType)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if c != test.expected {
+					t.Errorf("%v, %v: expected %d, got %d", test.a, test.b, test.expected, c)
+				}
+				if c, _ = Compare(test.b, test.a, AnyType); c != -test.expected {
+					t.Errorf("%v, %v: expected %d, got %d", test.b, test.a, -test.expected, c)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check NaN", func(t *testing.T) {
+			for _, other := range []any{math.Inf(-1), int64(math.MinInt64), Decimal{-1, 1, 0}} {
+				if c, _ := Compare(math.NaN(), other, AnyType); c != -1 {
+					t.Errorf("%v: expected NaN first, got %d", other, c)
+				}
+			}
+			if c, _ := Compare(float32(math.NaN()), math.NaN(), AnyType); c != 0 {
+				t.Errorf("expected NaN to equal NaN, got %d", c)
+			}
+		},
+	)
+
+	t.Run(
+		"check order of kinds", func(t *testing.T) {
+			values := []any{
+				nil, true, int8(-1), "a", []byte{0}, UUID{}, Date(0), time.Unix(0, 0),
+				time.Duration(0), Array{Int32Type, []any{}}, Map{StringType, Int32Type, nil},
+			}
+			for i, a := range values {
+				for j, b := range values {
+					if c, _ := Compare(a, b, AnyType); c != compareOrdered(i, j) {
+						t.Errorf("%v, %v: expected %d, got %d", a, b, compareOrdered(i, j), c)
+					}
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check times and zoned times", func(t *testing.T) {
+			utc := time.Unix(100, 0)
+			if c, _ := Compare(utc, newZonedTime(100, 0, 0), AnyType); c != 0 {
+				t.Errorf("expected time to equal zoned time in UTC, got %d", c)
+			}
+			if c, _ := Compare(utc, newZonedTime(100, 0, 3600), AnyType); c != -1 {
+				t.Errorf("expected time to be ordered before zoned time, got %d", c)
+			}
+			if c, _ := Compare(newZonedTime(99, 0, 7200), utc, AnyType); c != -1 {
+				t.Errorf("expected earlier zoned time first, got %d", c)
+			}
+		},
+	)
+
+	t.Run(
+		"check arrays and maps", func(t *testing.T) {
+			arrays := []Array{
+				{Int32Type, []any{}},
+				{Int32Type, []any{nil}},
+				{Int32Type, []any{int32(1)}},
+				{Int32Type, []any{int32(1), int32(0)}},
+				{AnyType, []any{1.5}},
+				{Int32Type, []any{int32(2)}},
+			}
+			for i := 1; i < len(arrays); i++ {
+				if c, _ := Compare(arrays[i-1], arrays[i], ArrayType); c != -1 {
+					t.Errorf("%v, %v: expected -1, got %d", arrays[i-1], arrays[i], c)
+				}
+			}
+
+			maps := []Map{
+				{StringType, Int32Type, map[any]any{}},
+				{StringType, Int32Type, map[any]any{"a": int32(1)}},
+				{StringType, Int32Type, map[any]any{"a": int32(1), "b": int32(0)}},
+				{StringType, Int32Type, map[any]any{"a": int32(2)}},
+				{StringType, Int32Type, map[any]any{"b": int32(0)}},
+			}
+			for i := 1; i < len(maps); i++ {
+				if c, _ := Compare(maps[i-1], maps[i], MapType); c != -1 {
+					t.Errorf("%v, %v: expected -1, got %d", maps[i-1], maps[i], c)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check type mismatch", func(t *testing.T) {
+			if _, err := Compare(int32(1), int64(1), Int32Type); err == nil {
+				t.Error("expected error for int64 value of int32 type")
+			}
+			if _, err := Compare(struct{}{}, nil, AnyType); err == nil {
+				t.Error("expected error for unsupported value")
+			}
+		},
+	)
+
+	t.Run(
+		"check sort", func(t *testing.T) {
+			values := []any{"b", nil, int64(3), 2.5, uint8(1), "A", math.NaN(), "a"}
+			comparator := Comparator{Collation: CaseInsensitiveCollation, NullsLast: true}
+			if err := comparator.Sort(values, AnyType); err != nil {
+				t.Fatal(err)
+			}
+			if !math.IsNaN(values[0].(float64)) {
+				t.Errorf("expected NaN first, got %v", values[0])
+			}
+			expected := []any{uint8(1), 2.5, int64(3), "A", "a", "b", nil}
+			if !reflect.DeepEqual(values[1:], expected) {
+				t.Errorf("expected %v, got %v", expected, values[1:])
+			}
+			if err := comparator.Sort([]any{"a", int32(1)}, StringType); err == nil {
+				t.Error("expected error for int32 value of string type")
+			}
+		},
+	)
+}
+
+func TestCollation(t *testing.T) {
+	t.Run(
+		"check collations", func(t *testing.T) {
+			tests := []struct {
+				collation Collation
+				a, b      string
+				expected  int
+			}{
+				{BinaryCollation, "Go", "GO", 1},
+				{BinaryCollation, "\u00e9", "e\u0301", 1},
+				{CaseInsensitiveCollation, "Go", "gO", 0},
+				{CaseInsensitiveCollation, "straße", "STRASSE", 1},
+				{CaseInsensitiveCollation, "K", "k", 0},
+				{CaseInsensitiveCollation, "a", "B", -1},
+				{CaseInsensitiveCollation, "ab", "A", 1},
+				{NormalizedCollation, "\u00e9", "e\u0301", 0},
+				{NormalizedCollation, "\u00c9", "e\u0301", -1},
+				{NormalizedCaseInsensitiveCollation, "E\u0301", "\u00e9", 0},
+				{NormalizedCaseInsensitiveCollation, "\u212b", "\u00e5", 0},
+			}
+			for _, test := range tests {
+				if c := test.collation.Compare(test.a, test.b); c != test.expected {
+					t.Errorf("%q, %q: expected %d, got %d", test.a, test.b, test.expected, c)
+				}
+				if c := test.collation.Compare(test.b, test.a); c != -test.expected {
+					t.Errorf("%q, %q: expected %d, got %d", test.b, test.a, -test.expected, c)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check keys match comparison", func(t *testing.T) {
+			strings := []string{
+				"", "a", "A", "ab", "aB", "b", "\u00e9", "e\u0301", "E\u0301x", "K", "k", "\xff",
+				"\u1e9e", "\u00df", "\u03a3", "\u03c3", "\u03c2", "\u212b", "\u00c5",
+			}
+			collations := []Collation{
+				BinaryCollation, CaseInsensitiveCollation, NormalizedCollation,
+				NormalizedCaseInsensitiveCollation,
+			}
+			for _, collation := range collations {
+				for _, a := range strings {
+					for _, b := range strings {
+						expected := collation.Compare(a, b)
+						comparator := Comparator{Collation: collation}
+						encodedA, err := comparator.AppendKey(nil, a, Ascending)
+						if err != nil {
+							t.Fatal(err)
+						}
+						encodedB, _ := comparator.AppendKey(nil, b, Ascending)
+						if c := bytes.Compare(encodedA, encodedB); c != expected {
+							t.Errorf("%q, %q: expected %d, got %d", a, b, expected, c)
+						}
+					}
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check null keys match comparison", func(t *testing.T) {
+			values := []any{nil, "", "a", "\xff\xff"}
+			for _, nullsLast := range []bool{false, true} {
+				comparator := Comparator{NullsLast: nullsLast}
+				for _, order := range []SortOrder{Ascending, Descending} {
+					for _, a := range values {
+						for _, b := range values {
+							expected, err := comparator.Compare(a, b, StringType)
+							if err != nil {
+								t.Fatal(err)
+							}
+							if order == Descending {
+								expected = -expected
+							}
+							encodedA, err := comparator.AppendKey(nil, a, order)
+							if err != nil {
+								t.Fatal(err)
+							}
+							encodedB, _ := comparator.AppendKey(nil, b, order)
+							if c := bytes.Compare(encodedA, encodedB); c != expected {
+								t.Errorf(
+									"%v, %d, %q, %q: expected %d, got %d", nullsLast, order, a, b,
+									expected, c,
+								)
+							}
+							decoded, err := DecodeKey(encodedA, order)
+							if err != nil {
+								t.Fatal(err)
+							}
+							if len(decoded) != 1 || decoded[0] != a {
+								t.Errorf("%v, %d, %q: decoded %v", nullsLast, order, a, decoded)
+							}
+						}
+					}
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check registry", func(t *testing.T) {
+			for name, expected := range map[string]Collation{
+				"binary": BinaryCollation, "nocase": CaseInsensitiveCollation,
+				"nfc": NormalizedCollation, "nfc_nocase": NormalizedCaseInsensitiveCollation,
+			} {
+				if collation, ok := LookupCollation(name); !ok || collation != expected {
+					t.Errorf("%s: expected %v, got %v", name, expected, collation)
+				}
+			}
+			if _, ok := LookupCollation("missing"); ok {
+				t.Error("expected no collation")
+			}
+			RegisterCollation("test", BinaryCollation)
+			if collation, ok := LookupCollation("test"); !ok || collation != BinaryCollation {
+				t.Errorf("expected registered collation, got %v", collation)
+			}
+		},
+	)
+}
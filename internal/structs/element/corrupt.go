@@ -0,0 +1,55 @@
+package element
+
+import "fmt"
+
+// CorruptRecordError is returned when encoded bytes cannot be decoded because they are truncated or
+// malformed, for example when a length or an offset read from the bytes points past their end, or
+// when a type byte is not a known element type.
+type CorruptRecordError struct {
+	Offset uint32
+	Reason string
+}
+
+func (e *CorruptRecordError) Error() string {
+	return fmt.Sprintf("corrupt record at offset %d: %s", e.Offset, e.Reason)
+}
+
+// checkBounds returns a CorruptRecordError if fewer than numBytes bytes are available at the given
+// offset.
+func checkBounds(b *Bytes, offset uint32, numBytes uint64) error {
+	if uint64(offset)+numBytes > uint64(len(*b)) {
+		return &CorruptRecordError{
+			offset,
+			fmt.Sprintf("%d bytes needed but only %d available", numBytes, available(b, offset)),
+		}
+	}
+	return nil
+}
+
+// available returns the number of bytes at and after the given offset.
+func available(b *Bytes, offset uint32) uint32 {
+	if offset >= uint32(len(*b)) {
+		return 0
+	}
+	return uint32(len(*b)) - offset
+}
+
+// readType reads the type byte stored at the given offset.
+func readType(b *Bytes, offset uint32) (Type, error) {
+	if err := checkBounds(b, offset, 1); err != nil {
+		return NullType, err
+	}
+	return (*b)[offset], nil
+}
+
+// checkCount returns a CorruptRecordError if count values, each taking at least minSize bytes,
+// cannot fit in the bytes available at the given offset. It keeps a corrupted count from causing a
+// large allocation before the values themselves are found to be missing.
+func checkCount(b *Bytes, offset uint32, count uint32, minSize uint32) error {
+	if uint64(count)*uint64(minSize) > uint64(available(b, offset)) {
+		return &CorruptRecordError{
+			offset, fmt.Sprintf("%d values cannot fit in %d bytes", count, available(b, offset)),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package element
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// MaxDecimalPrecision is the largest number of significant digits a Decimal can have.
+	MaxDecimalPrecision = 18
+
+	// decimalSize is the number of bytes needed to store a Decimal: 1 byte each for the precision
+	// and the scale, followed by 8 bytes for the unscaled value.
+	decimalSize = 10
+)
+
+// Decimal is a fixed-point number with Precision significant digits, Scale of which come after the
+// decimal point. The number represented is Value / 10^Scale.
+type Decimal struct {
+	Value     int64
+	Precision uint8
+	Scale     uint8
+}
+
+// InvalidDecimalError is returned when a value cannot be represented as a Decimal with the given
+// precision and scale.
+type InvalidDecimalError struct {
+	value     any
+	precision uint8
+	scale     uint8
+}
+
+func (e *InvalidDecimalError) Error() string {
+	return fmt.Sprintf(
+		"cannot represent %v as a decimal with precision=%d and scale=%d",
+		e.value, e.precision, e.scale,
+	)
+}
+
+// numDigits returns the number of decimal digits in the absolute value of the given integer.
+func numDigits(value int64) uint8 {
+	digits := uint8(1)
+	for value >= 10 || value <= -10 {
+		value /= 10
+		digits++
+	}
+	return digits
+}
+
+// NewDecimal returns a Decimal with the given unscaled value, precision and scale. An
+// InvalidDecimalError is returned if the precision is larger than MaxDecimalPrecision, the scale is
+// larger than the precision or the value has more digits than the precision allows.
+func NewDecimal(value int64, precision uint8, scale uint8) (Decimal, error) {
+	d := Decimal{Value: value, Precision: precision, Scale: scale}
+	if err := d.Validate(); err != nil {
+		return Decimal{}, err
+	}
+	return d, nil
+}
+
+// Validate returns an InvalidDecimalError if the decimal could not have been returned by
+// NewDecimal.
+func (d Decimal) Validate() error {
+	if d.Precision == 0 || d.Precision > MaxDecimalPrecision || d.Scale > d.Precision ||
+		numDigits(d.Value) > d.Precision {
+		return &InvalidDecimalError{d.Value, d.Precision, d.Scale}
+	}
+	return nil
+}
+
+// ParseDecimal parses a number such as "-12.345" into a Decimal with the given precision and
+// scale. Digits after the decimal point beyond the scale are not allowed, missing ones are treated
+// as zeros.
+func ParseDecimal(s string, precision uint8, scale uint8) (Decimal, error) {
+	invalid := &InvalidDecimalError{s, precision, scale}
+	digits := s
+	negative := strings.HasPrefix(digits, "-")
+	if negative || strings.HasPrefix(digits, "+") {
+		digits = digits[1:]
+	}
+	integral, fractional, _ := strings.Cut(digits, ".")
+	if integral == "" && fractional == "" {
+		return Decimal{}, invalid
+	}
+	if len(fractional) > int(scale) {
+		return Decimal{}, invalid
+	}
+	fractional += strings.Repeat("0", int(scale)-len(fractional))
+
+	var value int64
+	for _, c := range integral + fractional {
+		if c < '0' || c > '9' {
+			return Decimal{}, invalid
+		}
+		if value > (1<<63-1-9)/10 {
+			return Decimal{}, invalid
+		}
+		value = value*10 + int64(c-'0')
+	}
+	if negative {
+		value = -value
+	}
+	d, err := NewDecimal(value, precision, scale)
+	if err != nil {
+		return Decimal{}, invalid
+	}
+	return d, nil
+}
+
+// String returns the decimal formatted with exactly Scale digits after the decimal point.
+func (d Decimal) String() string {
+	sign := ""
+	value := d.Value
+	if value < 0 {
+		sign = "-"
+	}
+	digits := fmt.Sprintf("%d", value)
+	digits = strings.TrimPrefix(digits, "-")
+	if d.Scale == 0 {
+		return sign + digits
+	}
+	if len(digits) <= int(d.Scale) {
+		digits = strings.Repeat("0", int(d.Scale)-len(digits)+1) + digits
+	}
+	point := len(digits) - int(d.Scale)
+	return sign + digits[:point] + "." + digits[point:]
+}
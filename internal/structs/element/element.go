@@ -8,23 +8,51 @@ import (
 )
 
 const (
-	NullType    Type = '\x00'
-	Uint32Type  Type = 'u'
-	Uint64Type  Type = 'v'
-	Int32Type   Type = 'i'
-	Int64Type   Type = 'l'
-	Float32Type Type = 'f'
-	Float64Type Type = 'd'
-	BoolType    Type = 'b'
-	StringType  Type = 's'
-	TimeType    Type = 't'
-	ArrayType   Type = 'a'
-	MapType     Type = 'm'
-	AnyType     Type = 'x'
+	NullType      Type = '\x00'
+	Uint8Type     Type = 'C'
+	Uint16Type    Type = 'H'
+	Uint32Type    Type = 'u'
+	Uint64Type    Type = 'v'
+	Int8Type      Type = 'c'
+	Int16Type     Type = 'h'
+	Int32Type     Type = 'i'
+	Int64Type     Type = 'l'
+	Float32Type   Type = 'f'
+	Float64Type   Type = 'd'
+	BoolType      Type = 'b'
+	StringType    Type = 's'
+	BytesType     Type = 'y'
+	UUIDType      Type = 'g'
+	DecimalType   Type = 'n'
+	TimeType      Type = 't'
+	DateType      Type = 'D'
+	DurationType  Type = 'r'
+	ZonedTimeType Type = 'z'
+	ArrayType     Type = 'a'
+	MapType       Type = 'm'
+	AnyType       Type = 'x'
+)
+
+// Encoding is a set of flags selecting how elements are written. LegacyEncoding uses 16-bit
+// lengths and counts, which caps strings, arrays and maps at 65,535 bytes or entries, and stores
+// integers and times with a fixed width.
+// WideEncoding uses 32-bit lengths and counts instead.
+// CompactEncoding stores 32-bit and 64-bit integers, dates and durations as LEB128 varints, zigzag
+// encoded for signed values, and times as zigzag encoded varints of their Unix nanoseconds. Arrays
+// of times store the first time followed by the differences between consecutive times. Only values
+// are stored as varints: lengths and counts, and the offsets in the header of a record, keep the
+// fixed width of LegacyEncoding or WideEncoding, so that the element at a position is found
+// without reading the elements before it and lengths can be rewritten in place. The encoding
+// applies to a whole record; the columns of a record cannot be encoded differently.
+const (
+	LegacyEncoding  Encoding = 0
+	WideEncoding    Encoding = 1 << 0
+	CompactEncoding Encoding = 1 << 1
 )
 
 type Bytes = []byte
 type Type = byte
+type Encoding = byte
 
 type Array struct {
 	ElementType Type
@@ -37,6 +65,10 @@ type Map struct {
 	Data      map[any]any
 }
 
+// MaxNestingDepth is the largest number of arrays and maps that can be nested within each other,
+// counting the outermost one.
+const MaxNestingDepth = 32
+
 // TypeMismatchError is returned when the type of the user-provided value does not match the type
 // of the element expected at a position.
 type TypeMismatchError struct {
@@ -49,6 +81,24 @@ type UnrecognizedTypeError struct {
 	value any
 }
 
+// NestingDepthError is returned when arrays and maps are nested deeper than MaxNestingDepth.
+type NestingDepthError struct {
+	depth int
+}
+
+func (e *NestingDepthError) Error() string {
+	return fmt.Sprintf("nesting depth %d exceeds maximum of %d", e.depth, MaxNestingDepth)
+}
+
+// typeMismatch returns a TypeMismatchError for a value that is not of the expected type.
+func typeMismatch(expectedType Type, value any) error {
+	actualType, err := TypeForValue(value)
+	if err != nil {
+		return err
+	}
+	return &TypeMismatchError{expectedType, actualType}
+}
+
 func (e *TypeMismatchError) Error() string {
 	expectedTypeName, err := NameForType(e.Expected)
 	if err != nil {
@@ -71,10 +121,18 @@ func NameForType(elemType Type) (string, error) {
 	switch elemType {
 	case NullType:
 		elemTypeName = "null"
+	case Uint8Type:
+		elemTypeName = "uint8"
+	case Uint16Type:
+		elemTypeName = "uint16"
 	case Uint32Type:
 		elemTypeName = "uint32"
 	case Uint64Type:
 		elemTypeName = "uint64"
+	case Int8Type:
+		elemTypeName = "int8"
+	case Int16Type:
+		elemTypeName = "int16"
 	case Int32Type:
 		elemTypeName = "int32"
 	case Int64Type:
@@ -87,12 +145,26 @@ func NameForType(elemType Type) (string, error) {
 		elemTypeName = "bool"
 	case StringType:
 		elemTypeName = "string"
+	case BytesType:
+		elemTypeName = "bytes"
+	case UUIDType:
+		elemTypeName = "uuid"
+	case DecimalType:
+		elemTypeName = "decimal"
 	case TimeType:
 		elemTypeName = "time"
+	case DateType:
+		elemTypeName = "date"
+	case DurationType:
+		elemTypeName = "duration"
+	case ZonedTimeType:
+		elemTypeName = "zonedtime"
 	case ArrayType:
 		elemTypeName = "array"
 	case MapType:
 		elemTypeName = "map"
+	case AnyType:
+		elemTypeName = "any"
 	default:
 		err = &UnrecognizedTypeError{elemType}
 	}
@@ -103,13 +175,23 @@ func TypeForValue(value any) (Type, error) {
 	var elemType Type
 	var err error
 	switch value.(type) {
+	case nil:
+		elemType = NullType
 	case bool:
 		elemType = BoolType
-	case uint32:
+	case uint8:
+		elemType = Uint8Type
+	case uint16:
+		elemType = Uint16Type
+	case uint, uint32:
 		elemType = Uint32Type
 	case uint64:
 		elemType = Uint64Type
-	case int32:
+	case int8:
+		elemType = Int8Type
+	case int16:
+		elemType = Int16Type
+	case int, int32:
 		elemType = Int32Type
 	case int64:
 		elemType = Int64Type
@@ -119,8 +201,20 @@ func TypeForValue(value any) (Type, error) {
 		elemType = Float64Type
 	case string:
 		elemType = StringType
+	case []byte:
+		elemType = BytesType
+	case UUID:
+		elemType = UUIDType
+	case Decimal:
+		elemType = DecimalType
 	case time.Time:
 		elemType = TimeType
+	case Date:
+		elemType = DateType
+	case time.Duration:
+		elemType = DurationType
+	case ZonedTime:
+		elemType = ZonedTimeType
 	case Array:
 		elemType = ArrayType
 	case Map:
@@ -135,76 +229,257 @@ func IsPrimitiveType(elemType Type) bool {
 	return elemType != NullType && elemType != ArrayType && elemType != MapType
 }
 
-func BytesNeededForString(str string) uint16 {
-	return uint16(len(str)) + 2
+// LengthFieldSize returns the number of bytes used to store the length of a string, or the number
+// of entries in an array or a map, in the given encoding.
+func LengthFieldSize(encoding Encoding) uint32 {
+	if encoding&WideEncoding != 0 {
+		return 4
+	}
+	return 2
+}
+
+func BytesNeededForString(str string, encoding Encoding) uint32 {
+	return uint32(len(str)) + LengthFieldSize(encoding)
+}
+
+// BytesNeededForUvarint returns the number of bytes needed to store the given value as a LEB128
+// varint.
+func BytesNeededForUvarint(value uint64) uint32 {
+	bytesNeeded := uint32(1)
+	for value >= 0x80 {
+		value >>= 7
+		bytesNeeded++
+	}
+	return bytesNeeded
+}
+
+// zigzag maps signed integers to unsigned integers so that values of small magnitude, negative or
+// positive, have small varint encodings.
+func zigzag(value int64) uint64 {
+	return uint64(value<<1) ^ uint64(value>>63)
+}
+
+func unzigzag(value uint64) int64 {
+	return int64(value>>1) ^ -int64(value&1)
+}
+
+// varintForValue returns the unsigned integer that is stored as a varint for the given value in
+// CompactEncoding, along with the element type of the value. The last return value is false if the
+// value is not stored as a varint.
+func varintForValue(value any) (uint64, Type, bool) {
+	switch v := value.(type) {
+	case uint:
+		return uint64(uint32(v)), Uint32Type, true
+	case uint32:
+		return uint64(v), Uint32Type, true
+	case uint64:
+		return v, Uint64Type, true
+	case int:
+		return zigzag(int64(int32(v))), Int32Type, true
+	case int32:
+		return zigzag(int64(v)), Int32Type, true
+	case int64:
+		return zigzag(v), Int64Type, true
+	case time.Time:
+		return zigzag(v.UnixNano()), TimeType, true
+	case Date:
+		return zigzag(int64(v)), DateType, true
+	case time.Duration:
+		return zigzag(int64(v)), DurationType, true
+	}
+	return 0, NullType, false
 }
 
-func BytesNeededForPrimitive(value any) (uint16, error) {
-	var bytesNeeded uint16
+// timeDeltas returns the differences in nanoseconds between consecutive times in the given values,
+// the first time being relative to the Unix epoch.
+func timeDeltas(values []any) ([]int64, error) {
+	deltas := make([]int64, len(values))
+	var previous int64
+	for i, value := range values {
+		t, ok := value.(time.Time)
+		if !ok {
+			return nil, typeMismatch(TimeType, value)
+		}
+		if err := CheckTimeRange(t); err != nil {
+			return nil, err
+		}
+		deltas[i] = t.UnixNano() - previous
+		previous = t.UnixNano()
+	}
+	return deltas, nil
+}
+
+// isTimeDeltaArray returns true if the values of an array with the given element type are stored
+// as time deltas in the given encoding.
+func isTimeDeltaArray(elementType Type, encoding Encoding) bool {
+	return encoding&CompactEncoding != 0 && elementType == TimeType
+}
+
+// checkVarintRange returns a CorruptRecordError if the given varint, read at the given offset, is
+// out of range for values of the given element type in CompactEncoding.
+func checkVarintRange(varint uint64, elemType Type, offset uint32) error {
+	switch elemType {
+	case Uint32Type:
+		if varint > math.MaxUint32 {
+			return &CorruptRecordError{
+				offset, fmt.Sprintf("varint %d out of range for uint32", varint),
+			}
+		}
+	case Int32Type, DateType:
+		if value := unzigzag(varint); value < math.MinInt32 || value > math.MaxInt32 {
+			return &CorruptRecordError{
+				offset, fmt.Sprintf("varint %d out of range for int32", value),
+			}
+		}
+	}
+	return nil
+}
+
+// isVarintType returns true if values of the given element type are stored as varints in
+// CompactEncoding.
+func isVarintType(elemType Type) bool {
+	switch elemType {
+	case Uint32Type, Uint64Type, Int32Type, Int64Type, TimeType, DateType, DurationType:
+		return true
+	}
+	return false
+}
+
+func BytesNeededForPrimitive(value any, encoding Encoding) (uint32, error) {
+	if encoding&CompactEncoding != 0 {
+		if varint, _, ok := varintForValue(value); ok {
+			return BytesNeededForUvarint(varint), nil
+		}
+	}
+
+	var bytesNeeded uint32
 	var err error
 	switch value.(type) {
-	case bool:
+	case bool, uint8, int8:
 		bytesNeeded = 1
-	case uint, uint32, int, int32, float32:
+	case uint16, int16:
+		bytesNeeded = 2
+	case uint, uint32, int, int32, float32, Date:
 		bytesNeeded = 4
-	case uint64, int64, float64, time.Time:
+	case uint64, int64, float64, time.Time, time.Duration:
 		bytesNeeded = 8
+	case ZonedTime:
+		bytesNeeded = zonedTimeSize
+	case Decimal:
+		bytesNeeded = decimalSize
+	case UUID:
+		bytesNeeded = 16
 	case string:
-		bytesNeeded = BytesNeededForString(value.(string))
+		bytesNeeded = BytesNeededForString(value.(string), encoding)
+	case []byte:
+		bytesNeeded = uint32(len(value.([]byte))) + LengthFieldSize(encoding)
 	default:
 		err = fmt.Errorf("unsupported primitive type %T", value)
 	}
 	return bytesNeeded, err
 }
 
-func BytesNeededForArray(a Array) (uint16, error) {
-	var bytesNeeded uint16
-	var err error
+// bytesNeededForValue returns the number of bytes needed to store the given value as an element of
+// the given type. Values of AnyType elements are preceded by a byte storing their actual type.
+func bytesNeededForValue(
+	value any, elemType Type, encoding Encoding, depth int,
+) (uint32, error) {
+	switch elemType {
+	case AnyType:
+		actualType, err := TypeForValue(value)
+		if err != nil {
+			return 0, err
+		}
+		if actualType == NullType {
+			return 1, nil
+		}
+		bytesNeeded, err := bytesNeededForValue(value, actualType, encoding, depth)
+		return bytesNeeded + 1, err
+	case ArrayType:
+		a, ok := value.(Array)
+		if !ok {
+			return 0, typeMismatch(ArrayType, value)
+		}
+		return bytesNeededForArray(a, encoding, depth+1)
+	case MapType:
+		m, ok := value.(Map)
+		if !ok {
+			return 0, typeMismatch(MapType, value)
+		}
+		return bytesNeededForMap(m, encoding, depth+1)
+	default:
+		return BytesNeededForPrimitive(value, encoding)
+	}
+}
+
+func bytesNeededForArray(a Array, encoding Encoding, depth int) (uint32, error) {
+	if depth > MaxNestingDepth {
+		return 0, &NestingDepthError{depth}
+	}
+	bytesNeeded := LengthFieldSize(encoding) + 1
+	if isTimeDeltaArray(a.ElementType, encoding) {
+		deltas, err := timeDeltas(a.Values)
+		if err != nil {
+			return 0, err
+		}
+		for _, delta := range deltas {
+			bytesNeeded += BytesNeededForUvarint(zigzag(delta))
+		}
+		return bytesNeeded, nil
+	}
 	for _, value := range a.Values {
-		bytesNeededForElement, err := BytesNeededForPrimitive(value)
+		bytesNeededForElement, err := bytesNeededForValue(value, a.ElementType, encoding, depth)
 		if err != nil {
-			break
+			return 0, err
 		}
 		bytesNeeded += bytesNeededForElement
 	}
-	return bytesNeeded + 3, err
+	return bytesNeeded, nil
 }
 
-func BytesNeededForMap(m Map) (uint16, error) {
-	var bytesNeeded uint16
-	var err error
+func BytesNeededForArray(a Array, encoding Encoding) (uint32, error) {
+	return bytesNeededForArray(a, encoding, 1)
+}
+
+func bytesNeededForMap(m Map, encoding Encoding, depth int) (uint32, error) {
+	if depth > MaxNestingDepth {
+		return 0, &NestingDepthError{depth}
+	}
+	bytesNeeded := LengthFieldSize(encoding) + 2
+	if mapHasIndex(m.KeyType, m.ValueType, encoding) {
+		bytesNeeded += uint32(len(m.Data)) * LengthFieldSize(encoding)
+	}
 	for key, value := range m.Data {
-		bytesNeededForKey, err := BytesNeededForPrimitive(key)
+		bytesNeededForKey, err := bytesNeededForValue(key, m.KeyType, encoding, depth)
 		if err != nil {
-			break
-		}
-		var bytesNeededForValue uint16
-		if m.ValueType == ArrayType {
-			bytesNeededForValue, err = BytesNeededForArray(value.(Array))
-		} else {
-			bytesNeededForValue, err = BytesNeededForPrimitive(value)
+			return 0, err
 		}
+		bytesNeededForValue, err := bytesNeededForValue(value, m.ValueType, encoding, depth)
 		if err != nil {
-			break
+			return 0, err
 		}
 		bytesNeeded += bytesNeededForKey + bytesNeededForValue
 	}
-	return bytesNeeded + 4, err
+	return bytesNeeded, nil
+}
+
+func BytesNeededForMap(m Map, encoding Encoding) (uint32, error) {
+	return bytesNeededForMap(m, encoding, 1)
 }
 
-func WriteUint16(b *Bytes, offset uint16, value uint16) {
+func WriteUint16(b *Bytes, offset uint32, value uint16) {
 	(*b)[offset] = byte(value)
 	(*b)[offset+1] = byte(value >> 8)
 }
 
-func WriteUint32(b *Bytes, offset uint16, value uint32) {
+func WriteUint32(b *Bytes, offset uint32, value uint32) {
 	(*b)[offset] = byte(value)
 	(*b)[offset+1] = byte(value >> 8)
 	(*b)[offset+2] = byte(value >> 16)
 	(*b)[offset+3] = byte(value >> 24)
 }
 
-func WriteUint64(b *Bytes, offset uint16, value uint64) {
+func WriteUint64(b *Bytes, offset uint32, value uint64) {
 	(*b)[offset] = byte(value)
 	(*b)[offset+1] = byte(value >> 8)
 	(*b)[offset+2] = byte(value >> 16)
@@ -215,7 +490,7 @@ func WriteUint64(b *Bytes, offset uint16, value uint64) {
 	(*b)[offset+7] = byte(value >> 56)
 }
 
-func WriteBool(b *Bytes, offset uint16, value bool) {
+func WriteBool(b *Bytes, offset uint32, value bool) {
 	if value {
 		(*b)[offset] = 1
 	} else {
@@ -223,14 +498,44 @@ func WriteBool(b *Bytes, offset uint16, value bool) {
 	}
 }
 
-func WriteString(b *Bytes, offset uint16, value string) {
-	strLen := uint16(len(value))
-	(*b)[offset] = byte(strLen)
-	(*b)[offset+1] = byte(strLen >> 8)
-	copy((*b)[offset+2:offset+2+strLen], value)
+// WriteUvarint writes the given value as a LEB128 varint. It returns the offset right after the
+// written varint.
+func WriteUvarint(b *Bytes, offset uint32, value uint64) uint32 {
+	return offset + uint32(binary.PutUvarint((*b)[offset:], value))
+}
+
+// WriteLength writes the length of a string, or the number of entries in an array or a map, in the
+// given encoding. It returns the offset right after the written length.
+func WriteLength(b *Bytes, offset uint32, length uint32, encoding Encoding) uint32 {
+	if encoding&WideEncoding != 0 {
+		WriteUint32(b, offset, length)
+	} else {
+		WriteUint16(b, offset, uint16(length))
+	}
+	return offset + LengthFieldSize(encoding)
+}
+
+func WriteString(b *Bytes, offset uint32, value string, encoding Encoding) {
+	strLen := uint32(len(value))
+	offset = WriteLength(b, offset, strLen, encoding)
+	copy((*b)[offset:offset+strLen], value)
+}
+
+func WriteBytes(b *Bytes, offset uint32, value []byte, encoding Encoding) {
+	numBytes := uint32(len(value))
+	offset = WriteLength(b, offset, numBytes, encoding)
+	copy((*b)[offset:offset+numBytes], value)
 }
 
-func WritePrimitive(b *Bytes, offset uint16, value any, expectedType Type) (uint16, error) {
+func WriteDecimal(b *Bytes, offset uint32, value Decimal) {
+	(*b)[offset] = value.Precision
+	(*b)[offset+1] = value.Scale
+	WriteUint64(b, offset+2, uint64(value.Value))
+}
+
+func WritePrimitive(
+	b *Bytes, offset uint32, value any, expectedType Type, encoding Encoding,
+) (uint32, error) {
 	checkElementType := func(actualType Type) error {
 		if expectedType == AnyType || expectedType == actualType {
 			return nil
@@ -238,9 +543,50 @@ func WritePrimitive(b *Bytes, offset uint16, value any, expectedType Type) (uint
 		return &TypeMismatchError{expectedType, actualType}
 	}
 
-	var offsetAfterWrite uint16
+	var offsetAfterWrite uint32
 	var err error
+	if encoding&CompactEncoding != 0 {
+		if varint, actualType, ok := varintForValue(value); ok {
+			if err = checkElementType(actualType); err != nil {
+				return offset, err
+			}
+			if t, isTime := value.(time.Time); isTime {
+				if err = CheckTimeRange(t); err != nil {
+					return offset, err
+				}
+			}
+			return WriteUvarint(b, offset, varint), nil
+		}
+	}
 	switch value.(type) {
+	case uint8:
+		if err = checkElementType(Uint8Type); err != nil {
+			offsetAfterWrite = offset
+			break
+		}
+		(*b)[offset] = value.(uint8)
+		offsetAfterWrite = offset + 1
+	case uint16:
+		if err = checkElementType(Uint16Type); err != nil {
+			offsetAfterWrite = offset
+			break
+		}
+		WriteUint16(b, offset, value.(uint16))
+		offsetAfterWrite = offset + 2
+	case int8:
+		if err = checkElementType(Int8Type); err != nil {
+			offsetAfterWrite = offset
+			break
+		}
+		(*b)[offset] = byte(value.(int8))
+		offsetAfterWrite = offset + 1
+	case int16:
+		if err = checkElementType(Int16Type); err != nil {
+			offsetAfterWrite = offset
+			break
+		}
+		WriteUint16(b, offset, uint16(value.(int16)))
+		offsetAfterWrite = offset + 2
 	case uint:
 		if err = checkElementType(Uint32Type); err != nil {
 			offsetAfterWrite = offset
@@ -309,32 +655,125 @@ func WritePrimitive(b *Bytes, offset uint16, value any, expectedType Type) (uint
 			offsetAfterWrite = offset
 			break
 		}
-		WriteString(b, offset, value.(string))
-		offsetAfterWrite = offset + BytesNeededForString(value.(string))
+		WriteString(b, offset, value.(string), encoding)
+		offsetAfterWrite = offset + BytesNeededForString(value.(string), encoding)
+	case []byte:
+		if err = checkElementType(BytesType); err != nil {
+			offsetAfterWrite = offset
+			break
+		}
+		WriteBytes(b, offset, value.([]byte), encoding)
+		offsetAfterWrite = offset + LengthFieldSize(encoding) + uint32(len(value.([]byte)))
+	case UUID:
+		if err = checkElementType(UUIDType); err != nil {
+			offsetAfterWrite = offset
+			break
+		}
+		uuid := value.(UUID)
+		copy((*b)[offset:offset+16], uuid[:])
+		offsetAfterWrite = offset + 16
+	case Decimal:
+		if err = checkElementType(DecimalType); err != nil {
+			offsetAfterWrite = offset
+			break
+		}
+		if err = value.(Decimal).Validate(); err != nil {
+			offsetAfterWrite = offset
+			break
+		}
+		WriteDecimal(b, offset, value.(Decimal))
+		offsetAfterWrite = offset + decimalSize
 	case time.Time:
 		if err = checkElementType(TimeType); err != nil {
 			offsetAfterWrite = offset
 			break
 		}
+		if err = CheckTimeRange(value.(time.Time)); err != nil {
+			offsetAfterWrite = offset
+			break
+		}
 		WriteUint64(b, offset, uint64(value.(time.Time).UnixNano()))
 		offsetAfterWrite = offset + 8
+	case Date:
+		if err = checkElementType(DateType); err != nil {
+			offsetAfterWrite = offset
+			break
+		}
+		WriteUint32(b, offset, uint32(value.(Date)))
+		offsetAfterWrite = offset + 4
+	case time.Duration:
+		if err = checkElementType(DurationType); err != nil {
+			offsetAfterWrite = offset
+			break
+		}
+		WriteUint64(b, offset, uint64(value.(time.Duration)))
+		offsetAfterWrite = offset + 8
+	case ZonedTime:
+		if err = checkElementType(ZonedTimeType); err != nil {
+			offsetAfterWrite = offset
+			break
+		}
+		WriteZonedTime(b, offset, value.(ZonedTime))
+		offsetAfterWrite = offset + zonedTimeSize
 	default:
 		err = fmt.Errorf("unsupported primitive type %T", value)
 	}
 	return offsetAfterWrite, err
 }
 
-func WriteArray(b *Bytes, offset uint16, a Array) (uint16, error) {
-	newOffset := offset
-	(*b)[newOffset] = byte(len(a.Values))
-	newOffset++
-	(*b)[newOffset] = byte(len(a.Values) >> 8)
-	newOffset++
+// writeValue writes the given value as an element of the given type and returns the offset right
+// after it. Values of AnyType elements are preceded by a byte storing their actual type.
+func writeValue(
+	b *Bytes, offset uint32, value any, elemType Type, encoding Encoding, depth int,
+) (uint32, error) {
+	switch elemType {
+	case AnyType:
+		actualType, err := TypeForValue(value)
+		if err != nil {
+			return offset, err
+		}
+		(*b)[offset] = actualType
+		if actualType == NullType {
+			return offset + 1, nil
+		}
+		return writeValue(b, offset+1, value, actualType, encoding, depth)
+	case ArrayType:
+		a, ok := value.(Array)
+		if !ok {
+			return offset, typeMismatch(ArrayType, value)
+		}
+		return writeArray(b, offset, a, encoding, depth+1)
+	case MapType:
+		m, ok := value.(Map)
+		if !ok {
+			return offset, typeMismatch(MapType, value)
+		}
+		return writeMap(b, offset, m, encoding, depth+1)
+	default:
+		return WritePrimitive(b, offset, value, elemType, encoding)
+	}
+}
+
+func writeArray(b *Bytes, offset uint32, a Array, encoding Encoding, depth int) (uint32, error) {
+	if depth > MaxNestingDepth {
+		return offset, &NestingDepthError{depth}
+	}
+	newOffset := WriteLength(b, offset, uint32(len(a.Values)), encoding)
 	(*b)[newOffset] = a.ElementType
 	newOffset++
+	if isTimeDeltaArray(a.ElementType, encoding) {
+		deltas, err := timeDeltas(a.Values)
+		if err != nil {
+			return offset, err
+		}
+		for _, delta := range deltas {
+			newOffset = WriteUvarint(b, newOffset, zigzag(delta))
+		}
+		return newOffset, nil
+	}
 	for _, value := range a.Values {
 		var err error
-		newOffset, err = WritePrimitive(b, newOffset, value, a.ElementType)
+		newOffset, err = writeValue(b, newOffset, value, a.ElementType, encoding, depth)
 		if err != nil {
 			return offset, err
 		}
@@ -342,27 +781,40 @@ func WriteArray(b *Bytes, offset uint16, a Array) (uint16, error) {
 	return newOffset, nil
 }
 
-func WriteMap(b *Bytes, offset uint16, m Map) (uint16, error) {
-	newOffset := offset
-	(*b)[newOffset] = byte(len(m.Data))
-	newOffset++
-	(*b)[newOffset] = byte(len(m.Data) >> 8)
-	newOffset++
-	(*b)[newOffset] = m.KeyType
+func WriteArray(b *Bytes, offset uint32, a Array, encoding Encoding) (uint32, error) {
+	return writeArray(b, offset, a, encoding, 1)
+}
+
+func writeMap(b *Bytes, offset uint32, m Map, encoding Encoding, depth int) (uint32, error) {
+	if depth > MaxNestingDepth {
+		return offset, &NestingDepthError{depth}
+	}
+	newOffset := WriteLength(b, offset, uint32(len(m.Data)), encoding)
+	(*b)[newOffset] = m.KeyType | sortedMapFlag
 	newOffset++
 	(*b)[newOffset] = m.ValueType
 	newOffset++
-	for key, value := range m.Data {
+	index := newOffset
+	if mapHasIndex(m.KeyType, m.ValueType, encoding) {
+		newOffset += uint32(len(m.Data)) * LengthFieldSize(encoding)
+	}
+	entries := newOffset
+	for i, key := range SortedKeys(m.Data) {
+		if index != entries {
+			relative := newOffset - entries
+			if encoding&WideEncoding == 0 && relative > math.MaxUint16 {
+				return offset, fmt.Errorf(
+					"map entries take more than %d bytes", math.MaxUint16,
+				)
+			}
+			WriteLength(b, index+uint32(i)*LengthFieldSize(encoding), relative, encoding)
+		}
 		var err error
-		newOffset, err = WritePrimitive(b, newOffset, key, m.KeyType)
+		newOffset, err = writeValue(b, newOffset, key, m.KeyType, encoding, depth)
 		if err != nil {
 			return offset, err
 		}
-		if m.ValueType == ArrayType {
-			newOffset, err = WriteArray(b, newOffset, value.(Array))
-		} else {
-			newOffset, err = WritePrimitive(b, newOffset, value, m.ValueType)
-		}
+		newOffset, err = writeValue(b, newOffset, m.Data[key], m.ValueType, encoding, depth)
 		if err != nil {
 			return offset, err
 		}
@@ -370,32 +822,136 @@ func WriteMap(b *Bytes, offset uint16, m Map) (uint16, error) {
 	return newOffset, nil
 }
 
-func ReadUint16(b *Bytes, offset uint16) uint16 {
+func WriteMap(b *Bytes, offset uint32, m Map, encoding Encoding) (uint32, error) {
+	return writeMap(b, offset, m, encoding, 1)
+}
+
+func ReadUint16(b *Bytes, offset uint32) uint16 {
 	return binary.LittleEndian.Uint16((*b)[offset : offset+2])
 }
 
-func ReadUint32(b *Bytes, offset uint16) uint32 {
+func ReadUint32(b *Bytes, offset uint32) uint32 {
 	return binary.LittleEndian.Uint32((*b)[offset : offset+4])
 }
 
-func ReadUint64(b *Bytes, offset uint16) uint64 {
+func ReadUint64(b *Bytes, offset uint32) uint64 {
 	return binary.LittleEndian.Uint64((*b)[offset : offset+8])
 }
 
-func ReadBool(b *Bytes, offset uint16) bool {
+func ReadBool(b *Bytes, offset uint32) bool {
 	return (*b)[offset] == 1
 }
 
-func ReadString(b *Bytes, offset uint16) (string, uint16) {
-	strLen := binary.LittleEndian.Uint16((*b)[offset : offset+2])
-	return string((*b)[offset+2 : offset+2+strLen]), strLen
+// ReadUvarint reads a LEB128 varint. It returns the value and the offset right after the varint.
+func ReadUvarint(b *Bytes, offset uint32) (uint64, uint32, error) {
+	if err := checkBounds(b, offset, 1); err != nil {
+		return 0, offset, err
+	}
+	value, n := binary.Uvarint((*b)[offset:])
+	if n <= 0 {
+		return 0, offset, &CorruptRecordError{offset, "truncated or overflowing varint"}
+	}
+	return value, offset + uint32(n), nil
+}
+
+// ReadLength reads the length of a string, or the number of entries in an array or a map, in the
+// given encoding. It returns the length and the offset right after it.
+func ReadLength(b *Bytes, offset uint32, encoding Encoding) (uint32, uint32, error) {
+	size := LengthFieldSize(encoding)
+	if err := checkBounds(b, offset, uint64(size)); err != nil {
+		return 0, offset, err
+	}
+	if size == 4 {
+		return ReadUint32(b, offset), offset + 4, nil
+	}
+	return uint32(ReadUint16(b, offset)), offset + 2, nil
+}
+
+// ReadString returns the string stored at the given offset, along with its length in bytes.
+func ReadString(b *Bytes, offset uint32, encoding Encoding) (string, uint32, error) {
+	strLen, offset, err := ReadLength(b, offset, encoding)
+	if err != nil {
+		return "", 0, err
+	}
+	if err = checkBounds(b, offset, uint64(strLen)); err != nil {
+		return "", 0, err
+	}
+	return string((*b)[offset : offset+strLen]), strLen, nil
+}
+
+// ReadBytes returns a copy of the bytes stored at the given offset, along with their number.
+func ReadBytes(b *Bytes, offset uint32, encoding Encoding) ([]byte, uint32, error) {
+	numBytes, offset, err := ReadLength(b, offset, encoding)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err = checkBounds(b, offset, uint64(numBytes)); err != nil {
+		return nil, 0, err
+	}
+	value := make([]byte, numBytes)
+	copy(value, (*b)[offset:offset+numBytes])
+	return value, numBytes, nil
 }
 
-func ReadPrimitive(b *Bytes, offset uint16, expectedType Type) (any, uint16, error) {
+func ReadDecimal(b *Bytes, offset uint32) Decimal {
+	return Decimal{
+		Value:     int64(ReadUint64(b, offset+2)),
+		Precision: (*b)[offset],
+		Scale:     (*b)[offset+1],
+	}
+}
+
+func ReadPrimitive(
+	b *Bytes, offset uint32, expectedType Type, encoding Encoding,
+) (any, uint32, error) {
 	var value any
-	var offsetAfterRead uint16
+	var offsetAfterRead uint32
 	var err error
+	if encoding&CompactEncoding != 0 && isVarintType(expectedType) {
+		var varint uint64
+		varint, offsetAfterRead, err = ReadUvarint(b, offset)
+		if err == nil {
+			err = checkVarintRange(varint, expectedType, offset)
+		}
+		if err != nil {
+			return nil, offset, err
+		}
+		switch expectedType {
+		case Uint32Type:
+			value = uint32(varint)
+		case Uint64Type:
+			value = varint
+		case Int32Type:
+			value = int32(unzigzag(varint))
+		case Int64Type:
+			value = unzigzag(varint)
+		case TimeType:
+			value = time.Unix(0, unzigzag(varint))
+		case DateType:
+			value = Date(unzigzag(varint))
+		case DurationType:
+			value = time.Duration(unzigzag(varint))
+		}
+		return value, offsetAfterRead, nil
+	}
+	if size, ok := fixedSizeForType(expectedType, encoding); ok {
+		if err = checkBounds(b, offset, uint64(size)); err != nil {
+			return nil, offset, err
+		}
+	}
 	switch expectedType {
+	case Uint8Type:
+		value = (*b)[offset]
+		offsetAfterRead = offset + 1
+	case Uint16Type:
+		value = ReadUint16(b, offset)
+		offsetAfterRead = offset + 2
+	case Int8Type:
+		value = int8((*b)[offset])
+		offsetAfterRead = offset + 1
+	case Int16Type:
+		value = int16(ReadUint16(b, offset))
+		offsetAfterRead = offset + 2
 	case Uint32Type:
 		value = ReadUint32(b, offset)
 		offsetAfterRead = offset + 4
@@ -418,27 +974,105 @@ func ReadPrimitive(b *Bytes, offset uint16, expectedType Type) (any, uint16, err
 		value = ReadBool(b, offset)
 		offsetAfterRead = offset + 1
 	case StringType:
-		strValue, strLen := ReadString(b, offset)
+		var strValue string
+		var strLen uint32
+		strValue, strLen, err = ReadString(b, offset, encoding)
 		value = strValue
-		offsetAfterRead = offset + strLen + 2
+		offsetAfterRead = offset + LengthFieldSize(encoding) + strLen
+	case BytesType:
+		var bytesValue []byte
+		var numBytes uint32
+		bytesValue, numBytes, err = ReadBytes(b, offset, encoding)
+		value = bytesValue
+		offsetAfterRead = offset + LengthFieldSize(encoding) + numBytes
+	case UUIDType:
+		var uuid UUID
+		copy(uuid[:], (*b)[offset:offset+16])
+		value = uuid
+		offsetAfterRead = offset + 16
+	case DecimalType:
+		value = ReadDecimal(b, offset)
+		offsetAfterRead = offset + decimalSize
 	case TimeType:
 		value = time.Unix(0, int64(ReadUint64(b, offset)))
 		offsetAfterRead = offset + 8
+	case DateType:
+		value = Date(ReadUint32(b, offset))
+		offsetAfterRead = offset + 4
+	case DurationType:
+		value = time.Duration(ReadUint64(b, offset))
+		offsetAfterRead = offset + 8
+	case ZonedTimeType:
+		value = ReadZonedTime(b, offset)
+		offsetAfterRead = offset + zonedTimeSize
+	default:
+		err = &CorruptRecordError{offset, fmt.Sprintf("unknown primitive type %q", expectedType)}
+	}
+	if err != nil {
+		return nil, offset, err
+	}
+	return value, offsetAfterRead, nil
+}
+
+// readValue reads a value stored as an element of the given type and returns it along with the
+// offset right after it.
+func readValue(
+	b *Bytes, offset uint32, elemType Type, encoding Encoding, depth int,
+) (any, uint32, error) {
+	switch elemType {
+	case AnyType:
+		actualType, err := readType(b, offset)
+		if err != nil {
+			return nil, offset, err
+		}
+		switch actualType {
+		case NullType:
+			return nil, offset + 1, nil
+		case AnyType:
+			return nil, offset, &CorruptRecordError{offset, "value of any type tagged as any type"}
+		}
+		return readValue(b, offset+1, actualType, encoding, depth)
+	case ArrayType:
+		return readArray(b, offset, encoding, depth+1)
+	case MapType:
+		return readMap(b, offset, encoding, depth+1)
 	default:
-		err = fmt.Errorf("unsupported primitive type %v", expectedType)
+		return ReadPrimitive(b, offset, elemType, encoding)
 	}
-	return value, offsetAfterRead, err
 }
 
-func ReadArray(b *Bytes, offset uint16) (Array, uint16, error) {
-	arrayLen := binary.LittleEndian.Uint16((*b)[offset : offset+2])
-	offset += 2
-	elementType := (*b)[offset]
+func readArray(b *Bytes, offset uint32, encoding Encoding, depth int) (Array, uint32, error) {
+	if depth > MaxNestingDepth {
+		return Array{}, offset, &NestingDepthError{depth}
+	}
+	arrayLen, offset, err := ReadLength(b, offset, encoding)
+	if err != nil {
+		return Array{}, offset, err
+	}
+	elementType, err := readType(b, offset)
+	if err != nil {
+		return Array{}, offset, err
+	}
 	offset++
+	if err = checkCount(b, offset, arrayLen, 1); err != nil {
+		return Array{}, offset, err
+	}
 	a := Array{Values: make([]any, arrayLen), ElementType: elementType}
-	for i := uint16(0); i < arrayLen; i++ {
-		var err error
-		a.Values[i], offset, err = ReadPrimitive(b, offset, elementType)
+	if isTimeDeltaArray(elementType, encoding) {
+		var previous int64
+		for i := uint32(0); i < arrayLen; i++ {
+			var varint uint64
+			varint, offset, err = ReadUvarint(b, offset)
+			if err != nil {
+				return a, offset, err
+			}
+			previous += unzigzag(varint)
+			a.Values[i] = time.Unix(0, previous)
+		}
+		return a, offset, nil
+	}
+	for i := uint32(0); i < arrayLen; i++ {
+		a.Values[i], offset, err = readValue(b, offset, elementType, encoding, depth)
 		if err != nil {
 			return a, offset, err
 		}
@@ -446,29 +1080,160 @@ func ReadArray(b *Bytes, offset uint16) (Array, uint16, error) {
 	return a, offset, nil
 }
 
-func ReadMap(b *Bytes, offset uint16) (Map, uint16, error) {
-	mapLen := binary.LittleEndian.Uint16((*b)[offset : offset+2])
-	offset += 2
-	keyType := (*b)[offset]
-	offset++
-	valueType := (*b)[offset]
-	offset++
+func ReadArray(b *Bytes, offset uint32, encoding Encoding) (Array, uint32, error) {
+	return readArray(b, offset, encoding, 1)
+}
+
+// isKeyType returns true if values of the given type can be used as map keys.
+func isKeyType(elemType Type) bool {
+	return elemType != ArrayType && elemType != MapType && elemType != BytesType
+}
+
+func readMap(b *Bytes, offset uint32, encoding Encoding, depth int) (Map, uint32, error) {
+	if depth > MaxNestingDepth {
+		return Map{}, offset, &NestingDepthError{depth}
+	}
+	h, err := readMapHeader(b, offset, encoding)
+	if err != nil {
+		return Map{}, offset, err
+	}
+	keyType, valueType, mapLen := h.keyType, h.valueType, h.length
+	if !isKeyType(keyType) {
+		return Map{}, offset, &CorruptRecordError{
+			offset, fmt.Sprintf("invalid map key type %q", keyType),
+		}
+	}
+	offset = h.entries
+	if err = checkCount(b, offset, mapLen, 2); err != nil {
+		return Map{}, offset, err
+	}
 	m := Map{Data: make(map[any]any), KeyType: keyType, ValueType: valueType}
-	for i := uint16(0); i < mapLen; i++ {
+	var previous any
+	for i := uint32(0); i < mapLen; i++ {
+		if h.sorted {
+			entryOffset, err := h.entryOffset(b, i, encoding)
+			if err != nil {
+				return m, offset, err
+			}
+			if entryOffset != offset {
+				return m, offset, &CorruptRecordError{
+					offset, fmt.Sprintf("map entry %d indexed at offset %d", i, entryOffset),
+				}
+			}
+		}
+		if keyType == AnyType {
+			actualType, err := readType(b, offset)
+			if err != nil {
+				return m, offset, err
+			}
+			if !isKeyType(actualType) {
+				return m, offset, &CorruptRecordError{
+					offset, fmt.Sprintf("invalid map key type %q", actualType),
+				}
+			}
+		}
 		var key any
-		var err error
-		key, offset, err = ReadPrimitive(b, offset, keyType)
+		keyOffset := offset
+		key, offset, err = readValue(b, offset, keyType, encoding, depth)
 		if err != nil {
 			return m, offset, err
 		}
-		if valueType == ArrayType {
-			m.Data[key], offset, err = ReadArray(b, offset)
-		} else {
-			m.Data[key], offset, err = ReadPrimitive(b, offset, valueType)
+		if h.sorted && i > 0 && compareKeys(previous, key) >= 0 {
+			return m, offset, &CorruptRecordError{keyOffset, "map keys are not in ascending order"}
 		}
+		previous = key
+		m.Data[key], offset, err = readValue(b, offset, valueType, encoding, depth)
 		if err != nil {
 			return m, offset, err
 		}
 	}
 	return m, offset, nil
 }
+
+func ReadMap(b *Bytes, offset uint32, encoding Encoding) (Map, uint32, error) {
+	return readMap(b, offset, encoding, 1)
+}
+
+// SkipValue returns the offset right after a value stored at the given offset as an element of the
+// given type, without decoding the value.
+func SkipValue(b *Bytes, offset uint32, elemType Type, encoding Encoding) (uint32, error) {
+	return skipValue(b, offset, elemType, encoding, 0)
+}
+
+// skipValue returns the offset right after a value stored as an element of the given type,
+// without decoding the value. depth is the number of arrays and maps that the value is nested in.
+func skipValue(
+	b *Bytes, offset uint32, elemType Type, encoding Encoding, depth int,
+) (uint32, error) {
+	if size, ok := fixedSizeForType(elemType, encoding); ok {
+		return offset + size, checkBounds(b, offset, uint64(size))
+	}
+	if encoding&CompactEncoding != 0 && isVarintType(elemType) {
+		_, offsetAfterRead, err := ReadUvarint(b, offset)
+		return offsetAfterRead, err
+	}
+	switch elemType {
+	case AnyType:
+		actualType, err := readType(b, offset)
+		if err != nil {
+			return offset, err
+		}
+		switch actualType {
+		case NullType:
+			return offset + 1, nil
+		case AnyType:
+			return offset, &CorruptRecordError{offset, "value of any type tagged as any type"}
+		}
+		return skipValue(b, offset+1, actualType, encoding, depth)
+	case StringType, BytesType:
+		length, offsetAfterLength, err := ReadLength(b, offset, encoding)
+		if err != nil {
+			return offset, err
+		}
+		return offsetAfterLength + length, checkBounds(b, offsetAfterLength, uint64(length))
+	case ArrayType:
+		if depth+1 > MaxNestingDepth {
+			return offset, &NestingDepthError{depth + 1}
+		}
+		arrayLen, offset, err := ReadLength(b, offset, encoding)
+		if err != nil {
+			return offset, err
+		}
+		elementType, err := readType(b, offset)
+		if err != nil {
+			return offset, err
+		}
+		offset++
+		if isTimeDeltaArray(elementType, encoding) {
+			elementType = Uint64Type
+		}
+		for i := uint32(0); i < arrayLen; i++ {
+			offset, err = skipValue(b, offset, elementType, encoding, depth+1)
+			if err != nil {
+				return offset, err
+			}
+		}
+		return offset, nil
+	case MapType:
+		if depth+1 > MaxNestingDepth {
+			return offset, &NestingDepthError{depth + 1}
+		}
+		h, err := readMapHeader(b, offset, encoding)
+		if err != nil {
+			return offset, err
+		}
+		offset = h.entries
+		for i := uint32(0); i < h.length; i++ {
+			offset, err = skipValue(b, offset, h.keyType, encoding, depth+1)
+			if err != nil {
+				return offset, err
+			}
+			offset, err = skipValue(b, offset, h.valueType, encoding, depth+1)
+			if err != nil {
+				return offset, err
+			}
+		}
+		return offset, nil
+	}
+	return offset, &CorruptRecordError{offset, fmt.Sprintf("unknown element type %q", elemType)}
+}
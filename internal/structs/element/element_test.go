@@ -0,0 +1,345 @@
+package element
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func encode(t testing.TB, value any, elemType Type, encoding Encoding) Bytes {
+	numBytes, err := bytesNeededForValue(value, elemType, encoding, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make(Bytes, numBytes)
+	if _, err = writeValue(&b, 0, value, elemType, encoding, 1); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func checkCorrupt(t *testing.T, err error) {
+	if _, ok := err.(*CorruptRecordError); !ok {
+		t.Errorf("expected CorruptRecordError, got %v", err)
+	}
+}
+
+func TestReadPrimitive(t *testing.T) {
+	t.Run(
+		"check truncated fixed width value", func(t *testing.T) {
+			b := encode(t, int64(1), Int64Type, LegacyEncoding)[:7]
+			_, _, err := ReadPrimitive(&b, 0, Int64Type, LegacyEncoding)
+			checkCorrupt(t, err)
+		},
+	)
+
+	t.Run(
+		"check truncated varint", func(t *testing.T) {
+			b := encode(t, int64(1<<40), Int64Type, CompactEncoding)
+			b = b[:len(b)-1]
+			_, _, err := ReadPrimitive(&b, 0, Int64Type, CompactEncoding)
+			checkCorrupt(t, err)
+		},
+	)
+
+	t.Run(
+		"check varint out of range", func(t *testing.T) {
+			b := encode(t, uint64(1<<32), Uint64Type, CompactEncoding)
+			for _, elemType := range []Type{Uint32Type, Int32Type, DateType} {
+				_, _, err := ReadPrimitive(&b, 0, elemType, CompactEncoding)
+				checkCorrupt(t, err)
+			}
+			if _, _, err := ReadPrimitive(&b, 0, Int64Type, CompactEncoding); err != nil {
+				t.Error(err)
+			}
+		},
+	)
+
+	t.Run(
+		"check string length past end", func(t *testing.T) {
+			b := encode(t, "hello", StringType, LegacyEncoding)[:5]
+			_, _, err := ReadPrimitive(&b, 0, StringType, LegacyEncoding)
+			checkCorrupt(t, err)
+		},
+	)
+
+	t.Run(
+		"check offset past end", func(t *testing.T) {
+			b := encode(t, true, BoolType, LegacyEncoding)
+			_, _, err := ReadPrimitive(&b, 5, BoolType, LegacyEncoding)
+			checkCorrupt(t, err)
+		},
+	)
+
+	t.Run(
+		"check unknown type", func(t *testing.T) {
+			b := Bytes{1, 2, 3}
+			_, _, err := ReadPrimitive(&b, 0, '?', LegacyEncoding)
+			checkCorrupt(t, err)
+		},
+	)
+}
+
+func TestReadArray(t *testing.T) {
+	t.Run(
+		"check huge count", func(t *testing.T) {
+			b := Bytes{255, 255, 255, 255, BoolType, 1}
+			_, _, err := ReadArray(&b, 0, WideEncoding)
+			checkCorrupt(t, err)
+		},
+	)
+
+	t.Run(
+		"check truncated nested array", func(t *testing.T) {
+			a := Array{ArrayType, []any{Array{StringType, []any{"a", "b"}}}}
+			b := encode(t, a, ArrayType, LegacyEncoding)
+			for i := 0; i < len(b); i++ {
+				truncated := b[:i]
+				_, _, err := ReadArray(&truncated, 0, LegacyEncoding)
+				checkCorrupt(t, err)
+			}
+		},
+	)
+}
+
+func TestReadMap(t *testing.T) {
+	t.Run(
+		"check unhashable key type", func(t *testing.T) {
+			b := Bytes{1, 0, ArrayType, BoolType, 0, 0, Int32Type, 1}
+			_, _, err := ReadMap(&b, 0, LegacyEncoding)
+			checkCorrupt(t, err)
+		},
+	)
+
+	t.Run(
+		"check unhashable any key", func(t *testing.T) {
+			b := Bytes{1, 0, AnyType, BoolType, BytesType, 1, 0, 'a', 1}
+			_, _, err := ReadMap(&b, 0, LegacyEncoding)
+			checkCorrupt(t, err)
+		},
+	)
+
+	t.Run(
+		"check sorted map with keys out of order", func(t *testing.T) {
+			b := Bytes{2, 0, Int32Type | sortedMapFlag, BoolType, 2, 0, 0, 0, 1, 1, 0, 0, 0, 0}
+			_, _, err := ReadMap(&b, 0, LegacyEncoding)
+			checkCorrupt(t, err)
+		},
+	)
+
+	t.Run(
+		"check sorted map with invalid index", func(t *testing.T) {
+			b := Bytes{
+				2, 0, StringType | sortedMapFlag, BoolType, 0, 0, 3, 0, 1, 0, 'a', 1, 1, 0, 'b', 0,
+			}
+			_, _, err := ReadMap(&b, 0, LegacyEncoding)
+			checkCorrupt(t, err)
+			_, _, err = LookupMapKey(&b, 0, "b", LegacyEncoding)
+			checkCorrupt(t, err)
+		},
+	)
+}
+
+func TestLookupMapKey(t *testing.T) {
+	// Maps written before entries were sorted can store their keys in any order.
+	tests := []struct {
+		name    string
+		b       Bytes
+		values  map[any]any
+		missing any
+	}{
+		{
+			"variable size entries",
+			Bytes{
+				3, 0, StringType, Int32Type,
+				1, 0, 'c', 3, 0, 0, 0, 1, 0, 'a', 1, 0, 0, 0, 1, 0, 'b', 2, 0, 0, 0,
+			},
+			map[any]any{"a": int32(1), "b": int32(2), "c": int32(3)}, "d",
+		},
+		{
+			"fixed size entries",
+			Bytes{
+				3, 0, Int32Type, Int32Type,
+				30, 0, 0, 0, 3, 0, 0, 0, 10, 0, 0, 0, 1, 0, 0, 0, 20, 0, 0, 0, 2, 0, 0, 0,
+			},
+			map[any]any{int32(10): int32(1), int32(20): int32(2), int32(30): int32(3)}, int32(40),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			"check unsorted "+tt.name, func(t *testing.T) {
+				for key, want := range tt.values {
+					value, found, err := LookupMapKey(&tt.b, 0, key, LegacyEncoding)
+					if err != nil || !found || value != want {
+						t.Errorf("%v: expected %v, got %v, %v, %v", key, want, value, found, err)
+					}
+				}
+				_, found, err := LookupMapKey(&tt.b, 0, tt.missing, LegacyEncoding)
+				if err != nil || found {
+					t.Errorf("%v: expected missing key, got %v, %v", tt.missing, found, err)
+				}
+			},
+		)
+	}
+
+	t.Run(
+		"check sorted maps", func(t *testing.T) {
+			maps := []Map{
+				{KeyType: StringType, ValueType: Int32Type, Data: map[any]any{}},
+				{KeyType: Int32Type, ValueType: Int64Type, Data: map[any]any{}},
+				{KeyType: AnyType, ValueType: StringType, Data: map[any]any{}},
+			}
+			for i := 0; i < 100; i += 2 {
+				maps[0].Data[fmt.Sprint(i)] = int32(i)
+				maps[1].Data[int32(i)] = int64(i)
+				maps[2].Data[int64(i)] = fmt.Sprint(i)
+				maps[2].Data[fmt.Sprint(i)] = strings.Repeat("x", i)
+			}
+			for _, encoding := range []Encoding{LegacyEncoding, WideEncoding, CompactEncoding} {
+				for _, m := range maps {
+					b := encode(t, m, MapType, encoding)
+					for key, want := range m.Data {
+						value, found, err := LookupMapKey(&b, 0, key, encoding)
+						if err != nil || !found || value != want {
+							t.Errorf(
+								"%v: expected %v, got %v, %v, %v", key, want, value, found, err,
+							)
+						}
+					}
+					for _, missing := range []any{"1", "99", int32(1), int32(99), int64(-1)} {
+						if m.KeyType != AnyType {
+							if keyType, _ := TypeForValue(missing); keyType != m.KeyType {
+								continue
+							}
+						}
+						_, found, err := LookupMapKey(&b, 0, missing, encoding)
+						if err != nil || found {
+							t.Errorf("%v: expected missing key, got %v, %v", missing, found, err)
+						}
+					}
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check sorted map misses are not scanned", func(t *testing.T) {
+			// A sorted map is trusted to be sorted, so a key stored out of order is not found.
+			b := Bytes{
+				2, 0, Int32Type | sortedMapFlag, Int32Type,
+				20, 0, 0, 0, 2, 0, 0, 0, 10, 0, 0, 0, 1, 0, 0, 0,
+			}
+			_, found, err := LookupMapKey(&b, 0, int32(10), LegacyEncoding)
+			if err != nil || found {
+				t.Errorf("expected missing key, got %v, %v", found, err)
+			}
+		},
+	)
+}
+
+func addSeeds(f *testing.F, values []any, elemType Type) {
+	for _, encoding := range []Encoding{LegacyEncoding, WideEncoding, CompactEncoding} {
+		for _, value := range values {
+			f.Add([]byte(encode(f, value, elemType, encoding)), encoding)
+		}
+	}
+}
+
+func FuzzReadPrimitive(f *testing.F) {
+	for _, value := range []any{
+		uint8(1), int16(-2), uint32(3), int64(-4), float32(5.5), 6.5, true, "seven",
+		[]byte{8}, UUID{9}, Decimal{10, 4, 2}, time.Unix(11, 0), Date(12), time.Duration(13),
+		ZonedTime{time.Unix(14, 0).In(time.FixedZone("", 3600))},
+	} {
+		elemType, _ := TypeForValue(value)
+		for _, encoding := range []Encoding{LegacyEncoding, WideEncoding, CompactEncoding} {
+			f.Add([]byte(encode(f, value, elemType, encoding)), elemType, encoding)
+		}
+	}
+
+	f.Fuzz(
+		func(t *testing.T, data []byte, elemType Type, encoding Encoding) {
+			b := Bytes(data)
+			_, offset, err := ReadPrimitive(&b, 0, elemType, encoding)
+			if err == nil && offset > uint32(len(b)) {
+				t.Errorf("offset %d past end of %d bytes", offset, len(b))
+			}
+		},
+	)
+}
+
+func FuzzReadArray(f *testing.F) {
+	addSeeds(
+		f, []any{
+			Array{Int32Type, []any{int32(1), int32(-1)}},
+			Array{StringType, []any{"a", "bc"}},
+			Array{TimeType, []any{time.Unix(1, 0), time.Unix(2, 0)}},
+			Array{AnyType, []any{nil, "a", Array{BoolType, []any{true}}}},
+			Array{MapType, []any{Map{StringType, Int64Type, map[any]any{"a": int64(1)}}}},
+		}, ArrayType,
+	)
+
+	f.Fuzz(
+		func(t *testing.T, data []byte, encoding Encoding) {
+			b := Bytes(data)
+			_, offset, err := ReadArray(&b, 0, encoding)
+			if err == nil && offset > uint32(len(b)) {
+				t.Errorf("offset %d past end of %d bytes", offset, len(b))
+			}
+			view, err := NewArrayView(&b, 0, encoding)
+			if err != nil {
+				return
+			}
+			for _, i := range []int{0, 1, view.Len() - 1} {
+				_, _ = view.At(i)
+				_, _ = view.StringAt(i)
+				_, _ = view.BytesAt(i)
+				_, _ = view.Int32At(i)
+				_, _ = view.Int64At(i)
+				_, _ = view.TimeAt(i)
+			}
+			_ = view.Range(
+				func(_ int, value ValueView) bool {
+					_, _ = value.Value()
+					_, _ = value.Uint32()
+					return true
+				},
+			)
+		},
+	)
+}
+
+func FuzzReadMap(f *testing.F) {
+	addSeeds(
+		f, []any{
+			Map{StringType, Int32Type, map[any]any{"a": int32(1), "b": int32(2)}},
+			Map{Int64Type, Float64Type, map[any]any{int64(1): 1.5}},
+			Map{AnyType, AnyType, map[any]any{"a": nil, int32(1): Array{BoolType, []any{true}}}},
+			Map{StringType, MapType, map[any]any{"a": Map{BoolType, StringType, map[any]any{}}}},
+		}, MapType,
+	)
+
+	f.Fuzz(
+		func(t *testing.T, data []byte, encoding Encoding) {
+			b := Bytes(data)
+			_, offset, err := ReadMap(&b, 0, encoding)
+			if err == nil && offset > uint32(len(b)) {
+				t.Errorf("offset %d past end of %d bytes", offset, len(b))
+			}
+			for _, key := range []any{"a", int32(1), int64(1)} {
+				_, _, _ = LookupMapKey(&b, 0, key, encoding)
+			}
+			view, err := NewMapView(&b, 0, encoding)
+			if err == nil {
+				_ = view.Range(
+					func(key, value ValueView) bool {
+						_, _ = key.Value()
+						_, _ = value.Value()
+						return true
+					},
+				)
+			}
+		},
+	)
+}
@@ -0,0 +1,435 @@
+package element
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+)
+
+/*
+ * Keys are byte strings whose order under bytes.Compare matches the order of the values they
+ * encode, so that they can be stored in ordered indexes. A key is a tuple of one or more values,
+ * each encoded as its element type byte followed by its value:
+ *   - nulls have no value; their type byte is 0 so they are ordered before all other values, or
+ *     0xff when encoded by a Comparator with NullsLast so they are ordered after them
+ *   - unsigned integers are stored big-endian in their full width
+ *   - signed integers, dates and durations are stored big-endian in their full width with the sign
+ *     bit flipped, so that negative values are ordered before positive ones
+ *   - floats are stored big-endian with the sign bit flipped for positive values and all bits
+ *     flipped for negative values. NaN is stored as all zeros, before every other float, and -0 is
+ *     stored as 0
+ *   - bools are stored as 0 or 1
+ *   - strings and byte slices are stored with every 0 byte escaped as 0 0xff, followed by 0 1, so
+ *     that a string is ordered before the strings it is a prefix of
+ *   - UUIDs are stored as their 16 bytes
+ *   - decimals are stored as their value scaled to 18 digits after the decimal point, as a 128-bit
+ *     signed integer, followed by their precision and scale
+ *   - times are stored as the signed seconds since the Unix epoch followed by the nanoseconds, and
+ *     zoned times additionally by the signed offset of their time zone
+ * Values of different types are ordered by their type byte. Values stored in descending order have
+ * all their bytes, including the type byte, inverted.
+ */
+
+// SortOrder is the order of the values of a key column.
+type SortOrder uint8
+
+const (
+	Ascending SortOrder = iota
+	Descending
+)
+
+const (
+	keyFormat = "key"
+
+	// nullsLastKeyType is the type byte of nulls ordered after all other values.
+	nullsLastKeyType = 0xff
+
+	// keyDecimalScale is the scale that decimals are scaled to in keys.
+	keyDecimalScale = MaxDecimalPrecision
+)
+
+// appendSigned appends the value in the given number of bytes with its sign bit flipped.
+func appendSigned(key []byte, value int64, size int) []byte {
+	return appendUint(key, uint64(value)^1<<(8*size-1), size)
+}
+
+// appendKeyFloat appends the key encoding of a float given by its bits and size.
+func appendKeyFloat(key []byte, floatBits uint64, size int, isNaN bool) []byte {
+	signBit := uint64(1) << (8*size - 1)
+	switch {
+	case isNaN:
+		floatBits = 0
+	case floatBits == signBit:
+		// -0 is stored as 0.
+		floatBits = signBit
+	case floatBits&signBit != 0:
+		floatBits = ^floatBits & (signBit<<1 - 1)
+	default:
+		floatBits |= signBit
+	}
+	return appendUint(key, floatBits, size)
+}
+
+// appendKeyBytes appends the key encoding of a string or byte slice.
+func appendKeyBytes(key []byte, b []byte) []byte {
+	for _, c := range b {
+		if c == 0 {
+			key = append(key, 0, 0xff)
+		} else {
+			key = append(key, c)
+		}
+	}
+	return append(key, 0, 1)
+}
+
+var powersOf10 = func() [keyDecimalScale + 1]uint64 {
+	var powers [keyDecimalScale + 1]uint64
+	powers[0] = 1
+	for i := 1; i < len(powers); i++ {
+		powers[i] = powers[i-1] * 10
+	}
+	return powers
+}()
+
+// appendKeyDecimal appends the key encoding of a decimal.
+func appendKeyDecimal(key []byte, d Decimal) ([]byte, error) {
+	if d.Scale > keyDecimalScale {
+		return key, &InvalidDecimalError{d.Value, d.Precision, d.Scale}
+	}
+	abs := uint64(d.Value)
+	if d.Value < 0 {
+		abs = uint64(-d.Value)
+	}
+	hi, lo := bits.Mul64(abs, powersOf10[keyDecimalScale-d.Scale])
+	if d.Value < 0 {
+		// Negate the 128-bit value in two's complement.
+		var borrow uint64
+		lo, borrow = bits.Sub64(0, lo, 0)
+		hi, _ = bits.Sub64(0, hi, borrow)
+	}
+	key = appendUint(key, hi^1<<63, 8)
+	key = appendUint(key, lo, 8)
+	return append(key, d.Precision, d.Scale), nil
+}
+
+// AppendKey appends the key encoding of the given value in the given order to key. Values can be of
+// any primitive element type, or nil.
+func AppendKey(key []byte, value any, order SortOrder) ([]byte, error) {
+	start := len(key)
+	elemType, err := TypeForValue(value)
+	if err != nil {
+		return key, err
+	}
+	key = append(key, elemType)
+
+	switch v := value.(type) {
+	case nil:
+	case bool:
+		if v {
+			key = append(key, 1)
+		} else {
+			key = append(key, 0)
+		}
+	case uint8:
+		key = append(key, v)
+	case uint16:
+		key = appendUint(key, uint64(v), 2)
+	case uint:
+		key = appendUint(key, uint64(uint32(v)), 4)
+	case uint32:
+		key = appendUint(key, uint64(v), 4)
+	case uint64:
+		key = appendUint(key, v, 8)
+	case int8:
+		key = appendSigned(key, int64(v), 1)
+	case int16:
+		key = appendSigned(key, int64(v), 2)
+	case int:
+		key = appendSigned(key, int64(int32(v)), 4)
+	case int32:
+		key = appendSigned(key, int64(v), 4)
+	case int64:
+		key = appendSigned(key, v, 8)
+	case float32:
+		key = appendKeyFloat(key, uint64(math.Float32bits(v)), 4, v != v)
+	case float64:
+		key = appendKeyFloat(key, math.Float64bits(v), 8, v != v)
+	case string:
+		key = appendKeyBytes(key, []byte(v))
+	case []byte:
+		key = appendKeyBytes(key, v)
+	case UUID:
+		key = append(key, v[:]...)
+	case Decimal:
+		if key, err = appendKeyDecimal(key, v); err != nil {
+			return key[:start], err
+		}
+	case time.Time:
+		key = appendSigned(key, v.Unix(), 8)
+		key = appendUint(key, uint64(v.Nanosecond()), 4)
+	case Date:
+		key = appendSigned(key, int64(v), 4)
+	case time.Duration:
+		key = appendSigned(key, int64(v), 8)
+	case ZonedTime:
+		_, zoneOffset := v.Zone()
+		key = appendSigned(key, v.Unix(), 8)
+		key = appendUint(key, uint64(v.Nanosecond()), 4)
+		key = appendSigned(key, int64(zoneOffset), 4)
+	default:
+		name, _ := NameForType(elemType)
+		return key[:start], fmt.Errorf("%s values cannot be stored in keys", name)
+	}
+
+	if order == Descending {
+		for i := start; i < len(key); i++ {
+			key[i] = ^key[i]
+		}
+	}
+	return key, nil
+}
+
+// EncodeKey returns the key encoding of the tuple of the given values, all in ascending order.
+func EncodeKey(values ...any) ([]byte, error) {
+	var key []byte
+	for _, value := range values {
+		var err error
+		if key, err = AppendKey(key, value, Ascending); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// EncodeKeyWithOrder returns the key encoding of the tuple of the given values, each in the sort
+// order at the same index in orders. Values without an order are in ascending order.
+func EncodeKeyWithOrder(values []any, orders []SortOrder) ([]byte, error) {
+	return Comparator{}.EncodeKeyWithOrder(values, orders)
+}
+
+// keyReader reads the bytes of a key, inverting them for values in descending order.
+type keyReader struct {
+	codecReader
+	mask byte
+}
+
+func (r *keyReader) readByte() (byte, error) {
+	c, err := r.codecReader.readByte()
+	return c ^ r.mask, err
+}
+
+func (r *keyReader) readUint(size int) (uint64, error) {
+	u, err := r.codecReader.readUint(size)
+	if r.mask != 0 {
+		u = ^u & (1<<(8*size-1)<<1 - 1)
+	}
+	return u, err
+}
+
+func (r *keyReader) readSigned(size int) (int64, error) {
+	u, err := r.readUint(size)
+	u ^= 1 << (8*size - 1)
+	// Sign extend values smaller than 8 bytes.
+	shift := 64 - 8*size
+	return int64(u<<shift) >> shift, err
+}
+
+func (r *keyReader) readFloat(size int) (uint64, error) {
+	u, err := r.readUint(size)
+	signBit := uint64(1) << (8*size - 1)
+	if u&signBit != 0 {
+		return u &^ signBit, err
+	}
+	return ^u & (signBit<<1 - 1), err
+}
+
+func (r *keyReader) readBytes() ([]byte, error) {
+	b := []byte{}
+	for {
+		c, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if c != 0 {
+			b = append(b, c)
+			continue
+		}
+		if c, err = r.readByte(); err != nil {
+			return nil, err
+		}
+		switch c {
+		case 0xff:
+			b = append(b, 0)
+		case 1:
+			return b, nil
+		default:
+			r.offset--
+			return nil, r.errorf("invalid escape 0x%02x", c)
+		}
+	}
+}
+
+func (r *keyReader) readDecimal() (Decimal, error) {
+	hi, err := r.readUint(8)
+	if err != nil {
+		return Decimal{}, err
+	}
+	lo, err := r.readUint(8)
+	if err != nil {
+		return Decimal{}, err
+	}
+	precision, err := r.readByte()
+	if err != nil {
+		return Decimal{}, err
+	}
+	scale, err := r.readByte()
+	if err != nil {
+		return Decimal{}, err
+	}
+	hi ^= 1 << 63
+	negative := hi>>63 != 0
+	if negative {
+		var borrow uint64
+		lo, borrow = bits.Sub64(0, lo, 0)
+		hi, _ = bits.Sub64(0, hi, borrow)
+	}
+	if scale > keyDecimalScale || hi >= powersOf10[keyDecimalScale-scale] {
+		return Decimal{}, r.errorf("invalid decimal")
+	}
+	abs, remainder := bits.Div64(hi, lo, powersOf10[keyDecimalScale-scale])
+	if remainder != 0 || abs > math.MaxInt64 {
+		return Decimal{}, r.errorf("invalid decimal")
+	}
+	value := int64(abs)
+	if negative {
+		value = -value
+	}
+	d, err := NewDecimal(value, precision, scale)
+	if err != nil {
+		return Decimal{}, r.errorf("%v", err)
+	}
+	return d, nil
+}
+
+// readKeyValue reads the next value of the key.
+func (r *keyReader) readKeyValue() (any, error) {
+	start := r.offset
+	elemType, err := r.readByte()
+	if err != nil {
+		return nil, err
+	}
+	var u uint64
+	var i int64
+	switch elemType {
+	case NullType, nullsLastKeyType:
+		return nil, nil
+	case BoolType:
+		c, err := r.readByte()
+		if err == nil && c > 1 {
+			err = r.errorf("invalid bool %d", c)
+		}
+		return c == 1, err
+	case Uint8Type:
+		u, err = r.readUint(1)
+		return uint8(u), err
+	case Uint16Type:
+		u, err = r.readUint(2)
+		return uint16(u), err
+	case Uint32Type:
+		u, err = r.readUint(4)
+		return uint32(u), err
+	case Uint64Type:
+		return r.readUint(8)
+	case Int8Type:
+		i, err = r.readSigned(1)
+		return int8(i), err
+	case Int16Type:
+		i, err = r.readSigned(2)
+		return int16(i), err
+	case Int32Type:
+		i, err = r.readSigned(4)
+		return int32(i), err
+	case Int64Type:
+		return r.readSigned(8)
+	case Float32Type:
+		u, err = r.readFloat(4)
+		return math.Float32frombits(uint32(u)), err
+	case Float64Type:
+		u, err = r.readFloat(8)
+		return math.Float64frombits(u), err
+	case StringType:
+		b, err := r.readBytes()
+		return string(b), err
+	case BytesType:
+		return r.readBytes()
+	case UUIDType:
+		var uuid UUID
+		for j := range uuid {
+			if uuid[j], err = r.readByte(); err != nil {
+				return nil, err
+			}
+		}
+		return uuid, nil
+	case DecimalType:
+		return r.readDecimal()
+	case TimeType, ZonedTimeType:
+		seconds, err := r.readSigned(8)
+		if err != nil {
+			return nil, err
+		}
+		if u, err = r.readUint(4); err != nil {
+			return nil, err
+		}
+		if u >= 1e9 {
+			return nil, r.errorf("invalid nanoseconds %d", u)
+		}
+		if elemType == TimeType {
+			return time.Unix(seconds, int64(u)), nil
+		}
+		i, err = r.readSigned(4)
+		return newZonedTime(seconds, int64(u), int(i)), err
+	case DateType:
+		i, err = r.readSigned(4)
+		return Date(i), err
+	case DurationType:
+		i, err = r.readSigned(8)
+		return time.Duration(i), err
+	}
+	r.offset = start
+	return nil, r.errorf("invalid type byte 0x%02x", elemType)
+}
+
+// ReadKey reads the value stored in the given order at the given offset of a key. It returns the
+// value and the offset of the next value of the key. DecodeError is returned if the key is
+// malformed.
+func ReadKey(key []byte, offset int, order SortOrder) (any, int, error) {
+	r := &keyReader{codecReader: codecReader{format: keyFormat, data: key, offset: offset}}
+	if order == Descending {
+		r.mask = 0xff
+	}
+	value, err := r.readKeyValue()
+	if err != nil {
+		return nil, offset, err
+	}
+	return value, r.offset, nil
+}
+
+// DecodeKey returns the values of the tuple encoded in the given key, each stored in the sort order
+// at the same index in orders. Values without an order are in ascending order.
+func DecodeKey(key []byte, orders ...SortOrder) ([]any, error) {
+	var values []any
+	for offset := 0; offset < len(key); {
+		order := Ascending
+		if len(values) < len(orders) {
+			order = orders[len(values)]
+		}
+		value, next, err := ReadKey(key, offset, order)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		offset = next
+	}
+	return values, nil
+}
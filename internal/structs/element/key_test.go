@@ -0,0 +1,283 @@
+package element
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// keyTestValues returns values of every type that can be stored in keys, in ascending order within
+// each type.
+func keyTestValues() [][]any {
+	return [][]any{
+		{nil},
+		{false, true},
+		{uint8(0), uint8(1), uint8(255)},
+		{uint16(0), uint16(256), uint16(65535)},
+		{uint32(0), uint32(1), uint32(1 << 31), uint32(1<<32 - 1)},
+		{uint64(0), uint64(1 << 32), uint64(1<<64 - 1)},
+		{int8(-128), int8(-1), int8(0), int8(127)},
+		{int16(-32768), int16(-256), int16(0), int16(1)},
+		{int32(-1 << 31), int32(-1), int32(0), int32(1), int32(1<<31 - 1)},
+		{int64(-1 << 63), int64(-1 << 32), int64(-1), int64(0), int64(1 << 40), int64(1<<63 - 1)},
+		{
+			float32(math.NaN()), float32(math.Inf(-1)), float32(-1.5), float32(-1e-40), float32(0),
+			float32(1e-40), float32(2), float32(math.Inf(1)),
+		},
+		{
+			math.NaN(), math.Inf(-1), -math.MaxFloat64, -1.0, -math.SmallestNonzeroFloat64, 0.0,
+			math.SmallestNonzeroFloat64, 0.5, math.MaxFloat64, math.Inf(1),
+		},
+		{"", "\x00", "\x00\x00", "\x00\x01", "a", "a\x00", "a\x00b", "a\x01", "ab", "b", "\xff"},
+		{[]byte{}, []byte{0}, []byte{0, 0xff}, []byte{1}, []byte{0xff, 0xff}},
+		{UUID{}, UUID{0, 1}, UUID{1}, UUID{0xff}},
+		{
+			Decimal{-999999999999999999, 18, 0}, Decimal{-150, 3, 1}, Decimal{-1, 18, 18},
+			Decimal{0, 1, 0}, Decimal{1, 18, 18}, Decimal{125, 5, 2}, Decimal{13, 2, 1},
+			Decimal{999999999999999999, 18, 0},
+		},
+		{time.Unix(-1<<32, 0), time.Unix(-1, 999999999), time.Unix(0, 0), time.Unix(1<<32, 1)},
+		{Date(math.MinInt32), Date(-1), Date(0), Date(19000)},
+		{time.Duration(math.MinInt64), -time.Second, time.Duration(0), time.Nanosecond, time.Hour},
+		{
+			newZonedTime(-1, 0, 3600), newZonedTime(0, 0, -3600), newZonedTime(0, 0, 0),
+			newZonedTime(0, 0, 3600), newZonedTime(0, 1, -7200),
+		},
+	}
+}
+
+func checkKeyValue(t *testing.T, expected any, value any) {
+	switch e := expected.(type) {
+	case float32:
+		if e != e {
+			if v, ok := value.(float32); !ok || v == v {
+				t.Errorf("expected NaN, got %v", value)
+			}
+			return
+		}
+	case float64:
+		if e != e {
+			if v, ok := value.(float64); !ok || v == v {
+				t.Errorf("expected NaN, got %v", value)
+			}
+			return
+		}
+	case ZonedTime:
+		z, ok := value.(ZonedTime)
+		_, expectedOffset := e.Zone()
+		_, offset := z.Zone()
+		if !ok || !z.Equal(e.Time) || offset != expectedOffset {
+			t.Errorf("expected %v, got %v", expected, value)
+		}
+		return
+	}
+	if !reflect.DeepEqual(value, expected) {
+		t.Errorf("expected %#v, got %#v", expected, value)
+	}
+}
+
+func TestEncodeKey(t *testing.T) {
+	t.Run(
+		"check order of values", func(t *testing.T) {
+			for _, order := range []SortOrder{Ascending, Descending} {
+				var previous []byte
+				for _, values := range keyTestValues() {
+					for i, value := range values {
+						key, err := AppendKey(nil, value, order)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if i > 0 {
+							c := bytes.Compare(previous, key)
+							if (order == Ascending && c >= 0) || (order == Descending && c <= 0) {
+								t.Errorf("%v: key %x is not ordered after %x", value, key, previous)
+							}
+						}
+						previous = key
+
+						decoded, next, err := ReadKey(key, 0, order)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if next != len(key) {
+							t.Errorf("expected next offset %d, got %d", len(key), next)
+						}
+						checkKeyValue(t, value, decoded)
+					}
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check order matches map key order", func(t *testing.T) {
+			var all []any
+			for _, values := range keyTestValues() {
+				for _, value := range values {
+					if _, ok := value.([]byte); !ok {
+						all = append(all, value)
+					}
+				}
+			}
+			for _, a := range all {
+				for _, b := range all {
+					keyA, _ := EncodeKey(a)
+					keyB, _ := EncodeKey(b)
+					c := bytes.Compare(keyA, keyB)
+					if expected := compareKeys(a, b); c != expected && expected != 0 {
+						t.Errorf("%v, %v: expected %d, got %d", a, b, expected, c)
+					}
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check negative zero", func(t *testing.T) {
+			negative, _ := EncodeKey(math.Copysign(0, -1))
+			positive, _ := EncodeKey(0.0)
+			if !bytes.Equal(negative, positive) {
+				t.Errorf("expected %x, got %x", positive, negative)
+			}
+		},
+	)
+
+	t.Run(
+		"check tuples", func(t *testing.T) {
+			tuples := [][]any{
+				{nil, int64(5)},
+				{"a", int64(2)},
+				{"a", int64(10)},
+				{"a\x00", int64(1)},
+				{"ab", int64(-1)},
+				{"ab", int64(0)},
+			}
+			var previous []byte
+			for i, tuple := range tuples {
+				key, err := EncodeKey(tuple...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if i > 0 && bytes.Compare(previous, key) >= 0 {
+					t.Errorf("%v: key %x is not ordered after %x", tuple, key, previous)
+				}
+				previous = key
+				values, err := DecodeKey(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(values, tuple) {
+					t.Errorf("expected %v, got %v", tuple, values)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"check mixed sort orders", func(t *testing.T) {
+			orders := []SortOrder{Ascending, Descending}
+			tuples := [][]any{
+				{"a", int32(3)}, {"b", int32(2)}, {"a", int32(1)}, {"b", nil}, {"a", int32(2)},
+			}
+			keys := make([][]byte, len(tuples))
+			for i, tuple := range tuples {
+				var err error
+				if keys[i], err = EncodeKeyWithOrder(tuple, orders); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+			var sorted [][]any
+			for _, key := range keys {
+				values, err := DecodeKey(key, orders...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sorted = append(sorted, values)
+			}
+			expected := [][]any{
+				{"a", int32(3)}, {"a", int32(2)}, {"a", int32(1)}, {"b", int32(2)}, {"b", nil},
+			}
+			if !reflect.DeepEqual(sorted, expected) {
+				t.Errorf("expected %v, got %v", expected, sorted)
+			}
+		},
+	)
+
+	t.Run(
+		"check unsupported values", func(t *testing.T) {
+			key := []byte{1, 2}
+			key, err := AppendKey(key, Array{Int32Type, []any{}}, Ascending)
+			if err == nil {
+				t.Error("expected error for array")
+			}
+			if !bytes.Equal(key, []byte{1, 2}) {
+				t.Errorf("expected key to be unchanged, got %x", key)
+			}
+			if _, err = EncodeKey(Decimal{1, 20, 19}); err == nil {
+				t.Error("expected error for invalid decimal")
+			}
+		},
+	)
+
+	t.Run(
+		"check invalid keys", func(t *testing.T) {
+			for _, key := range [][]byte{
+				{Int64Type, 0},
+				{StringType, 'a'},
+				{StringType, 0, 2},
+				{BoolType, 2},
+				{'?'},
+				{TimeType, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff},
+			} {
+				_, err := DecodeKey(key)
+				if _, ok := err.(*DecodeError); !ok {
+					t.Errorf("%x: expected DecodeError, got %v", key, err)
+				}
+			}
+		},
+	)
+}
+
+func FuzzDecodeKey(f *testing.F) {
+	for _, values := range keyTestValues() {
+		key, err := EncodeKey(values...)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(key, false)
+		key, _ = EncodeKeyWithOrder(values, []SortOrder{Descending, Descending})
+		f.Add(key, true)
+	}
+
+	f.Fuzz(
+		func(t *testing.T, key []byte, descending bool) {
+			order := Ascending
+			if descending {
+				order = Descending
+			}
+			offset := 0
+			for offset < len(key) {
+				value, next, err := ReadKey(key, offset, order)
+				if err != nil {
+					return
+				}
+				reencoded, err := AppendKey(nil, value, order)
+				if err != nil {
+					t.Fatalf("cannot encode decoded value %#v: %v", value, err)
+				}
+				decoded, _, err := ReadKey(reencoded, 0, order)
+				if err != nil {
+					t.Fatalf("cannot decode re-encoded value %#v: %v", value, err)
+				}
+				if c := compareKeys(value, decoded); c != 0 && !isNaN(value) {
+					t.Errorf("expected %#v, got %#v", value, decoded)
+				}
+				offset = next
+			}
+		},
+	)
+}
@@ -0,0 +1,360 @@
+package element
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Map entries are written in ascending order of their keys so that equal maps are always encoded
+// as equal bytes, and so that a single key can be looked up without decoding the whole map. Such
+// maps are marked by sortedMapFlag in their key type byte. If their keys or their values vary in
+// size, the type bytes are followed by an index of the offset of each entry, relative to the first
+// entry and stored like a length, so that the entries can be binary searched. Maps written before
+// entries were sorted store them in the order they were iterated in, without the flag or an index,
+// so a key is only ruled out of them after scanning all entries.
+//
+// Keys of the same type are ordered as follows. Integers, floats, dates, durations and decimals
+// are ordered numerically, with NaN before all other floats. Booleans are ordered false before
+// true. Strings and UUIDs are ordered bytewise. Times are ordered by the instant they represent
+// and zoned times with the same instant are ordered by their zone offset. Keys of AnyType maps are
+// first ordered by their type byte and then by their values.
+
+// sortedMapFlag is set in the key type byte of a Map whose entries are sorted by key. Type bytes
+// are ASCII characters, so the flag never clashes with a type.
+const sortedMapFlag Type = 0x80
+
+// mapHeader describes the encoded header of a Map.
+type mapHeader struct {
+	length    uint32
+	keyType   Type
+	valueType Type
+	sorted    bool
+
+	// index is the offset of the index of entry offsets, or 0 if the map has none.
+	index uint32
+
+	// entries is the offset of the first entry.
+	entries uint32
+
+	// entrySize is the size of every entry of a sorted map whose keys and values have a fixed
+	// size, or 0.
+	entrySize uint32
+}
+
+type ordered interface {
+	~int64 | ~uint64 | ~float64 | ~string | ~int
+}
+
+func compareOrdered[T ordered](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// signedValue returns the given signed integer, date or duration value as an int64.
+func signedValue(value any) int64 {
+	switch v := value.(type) {
+	case int:
+		return int64(int32(v))
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case Date:
+		return int64(v)
+	case time.Duration:
+		return int64(v)
+	}
+	return 0
+}
+
+// unsignedValue returns the given unsigned integer value as an uint64.
+func unsignedValue(value any) uint64 {
+	switch v := value.(type) {
+	case uint:
+		return uint64(uint32(v))
+	case uint8:
+		return uint64(v)
+	case uint16:
+		return uint64(v)
+	case uint32:
+		return uint64(v)
+	case uint64:
+		return v
+	}
+	return 0
+}
+
+func compareFloats(a, b float64) int {
+	aIsNaN, bIsNaN := math.IsNaN(a), math.IsNaN(b)
+	switch {
+	case aIsNaN && bIsNaN:
+		return 0
+	case aIsNaN:
+		return -1
+	case bIsNaN:
+		return 1
+	}
+	return compareOrdered(a, b)
+}
+
+func compareDecimals(a, b Decimal) int {
+	if a.Scale == b.Scale {
+		return compareOrdered(a.Value, b.Value)
+	}
+	scaledA := big.NewInt(a.Value)
+	scaledA.Mul(scaledA, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(b.Scale)), nil))
+	scaledB := big.NewInt(b.Value)
+	scaledB.Mul(scaledB, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(a.Scale)), nil))
+	return scaledA.Cmp(scaledB)
+}
+
+// compareKeys returns -1, 0 or 1 depending on whether map key a is ordered before, equal to or
+// after map key b.
+func compareKeys(a, b any) int {
+	typeA, _ := TypeForValue(a)
+	typeB, _ := TypeForValue(b)
+	if typeA != typeB {
+		return compareOrdered(int(typeA), int(typeB))
+	}
+	switch typeA {
+	case Uint8Type, Uint16Type, Uint32Type, Uint64Type:
+		return compareOrdered(unsignedValue(a), unsignedValue(b))
+	case Int8Type, Int16Type, Int32Type, Int64Type, DateType, DurationType:
+		return compareOrdered(signedValue(a), signedValue(b))
+	case Float32Type:
+		return compareFloats(float64(a.(float32)), float64(b.(float32)))
+	case Float64Type:
+		return compareFloats(a.(float64), b.(float64))
+	case BoolType:
+		boolA, boolB := a.(bool), b.(bool)
+		if boolA == boolB {
+			return 0
+		} else if boolA {
+			return 1
+		}
+		return -1
+	case StringType:
+		return strings.Compare(a.(string), b.(string))
+	case UUIDType:
+		uuidA, uuidB := a.(UUID), b.(UUID)
+		return bytes.Compare(uuidA[:], uuidB[:])
+	case DecimalType:
+		return compareDecimals(a.(Decimal), b.(Decimal))
+	case TimeType:
+		return compareOrdered(a.(time.Time).UnixNano(), b.(time.Time).UnixNano())
+	case ZonedTimeType:
+		zonedA, zonedB := a.(ZonedTime), b.(ZonedTime)
+		if c := compareOrdered(zonedA.UnixNano(), zonedB.UnixNano()); c != 0 {
+			return c
+		}
+		_, offsetA := zonedA.Zone()
+		_, offsetB := zonedB.Zone()
+		return compareOrdered(offsetA, offsetB)
+	}
+	return 0
+}
+
+// SortedKeys returns the keys of the given map data in the order they are written.
+func SortedKeys(data map[any]any) []any {
+	keys := make([]any, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Slice(
+		keys, func(i, j int) bool {
+			return compareKeys(keys[i], keys[j]) < 0
+		},
+	)
+	return keys
+}
+
+// fixedSizeForType returns the number of bytes taken by every value of the given element type in
+// the given encoding. The last return value is false if values of the type vary in size.
+func fixedSizeForType(elemType Type, encoding Encoding) (uint32, bool) {
+	if encoding&CompactEncoding != 0 && isVarintType(elemType) {
+		return 0, false
+	}
+	switch elemType {
+	case BoolType, Uint8Type, Int8Type:
+		return 1, true
+	case Uint16Type, Int16Type:
+		return 2, true
+	case Uint32Type, Int32Type, Float32Type, DateType:
+		return 4, true
+	case Uint64Type, Int64Type, Float64Type, TimeType, DurationType:
+		return 8, true
+	case ZonedTimeType:
+		return zonedTimeSize, true
+	case DecimalType:
+		return decimalSize, true
+	case UUIDType:
+		return 16, true
+	}
+	return 0, false
+}
+
+// mapHasIndex returns true if sorted maps with the given key and value types store an index of
+// entry offsets in the given encoding, which they do unless every entry has the same size.
+func mapHasIndex(keyType Type, valueType Type, encoding Encoding) bool {
+	_, keyIsFixed := fixedSizeForType(keyType, encoding)
+	_, valueIsFixed := fixedSizeForType(valueType, encoding)
+	return !keyIsFixed || !valueIsFixed
+}
+
+// readMapHeader reads the header of the Map that starts at the given offset.
+func readMapHeader(b *Bytes, offset uint32, encoding Encoding) (mapHeader, error) {
+	length, offset, err := ReadLength(b, offset, encoding)
+	if err != nil {
+		return mapHeader{}, err
+	}
+	if err = checkBounds(b, offset, 2); err != nil {
+		return mapHeader{}, err
+	}
+	h := mapHeader{
+		length:    length,
+		keyType:   (*b)[offset] &^ sortedMapFlag,
+		valueType: (*b)[offset+1],
+		sorted:    (*b)[offset]&sortedMapFlag != 0,
+		entries:   offset + 2,
+	}
+	if !h.sorted {
+		return h, nil
+	}
+	if mapHasIndex(h.keyType, h.valueType, encoding) {
+		indexSize := uint64(length) * uint64(LengthFieldSize(encoding))
+		if err = checkBounds(b, h.entries, indexSize); err != nil {
+			return mapHeader{}, err
+		}
+		h.index = h.entries
+		h.entries += uint32(indexSize)
+	} else {
+		keySize, _ := fixedSizeForType(h.keyType, encoding)
+		valueSize, _ := fixedSizeForType(h.valueType, encoding)
+		h.entrySize = keySize + valueSize
+		if err = checkBounds(b, h.entries, uint64(length)*uint64(h.entrySize)); err != nil {
+			return mapHeader{}, err
+		}
+	}
+	return h, nil
+}
+
+// entryOffset returns the offset of the entry with the given index of a sorted map.
+func (h mapHeader) entryOffset(b *Bytes, i uint32, encoding Encoding) (uint32, error) {
+	if h.index == 0 {
+		return h.entries + i*h.entrySize, nil
+	}
+	relative, _, err := ReadLength(b, h.index+i*LengthFieldSize(encoding), encoding)
+	if err != nil {
+		return 0, err
+	}
+	if uint64(h.entries)+uint64(relative) > uint64(len(*b)) {
+		return 0, &CorruptRecordError{h.index, fmt.Sprintf("invalid map entry offset %d", relative)}
+	}
+	return h.entries + relative, nil
+}
+
+// lookupMapEntry returns the offset of the value stored for the given key in the Map that starts
+// at the given offset, along with the type of the value. Values of AnyType maps are resolved to
+// the offset right after their type byte, and their actual type is returned. The third return
+// value is false if the map does not have the given key.
+func lookupMapEntry(
+	b *Bytes, offset uint32, key any, encoding Encoding,
+) (uint32, Type, bool, error) {
+	h, err := readMapHeader(b, offset, encoding)
+	if err != nil {
+		return 0, NullType, false, err
+	}
+	if h.keyType != AnyType {
+		actualType, err := TypeForValue(key)
+		if err != nil {
+			return 0, NullType, false, err
+		}
+		if actualType != h.keyType {
+			return 0, NullType, false, &TypeMismatchError{h.keyType, actualType}
+		}
+	}
+
+	found := func(valueOffset uint32) (uint32, Type, bool, error) {
+		if h.valueType == AnyType {
+			actualType, err := readType(b, valueOffset)
+			return valueOffset + 1, actualType, err == nil, err
+		}
+		return valueOffset, h.valueType, true, nil
+	}
+
+	if h.sorted {
+		var searchErr error
+		readKey := func(i uint32) (any, uint32) {
+			entryOffset, err := h.entryOffset(b, i, encoding)
+			if err != nil {
+				searchErr = err
+				return nil, 0
+			}
+			entryKey, valueOffset, err := readValue(b, entryOffset, h.keyType, encoding, 1)
+			if err != nil {
+				searchErr = err
+			}
+			return entryKey, valueOffset
+		}
+		i := sort.Search(
+			int(h.length), func(i int) bool {
+				entryKey, _ := readKey(uint32(i))
+				return searchErr != nil || compareKeys(entryKey, key) >= 0
+			},
+		)
+		if searchErr != nil || i == int(h.length) {
+			return 0, NullType, false, searchErr
+		}
+		entryKey, valueOffset := readKey(uint32(i))
+		if searchErr != nil || compareKeys(entryKey, key) != 0 {
+			return 0, NullType, false, searchErr
+		}
+		return found(valueOffset)
+	}
+
+	offset = h.entries
+	for i := uint32(0); i < h.length; i++ {
+		entryKey, valueOffset, err := readValue(b, offset, h.keyType, encoding, 1)
+		if err != nil {
+			return 0, NullType, false, err
+		}
+		if compareKeys(entryKey, key) == 0 {
+			return found(valueOffset)
+		}
+		offset, err = skipValue(b, valueOffset, h.valueType, encoding, 1)
+		if err != nil {
+			return 0, NullType, false, err
+		}
+	}
+	return 0, NullType, false, nil
+}
+
+// LookupMapKey returns the value stored for the given key in the Map that starts at the given
+// offset, without decoding the rest of the map. The entry is found by a binary search over the
+// entries of a sorted map. The entries of a map written before entries were sorted are scanned in
+// order until the key is found, skipping over the values of other keys without decoding them, so
+// a key that is not found is only reported missing after all entries have been scanned.
+//
+// The second return value is false if the map does not have the given key.
+func LookupMapKey(b *Bytes, offset uint32, key any, encoding Encoding) (any, bool, error) {
+	valueOffset, valueType, found, err := lookupMapEntry(b, offset, key, encoding)
+	if !found || err != nil || valueType == NullType {
+		return nil, found, err
+	}
+	value, _, err := readValue(b, valueOffset, valueType, encoding, 1)
+	return value, err == nil, err
+}
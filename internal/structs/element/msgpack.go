@@ -0,0 +1,382 @@
+package element
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+/*
+ * Values are encoded in MessagePack as follows:
+ *   - integers use the format of their width and signedness, for example uint16 values always use
+ *     the uint 16 format and int64 values always use the int 64 format, so that decoding restores
+ *     their Go type; positive and negative fixints written by other encoders decode as int64
+ *   - float32 and float64 values use the float 32 and float 64 formats
+ *   - nulls, bools, strings, byte slices, arrays and maps use the matching MessagePack formats,
+ *     with map entries in key order
+ *   - time.Time values use the timestamp extension type (-1) in its 96-bit format; all three
+ *     timestamp formats are decoded
+ *   - the other types use application extension types:
+ *       1: UUID, the 16 bytes of the UUID
+ *       2: Decimal, 1 byte precision, 1 byte scale and the 8 byte unscaled value
+ *       3: Date, the 4 byte number of days since 1970-01-01
+ *       4: Duration, the 8 byte number of nanoseconds
+ *       5: ZonedTime, the 8 byte seconds since the Unix epoch, 4 byte nanoseconds and 4 byte offset
+ *          of the time zone from UTC in seconds
+ * All multi-byte numbers are big-endian.
+ */
+
+const (
+	msgpackFormat = "MessagePack"
+
+	msgpackTimestampExt = -1
+	msgpackUUIDExt      = 1
+	msgpackDecimalExt   = 2
+	msgpackDateExt      = 3
+	msgpackDurationExt  = 4
+	msgpackZonedTimeExt = 5
+)
+
+// EncodeMsgpack returns the MessagePack encoding of the given value, which can be of any element
+// type.
+func EncodeMsgpack(value any) ([]byte, error) {
+	return appendMsgpack(nil, value, 0)
+}
+
+// DecodeMsgpack returns the value encoded in the given MessagePack data, which must hold exactly
+// one value. DecodeError is returned if the data is malformed.
+func DecodeMsgpack(data []byte) (any, error) {
+	r := &codecReader{format: msgpackFormat, data: data}
+	value, err := r.readMsgpack(0)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.checkEnd(); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// appendMsgpackLength appends the header of a string, binary, array or map value of the given
+// length. fixFormat is the format byte of the fix format, or zero if there is none, and fixMax is
+// the largest length the fix format can store. The 8, 16 and 32 bit formats follow each other
+// starting at format8, or at format16 if format8 is zero.
+func appendMsgpackLength(
+	b []byte, length int, fixFormat byte, fixMax int, format8 byte, format16 byte,
+) ([]byte, error) {
+	switch {
+	case fixFormat != 0 && length <= fixMax:
+		return append(b, fixFormat|byte(length)), nil
+	case format8 != 0 && length <= math.MaxUint8:
+		return append(b, format8, byte(length)), nil
+	case length <= math.MaxUint16:
+		return appendUint(append(b, format16), uint64(length), 2), nil
+	case uint64(length) <= math.MaxUint32:
+		return appendUint(append(b, format16+1), uint64(length), 4), nil
+	}
+	return b, fmt.Errorf("length %d is too large for %s", length, msgpackFormat)
+}
+
+// appendMsgpackExt appends an extension value of the given type.
+func appendMsgpackExt(b []byte, extType int8, data []byte) []byte {
+	switch len(data) {
+	case 1, 2, 4, 8, 16:
+		fixFormats := map[int]byte{1: 0xd4, 2: 0xd5, 4: 0xd6, 8: 0xd7, 16: 0xd8}
+		b = append(b, fixFormats[len(data)], byte(extType))
+	default:
+		b = append(b, 0xc7, byte(len(data)), byte(extType))
+	}
+	return append(b, data...)
+}
+
+func appendMsgpack(b []byte, value any, depth int) ([]byte, error) {
+	var err error
+	switch v := value.(type) {
+	case nil:
+		b = append(b, 0xc0)
+	case bool:
+		if v {
+			b = append(b, 0xc3)
+		} else {
+			b = append(b, 0xc2)
+		}
+	case uint8:
+		b = append(b, 0xcc, v)
+	case uint16:
+		b = appendUint(append(b, 0xcd), uint64(v), 2)
+	case uint:
+		b = appendUint(append(b, 0xce), uint64(uint32(v)), 4)
+	case uint32:
+		b = appendUint(append(b, 0xce), uint64(v), 4)
+	case uint64:
+		b = appendUint(append(b, 0xcf), v, 8)
+	case int8:
+		b = append(b, 0xd0, byte(v))
+	case int16:
+		b = appendUint(append(b, 0xd1), uint64(v), 2)
+	case int:
+		b = appendUint(append(b, 0xd2), uint64(int32(v)), 4)
+	case int32:
+		b = appendUint(append(b, 0xd2), uint64(v), 4)
+	case int64:
+		b = appendUint(append(b, 0xd3), uint64(v), 8)
+	case float32:
+		b = appendUint(append(b, 0xca), uint64(math.Float32bits(v)), 4)
+	case float64:
+		b = appendUint(append(b, 0xcb), math.Float64bits(v), 8)
+	case string:
+		if b, err = appendMsgpackLength(b, len(v), 0xa0, 31, 0xd9, 0xda); err != nil {
+			return b, err
+		}
+		b = append(b, v...)
+	case []byte:
+		if b, err = appendMsgpackLength(b, len(v), 0, 0, 0xc4, 0xc5); err != nil {
+			return b, err
+		}
+		b = append(b, v...)
+	case UUID:
+		b = appendMsgpackExt(b, msgpackUUIDExt, v[:])
+	case Decimal:
+		data := appendUint([]byte{v.Precision, v.Scale}, uint64(v.Value), 8)
+		b = appendMsgpackExt(b, msgpackDecimalExt, data)
+	case time.Time:
+		data := appendUint(nil, uint64(v.Nanosecond()), 4)
+		b = appendMsgpackExt(b, msgpackTimestampExt, appendUint(data, uint64(v.Unix()), 8))
+	case Date:
+		b = appendMsgpackExt(b, msgpackDateExt, appendUint(nil, uint64(uint32(v)), 4))
+	case time.Duration:
+		b = appendMsgpackExt(b, msgpackDurationExt, appendUint(nil, uint64(v), 8))
+	case ZonedTime:
+		_, zoneOffset := v.Zone()
+		data := appendUint(nil, uint64(v.Unix()), 8)
+		data = appendUint(data, uint64(v.Nanosecond()), 4)
+		data = appendUint(data, uint64(uint32(int32(zoneOffset))), 4)
+		b = appendMsgpackExt(b, msgpackZonedTimeExt, data)
+	case Array:
+		if depth++; depth > MaxNestingDepth {
+			return b, &NestingDepthError{depth}
+		}
+		if b, err = appendMsgpackLength(b, len(v.Values), 0x90, 15, 0, 0xdc); err != nil {
+			return b, err
+		}
+		for _, elem := range v.Values {
+			if err = checkValueType(v.ElementType, elem); err != nil {
+				return b, err
+			}
+			if b, err = appendMsgpack(b, elem, depth); err != nil {
+				return b, err
+			}
+		}
+	case Map:
+		if depth++; depth > MaxNestingDepth {
+			return b, &NestingDepthError{depth}
+		}
+		if b, err = appendMsgpackLength(b, len(v.Data), 0x80, 15, 0, 0xde); err != nil {
+			return b, err
+		}
+		for _, key := range SortedKeys(v.Data) {
+			if err = checkValueType(v.KeyType, key); err != nil {
+				return b, err
+			}
+			if err = checkValueType(v.ValueType, v.Data[key]); err != nil {
+				return b, err
+			}
+			if b, err = appendMsgpack(b, key, depth); err != nil {
+				return b, err
+			}
+			if b, err = appendMsgpack(b, v.Data[key], depth); err != nil {
+				return b, err
+			}
+		}
+	default:
+		return b, fmt.Errorf("unsupported type %T", value)
+	}
+	return b, nil
+}
+
+// readMsgpack decodes the next value.
+func (r *codecReader) readMsgpack(depth int) (any, error) {
+	start := r.offset
+	format, err := r.readByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case format <= 0x7f:
+		return int64(format), nil
+	case format >= 0xe0:
+		return int64(int8(format)), nil
+	case format <= 0x8f:
+		return r.readMsgpackMap(uint64(format&0x0f), depth)
+	case format <= 0x9f:
+		return r.readMsgpackArray(uint64(format&0x0f), depth)
+	case format <= 0xbf:
+		b, err := r.next(uint64(format & 0x1f))
+		return string(b), err
+	}
+
+	switch format {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		length, err := r.readUint(1 << (format - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		b, err := r.next(length)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, b...), nil
+	case 0xc7, 0xc8, 0xc9:
+		length, err := r.readUint(1 << (format - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return r.readMsgpackExt(length)
+	case 0xca:
+		bits, err := r.readUint(4)
+		return math.Float32frombits(uint32(bits)), err
+	case 0xcb:
+		bits, err := r.readUint(8)
+		return math.Float64frombits(bits), err
+	case 0xcc:
+		u, err := r.readUint(1)
+		return uint8(u), err
+	case 0xcd:
+		u, err := r.readUint(2)
+		return uint16(u), err
+	case 0xce:
+		u, err := r.readUint(4)
+		return uint32(u), err
+	case 0xcf:
+		return r.readUint(8)
+	case 0xd0:
+		u, err := r.readUint(1)
+		return int8(u), err
+	case 0xd1:
+		u, err := r.readUint(2)
+		return int16(u), err
+	case 0xd2:
+		u, err := r.readUint(4)
+		return int32(u), err
+	case 0xd3:
+		u, err := r.readUint(8)
+		return int64(u), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return r.readMsgpackExt(1 << (format - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		length, err := r.readUint(1 << (format - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		b, err := r.next(length)
+		return string(b), err
+	case 0xdc, 0xdd:
+		count, err := r.readUint(2 << (format - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return r.readMsgpackArray(count, depth)
+	case 0xde, 0xdf:
+		count, err := r.readUint(2 << (format - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return r.readMsgpackMap(count, depth)
+	}
+	r.offset = start
+	return nil, r.errorf("unknown format 0x%02x", format)
+}
+
+func (r *codecReader) readMsgpackArray(count uint64, depth int) (Array, error) {
+	if depth++; depth > MaxNestingDepth {
+		return Array{}, &NestingDepthError{depth}
+	}
+	if err := r.checkCount(count, 1); err != nil {
+		return Array{}, err
+	}
+	values := make([]any, count)
+	for i := range values {
+		var err error
+		if values[i], err = r.readMsgpack(depth); err != nil {
+			return Array{}, err
+		}
+	}
+	return Array{typeOfValues(values), values}, nil
+}
+
+func (r *codecReader) readMsgpackMap(count uint64, depth int) (Map, error) {
+	if depth++; depth > MaxNestingDepth {
+		return Map{}, &NestingDepthError{depth}
+	}
+	if err := r.checkCount(count, 2); err != nil {
+		return Map{}, err
+	}
+	keys := make([]any, count)
+	values := make([]any, count)
+	for i := range keys {
+		var err error
+		if keys[i], err = r.readMsgpack(depth); err != nil {
+			return Map{}, err
+		}
+		if values[i], err = r.readMsgpack(depth); err != nil {
+			return Map{}, err
+		}
+	}
+	return r.decodedMap(keys, values)
+}
+
+// readMsgpackExt decodes the type and data of an extension value of the given length.
+func (r *codecReader) readMsgpackExt(length uint64) (any, error) {
+	extByte, err := r.readByte()
+	if err != nil {
+		return nil, err
+	}
+	extType := int8(extByte)
+	data, err := r.next(length)
+	if err != nil {
+		return nil, err
+	}
+	ext := &codecReader{format: r.format, data: data}
+	switch {
+	case extType == msgpackTimestampExt && length == 4:
+		seconds, _ := ext.readUint(4)
+		return time.Unix(int64(seconds), 0), nil
+	case extType == msgpackTimestampExt && length == 8:
+		value, _ := ext.readUint(8)
+		return time.Unix(int64(value&(1<<34-1)), int64(value>>34)), nil
+	case extType == msgpackTimestampExt && length == 12:
+		nanoseconds, _ := ext.readUint(4)
+		seconds, _ := ext.readUint(8)
+		return time.Unix(int64(seconds), int64(nanoseconds)), nil
+	case extType == msgpackUUIDExt && length == 16:
+		var u UUID
+		copy(u[:], data)
+		return u, nil
+	case extType == msgpackDecimalExt && length == decimalSize:
+		ext.offset = 2
+		value, _ := ext.readUint(8)
+		d, err := NewDecimal(int64(value), data[0], data[1])
+		if err != nil {
+			return nil, r.errorf("%v", err)
+		}
+		return d, nil
+	case extType == msgpackDateExt && length == 4:
+		days, _ := ext.readUint(4)
+		return Date(int32(days)), nil
+	case extType == msgpackDurationExt && length == 8:
+		nanoseconds, _ := ext.readUint(8)
+		return time.Duration(nanoseconds), nil
+	case extType == msgpackZonedTimeExt && length == zonedTimeSize:
+		seconds, _ := ext.readUint(8)
+		nanoseconds, _ := ext.readUint(4)
+		zoneOffset, _ := ext.readUint(4)
+		return newZonedTime(int64(seconds), int64(nanoseconds), int(int32(zoneOffset))), nil
+	}
+	return nil, r.errorf("unknown extension type %d with %d bytes", extType, length)
+}
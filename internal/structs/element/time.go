@@ -0,0 +1,97 @@
+package element
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	secondsPerDay = 24 * 60 * 60
+
+	// zonedTimeSize is the number of bytes needed to store a ZonedTime: 8 bytes for the seconds
+	// since the Unix epoch, 4 bytes for the nanoseconds within the second and 4 bytes for the
+	// offset of the time zone from UTC in seconds.
+	zonedTimeSize = 16
+)
+
+var (
+	minTime = time.Unix(0, math.MinInt64)
+	maxTime = time.Unix(0, math.MaxInt64)
+)
+
+// Date is a calendar date, stored as the number of days since 1970-01-01.
+type Date int32
+
+// ZonedTime is a time that keeps the offset of its time zone from UTC when it is stored. Only the
+// offset is kept, the name of the time zone is not.
+type ZonedTime struct {
+	time.Time
+}
+
+// TimeOutOfRangeError is returned when a time cannot be stored as a TimeType element. TimeType
+// elements are stored as nanoseconds since the Unix epoch, which limits them to the years 1678 to
+// 2262.
+type TimeOutOfRangeError struct {
+	value time.Time
+}
+
+func (e *TimeOutOfRangeError) Error() string {
+	return fmt.Sprintf(
+		"time %v is out of range, must be between %v and %v", e.value, minTime.UTC(), maxTime.UTC(),
+	)
+}
+
+// CheckTimeRange returns a TimeOutOfRangeError if the given time cannot be stored as a TimeType
+// element.
+func CheckTimeRange(t time.Time) error {
+	if t.Before(minTime) || t.After(maxTime) {
+		return &TimeOutOfRangeError{t}
+	}
+	return nil
+}
+
+// DateOf returns the calendar date of the given time in the time's location.
+func DateOf(t time.Time) Date {
+	year, month, day := t.Date()
+	seconds := time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Unix()
+	days := seconds / secondsPerDay
+	if seconds%secondsPerDay < 0 {
+		days--
+	}
+	return Date(days)
+}
+
+// Time returns midnight UTC at the start of the date.
+func (d Date) Time() time.Time {
+	return time.Unix(int64(d)*secondsPerDay, 0).UTC()
+}
+
+// String returns the date formatted as YYYY-MM-DD.
+func (d Date) String() string {
+	return d.Time().Format("2006-01-02")
+}
+
+func WriteZonedTime(b *Bytes, offset uint32, value ZonedTime) {
+	_, zoneOffset := value.Zone()
+	WriteUint64(b, offset, uint64(value.Unix()))
+	WriteUint32(b, offset+8, uint32(value.Nanosecond()))
+	WriteUint32(b, offset+12, uint32(int32(zoneOffset)))
+}
+
+func ReadZonedTime(b *Bytes, offset uint32) ZonedTime {
+	seconds := int64(ReadUint64(b, offset))
+	nanoseconds := int64(ReadUint32(b, offset+8))
+	zoneOffset := int(int32(ReadUint32(b, offset+12)))
+	return newZonedTime(seconds, nanoseconds, zoneOffset)
+}
+
+// newZonedTime returns the ZonedTime for the given time since the Unix epoch, in a time zone with
+// the given offset from UTC in seconds.
+func newZonedTime(seconds int64, nanoseconds int64, zoneOffset int) ZonedTime {
+	location := time.UTC
+	if zoneOffset != 0 {
+		location = time.FixedZone("", zoneOffset)
+	}
+	return ZonedTime{time.Unix(seconds, nanoseconds).In(location)}
+}
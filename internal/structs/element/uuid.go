@@ -0,0 +1,38 @@
+package element
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// UUID is a 16-byte universally unique identifier.
+type UUID [16]byte
+
+// InvalidUUIDError is returned when a string cannot be parsed as a UUID.
+type InvalidUUIDError struct {
+	value string
+}
+
+func (e *InvalidUUIDError) Error() string {
+	return fmt.Sprintf("invalid uuid '%s'", e.value)
+}
+
+// ParseUUID parses a UUID in its canonical form, e.g. "123e4567-e89b-12d3-a456-426614174000".
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, &InvalidUUIDError{s}
+	}
+	digits := s[0:8] + s[9:13] + s[14:18] + s[19:23] + s[24:36]
+	if _, err := hex.Decode(u[:], []byte(digits)); err != nil {
+		return u, &InvalidUUIDError{s}
+	}
+	return u, nil
+}
+
+// String returns the UUID in its canonical form.
+func (u UUID) String() string {
+	digits := hex.EncodeToString(u[:])
+	return digits[0:8] + "-" + digits[8:12] + "-" + digits[12:16] + "-" + digits[16:20] + "-" +
+		digits[20:32]
+}
@@ -0,0 +1,635 @@
+package element
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// ArrayView and MapView read the values of an Array or a Map directly from the bytes they are
+// encoded in, decoding only the values that are asked for. Views do not copy the bytes, so a view
+// must not be used after the bytes it was created from have been modified.
+//
+// Reading the value at an index of an ArrayView skips over the values before it, unless all values
+// of the array have the same fixed size, so visiting every value by index takes time quadratic in
+// the length of the array. ArrayIterator and MapIterator find each value right after the one before
+// it instead, and return ValueViews, which decode nothing until one of their accessors is called
+// and give access to nested arrays and maps as views.
+
+// ArrayView gives access to the values of an encoded Array without decoding the whole array.
+type ArrayView struct {
+	b           Bytes
+	start       uint32
+	length      uint32
+	elementType Type
+	encoding    Encoding
+}
+
+// MapView gives access to the entries of an encoded Map without decoding the whole map.
+type MapView struct {
+	b         Bytes
+	offset    uint32
+	start     uint32
+	length    uint32
+	keyType   Type
+	valueType Type
+	encoding  Encoding
+}
+
+// ValueView gives access to a single encoded value of an ArrayView or a MapView.
+type ValueView struct {
+	b        Bytes
+	offset   uint32
+	elemType Type
+	encoding Encoding
+
+	// delta is set for the values of arrays that store time deltas, whose bytes do not hold the
+	// time itself, which is stored in nanos instead.
+	delta bool
+	nanos int64
+}
+
+// ArrayIterator iterates over the values of an ArrayView in order.
+type ArrayIterator struct {
+	view   ArrayView
+	index  int
+	offset uint32
+	nanos  int64
+}
+
+// MapIterator iterates over the entries of a MapView in key order.
+type MapIterator struct {
+	view   MapView
+	index  uint32
+	offset uint32
+}
+
+// IndexOutOfRangeError is returned when a value is requested at an index outside an ArrayView.
+type IndexOutOfRangeError struct {
+	index  int
+	length int
+}
+
+func (e *IndexOutOfRangeError) Error() string {
+	return fmt.Sprintf("index %d out of range for array of length %d", e.index, e.length)
+}
+
+// NewArrayView returns a view of the Array that starts at the given offset.
+func NewArrayView(b *Bytes, offset uint32, encoding Encoding) (ArrayView, error) {
+	length, start, err := ReadLength(b, offset, encoding)
+	if err != nil {
+		return ArrayView{}, err
+	}
+	elementType, err := readType(b, start)
+	if err != nil {
+		return ArrayView{}, err
+	}
+	if err = checkCount(b, start+1, length, 1); err != nil {
+		return ArrayView{}, err
+	}
+	return ArrayView{*b, start + 1, length, elementType, encoding}, nil
+}
+
+// NewMapView returns a view of the Map that starts at the given offset.
+func NewMapView(b *Bytes, offset uint32, encoding Encoding) (MapView, error) {
+	h, err := readMapHeader(b, offset, encoding)
+	if err != nil {
+		return MapView{}, err
+	}
+	if err = checkCount(b, h.entries, h.length, 2); err != nil {
+		return MapView{}, err
+	}
+	return MapView{*b, offset, h.entries, h.length, h.keyType, h.valueType, encoding}, nil
+}
+
+// newValueView returns a view of the value stored at the given offset as an element of the given
+// type. Values of AnyType elements are resolved to their actual type. Primitive values are checked
+// to fit in the bytes; arrays and maps are checked when a view of them is created.
+func newValueView(b *Bytes, offset uint32, elemType Type, encoding Encoding) (ValueView, error) {
+	if elemType == AnyType {
+		actualType, err := readType(b, offset)
+		if err != nil {
+			return ValueView{}, err
+		}
+		if actualType == AnyType {
+			return ValueView{}, &CorruptRecordError{offset, "value of any type tagged as any type"}
+		}
+		offset++
+		elemType = actualType
+	}
+	if elemType != NullType && elemType != ArrayType && elemType != MapType {
+		if _, err := skipValue(b, offset, elemType, encoding, 1); err != nil {
+			return ValueView{}, err
+		}
+	}
+	return ValueView{b: *b, offset: offset, elemType: elemType, encoding: encoding}, nil
+}
+
+// Len returns the number of values in the array.
+func (a ArrayView) Len() int {
+	return int(a.length)
+}
+
+// ElementType returns the element type of the array.
+func (a ArrayView) ElementType() Type {
+	return a.elementType
+}
+
+// offsetAt returns the offset of the value at the given index. The values before the index are
+// skipped over unless all values of the array have the same fixed size.
+func (a ArrayView) offsetAt(i int) (uint32, error) {
+	if i < 0 || i >= int(a.length) {
+		return 0, &IndexOutOfRangeError{i, int(a.length)}
+	}
+	if size, ok := fixedSizeForType(a.elementType, a.encoding); ok {
+		return a.start + uint32(i)*size, nil
+	}
+	offset := a.start
+	for j := 0; j < i; j++ {
+		var err error
+		offset, err = skipValue(&a.b, offset, a.elementType, a.encoding, 1)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return offset, nil
+}
+
+// timeAt returns the time at the given index of an array that stores time deltas.
+func (a ArrayView) timeAt(i int) (time.Time, error) {
+	if i < 0 || i >= int(a.length) {
+		return time.Time{}, &IndexOutOfRangeError{i, int(a.length)}
+	}
+	var nanos int64
+	offset := a.start
+	for j := 0; j <= i; j++ {
+		varint, offsetAfterRead, err := ReadUvarint(&a.b, offset)
+		if err != nil {
+			return time.Time{}, err
+		}
+		nanos += unzigzag(varint)
+		offset = offsetAfterRead
+	}
+	return time.Unix(0, nanos), nil
+}
+
+// ValueAt returns a view of the value at the given index.
+func (a ArrayView) ValueAt(i int) (ValueView, error) {
+	if isTimeDeltaArray(a.elementType, a.encoding) {
+		t, err := a.timeAt(i)
+		if err != nil {
+			return ValueView{}, err
+		}
+		nanos := t.UnixNano()
+		return ValueView{elemType: TimeType, encoding: a.encoding, delta: true, nanos: nanos}, nil
+	}
+	offset, err := a.offsetAt(i)
+	if err != nil {
+		return ValueView{}, err
+	}
+	return newValueView(&a.b, offset, a.elementType, a.encoding)
+}
+
+// At returns the value at the given index.
+func (a ArrayView) At(i int) (any, error) {
+	value, err := a.ValueAt(i)
+	if err != nil {
+		return nil, err
+	}
+	return value.Value()
+}
+
+// Iterator returns an iterator over the values of the array.
+func (a ArrayView) Iterator() *ArrayIterator {
+	return &ArrayIterator{view: a, offset: a.start}
+}
+
+// Range calls fn for each value of the array in order, until fn returns false.
+func (a ArrayView) Range(fn func(i int, value ValueView) bool) error {
+	it := ArrayIterator{view: a, offset: a.start}
+	for {
+		i, value, err := it.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !fn(i, value) {
+			return nil
+		}
+	}
+}
+
+// BoolAt returns the bool value at the given index.
+func (a ArrayView) BoolAt(i int) (bool, error) {
+	value, err := a.ValueAt(i)
+	if err != nil {
+		return false, err
+	}
+	return value.Bool()
+}
+
+// Int32At returns the int32 value at the given index.
+func (a ArrayView) Int32At(i int) (int32, error) {
+	value, err := a.ValueAt(i)
+	if err != nil {
+		return 0, err
+	}
+	return value.Int32()
+}
+
+// Int64At returns the int64 value at the given index.
+func (a ArrayView) Int64At(i int) (int64, error) {
+	value, err := a.ValueAt(i)
+	if err != nil {
+		return 0, err
+	}
+	return value.Int64()
+}
+
+// Uint32At returns the uint32 value at the given index.
+func (a ArrayView) Uint32At(i int) (uint32, error) {
+	value, err := a.ValueAt(i)
+	if err != nil {
+		return 0, err
+	}
+	return value.Uint32()
+}
+
+// Uint64At returns the uint64 value at the given index.
+func (a ArrayView) Uint64At(i int) (uint64, error) {
+	value, err := a.ValueAt(i)
+	if err != nil {
+		return 0, err
+	}
+	return value.Uint64()
+}
+
+// Float32At returns the float32 value at the given index.
+func (a ArrayView) Float32At(i int) (float32, error) {
+	value, err := a.ValueAt(i)
+	if err != nil {
+		return 0, err
+	}
+	return value.Float32()
+}
+
+// Float64At returns the float64 value at the given index.
+func (a ArrayView) Float64At(i int) (float64, error) {
+	value, err := a.ValueAt(i)
+	if err != nil {
+		return 0, err
+	}
+	return value.Float64()
+}
+
+// StringAt returns the string value at the given index.
+func (a ArrayView) StringAt(i int) (string, error) {
+	value, err := a.ValueAt(i)
+	if err != nil {
+		return "", err
+	}
+	return value.String()
+}
+
+// BytesAt returns the bytes value at the given index. The returned slice shares its memory with
+// the bytes of the view.
+func (a ArrayView) BytesAt(i int) ([]byte, error) {
+	value, err := a.ValueAt(i)
+	if err != nil {
+		return nil, err
+	}
+	return value.Bytes()
+}
+
+// TimeAt returns the time.Time value at the given index.
+func (a ArrayView) TimeAt(i int) (time.Time, error) {
+	value, err := a.ValueAt(i)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return value.Time()
+}
+
+// ArrayAt returns a view of the Array at the given index.
+func (a ArrayView) ArrayAt(i int) (ArrayView, error) {
+	value, err := a.ValueAt(i)
+	if err != nil {
+		return ArrayView{}, err
+	}
+	return value.Array()
+}
+
+// MapAt returns a view of the Map at the given index.
+func (a ArrayView) MapAt(i int) (MapView, error) {
+	value, err := a.ValueAt(i)
+	if err != nil {
+		return MapView{}, err
+	}
+	return value.Map()
+}
+
+// Next returns the index and a view of the next value of the array. io.EOF is returned after the
+// last value.
+func (it *ArrayIterator) Next() (int, ValueView, error) {
+	a := it.view
+	if it.index >= int(a.length) {
+		return it.index, ValueView{}, io.EOF
+	}
+	var value ValueView
+	var end uint32
+	var err error
+	if isTimeDeltaArray(a.elementType, a.encoding) {
+		var varint uint64
+		if varint, end, err = ReadUvarint(&a.b, it.offset); err != nil {
+			return it.index, ValueView{}, err
+		}
+		it.nanos += unzigzag(varint)
+		value = ValueView{elemType: TimeType, encoding: a.encoding, delta: true, nanos: it.nanos}
+	} else {
+		if end, err = skipValue(&a.b, it.offset, a.elementType, a.encoding, 1); err != nil {
+			return it.index, ValueView{}, err
+		}
+		if value, err = newValueView(&a.b, it.offset, a.elementType, a.encoding); err != nil {
+			return it.index, ValueView{}, err
+		}
+	}
+	i := it.index
+	it.index++
+	it.offset = end
+	return i, value, nil
+}
+
+// Len returns the number of entries in the map.
+func (m MapView) Len() int {
+	return int(m.length)
+}
+
+// KeyType returns the key type of the map.
+func (m MapView) KeyType() Type {
+	return m.keyType
+}
+
+// ValueType returns the value type of the map.
+func (m MapView) ValueType() Type {
+	return m.valueType
+}
+
+// Get returns the value stored for the given key. The second return value is false if the map
+// does not have the given key.
+func (m MapView) Get(key any) (any, bool, error) {
+	return LookupMapKey(&m.b, m.offset, key, m.encoding)
+}
+
+// GetValue returns a view of the value stored for the given key. The second return value is false
+// if the map does not have the given key.
+func (m MapView) GetValue(key any) (ValueView, bool, error) {
+	offset, actualType, found, err := lookupMapEntry(&m.b, m.offset, key, m.encoding)
+	if !found || err != nil {
+		return ValueView{}, false, err
+	}
+	value, err := newValueView(&m.b, offset, actualType, m.encoding)
+	return value, err == nil, err
+}
+
+// GetArray returns a view of the Array stored for the given key.
+func (m MapView) GetArray(key any) (ArrayView, bool, error) {
+	value, found, err := m.GetValue(key)
+	if !found {
+		return ArrayView{}, false, err
+	}
+	view, err := value.Array()
+	return view, err == nil, err
+}
+
+// GetMap returns a view of the Map stored for the given key.
+func (m MapView) GetMap(key any) (MapView, bool, error) {
+	value, found, err := m.GetValue(key)
+	if !found {
+		return MapView{}, false, err
+	}
+	view, err := value.Map()
+	return view, err == nil, err
+}
+
+// Iterator returns an iterator over the entries of the map.
+func (m MapView) Iterator() *MapIterator {
+	return &MapIterator{view: m, offset: m.start}
+}
+
+// Range calls fn for each entry of the map in key order, until fn returns false.
+func (m MapView) Range(fn func(key, value ValueView) bool) error {
+	it := MapIterator{view: m, offset: m.start}
+	for {
+		key, value, err := it.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !fn(key, value) {
+			return nil
+		}
+	}
+}
+
+// Next returns views of the key and the value of the next entry of the map. io.EOF is returned
+// after the last entry.
+func (it *MapIterator) Next() (ValueView, ValueView, error) {
+	m := it.view
+	if it.index >= m.length {
+		return ValueView{}, ValueView{}, io.EOF
+	}
+	valueOffset, err := skipValue(&m.b, it.offset, m.keyType, m.encoding, 1)
+	if err != nil {
+		return ValueView{}, ValueView{}, err
+	}
+	end, err := skipValue(&m.b, valueOffset, m.valueType, m.encoding, 1)
+	if err != nil {
+		return ValueView{}, ValueView{}, err
+	}
+	key, err := newValueView(&m.b, it.offset, m.keyType, m.encoding)
+	if err != nil {
+		return ValueView{}, ValueView{}, err
+	}
+	value, err := newValueView(&m.b, valueOffset, m.valueType, m.encoding)
+	if err != nil {
+		return ValueView{}, ValueView{}, err
+	}
+	it.index++
+	it.offset = end
+	return key, value, nil
+}
+
+// Type returns the type of the value, which is NullType for the null values of AnyType arrays and
+// maps.
+func (v ValueView) Type() Type {
+	return v.elemType
+}
+
+// Value returns the value, decoding nested arrays and maps in full.
+func (v ValueView) Value() (any, error) {
+	switch {
+	case v.elemType == NullType:
+		return nil, nil
+	case v.delta:
+		return time.Unix(0, v.nanos), nil
+	}
+	value, _, err := readValue(&v.b, v.offset, v.elemType, v.encoding, 1)
+	return value, err
+}
+
+// checkType returns a TypeMismatchError if the value is not of the given type.
+func (v ValueView) checkType(elemType Type) error {
+	if v.elemType != elemType {
+		return &TypeMismatchError{elemType, v.elemType}
+	}
+	return nil
+}
+
+// varint returns the varint that the value is stored as in CompactEncoding.
+func (v ValueView) varint() (uint64, error) {
+	varint, _, err := ReadUvarint(&v.b, v.offset)
+	return varint, err
+}
+
+// Bool returns the value as a bool.
+func (v ValueView) Bool() (bool, error) {
+	if err := v.checkType(BoolType); err != nil {
+		return false, err
+	}
+	return ReadBool(&v.b, v.offset), nil
+}
+
+// Int32 returns the value as an int32.
+func (v ValueView) Int32() (int32, error) {
+	if err := v.checkType(Int32Type); err != nil {
+		return 0, err
+	}
+	if v.encoding&CompactEncoding == 0 {
+		return int32(ReadUint32(&v.b, v.offset)), nil
+	}
+	varint, err := v.varint()
+	if err == nil {
+		err = checkVarintRange(varint, Int32Type, v.offset)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int32(unzigzag(varint)), nil
+}
+
+// Int64 returns the value as an int64.
+func (v ValueView) Int64() (int64, error) {
+	if err := v.checkType(Int64Type); err != nil {
+		return 0, err
+	}
+	if v.encoding&CompactEncoding == 0 {
+		return int64(ReadUint64(&v.b, v.offset)), nil
+	}
+	varint, err := v.varint()
+	return unzigzag(varint), err
+}
+
+// Uint32 returns the value as a uint32.
+func (v ValueView) Uint32() (uint32, error) {
+	if err := v.checkType(Uint32Type); err != nil {
+		return 0, err
+	}
+	if v.encoding&CompactEncoding == 0 {
+		return ReadUint32(&v.b, v.offset), nil
+	}
+	varint, err := v.varint()
+	if err == nil {
+		err = checkVarintRange(varint, Uint32Type, v.offset)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return uint32(varint), nil
+}
+
+// Uint64 returns the value as a uint64.
+func (v ValueView) Uint64() (uint64, error) {
+	if err := v.checkType(Uint64Type); err != nil {
+		return 0, err
+	}
+	if v.encoding&CompactEncoding == 0 {
+		return ReadUint64(&v.b, v.offset), nil
+	}
+	return v.varint()
+}
+
+// Float32 returns the value as a float32.
+func (v ValueView) Float32() (float32, error) {
+	if err := v.checkType(Float32Type); err != nil {
+		return 0, err
+	}
+	return math.Float32frombits(ReadUint32(&v.b, v.offset)), nil
+}
+
+// Float64 returns the value as a float64.
+func (v ValueView) Float64() (float64, error) {
+	if err := v.checkType(Float64Type); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(ReadUint64(&v.b, v.offset)), nil
+}
+
+// String returns the value as a string.
+func (v ValueView) String() (string, error) {
+	if err := v.checkType(StringType); err != nil {
+		return "", err
+	}
+	value, _, err := ReadString(&v.b, v.offset, v.encoding)
+	return value, err
+}
+
+// Bytes returns the value as a byte slice, which shares its memory with the bytes of the view.
+func (v ValueView) Bytes() ([]byte, error) {
+	if err := v.checkType(BytesType); err != nil {
+		return nil, err
+	}
+	length, offset, err := ReadLength(&v.b, v.offset, v.encoding)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkBounds(&v.b, offset, uint64(length)); err != nil {
+		return nil, err
+	}
+	return v.b[offset : offset+length : offset+length], nil
+}
+
+// Time returns the value as a time.Time.
+func (v ValueView) Time() (time.Time, error) {
+	if err := v.checkType(TimeType); err != nil {
+		return time.Time{}, err
+	}
+	switch {
+	case v.delta:
+		return time.Unix(0, v.nanos), nil
+	case v.encoding&CompactEncoding != 0:
+		varint, err := v.varint()
+		return time.Unix(0, unzigzag(varint)), err
+	}
+	return time.Unix(0, int64(ReadUint64(&v.b, v.offset))), nil
+}
+
+// Array returns a view of the value as an Array.
+func (v ValueView) Array() (ArrayView, error) {
+	if err := v.checkType(ArrayType); err != nil {
+		return ArrayView{}, err
+	}
+	return NewArrayView(&v.b, v.offset, v.encoding)
+}
+
+// Map returns a view of the value as a Map.
+func (v ValueView) Map() (MapView, error) {
+	if err := v.checkType(MapType); err != nil {
+		return MapView{}, err
+	}
+	return NewMapView(&v.b, v.offset, v.encoding)
+}
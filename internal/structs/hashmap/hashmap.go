@@ -8,7 +8,7 @@ import (
 )
 
 type Hashable interface {
-	~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr | ~float32 | ~float64 | ~string | ~bool | time.Time
+	~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr | ~float32 | ~float64 | ~string | ~bool | time.Time | element.UUID
 }
 
 type HashMap[K Hashable, V any] interface {
@@ -22,12 +22,12 @@ type HashMap[K Hashable, V any] interface {
 }
 
 func hashMod[K Hashable](key K, hash64 hash.Hash64, numSlots uint64) (uint64, error) {
-	numBytesNeeded, err := element.BytesNeededForPrimitive(key)
+	numBytesNeeded, err := element.BytesNeededForPrimitive(key, element.LegacyEncoding)
 	if err != nil {
 		return 0, err
 	}
 	b := make([]byte, numBytesNeeded)
-	_, err = element.WritePrimitive(&b, 0, key, element.AnyType)
+	_, err = element.WritePrimitive(&b, 0, key, element.AnyType, element.LegacyEncoding)
 	if err != nil {
 		return 0, err
 	}